@@ -115,6 +115,7 @@ func addServerFlags(flags *pflag.FlagSet) {
 	flags.String("archivesPath", "", "path to Archives directory for selective sync")
 	flags.String("spacesPath", "", "path to Spaces directory for selective sync")
 	flags.String("syncLog", "", "sync debug log path (e.g. /log/sync.log); empty=disabled, 'true'=legacy ./log/sync.log")
+	flags.String("syncConfig", "", "sync config file path (YAML or TOML); FB_SYNC_* env vars override it")
 }
 
 var rootCmd = &cobra.Command{
@@ -249,8 +250,16 @@ user created with the credentials from options "username" and "password".`,
 		}
 
 		// Set up sync daemon if paths are configured
+		syncBase := ssync.DefaultConfig()
+		syncBase.ArchivesRoot = server.ArchivesPath
+		syncBase.SpacesRoot = server.SpacesPath
+		syncCfg, err := ssync.LoadConfig(v.GetString("syncConfig"), syncBase)
+		if err != nil {
+			return fmt.Errorf("load sync config: %w", err)
+		}
+
 		var syncHandlers *ssync.Handlers
-		if server.ArchivesPath != "" && server.SpacesPath != "" {
+		if syncCfg.ArchivesRoot != "" && syncCfg.SpacesRoot != "" {
 			syncLogPath := v.GetString("syncLog")
 			if syncLogPath != "" && syncLogPath != "false" {
 				if syncLogPath == "true" {
@@ -275,13 +284,19 @@ user created with the credentials from options "username" and "password".`,
 			defer syncDB.Close()
 
 			syncStore := ssync.NewStore(syncDB)
-			syncDaemon := ssync.NewDaemon(syncStore, server.ArchivesPath, server.SpacesPath)
-			syncHandlers = ssync.NewHandlers(syncStore, syncDaemon, server.ArchivesPath, server.SpacesPath)
+			syncDaemon := ssync.NewDaemonFromConfig(syncStore, syncCfg)
+			syncHandlers = ssync.NewHandlers(syncStore, syncDaemon, syncCfg.ArchivesRoot, syncCfg.SpacesRoot)
 
 			syncCtx, syncCancel := context.WithCancel(context.Background())
 			defer syncCancel()
 			go syncDaemon.Run(syncCtx)
 		}
+		if syncCfg.SpokeHub != "" {
+			spokeCtx, spokeCancel := context.WithCancel(context.Background())
+			defer spokeCancel()
+			spoke := ssync.NewSpokeClient(syncCfg.SpokeHub, syncCfg.SpokeToken, syncCfg.SpokeRoot)
+			go spoke.Run(spokeCtx, time.Duration(syncCfg.SpokeSyncSeconds)*time.Second)
+		}
 
 		handler, err := fbhttp.NewHandler(imageService, fileCache, uploadCache, st.Storage, server, assetsFs, syncHandlers)
 		if err != nil {
@@ -3,6 +3,7 @@ import { fetchJSON, fetchURL } from "./utils";
 export interface SyncEntry {
   inode: number;
   name: string;
+  path: string;
   type: string;
   size: number | null;
   mtime: number;
@@ -10,6 +11,9 @@ export interface SyncEntry {
   status: string;
   childTotalCount?: number;
   childSelectedCount?: number;
+  inSpacesView: boolean;
+  archiveMtime?: number;
+  spacesMtime?: number;
 }
 
 export interface SyncListResponse {
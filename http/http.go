@@ -89,12 +89,86 @@ func NewHandler(
 
 	// Sync API routes
 	if syncHandlers != nil {
+		r.PathPrefix("/sync/status").Handler(http.StripPrefix("/sync/status", syncHandlers.StatusPage()))
 		syncAPI := api.PathPrefix("/sync").Subrouter()
+		syncAPI.Use(syncHandlers.Trace)
+		syncAPI.Use(syncHandlers.TrackActivity)
+		syncAPI.Use(syncHandlers.GuardInstance)
 		syncAPI.HandleFunc("/entries", syncHandlers.HandleListEntries).Methods("GET")
+		syncAPI.HandleFunc("/instance", syncHandlers.HandleInstance).Methods("GET")
 		syncAPI.HandleFunc("/entry/{inode:[0-9]+}", syncHandlers.HandleGetEntry).Methods("GET")
+		syncAPI.HandleFunc("/entry/{inode:[0-9]+}", syncHandlers.HandlePatchEntry).Methods("PATCH")
+		syncAPI.HandleFunc("/starred", syncHandlers.HandleStarred).Methods("GET")
 		syncAPI.HandleFunc("/select", syncHandlers.HandleSelect).Methods("POST")
 		syncAPI.HandleFunc("/deselect", syncHandlers.HandleDeselect).Methods("POST")
+		syncAPI.HandleFunc("/undo", syncHandlers.HandleUndo).Methods("POST")
+		syncAPI.HandleFunc("/jobs/{id:[0-9a-f]+}", syncHandlers.HandleSelectionJob).Methods("GET")
+		syncAPI.HandleFunc("/jobs/{id:[0-9a-f]+}", syncHandlers.HandleCancelSelectionJob).Methods("DELETE")
+		syncAPI.HandleFunc("/jobs/{id:[0-9a-f]+}/cancel", syncHandlers.HandleCancelSelectionJob).Methods("POST")
+		syncAPI.HandleFunc("/jobs/cancel", syncHandlers.HandleCancelJobsUnder).Methods("POST")
+		syncAPI.HandleFunc("/exclude", syncHandlers.HandleExclude).Methods("POST")
+		syncAPI.HandleFunc("/include", syncHandlers.HandleInclude).Methods("POST")
+		syncAPI.HandleFunc("/hold", syncHandlers.HandleHold).Methods("POST")
+		syncAPI.HandleFunc("/unhold", syncHandlers.HandleUnhold).Methods("POST")
+		syncAPI.HandleFunc("/holds", syncHandlers.HandleListHolds).Methods("GET")
+		syncAPI.HandleFunc("/anomaly", syncHandlers.HandleAnomaly).Methods("GET")
+		syncAPI.HandleFunc("/anomaly/ack", syncHandlers.HandleAcknowledgeAnomaly).Methods("POST")
+		syncAPI.HandleFunc("/tag", syncHandlers.HandleTag).Methods("POST")
+		syncAPI.HandleFunc("/untag", syncHandlers.HandleUntag).Methods("POST")
+		syncAPI.HandleFunc("/tags", syncHandlers.HandleListTags).Methods("GET")
+		syncAPI.HandleFunc("/search", syncHandlers.HandleSearch).Methods("GET")
+		syncAPI.HandleFunc("/unconfirmed", syncHandlers.HandleListUnconfirmed).Methods("GET")
+		syncAPI.HandleFunc("/unconfirmed/confirm", syncHandlers.HandleConfirm).Methods("POST")
+		syncAPI.HandleFunc("/unconfirmed/reject", syncHandlers.HandleReject).Methods("POST")
+		syncAPI.HandleFunc("/large", syncHandlers.HandleListLargeCopies).Methods("GET")
+		syncAPI.HandleFunc("/large/confirm", syncHandlers.HandleConfirmLargeCopies).Methods("POST")
+		syncAPI.HandleFunc("/download", syncHandlers.HandleDownload).Methods("GET")
+		syncAPI.HandleFunc("/upload", syncHandlers.HandleUpload).Methods("POST")
+		syncAPI.HandleFunc("/move", syncHandlers.HandleMove).Methods("POST")
+		syncAPI.HandleFunc("/copy", syncHandlers.HandleCopy).Methods("POST")
+		syncAPI.HandleFunc("/mkdir", syncHandlers.HandleMkdir).Methods("POST")
+		syncAPI.HandleFunc("/content/{inode:[0-9]+}", syncHandlers.HandleGetContent).Methods("GET")
+		syncAPI.HandleFunc("/content/{inode:[0-9]+}", syncHandlers.HandlePutContent).Methods("PUT")
 		syncAPI.HandleFunc("/stats", syncHandlers.HandleStats).Methods("GET")
+		syncAPI.HandleFunc("/queue", syncHandlers.HandleQueue).Methods("GET")
+		syncAPI.HandleFunc("/queue/item", syncHandlers.HandleDropQueueItem).Methods("DELETE")
+		syncAPI.HandleFunc("/dirsize/{inode:[0-9]+}", syncHandlers.HandleDirSize).Methods("GET")
+		syncAPI.HandleFunc("/stats/breakdown", syncHandlers.HandleStatsBreakdown).Methods("GET")
+		syncAPI.HandleFunc("/stats/io", syncHandlers.HandleIOStats).Methods("GET")
+		syncAPI.HandleFunc("/diff", syncHandlers.HandleDiff).Methods("GET")
+		syncAPI.HandleFunc("/fsck", syncHandlers.HandleFsck).Methods("POST")
+		syncAPI.HandleFunc("/fsck/{id:[0-9a-f]+}", syncHandlers.HandleFsckStatus).Methods("GET")
+		syncAPI.HandleFunc("/reconcile", syncHandlers.HandleReconcile).Methods("POST")
+		syncAPI.HandleFunc("/reconcile/{id:[0-9a-f]+}", syncHandlers.HandleReconcileStatus).Methods("GET")
+		syncAPI.HandleFunc("/seed-status", syncHandlers.HandleSeedStatus).Methods("GET")
+		syncAPI.HandleFunc("/blocks", syncHandlers.HandleBlockStats).Methods("GET")
+		syncAPI.HandleFunc("/config", syncHandlers.HandleGetConfig).Methods("GET")
+		syncAPI.HandleFunc("/config", syncHandlers.HandlePatchConfig).Methods("PATCH")
+		syncAPI.HandleFunc("/types", syncHandlers.HandleTypes).Methods("GET")
+		syncAPI.HandleFunc("/loglevel", syncHandlers.HandleGetLogLevel).Methods("GET")
+		syncAPI.HandleFunc("/loglevel", syncHandlers.HandleSetLogLevel).Methods("PUT")
+		syncAPI.HandleFunc("/errors", syncHandlers.HandleErrors).Methods("GET")
+		syncAPI.HandleFunc("/report", syncHandlers.HandleReport).Methods("GET")
+		syncAPI.HandleFunc("/simulate", syncHandlers.HandleSimulate).Methods("GET")
+		syncAPI.HandleFunc("/events", syncHandlers.HandleSSE).Methods("GET")
+		syncAPI.HandleFunc("/audit", syncHandlers.HandleAudit).Methods("GET")
+		syncAPI.HandleFunc("/conflicts", syncHandlers.HandleConflicts).Methods("GET")
+		syncAPI.HandleFunc("/conflicts/resolve", syncHandlers.HandleResolveConflict).Methods("POST")
+		syncAPI.HandleFunc("/spokes", syncHandlers.HandleRegisterSpoke).Methods("POST")
+		syncAPI.HandleFunc("/spokes", syncHandlers.HandleListSpokes).Methods("GET")
+		syncAPI.HandleFunc("/spokes/{id:[0-9a-f]+}", syncHandlers.HandleDeleteSpoke).Methods("DELETE")
+		syncAPI.HandleFunc("/spoke/entries", syncHandlers.HandleSpokeEntries).Methods("GET")
+		syncAPI.HandleFunc("/spoke/hydrate", syncHandlers.HandleSpokeHydrate).Methods("POST")
+		syncAPI.HandleFunc("/spoke/release", syncHandlers.HandleSpokeRelease).Methods("POST")
+		syncAPI.HandleFunc("/spoke/plan", syncHandlers.HandleSpokePlan).Methods("GET")
+		syncAPI.HandleFunc("/spoke/content/{inode:[0-9]+}", syncHandlers.HandleSpokeContent).Methods("GET")
+		syncAPI.HandleFunc("/spoke/report", syncHandlers.HandleSpokeReport).Methods("POST")
+		syncAPI.HandleFunc("/backups", syncHandlers.HandleCreateBackup).Methods("POST")
+		syncAPI.HandleFunc("/backups", syncHandlers.HandleListBackups).Methods("GET")
+		syncAPI.HandleFunc("/backups/{id:[0-9a-f]+}", syncHandlers.HandleGetBackup).Methods("GET")
+		syncAPI.HandleFunc("/backups/{id:[0-9a-f]+}", syncHandlers.HandleDeleteBackup).Methods("DELETE")
+		syncAPI.HandleFunc("/backups/{id:[0-9a-f]+}/cancel", syncHandlers.HandleCancelBackup).Methods("POST")
+		syncAPI.HandleFunc("/backups/{id:[0-9a-f]+}/resume", syncHandlers.HandleResumeBackup).Methods("POST")
 	}
 
 	public := api.PathPrefix("/public").Subrouter()
@@ -0,0 +1,250 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// Ransomware encrypting Spaces shows up as a flood of Spaces changes, each
+// of which P2 would faithfully copy over the Archives original. The
+// pipeline reports every Spaces change it sees to anomalies; more distinct
+// paths in a minute than configured raise an alert, published as an event
+// and POSTed to the configured URL. With autoPause, Spaces changes are
+// then held back from Archives until the alert is acknowledged.
+//
+// A tripped canary (see canary.go) raises an alert too, and enters safe
+// mode: all P2 and P3 work is held, whatever autoPause says.
+
+// anomalyWindow is the span changes are counted over.
+const anomalyWindow = time.Minute
+
+// anomalySampleSize is how many of the changed paths an alert names.
+const anomalySampleSize = 10
+
+// AnomalyAlerts configures the alert on unusual Spaces activity.
+type AnomalyAlerts struct {
+	SpacesChangesPerMinute int    `json:"spacesChangesPerMinute" yaml:"spacesChangesPerMinute" toml:"spacesChangesPerMinute"` // alert when more Spaces files change within a minute, 0 = off
+	URL                    string `json:"url" yaml:"url" toml:"url"`                                                          // POSTed each alert as JSON
+	AutoPause              bool   `json:"autoPause" yaml:"autoPause" toml:"autoPause"`                                        // hold Spaces changes from Archives until the alert is acknowledged
+
+	Canaries []string `json:"canaries" yaml:"canaries" toml:"canaries"` // Spaces paths of canary files the daemon places; any change to one enters safe mode
+}
+
+func (a AnomalyAlerts) validate() error {
+	if a.SpacesChangesPerMinute < 0 {
+		return fmt.Errorf("spacesChangesPerMinute must not be negative, got %d", a.SpacesChangesPerMinute)
+	}
+	if a.URL != "" && !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://") {
+		return fmt.Errorf("url must be http:// or https://, got %q", a.URL)
+	}
+	if a.AutoPause && a.SpacesChangesPerMinute == 0 {
+		return fmt.Errorf("autoPause needs spacesChangesPerMinute")
+	}
+	for i, p := range a.Canaries {
+		if relPath, err := safeRelPath(p); err != nil || relPath == "" {
+			return fmt.Errorf("canaries[%d]: invalid path %q", i, p)
+		}
+	}
+	return nil
+}
+
+// Anomaly alert reasons.
+const (
+	AnomalySpacesChanges = "spaces-changes" // more Spaces changes than spacesChangesPerMinute
+	AnomalyCanary        = "canary"         // a canary file changed
+)
+
+// AnomalyAlert is detected unusual Spaces activity.
+type AnomalyAlert struct {
+	Reason    string    `json:"reason"`              // AnomalySpacesChanges or AnomalyCanary
+	Changes   int       `json:"changes,omitempty"`   // distinct Spaces paths changed in the window
+	Threshold int       `json:"threshold,omitempty"` // spacesChangesPerMinute at the time
+	Since     time.Time `json:"since"`               // start of the window; when the canary was found changed
+	Time      time.Time `json:"time"`
+	Sample    []string  `json:"sample"`           // some of the changed paths; the canary
+	Canary    string    `json:"canary,omitempty"` // how the canary changed: modified or deleted
+	Paused    bool      `json:"paused"`           // Spaces changes are held until acknowledged
+	SafeMode  bool      `json:"safeMode"`         // all changes are held until acknowledged
+}
+
+// AnomalyStatus is returned by GET /api/sync/anomaly.
+type AnomalyStatus struct {
+	Alert    *AnomalyAlert `json:"alert"`    // nil while none is unacknowledged
+	Paused   bool          `json:"paused"`   // Spaces changes are held from Archives
+	SafeMode bool          `json:"safeMode"` // all changes are held
+	Waiting  int           `json:"waiting"`  // paths held so far
+}
+
+// anomalyMonitor counts Spaces changes per window and holds the alert
+// until it is acknowledged.
+type anomalyMonitor struct {
+	mu      gosync.Mutex
+	since   time.Time           // start of the current window
+	changed map[string]struct{} // paths changed in it
+	alert   *AnomalyAlert
+	waiting map[string]struct{} // paths held while paused
+	safe    bool                // safe mode: hold all changes, not just Spaces ones
+}
+
+// anomalies is the monitor shared by the pipeline and handlers.
+var anomalies = &anomalyMonitor{}
+
+// spacesChanged records that the pipeline found relPath changed in
+// Spaces, raising an alert once the configured rate is exceeded.
+func (m *anomalyMonitor) spacesChanged(relPath string) {
+	cfg := activeConfig.Load().Anomaly
+	if cfg.SpacesChangesPerMinute == 0 {
+		return
+	}
+	now := nowFunc()
+	m.mu.Lock()
+	if m.alert != nil {
+		// One alert until acknowledged, however long the burst.
+		m.mu.Unlock()
+		return
+	}
+	if m.changed == nil || now.Sub(m.since) >= anomalyWindow {
+		m.since, m.changed = now, make(map[string]struct{})
+	}
+	m.changed[relPath] = struct{}{}
+	if len(m.changed) <= cfg.SpacesChangesPerMinute {
+		m.mu.Unlock()
+		return
+	}
+	alert := &AnomalyAlert{
+		Reason:    AnomalySpacesChanges,
+		Changes:   len(m.changed),
+		Threshold: cfg.SpacesChangesPerMinute,
+		Since:     m.since,
+		Time:      now,
+		Sample:    samplePaths(m.changed, anomalySampleSize),
+		Paused:    cfg.AutoPause,
+	}
+	m.alert, m.changed = alert, nil
+	if alert.Paused {
+		m.waiting = make(map[string]struct{})
+	}
+	m.mu.Unlock()
+
+	sub("anomaly").Error("unusual Spaces activity, possibly ransomware",
+		"changes", alert.Changes, "threshold", alert.Threshold, "paused", alert.Paused, "sample", alert.Sample)
+	raiseAnomaly(cfg.URL, alert)
+}
+
+// canaryTripped enters safe mode because the canary at relPath was found
+// modified or deleted (how). It replaces a pending alert on the rate of
+// changes; one alert is raised until acknowledged.
+func (m *anomalyMonitor) canaryTripped(relPath, how string) {
+	now := nowFunc()
+	m.mu.Lock()
+	if m.safe {
+		m.mu.Unlock()
+		return
+	}
+	alert := &AnomalyAlert{
+		Reason:   AnomalyCanary,
+		Since:    now,
+		Time:     now,
+		Sample:   []string{relPath},
+		Canary:   how,
+		Paused:   true,
+		SafeMode: true,
+	}
+	m.alert, m.safe, m.changed = alert, true, nil
+	if m.waiting == nil {
+		m.waiting = make(map[string]struct{})
+	}
+	m.mu.Unlock()
+
+	sub("anomaly").Error("canary file "+how+", entering safe mode until acknowledged", "canary", relPath)
+	raiseAnomaly(activeConfig.Load().Anomaly.URL, alert)
+}
+
+// raiseAnomaly publishes alert and POSTs it to url, if set.
+func raiseAnomaly(url string, alert *AnomalyAlert) {
+	events.Publish(Event{Type: EventAnomaly, Time: alert.Time.UnixNano(), Data: map[string]any{
+		"reason": alert.Reason, "changes": alert.Changes, "threshold": alert.Threshold,
+		"paused": alert.Paused, "safeMode": alert.SafeMode, "sample": alert.Sample, "canary": alert.Canary,
+	}})
+	if url != "" {
+		go postAnomaly(url, *alert)
+	}
+}
+
+// hold reports whether changes to relPath must wait for the alert to be
+// acknowledged, remembering relPath to queue it again then. While paused
+// only Spaces changes wait, in safe mode all do.
+func (m *anomalyMonitor) hold(relPath string, spacesChange bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.waiting == nil || !(spacesChange || m.safe) {
+		return false
+	}
+	m.waiting[relPath] = struct{}{}
+	return true
+}
+
+// acknowledge clears the alert and lifts the pause, returning the alert
+// and the paths held meanwhile.
+func (m *anomalyMonitor) acknowledge() (*AnomalyAlert, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alert, waiting := m.alert, samplePaths(m.waiting, 0)
+	m.alert, m.waiting, m.changed, m.safe = nil, nil, nil, false
+	return alert, waiting
+}
+
+func (m *anomalyMonitor) status() AnomalyStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return AnomalyStatus{Alert: m.alert, Paused: m.waiting != nil, SafeMode: m.safe, Waiting: len(m.waiting)}
+}
+
+// samplePaths returns up to n of paths (all for n = 0), sorted.
+func samplePaths(paths map[string]struct{}, n int) []string {
+	out := make([]string, 0, len(paths))
+	for p := range paths {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func postAnomaly(url string, alert AnomalyAlert) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	body, _ := json.Marshal(alert)
+	if err := postHook(ctx, url, body); err != nil {
+		sub("anomaly").Warn("anomaly webhook failed", "url", url, "err", err)
+	}
+}
+
+// HandleAnomaly handles GET /api/sync/anomaly
+func (h *Handlers) HandleAnomaly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anomalies.status()) //nolint:errcheck
+}
+
+// HandleAcknowledgeAnomaly handles POST /api/sync/anomaly/ack
+// It clears the alert and leaves safe mode, restoring the canaries, and
+// lets changes held meanwhile through by queueing their paths.
+func (h *Handlers) HandleAcknowledgeAnomaly(w http.ResponseWriter, r *http.Request) {
+	alert, waiting := anomalies.acknowledge()
+	if alert != nil && alert.SafeMode {
+		placeCanaries(h.store, h.spacesRoot, true)
+	}
+	sub("handlers").Info("HTTP acknowledge anomaly", "alert", alert != nil, "requeued", len(waiting))
+	h.daemon.queue.PushMany(waiting)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"alert": alert, "requeued": len(waiting)}) //nolint:errcheck
+}
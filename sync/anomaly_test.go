@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetAnomalies gives the test a fresh anomaly monitor.
+func resetAnomalies(t *testing.T) {
+	prev := anomalies
+	anomalies = &anomalyMonitor{}
+	t.Cleanup(func() { anomalies = prev })
+}
+
+func TestPipeline_AnomalyPausesSpacesChanges(t *testing.T) {
+	restoreConfig(t)
+	resetAnomalies(t)
+	posted := make(chan AnomalyAlert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert AnomalyAlert
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &alert) //nolint:errcheck
+		posted <- alert
+	}))
+	defer srv.Close()
+	cfg := currentConfig()
+	cfg.Anomaly = AnomalyAlerts{SpacesChangesPerMinute: 2, URL: srv.URL, AutoPause: true}
+	require.NoError(t, setConfig(cfg))
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	env := setupPipelineEnv(t)
+	for i := range 4 {
+		p := fmt.Sprintf("f%d.txt", i)
+		env.writeArchive(t, p, []byte("original"))
+		env.writeSpaces(t, p, []byte("original"))
+		env.run(t, p)
+	}
+	env.clock.Advance(time.Second)
+	for i := range 4 {
+		env.writeSpaces(t, fmt.Sprintf("f%d.txt", i), []byte("encrypted"))
+	}
+	for i := range 4 {
+		env.run(t, fmt.Sprintf("f%d.txt", i))
+	}
+	for i, want := range []string{"encrypted", "encrypted", "original", "original"} {
+		got, err := os.ReadFile(filepath.Join(env.archivesRoot, fmt.Sprintf("f%d.txt", i)))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got), i)
+	}
+
+	select {
+	case ev := <-ch:
+		assert.Equal(t, EventAnomaly, ev.Type)
+		assert.Equal(t, 3, ev.Data["changes"])
+	case <-time.After(time.Second):
+		t.Fatal("no anomaly event")
+	}
+	select {
+	case alert := <-posted:
+		assert.Equal(t, 3, alert.Changes)
+		assert.Equal(t, 2, alert.Threshold)
+		assert.True(t, alert.Paused)
+		assert.Equal(t, []string{"f0.txt", "f1.txt", "f2.txt"}, alert.Sample)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+	status := anomalies.status()
+	assert.True(t, status.Paused)
+	assert.Equal(t, 2, status.Waiting)
+
+	alert, waiting := anomalies.acknowledge()
+	require.NotNil(t, alert)
+	assert.Equal(t, []string{"f2.txt", "f3.txt"}, waiting)
+	env.run(t, "f2.txt")
+	got, err := os.ReadFile(filepath.Join(env.archivesRoot, "f2.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "encrypted", string(got))
+}
+
+func TestAnomalyMonitor_Window(t *testing.T) {
+	restoreConfig(t)
+	resetAnomalies(t)
+	clk := useFakeClock(t, time.Now())
+	cfg := currentConfig()
+	cfg.Anomaly.SpacesChangesPerMinute = 2
+	require.NoError(t, setConfig(cfg))
+
+	anomalies.spacesChanged("a")
+	anomalies.spacesChanged("a") // counted once
+	anomalies.spacesChanged("b")
+	clk.Advance(anomalyWindow)
+	anomalies.spacesChanged("c")
+	anomalies.spacesChanged("d")
+	assert.Nil(t, anomalies.status().Alert)
+	assert.False(t, anomalies.hold("d", true), "no pause without autoPause")
+
+	anomalies.spacesChanged("e")
+	require.NotNil(t, anomalies.status().Alert)
+	assert.False(t, anomalies.status().Paused)
+}
+
+func TestHandleAcknowledgeAnomaly(t *testing.T) {
+	restoreConfig(t)
+	resetAnomalies(t)
+	h, _, _, _ := setupHandlersEnv(t)
+	cfg := currentConfig()
+	cfg.Anomaly = AnomalyAlerts{SpacesChangesPerMinute: 1, AutoPause: true}
+	require.NoError(t, setConfig(cfg))
+	anomalies.spacesChanged("a")
+	anomalies.spacesChanged("b")
+	require.True(t, anomalies.hold("b", true))
+
+	w := httptest.NewRecorder()
+	h.HandleAnomaly(w, httptest.NewRequest("GET", "/api/sync/anomaly", nil))
+	var status AnomalyStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Paused)
+	assert.Equal(t, 1, status.Waiting)
+	require.NotNil(t, status.Alert)
+
+	w = httptest.NewRecorder()
+	h.HandleAcknowledgeAnomaly(w, httptest.NewRequest("POST", "/api/sync/anomaly/ack", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, h.daemon.queue.Has("b"))
+	assert.Equal(t, AnomalyStatus{}, anomalies.status())
+	assert.False(t, anomalies.hold("b", true))
+}
+
+func TestAnomalyAlerts_Validate(t *testing.T) {
+	assert.NoError(t, AnomalyAlerts{}.validate())
+	assert.NoError(t, AnomalyAlerts{SpacesChangesPerMinute: 100, URL: "https://example.com/hook", AutoPause: true}.validate())
+	assert.Error(t, AnomalyAlerts{SpacesChangesPerMinute: -1}.validate())
+	assert.Error(t, AnomalyAlerts{SpacesChangesPerMinute: 1, URL: "ftp://x"}.validate())
+	assert.Error(t, AnomalyAlerts{AutoPause: true}.validate())
+}
+
+func TestPatchConfig_AnomalyURLStartupOnly(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.Anomaly = AnomalyAlerts{SpacesChangesPerMinute: 100, URL: "https://alerts.example/hook"}
+	})
+	_, err := patchConfig([]byte(`{"anomaly":{"spacesChangesPerMinute":100,"url":"http://169.254.169.254/"}}`))
+	assert.Error(t, err)
+	assert.Equal(t, "https://alerts.example/hook", currentConfig().Anomaly.URL)
+
+	_, err = patchConfig([]byte(`{"anomaly":{"spacesChangesPerMinute":50,"url":"https://alerts.example/hook"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 50, currentConfig().Anomaly.SpacesChangesPerMinute)
+}
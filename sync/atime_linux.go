@@ -0,0 +1,27 @@
+package sync
+
+import (
+	"os"
+	"syscall"
+)
+
+// accessTime returns the last access time of info in nanoseconds, or 0 if unknown.
+func accessTime(info os.FileInfo) int64 {
+	if ri, ok := info.(*remoteInfo); ok {
+		return ri.atime
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Atim.Nano()
+	}
+	return 0
+}
+
+// changeTime returns the inode change time of info in nanoseconds, or 0
+// if unknown. Unlike the mtime it is always set from the clock of the
+// machine holding the file.
+func changeTime(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ctim.Nano()
+	}
+	return 0
+}
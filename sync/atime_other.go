@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sync
+
+import "os"
+
+// accessTime returns 0 for local files: their access times are only read
+// on Linux. Remote Spaces files carry theirs.
+func accessTime(info os.FileInfo) int64 {
+	if ri, ok := info.(*remoteInfo); ok {
+		return ri.atime
+	}
+	return 0
+}
+
+// changeTime returns 0: inode change times are only read on Linux.
+func changeTime(info os.FileInfo) int64 {
+	return 0
+}
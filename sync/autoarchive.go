@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// autoArchiveInterval is how often the daemon looks for stale Spaces copies.
+const autoArchiveInterval = time.Hour
+
+// AuditAutoArchive is the audit action recorded when a stale file is deselected.
+const AuditAutoArchive = "auto-archive"
+
+// optedOut reports whether relPath is, or is under, one of the opt-out directories.
+func optedOut(relPath string, optOut []string) bool {
+	for _, dir := range optOut {
+		dir = strings.Trim(filepath.ToSlash(dir), "/")
+		if dir == "" {
+			continue
+		}
+		if relPath == dir || strings.HasPrefix(relPath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// autoArchivePass deselects selected files whose Spaces copy hasn't been
+// modified or accessed in Config.AutoArchiveDays days, skipping directories
+// listed in Config.AutoArchiveOptOut. Each deselection is recorded in the
+// audit log and published as an auto-archived event.
+// Returns the deselected entries keyed by relative path so the caller can queue them.
+func autoArchivePass(store *Store, spacesRoot string) (map[string]Entry, error) {
+	cfg := currentConfig()
+	archived := make(map[string]Entry)
+	if cfg.AutoArchiveDays <= 0 {
+		return archived, nil
+	}
+	cutoff := nowFunc().Add(-time.Duration(cfg.AutoArchiveDays) * 24 * time.Hour).UnixNano()
+	// A truncated walk only archives what it visited.
+	err := autoArchiveUnder(store, spacesRoot, cfg.AutoArchiveOptOut, cutoff, 0, "", archived)
+	if errors.Is(err, errTreeTruncated) {
+		sub("autoarchive").Warn("auto-archive pass covered part of the tree", "archived", len(archived))
+	} else if err != nil {
+		return nil, err
+	}
+	sub("autoarchive").Info("auto-archive pass complete", "days", cfg.AutoArchiveDays, "archived", len(archived))
+	return archived, nil
+}
+
+func autoArchiveUnder(store *Store, spacesRoot string, optOut []string, cutoff int64, parentIno uint64, parentPath string, archived map[string]Entry) error {
+	l := sub("autoarchive")
+	return store.walkTree(parentIno, parentPath, func(e *Entry, relPath string) (bool, error) {
+		child := *e
+		if optedOut(relPath, optOut) {
+			return false, nil
+		}
+		if child.Type == "dir" {
+			return true, nil
+		}
+		if !child.Selected {
+			return false, nil
+		}
+
+		spacesPath := filepath.Join(spacesRoot, relPath)
+		info, err := fsFor(spacesPath).Stat(spacesPath)
+		if err != nil {
+			return false, nil // not copied yet
+		}
+		lastUsed := accessTime(info)
+		if !futureMtime(spacesPath, info.ModTime()) {
+			lastUsed = max(lastUsed, info.ModTime().UnixNano())
+		}
+		if lastUsed > cutoff {
+			return false, nil
+		}
+
+		if err := store.SetSelected([]uint64{child.Inode}, false); err != nil {
+			return false, fmt.Errorf("deselect %s: %w", relPath, err)
+		}
+		idle := time.Duration(nowNano() - lastUsed).Round(time.Hour)
+		if err := store.AppendAudit(AuditRecord{
+			Action: AuditAutoArchive,
+			Path:   relPath,
+			Inode:  child.Inode,
+			Detail: fmt.Sprintf("idle for %s", idle),
+		}); err != nil {
+			l.Warn("audit append failed", "path", relPath, "err", err)
+		}
+		events.Publish(Event{
+			Type:  EventAutoArchived,
+			Path:  relPath,
+			Inode: child.Inode,
+			Data:  map[string]any{"lastUsed": lastUsed},
+		})
+		l.Info("auto-archived stale file", "path", relPath, "inode", child.Inode, "idle", idle)
+
+		child.Selected = false
+		archived[relPath] = child
+		return false, nil
+	})
+}
+
+// runAutoArchive periodically runs autoArchivePass and queues the
+// deselected paths until ctx is cancelled.
+func (d *Daemon) runAutoArchive(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(autoArchiveInterval)):
+			archived, err := autoArchivePass(d.store, d.spacesRoot)
+			if err != nil {
+				sub("autoarchive").Error("auto-archive pass failed", "err", err)
+				continue
+			}
+			for relPath, entry := range archived {
+				d.queue.PushSized(relPath, sizeOrZero(entry.Size), false)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSpacesAged writes a Spaces file with mtime and atime set to age ago.
+func writeSpacesAged(t *testing.T, spacesRoot, relPath string, age time.Duration) {
+	t.Helper()
+	p := filepath.Join(spacesRoot, relPath)
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, os.WriteFile(p, []byte("x"), 0644))
+	ts := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(p, ts, ts))
+}
+
+func TestAutoArchivePass(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.AutoArchiveDays = 7
+	cfg.AutoArchiveOptOut = []string{"Keep"}
+	require.NoError(t, setConfig(cfg))
+
+	h, store, _, spacesRoot := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "old.txt", Type: "text", Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "new.txt", Type: "text", Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 3, Name: "Keep", Type: "dir", Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 4, ParentIno: 3, Name: "old.txt", Type: "text", Mtime: 1, Selected: true}))
+	writeSpacesAged(t, spacesRoot, "old.txt", 30*24*time.Hour)
+	writeSpacesAged(t, spacesRoot, "new.txt", time.Hour)
+	writeSpacesAged(t, spacesRoot, "Keep/old.txt", 30*24*time.Hour)
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	archived, err := autoArchivePass(store, spacesRoot)
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Contains(t, archived, "old.txt")
+
+	for ino, want := range map[uint64]bool{1: false, 2: true, 4: true} {
+		e, err := store.GetEntry(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, e.Selected, "inode %d", ino)
+	}
+
+	select {
+	case ev := <-ch:
+		assert.Equal(t, EventAutoArchived, ev.Type)
+		assert.Equal(t, "old.txt", ev.Path)
+	default:
+		t.Fatal("expected auto-archived event")
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleAudit(w, httptest.NewRequest("GET", "/api/sync/audit?action=auto-archive", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []AuditRecord `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "old.txt", resp.Items[0].Path)
+	assert.Equal(t, uint64(1), resp.Items[0].Inode)
+}
+
+func TestAutoArchivePass_Disabled(t *testing.T) {
+	restoreConfig(t)
+	_, store, _, spacesRoot := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "old.txt", Type: "text", Mtime: 1, Selected: true}))
+	writeSpacesAged(t, spacesRoot, "old.txt", 365*24*time.Hour)
+
+	archived, err := autoArchivePass(store, spacesRoot)
+	require.NoError(t, err)
+	assert.Empty(t, archived)
+}
+
+func TestEventBus_SlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := NewEventBus()
+	ch, unsubscribe := bus.Subscribe()
+	for i := 0; i < eventBufferSize+10; i++ {
+		bus.Publish(Event{Type: "test"})
+	}
+	assert.Len(t, ch, eventBufferSize)
+	unsubscribe()
+	unsubscribe() // idempotent
+}
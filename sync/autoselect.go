@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// An auto-select directory, such as an Inbox, has everything appearing
+// under it selected as it is registered: P1, the seeder and lazy
+// registration select new entries below it, subdirectories included.
+// Entries already registered keep their selection.
+
+// SetAutoSelect sets or clears the auto-select flag of a directory.
+func (s *Store) SetAutoSelect(dirIno uint64, on bool) error {
+	query := "DELETE FROM auto_select_dirs WHERE dir_ino = ?"
+	if on {
+		query = "INSERT OR IGNORE INTO auto_select_dirs (dir_ino) VALUES (?)"
+	}
+	if _, err := s.exec(query, dirIno); err != nil {
+		return fmt.Errorf("set auto-select %d: %w", dirIno, err)
+	}
+	return nil
+}
+
+// AutoSelect reports whether a directory has the auto-select flag itself.
+func (s *Store) AutoSelect(dirIno uint64) (bool, error) {
+	var one int
+	err := s.rdb.QueryRowContext(s.context(), "SELECT 1 FROM auto_select_dirs WHERE dir_ino = ?", dirIno).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get auto-select %d: %w", dirIno, err)
+	}
+	return true, nil
+}
+
+// AutoSelectFor reports whether dirIno or a directory above it has the
+// auto-select flag, which selects an entry appearing in dirIno.
+func (s *Store) AutoSelectFor(dirIno uint64) (bool, error) {
+	var one int
+	err := s.rdb.QueryRowContext(s.context(), `
+		WITH RECURSIVE up(inode, parent_ino) AS (
+			SELECT inode, parent_ino FROM entries WHERE inode = ?
+			UNION ALL
+			SELECT e.inode, e.parent_ino FROM entries e JOIN up ON e.inode = up.parent_ino
+			WHERE up.parent_ino != 0
+		)
+		SELECT 1 FROM up JOIN auto_select_dirs a ON a.dir_ino = up.inode LIMIT 1
+	`, dirIno).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("auto-select for %d: %w", dirIno, err)
+	}
+	return true, nil
+}
+
+// AutoSelectDirs returns the inodes of the directories with the
+// auto-select flag.
+func (s *Store) AutoSelectDirs() (map[uint64]bool, error) {
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT dir_ino FROM auto_select_dirs")
+	if err != nil {
+		return nil, fmt.Errorf("list auto-select dirs: %w", err)
+	}
+	defer rows.Close()
+	dirs := make(map[uint64]bool)
+	for rows.Next() {
+		var ino uint64
+		if err := rows.Scan(&ino); err != nil {
+			return nil, fmt.Errorf("scan auto-select dir: %w", err)
+		}
+		dirs[ino] = true
+	}
+	return dirs, rows.Err()
+}
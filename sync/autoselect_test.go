@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_AutoSelect(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 10, Name: "Inbox", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 11, ParentIno: 10, Name: "deep", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 20, Name: "Other", Type: "dir", Mtime: 1}))
+
+	require.NoError(t, store.SetAutoSelect(10, true))
+	require.NoError(t, store.SetAutoSelect(10, true), "idempotent")
+	for ino, want := range map[uint64]bool{10: true, 11: true, 20: false, 0: false} {
+		got, err := store.AutoSelectFor(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, ino)
+	}
+	own, err := store.AutoSelect(11)
+	require.NoError(t, err)
+	assert.False(t, own, "inherited, not set on the subdirectory")
+
+	require.NoError(t, store.SetAutoSelect(10, false))
+	got, err := store.AutoSelectFor(11)
+	require.NoError(t, err)
+	assert.False(t, got)
+}
+
+func TestPipeline_AutoSelectTakesNewEntries(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "Inbox/old.txt", []byte("old"))
+	env.run(t, "Inbox")
+	env.run(t, "Inbox/old.txt")
+	require.NoError(t, env.store.SetAutoSelect(registered(t, env.store, env.archivesRoot, "Inbox").Inode, true))
+
+	env.writeArchive(t, "Inbox/new.txt", []byte("new"))
+	env.writeArchive(t, "Inbox/2026/scan.txt", []byte("scan"))
+	for _, p := range []string{"Inbox/new.txt", "Inbox/2026", "Inbox/2026/scan.txt"} {
+		env.run(t, p)
+		assert.True(t, registered(t, env.store, env.archivesRoot, p).Selected, p)
+		env.run(t, p)
+	}
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "Inbox", "new.txt"))
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "Inbox", "2026", "scan.txt"))
+	assert.False(t, registered(t, env.store, env.archivesRoot, "Inbox/old.txt").Selected, "registered before")
+
+	// A deselect rule still wins.
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleDeselect, MinSize: 1000}}
+	require.NoError(t, setConfig(cfg))
+	env.writeArchive(t, "Inbox/big.bin", bytes.Repeat([]byte("x"), 1000))
+	env.run(t, "Inbox/big.bin")
+	assert.False(t, registered(t, env.store, env.archivesRoot, "Inbox/big.bin").Selected)
+}
+
+func TestSeed_AutoSelect(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "Inbox/old.txt", []byte("old"))
+	env.writeArchive(t, "Other/file.txt", []byte("other"))
+	require.NoError(t, Seed(env.store, env.archivesRoot, env.spacesRoot))
+	require.NoError(t, env.store.SetAutoSelect(registered(t, env.store, env.archivesRoot, "Inbox").Inode, true))
+
+	// Appeared while the daemon was down.
+	env.writeArchive(t, "Inbox/new.txt", []byte("new"))
+	env.writeArchive(t, "Inbox/2026/scan.txt", []byte("scan"))
+	env.writeArchive(t, "Other/new.txt", []byte("other"))
+	require.NoError(t, Seed(env.store, env.archivesRoot, env.spacesRoot))
+	for p, want := range map[string]bool{
+		"Inbox/old.txt":       false,
+		"Inbox/new.txt":       true,
+		"Inbox/2026":          true,
+		"Inbox/2026/scan.txt": true,
+		"Other/new.txt":       false,
+	} {
+		assert.Equal(t, want, registered(t, env.store, env.archivesRoot, p).Selected, p)
+	}
+}
+
+func TestHandlePatchEntry_AutoSelect(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "Inbox", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "todo.md", Type: "text", Mtime: 1}))
+
+	patch := func(path, body string) int {
+		w := httptest.NewRecorder()
+		h.HandlePatchEntry(w, httptest.NewRequest("PATCH", path, bytes.NewBufferString(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, patch("/api/sync/entry/2", `{"autoSelect":true}`))
+	require.Equal(t, http.StatusOK, patch("/api/sync/entry/1", `{"autoSelect":true}`))
+
+	w := httptest.NewRecorder()
+	h.HandleGetEntry(w, httptest.NewRequest("GET", "/api/sync/entry/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp SyncEntryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.AutoSelect)
+
+	require.Equal(t, http.StatusOK, patch("/api/sync/entry/1", `{"autoSelect":false}`))
+	auto, err := store.AutoSelect(1)
+	require.NoError(t, err)
+	assert.False(t, auto)
+}
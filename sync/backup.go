@@ -0,0 +1,528 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"syscall"
+)
+
+// Backup jobs export the selection, or the files matching a set of
+// rules, to a directory such as an external drive. The files a job
+// copies are fixed when it is created and tracked in backup_files, so a
+// job interrupted by a restart or cancelled by hand continues where it
+// stopped. Jobs run one at a time, copying from Archives with SafeCopy
+// and reading both sides back to verify each copy.
+
+// backupBatch is how many pending files a job fetches at a time.
+const backupBatch = 100
+
+// errBackupTarget stops a job whose target is gone, is no longer the file
+// system it was created on, e.g. an unplugged drive whose empty mount
+// point is left behind, or is no longer under Config.BackupTargets.
+var errBackupTarget = errors.New("backup target unavailable")
+
+// BackupRequest is the request body for starting a backup job.
+type BackupRequest struct {
+	Target string           `json:"target"`          // absolute directory under Config.BackupTargets to copy into
+	Rules  []AutoSelectRule `json:"rules,omitempty"` // export files the first matching rule selects; empty exports the selection
+}
+
+// BackupJobStatus is a job with the files that failed so far.
+type BackupJobStatus struct {
+	BackupJob
+	Failures []BackupFile `json:"failures"`
+}
+
+// backupRunner tracks the job being run.
+type backupRunner struct {
+	wake chan struct{}
+
+	mu      gosync.Mutex
+	running string
+	cancel  context.CancelFunc
+}
+
+func newBackupRunner() backupRunner {
+	return backupRunner{wake: make(chan struct{}, 1)}
+}
+
+// kick tells runBackups a job may be waiting.
+func (b *backupRunner) kick() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop cancels job id if it is the one running.
+func (b *backupRunner) stop(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running == id && b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// runBackups runs pending jobs, and jobs left running by a restart,
+// oldest first, until ctx is cancelled.
+func (d *Daemon) runBackups(ctx context.Context) {
+	l := sub("backup")
+	for {
+		jobs, err := d.store.ListBackupJobs()
+		if err != nil {
+			l.Warn("list backup jobs failed", "err", err)
+		}
+		next := ""
+		for _, job := range jobs {
+			if job.State == BackupPending || job.State == BackupRunning {
+				next = job.ID
+				break
+			}
+		}
+		if next != "" {
+			d.runBackupJob(ctx, next)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.backups.wake:
+		}
+	}
+}
+
+// runBackupJob copies the pending files of job id. Files that fail are
+// recorded and skipped; the job ends failed if any did. A cancelled ctx
+// leaves the job running, to continue on the next start.
+func (d *Daemon) runBackupJob(ctx context.Context, id string) {
+	l := sub("backup")
+	job, err := d.store.GetBackupJob(id)
+	if err != nil || job == nil {
+		l.Warn("backup job unavailable", "id", id, "err", err)
+		return
+	}
+	if err := d.store.SetBackupJobState(id, BackupRunning, "", nowFunc().UnixNano()); err != nil {
+		l.Warn("start backup job failed", "id", id, "err", err)
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.backups.mu.Lock()
+	d.backups.running, d.backups.cancel = id, cancel
+	d.backups.mu.Unlock()
+	defer func() {
+		d.backups.mu.Lock()
+		d.backups.running, d.backups.cancel = "", nil
+		d.backups.mu.Unlock()
+	}()
+	l.Info("backup job started", "id", id, "target", job.Target, "files", job.Files, "bytes", job.Bytes)
+
+	var copyErr error
+	for jobCtx.Err() == nil && copyErr == nil {
+		if dev, err := deviceOf(job.Target); err != nil || dev != job.TargetDev || !backupTargetAllowed(job.Target) {
+			copyErr = fmt.Errorf("%w: %s", errBackupTarget, job.Target)
+			break
+		}
+		files, err := d.store.ListBackupFiles(id, BackupPending, backupBatch)
+		if err != nil {
+			copyErr = err
+			break
+		}
+		if len(files) == 0 {
+			break
+		}
+		for _, f := range files {
+			err := d.backupFile(jobCtx, job.Target, f)
+			if jobCtx.Err() != nil {
+				break // stays pending
+			}
+			state, msg := BackupDone, ""
+			if err != nil {
+				l.Warn("backup file failed", "id", id, "path", f.Path, "err", err)
+				state, msg = BackupFailed, err.Error()
+			}
+			if err := d.store.SetBackupFileState(id, f.Path, state, msg); err != nil {
+				copyErr = err
+				break
+			}
+		}
+	}
+
+	if jobCtx.Err() != nil {
+		if ctx.Err() == nil {
+			l.Info("backup job cancelled", "id", id)
+		}
+		return // cancelled by hand (already recorded) or by shutdown
+	}
+	state, msg := BackupDone, ""
+	if copyErr != nil {
+		state, msg = BackupFailed, copyErr.Error()
+	} else if job, err = d.store.GetBackupJob(id); err == nil && job != nil && job.FailedFiles > 0 {
+		state, msg = BackupFailed, fmt.Sprintf("%d files failed", job.FailedFiles)
+	}
+	if err := d.store.SetBackupJobState(id, state, msg, nowFunc().UnixNano()); err != nil {
+		l.Warn("finish backup job failed", "id", id, "err", err)
+	}
+	l.Info("backup job finished", "id", id, "state", state, "error", msg)
+}
+
+// backupFile copies one file of a job from Archives to target and
+// verifies the copy. A copy an earlier run finished is kept as is.
+func (d *Daemon) backupFile(ctx context.Context, target string, f BackupFile) error {
+	src := filepath.Join(d.archivesRoot, filepath.FromSlash(f.Path))
+	dst := filepath.Join(target, filepath.FromSlash(f.Path))
+	if s, err := os.Stat(src); err == nil {
+		if t, err := os.Stat(dst); err == nil && t.Size() == s.Size() && t.ModTime().Equal(s.ModTime()) {
+			return nil
+		}
+	}
+	if err := SafeCopy(ctx, src, dst, nil); err != nil {
+		return err
+	}
+	return verifyCopy(src, dst)
+}
+
+// verifyCopy reads src and dst back and removes dst if they differ.
+func verifyCopy(src, dst string) error {
+	want, err := fileSHA256(src)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	got, err := fileSHA256(dst)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if want != got {
+		os.Remove(dst) //nolint:errcheck
+		return fmt.Errorf("verify: copy differs from %s", src)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// backupPlan lists the files a job exports: the selected ones, or with
+// rules, those the first matching rule selects. Excluded entries are
+// skipped either way.
+func (h *Handlers) backupPlan(rules []AutoSelectRule) ([]BackupFile, error) {
+	var files []BackupFile
+	seen := make(map[uint64]bool)
+	var walk func(e *Entry, relPath string) error
+	walk = func(e *Entry, relPath string) error {
+		if e.Excluded || seen[e.Inode] {
+			return nil
+		}
+		seen[e.Inode] = true
+		if e.Type != "dir" {
+			if (len(rules) == 0 && e.Selected) || (len(rules) > 0 && evalRules(rules, relPath, sizeOrZero(e.Size), e.Mtime) == RuleSelect) {
+				files = append(files, BackupFile{Path: relPath, Size: sizeOrZero(e.Size), Mtime: e.Mtime})
+			}
+			return nil
+		}
+		if err := h.daemon.ensureListed(e.Inode); err != nil {
+			return err
+		}
+		children, err := h.store.ListChildren(e.Inode)
+		if err != nil {
+			return err
+		}
+		for i := range children {
+			child := children[i].Name
+			if relPath != "" {
+				child = relPath + "/" + child
+			}
+			if err := walk(&children[i], child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var roots []Entry
+	if len(rules) == 0 {
+		var err error
+		if roots, err = h.store.ListSelectionRoots(); err != nil {
+			return nil, err
+		}
+	} else {
+		roots = []Entry{{Type: "dir"}} // the whole tree
+	}
+	for i := range roots {
+		relPath := ""
+		if roots[i].Inode != 0 {
+			relPath = h.resolveRelPath(&roots[i])
+		}
+		if err := walk(&roots[i], relPath); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// deviceOf returns the device holding path.
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("%s: no device", path)
+	}
+	return uint64(stat.Dev), nil //nolint:unconvert // int32 on some platforms
+}
+
+// overlaps reports whether either path is inside the other.
+func overlaps(a, b string) bool {
+	a, b = filepath.Clean(a)+string(filepath.Separator), filepath.Clean(b)+string(filepath.Separator)
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// checkBackupTarget requires an existing directory under one of
+// Config.BackupTargets and apart from the sync roots, comparing paths with
+// their symlinks resolved, and returns the target resolved.
+func (h *Handlers) checkBackupTarget(target string) (string, error) {
+	if !filepath.IsAbs(target) {
+		return "", fmt.Errorf("target must be an absolute path")
+	}
+	resolved, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("target: %w", err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("target: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("target is not a directory")
+	}
+	if !backupTargetAllowed(resolved) {
+		return "", fmt.Errorf("target is not under a configured backupTargets directory")
+	}
+	for _, root := range []string{h.archivesRoot, h.spacesRoot, h.daemon.trashRoot} {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		if overlaps(resolved, root) {
+			return "", fmt.Errorf("target overlaps %s", root)
+		}
+	}
+	return resolved, nil
+}
+
+// backupTargetAllowed reports whether the resolved target is under one of
+// Config.BackupTargets.
+func backupTargetAllowed(target string) bool {
+	for _, allowed := range activeConfig.Load().BackupTargets {
+		if a, err := filepath.EvalSymlinks(allowed); err == nil && underRoot(target, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// backupIDFromPath returns the job id in /api/sync/backups/<id>[/...].
+func backupIDFromPath(path string) string {
+	_, rest, _ := strings.Cut(path, "/backups/")
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
+
+// HandleCreateBackup handles POST /api/sync/backups
+// Plans and queues a backup job of the selection, or of the files
+// matching rules, to target.
+func (h *Handlers) HandleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	target, err := h.checkBackupTarget(req.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, rule := range req.Rules {
+		if err := rule.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("rules[%d]: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	files, err := h.backupPlan(req.Rules)
+	if err != nil {
+		l.Error("plan backup failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	dev, err := deviceOf(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := nowFunc().UnixNano()
+	job := BackupJob{ID: hex.EncodeToString(id), Target: target, TargetDev: dev, Rules: req.Rules, State: BackupPending, CreatedAt: now, UpdatedAt: now}
+	if err := h.store.CreateBackupJob(job, files); err != nil {
+		l.Error("create backup job failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.backups.kick()
+	created, err := h.store.GetBackupJob(job.ID)
+	if err != nil || created == nil {
+		http.Error(w, fmt.Sprintf("reload backup job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	l.Info("backup job created", "id", job.ID, "target", job.Target, "files", created.Files, "bytes", created.Bytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created) //nolint:errcheck
+}
+
+// HandleListBackups handles GET /api/sync/backups
+func (h *Handlers) HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	jobs, err := h.store.ListBackupJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []BackupJob{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": jobs}) //nolint:errcheck
+}
+
+// backupJob loads the job named in the path, writing 404 if there is none.
+func (h *Handlers) backupJob(w http.ResponseWriter, r *http.Request) (*BackupJob, bool) {
+	job, err := h.store.GetBackupJob(backupIDFromPath(r.URL.Path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if job == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+// HandleGetBackup handles GET /api/sync/backups/<id>
+func (h *Handlers) HandleGetBackup(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	job, ok := h.backupJob(w, r)
+	if !ok {
+		return
+	}
+	failures, err := h.store.ListBackupFiles(job.ID, BackupFailed, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if failures == nil {
+		failures = []BackupFile{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BackupJobStatus{BackupJob: *job, Failures: failures}) //nolint:errcheck
+}
+
+// HandleCancelBackup handles POST /api/sync/backups/<id>/cancel
+// Stops a pending or running job; copies already made stay on the target.
+func (h *Handlers) HandleCancelBackup(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	job, ok := h.backupJob(w, r)
+	if !ok {
+		return
+	}
+	if job.State != BackupPending && job.State != BackupRunning {
+		http.Error(w, fmt.Sprintf("job is %s", job.State), http.StatusConflict)
+		return
+	}
+	if err := h.store.SetBackupJobState(job.ID, BackupCancelled, "", nowFunc().UnixNano()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.backups.stop(job.ID)
+	sub("handlers").Info("backup job cancelled", "id", job.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResumeBackup handles POST /api/sync/backups/<id>/resume
+// Queues a cancelled or failed job again, retrying its failed files.
+func (h *Handlers) HandleResumeBackup(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	job, ok := h.backupJob(w, r)
+	if !ok {
+		return
+	}
+	if job.State != BackupCancelled && job.State != BackupFailed {
+		http.Error(w, fmt.Sprintf("job is %s", job.State), http.StatusConflict)
+		return
+	}
+	err := h.store.RetryBackupFiles(job.ID)
+	if err == nil {
+		err = h.store.SetBackupJobState(job.ID, BackupPending, "", nowFunc().UnixNano())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.backups.kick()
+	sub("handlers").Info("backup job resumed", "id", job.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteBackup handles DELETE /api/sync/backups/<id>
+// Forgets a job that isn't running. Its copies stay on the target.
+func (h *Handlers) HandleDeleteBackup(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	job, ok := h.backupJob(w, r)
+	if !ok {
+		return
+	}
+	if job.State == BackupRunning {
+		http.Error(w, "job is running; cancel it first", http.StatusConflict)
+		return
+	}
+	if _, err := h.store.DeleteBackupJob(job.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,236 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backupTarget returns a new directory configured as a backup target for
+// the test.
+func backupTarget(t *testing.T) string {
+	t.Helper()
+	target := t.TempDir()
+	withConfig(t, func(c *Config) { c.BackupTargets = append(c.BackupTargets, target) })
+	return target
+}
+
+// createBackup starts a backup job through the handler.
+func createBackup(t *testing.T, h *Handlers, body string) BackupJob {
+	t.Helper()
+	w := postJSON(h.HandleCreateBackup, "/api/sync/backups", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var job BackupJob
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	return job
+}
+
+func getBackup(t *testing.T, h *Handlers, id string) BackupJobStatus {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleGetBackup(w, httptest.NewRequest("GET", "/api/sync/backups/"+id, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var st BackupJobStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	return st
+}
+
+func TestBackup_ExportsSelection(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/sub/", "Docs/sub/b.txt", "Other.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "Docs").Inode}, true))
+	target := backupTarget(t)
+
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q}`, target))
+	assert.Equal(t, BackupPending, job.State)
+	assert.Equal(t, 2, job.Files)
+	assert.EqualValues(t, len("Docs/a.txt")+len("Docs/sub/b.txt"), job.Bytes)
+
+	h.daemon.runBackupJob(context.Background(), job.ID)
+	st := getBackup(t, h, job.ID)
+	assert.Equal(t, BackupDone, st.State)
+	assert.Equal(t, 2, st.DoneFiles)
+	assert.Equal(t, st.Bytes, st.DoneBytes)
+	assert.Empty(t, st.Failures)
+	for _, rel := range []string{"Docs/a.txt", "Docs/sub/b.txt"} {
+		got, err := os.ReadFile(filepath.Join(target, rel))
+		require.NoError(t, err)
+		assert.Equal(t, rel, string(got))
+		aInfo, err := os.Stat(filepath.Join(archivesRoot, rel))
+		require.NoError(t, err)
+		bInfo, err := os.Stat(filepath.Join(target, rel))
+		require.NoError(t, err)
+		assert.Equal(t, aInfo.ModTime(), bInfo.ModTime())
+	}
+	assert.NoFileExists(t, filepath.Join(target, "Other.txt"))
+}
+
+func TestBackup_ExportsRuleMatches(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Photos/", "Photos/a.jpg", "Photos/b.txt", "c.jpg"}, nil)
+	target := backupTarget(t)
+
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q,"rules":[{"action":"select","extensions":["jpg"]}]}`, target))
+	assert.Equal(t, 2, job.Files)
+	h.daemon.runBackupJob(context.Background(), job.ID)
+	assert.FileExists(t, filepath.Join(target, "Photos", "a.jpg"))
+	assert.FileExists(t, filepath.Join(target, "c.jpg"))
+	assert.NoFileExists(t, filepath.Join(target, "Photos", "b.txt"))
+	assert.Equal(t, BackupDone, getBackup(t, h, job.ID).State)
+
+	// The selection is untouched
+	assert.False(t, registered(t, store, archivesRoot, "c.jpg").Selected)
+}
+
+func TestBackup_FailedFilesAreRetriedOnResume(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt", "b.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{
+		registered(t, store, archivesRoot, "a.txt").Inode,
+		registered(t, store, archivesRoot, "b.txt").Inode,
+	}, true))
+	target := backupTarget(t)
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q}`, target))
+
+	b := filepath.Join(archivesRoot, "b.txt")
+	require.NoError(t, os.Rename(b, b+".away"))
+	h.daemon.runBackupJob(context.Background(), job.ID)
+	st := getBackup(t, h, job.ID)
+	assert.Equal(t, BackupFailed, st.State)
+	assert.Equal(t, "1 files failed", st.Error)
+	require.Len(t, st.Failures, 1)
+	assert.Equal(t, "b.txt", st.Failures[0].Path)
+	assert.Equal(t, 1, st.DoneFiles)
+
+	require.NoError(t, os.Rename(b+".away", b))
+	w := postJSON(h.HandleResumeBackup, "/api/sync/backups/"+job.ID+"/resume", "")
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, BackupPending, getBackup(t, h, job.ID).State)
+	h.daemon.runBackupJob(context.Background(), job.ID)
+	st = getBackup(t, h, job.ID)
+	assert.Equal(t, BackupDone, st.State)
+	assert.Equal(t, 2, st.DoneFiles)
+	assert.FileExists(t, filepath.Join(target, "b.txt"))
+}
+
+func TestBackup_StopsWhenTargetGoes(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "a.txt").Inode}, true))
+	target := filepath.Join(backupTarget(t), "drive")
+	require.NoError(t, os.Mkdir(target, 0755))
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q}`, target))
+
+	require.NoError(t, os.Remove(target))
+	h.daemon.runBackupJob(context.Background(), job.ID)
+	st := getBackup(t, h, job.ID)
+	assert.Equal(t, BackupFailed, st.State)
+	assert.Contains(t, st.Error, "target unavailable")
+	assert.Zero(t, st.DoneFiles)
+	assert.Empty(t, st.Failures, "files stay pending for a resume")
+	assert.NoDirExists(t, target)
+}
+
+func TestBackup_RunnerResumesInterruptedJobs(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "a.txt").Inode}, true))
+	target := backupTarget(t)
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q}`, target))
+
+	// A shutdown mid-job leaves it running
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.daemon.runBackupJob(cancelled, job.ID)
+	assert.Equal(t, BackupRunning, getBackup(t, h, job.ID).State)
+	assert.NoFileExists(t, filepath.Join(target, "a.txt"))
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go h.daemon.runBackups(ctx)
+	require.Eventually(t, func() bool {
+		return getBackup(t, h, job.ID).State == BackupDone
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.FileExists(t, filepath.Join(target, "a.txt"))
+}
+
+func TestBackup_CancelAndDelete(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "a.txt").Inode}, true))
+	target := backupTarget(t)
+	job := createBackup(t, h, fmt.Sprintf(`{"target":%q}`, target))
+
+	w := postJSON(h.HandleCancelBackup, "/api/sync/backups/"+job.ID+"/cancel", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, BackupCancelled, getBackup(t, h, job.ID).State)
+	w = postJSON(h.HandleCancelBackup, "/api/sync/backups/"+job.ID+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleListBackups(w, httptest.NewRequest("GET", "/api/sync/backups", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Items []BackupJob `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, job.ID, list.Items[0].ID)
+
+	w = httptest.NewRecorder()
+	h.HandleDeleteBackup(w, httptest.NewRequest("DELETE", "/api/sync/backups/"+job.ID, nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = httptest.NewRecorder()
+	h.HandleGetBackup(w, httptest.NewRequest("GET", "/api/sync/backups/"+job.ID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestBackup_RejectsBadTargets(t *testing.T) {
+	h, _, archivesRoot, _ := setupHandlersEnv(t)
+	withConfig(t, func(c *Config) { c.BackupTargets = []string{filepath.Dir(archivesRoot)} })
+	allowed := backupTarget(t)
+	file := filepath.Join(allowed, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	elsewhere := t.TempDir()
+	require.NoError(t, os.Symlink(elsewhere, filepath.Join(allowed, "out")))
+	require.NoError(t, os.Symlink(archivesRoot, filepath.Join(allowed, "in")))
+	for _, body := range []string{
+		`{"target":"relative/dir"}`,
+		fmt.Sprintf(`{"target":%q}`, filepath.Join(archivesRoot, "inside")),
+		fmt.Sprintf(`{"target":%q}`, filepath.Dir(archivesRoot)),
+		fmt.Sprintf(`{"target":%q}`, file),
+		fmt.Sprintf(`{"target":%q}`, elsewhere),
+		fmt.Sprintf(`{"target":%q}`, filepath.Join(allowed, "out")),
+		fmt.Sprintf(`{"target":%q}`, filepath.Join(allowed, "in")),
+		fmt.Sprintf(`{"target":%q,"rules":[{"action":"keep"}]}`, allowed),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "inside"), 0755))
+		w := postJSON(h.HandleCreateBackup, "/api/sync/backups", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestPatchConfig_BackupTargetsStartupOnly(t *testing.T) {
+	allowed := backupTarget(t)
+	_, err := patchConfig([]byte(`{"backupTargets":["/etc"]}`))
+	assert.Error(t, err)
+	assert.Equal(t, []string{allowed}, currentConfig().BackupTargets)
+}
+
+func TestVerifyCopy_RemovesMismatch(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	require.NoError(t, os.WriteFile(src, []byte("original"), 0644))
+	require.NoError(t, os.WriteFile(dst, []byte("0riginal"), 0644))
+	assert.ErrorContains(t, verifyCopy(src, dst), "differs")
+	assert.NoFileExists(t, dst)
+}
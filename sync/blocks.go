@@ -0,0 +1,613 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"time"
+)
+
+// In block store mode (Config.SpacesBlockStore) every distinct chunk of
+// Spaces content is stored once. A Spaces file is then a small manifest
+// naming its chunks by sha256, which live in the store directory as
+// <first two hex digits>/<sha256 hex>. Chunk boundaries are chosen by a
+// gear hash of the content, so files differing by an insertion still
+// share the chunks around it.
+const (
+	blockMagic    = "FBSB\x01"
+	blockMinChunk = 16 << 10
+	blockMaxChunk = 256 << 10
+	blockMask     = 1<<16 - 1 // a boundary every ~64KiB on average
+)
+
+// blockGCInterval is how often chunks no manifest refers to any more are
+// removed.
+var blockGCInterval = time.Hour
+
+// gearTable holds the per-byte values of the chunking hash.
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15 // splitmix64
+		z := (x ^ x>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// BlockStats describes the block store as of its last collection.
+type BlockStats struct {
+	Files        int64     `json:"files"`        // manifests in Spaces and trash
+	LogicalBytes int64     `json:"logicalBytes"` // size of the files they describe
+	Chunks       int64     `json:"chunks"`
+	StoredBytes  int64     `json:"storedBytes"` // chunk bytes on disk
+	FreedChunks  int64     `json:"freedChunks"` // removed by the collection
+	FreedBytes   int64     `json:"freedBytes"`
+	CollectedAt  time.Time `json:"collectedAt"`
+}
+
+type blockChunk struct {
+	hash string
+	size int64
+}
+
+type blockManifest struct {
+	size   int64
+	chunks []blockChunk
+}
+
+// blockFS keeps the Spaces and trash roots as manifests over a shared
+// chunk directory. Directories, mtimes and inodes are those of the
+// manifest tree; sizes and content are those of the files described.
+// Plain files found there, such as edits made in Spaces by hand, are
+// read as they are.
+type blockFS struct {
+	dir   string
+	roots []string
+
+	mu    gosync.Mutex
+	live  map[string]int      // chunks referenced by open writers
+	kept  map[string]struct{} // chunks of manifests written or moved during a collection; nil outside one
+	stats BlockStats
+}
+
+func newBlockFS(dir string, roots ...string) *blockFS {
+	return &blockFS{dir: dir, roots: roots, live: make(map[string]int)}
+}
+
+func (b *blockFS) chunkPath(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+// blockInfo is a manifest's FileInfo reporting the size of its file.
+type blockInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i blockInfo) Size() int64 { return i.size }
+
+// logical turns the FileInfo of name into that of the file it describes.
+func (b *blockFS) logical(name string, info os.FileInfo, err error) (os.FileInfo, error) {
+	if err != nil || !info.Mode().IsRegular() {
+		return info, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, ok, err := readManifestHead(bufio.NewReaderSize(f, 64))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !ok {
+		return info, nil
+	}
+	return blockInfo{FileInfo: info, size: size}, nil
+}
+
+func (b *blockFS) Stat(name string) (os.FileInfo, error) {
+	info, err := os.Stat(name)
+	return b.logical(name, info, err)
+}
+
+func (b *blockFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := os.Lstat(name)
+	return b.logical(name, info, err)
+}
+
+func (b *blockFS) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := osFS{}.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	out := infos[:0]
+	for _, info := range infos {
+		info, err := b.logical(filepath.Join(name, info.Name()), info, nil)
+		if os.IsNotExist(err) {
+			continue // removed since ReadDir
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// ScanTree walks the manifest tree so scans see logical sizes.
+func (b *blockFS) ScanTree(root string) (map[string]FileStat, error) {
+	files := make(map[string]FileStat)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		if d.IsDir() && skipWatchDir(d.Name()) {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err == nil {
+			info, err = b.logical(path, info, nil)
+		}
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[relPath] = FileStat{
+			Inode: stat.Ino,
+			Name:  d.Name(),
+			Size:  info.Size(),
+			Mtime: info.ModTime().UnixNano(),
+			IsDir: d.IsDir(),
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (b *blockFS) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	m, err := readManifest(br)
+	if err == nil && m == nil {
+		_, err = f.Seek(0, io.SeekStart) // a plain file
+		if err == nil {
+			return f, nil
+		}
+	}
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &blockReader{fs: b, chunks: m.chunks}, nil
+}
+
+// Append continues the manifest at name, or starts one holding the
+// content of a plain file there. The manifest is written on Close.
+func (b *blockFS) Append(name string) (io.WriteCloser, int64, error) {
+	w := &blockWriter{fs: b, name: name}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return w, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	m, err := readManifest(br)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if m != nil {
+		b.mu.Lock()
+		for _, c := range m.chunks {
+			b.live[c.hash]++
+		}
+		b.mu.Unlock()
+		w.chunks, w.size = m.chunks, m.size
+		return w, w.size, nil
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		w.Close() //nolint:errcheck
+		return nil, 0, err
+	}
+	return w, w.size + int64(len(w.buf)), nil
+}
+
+// Rename moves a manifest or a directory of them; a collection running
+// meanwhile may have already walked where it went, so its chunks are
+// kept.
+func (b *blockFS) Rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	collecting := b.kept != nil
+	b.mu.Unlock()
+	if collecting {
+		return b.keepTree(to)
+	}
+	return nil
+}
+
+func (b *blockFS) Remove(name string) error { return os.Remove(name) }
+
+func (b *blockFS) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+
+func (b *blockFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// putChunk stores data under hash unless it is already there.
+func (b *blockFS) putChunk(hash string, data []byte) error {
+	path := b.chunkPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) //nolint:errcheck
+	}
+	return err
+}
+
+// readChunk reads a chunk, failing if its content no longer matches.
+func (b *blockFS) readChunk(c blockChunk) ([]byte, error) {
+	data, err := os.ReadFile(b.chunkPath(c.hash))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", c.hash, err)
+	}
+	if sum := sha256.Sum256(data); int64(len(data)) != c.size || hex.EncodeToString(sum[:]) != c.hash {
+		return nil, fmt.Errorf("chunk %s: corrupt", c.hash)
+	}
+	return data, nil
+}
+
+// release drops an open writer's hold on its chunks.
+func (b *blockFS) release(chunks []blockChunk) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range chunks {
+		if b.live[c.hash]--; b.live[c.hash] <= 0 {
+			delete(b.live, c.hash)
+		}
+		if b.kept != nil {
+			b.kept[c.hash] = struct{}{}
+		}
+	}
+}
+
+// keepTree marks the chunks of every manifest under path as kept by the
+// running collection.
+func (b *blockFS) keepTree(path string) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		m, err := loadManifest(p)
+		if err != nil || m == nil {
+			return err
+		}
+		b.mu.Lock()
+		if b.kept != nil {
+			for _, c := range m.chunks {
+				b.kept[c.hash] = struct{}{}
+			}
+		}
+		b.mu.Unlock()
+		return nil
+	})
+}
+
+// collect removes the chunks no manifest under the roots refers to. A
+// failure to read any manifest aborts it before anything is removed.
+func (b *blockFS) collect() (BlockStats, error) {
+	b.mu.Lock()
+	b.kept = make(map[string]struct{})
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.kept = nil
+		b.mu.Unlock()
+	}()
+
+	var st BlockStats
+	used := make(map[string]bool)
+	for _, root := range b.roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			m, err := loadManifest(path)
+			if err != nil || m == nil {
+				if os.IsNotExist(err) {
+					return nil // moved away; Rename keeps its chunks
+				}
+				return err
+			}
+			st.Files++
+			st.LogicalBytes += m.size
+			for _, c := range m.chunks {
+				used[c.hash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return st, fmt.Errorf("read manifests: %w", err)
+		}
+	}
+
+	err := filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed since the walk listed it
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			if sinceNow(info.ModTime()) > blockGCInterval {
+				os.Remove(path) //nolint:errcheck // left by a crash
+			}
+			return nil
+		}
+		b.mu.Lock()
+		_, kept := b.kept[name]
+		keep := used[name] || kept || b.live[name] > 0
+		if !keep {
+			err = os.Remove(path)
+		}
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if keep {
+			st.Chunks++
+			st.StoredBytes += info.Size()
+		} else {
+			st.FreedChunks++
+			st.FreedBytes += info.Size()
+		}
+		return nil
+	})
+	st.CollectedAt = nowFunc()
+	if err != nil {
+		return st, fmt.Errorf("collect chunks: %w", err)
+	}
+	b.mu.Lock()
+	b.stats = st
+	b.mu.Unlock()
+	return st, nil
+}
+
+// Stats returns the figures of the last collection.
+func (b *blockFS) Stats() BlockStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// blockWriter chunks what is written to it and writes the manifest of
+// the result on Close.
+type blockWriter struct {
+	fs     *blockFS
+	name   string
+	chunks []blockChunk
+	size   int64 // of chunks
+	buf    []byte
+	hash   uint64
+	closed bool
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	start := 0
+	for i, c := range p {
+		w.hash = w.hash<<1 + gearTable[c]
+		n := len(w.buf) + i + 1 - start
+		if n >= blockMaxChunk || (n >= blockMinChunk && w.hash&blockMask == 0) {
+			w.buf = append(w.buf, p[start:i+1]...)
+			start = i + 1
+			if err := w.cut(); err != nil {
+				return start, err
+			}
+		}
+	}
+	w.buf = append(w.buf, p[start:]...)
+	return len(p), nil
+}
+
+// cut stores the buffered bytes as the next chunk.
+func (w *blockWriter) cut() error {
+	sum := sha256.Sum256(w.buf)
+	c := blockChunk{hash: hex.EncodeToString(sum[:]), size: int64(len(w.buf))}
+	// Hold the chunk before looking for it, so a collection can't remove
+	// it in between
+	w.fs.mu.Lock()
+	w.fs.live[c.hash]++
+	w.fs.mu.Unlock()
+	w.chunks = append(w.chunks, c)
+	w.size += c.size
+	err := w.fs.putChunk(c.hash, w.buf)
+	w.buf, w.hash = w.buf[:0], 0
+	return err
+}
+
+func (w *blockWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer func() { w.fs.release(w.chunks) }()
+	if len(w.buf) > 0 {
+		if err := w.cut(); err != nil {
+			return err
+		}
+	}
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "%s %d\n", blockMagic, w.size)
+	for _, c := range w.chunks {
+		fmt.Fprintf(&m, "%s %d\n", c.hash, c.size)
+	}
+	return os.WriteFile(w.name, m.Bytes(), 0644)
+}
+
+// blockReader reads the file a manifest describes, chunk by chunk.
+type blockReader struct {
+	fs     *blockFS
+	chunks []blockChunk
+	cur    bytes.Reader
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for r.cur.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := r.fs.readChunk(r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		r.chunks = r.chunks[1:]
+		r.cur.Reset(data)
+	}
+	return r.cur.Read(p)
+}
+
+func (r *blockReader) Close() error { return nil }
+
+// readManifestHead reads the first line of a manifest and returns the
+// size of the file it describes; ok is false for any other file.
+func readManifestHead(br *bufio.Reader) (size int64, ok bool, err error) {
+	head, err := br.Peek(len(blockMagic) + 1)
+	if err == io.EOF || (err == nil && string(head) != blockMagic+" ") {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return 0, false, fmt.Errorf("corrupt manifest: %w", err)
+	}
+	size, err = strconv.ParseInt(strings.TrimSuffix(line[len(head):], "\n"), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("corrupt manifest: %w", err)
+	}
+	return size, true, nil
+}
+
+// readManifest parses a manifest, or returns nil for any other file.
+func readManifest(br *bufio.Reader) (*blockManifest, error) {
+	size, ok, err := readManifestHead(br)
+	if err != nil || !ok {
+		return nil, err
+	}
+	m := &blockManifest{size: size}
+	var total int64
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt manifest: %w", err)
+		}
+		hash, n, found := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		chunkSize, perr := strconv.ParseInt(n, 10, 64)
+		if !found || len(hash) != sha256.Size*2 || perr != nil {
+			return nil, fmt.Errorf("corrupt manifest: bad chunk line %q", line)
+		}
+		m.chunks = append(m.chunks, blockChunk{hash: hash, size: chunkSize})
+		total += chunkSize
+	}
+	if total != size {
+		return nil, fmt.Errorf("corrupt manifest: chunks hold %d bytes, not %d", total, size)
+	}
+	return m, nil
+}
+
+func loadManifest(path string) (*blockManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readManifest(bufio.NewReader(f))
+}
+
+// mountBlocks routes the Spaces and trash roots through the block store
+// when Config.SpacesBlockStore is set. The returned func unmounts it.
+func (d *Daemon) mountBlocks() (func(), error) {
+	if d.blocks == nil {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(d.blocks.dir, 0755); err != nil {
+		return nil, fmt.Errorf("block store: %w", err)
+	}
+	unmount := mountFS(d.blocks, d.spacesRoot, d.trashRoot)
+	sub("daemon").Info("Spaces block store mounted", "dir", d.blocks.dir, "spaces", d.spacesRoot)
+	return unmount, nil
+}
+
+// runBlockGC collects unreferenced chunks every blockGCInterval.
+func (d *Daemon) runBlockGC(ctx context.Context) {
+	l := sub("blocks")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(blockGCInterval)):
+		}
+		st, err := d.blocks.collect()
+		if err != nil {
+			l.Warn("block collection failed", "err", err)
+			continue
+		}
+		l.Info("block collection complete", "files", st.Files, "logicalBytes", st.LogicalBytes,
+			"chunks", st.Chunks, "storedBytes", st.StoredBytes, "freedChunks", st.FreedChunks, "freedBytes", st.FreedBytes)
+	}
+}
@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mountBlockStore routes root through a block store in a temp dir.
+func mountBlockStore(t *testing.T, roots ...string) *blockFS {
+	t.Helper()
+	b := newBlockFS(filepath.Join(t.TempDir(), "blocks"), roots...)
+	t.Cleanup(mountFS(b, roots...))
+	return b
+}
+
+func countChunks(t *testing.T, b *blockFS) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(b.dir, func(_ string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func readVia(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := fsFor(path).Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return data
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestBlockFS_StoresIdenticalContentOnce(t *testing.T) {
+	src, spaces := t.TempDir(), t.TempDir()
+	b := mountBlockStore(t, spaces)
+	data := randomBytes(t, 2<<20)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.bin"), data, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.bin"), data, 0644))
+	ctx := context.Background()
+
+	require.NoError(t, SafeCopy(ctx, filepath.Join(src, "a.bin"), filepath.Join(spaces, "a.bin"), nil))
+	one := countChunks(t, b)
+	assert.Greater(t, one, 1)
+	require.NoError(t, SafeCopy(ctx, filepath.Join(src, "b.bin"), filepath.Join(spaces, "Copy", "b.bin"), nil))
+	assert.Equal(t, one, countChunks(t, b), "the second copy adds no chunks")
+
+	for p, from := range map[string]string{filepath.Join(spaces, "a.bin"): "a.bin", filepath.Join(spaces, "Copy", "b.bin"): "b.bin"} {
+		raw, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(raw), blockMagic), "Spaces holds a manifest")
+		assert.Less(t, len(raw), 64<<10)
+		info, err := fsFor(p).Stat(p)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), info.Size())
+		srcInfo, err := os.Stat(filepath.Join(src, from))
+		require.NoError(t, err)
+		assert.Equal(t, srcInfo.ModTime(), info.ModTime())
+		assert.Equal(t, data, readVia(t, p))
+	}
+
+	// An insertion only changes the chunks around it
+	edited := append(append(bytes.Clone(data[:1<<20]), []byte("inserted")...), data[1<<20:]...)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "c.bin"), edited, 0644))
+	require.NoError(t, SafeCopy(ctx, filepath.Join(src, "c.bin"), filepath.Join(spaces, "c.bin"), nil))
+	assert.LessOrEqual(t, countChunks(t, b), one+3)
+	assert.Equal(t, edited, readVia(t, filepath.Join(spaces, "c.bin")))
+}
+
+func TestBlockFS_PlainFilesReadAsIs(t *testing.T) {
+	spaces := t.TempDir()
+	mountBlockStore(t, spaces)
+	p := filepath.Join(spaces, "by-hand.txt")
+	require.NoError(t, os.WriteFile(p, []byte("typed in Spaces"), 0644))
+
+	info, err := fsFor(p).Stat(p)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("typed in Spaces")), info.Size())
+	assert.Equal(t, "typed in Spaces", string(readVia(t, p)))
+
+	// Appending turns it into a manifest of the whole content
+	w, size, err := fsFor(p).Append(p)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("typed in Spaces")), size)
+	_, err = w.Write([]byte(", then appended"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "typed in Spaces, then appended", string(readVia(t, p)))
+	raw, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), blockMagic))
+}
+
+func TestBlockFS_CollectRemovesUnreferencedChunks(t *testing.T) {
+	src, spaces, trash := t.TempDir(), t.TempDir(), t.TempDir()
+	b := mountBlockStore(t, spaces, trash)
+	shared, own := randomBytes(t, 1<<20), randomBytes(t, 1<<20)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "shared"), shared, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "own"), own, 0644))
+	ctx := context.Background()
+	for _, name := range []string{"one", "two"} {
+		require.NoError(t, SafeCopy(ctx, filepath.Join(src, "shared"), filepath.Join(spaces, name), nil))
+	}
+	require.NoError(t, SafeCopy(ctx, filepath.Join(src, "own"), filepath.Join(spaces, "own"), nil))
+	all := countChunks(t, b)
+
+	st, err := b.collect()
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, st.Files)
+	assert.EqualValues(t, 3<<20, st.LogicalBytes)
+	assert.EqualValues(t, 2<<20, st.StoredBytes)
+	assert.Zero(t, st.FreedChunks)
+	assert.Equal(t, st, b.Stats())
+
+	// Trashed files keep their chunks
+	_, err = SoftDelete(filepath.Join(spaces, "own"), trash)
+	require.NoError(t, err)
+	require.NoError(t, fsFor(spaces).Remove(filepath.Join(spaces, "one")))
+	st, err = b.collect()
+	require.NoError(t, err)
+	assert.Zero(t, st.FreedChunks)
+	assert.Equal(t, all, countChunks(t, b))
+
+	require.NoError(t, os.RemoveAll(trash))
+	st, err = b.collect()
+	require.NoError(t, err)
+	assert.Positive(t, st.FreedChunks)
+	assert.EqualValues(t, 1<<20, st.StoredBytes)
+	assert.Equal(t, shared, readVia(t, filepath.Join(spaces, "two")))
+}
+
+func TestBlockFS_CollectKeepsChunksOfOpenWriters(t *testing.T) {
+	spaces := t.TempDir()
+	b := mountBlockStore(t, spaces)
+	p := filepath.Join(spaces, "growing")
+	w, _, err := fsFor(p).Append(p)
+	require.NoError(t, err)
+	data := randomBytes(t, 1<<20)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+
+	st, err := b.collect()
+	require.NoError(t, err)
+	assert.Zero(t, st.FreedChunks, "chunks not yet in a manifest are kept")
+	require.NoError(t, w.Close())
+	assert.Equal(t, data, readVia(t, p))
+}
+
+func TestBlockFS_ReadFailsOnCorruptChunk(t *testing.T) {
+	src, spaces := t.TempDir(), t.TempDir()
+	b := mountBlockStore(t, spaces)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "f"), []byte("some content"), 0644))
+	p := filepath.Join(spaces, "f")
+	require.NoError(t, SafeCopy(context.Background(), filepath.Join(src, "f"), p, nil))
+	m, err := loadManifest(p)
+	require.NoError(t, err)
+	require.Len(t, m.chunks, 1)
+	require.NoError(t, os.WriteFile(b.chunkPath(m.chunks[0].hash), []byte("other stuff!"), 0644))
+
+	f, err := fsFor(p).Open(p)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = io.ReadAll(f)
+	assert.ErrorContains(t, err, "corrupt")
+}
+
+func TestPipeline_BlockStoreSpaces(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	b := mountBlockStore(t, spacesRoot)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	entry := registered(t, store, archivesRoot, "Docs/a.txt")
+	require.NoError(t, store.SetSelected([]uint64{entry.Inode}, true))
+	ctx := context.Background()
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+
+	spacesPath := filepath.Join(spacesRoot, "Docs", "a.txt")
+	raw, err := os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), blockMagic))
+	assert.Equal(t, "Docs/a.txt", string(readVia(t, spacesPath)))
+	assert.Equal(t, 1, countChunks(t, b))
+	sv, err := store.GetSpacesView(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+
+	// Converged: a second run rewrites nothing
+	before, err := os.Stat(spacesPath)
+	require.NoError(t, err)
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+	after, err := os.Stat(spacesPath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(before, after))
+
+	// A file edited in Spaces by hand reaches Archives
+	require.NoError(t, os.WriteFile(spacesPath, []byte("edited"), 0644))
+	later := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(spacesPath, later, later))
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+	got, err := os.ReadFile(filepath.Join(archivesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "edited", string(got))
+
+	// The content endpoint serves the file, not its manifest
+	w := httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/"+strconv.FormatUint(entry.Inode, 10), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "edited", w.Body.String())
+}
+
+func TestHandleBlockStats(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	w := httptest.NewRecorder()
+	h.HandleBlockStats(w, httptest.NewRequest("GET", "/api/sync/blocks", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.SpacesBlockStore = filepath.Join(t.TempDir(), "blocks")
+	require.NoError(t, setConfig(cfg))
+	h, _, _, spacesRoot := setupHandlersEnv(t)
+	require.NotNil(t, h.daemon.blocks)
+	t.Cleanup(mountFS(h.daemon.blocks, spacesRoot))
+	require.NoError(t, os.MkdirAll(cfg.SpacesBlockStore, 0755))
+	src := filepath.Join(t.TempDir(), "f")
+	require.NoError(t, os.WriteFile(src, []byte("twice"), 0644))
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spacesRoot, "one"), nil))
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spacesRoot, "two"), nil))
+	_, err := h.daemon.blocks.collect()
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.HandleBlockStats(w, httptest.NewRequest("GET", "/api/sync/blocks", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var st BlockStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.EqualValues(t, 2, st.Files)
+	assert.EqualValues(t, 10, st.LogicalBytes)
+	assert.EqualValues(t, 5, st.StoredBytes)
+}
@@ -0,0 +1,47 @@
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// breakdownTTL is how long a computed storage breakdown is served from cache.
+const breakdownTTL = 30 * time.Second
+
+// StatsBreakdownResponse is the storage usage breakdown returned by
+// GET /api/sync/stats/breakdown.
+type StatsBreakdownResponse struct {
+	ByType     []UsageBucket `json:"byType"`
+	ByDir      []UsageBucket `json:"byDir"`
+	ComputedAt int64         `json:"computedAt"` // nanoseconds
+}
+
+// breakdownCache holds the last breakdown so treemap refreshes don't
+// re-walk the whole tree on every request.
+type breakdownCache struct {
+	mu  gosync.Mutex
+	val *StatsBreakdownResponse
+}
+
+// get returns the cached breakdown, recomputing it once it is older than
+// breakdownTTL or when refresh is set.
+func (c *breakdownCache) get(store *Store, refresh bool) (*StatsBreakdownResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := nowFunc()
+	if !refresh && c.val != nil && now.Sub(time.Unix(0, c.val.ComputedAt)) < breakdownTTL {
+		return c.val, nil
+	}
+
+	byType, err := store.UsageByType()
+	if err != nil {
+		return nil, err
+	}
+	byDir, err := store.UsageByTopDir()
+	if err != nil {
+		return nil, err
+	}
+	c.val = &StatsBreakdownResponse{ByType: byType, ByDir: byDir, ComputedAt: now.UnixNano()}
+	sub("stats").Debug("breakdown computed", "types", len(byType), "dirs", len(byDir), "durationMs", nowFunc().Sub(now).Milliseconds())
+	return c.val, nil
+}
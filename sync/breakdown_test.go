@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleStatsBreakdown(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	// Media(1)/{a.mp4(2) synced, Sub(3)/b.jpg(4)}, Docs(5)/c.txt(6), root.txt(7)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "Media", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "a.mp4", Type: "video", Size: ptr(int64(1000)), Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 3, ParentIno: 1, Name: "Sub", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 4, ParentIno: 3, Name: "b.jpg", Type: "image", Size: ptr(int64(300)), Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "Docs", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 6, ParentIno: 5, Name: "c.txt", Type: "text", Size: ptr(int64(20)), Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 7, Name: "root.txt", Type: "text", Size: ptr(int64(5)), Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 2, SyncedMtime: 1, CheckedAt: 1}))
+
+	get := func(query string) StatsBreakdownResponse {
+		w := httptest.NewRecorder()
+		h.HandleStatsBreakdown(w, httptest.NewRequest("GET", "/api/sync/stats/breakdown"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp StatsBreakdownResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := get("")
+	assert.Equal(t, []UsageBucket{
+		{Key: "video", TotalBytes: 1000, SyncedBytes: 1000, Files: 1},
+		{Key: "image", TotalBytes: 300, Files: 1},
+		{Key: "text", TotalBytes: 25, Files: 2},
+	}, resp.ByType)
+	assert.Equal(t, []UsageBucket{
+		{Key: "Media", Inode: 1, TotalBytes: 1300, SyncedBytes: 1000, Files: 2},
+		{Key: "Docs", Inode: 5, TotalBytes: 20, Files: 1},
+		{Key: "", TotalBytes: 5, Files: 1},
+	}, resp.ByDir)
+
+	// Served from cache until the TTL passes or refresh is requested
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 8, ParentIno: 5, Name: "d.txt", Type: "text", Size: ptr(int64(1)), Mtime: 1}))
+	assert.Equal(t, resp, get(""))
+	assert.Len(t, get("?refresh=1").ByType, 3)
+	assert.Equal(t, 3, get("").ByType[2].Files)
+
+	useFakeClock(t, time.Now().Add(breakdownTTL))
+	require.NoError(t, store.DeleteEntry(8))
+	assert.Equal(t, 2, get("").ByType[2].Files)
+}
@@ -0,0 +1,225 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// Canary files are decoys the daemon places in Spaces, at the paths of
+// Config.Anomaly.Canaries, and that nothing is ever expected to touch.
+// Ransomware working through Spaces gets to them sooner or later: a
+// canary found modified or deleted enters safe mode at once. Canary paths
+// are skipped by the scanner and watcher on both roots, so they are never
+// synced; the watcher checks them as their events come in, and
+// runCanaryCheck regularly, for remote Spaces and events missed.
+//
+// The store records each canary placed, so one deleted while the daemon
+// was down trips at startup instead of being placed again, and a path
+// holding a file the daemon did not place never gets a canary written
+// over it, nor is it checked.
+
+// canaryCheckInterval is how often all canaries are checked.
+var canaryCheckInterval = time.Minute
+
+// canaryContent is what a canary holds.
+var canaryContent = []byte("This file is a canary watched by the sync daemon.\nDo not modify, move or delete it.\n")
+
+// placedCanaries is the set of canaries recorded in the store, as last
+// loaded by placeCanaries.
+var placedCanaries struct {
+	mu    gosync.Mutex
+	paths map[string]bool
+}
+
+// canaryPlaced reports whether the canary at relPath was placed.
+func canaryPlaced(relPath string) bool {
+	placedCanaries.mu.Lock()
+	defer placedCanaries.mu.Unlock()
+	return placedCanaries.paths[relPath]
+}
+
+// isCanary reports whether relPath is one of the configured canaries.
+func isCanary(relPath string) bool {
+	canaries := activeConfig.Load().Anomaly.Canaries
+	if len(canaries) == 0 {
+		return false
+	}
+	relPath = cleanRelPath(relPath)
+	for _, c := range canaries {
+		if cleanRelPath(c) == relPath {
+			return true
+		}
+	}
+	return false
+}
+
+// placeCanaries creates the canaries under spacesRoot never placed and
+// checks the others, as at startup; with reset, the changed ones are
+// rewritten instead of tripping safe mode. A canary is never written over
+// a file the daemon did not place.
+func placeCanaries(store *Store, spacesRoot string, reset bool) {
+	l := sub("canary")
+	placed, err := store.PlacedCanaries()
+	if err != nil {
+		l.Error("canaries not placed", "err", err)
+		return
+	}
+	configured := make(map[string]bool)
+	for _, c := range activeConfig.Load().Anomaly.Canaries {
+		relPath := cleanRelPath(c)
+		configured[relPath] = true
+		how := canaryChanged(spacesRoot, relPath)
+		switch {
+		case how == "" && placed[relPath]:
+			continue
+		case how == "":
+			// Placed before canaries were recorded.
+		case placed[relPath] && !reset:
+			anomalies.canaryTripped(relPath, how)
+			continue
+		case how == "modified" && !placed[relPath]:
+			l.Error("canary not placed: a file the daemon did not place is at its path", "canary", relPath)
+			continue
+		default:
+			if err := writeCanary(filepath.Join(spacesRoot, relPath)); err != nil {
+				l.Error("canary not placed", "canary", relPath, "err", err)
+				continue
+			}
+			l.Info("canary placed", "canary", relPath)
+		}
+		if err := store.SetCanaryPlaced(relPath, true); err != nil {
+			l.Error("canary not recorded", "canary", relPath, "err", err)
+			continue
+		}
+		placed[relPath] = true
+	}
+	for relPath := range placed {
+		if configured[relPath] {
+			continue
+		}
+		if err := store.SetCanaryPlaced(relPath, false); err != nil {
+			l.Warn("canary record not removed", "canary", relPath, "err", err)
+		}
+		delete(placed, relPath)
+	}
+	placedCanaries.mu.Lock()
+	placedCanaries.paths = placed
+	placedCanaries.mu.Unlock()
+}
+
+// checkCanaries trips safe mode if a canary under spacesRoot changed.
+func checkCanaries(spacesRoot string) {
+	for _, c := range activeConfig.Load().Anomaly.Canaries {
+		checkCanary(spacesRoot, cleanRelPath(c))
+	}
+}
+
+// checkCanary trips safe mode if the canary at relPath changed. Paths not
+// holding a placed canary are left alone.
+func checkCanary(spacesRoot, relPath string) {
+	if !canaryPlaced(relPath) {
+		return
+	}
+	if how := canaryChanged(spacesRoot, relPath); how != "" {
+		anomalies.canaryTripped(relPath, how)
+	}
+}
+
+// canaryChanged returns how the canary at relPath differs from what was
+// placed: "deleted", "modified", or "" if it is intact. A canary that
+// can't be read for another reason counts as intact: the disk may be
+// busy, and the next check tries again.
+func canaryChanged(spacesRoot, relPath string) string {
+	path := filepath.Join(spacesRoot, relPath)
+	f, err := fsFor(path).Open(path)
+	if os.IsNotExist(err) {
+		return "deleted"
+	}
+	if err != nil {
+		sub("canary").Warn("canary unreadable", "canary", relPath, "err", err)
+		return ""
+	}
+	defer f.Close()
+	got, err := io.ReadAll(io.LimitReader(f, int64(len(canaryContent))+1))
+	if err != nil {
+		sub("canary").Warn("canary unreadable", "canary", relPath, "err", err)
+		return ""
+	}
+	if !bytes.Equal(got, canaryContent) {
+		return "modified"
+	}
+	return ""
+}
+
+// writeCanary writes a canary to path through a temp file, so a check
+// never sees it half written.
+func writeCanary(path string) error {
+	fs := fsFor(path)
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".sync-tmp"
+	fs.Remove(tmp) //nolint:errcheck // a leftover from an interrupted write
+	w, _, err := fs.Append(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(canaryContent); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmp, path)
+}
+
+// runCanaryCheck checks the canaries every canaryCheckInterval until ctx
+// is cancelled.
+func (d *Daemon) runCanaryCheck(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(canaryCheckInterval):
+			checkCanaries(d.spacesRoot)
+		}
+	}
+}
+
+// PlacedCanaries returns the paths of the canaries placed.
+func (s *Store) PlacedCanaries() (map[string]bool, error) {
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT rel_path FROM canaries")
+	if err != nil {
+		return nil, fmt.Errorf("list canaries: %w", err)
+	}
+	defer rows.Close()
+	placed := make(map[string]bool)
+	for rows.Next() {
+		var relPath string
+		if err := rows.Scan(&relPath); err != nil {
+			return nil, fmt.Errorf("scan canary: %w", err)
+		}
+		placed[relPath] = true
+	}
+	return placed, rows.Err()
+}
+
+// SetCanaryPlaced records, or with placed false forgets, the canary at
+// relPath.
+func (s *Store) SetCanaryPlaced(relPath string, placed bool) error {
+	query := "DELETE FROM canaries WHERE rel_path = ?"
+	if placed {
+		query = "INSERT OR IGNORE INTO canaries (rel_path) VALUES (?)"
+	}
+	if _, err := s.exec(query, relPath); err != nil {
+		return fmt.Errorf("set canary %s: %w", relPath, err)
+	}
+	return nil
+}
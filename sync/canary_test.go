@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useCanaries configures the given canaries for the test.
+func useCanaries(t *testing.T, canaries ...string) {
+	t.Helper()
+	resetAnomalies(t)
+	withConfig(t, func(c *Config) { c.Anomaly.Canaries = canaries })
+	prev := placedCanaries.paths
+	t.Cleanup(func() { placedCanaries.paths = prev })
+}
+
+func TestCanary_TripEntersSafeMode(t *testing.T) {
+	useCanaries(t, "Docs/!passwords.xlsx")
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "Docs/a.txt", []byte("v1"))
+	env.writeSpaces(t, "Docs/a.txt", []byte("v1"))
+	env.run(t, "Docs")
+	env.run(t, "Docs/a.txt")
+
+	placeCanaries(env.store, env.spacesRoot, false)
+	canary := filepath.Join(env.spacesRoot, "Docs", "!passwords.xlsx")
+	got, err := os.ReadFile(canary)
+	require.NoError(t, err)
+	assert.Equal(t, canaryContent, got)
+	checkCanaries(env.spacesRoot)
+	assert.Nil(t, anomalies.status().Alert)
+
+	require.NoError(t, os.WriteFile(canary, []byte("encrypted"), 0644))
+	checkCanaries(env.spacesRoot)
+	status := anomalies.status()
+	require.NotNil(t, status.Alert)
+	assert.Equal(t, AnomalyCanary, status.Alert.Reason)
+	assert.Equal(t, "modified", status.Alert.Canary)
+	assert.True(t, status.SafeMode)
+
+	// Archives changes wait too in safe mode.
+	env.clock.Advance(time.Second)
+	env.writeArchive(t, "Docs/a.txt", []byte("v2"))
+	env.run(t, "Docs/a.txt")
+	got, err = os.ReadFile(filepath.Join(env.spacesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+
+	// Acknowledging restores the canary and lets the change through.
+	h := NewHandlers(env.store, NewDaemon(env.store, env.archivesRoot, env.spacesRoot), env.archivesRoot, env.spacesRoot)
+	w := httptest.NewRecorder()
+	h.HandleAcknowledgeAnomaly(w, httptest.NewRequest("POST", "/api/sync/anomaly/ack", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, h.daemon.queue.Has("Docs/a.txt"))
+	got, err = os.ReadFile(canary)
+	require.NoError(t, err)
+	assert.Equal(t, canaryContent, got)
+	assert.False(t, anomalies.status().SafeMode)
+	env.run(t, "Docs/a.txt")
+	got, err = os.ReadFile(filepath.Join(env.spacesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(got))
+}
+
+func TestCanary_DeletedWhileDownTrips(t *testing.T) {
+	useCanaries(t, "!passwords.xlsx")
+	store := setupTestDB(t)
+	root := t.TempDir()
+	placeCanaries(store, root, false)
+	canary := filepath.Join(root, "!passwords.xlsx")
+	require.FileExists(t, canary)
+
+	// Deleted while the daemon was down: the next startup trips.
+	require.NoError(t, os.Remove(canary))
+	placeCanaries(store, root, false)
+	status := anomalies.status()
+	require.NotNil(t, status.Alert)
+	assert.Equal(t, "deleted", status.Alert.Canary)
+	assert.NoFileExists(t, canary)
+
+	placeCanaries(store, root, true)
+	assert.FileExists(t, canary)
+}
+
+func TestCanary_NotPlacedOverFile(t *testing.T) {
+	useCanaries(t, "Docs/budget.xlsx")
+	store := setupTestDB(t)
+	root := t.TempDir()
+	file := filepath.Join(root, "Docs", "budget.xlsx")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	require.NoError(t, os.WriteFile(file, []byte("real"), 0644))
+
+	placeCanaries(store, root, false)
+	checkCanaries(root)
+	assert.Nil(t, anomalies.status().Alert)
+	placeCanaries(store, root, true)
+	got, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "real", string(got))
+	placed, err := store.PlacedCanaries()
+	require.NoError(t, err)
+	assert.Empty(t, placed)
+}
+
+func TestCanary_SkippedByScanner(t *testing.T) {
+	useCanaries(t, "!passwords.xlsx")
+	root := t.TempDir()
+	placeCanaries(setupTestDB(t), root, false)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+
+	files, err := ScanDir(root)
+	require.NoError(t, err)
+	assert.Contains(t, files, "a.txt")
+	assert.NotContains(t, files, "!passwords.xlsx")
+	listed, err := listDir(root, "")
+	require.NoError(t, err)
+	assert.NotContains(t, listed, "!passwords.xlsx")
+}
+
+func TestCanary_WatcherTrips(t *testing.T) {
+	useCanaries(t, "!passwords.xlsx")
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(archivesRoot, 0755))
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+	placeCanaries(setupTestDB(t), spacesRoot, false)
+
+	queue := NewEvalQueue()
+	w, err := NewWatcher(archivesRoot, spacesRoot, queue)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.Start(ctx) //nolint:errcheck
+	}()
+	defer func() { cancel(); <-stopped }()
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "!passwords.xlsx")))
+	require.Eventually(t, func() bool { return anomalies.status().SafeMode }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "deleted", anomalies.status().Alert.Canary)
+	assert.Zero(t, queue.Len(), "canaries are not synced")
+}
@@ -0,0 +1,341 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errChaos is the error injected faults fail with.
+var errChaos = errors.New("chaos: injected fault")
+
+// chaos injects faults while it is on: it fails file system and Store
+// calls, delays them, and cuts reads short. Its choices come from one
+// seeded source, so rerunning a failure's seed takes the same path, up to
+// the inode numbers the disk hands out.
+type chaos struct {
+	mu       gosync.Mutex
+	rng      *rand.Rand
+	on       bool
+	failPct  int // calls failed outright
+	truncPct int // reads cut short
+	maxDelay time.Duration
+}
+
+func newChaos(seed uint64) *chaos {
+	return &chaos{rng: rand.New(rand.NewPCG(seed, 0)), on: true, failPct: 5, truncPct: 5, maxDelay: time.Millisecond}
+}
+
+func (c *chaos) stop() {
+	c.mu.Lock()
+	c.on = false
+	c.mu.Unlock()
+}
+
+// roll reports whether a fault with a pct chance happens now.
+func (c *chaos) roll(pct int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.on && c.rng.IntN(100) < pct
+}
+
+// fault delays the call op and may fail it.
+func (c *chaos) fault(op string) error {
+	c.mu.Lock()
+	on := c.on
+	var delay time.Duration
+	if on && c.maxDelay > 0 {
+		delay = time.Duration(c.rng.Int64N(int64(c.maxDelay)))
+	}
+	c.mu.Unlock()
+	if !on {
+		return nil
+	}
+	time.Sleep(delay)
+	if c.roll(c.failPct) {
+		return fmt.Errorf("%s: %w", op, errChaos)
+	}
+	return nil
+}
+
+// chaosFS is a fileSystem whose calls go through a chaos injector.
+type chaosFS struct {
+	fileSystem
+	c *chaos
+}
+
+func (f chaosFS) Stat(name string) (os.FileInfo, error) {
+	if err := f.c.fault("stat"); err != nil {
+		return nil, err
+	}
+	return f.fileSystem.Stat(name)
+}
+
+func (f chaosFS) ReadDir(name string) ([]os.FileInfo, error) {
+	if err := f.c.fault("readdir"); err != nil {
+		return nil, err
+	}
+	return f.fileSystem.ReadDir(name)
+}
+
+func (f chaosFS) Open(name string) (io.ReadCloser, error) {
+	if err := f.c.fault("open"); err != nil {
+		return nil, err
+	}
+	rc, err := f.fileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosReader{ReadCloser: rc, c: f.c}, nil
+}
+
+func (f chaosFS) Append(name string) (io.WriteCloser, int64, error) {
+	if err := f.c.fault("append"); err != nil {
+		return nil, 0, err
+	}
+	wc, n, err := f.fileSystem.Append(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &chaosWriter{WriteCloser: wc, c: f.c}, n, nil
+}
+
+func (f chaosFS) Rename(from, to string) error {
+	if err := f.c.fault("rename"); err != nil {
+		return err
+	}
+	return f.fileSystem.Rename(from, to)
+}
+
+func (f chaosFS) Remove(name string) error {
+	if err := f.c.fault("remove"); err != nil {
+		return err
+	}
+	return f.fileSystem.Remove(name)
+}
+
+func (f chaosFS) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.c.fault("mkdir"); err != nil {
+		return err
+	}
+	return f.fileSystem.MkdirAll(name, perm)
+}
+
+func (f chaosFS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.c.fault("chtimes"); err != nil {
+		return err
+	}
+	return f.fileSystem.Chtimes(name, atime, mtime)
+}
+
+// chaosReader fails reads or ends them early, as a dropped connection
+// or a short read would.
+type chaosReader struct {
+	io.ReadCloser
+	c   *chaos
+	eof bool
+}
+
+func (r *chaosReader) Read(p []byte) (int, error) {
+	if r.eof || r.c.roll(r.c.truncPct) {
+		r.eof = true
+		return 0, io.EOF
+	}
+	if err := r.c.fault("read"); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// chaosWriter fails writes.
+type chaosWriter struct {
+	io.WriteCloser
+	c *chaos
+}
+
+func (w *chaosWriter) Write(p []byte) (int, error) {
+	if err := w.c.fault("write"); err != nil {
+		return 0, err
+	}
+	return w.WriteCloser.Write(p)
+}
+
+// soakSteps returns whether step i of a soak test should run: a fixed
+// number by default, or until FB_SYNC_SOAK (a duration, e.g. 10m) has
+// passed since start.
+func soakSteps(t *testing.T, start time.Time) func(i int) bool {
+	t.Helper()
+	v := os.Getenv("FB_SYNC_SOAK")
+	if v == "" {
+		return func(i int) bool { return i < 500 }
+	}
+	d, err := time.ParseDuration(v)
+	require.NoError(t, err, "FB_SYNC_SOAK")
+	return func(int) bool { return time.Since(start) < d }
+}
+
+// soakSeed returns FB_SYNC_SOAK_SEED, or a fresh seed.
+func soakSeed(t *testing.T) uint64 {
+	t.Helper()
+	if v := os.Getenv("FB_SYNC_SOAK_SEED"); v != "" {
+		seed, err := strconv.ParseUint(v, 10, 64)
+		require.NoError(t, err, "FB_SYNC_SOAK_SEED")
+		return seed
+	}
+	return uint64(time.Now().UnixNano())
+}
+
+// soakPaths lists every path the daemon would evaluate: what the scanner
+// finds on either disk plus every live entry, parents first.
+func soakPaths(t *testing.T, env *pipelineEnv) []string {
+	t.Helper()
+	set := make(map[string]bool)
+	for _, root := range []string{env.archivesRoot, env.spacesRoot} {
+		stats, err := ScanDir(root)
+		require.NoError(t, err)
+		for rel := range stats {
+			set[rel] = true
+		}
+	}
+	var walk func(parent uint64)
+	walk = func(parent uint64) {
+		children, err := env.store.ListChildren(parent)
+		require.NoError(t, err)
+		for i := range children {
+			set[env.store.RelPath(&children[i])] = true
+			if children[i].Type == "dir" {
+				walk(children[i].Inode)
+			}
+		}
+	}
+	walk(0)
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// soakState gathers the state of relPath as RunPipeline does.
+func soakState(t *testing.T, env *pipelineEnv, relPath string) (State, *Entry) {
+	t.Helper()
+	aMtime, _, _, _ := statFile(filepath.Join(env.archivesRoot, relPath))
+	sMtime, _, _, _ := statFile(filepath.Join(env.spacesRoot, relPath))
+	entry, sv, err := lookupPipeline(env.store, env.archivesRoot, relPath)
+	require.NoError(t, err)
+	return ComputeState(entry, sv, aMtime, sMtime), entry
+}
+
+// TestSoak_ConvergesUnderChaos mutates both roots and the selection at
+// random while pipeline runs hit injected faults, then checks that once
+// the faults stop, re-evaluating every path (as the daemon's rescans do)
+// brings each one to a truth-table target. Set FB_SYNC_SOAK to run for
+// longer and FB_SYNC_SOAK_SEED to rerun a failing seed.
+func TestSoak_ConvergesUnderChaos(t *testing.T) {
+	restoreConfig(t)
+	seed := soakSeed(t)
+	t.Logf("seed %d", seed)
+	env := setupPipelineEnv(t)
+	c := newChaos(seed)
+	t.Cleanup(mountFS(chaosFS{fileSystem: osFS{}, c: c}, env.archivesRoot, env.spacesRoot, env.trashRoot))
+	storeFault = func() error { return c.fault("store") }
+	t.Cleanup(func() { storeFault = nil })
+
+	rng := rand.New(rand.NewPCG(seed, 1))
+	names := []string{"a.txt", "b.txt", "c.bin", "d/e.txt", "d/f.txt", "d/g/h.txt"}
+	mtime := time.Unix(1_700_000_000, 0)
+	write := func(root, rel string) {
+		p := filepath.Join(root, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, fmt.Appendf(nil, "%s %d", rel, rng.Int()), 0644))
+		mtime = mtime.Add(time.Second)
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+	run := func(rel string) {
+		RunPipeline(context.Background(), rel, env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil) //nolint:errcheck // faults fail runs
+	}
+
+	more := soakSteps(t, time.Now())
+	for i := 0; more(i); i++ {
+		rel := names[rng.IntN(len(names))]
+		switch rng.IntN(8) {
+		case 0:
+			write(env.archivesRoot, rel)
+		case 1:
+			write(env.spacesRoot, rel)
+		case 2:
+			os.Remove(filepath.Join(env.archivesRoot, rel)) //nolint:errcheck
+		case 3:
+			os.Remove(filepath.Join(env.spacesRoot, rel)) //nolint:errcheck
+		case 4:
+			if _, entry := soakState(t, env, rel); entry != nil {
+				env.store.SetSelected([]uint64{entry.Inode}, !entry.Selected) //nolint:errcheck // faults fail writes
+			}
+		default:
+			// Parents first, as the scanner queues them.
+			for d := filepath.Dir(rel); d != "."; d = filepath.Dir(d) {
+				run(d)
+			}
+			run(rel)
+		}
+	}
+
+	// Stop the faults and restart, as after a crash: the journal finishes
+	// the steps whose DB half failed.
+	c.stop()
+	_, err := replayJournal(env.store, env.archivesRoot, env.spacesRoot)
+	require.NoError(t, err)
+	var unconverged []string
+	for round := 0; round < 10; round++ {
+		paths := soakPaths(t, env)
+		unconverged = unconverged[:0]
+		for _, rel := range paths {
+			if st, _ := soakState(t, env, rel); !isTerminalScenario(st.Scenario()) {
+				unconverged = append(unconverged, fmt.Sprintf("%s #%d", rel, st.Scenario()))
+			}
+		}
+		if len(unconverged) == 0 {
+			break
+		}
+		for _, rel := range paths {
+			require.NoError(t, RunPipeline(context.Background(), rel, env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil), "round %d: %s", round, rel)
+		}
+	}
+	require.Empty(t, unconverged, "paths left outside the truth-table targets")
+
+	// The targets hold on disk too: a synced file is the same on both
+	// sides, an archived one is only in Archives.
+	for _, rel := range soakPaths(t, env) {
+		st, entry := soakState(t, env, rel)
+		switch st.Scenario() {
+		case 31:
+			if entry.Type == "dir" {
+				continue
+			}
+			a, err := os.ReadFile(filepath.Join(env.archivesRoot, rel))
+			require.NoError(t, err)
+			s, err := os.ReadFile(filepath.Join(env.spacesRoot, rel))
+			require.NoError(t, err)
+			assert.Equal(t, string(a), string(s), "synced %s differs", rel)
+		case 15:
+			assert.False(t, entry.Selected, rel)
+			assert.NoFileExists(t, filepath.Join(env.spacesRoot, rel))
+		}
+	}
+	intents, err := env.store.ListIntents()
+	require.NoError(t, err)
+	assert.Empty(t, intents, "journal intents left unfinished")
+}
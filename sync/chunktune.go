@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"path/filepath"
+	"sort"
+	gosync "sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// minChunk and maxAutoChunk bound the chunk sizes picked in auto mode.
+	minChunk     = 4 << 10
+	maxAutoChunk = 16 << 20
+
+	// chunkTarget is how long one chunk should take at the measured
+	// throughput: long enough to amortize the per-chunk overhead, short
+	// enough for cancellation and re-queue checks to stay responsive.
+	chunkTarget = 20 * time.Millisecond
+
+	// minTuneSample is the smallest copy whose throughput is measured.
+	// Below it the fixed costs (open, fsync, rename) dominate.
+	minTuneSample = 1 << 20
+)
+
+// chunkTuner picks SafeCopy's chunk size with Config.CopyChunkAuto. It
+// keeps a moving average of the copy throughput to each destination
+// device, and sizes chunks to take chunkTarget at that rate, never below
+// Config.CopyChunkSize. Before a device is measured, large files get
+// larger chunks; files smaller than a chunk get a buffer that just fits.
+type chunkTuner struct {
+	mu      gosync.Mutex
+	devices map[uint64]*deviceRate
+}
+
+type deviceRate struct {
+	rate  float64 // bytes/s, moving average
+	chunk int     // last size picked
+}
+
+var chunks = &chunkTuner{devices: make(map[uint64]*deviceRate)}
+
+// CopyChunkStat is the auto-tuned chunk size for one destination device.
+type CopyChunkStat struct {
+	Device     uint64  `json:"device"`
+	Throughput float64 `json:"throughput"` // bytes/s, moving average
+	ChunkSize  int     `json:"chunkSize"`  // last picked
+}
+
+// destDevice returns the device holding dst's directory, or 0 when the
+// file system doesn't say.
+func destDevice(dst string) uint64 {
+	dir := filepath.Dir(dst)
+	info, err := fsFor(dir).Stat(dir)
+	if err != nil {
+		return 0
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev) //nolint:unconvert // Dev is uint32 on some platforms
+	}
+	return 0
+}
+
+// chunkSize returns the chunk size for copying size bytes to dst, and
+// the device to report the copy's throughput for to observe.
+func (c *chunkTuner) chunkSize(dst string, size int64) (int, uint64) {
+	cfg := activeConfig.Load()
+	if !cfg.CopyChunkAuto {
+		return cfg.CopyChunkSize, 0
+	}
+	dev := destDevice(dst)
+	chunk := cfg.CopyChunkSize
+	c.mu.Lock()
+	d := c.devices[dev]
+	if d != nil && d.rate > 0 {
+		want := int(d.rate * chunkTarget.Seconds())
+		chunk = max(chunk, min(roundPow2(want), maxAutoChunk))
+	} else {
+		// Not measured yet: scale with the file, a thousand chunks or so.
+		chunk = max(chunk, min(roundPow2(int(size>>10)), maxAutoChunk))
+	}
+	if size < int64(chunk) {
+		chunk = max(roundPow2(int(size)), minChunk)
+	}
+	if d == nil {
+		d = &deviceRate{}
+		c.devices[dev] = d
+	}
+	d.chunk = chunk
+	c.mu.Unlock()
+	return chunk, dev
+}
+
+// observe folds a copy of n bytes to dev that took took into the
+// device's throughput.
+func (c *chunkTuner) observe(dev uint64, n int64, took time.Duration) {
+	if n < minTuneSample || took <= 0 || !activeConfig.Load().CopyChunkAuto {
+		return
+	}
+	rate := float64(n) / took.Seconds()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.devices[dev]
+	if d == nil {
+		d = &deviceRate{}
+		c.devices[dev] = d
+	}
+	if d.rate == 0 {
+		d.rate = rate
+	} else {
+		d.rate = 0.7*d.rate + 0.3*rate
+	}
+}
+
+// stats returns the tuned devices, by device number.
+func (c *chunkTuner) stats() []CopyChunkStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CopyChunkStat, 0, len(c.devices))
+	for dev, d := range c.devices {
+		out = append(out, CopyChunkStat{Device: dev, Throughput: d.rate, ChunkSize: d.chunk})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Device < out[j].Device })
+	return out
+}
+
+// roundPow2 rounds n up to a power of two.
+func roundPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setChunkAuto(t *testing.T, auto bool) {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.CopyChunkAuto = auto })
+	orig := chunks
+	chunks = &chunkTuner{devices: make(map[uint64]*deviceRate)}
+	t.Cleanup(func() { chunks = orig })
+}
+
+func TestChunkSize_FixedByDefault(t *testing.T) {
+	setChunkAuto(t, false)
+	dst := filepath.Join(t.TempDir(), "f")
+	chunk, _ := chunks.chunkSize(dst, 1)
+	assert.Equal(t, copyChunkSize, chunk)
+	chunk, _ = chunks.chunkSize(dst, 10<<30)
+	assert.Equal(t, copyChunkSize, chunk)
+	assert.Empty(t, chunks.stats())
+}
+
+func TestChunkSize_Auto(t *testing.T) {
+	setChunkAuto(t, true)
+	dst := filepath.Join(t.TempDir(), "f")
+
+	chunk, dev := chunks.chunkSize(dst, 100)
+	assert.Equal(t, minChunk, chunk, "tiny file")
+	chunk, _ = chunks.chunkSize(dst, 100<<10)
+	assert.Equal(t, 128<<10, chunk, "fits the file")
+	chunk, _ = chunks.chunkSize(dst, 10<<20)
+	assert.Equal(t, copyChunkSize, chunk, "never below copyChunkSize")
+	chunk, _ = chunks.chunkSize(dst, 2<<30)
+	assert.Equal(t, 2<<20, chunk, "large file, device not measured")
+
+	// 2 GB/s: 40 MB per 20ms, capped.
+	chunks.observe(dev, 2<<30, time.Second)
+	chunk, _ = chunks.chunkSize(dst, 2<<30)
+	assert.Equal(t, maxAutoChunk, chunk)
+
+	stats := chunks.stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, dev, stats[0].Device)
+	assert.InDelta(t, float64(2<<30), stats[0].Throughput, 1)
+	assert.Equal(t, maxAutoChunk, stats[0].ChunkSize)
+
+	// A slower device: 100 MB/s gives 2 MB per 20ms.
+	chunks.devices = make(map[uint64]*deviceRate)
+	chunks.observe(dev, 100e6, time.Second)
+	chunk, _ = chunks.chunkSize(dst, 2<<30)
+	assert.Equal(t, 2<<20, chunk)
+}
+
+func TestChunkSize_SmallCopiesNotMeasured(t *testing.T) {
+	setChunkAuto(t, true)
+	chunks.observe(1, minTuneSample-1, time.Nanosecond)
+	assert.Empty(t, chunks.stats())
+}
+
+func TestSafeCopy_AutoChunkMeasures(t *testing.T) {
+	setChunkAuto(t, true)
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.bin"), filepath.Join(dir, "out", "dst.bin")
+	data := make([]byte, 3<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	require.NoError(t, os.WriteFile(src, data, 0644))
+
+	require.NoError(t, SafeCopy(context.Background(), src, dst, nil))
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	stats := chunks.stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, destDevice(dst), stats[0].Device)
+	assert.Positive(t, stats[0].Throughput)
+}
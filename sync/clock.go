@@ -0,0 +1,31 @@
+package sync
+
+import "time"
+
+// Clock is the package's time source. Tests swap in a fake one that only
+// moves when told to, so they step through stability windows, holds and
+// maintenance intervals instead of sleeping through them.
+type Clock interface {
+	Now() time.Time
+	// After is time.After on this clock.
+	After(d time.Duration) <-chan time.Time
+}
+
+// wallClock is the real time.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time                         { return time.Now() }
+func (wallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock is the Clock in use, replaceable in tests.
+var clock Clock = wallClock{}
+
+// nowFunc returns the current time on clock.
+func nowFunc() time.Time {
+	return clock.Now()
+}
+
+// sinceNow is time.Since on clock.
+func sinceNow(t time.Time) time.Duration {
+	return nowFunc().Sub(t)
+}
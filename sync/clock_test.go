@@ -0,0 +1,102 @@
+package sync
+
+import (
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock that only moves on Set and Advance, firing the
+// After channels whose deadline it passes.
+type fakeClock struct {
+	mu      gosync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// useFakeClock makes a fakeClock reading now the package's Clock for the
+// rest of the test.
+func useFakeClock(t testing.TB, now time.Time) *fakeClock {
+	t.Helper()
+	c := &fakeClock{now: now}
+	orig := clock
+	clock = c
+	t.Cleanup(func() { clock = orig })
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now.
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(now) {
+			kept = append(kept, w)
+			continue
+		}
+		w.ch <- now
+	}
+	c.waiters = kept
+}
+
+// pending returns how many After channels are waiting, for tests waiting
+// on a goroutine to block on the clock.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func TestFakeClock_AfterFiresOnAdvance(t *testing.T) {
+	c := useFakeClock(t, time.Unix(1_700_000_000, 0))
+	ch := c.After(time.Minute)
+	assert.Equal(t, 1, c.pending())
+
+	c.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("fired early")
+	default:
+	}
+	c.Advance(time.Second)
+	select {
+	case at := <-ch:
+		assert.Equal(t, time.Unix(1_700_000_060, 0), at)
+	default:
+		t.Fatal("didn't fire")
+	}
+	assert.Zero(t, c.pending())
+	assert.Equal(t, time.Unix(1_700_000_060, 0), nowFunc())
+}
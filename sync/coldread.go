@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"io"
+	"os"
+)
+
+// dropCacheEvery is how much a DropCache copy reads between dropping it
+// from the page cache.
+const dropCacheEvery = 8 << 20
+
+// openSource opens src for copying, with the RootReads of its root when
+// it is on the local disk.
+func openSource(fs fileSystem, src string) (io.ReadCloser, error) {
+	reads := activeConfig.Load().rootReads(src)
+	if _, local := fs.(osFS); !local || reads == (RootReads{}) {
+		return fs.Open(src)
+	}
+	f, err := openLocal(src, reads.NoAtime)
+	if err != nil {
+		return nil, err
+	}
+	if !reads.DropCache {
+		return f, nil
+	}
+	return &uncachedReader{f: f}, nil
+}
+
+// uncachedReader reads a file, dropping what it has read from the page
+// cache every dropCacheEvery bytes and on close.
+type uncachedReader struct {
+	f             *os.File
+	read, dropped int64
+}
+
+func (r *uncachedReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.read += int64(n)
+	if r.read-r.dropped >= dropCacheEvery {
+		dropCache(r.f, r.dropped, r.read-r.dropped)
+		r.dropped = r.read
+	}
+	return n, err
+}
+
+func (r *uncachedReader) Close() error {
+	dropCache(r.f, 0, 0)
+	return r.f.Close()
+}
@@ -0,0 +1,26 @@
+package sync
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openLocal opens name for reading, with O_NOATIME if noAtime. Only the
+// file's owner may open it that way; other files are opened plainly.
+func openLocal(name string, noAtime bool) (*os.File, error) {
+	if noAtime {
+		f, err := os.OpenFile(name, os.O_RDONLY|unix.O_NOATIME, 0)
+		if !errors.Is(err, os.ErrPermission) {
+			return f, err
+		}
+	}
+	return os.Open(name)
+}
+
+// dropCache tells the kernel the n bytes of f from off won't be read
+// again, so it can drop them from the page cache. n 0 means to the end.
+func dropCache(f *os.File, off, n int64) {
+	unix.Fadvise(int(f.Fd()), off, n, unix.FADV_DONTNEED) //nolint:errcheck // advisory
+}
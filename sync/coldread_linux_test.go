@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeCopy_NoAtime(t *testing.T) {
+	archives := t.TempDir()
+	withConfig(t, func(c *Config) { c.ArchivesRoot, c.ArchivesReads = archives, RootReads{NoAtime: true} })
+	src := filepath.Join(archives, "a.bin")
+	require.NoError(t, os.WriteFile(src, make([]byte, 1<<20), 0644))
+	// Old enough that relatime would update it on a plain read.
+	atime := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(src, atime, atime))
+
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(t.TempDir(), "a.bin"), nil))
+	info, err := os.Stat(src)
+	require.NoError(t, err)
+	assert.Equal(t, atime.UnixNano(), accessTime(info))
+}
+
+func TestSafeCopy_DropCache(t *testing.T) {
+	archives := t.TempDir()
+	withConfig(t, func(c *Config) { c.ArchivesRoot, c.ArchivesReads = archives, RootReads{NoAtime: true, DropCache: true} })
+	src := filepath.Join(archives, "a.bin")
+	data := make([]byte, dropCacheEvery+12345)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	require.NoError(t, os.WriteFile(src, data, 0644))
+
+	for _, minMB := range []int{0, 1} {
+		cfg := currentConfig()
+		cfg.ParallelCopyMinMB = minMB
+		require.NoError(t, setConfig(cfg))
+		dst := filepath.Join(t.TempDir(), "a.bin")
+		require.NoError(t, SafeCopy(context.Background(), src, dst, nil), "parallelCopyMinMB %d", minMB)
+		got, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, data, got, "parallelCopyMinMB %d", minMB)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sync
+
+import "os"
+
+// openLocal opens name for reading. Access times are only left alone on
+// Linux.
+func openLocal(name string, noAtime bool) (*os.File, error) {
+	return os.Open(name)
+}
+
+// dropCache does nothing: the page cache is only advised on Linux.
+func dropCache(f *os.File, off, n int64) {}
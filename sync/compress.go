@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed Spaces copies keep their name and start with compressMagic,
+// followed by a zstd stream of the file. The marker tells them apart from
+// .zst files that are synced as they are.
+const (
+	compressMagic = "FBSZ\x01"
+
+	// compressZstd names the scheme in spaces_view.compression.
+	compressZstd = "zstd"
+)
+
+// compressReader reads src and returns the compressed file.
+type compressReader struct {
+	src  io.Reader
+	enc  *zstd.Encoder
+	buf  []byte
+	out  bytes.Buffer
+	done bool
+	err  error
+}
+
+func newCompressReader(src io.Reader) *compressReader {
+	r := &compressReader{src: src, buf: make([]byte, encryptChunkSize)}
+	r.out.WriteString(compressMagic)
+	// One goroutine-free encoder per copy; Close flushes the last frame
+	r.enc, r.err = zstd.NewWriter(&r.out, zstd.WithEncoderConcurrency(1))
+	return r
+}
+
+func (r *compressReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := r.src.Read(r.buf)
+		if n > 0 {
+			if _, werr := r.enc.Write(r.buf[:n]); werr != nil {
+				r.err = werr
+				continue
+			}
+		}
+		switch {
+		case err == io.EOF:
+			r.err = r.enc.Close()
+			r.done = true
+		case err != nil:
+			r.enc.Close() //nolint:errcheck
+			r.err = err
+		}
+	}
+	return r.out.Read(p)
+}
+
+// decompressReader reads the zstd stream following compressMagic and
+// returns the original file.
+type decompressReader struct {
+	dec *zstd.Decoder
+	err error
+}
+
+func newDecompressReader(src io.Reader) *decompressReader {
+	dec, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+	return &decompressReader{dec: dec, err: err}
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.dec.Read(p)
+	if err != nil {
+		r.dec.Close()
+		r.err = err
+	}
+	return n, err
+}
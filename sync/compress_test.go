@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress_RoundTrip(t *testing.T) {
+	random := make([]byte, 3*encryptChunkSize+7)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	for name, plain := range map[string][]byte{
+		"empty":  nil,
+		"text":   []byte(strings.Repeat("the quick brown fox\n", 20000)),
+		"random": random,
+	} {
+		packed, err := io.ReadAll(newCompressReader(bytes.NewReader(plain)))
+		require.NoError(t, err, name)
+		require.True(t, bytes.HasPrefix(packed, []byte(compressMagic)), name)
+		got, err := io.ReadAll(decodeSpaces(bytes.NewReader(packed)))
+		require.NoError(t, err, name)
+		assert.Equal(t, len(plain), len(got), name)
+		assert.True(t, bytes.Equal(plain, got), name)
+		if name == "text" {
+			assert.Less(t, len(packed), len(plain)/10, "text compresses")
+		}
+	}
+}
+
+func TestDecodeSpaces_PassesOtherFilesThrough(t *testing.T) {
+	// A .zst file synced as is isn't ours to decompress
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	raw := enc.EncodeAll([]byte("archive"), nil)
+	got, err := io.ReadAll(decodeSpaces(bytes.NewReader(raw)))
+	require.NoError(t, err)
+	assert.Equal(t, raw, got)
+
+	got, err = io.ReadAll(decodeSpaces(strings.NewReader("FBS")))
+	require.NoError(t, err)
+	assert.Equal(t, "FBS", string(got))
+}
+
+func TestPipeline_CompressRule(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"log"}, Compress: true}}
+	cfg.ContentMaxBytes = 64 << 10
+	require.NoError(t, setConfig(cfg))
+
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	text := strings.Repeat("GET /index.html 200\n", 5000)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "plain.txt"), []byte("plain"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "access.log"), []byte(text), 0644))
+	ctx := context.Background()
+	for _, p := range []string{"plain.txt", "access.log"} {
+		require.NoError(t, RunPipeline(ctx, p, store, archivesRoot, spacesRoot, "", nil))
+	}
+
+	spacesPath := filepath.Join(spacesRoot, "access.log")
+	packed, err := os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(packed, []byte(compressMagic)))
+	assert.Less(t, len(packed), len(text)/10)
+	entry := registered(t, store, archivesRoot, "access.log")
+	sv, err := store.GetSpacesView(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Equal(t, compressZstd, sv.Compression)
+	assert.Empty(t, sv.Cipher)
+
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "plain.txt"), "no rule selects it")
+	assert.Equal(t, int64(len(text)), *entry.Size, "Archives keeps the original")
+	w := httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/"+strconv.FormatUint(entry.Inode, 10), nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "the limit applies to the original size")
+
+	// A compressed edit in Spaces reaches Archives decompressed
+	edited, err := io.ReadAll(newCompressReader(strings.NewReader("rotated\n")))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(spacesPath, edited, 0644))
+	later := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(spacesPath, later, later))
+	require.NoError(t, RunPipeline(ctx, "access.log", store, archivesRoot, spacesRoot, "", nil))
+	got, err := os.ReadFile(filepath.Join(archivesRoot, "access.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "rotated\n", string(got))
+
+	w = httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/"+strconv.FormatUint(registered(t, store, archivesRoot, "access.log").Inode, 10), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "rotated\n", w.Body.String())
+}
+
+func TestPipeline_CompressAndEncrypt(t *testing.T) {
+	key := enableEncryption(t)
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"csv"}, Compress: true}}
+	require.NoError(t, setConfig(cfg))
+
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	text := strings.Repeat("1,2,3,4\n", 10000)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "data.csv"), []byte(text), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "data.csv", store, archivesRoot, spacesRoot, "", nil))
+
+	sealed, err := os.ReadFile(filepath.Join(spacesRoot, "data.csv"))
+	require.NoError(t, err)
+	assert.Less(t, len(sealed), len(text)/10, "compressed before encrypting")
+	sv, err := store.GetSpacesView(registered(t, store, archivesRoot, "data.csv").Inode)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Equal(t, key.Cipher(), sv.Cipher)
+	assert.Equal(t, compressZstd, sv.Compression)
+
+	got, err := io.ReadAll(decodeSpaces(bytes.NewReader(sealed)))
+	require.NoError(t, err)
+	assert.Equal(t, text, string(got))
+}
@@ -0,0 +1,738 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to every environment override, matching the
+// FB_ prefix used by the rest of filebrowser's configuration.
+const envPrefix = "FB_SYNC_"
+
+// Config holds all tunable settings of the sync subsystem.
+// Loaded from an optional YAML/TOML file, then overridden by FB_SYNC_* env vars.
+type Config struct {
+	ArchivesRoot  string     `json:"archivesRoot" yaml:"archivesRoot" toml:"archivesRoot"`
+	SpacesRoot    string     `json:"spacesRoot" yaml:"spacesRoot" toml:"spacesRoot"`
+	TrashRoot     string     `json:"trashRoot" yaml:"trashRoot" toml:"trashRoot"` // default: <spaces parent>/.trash
+	DebounceMs    int        `json:"debounceMs" yaml:"debounceMs" toml:"debounceMs"`
+	CopyChunkSize int        `json:"copyChunkSize" yaml:"copyChunkSize" toml:"copyChunkSize"` // bytes
+	CopyChunkAuto bool       `json:"copyChunkAuto" yaml:"copyChunkAuto" toml:"copyChunkAuto"` // size chunks per copy from file size and measured throughput
+	Workers       int        `json:"workers" yaml:"workers" toml:"workers"`
+	QueueOrder    QueueOrder `json:"queueOrder" yaml:"queueOrder" toml:"queueOrder"` // fifo|small-first|large-first
+
+	ParallelCopyMinMB   int `json:"parallelCopyMinMB" yaml:"parallelCopyMinMB" toml:"parallelCopyMinMB"`       // copy local files this large (MiB) as parallel ranges, 0 = off
+	ParallelCopyStreams int `json:"parallelCopyStreams" yaml:"parallelCopyStreams" toml:"parallelCopyStreams"` // ranges copied at once
+
+	MaxAutoCopyMB int `json:"maxAutoCopyMB" yaml:"maxAutoCopyMB" toml:"maxAutoCopyMB"` // selected files larger than this (MiB) are copied into Spaces only once confirmed, 0 = no limit
+
+	TreeMaxDepth   int `json:"treeMaxDepth" yaml:"treeMaxDepth" toml:"treeMaxDepth"`       // directory levels a tree walk descends
+	TreeMaxEntries int `json:"treeMaxEntries" yaml:"treeMaxEntries" toml:"treeMaxEntries"` // entries one tree walk visits, 0 = unlimited
+
+	ErrorBufferSize int  `json:"errorBufferSize" yaml:"errorBufferSize" toml:"errorBufferSize"` // recent-errors ring capacity
+	ErrorBufferWarn bool `json:"errorBufferWarn" yaml:"errorBufferWarn" toml:"errorBufferWarn"` // also capture WARN records
+
+	IOStatsDays int `json:"ioStatsDays" yaml:"ioStatsDays" toml:"ioStatsDays"` // days of per-day copy counters kept
+
+	TombstoneDays int `json:"tombstoneDays" yaml:"tombstoneDays" toml:"tombstoneDays"` // days lost entries are kept as tombstones, 0 = purge at once
+
+	Rules []AutoSelectRule `json:"rules" yaml:"rules" toml:"rules"` // auto-select rules, first match wins
+
+	AutoArchiveDays   int      `json:"autoArchiveDays" yaml:"autoArchiveDays" toml:"autoArchiveDays"`       // deselect files idle for N days, 0 = off
+	AutoArchiveOptOut []string `json:"autoArchiveOptOut" yaml:"autoArchiveOptOut" toml:"autoArchiveOptOut"` // directories never auto-archived
+
+	DeselectGraceMinutes int `json:"deselectGraceMinutes" yaml:"deselectGraceMinutes" toml:"deselectGraceMinutes"` // keep deselected Spaces copies this long before trashing them, 0 = trash at once
+
+	Metadata bool `json:"metadata" yaml:"metadata" toml:"metadata"` // extract image/video/audio metadata
+
+	ContentIndex bool `json:"contentIndex" yaml:"contentIndex" toml:"contentIndex"` // full-text index synced text, code and PDF files
+
+	Types map[string]string `json:"types" yaml:"types" toml:"types"` // extension → category overrides
+
+	DownloadMaxBytes int64 `json:"downloadMaxBytes" yaml:"downloadMaxBytes" toml:"downloadMaxBytes"` // bundle download limit, 0 = unlimited
+	UploadMaxBytes   int64 `json:"uploadMaxBytes" yaml:"uploadMaxBytes" toml:"uploadMaxBytes"`       // upload request limit, 0 = unlimited
+	ContentMaxBytes  int64 `json:"contentMaxBytes" yaml:"contentMaxBytes" toml:"contentMaxBytes"`    // inline content read/write limit, 0 = unlimited
+
+	BackupTargets []string `json:"backupTargets" yaml:"backupTargets" toml:"backupTargets"` // directories, such as drive mount points, backups may write under; none = backups off
+
+	LazyRegistration bool `json:"lazyRegistration" yaml:"lazyRegistration" toml:"lazyRegistration"` // register Archives directories when browsed or selected
+
+	WatchScoped        bool     `json:"watchScoped" yaml:"watchScoped" toml:"watchScoped"`                      // watch only selected, active and pinned Archives directories
+	WatchPins          []string `json:"watchPins" yaml:"watchPins" toml:"watchPins"`                            // Archives subtrees always watched in scoped mode
+	WatchActiveMinutes int      `json:"watchActiveMinutes" yaml:"watchActiveMinutes" toml:"watchActiveMinutes"` // how long a browsed directory stays watched
+	WatchScanSeconds   int      `json:"watchScanSeconds" yaml:"watchScanSeconds" toml:"watchScanSeconds"`       // periodic scan of unwatched directories, 0 = off
+	WatchProbeSeconds  int      `json:"watchProbeSeconds" yaml:"watchProbeSeconds" toml:"watchProbeSeconds"`    // how often each watched root is probed for lost events, 0 = off
+
+	WatchBackend WatchBackend `json:"watchBackend" yaml:"watchBackend" toml:"watchBackend"` // fsnotify|fanotify
+
+	StableMs       int  `json:"stableMs" yaml:"stableMs" toml:"stableMs"`                   // copy only files unmodified for this long, 0 = off
+	OpenWriteCheck bool `json:"openWriteCheck" yaml:"openWriteCheck" toml:"openWriteCheck"` // don't copy files another process has open for writing (Linux)
+
+	LowPower            bool `json:"lowPower" yaml:"lowPower" toml:"lowPower"`                                  // stretch timers and defer background work while no user is active
+	LowPowerIdleMinutes int  `json:"lowPowerIdleMinutes" yaml:"lowPowerIdleMinutes" toml:"lowPowerIdleMinutes"` // minutes without a user action before the host counts as idle
+
+	IgnorePatterns []string `json:"ignorePatterns" yaml:"ignorePatterns" toml:"ignorePatterns"` // extra name globs skipped by watcher and scanner
+
+	Placeholders bool `json:"placeholders" yaml:"placeholders" toml:"placeholders"` // selected files start as stubs hydrated on first open (Linux, fanotify)
+
+	SpacesRemote            string `json:"spacesRemote" yaml:"spacesRemote" toml:"spacesRemote"`                                  // user@host[:port]: spacesRoot and trashRoot are on that host, over SSH
+	SpacesRemoteKey         string `json:"spacesRemoteKey" yaml:"spacesRemoteKey" toml:"spacesRemoteKey"`                         // SSH private key file
+	SpacesRemoteKnownHosts  string `json:"spacesRemoteKnownHosts" yaml:"spacesRemoteKnownHosts" toml:"spacesRemoteKnownHosts"`    // known_hosts file verifying the remote
+	SpacesRemotePool        int    `json:"spacesRemotePool" yaml:"spacesRemotePool" toml:"spacesRemotePool"`                      // SSH connections kept to the remote
+	SpacesRemoteScanSeconds int    `json:"spacesRemoteScanSeconds" yaml:"spacesRemoteScanSeconds" toml:"spacesRemoteScanSeconds"` // how often remote Spaces is scanned for changes
+
+	SpacesEncryptionKey string `json:"spacesEncryptionKey" yaml:"spacesEncryptionKey" toml:"spacesEncryptionKey"` // file holding a hex 32-byte key; set to store Spaces copies encrypted
+
+	SpacesBlockStore string `json:"spacesBlockStore" yaml:"spacesBlockStore" toml:"spacesBlockStore"` // chunk directory outside spacesRoot; set to keep Spaces as manifests over deduplicated chunks
+
+	SpokeHub         string `json:"spokeHub" yaml:"spokeHub" toml:"spokeHub"`                         // hub sync API URL; set to run as a spoke of that hub
+	SpokeToken       string `json:"spokeToken" yaml:"spokeToken" toml:"spokeToken"`                   // token from registering this spoke with the hub
+	SpokeRoot        string `json:"spokeRoot" yaml:"spokeRoot" toml:"spokeRoot"`                      // local directory holding this spoke's selection
+	SpokeSyncSeconds int    `json:"spokeSyncSeconds" yaml:"spokeSyncSeconds" toml:"spokeSyncSeconds"` // how often the spoke syncs with the hub
+
+	Syncthing               bool   `json:"syncthing" yaml:"syncthing" toml:"syncthing"`                                           // Spaces is a Syncthing folder: ingest its conflict copies, honour .stignore
+	SyncthingURL            string `json:"syncthingUrl" yaml:"syncthingUrl" toml:"syncthingUrl"`                                  // Syncthing REST API, e.g. http://127.0.0.1:8384; set to pause the folder during bulk work
+	SyncthingAPIKey         string `json:"syncthingApiKey" yaml:"syncthingApiKey" toml:"syncthingApiKey"`                         // Syncthing API key
+	SyncthingFolder         string `json:"syncthingFolder" yaml:"syncthingFolder" toml:"syncthingFolder"`                         // Syncthing folder ID of Spaces
+	SyncthingPauseThreshold int    `json:"syncthingPauseThreshold" yaml:"syncthingPauseThreshold" toml:"syncthingPauseThreshold"` // queued paths that count as bulk work
+
+	OTLPEndpoint       string `json:"otlpEndpoint" yaml:"otlpEndpoint" toml:"otlpEndpoint"`                   // OTLP/HTTP collector host:port; set to export trace spans
+	OTLPInsecure       bool   `json:"otlpInsecure" yaml:"otlpInsecure" toml:"otlpInsecure"`                   // send spans over plain HTTP
+	TraceSamplePercent int    `json:"traceSamplePercent" yaml:"traceSamplePercent" toml:"traceSamplePercent"` // share of new traces recorded
+
+	DecisionLog string `json:"decisionLog" yaml:"decisionLog" toml:"decisionLog"` // rotating NDJSON file of one record per pipeline run, empty = off
+
+	ScanCommand        string `json:"scanCommand" yaml:"scanCommand" toml:"scanCommand"`                      // command scanning Spaces files (content on stdin) before they are copied into Archives
+	ScanClamd          string `json:"scanClamd" yaml:"scanClamd" toml:"scanClamd"`                            // clamd socket path or host:port to scan them with instead
+	ScanTimeoutSeconds int    `json:"scanTimeoutSeconds" yaml:"scanTimeoutSeconds" toml:"scanTimeoutSeconds"` // how long one scan may take
+
+	ArchivesQueue RootQueue `json:"archivesQueue" yaml:"archivesQueue" toml:"archivesQueue"` // watcher batching for Archives events
+	SpacesQueue   RootQueue `json:"spacesQueue" yaml:"spacesQueue" toml:"spacesQueue"`       // watcher batching for Spaces events
+
+	ArchivesReads RootReads `json:"archivesReads" yaml:"archivesReads" toml:"archivesReads"` // how copies read local Archives files
+	SpacesReads   RootReads `json:"spacesReads" yaml:"spacesReads" toml:"spacesReads"`       // how copies read local Spaces files
+
+	ArchivesXattrs RootXattrs `json:"archivesXattrs" yaml:"archivesXattrs" toml:"archivesXattrs"` // attributes copies into Archives carry over
+	SpacesXattrs   RootXattrs `json:"spacesXattrs" yaml:"spacesXattrs" toml:"spacesXattrs"`       // attributes copies into Spaces carry over
+
+	SpacesOwner SpacesOwner `json:"spacesOwner" yaml:"spacesOwner" toml:"spacesOwner"` // who owns the files copied and restored into Spaces
+
+	Hooks []Hook `json:"hooks" yaml:"hooks" toml:"hooks"` // commands and URLs told about P2 and P3 copies and trash moves
+
+	NewFiles []NewFileRule `json:"newFiles" yaml:"newFiles" toml:"newFiles"` // what becomes of files created in Spaces, by directory; archive when none match
+
+	Anomaly AnomalyAlerts `json:"anomaly" yaml:"anomaly" toml:"anomaly"` // alert, and optionally pause, on bursts of Spaces changes
+
+	Verify CopyVerify `json:"verify" yaml:"verify" toml:"verify"` // read back a sample of copies and compare them with their source
+}
+
+// RootQueue tunes how one root's watcher events reach the eval queue.
+type RootQueue struct {
+	DebounceMs int  `json:"debounceMs" yaml:"debounceMs" toml:"debounceMs"` // 0 = Config.DebounceMs
+	FlushBatch int  `json:"flushBatch" yaml:"flushBatch" toml:"flushBatch"` // flush once this many paths are pending, 0 = debounce only
+	Promote    bool `json:"promote" yaml:"promote" toml:"promote"`          // pop this root's paths before other queued work
+}
+
+// RootReads tunes how SafeCopy reads the local files of one root, to keep
+// bulk copies from spinning up or crowding out everything else. Both only
+// take effect on Linux.
+type RootReads struct {
+	NoAtime   bool `json:"noAtime" yaml:"noAtime" toml:"noAtime"`       // open with O_NOATIME, so copies don't update access times
+	DropCache bool `json:"dropCache" yaml:"dropCache" toml:"dropCache"` // drop what a copy read from the page cache as it goes
+}
+
+// RootXattrs picks the extended attributes SafeCopy carries over to the
+// local files it writes into one root. Both are off by default, since
+// not every file system takes them, and only take effect on Linux.
+type RootXattrs struct {
+	ACLs     bool `json:"acls" yaml:"acls" toml:"acls"`             // POSIX access and default ACLs
+	Security bool `json:"security" yaml:"security" toml:"security"` // security.* attributes: SELinux context, capabilities
+}
+
+// DefaultConfig returns the built-in defaults.
+func DefaultConfig() Config {
+	return Config{
+		DebounceMs:    int(debounceInterval / time.Millisecond),
+		CopyChunkSize: copyChunkSize,
+		Workers:       1,
+		QueueOrder:    OrderFIFO,
+
+		ParallelCopyStreams: 4,
+
+		TreeMaxDepth: 128,
+
+		ErrorBufferSize: 200,
+
+		IOStatsDays: 90,
+
+		TombstoneDays: 30,
+
+		DownloadMaxBytes: 16 << 30,
+		UploadMaxBytes:   16 << 30,
+		ContentMaxBytes:  1 << 20,
+
+		WatchActiveMinutes: 60,
+		WatchScanSeconds:   900,
+		WatchProbeSeconds:  300,
+
+		WatchBackend: BackendFsnotify,
+
+		OpenWriteCheck: true,
+
+		LowPowerIdleMinutes: 10,
+
+		SpacesRemotePool:        2,
+		SpacesRemoteScanSeconds: 60,
+
+		SpokeSyncSeconds: 60,
+
+		SyncthingPauseThreshold: 200,
+
+		TraceSamplePercent: 100,
+
+		ScanTimeoutSeconds: 300,
+
+		SpacesOwner: SpacesOwner{Policy: OwnerDaemon},
+	}
+}
+
+// Debounce returns the watcher debounce interval.
+func (c Config) Debounce() time.Duration {
+	return time.Duration(c.DebounceMs) * time.Millisecond
+}
+
+func (q RootQueue) validate() error {
+	if q.DebounceMs != 0 && (q.DebounceMs < 10 || q.DebounceMs > 60_000) {
+		return fmt.Errorf("debounceMs must be 0 or between 10 and 60000, got %d", q.DebounceMs)
+	}
+	if q.FlushBatch < 0 {
+		return fmt.Errorf("flushBatch must not be negative, got %d", q.FlushBatch)
+	}
+	return nil
+}
+
+// rootQueue returns the watcher settings for Spaces or Archives events
+// and their effective debounce interval.
+func (c *Config) rootQueue(spaces bool) (RootQueue, time.Duration) {
+	rq := c.ArchivesQueue
+	if spaces {
+		rq = c.SpacesQueue
+	}
+	if rq.DebounceMs == 0 {
+		return rq, c.Debounce()
+	}
+	return rq, time.Duration(rq.DebounceMs) * time.Millisecond
+}
+
+// underRoot reports whether path is root or below it. An unset root
+// holds nothing.
+func underRoot(path, root string) bool {
+	if root == "" {
+		return false
+	}
+	root = filepath.Clean(root)
+	return root != "." && (path == root || strings.HasPrefix(path, root+string(filepath.Separator)))
+}
+
+// rootReads returns the read settings for path's root.
+func (c *Config) rootReads(path string) RootReads {
+	switch {
+	case underRoot(path, c.ArchivesRoot):
+		return c.ArchivesReads
+	case underRoot(path, c.SpacesRoot):
+		return c.SpacesReads
+	}
+	return RootReads{}
+}
+
+// rootXattrs returns the attributes carried over to copies into path's
+// root.
+func (c *Config) rootXattrs(path string) RootXattrs {
+	switch {
+	case underRoot(path, c.ArchivesRoot):
+		return c.ArchivesXattrs
+	case underRoot(path, c.SpacesRoot):
+		return c.SpacesXattrs
+	}
+	return RootXattrs{}
+}
+
+// ResolvedTrashRoot returns TrashRoot, or the default next to SpacesRoot.
+func (c Config) ResolvedTrashRoot() string {
+	if c.TrashRoot != "" {
+		return c.TrashRoot
+	}
+	return filepath.Join(filepath.Dir(c.SpacesRoot), ".trash")
+}
+
+// Validate checks that all values are within acceptable bounds.
+func (c Config) Validate() error {
+	if c.DebounceMs < 10 || c.DebounceMs > 60_000 {
+		return fmt.Errorf("debounceMs must be between 10 and 60000, got %d", c.DebounceMs)
+	}
+	if err := c.ArchivesQueue.validate(); err != nil {
+		return fmt.Errorf("archivesQueue: %w", err)
+	}
+	if err := c.SpacesQueue.validate(); err != nil {
+		return fmt.Errorf("spacesQueue: %w", err)
+	}
+	if c.CopyChunkSize < 4*1024 || c.CopyChunkSize > 64*1024*1024 {
+		return fmt.Errorf("copyChunkSize must be between 4KiB and 64MiB, got %d", c.CopyChunkSize)
+	}
+	if c.ParallelCopyMinMB < 0 {
+		return fmt.Errorf("parallelCopyMinMB must not be negative, got %d", c.ParallelCopyMinMB)
+	}
+	if c.MaxAutoCopyMB < 0 {
+		return fmt.Errorf("maxAutoCopyMB must not be negative, got %d", c.MaxAutoCopyMB)
+	}
+	if c.ParallelCopyStreams < 1 || c.ParallelCopyStreams > 64 {
+		return fmt.Errorf("parallelCopyStreams must be between 1 and 64, got %d", c.ParallelCopyStreams)
+	}
+	if c.TreeMaxDepth < 1 || c.TreeMaxDepth > 4096 {
+		return fmt.Errorf("treeMaxDepth must be between 1 and 4096, got %d", c.TreeMaxDepth)
+	}
+	if c.TreeMaxEntries < 0 {
+		return fmt.Errorf("treeMaxEntries must not be negative, got %d", c.TreeMaxEntries)
+	}
+	if c.Workers < 1 || c.Workers > 64 {
+		return fmt.Errorf("workers must be between 1 and 64, got %d", c.Workers)
+	}
+	if !c.QueueOrder.valid() {
+		return fmt.Errorf("queueOrder must be one of fifo, small-first, large-first, got %q", c.QueueOrder)
+	}
+	if c.ErrorBufferSize < 0 || c.ErrorBufferSize > 100_000 {
+		return fmt.Errorf("errorBufferSize must be between 0 and 100000, got %d", c.ErrorBufferSize)
+	}
+	if c.IOStatsDays < 1 || c.IOStatsDays > 3650 {
+		return fmt.Errorf("ioStatsDays must be between 1 and 3650, got %d", c.IOStatsDays)
+	}
+	if c.TombstoneDays < 0 {
+		return fmt.Errorf("tombstoneDays must not be negative, got %d", c.TombstoneDays)
+	}
+	if c.AutoArchiveDays < 0 {
+		return fmt.Errorf("autoArchiveDays must not be negative, got %d", c.AutoArchiveDays)
+	}
+	if c.DeselectGraceMinutes < 0 || c.DeselectGraceMinutes > 10080 {
+		return fmt.Errorf("deselectGraceMinutes must be between 0 and 10080, got %d", c.DeselectGraceMinutes)
+	}
+	if !c.WatchBackend.valid() {
+		return fmt.Errorf("watchBackend must be one of fsnotify, fanotify, got %q", c.WatchBackend)
+	}
+	if c.StableMs < 0 || c.StableMs > 600_000 {
+		return fmt.Errorf("stableMs must be between 0 and 600000, got %d", c.StableMs)
+	}
+	if c.LowPowerIdleMinutes < 1 {
+		return fmt.Errorf("lowPowerIdleMinutes must be at least 1, got %d", c.LowPowerIdleMinutes)
+	}
+	if c.WatchActiveMinutes < 0 || c.WatchScanSeconds < 0 || c.WatchProbeSeconds < 0 {
+		return fmt.Errorf("watchActiveMinutes, watchScanSeconds and watchProbeSeconds must not be negative")
+	}
+	if c.DownloadMaxBytes < 0 || c.UploadMaxBytes < 0 || c.ContentMaxBytes < 0 {
+		return fmt.Errorf("downloadMaxBytes, uploadMaxBytes and contentMaxBytes must not be negative")
+	}
+	if c.SpacesRemote != "" {
+		if c.SpacesRemoteKey == "" || c.SpacesRemoteKnownHosts == "" {
+			return fmt.Errorf("spacesRemote needs spacesRemoteKey and spacesRemoteKnownHosts")
+		}
+		if c.Placeholders {
+			return fmt.Errorf("placeholders need a local Spaces, not spacesRemote")
+		}
+	}
+	if c.SpacesEncryptionKey != "" && c.Placeholders {
+		return fmt.Errorf("placeholders can't be used with spacesEncryptionKey")
+	}
+	if c.SpacesBlockStore != "" {
+		switch {
+		case c.SpacesRemote != "":
+			return fmt.Errorf("spacesBlockStore needs a local Spaces, not spacesRemote")
+		case c.Placeholders || c.Syncthing:
+			return fmt.Errorf("placeholders and syncthing need plain files in Spaces, not spacesBlockStore")
+		case c.SpacesEncryptionKey != "":
+			return fmt.Errorf("spacesBlockStore can't deduplicate copies encrypted with spacesEncryptionKey")
+		case c.SpacesRoot != "" && strings.HasPrefix(filepath.Clean(c.SpacesBlockStore)+string(filepath.Separator), filepath.Clean(c.SpacesRoot)+string(filepath.Separator)):
+			return fmt.Errorf("spacesBlockStore must be outside spacesRoot")
+		}
+	}
+	if c.SpacesRemotePool < 1 || c.SpacesRemotePool > 16 {
+		return fmt.Errorf("spacesRemotePool must be between 1 and 16, got %d", c.SpacesRemotePool)
+	}
+	if c.SpacesRemoteScanSeconds < 5 {
+		return fmt.Errorf("spacesRemoteScanSeconds must be at least 5, got %d", c.SpacesRemoteScanSeconds)
+	}
+	if c.SpokeHub != "" && (c.SpokeToken == "" || c.SpokeRoot == "") {
+		return fmt.Errorf("spokeHub needs spokeToken and spokeRoot")
+	}
+	if c.SpokeSyncSeconds < 5 {
+		return fmt.Errorf("spokeSyncSeconds must be at least 5, got %d", c.SpokeSyncSeconds)
+	}
+	if c.SyncthingURL != "" && (c.SyncthingAPIKey == "" || c.SyncthingFolder == "") {
+		return fmt.Errorf("syncthingUrl needs syncthingApiKey and syncthingFolder")
+	}
+	if c.SyncthingPauseThreshold < 1 {
+		return fmt.Errorf("syncthingPauseThreshold must be at least 1, got %d", c.SyncthingPauseThreshold)
+	}
+	if c.TraceSamplePercent < 0 || c.TraceSamplePercent > 100 {
+		return fmt.Errorf("traceSamplePercent must be between 0 and 100, got %d", c.TraceSamplePercent)
+	}
+	if c.ScanCommand != "" && c.ScanClamd != "" {
+		return fmt.Errorf("set scanCommand or scanClamd, not both")
+	}
+	if c.ScanTimeoutSeconds < 1 || c.ScanTimeoutSeconds > 3600 {
+		return fmt.Errorf("scanTimeoutSeconds must be between 1 and 3600, got %d", c.ScanTimeoutSeconds)
+	}
+	if err := c.SpacesOwner.validate(); err != nil {
+		return fmt.Errorf("spacesOwner: %w", err)
+	}
+	if err := c.Anomaly.validate(); err != nil {
+		return fmt.Errorf("anomaly: %w", err)
+	}
+	if err := c.Verify.validate(); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	for i, h := range c.Hooks {
+		if err := h.validate(); err != nil {
+			return fmt.Errorf("hooks[%d]: %w", i, err)
+		}
+	}
+	for i, r := range c.NewFiles {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("newFiles[%d]: %w", i, err)
+		}
+	}
+	for i, t := range c.BackupTargets {
+		if !filepath.IsAbs(t) {
+			return fmt.Errorf("backupTargets[%d]: must be an absolute path, got %q", i, t)
+		}
+	}
+	if err := validateIgnorePatterns(c.IgnorePatterns); err != nil {
+		return fmt.Errorf("ignorePatterns: %w", err)
+	}
+	if err := validateTypes(c.Types); err != nil {
+		return fmt.Errorf("types: %w", err)
+	}
+	for i, r := range c.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfig overlays the config file at path (if non-empty) and FB_SYNC_*
+// environment variables onto base, then validates the result.
+// The file format is chosen by extension: .toml, otherwise YAML (JSON is valid YAML).
+func LoadConfig(path string, base Config) (Config, error) {
+	l := sub("config")
+	cfg := base
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config: %w", err)
+		}
+		if strings.EqualFold(filepath.Ext(path), ".toml") {
+			err = toml.Unmarshal(data, &cfg)
+		} else {
+			err = yaml.Unmarshal(data, &cfg)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("parse config %s: %w", path, err)
+		}
+		l.Info("config file loaded", "path", path)
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides cfg fields from FB_SYNC_* environment variables.
+func applyEnv(cfg *Config) error {
+	strs := map[string]*string{
+		"ARCHIVES_ROOT": &cfg.ArchivesRoot,
+		"SPACES_ROOT":   &cfg.SpacesRoot,
+		"TRASH_ROOT":    &cfg.TrashRoot,
+
+		"SPACES_REMOTE":             &cfg.SpacesRemote,
+		"SPACES_REMOTE_KEY":         &cfg.SpacesRemoteKey,
+		"SPACES_REMOTE_KNOWN_HOSTS": &cfg.SpacesRemoteKnownHosts,
+		"SPACES_ENCRYPTION_KEY":     &cfg.SpacesEncryptionKey,
+		"SPACES_BLOCK_STORE":        &cfg.SpacesBlockStore,
+
+		"SPOKE_HUB":   &cfg.SpokeHub,
+		"SPOKE_TOKEN": &cfg.SpokeToken,
+		"SPOKE_ROOT":  &cfg.SpokeRoot,
+
+		"SYNCTHING_URL":     &cfg.SyncthingURL,
+		"SYNCTHING_API_KEY": &cfg.SyncthingAPIKey,
+		"SYNCTHING_FOLDER":  &cfg.SyncthingFolder,
+
+		"OTLP_ENDPOINT": &cfg.OTLPEndpoint,
+
+		"DECISION_LOG": &cfg.DecisionLog,
+
+		"SCAN_COMMAND": &cfg.ScanCommand,
+		"SCAN_CLAMD":   &cfg.ScanClamd,
+
+		"ANOMALY_URL": &cfg.Anomaly.URL,
+	}
+	for key, dst := range strs {
+		if v, ok := os.LookupEnv(envPrefix + key); ok {
+			*dst = v
+		}
+	}
+	if v, ok := os.LookupEnv(envPrefix + "QUEUE_ORDER"); ok {
+		cfg.QueueOrder = QueueOrder(v)
+	}
+	if v, ok := os.LookupEnv(envPrefix + "WATCH_BACKEND"); ok {
+		cfg.WatchBackend = WatchBackend(v)
+	}
+	if v, ok := os.LookupEnv(envPrefix + "SPACES_OWNER"); ok {
+		cfg.SpacesOwner.Policy = OwnerPolicy(v)
+	}
+
+	ints := map[string]*int{
+		"DEBOUNCE_MS":         &cfg.DebounceMs,
+		"COPY_CHUNK_SIZE":     &cfg.CopyChunkSize,
+		"WORKERS":             &cfg.Workers,
+		"ERROR_BUFFER":        &cfg.ErrorBufferSize,
+		"AUTO_ARCHIVE_DAYS":   &cfg.AutoArchiveDays,
+		"IO_STATS_DAYS":       &cfg.IOStatsDays,
+		"TOMBSTONE_DAYS":      &cfg.TombstoneDays,
+		"WATCH_SCAN_SECONDS":  &cfg.WatchScanSeconds,
+		"WATCH_PROBE_SECONDS": &cfg.WatchProbeSeconds,
+		"STABLE_MS":           &cfg.StableMs,
+
+		"PARALLEL_COPY_MIN_MB":  &cfg.ParallelCopyMinMB,
+		"MAX_AUTO_COPY_MB":      &cfg.MaxAutoCopyMB,
+		"PARALLEL_COPY_STREAMS": &cfg.ParallelCopyStreams,
+		"TREE_MAX_DEPTH":        &cfg.TreeMaxDepth,
+		"TREE_MAX_ENTRIES":      &cfg.TreeMaxEntries,
+
+		"LOW_POWER_IDLE_MINUTES": &cfg.LowPowerIdleMinutes,
+		"DESELECT_GRACE_MINUTES": &cfg.DeselectGraceMinutes,
+
+		"SPACES_REMOTE_POOL":         &cfg.SpacesRemotePool,
+		"SPACES_REMOTE_SCAN_SECONDS": &cfg.SpacesRemoteScanSeconds,
+		"SPOKE_SYNC_SECONDS":         &cfg.SpokeSyncSeconds,
+		"SYNCTHING_PAUSE_THRESHOLD":  &cfg.SyncthingPauseThreshold,
+
+		"ARCHIVES_DEBOUNCE_MS": &cfg.ArchivesQueue.DebounceMs,
+		"ARCHIVES_FLUSH_BATCH": &cfg.ArchivesQueue.FlushBatch,
+		"SPACES_DEBOUNCE_MS":   &cfg.SpacesQueue.DebounceMs,
+		"SPACES_FLUSH_BATCH":   &cfg.SpacesQueue.FlushBatch,
+
+		"TRACE_SAMPLE_PERCENT": &cfg.TraceSamplePercent,
+		"SCAN_TIMEOUT_SECONDS": &cfg.ScanTimeoutSeconds,
+
+		"SPACES_OWNER_UID": &cfg.SpacesOwner.UID,
+		"SPACES_OWNER_GID": &cfg.SpacesOwner.GID,
+
+		"ANOMALY_SPACES_CHANGES_PER_MINUTE": &cfg.Anomaly.SpacesChangesPerMinute,
+
+		"VERIFY_PERCENT": &cfg.Verify.Percent,
+		"VERIFY_MIN_MB":  &cfg.Verify.MinMB,
+	}
+	for key, dst := range ints {
+		v, ok := os.LookupEnv(envPrefix + key)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("env %s%s: %w", envPrefix, key, err)
+		}
+		*dst = n
+	}
+
+	bools := map[string]*bool{
+		"COPY_CHUNK_AUTO":   &cfg.CopyChunkAuto,
+		"ERROR_BUFFER_WARN": &cfg.ErrorBufferWarn,
+		"METADATA":          &cfg.Metadata,
+		"LAZY_REGISTRATION": &cfg.LazyRegistration,
+		"WATCH_SCOPED":      &cfg.WatchScoped,
+		"ARCHIVES_PROMOTE":  &cfg.ArchivesQueue.Promote,
+		"SPACES_PROMOTE":    &cfg.SpacesQueue.Promote,
+		"OPEN_WRITE_CHECK":  &cfg.OpenWriteCheck,
+		"LOW_POWER":         &cfg.LowPower,
+		"PLACEHOLDERS":      &cfg.Placeholders,
+		"SYNCTHING":         &cfg.Syncthing,
+		"OTLP_INSECURE":     &cfg.OTLPInsecure,
+
+		"CONTENT_INDEX": &cfg.ContentIndex,
+
+		"ANOMALY_AUTO_PAUSE": &cfg.Anomaly.AutoPause,
+
+		"ARCHIVES_NOATIME":    &cfg.ArchivesReads.NoAtime,
+		"ARCHIVES_DROP_CACHE": &cfg.ArchivesReads.DropCache,
+		"SPACES_NOATIME":      &cfg.SpacesReads.NoAtime,
+		"SPACES_DROP_CACHE":   &cfg.SpacesReads.DropCache,
+
+		"ARCHIVES_XATTR_ACLS":     &cfg.ArchivesXattrs.ACLs,
+		"ARCHIVES_XATTR_SECURITY": &cfg.ArchivesXattrs.Security,
+		"SPACES_XATTR_ACLS":       &cfg.SpacesXattrs.ACLs,
+		"SPACES_XATTR_SECURITY":   &cfg.SpacesXattrs.Security,
+	}
+	for key, dst := range bools {
+		v, ok := os.LookupEnv(envPrefix + key)
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("env %s%s: %w", envPrefix, key, err)
+		}
+		*dst = b
+	}
+	return nil
+}
+
+// activeConfig is the config currently in effect, read by the watcher and
+// SafeCopy on every use so runtime changes apply without a restart. Hot
+// paths read it through Load, without copying; what it points to is never
+// modified, only replaced by setConfig.
+var activeConfig atomic.Pointer[Config]
+
+func init() {
+	cfg := DefaultConfig()
+	activeConfig.Store(&cfg)
+}
+
+// currentConfig returns a copy of the config currently in effect, safe
+// to change and install with setConfig.
+func currentConfig() Config {
+	return *activeConfig.Load()
+}
+
+// setConfig validates and installs cfg as the active config.
+func setConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	activeConfig.Store(&cfg)
+	return nil
+}
+
+// clone returns a deep copy of c, so unmarshalling a patch into it can't
+// mutate the maps and slices shared with the active config.
+func (c Config) clone() (Config, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return c, fmt.Errorf("clone config: %w", err)
+	}
+	var out Config
+	if err := json.Unmarshal(data, &out); err != nil {
+		return c, fmt.Errorf("clone config: %w", err)
+	}
+	return out, nil
+}
+
+// redactedSecret stands in for the secrets of a config returned by the
+// API. Patched back, it leaves the secret as it is.
+const redactedSecret = "********"
+
+// secrets returns the fields of c holding keys and tokens, or the files
+// they are read from.
+func (c *Config) secrets() []*string {
+	return []*string{&c.SpacesRemoteKey, &c.SpacesRemoteKnownHosts, &c.SpacesEncryptionKey, &c.SpokeToken, &c.SyncthingAPIKey}
+}
+
+// redacted returns c with the secrets that are set replaced by
+// redactedSecret.
+func (c Config) redacted() Config {
+	for _, p := range c.secrets() {
+		if *p != "" {
+			*p = redactedSecret
+		}
+	}
+	return c
+}
+
+// changedKeys returns the JSON names of the top-level fields that differ
+// between a and b, sorted.
+func changedKeys(a, b Config) []string {
+	var am, bm map[string]json.RawMessage
+	ad, _ := json.Marshal(a) // a Config always encodes
+	bd, _ := json.Marshal(b)
+	json.Unmarshal(ad, &am) //nolint:errcheck
+	json.Unmarshal(bd, &bm) //nolint:errcheck
+	var keys []string
+	for k, v := range bm {
+		if !bytes.Equal(am[k], v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// patchConfig applies a partial JSON document to the active config.
+// Only runtime-tunable fields may change; roots, worker count, lazy
+// registration, watch scoping, the watch backend, placeholders, the
+// Spaces key, the OTLP exporter, the decision log, the remote, spoke and
+// Syncthing connections, the hooks, the scanner, the anomaly alert URL,
+// the Spaces owner and the backup targets require a restart and are
+// rejected, and rules may only use transcode commands already configured.
+// Those run commands, send keys and data to the host configured or hand
+// files to the user or directory configured, which the unauthenticated
+// sync API must not set.
+func patchConfig(patch []byte) (Config, error) {
+	old := currentConfig()
+	cfg, err := old.clone()
+	if err != nil {
+		return old, err
+	}
+	if err := json.Unmarshal(patch, &cfg); err != nil {
+		return old, fmt.Errorf("invalid patch: %w", err)
+	}
+	prev := old.secrets()
+	for i, p := range cfg.secrets() {
+		if *p == redactedSecret {
+			*p = *prev[i] // sent back as read
+		}
+	}
+	for ext, category := range cfg.Types {
+		if category == "" {
+			delete(cfg.Types, ext) // "" removes an override
+		}
+	}
+	if cfg.ArchivesRoot != old.ArchivesRoot || cfg.SpacesRoot != old.SpacesRoot ||
+		cfg.TrashRoot != old.TrashRoot || cfg.Workers != old.Workers ||
+		cfg.LazyRegistration != old.LazyRegistration || cfg.WatchScoped != old.WatchScoped ||
+		cfg.WatchBackend != old.WatchBackend || cfg.Placeholders != old.Placeholders ||
+		cfg.SpacesRemote != old.SpacesRemote || cfg.SpacesRemoteKey != old.SpacesRemoteKey ||
+		cfg.SpacesRemoteKnownHosts != old.SpacesRemoteKnownHosts || cfg.SpacesRemotePool != old.SpacesRemotePool ||
+		cfg.SpacesEncryptionKey != old.SpacesEncryptionKey || cfg.SpacesBlockStore != old.SpacesBlockStore ||
+		cfg.SpokeHub != old.SpokeHub || cfg.SpokeToken != old.SpokeToken || cfg.SpokeRoot != old.SpokeRoot ||
+		cfg.OTLPEndpoint != old.OTLPEndpoint || cfg.OTLPInsecure != old.OTLPInsecure ||
+		cfg.DecisionLog != old.DecisionLog || !reflect.DeepEqual(cfg.Hooks, old.Hooks) ||
+		cfg.ScanCommand != old.ScanCommand || cfg.ScanClamd != old.ScanClamd || cfg.Anomaly.URL != old.Anomaly.URL ||
+		!reflect.DeepEqual(cfg.SpacesOwner, old.SpacesOwner) || !reflect.DeepEqual(cfg.BackupTargets, old.BackupTargets) ||
+		cfg.Syncthing != old.Syncthing || cfg.SyncthingURL != old.SyncthingURL || cfg.SyncthingFolder != old.SyncthingFolder {
+		return old, fmt.Errorf("roots, workers, lazyRegistration, watchScoped, watchBackend, placeholders, spacesEncryptionKey, spacesBlockStore, the OTLP exporter, decisionLog, hooks, scanCommand, scanClamd, anomaly.url, spacesOwner, backupTargets and the spacesRemote, spoke and Syncthing connections cannot be changed at runtime")
+	}
+	if tmpl := newTranscode(old.Rules, cfg.Rules); tmpl != "" {
+		return old, fmt.Errorf("transcode command %q is not among those configured at startup", tmpl)
+	}
+	if err := setConfig(cfg); err != nil {
+		return old, err
+	}
+	sub("config").Info("config updated", "changed", changedKeys(old, cfg))
+	return cfg, nil
+}
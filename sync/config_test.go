@@ -0,0 +1,226 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restoreConfig resets the active config after the test.
+func restoreConfig(t testing.TB) {
+	t.Helper()
+	prev := currentConfig()
+	t.Cleanup(func() { activeConfig.Store(&prev) })
+}
+
+// withConfig installs the active config as changed by edit for the rest
+// of the test, restoring the previous one when it ends.
+func withConfig(t testing.TB, edit func(*Config)) {
+	t.Helper()
+	restoreConfig(t)
+	cfg := currentConfig()
+	edit(&cfg)
+	require.NoError(t, setConfig(cfg))
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := LoadConfig("", DefaultConfig())
+	require.NoError(t, err)
+	assert.Equal(t, 300, cfg.DebounceMs)
+	assert.Equal(t, copyChunkSize, cfg.CopyChunkSize)
+	assert.Equal(t, 1, cfg.Workers)
+}
+
+func TestLoadConfig_YAMLAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sync.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("archivesRoot: /a\nspacesRoot: /s\ndebounceMs: 1000\nworkers: 2\n"), 0644))
+
+	t.Setenv("FB_SYNC_WORKERS", "4")
+
+	cfg, err := LoadConfig(path, DefaultConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "/a", cfg.ArchivesRoot)
+	assert.Equal(t, "/s", cfg.SpacesRoot)
+	assert.Equal(t, 1000, cfg.DebounceMs)
+	assert.Equal(t, 4, cfg.Workers, "env overrides file")
+	assert.Equal(t, "/.trash", cfg.ResolvedTrashRoot())
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sync.toml")
+	require.NoError(t, os.WriteFile(path, []byte("trashRoot = \"/t\"\ncopyChunkSize = 1048576\n"), 0644))
+
+	cfg, err := LoadConfig(path, DefaultConfig())
+	require.NoError(t, err)
+	assert.Equal(t, "/t", cfg.ResolvedTrashRoot())
+	assert.Equal(t, 1048576, cfg.CopyChunkSize)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	t.Setenv("FB_SYNC_DEBOUNCE_MS", "1")
+	_, err := LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+
+	t.Setenv("FB_SYNC_DEBOUNCE_MS", "abc")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+
+	t.Setenv("FB_SYNC_DEBOUNCE_MS", "300")
+	t.Setenv("FB_SYNC_WATCH_BACKEND", "kqueue")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+
+	t.Setenv("FB_SYNC_WATCH_BACKEND", "fsnotify")
+	t.Setenv("FB_SYNC_SPACES_REMOTE", "sync@nas")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err, "a remote needs a key and known hosts")
+
+	t.Setenv("FB_SYNC_SPACES_REMOTE", "")
+	t.Setenv("FB_SYNC_SPACES_ENCRYPTION_KEY", "/etc/sync/spaces.key")
+	t.Setenv("FB_SYNC_PLACEHOLDERS", "true")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err, "stubs can't be encrypted")
+
+	t.Setenv("FB_SYNC_SPACES_ENCRYPTION_KEY", "")
+	t.Setenv("FB_SYNC_PLACEHOLDERS", "false")
+	t.Setenv("FB_SYNC_SPACES_ROOT", "/srv/Spaces")
+	t.Setenv("FB_SYNC_SPACES_BLOCK_STORE", "/srv/Spaces/.blocks")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err, "the block store can't live in Spaces")
+	t.Setenv("FB_SYNC_SPACES_BLOCK_STORE", "/srv/blocks")
+	t.Setenv("FB_SYNC_TRACE_SAMPLE_PERCENT", "101")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+	t.Setenv("FB_SYNC_TRACE_SAMPLE_PERCENT", "10")
+	t.Setenv("FB_SYNC_PARALLEL_COPY_STREAMS", "0")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+	t.Setenv("FB_SYNC_PARALLEL_COPY_STREAMS", "8")
+	t.Setenv("FB_SYNC_TREE_MAX_DEPTH", "0")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.Error(t, err)
+	t.Setenv("FB_SYNC_TREE_MAX_DEPTH", "64")
+	_, err = LoadConfig("", DefaultConfig())
+	assert.NoError(t, err)
+}
+
+func TestHandleConfig_GetAndPatch(t *testing.T) {
+	restoreConfig(t)
+	h, _, _, _ := setupHandlersEnv(t)
+
+	w := httptest.NewRecorder()
+	h.HandleGetConfig(w, httptest.NewRequest("GET", "/api/sync/config", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var got Config
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, 300, got.DebounceMs)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"debounceMs":50}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 50, currentConfig().DebounceMs)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"spacesQueue":{"debounceMs":20,"promote":true}}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, RootQueue{DebounceMs: 20, Promote: true}, currentConfig().SpacesQueue)
+
+	// Out-of-range value rejected
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"copyChunkSize":1}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Restart-only value rejected
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"workers":8}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 50, currentConfig().DebounceMs)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"lazyRegistration":true}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"watchBackend":"fanotify"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"placeholders":true}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"spacesRemotePool":4}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"spacesEncryptionKey":"/tmp/key"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"spacesBlockStore":"/tmp/blocks"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestConfig_RootReads(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ArchivesRoot = "/srv/Archives"
+	cfg.SpacesRoot = "/srv/Spaces"
+	cfg.ArchivesReads = RootReads{NoAtime: true, DropCache: true}
+	cfg.SpacesReads = RootReads{DropCache: true}
+
+	assert.Equal(t, cfg.ArchivesReads, cfg.rootReads("/srv/Archives/a/b.txt"))
+	assert.Equal(t, cfg.SpacesReads, cfg.rootReads("/srv/Spaces/b.txt"))
+	assert.Equal(t, RootReads{}, cfg.rootReads("/srv/Archives2/b.txt"))
+	assert.Equal(t, RootReads{}, cfg.rootReads("/tmp/b.txt"))
+}
+
+func TestHandleConfig_RedactsSecrets(t *testing.T) {
+	restoreConfig(t)
+	var logs bytes.Buffer
+	prevLogger := logger
+	logger = slog.New(slog.NewTextHandler(&logs, nil))
+	t.Cleanup(func() { logger = prevLogger })
+	cfg := currentConfig()
+	cfg.SyncthingURL, cfg.SyncthingAPIKey, cfg.SyncthingFolder = "http://127.0.0.1:8384", "s3cret-api-key", "spaces"
+	require.NoError(t, setConfig(cfg))
+	h, _, _, _ := setupHandlersEnv(t)
+
+	w := httptest.NewRecorder()
+	h.HandleGetConfig(w, httptest.NewRequest("GET", "/api/sync/config", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret-api-key")
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, redactedSecret, got["syncthingApiKey"])
+	assert.Equal(t, "", got["spokeToken"], "unset secrets stay empty")
+
+	// Sending back what was read keeps the secret.
+	got["debounceMs"] = 50
+	body, err := json.Marshal(got)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "s3cret-api-key")
+	assert.Equal(t, "s3cret-api-key", currentConfig().SyncthingAPIKey)
+	assert.Equal(t, 50, currentConfig().DebounceMs)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", bytes.NewBufferString(`{"syncthingApiKey":"n3w-api-key"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "n3w-api-key", currentConfig().SyncthingAPIKey)
+
+	assert.NotContains(t, logs.String(), "s3cret-api-key")
+	assert.NotContains(t, logs.String(), "n3w-api-key")
+	assert.Contains(t, logs.String(), "changed=[syncthingApiKey]")
+}
@@ -0,0 +1,208 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleContent_ReadWriteSynced(t *testing.T) {
+	restoreConfig(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"notes.txt"}, map[string]bool{"notes.txt": true})
+	ino, err := h.resolvePathToIno("notes.txt")
+	require.NoError(t, err)
+	url := "/api/sync/content/" + strconv.FormatUint(ino, 10)
+
+	// Spaces copy diverged but not yet synced back: GET serves Spaces
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "notes.txt"), []byte("spaces"), 0644))
+	w := httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "spaces", w.Body.String())
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	stale := httptest.NewRequest("PUT", url, strings.NewReader("x"))
+	stale.Header.Set("If-Match", `"1"`)
+	w = httptest.NewRecorder()
+	h.HandlePutContent(w, stale)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	// Writing would replace the Archives file the Spaces change isn't in
+	diverged := httptest.NewRequest("PUT", url, strings.NewReader("x"))
+	diverged.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	h.HandlePutContent(w, diverged)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Once synced back, the write goes through
+	require.NoError(t, RunPipeline(context.Background(), "notes.txt", store, archivesRoot, spacesRoot, "", nil))
+	w = httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", url, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "spaces", w.Body.String())
+	etag = w.Header().Get("ETag")
+
+	req := httptest.NewRequest("PUT", url, strings.NewReader("edited"))
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	h.HandlePutContent(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var e Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+
+	for _, root := range []string{archivesRoot, spacesRoot} {
+		data, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "edited", string(data))
+	}
+
+	// DB matches disk on both sides, so the pipeline has nothing to do
+	entry, sv, err := lookupDB(store, archivesRoot, "notes.txt")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.NotNil(t, sv)
+	assert.Equal(t, e.Inode, entry.Inode)
+	aMtime, _, _, _ := statFile(filepath.Join(archivesRoot, "notes.txt"))
+	sMtime, _, _, _ := statFile(filepath.Join(spacesRoot, "notes.txt"))
+	st := ComputeState(entry, sv, aMtime, sMtime)
+	assert.False(t, st.ADirty)
+	assert.False(t, st.SDirty)
+}
+
+func TestHandleContent_Limits(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.ContentMaxBytes = 4
+	require.NoError(t, setConfig(cfg))
+
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"big.txt", "Dir/"}, nil)
+	ino, err := h.resolvePathToIno("big.txt")
+	require.NoError(t, err)
+	url := "/api/sync/content/" + strconv.FormatUint(ino, 10)
+
+	w := httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", url, nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req := httptest.NewRequest("PUT", url, strings.NewReader("still too big"))
+	req.Header.Set("If-Match", fileETag(t, filepath.Join(archivesRoot, "big.txt")))
+	w = httptest.NewRecorder()
+	h.HandlePutContent(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	data, err := os.ReadFile(filepath.Join(archivesRoot, "big.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "big.txt", string(data), "rejected write leaves the file intact")
+
+	dirIno, err := h.resolvePathToIno("Dir")
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/"+strconv.FormatUint(dirIno, 10), nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/999999", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// fileETag returns the content ETag of the file at p.
+func fileETag(t *testing.T, p string) string {
+	t.Helper()
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	return contentETag(info.ModTime().UnixNano(), info.Size())
+}
+
+func TestHandlePutContent_ArchivesChangedUnderSpaces(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"notes.txt"}, map[string]bool{"notes.txt": true})
+	ino, err := h.resolvePathToIno("notes.txt")
+	require.NoError(t, err)
+	url := "/api/sync/content/" + strconv.FormatUint(ino, 10)
+	archivePath := filepath.Join(archivesRoot, "notes.txt")
+	require.NoError(t, os.WriteFile(archivePath, []byte("archives"), 0644))
+	require.NoError(t, os.Chtimes(archivePath, time.Now(), time.Now().Add(time.Hour)))
+
+	// The ETag is the Spaces copy's, which hasn't changed
+	req := httptest.NewRequest("PUT", url, strings.NewReader("edited"))
+	req.Header.Set("If-Match", fileETag(t, filepath.Join(spacesRoot, "notes.txt")))
+	w := httptest.NewRecorder()
+	h.HandlePutContent(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	data, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "archives", string(data))
+}
+
+func TestHandlePutContent_RequiresCurrentETag(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"notes.txt"}, nil)
+	ino, err := h.resolvePathToIno("notes.txt")
+	require.NoError(t, err)
+	url := "/api/sync/content/" + strconv.FormatUint(ino, 10)
+	p := filepath.Join(archivesRoot, "notes.txt")
+	etag := fileETag(t, p)
+
+	w := httptest.NewRecorder()
+	h.HandlePutContent(w, httptest.NewRequest("PUT", url, strings.NewReader("blind")))
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	// Changed behind the reader's back, keeping the mtime.
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(p, []byte("rewritten elsewhere"), 0644))
+	require.NoError(t, os.Chtimes(p, info.ModTime(), info.ModTime()))
+	req := httptest.NewRequest("PUT", url, strings.NewReader("edited"))
+	req.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	h.HandlePutContent(w, req)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "rewritten elsewhere", string(data))
+}
+
+func TestHandlePutContent_WaitsForWorker(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"notes.txt"}, nil)
+	ino, err := h.resolvePathToIno("notes.txt")
+	require.NoError(t, err)
+	url := "/api/sync/content/" + strconv.FormatUint(ino, 10)
+	p := filepath.Join(archivesRoot, "notes.txt")
+	etag := fileETag(t, p)
+
+	// A worker holds the path and rewrites the file meanwhile.
+	release := h.daemon.acquirePath("notes.txt")
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest("PUT", url, strings.NewReader("edited"))
+		req.Header.Set("If-Match", etag)
+		w := httptest.NewRecorder()
+		h.HandlePutContent(w, req)
+		done <- w.Code
+	}()
+	select {
+	case code := <-done:
+		t.Fatalf("wrote while a worker held the path: %d", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, os.WriteFile(p, []byte("from the worker"), 0644))
+	release()
+
+	assert.Equal(t, http.StatusPreconditionFailed, <-done)
+	data, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "from the worker", string(data))
+}
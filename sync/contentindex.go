@@ -0,0 +1,269 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// contentIndexInterval is how often the indexer looks for synced
+	// documents to index when no pipeline run wakes it.
+	contentIndexInterval = time.Minute
+	// contentIndexBatch is the number of documents indexed per pass.
+	contentIndexBatch = 50
+	// contentIndexSettle is how long after a pipeline copy or trash move
+	// the indexer looks at it.
+	contentIndexSettle = 2 * time.Second
+	// contentIndexMaxText bounds the text kept per document.
+	contentIndexMaxText = 1 << 20
+)
+
+// contentIndexWake is signalled after pipeline copies and trash moves,
+// so the indexer picks up the change without waiting for its interval.
+var contentIndexWake = make(chan struct{}, 1)
+
+// wakeContentIndex tells the indexer the synced files changed.
+func wakeContentIndex() {
+	select {
+	case contentIndexWake <- struct{}{}:
+	default:
+	}
+}
+
+// ContentHit is a synced document matching a content search.
+type ContentHit struct {
+	Entry
+	Path    string `json:"path"`
+	Snippet string `json:"snippet"` // matched terms wrapped in [ ]
+}
+
+// extractText returns the text of the document at path, cut at
+// contentIndexMaxText. Text and code files are read as they are; PDFs go
+// through pdftotext, and yield no text where it isn't installed.
+func extractText(ctx context.Context, path, entryType string) (string, error) {
+	var r io.Reader
+	switch entryType {
+	case "text", "code":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	case "pdf":
+		bin, err := exec.LookPath("pdftotext")
+		if err != nil {
+			return "", nil
+		}
+		out, err := exec.CommandContext(ctx, bin, "-q", "-enc", "UTF-8", path, "-").Output()
+		if err != nil {
+			return "", fmt.Errorf("pdftotext: %w", err)
+		}
+		r = strings.NewReader(string(out))
+	default:
+		return "", nil
+	}
+	b, err := io.ReadAll(io.LimitReader(r, contentIndexMaxText))
+	if err != nil {
+		return "", err
+	}
+	// Drops a rune cut in half at the limit along with anything binary.
+	return strings.ToValidUTF8(string(b), ""), nil
+}
+
+// indexContentBatch drops the documents no longer synced from the index,
+// then indexes up to contentIndexBatch new or changed synced ones. It
+// returns how many it indexed.
+func indexContentBatch(ctx context.Context, store *Store, archivesRoot string) (int, error) {
+	l := sub("contentindex")
+	if n, err := store.DropStaleContent(); err != nil {
+		return 0, err
+	} else if n > 0 {
+		l.Debug("dropped from content index", "count", n)
+	}
+	pending, err := store.ListContentPending(contentIndexBatch)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pending {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		entry := &pending[i]
+		relPath := store.RelPath(entry)
+		text, err := extractText(ctx, filepath.Join(archivesRoot, relPath), entry.Type)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			l.Warn("text extraction failed", "path", relPath, "err", err)
+		}
+		// Record even empty results so the file isn't re-read until its
+		// mtime changes.
+		if err := store.PutContent(entry.Inode, entry.Mtime, text); err != nil {
+			return i, fmt.Errorf("index %s: %w", relPath, err)
+		}
+		l.Debug("content indexed", "path", relPath, "bytes", len(text))
+	}
+	return len(pending), nil
+}
+
+// runContentIndexer keeps the content index in step with the synced
+// documents while Config.ContentIndex is enabled, until ctx is cancelled.
+// A full batch is followed immediately by the next.
+func (d *Daemon) runContentIndexer(ctx context.Context) {
+	l := sub("contentindex")
+	l.Info("content indexer started")
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	due := time.Now()
+	reset := func(d time.Duration) {
+		timer.Reset(d)
+		due = time.Now().Add(d)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-contentIndexWake:
+			// Let the pipeline run finish recording the change first.
+			if time.Until(due) > contentIndexSettle {
+				reset(contentIndexSettle)
+			}
+			continue
+		case <-timer.C:
+		}
+		if !currentConfig().ContentIndex || power.deferWork() {
+			reset(power.stretch(contentIndexInterval))
+			continue
+		}
+		n, err := indexContentBatch(ctx, d.store, d.archivesRoot)
+		if err != nil && ctx.Err() == nil {
+			l.Error("content index batch failed", "err", err)
+		}
+		if n == contentIndexBatch {
+			reset(0)
+		} else {
+			reset(power.stretch(contentIndexInterval))
+		}
+	}
+}
+
+// contentTypes are the entry types the content index holds.
+const contentTypes = `('text', 'code', 'pdf')`
+
+// ListContentPending returns synced documents missing from the content
+// index or indexed at another mtime, up to limit.
+func (s *Store) ListContentPending(limit int) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e
+		JOIN spaces_view sv ON sv.entry_ino = e.inode
+		LEFT JOIN content_docs c ON c.entry_ino = e.inode
+		WHERE e.type IN `+contentTypes+` AND e.deleted_at = 0
+		  AND (c.entry_ino IS NULL OR c.mtime != e.mtime)
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list content pending: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PutContent replaces the indexed text of an entry, extracted from the
+// file at mtime.
+func (s *Store) PutContent(entryIno uint64, mtime int64, text string) error {
+	return s.WithTx(func(t *TxStore) error {
+		stmts := []struct {
+			query string
+			args  []any
+		}{
+			{`DELETE FROM content_fts WHERE rowid = ?`, []any{entryIno}},
+			{`INSERT INTO content_fts (rowid, body) VALUES (?, ?)`, []any{entryIno, text}},
+			{`INSERT INTO content_docs (entry_ino, mtime, indexed_at) VALUES (?, ?, ?)
+			  ON CONFLICT(entry_ino) DO UPDATE SET mtime = excluded.mtime, indexed_at = excluded.indexed_at`, []any{entryIno, mtime, nowNano()}},
+		}
+		for _, st := range stmts {
+			if _, err := t.tx.Exec(st.query, st.args...); err != nil {
+				return fmt.Errorf("put content: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DropStaleContent removes the indexed text of entries no longer synced:
+// gone from Spaces or lost from Archives. Entries deleted outright take
+// theirs with them.
+func (s *Store) DropStaleContent() (int64, error) {
+	res, err := s.exec(`
+		DELETE FROM content_docs WHERE entry_ino IN (
+			SELECT c.entry_ino FROM content_docs c
+			JOIN entries e ON e.inode = c.entry_ino
+			LEFT JOIN spaces_view sv ON sv.entry_ino = c.entry_ino
+			WHERE e.deleted_at != 0 OR sv.entry_ino IS NULL
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("drop stale content: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// SearchContent returns the synced documents whose text matches every
+// word of query, best match first, up to limit.
+func (s *Store) SearchContent(query string, limit int) ([]ContentHit, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at,
+		       snippet(content_fts, 0, '[', ']', '…', 16)
+		FROM content_fts f
+		JOIN entries e ON e.inode = f.rowid
+		JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE content_fts MATCH ? AND e.deleted_at = 0
+		ORDER BY f.rank
+		LIMIT ?
+	`, match, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search content: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []ContentHit
+	for rows.Next() {
+		var h ContentHit
+		e := &h.Entry
+		if err := rows.Scan(&e.Inode, &e.ParentIno, &e.Name, &e.Type, &e.Size, &e.Mtime, &e.Selected, &e.Excluded, &e.Starred, &e.CreatedAt, &e.UpdatedAt, &e.DeletedAt, &h.Snippet); err != nil {
+			return nil, fmt.Errorf("scan hit: %w", err)
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// ftsQuery turns the words of a user's query into FTS5 phrases, so
+// quotes and operators in it are searched for rather than parsed.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
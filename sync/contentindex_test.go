@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentIndex_FollowsSync(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	ctx := context.Background()
+	env.writeArchive(t, "plan.md", []byte("# Plan\nMigrate the heron database before spring."))
+	env.writeArchive(t, "other.txt", []byte("heron sightings, not synced"))
+	env.run(t, "plan.md")
+	env.run(t, "other.txt")
+	plan, err := env.store.GetEntryByPath(0, "plan.md")
+	require.NoError(t, err)
+	require.NoError(t, env.store.SetSelected([]uint64{plan.Inode}, true))
+	env.run(t, "plan.md")
+
+	n, err := indexContentBatch(ctx, env.store, env.archivesRoot)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "only the synced document is indexed")
+	hits, err := env.store.SearchContent("HERON spring", 10)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, plan.Inode, hits[0].Inode)
+	assert.Contains(t, hits[0].Snippet, "[heron]")
+	n, err = indexContentBatch(ctx, env.store, env.archivesRoot)
+	require.NoError(t, err)
+	assert.Zero(t, n, "unchanged documents aren't reindexed")
+
+	// An edit is reindexed.
+	env.clock.Advance(time.Second)
+	env.writeArchive(t, "plan.md", []byte("# Plan\nMigrate the egret database."))
+	env.run(t, "plan.md")
+	_, err = indexContentBatch(ctx, env.store, env.archivesRoot)
+	require.NoError(t, err)
+	hits, err = env.store.SearchContent("heron", 10)
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+	hits, err = env.store.SearchContent("egret", 10)
+	require.NoError(t, err)
+	assert.Len(t, hits, 1)
+
+	// Deselected, it leaves Spaces and the index.
+	require.NoError(t, env.store.SetSelected([]uint64{plan.Inode}, false))
+	env.run(t, "plan.md")
+	_, err = indexContentBatch(ctx, env.store, env.archivesRoot)
+	require.NoError(t, err)
+	var left int
+	require.NoError(t, env.store.rdb.QueryRow("SELECT COUNT(*) FROM content_fts").Scan(&left))
+	assert.Zero(t, left)
+}
+
+func TestContentIndex_QueryIsNotParsed(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 1, SyncedMtime: 1}))
+	require.NoError(t, store.PutContent(1, 1, `say "hi" AND NOT bye`))
+
+	for _, q := range []string{`"hi"`, `NOT`, `hi AND`, `bye)`} {
+		_, err := store.SearchContent(q, 10)
+		assert.NoError(t, err, q)
+	}
+	hits, err := store.SearchContent(`NOT bye`, 10)
+	require.NoError(t, err)
+	assert.Len(t, hits, 1, "operators are searched for as words")
+}
+
+func TestContentIndex_DeleteEntryDropsText(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.PutContent(1, 1, "some words"))
+	require.NoError(t, store.DeleteEntry(1))
+	var left int
+	require.NoError(t, store.rdb.QueryRow("SELECT COUNT(*) FROM content_fts").Scan(&left))
+	assert.Zero(t, left)
+}
+
+func TestHandleSearch(t *testing.T) {
+	restoreConfig(t)
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 10, Name: "docs", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 11, ParentIno: 10, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 11, SyncedMtime: 1}))
+	require.NoError(t, store.PutContent(11, 1, "the quarterly figures"))
+	h := NewHandlers(store, nil, t.TempDir(), t.TempDir())
+
+	search := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.HandleSearch(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, search("/api/sync/search?content=quarterly").Code, "index disabled")
+
+	cfg := currentConfig()
+	cfg.ContentIndex = true
+	require.NoError(t, setConfig(cfg))
+	assert.Equal(t, http.StatusBadRequest, search("/api/sync/search").Code)
+	assert.Equal(t, http.StatusBadRequest, search("/api/sync/search?content=x&limit=0").Code)
+
+	w := search("/api/sync/search?content=quarterly")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Items []ContentHit `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "docs/a.txt", resp.Items[0].Path)
+	assert.Equal(t, "the [quarterly] figures", resp.Items[0].Snippet)
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCopy_Directory(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Proj/", "Proj/sub/", "Proj/a.txt", "Proj/sub/b.txt"},
+		map[string]bool{"Proj/a.txt": true})
+
+	w := postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"Proj","to":"Proj copy","inheritSelection":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var root Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &root))
+	assert.Equal(t, "Proj copy", root.Name)
+	assert.Equal(t, "dir", root.Type)
+
+	data, err := os.ReadFile(filepath.Join(archivesRoot, "Proj copy", "sub", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Proj/sub/b.txt", string(data))
+
+	ino, err := h.resolvePathToIno("Proj copy/a.txt")
+	require.NoError(t, err)
+	require.NotZero(t, ino)
+	e, _ := store.GetEntry(ino)
+	assert.True(t, e.Selected, "selection inherited")
+	assert.True(t, h.daemon.Queue().Has("Proj copy/a.txt"))
+
+	ino, err = h.resolvePathToIno("Proj copy/sub/b.txt")
+	require.NoError(t, err)
+	e, _ = store.GetEntry(ino)
+	require.NotNil(t, e)
+	assert.False(t, e.Selected)
+}
+
+func TestHandleCopy_FileWithoutInherit(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, map[string]bool{"a.txt": true})
+
+	w := postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"a.txt","to":"b.txt"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var e Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.False(t, e.Selected)
+	assert.NotZero(t, e.Inode)
+
+	assert.Equal(t, http.StatusConflict, postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"a.txt","to":"b.txt"}`).Code)
+	assert.Equal(t, http.StatusNotFound, postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"zzz","to":"c.txt"}`).Code)
+}
+
+func TestHandleCopy_KeepsWhatWasCopiedOnFailure(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Proj/", "Proj/a.txt"}, map[string]bool{"Proj/": true, "Proj/a.txt": true})
+
+	// Cancelled before the file's copy: only the directory gets made
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/api/sync/copy", strings.NewReader(`{"from":"Proj","to":"Copy","inheritSelection":true}`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.HandleCopy(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	assert.NoFileExists(t, filepath.Join(archivesRoot, "Copy", "a.txt"))
+	ino, err := h.resolvePathToIno("Copy")
+	require.NoError(t, err)
+	require.NotZero(t, ino, "the directory copied is registered")
+	assert.True(t, h.daemon.Queue().Has("Copy"))
+}
+
+func TestHandleCopy_WaitsForWorker(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	release := h.daemon.acquirePath("b.txt")
+	done := make(chan int)
+	go func() {
+		done <- postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"a.txt","to":"b.txt"}`).Code
+	}()
+	select {
+	case <-done:
+		t.Fatal("copy ran while a worker held its target")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	assert.Equal(t, http.StatusOK, <-done)
+}
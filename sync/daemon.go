@@ -2,7 +2,15 @@ package sync
 
 import (
 	"context"
+	"errors"
+	"path"
 	"path/filepath"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Daemon orchestrates the sync process: initial seed, watcher, and eval queue worker.
@@ -13,19 +21,78 @@ type Daemon struct {
 	trashRoot    string
 	queue        *EvalQueue
 	pathCache    *PathCache
+	monitor      *ProgressMonitor
+	workers      int
+	seedStatus   seedTracker
+	lazy         *lazyIndex // nil unless Config.LazyRegistration
+	blocks       *blockFS   // nil unless Config.SpacesBlockStore
+	backups      backupRunner
+	reconciles   *reconcileJobs
+	jobs         *selectionJobs
+	fsckRuns     *fsckRuns
+	active       activeDirs
+	selSnaps     selectionSnapshots
+	scopeChanged chan struct{}
+	instance     *instanceLock
+	watcher      atomic.Pointer[Watcher] // the current one, replaced on remounts
+
+	inflightMu gosync.Mutex
+	inflight   map[string]chan struct{} // paths currently in RunPipeline
+	aborts     map[string]pathAbort     // runs of cancelled jobs, see jobcancel.go
 }
 
-// NewDaemon creates a new sync daemon.
+// NewDaemon creates a new sync daemon using the active config for
+// everything but the roots.
 func NewDaemon(store *Store, archivesRoot, spacesRoot string) *Daemon {
-	trashRoot := filepath.Join(filepath.Dir(spacesRoot), ".trash")
-	return &Daemon{
+	cfg := currentConfig()
+	cfg.ArchivesRoot = archivesRoot
+	cfg.SpacesRoot = spacesRoot
+	return NewDaemonFromConfig(store, cfg)
+}
+
+// NewDaemonFromConfig creates a new sync daemon from a loaded Config and
+// installs it as the active config so runtime-tunable values apply.
+func NewDaemonFromConfig(store *Store, cfg Config) *Daemon {
+	if err := setConfig(cfg); err != nil {
+		sub("daemon").Warn("invalid config, keeping previous", "err", err)
+	}
+	queue := NewEvalQueue()
+	archivesRoot := cfg.ArchivesRoot
+	queue.SetSizer(func(relPath string) (int64, bool) {
+		_, isDir, _, size := statFile(filepath.Join(archivesRoot, relPath))
+		if size == nil {
+			return 0, false
+		}
+		return *size, *isDir
+	})
+	queue.SetHold(power.deferWork)
+	var lazy *lazyIndex
+	if cfg.LazyRegistration {
+		lazy = newLazyIndex()
+	}
+	d := &Daemon{
 		store:        store,
-		archivesRoot: archivesRoot,
-		spacesRoot:   spacesRoot,
-		trashRoot:    trashRoot,
-		queue:        NewEvalQueue(),
+		archivesRoot: cfg.ArchivesRoot,
+		spacesRoot:   cfg.SpacesRoot,
+		trashRoot:    cfg.ResolvedTrashRoot(),
+		queue:        queue,
 		pathCache:    NewPathCache(),
+		monitor:      NewProgressMonitor(stallThreshold, queue.Len),
+		workers:      max(cfg.Workers, 1),
+		lazy:         lazy,
+		scopeChanged: make(chan struct{}, 1),
+		instance:     newInstanceLock(),
+		inflight:     make(map[string]chan struct{}),
+		aborts:       make(map[string]pathAbort),
+		backups:      newBackupRunner(),
+		reconciles:   newReconcileJobs(),
+		jobs:         newSelectionJobs(),
+		fsckRuns:     newFsckRuns(),
+	}
+	if cfg.SpacesBlockStore != "" {
+		d.blocks = newBlockFS(cfg.SpacesBlockStore, d.spacesRoot, d.trashRoot)
 	}
+	return d
 }
 
 // Queue returns the eval queue, used by HTTP handlers to push select/deselect events.
@@ -33,99 +100,378 @@ func (d *Daemon) Queue() *EvalQueue {
 	return d.queue
 }
 
+// Monitor returns the worker progress monitor used for stall detection.
+func (d *Daemon) Monitor() *ProgressMonitor {
+	return d.monitor
+}
+
+// SeedStatus returns the progress of the startup seed.
+func (d *Daemon) SeedStatus() SeedStatus {
+	return d.seedStatus.snapshot()
+}
+
 // Run starts the daemon. It performs an initial seed, starts the watcher,
 // then processes the eval queue. Blocks until ctx is cancelled.
 func (d *Daemon) Run(ctx context.Context) {
 	l := sub("daemon")
 	l.Info("sync daemon starting", "archives", d.archivesRoot, "spaces", d.spacesRoot, "trash", d.trashRoot)
 
+	// One daemon per database; this one stops if it loses the lock.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	if !d.acquireInstance(ctx) {
+		return
+	}
+	defer d.releaseInstance()
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		d.runInstanceHeartbeat(ctx, stop)
+	}()
+	defer func() { stop(); <-heartbeat }()
+
+	// Reach a remote Spaces before anything touches it
+	unmount, err := d.mountRemote()
+	if err != nil {
+		l.Error("remote Spaces unavailable, daemon aborting", "err", err)
+		return
+	}
+	defer unmount()
+	unmountBlocks, err := d.mountBlocks()
+	if err != nil {
+		l.Error("Spaces block store unavailable, daemon aborting", "err", err)
+		return
+	}
+	defer unmountBlocks()
+	defer detectRootTimes(d.archivesRoot, d.spacesRoot, d.trashRoot)()
+	if key, err := spacesKey(); err != nil {
+		l.Error("Spaces key unavailable, daemon aborting", "err", err)
+		return
+	} else if key != nil {
+		l.Info("Spaces copies are encrypted", "cipher", key.Cipher())
+	}
+
+	defer power.watch(d.localRoots(), d.queue.Wake)()
+	defer events.persistTo(d.store)()
+	defer decisions.open(currentConfig().DecisionLog)()
+	if shutdown, err := startTracing(ctx, currentConfig()); err != nil {
+		l.Warn("trace exporter unavailable, tracing disabled", "err", err)
+	} else {
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				l.Warn("flush trace spans failed", "err", err)
+			}
+		}()
+	}
+
+	// Phase 0: Finish pipeline steps interrupted by a crash
+	replayed, err := replayJournal(d.store, d.archivesRoot, d.spacesRoot)
+	if err != nil {
+		l.Error("journal replay failed", "err", err)
+	}
+
+	// Start hydration before anything can create placeholders
+	d.startHydrator(ctx)
+
+	// Canaries first: one changed while the daemon was down must stop
+	// the reconcile from propagating what the seed finds.
+	placeCanaries(d.store, d.spacesRoot, false)
+
 	// Phase 1: Initial seed
-	if err := Seed(d.store, d.archivesRoot, d.spacesRoot); err != nil {
+	changed, err := seed(d.store, d.archivesRoot, d.spacesRoot, &d.seedStatus, d.lazy)
+	if err != nil {
 		l.Error("seed failed, daemon aborting", "err", err)
 		return
 	}
 
-	// Phase 2: Full reconcile — push all entries to eval queue
-	d.fullReconcile()
+	// Phase 2: Reconcile — push changed subtrees (all entries on first
+	// boot) to eval queue
+	d.reconcile(changed)
+	d.queue.PushMany(replayed)
+	d.queueSyncthingConflicts()
 
 	// Phase 3: Start watcher in background
-	watcher, err := NewWatcher(d.archivesRoot, d.spacesRoot, d.queue)
+	watcher, err := d.newWatcher()
 	if err != nil {
 		l.Error("watcher creation failed, daemon aborting", "err", err)
 		return
 	}
+	if currentConfig().WatchScoped && !watcher.WholeTree() {
+		go d.runWatchScan(ctx, d.watched)
+	}
+	if isRemote(d.spacesRoot) {
+		go d.runRemoteScan(ctx)
+	}
+	if d.blocks != nil {
+		go d.runBlockGC(ctx)
+	}
+	go d.runBackups(ctx)
+	go d.runIOStats(ctx)
+	go d.runTombstonePurge(ctx)
+	go d.runPendingRemovals(ctx)
+	go d.runCanaryCheck(ctx)
 
-	go func() {
-		if err := watcher.Start(ctx); err != nil && ctx.Err() == nil {
-			l.Warn("watcher stopped unexpectedly", "err", err)
-		}
-	}()
+	go d.superviseWatcher(ctx, watcher)
+	go d.runWatchProbe(ctx)
+
+	// Seed and reconcile are done — tell systemd we're up, then keep
+	// the watchdog fed (and detect stalls) for as long as we run.
+	sdNotify("READY=1")
+	d.monitor.Tick()
+	go runWatchdog(ctx, watchdogInterval(), d.monitor)
+	go d.runAutoArchive(ctx)
+	go d.runMetadataWorker(ctx)
+	go d.runContentIndexer(ctx)
+	go d.runSyncthingPauser(ctx, syncthingPauseInterval)
+	if d.lazy != nil {
+		go d.runLazyCompletion(ctx)
+	}
+
+	// Phase 4: Worker loop(s) — process eval queue
+	var wg gosync.WaitGroup
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			d.worker(ctx, id)
+		}(i)
+	}
+	wg.Wait()
+	if err := d.flushIOStats(); err != nil {
+		l.Warn("flush io stats failed", "err", err)
+	}
+	if err := d.flushVerifications(); err != nil {
+		l.Warn("flush verifications failed", "err", err)
+	}
 
-	// Phase 4: Worker loop — process eval queue
+	sdNotify("STOPPING=1")
+	d.watcher.Load().Close()
+	l.Debug("watcher closed")
+	l.Info("sync daemon stopped")
+}
+
+// localRoots returns the directories on local disks the daemon keeps its
+// files in, for low-power mode to check the disks of.
+func (d *Daemon) localRoots() []string {
+	roots := []string{d.archivesRoot}
+	if d.blocks != nil {
+		roots = append(roots, d.spacesRoot, d.blocks.dir)
+	} else if !isRemote(d.spacesRoot) {
+		roots = append(roots, d.spacesRoot)
+	}
+	return roots
+}
+
+// worker pops paths from the eval queue and runs the pipeline on each
+// until ctx is cancelled.
+func (d *Daemon) worker(ctx context.Context, id int) {
+	l := sub("daemon").With("worker", id)
 	l.Info("worker loop started")
 	done := ctx.Done()
 	for {
-		path, ok := d.queue.Pop(done)
+		job, ok := d.queue.PopJob(done)
 		if !ok {
 			l.Info("worker stopping, context cancelled")
-			break
+			return
 		}
+		path := job.Path
+		started := nowFunc()
+		queueWait.add(started.Sub(time.Unix(0, job.QueuedAt)))
 
-		l.Debug("queue pop", "path", path, "queueLen", d.queue.Len())
+		l.Debug("queue pop", "path", path, "from", job.From, "queueLen", d.queue.Len())
 
 		hasQueued := func() bool {
-			return d.queue.Has(path)
+			return d.queue.Has(path) || d.abortRequested(path)
 		}
 
-		if err := RunPipeline(ctx, path, d.store, d.archivesRoot, d.spacesRoot, d.trashRoot, hasQueued); err != nil {
+		jobCtx, span := tracer().Start(trace.ContextWithSpanContext(ctx, job.Trace), "evaluate",
+			trace.WithAttributes(attribute.String("sync.path", path), attribute.Int("sync.worker", id)))
+		var err error
+		if job.From != "" {
+			span.SetAttributes(attribute.String("sync.from", job.From))
+			err = d.runMove(jobCtx, job.From, path)
+		} else {
+			release := d.acquirePath(path)
+			err = RunPipeline(jobCtx, path, d.store, d.archivesRoot, d.spacesRoot, d.trashRoot, hasQueued)
+			if err == nil {
+				d.verifySelection(path)
+			}
+			release()
+		}
+		if d.finishAborted(path, err) {
+			err = nil
+		}
+		if errors.Is(err, ErrSourceUnstable) {
+			span.AddEvent("requeued: source unstable")
+			span.End()
+			retry := unstableRetry()
+			l.Info("file still being written, requeued", "path", path, "retryMs", retry.Milliseconds(), "err", err)
+			d.queue.Finished(path, false)
+			d.queue.PushAfter(path, retry)
+		} else if err != nil {
+			endSpan(span, err)
 			if ctx.Err() != nil {
 				l.Info("worker stopping, context cancelled")
-				break
+				return
 			}
 			l.Error("pipeline failed", "path", path, "err", err)
+			d.queue.Finished(path, false)
+			events.finished(path, false)
+			d.reconciles.finish(path, true)
+			d.jobs.finish(path, true)
+			d.fsckRuns.finish(path, true)
 		} else {
+			span.End()
 			l.Debug("pipeline ok", "path", path)
+			d.queue.Finished(path, true)
+			events.finished(path, true)
+			d.reconciles.finish(path, false)
+			d.jobs.finish(path, false)
+			d.fsckRuns.finish(path, false)
 		}
+		runTime.add(nowFunc().Sub(started))
+		itemsMeter.Add(1)
+		d.monitor.Tick()
 	}
+}
 
-	watcher.Close()
-	l.Debug("watcher closed")
-	l.Info("sync daemon stopped")
+// dropped tells what waits on relPath, dropped from the queue, that it
+// will not run: the bulk event, reconcile, selection job and repair it
+// belongs to count it as failed.
+func (d *Daemon) dropped(relPath string) {
+	events.finished(relPath, false)
+	d.reconciles.finish(relPath, true)
+	d.jobs.finish(relPath, true)
+	d.fsckRuns.finish(relPath, true)
+}
+
+// acquirePath ensures a path is evaluated by at most one worker at a time.
+// It blocks while another worker holds the path and returns a release func.
+func (d *Daemon) acquirePath(path string) func() {
+	for {
+		d.inflightMu.Lock()
+		busy, held := d.inflight[path]
+		if !held {
+			ch := make(chan struct{})
+			d.inflight[path] = ch
+			d.inflightMu.Unlock()
+			return func() {
+				d.inflightMu.Lock()
+				delete(d.inflight, path)
+				d.inflightMu.Unlock()
+				close(ch)
+			}
+		}
+		d.inflightMu.Unlock()
+		<-busy
+	}
 }
 
-// fullReconcile pushes all known entries to the eval queue for re-evaluation.
-// This handles any state drift that occurred during downtime.
-// Spaces-only files are already handled by Seed (SafeCopy S→A + INSERT),
-// so reconcile only needs to iterate DB entries.
+// acquirePaths holds two paths at once, taking them in a fixed order so
+// concurrent moves can't deadlock.
+func (d *Daemon) acquirePaths(a, b string) func() {
+	if a > b {
+		a, b = b, a
+	}
+	releaseA := d.acquirePath(a)
+	if a == b {
+		return releaseA
+	}
+	releaseB := d.acquirePath(b)
+	return func() {
+		releaseB()
+		releaseA()
+	}
+}
+
+// fullReconcile pushes the known entries whose state drifted to the eval
+// queue for re-evaluation. This handles any state drift that occurred
+// during downtime. Spaces-only files are already handled by Seed
+// (SafeCopy S→A + INSERT), so reconcile only needs to iterate DB entries.
+// Drift is judged against an index of each root built by one walk (see
+// reconcileindex.go); should a walk fail, every entry is queued.
 func (d *Daemon) fullReconcile() {
 	l := sub("daemon")
 	l.Info("full reconcile starting")
 
-	d.reconcileChildren(0, "")
+	if archives, spaces, err := d.reconcileIndexes(); err != nil {
+		l.Warn("reconcile index failed, queueing every entry", "err", err)
+		d.reconcileChildren(0, "")
+	} else {
+		d.reconcileIndexed(archives, spaces)
+	}
 
 	l.Info("full reconcile complete", "queued", d.queue.Len())
 }
 
 func (d *Daemon) reconcileChildren(parentIno uint64, parentPath string) {
 	l := sub("daemon")
-	children, err := d.store.ListChildren(parentIno)
-	if err != nil {
+	err := d.store.walkTree(parentIno, parentPath, func(child *Entry, relPath string) (bool, error) {
+		d.queue.PushSized(relPath, sizeOrZero(child.Size), child.Type == "dir")
+		d.pathCache.Set(child.Inode, relPath)
+		l.Debug("reconcile queued", "path", relPath, "inode", child.Inode, "type", child.Type)
+		return true, nil
+	})
+	if errors.Is(err, errTreeTruncated) {
+		l.Warn("reconcile queued part of the tree", "parentIno", parentIno)
+	} else if err != nil {
 		l.Error("reconcile list failed", "parentIno", parentIno, "err", err)
+	}
+}
+
+// reconcile pushes the entries of directories whose seed fingerprint
+// changed, plus every entry with pending selection work, to the eval
+// queue. Unchanged subtrees are skipped entirely. A nil changed set falls
+// back to fullReconcile.
+func (d *Daemon) reconcile(changed map[string]bool) {
+	if changed == nil {
+		d.fullReconcile()
 		return
 	}
+	l := sub("daemon")
+	l.Info("incremental reconcile starting", "changedDirs", len(changed))
 
-	for _, child := range children {
-		relPath := child.Name
-		if parentPath != "" {
-			relPath = parentPath + "/" + child.Name
+	// A subtree needs a visit if it contains a changed directory.
+	visit := make(map[string]bool, len(changed))
+	for dir := range changed {
+		for !visit[dir] {
+			visit[dir] = true
+			if dir == "" {
+				break
+			}
+			if dir = path.Dir(dir); dir == "." {
+				dir = ""
+			}
 		}
+	}
+	d.reconcileChanged(0, "", changed, visit)
 
-		d.queue.Push(relPath)
-		d.pathCache.Set(child.Inode, relPath)
-		l.Debug("reconcile queued", "path", relPath, "inode", child.Inode, "type", child.Type)
+	unconverged, err := d.store.ListUnconverged()
+	if err != nil {
+		l.Error("reconcile list unconverged failed", "err", err)
+	}
+	for i := range unconverged {
+		e := &unconverged[i]
+		d.queue.PushSized(d.store.RelPath(e), sizeOrZero(e.Size), e.Type == "dir")
+	}
+
+	l.Info("incremental reconcile complete", "unconverged", len(unconverged), "queued", d.queue.Len())
+}
 
-		if child.Type == "dir" {
-			d.reconcileChildren(child.Inode, relPath)
+func (d *Daemon) reconcileChanged(parentIno uint64, parentPath string, changed, visit map[string]bool) {
+	if !visit[parentPath] {
+		return
+	}
+	err := d.store.walkTree(parentIno, parentPath, func(child *Entry, relPath string) (bool, error) {
+		if dir := path.Dir(relPath); changed[dir] || (dir == "." && changed[""]) {
+			d.queue.PushSized(relPath, sizeOrZero(child.Size), child.Type == "dir")
+			d.pathCache.Set(child.Inode, relPath)
 		}
+		return visit[relPath], nil
+	})
+	if errors.Is(err, errTreeTruncated) {
+		sub("daemon").Warn("reconcile queued part of the tree", "parentIno", parentIno)
+	} else if err != nil {
+		sub("daemon").Error("reconcile list failed", "parentIno", parentIno, "err", err)
 	}
 }
@@ -128,3 +128,58 @@ func TestDaemon_SpacesOnlyColdStart(t *testing.T) {
 	}
 	assert.True(t, found, "spoke.txt should be registered in DB")
 }
+
+func TestDaemon_IncrementalReconcile(t *testing.T) {
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	for _, p := range []string{"a", "b"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, p), 0755))
+	}
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "a", "x.txt"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "b", "y.txt"), []byte("y"), 0644))
+
+	store := setupTestDB(t)
+	daemon := NewDaemon(store, archivesRoot, spacesRoot)
+
+	changed, err := seed(store, archivesRoot, spacesRoot, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, changed, "first boot has nothing to compare against")
+	daemon.reconcile(changed)
+	assert.Empty(t, daemon.queue.Drain(), "first boot reconciles everything, and the seeded tree is settled")
+
+	changed, err = seed(store, archivesRoot, spacesRoot, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	daemon.reconcile(changed)
+	assert.Empty(t, daemon.queue.Drain(), "unchanged tree queues nothing")
+
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "b", "z.txt"), []byte("z"), 0644))
+	changed, err = seed(store, archivesRoot, spacesRoot, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"b": true}, changed)
+	daemon.reconcile(changed)
+	assert.ElementsMatch(t, []string{"b/y.txt", "b/z.txt"}, daemon.queue.Drain())
+
+	// Rewritten in place keeping its mtime, as a restore may: the latest
+	// mtime and the child count stay as they were.
+	xPath := filepath.Join(archivesRoot, "a", "x.txt")
+	info, err := os.Stat(xPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(xPath, []byte("restored"), 0644))
+	require.NoError(t, os.Chtimes(xPath, time.Now(), info.ModTime()))
+	changed, err = seed(store, archivesRoot, spacesRoot, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a": true}, changed)
+	daemon.reconcile(changed)
+	assert.Equal(t, []string{"a/x.txt"}, daemon.queue.Drain())
+
+	// Selected without a Spaces copy: queued even though nothing changed.
+	x, _, err := lookupDB(store, archivesRoot, "a/x.txt")
+	require.NoError(t, err)
+	require.NotNil(t, x)
+	require.NoError(t, store.SetSelected([]uint64{x.Inode}, true))
+	daemon.reconcile(map[string]bool{})
+	assert.Equal(t, []string{"a/x.txt"}, daemon.queue.Drain())
+}
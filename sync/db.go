@@ -2,14 +2,18 @@ package sync
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-const schemaVersion = 3
+const schemaVersion = 32
 
 const schema = `
 CREATE TABLE IF NOT EXISTS entries (
@@ -20,13 +24,272 @@ CREATE TABLE IF NOT EXISTS entries (
     size       INTEGER,
     mtime      INTEGER NOT NULL,
     selected   INTEGER NOT NULL DEFAULT 0,
+    excluded   INTEGER NOT NULL DEFAULT 0,
+    starred    INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    deleted_at INTEGER NOT NULL DEFAULT 0,
     UNIQUE(parent_ino, name)
 );
 
+CREATE INDEX IF NOT EXISTS idx_entries_starred ON entries(starred) WHERE starred = 1;
+CREATE INDEX IF NOT EXISTS idx_entries_deleted ON entries(deleted_at) WHERE deleted_at != 0;
+
+-- An inode registered at a new path is not the tombstone's file any more
+-- (or it moved while unwatched): drop the tombstone so the insert succeeds.
+CREATE TRIGGER IF NOT EXISTS entries_reuse_tombstone BEFORE INSERT ON entries BEGIN
+    DELETE FROM entries WHERE inode = NEW.inode AND deleted_at != 0
+        AND (parent_ino != NEW.parent_ino OR name != NEW.name);
+END;
+
 CREATE TABLE IF NOT EXISTS spaces_view (
     entry_ino    INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
     synced_mtime INTEGER NOT NULL,
-    checked_at   INTEGER NOT NULL
+    checked_at   INTEGER NOT NULL,
+    cipher       TEXT NOT NULL DEFAULT '',
+    compression  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    time   INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    path   TEXT NOT NULL,
+    inode  INTEGER NOT NULL DEFAULT 0,
+    detail TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log(time);
+
+CREATE TABLE IF NOT EXISTS metadata (
+    entry_ino    INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    width        INTEGER,
+    height       INTEGER,
+    duration_ms  INTEGER,
+    taken_at     INTEGER,
+    extracted_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE
+);
+
+CREATE TABLE IF NOT EXISTS entry_tags (
+    entry_ino INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    tag_id    INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (entry_ino, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_entry_tags_tag ON entry_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS journal (
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    time   INTEGER NOT NULL,
+    op     TEXT NOT NULL,
+    path   TEXT NOT NULL,
+    inode  INTEGER NOT NULL DEFAULT 0,
+    target TEXT NOT NULL DEFAULT '',
+    done   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS conflicts (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    time          INTEGER NOT NULL,
+    path          TEXT NOT NULL,
+    conflict_ino  INTEGER NOT NULL,
+    conflict_name TEXT NOT NULL,
+    winner_ino    INTEGER NOT NULL DEFAULT 0,
+    resolution    TEXT NOT NULL DEFAULT '',
+    resolved_at   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS dir_fingerprints (
+    path     TEXT PRIMARY KEY,
+    hash     INTEGER NOT NULL,
+    children INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS placeholders (
+    entry_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS spokes (
+    id            TEXT PRIMARY KEY,
+    name          TEXT NOT NULL,
+    token_hash    TEXT NOT NULL UNIQUE,
+    registered_at INTEGER NOT NULL,
+    last_seen     INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS spoke_selections (
+    spoke_id  TEXT NOT NULL REFERENCES spokes(id) ON DELETE CASCADE,
+    entry_ino INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (spoke_id, entry_ino)
+);
+
+CREATE TABLE IF NOT EXISTS spoke_views (
+    spoke_id     TEXT NOT NULL REFERENCES spokes(id) ON DELETE CASCADE,
+    entry_ino    INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    synced_mtime INTEGER NOT NULL,
+    checked_at   INTEGER NOT NULL,
+    PRIMARY KEY (spoke_id, entry_ino)
+);
+
+CREATE TABLE IF NOT EXISTS backup_jobs (
+    id         TEXT PRIMARY KEY,
+    target     TEXT NOT NULL,
+    target_dev INTEGER NOT NULL,
+    rules      TEXT NOT NULL DEFAULT '',
+    state      TEXT NOT NULL,
+    error      TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS backup_files (
+    job_id TEXT NOT NULL REFERENCES backup_jobs(id) ON DELETE CASCADE,
+    path   TEXT NOT NULL,
+    size   INTEGER NOT NULL,
+    mtime  INTEGER NOT NULL,
+    state  TEXT NOT NULL,
+    error  TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (job_id, path)
+);
+
+CREATE TABLE IF NOT EXISTS io_stats (
+    day       TEXT NOT NULL,
+    root      TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    bytes     INTEGER NOT NULL DEFAULT 0,
+    files     INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, root, direction)
+);
+
+CREATE TABLE IF NOT EXISTS selection_ops (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    time     INTEGER NOT NULL,
+    selected INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS selection_op_entries (
+    op_id      INTEGER NOT NULL REFERENCES selection_ops(id) ON DELETE CASCADE,
+    entry_ino  INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    trash_path TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (op_id, entry_ino)
+);
+
+CREATE INDEX IF NOT EXISTS idx_selection_op_entries_ino ON selection_op_entries(entry_ino);
+
+CREATE TABLE IF NOT EXISTS events (
+    id    INTEGER PRIMARY KEY AUTOINCREMENT,
+    time  INTEGER NOT NULL,
+    type  TEXT NOT NULL,
+    path  TEXT NOT NULL DEFAULT '',
+    inode INTEGER NOT NULL DEFAULT 0,
+    data  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_time ON events(time);
+
+CREATE TABLE IF NOT EXISTS content_docs (
+    entry_ino  INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    mtime      INTEGER NOT NULL,
+    indexed_at INTEGER NOT NULL
+);
+
+-- Text of the indexed documents, keyed by entry inode; content_docs says
+-- which mtime each was extracted at.
+CREATE VIRTUAL TABLE IF NOT EXISTS content_fts USING fts5(body, tokenize = 'unicode61 remove_diacritics 2');
+
+CREATE TRIGGER IF NOT EXISTS content_docs_delete AFTER DELETE ON content_docs BEGIN
+    DELETE FROM content_fts WHERE rowid = OLD.entry_ino;
+END;
+
+-- The text is keyed by the old inode: drop the doc so it is reindexed.
+CREATE TRIGGER IF NOT EXISTS content_docs_reinode AFTER UPDATE OF entry_ino ON content_docs BEGIN
+    DELETE FROM content_fts WHERE rowid = OLD.entry_ino;
+    DELETE FROM content_docs WHERE entry_ino = NEW.entry_ino;
+END;
+
+CREATE TABLE IF NOT EXISTS derivatives (
+    entry_ino    INTEGER PRIMARY KEY REFERENCES spaces_view(entry_ino) ON UPDATE CASCADE ON DELETE CASCADE,
+    original     TEXT NOT NULL,
+    transcode    TEXT NOT NULL,
+    source_mtime INTEGER NOT NULL,
+    created_at   INTEGER NOT NULL
+);
+
+-- Filters narrowing the selection of a directory, as SelectFilter JSON.
+CREATE TABLE IF NOT EXISTS selection_filters (
+    dir_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    filter  TEXT NOT NULL
+);
+
+-- New Spaces files the new-file policy holds from Archives, by Spaces path.
+CREATE TABLE IF NOT EXISTS unconfirmed (
+    path    TEXT PRIMARY KEY,
+    size    INTEGER NOT NULL,
+    mtime   INTEGER NOT NULL,
+    status  TEXT NOT NULL,
+    seen_at INTEGER NOT NULL
+);
+
+-- Spaces copies of deselected entries left in place for the grace period.
+CREATE TABLE IF NOT EXISTS pending_removals (
+    entry_ino INTEGER PRIMARY KEY REFERENCES spaces_view(entry_ino) ON UPDATE CASCADE ON DELETE CASCADE,
+    due_at    INTEGER NOT NULL
+);
+
+-- Spaces items moved to the trash, by their original paths.
+CREATE TABLE IF NOT EXISTS trash (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    rel_path   TEXT NOT NULL,
+    entry_ino  INTEGER NOT NULL DEFAULT 0,
+    size       INTEGER NOT NULL DEFAULT 0,
+    mtime      INTEGER NOT NULL DEFAULT 0,
+    is_dir     INTEGER NOT NULL DEFAULT 0,
+    trash_path TEXT NOT NULL UNIQUE,
+    trashed_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trash_rel_path ON trash(rel_path);
+
+-- The daemon using the database, renewed by its heartbeat.
+CREATE TABLE IF NOT EXISTS instance_lock (
+    id           INTEGER PRIMARY KEY CHECK (id = 1),
+    owner        TEXT NOT NULL,
+    host         TEXT NOT NULL,
+    pid          INTEGER NOT NULL,
+    started_at   INTEGER NOT NULL,
+    heartbeat_at INTEGER NOT NULL
+);
+
+-- Directories whose new entries are selected as they appear.
+CREATE TABLE IF NOT EXISTS auto_select_dirs (
+    dir_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- Outcome of verifying the latest copy of an entry, when it was sampled.
+CREATE TABLE IF NOT EXISTS copy_verifications (
+    entry_ino   INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    ok          INTEGER NOT NULL,
+    direction   TEXT NOT NULL,
+    verified_at INTEGER NOT NULL
+);
+
+-- Selected files over maxAutoCopyMB, waiting for or given confirmation
+-- before they are copied into Spaces.
+CREATE TABLE IF NOT EXISTS large_copies (
+    entry_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+    size      INTEGER NOT NULL,
+    confirmed INTEGER NOT NULL DEFAULT 0,
+    seen_at   INTEGER NOT NULL
+);
+
+-- Canaries the daemon placed in Spaces, by path.
+CREATE TABLE IF NOT EXISTS canaries (
+    rel_path TEXT PRIMARY KEY
 );
 
 CREATE TABLE IF NOT EXISTS meta (
@@ -35,42 +298,130 @@ CREATE TABLE IF NOT EXISTS meta (
 );
 `
 
+// dbReadConns is the size of the read-only connection pool. Writes go
+// through a single connection, so API reads never queue behind them.
+const dbReadConns = 4
+
+// dbBusyTimeout is how long SQLite itself waits on a locked database
+// before returning SQLITE_BUSY.
+const dbBusyTimeout = 5 * time.Second
+
+// busyRetries and busyBackoff bound the retries of a write that still
+// found the database locked: backoff doubles from busyBackoff each time.
+const (
+	busyRetries = 3
+	busyBackoff = 50 * time.Millisecond
+)
+
+// DB is the sync database: the embedded *sql.DB is the single writer
+// connection, and read serves queries from a pool of read-only ones.
+// Both run in WAL mode, so readers see every committed write.
+type DB struct {
+	*sql.DB
+	read *sql.DB
+}
+
+// Close closes both pools.
+func (db *DB) Close() error {
+	return errors.Join(db.read.Close(), db.DB.Close())
+}
+
 // OpenDB opens (or creates) the sync SQLite database next to the given
 // filebrowser database path.
-func OpenDB(filebrowserDBPath string) (*sql.DB, error) {
+func OpenDB(filebrowserDBPath string) (*DB, error) {
 	dir := filepath.Dir(filebrowserDBPath)
 	dbPath := filepath.Join(dir, "sync.db")
 	return openDBAt(dbPath)
 }
 
 // openDBAt opens the database at the exact path. Useful for testing.
-func openDBAt(dbPath string) (*sql.DB, error) {
+func openDBAt(dbPath string) (*DB, error) {
 	l := sub("db")
 	l.Info("opening sync database", "path", dbPath)
 
-	db, err := sql.Open("sqlite", dbPath)
+	// Pragmas in the DSN apply to every connection the pool opens, not
+	// just the one that happens to run an Exec.
+	busy := fmt.Sprintf("_pragma=busy_timeout(%d)", dbBusyTimeout.Milliseconds())
+	w, err := sql.Open("sqlite", dbPath+"?"+busy+"&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("open sync db: %w", err)
 	}
+	w.SetMaxOpenConns(1)
+	if err := w.Ping(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("open sync db: %w", err)
+	}
+	l.Debug("writer opened", "pragmas", "foreign_keys=ON journal_mode=WAL", "txlock", "immediate")
+
+	if err := migrate(w); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
 
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("enable foreign keys: %w", err)
+	r, err := sql.Open("sqlite", dbPath+"?"+busy+"&_pragma=query_only(1)")
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("open sync db readers: %w", err)
 	}
-	l.Debug("PRAGMA foreign_keys=ON")
+	r.SetMaxOpenConns(dbReadConns)
+	r.SetMaxIdleConns(dbReadConns)
+	l.Debug("readers opened", "conns", dbReadConns)
+
+	return &DB{DB: w, read: r}, nil
+}
 
-	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("set WAL mode: %w", err)
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED.
+func isBusy(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
 	}
-	l.Debug("PRAGMA journal_mode=WAL")
+	code := se.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
 
-	if err := migrate(db); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("migrate: %w", err)
+// busyMetrics counts writes that found the database locked.
+type busyMetrics struct {
+	busy    atomic.Int64 // SQLITE_BUSY results, retried or not
+	retries atomic.Int64
+	backoff atomic.Int64 // nanoseconds slept between retries
+}
+
+var dbBusy busyMetrics
+
+// retryBusy runs op, retrying with exponential backoff while it fails
+// with SQLITE_BUSY, up to busyRetries times.
+func retryBusy(op func() error) error {
+	wait := busyBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !isBusy(err) {
+			return err
+		}
+		dbBusy.busy.Add(1)
+		if attempt == busyRetries {
+			sub("db").Warn("database busy, giving up", "attempts", attempt+1, "err", err)
+			return err
+		}
+		sub("db").Debug("database busy, backing off", "attempt", attempt+1, "wait", wait)
+		dbBusy.retries.Add(1)
+		dbBusy.backoff.Add(int64(wait))
+		time.Sleep(wait)
+		wait *= 2
 	}
+}
 
-	return db, nil
+// DBStats reports contention on the sync database.
+type DBStats struct {
+	ReadOpen    int   `json:"readOpen"`
+	ReadInUse   int   `json:"readInUse"`
+	ReadWaits   int64 `json:"readWaits"` // queries that waited for a free reader
+	ReadWaitMs  int64 `json:"readWaitMs"`
+	WriteWaits  int64 `json:"writeWaits"` // writes that waited for the writer
+	WriteWaitMs int64 `json:"writeWaitMs"`
+	Busy        int64 `json:"busy"` // writes that found the database locked
+	BusyRetries int64 `json:"busyRetries"`
+	BackoffMs   int64 `json:"backoffMs"`
 }
 
 func migrate(db *sql.DB) error {
@@ -104,6 +455,180 @@ func migrate(db *sql.DB) error {
 			}
 			l.Info("migrated v2→v3")
 		}
+		if version < 4 {
+			if err := migrateV3toV4(db); err != nil {
+				return fmt.Errorf("migrate v3→v4: %w", err)
+			}
+			l.Info("migrated v3→v4")
+		}
+		if version < 5 {
+			if err := migrateV4toV5(db); err != nil {
+				return fmt.Errorf("migrate v4→v5: %w", err)
+			}
+			l.Info("migrated v4→v5")
+		}
+		if version < 6 {
+			if err := migrateV5toV6(db); err != nil {
+				return fmt.Errorf("migrate v5→v6: %w", err)
+			}
+			l.Info("migrated v5→v6")
+		}
+		if version < 7 {
+			if err := migrateV6toV7(db); err != nil {
+				return fmt.Errorf("migrate v6→v7: %w", err)
+			}
+			l.Info("migrated v6→v7")
+		}
+		if version < 8 {
+			if err := migrateV7toV8(db); err != nil {
+				return fmt.Errorf("migrate v7→v8: %w", err)
+			}
+			l.Info("migrated v7→v8")
+		}
+		if version < 9 {
+			if err := migrateV8toV9(db); err != nil {
+				return fmt.Errorf("migrate v8→v9: %w", err)
+			}
+			l.Info("migrated v8→v9")
+		}
+		if version < 10 {
+			if err := migrateV9toV10(db); err != nil {
+				return fmt.Errorf("migrate v9→v10: %w", err)
+			}
+			l.Info("migrated v9→v10")
+		}
+		if version < 11 {
+			if err := migrateV10toV11(db); err != nil {
+				return fmt.Errorf("migrate v10→v11: %w", err)
+			}
+			l.Info("migrated v10→v11")
+		}
+		if version < 12 {
+			if err := migrateV11toV12(db); err != nil {
+				return fmt.Errorf("migrate v11→v12: %w", err)
+			}
+			l.Info("migrated v11→v12")
+		}
+		if version < 13 {
+			if err := migrateV12toV13(db); err != nil {
+				return fmt.Errorf("migrate v12→v13: %w", err)
+			}
+			l.Info("migrated v12→v13")
+		}
+		if version < 14 {
+			if err := migrateV13toV14(db); err != nil {
+				return fmt.Errorf("migrate v13→v14: %w", err)
+			}
+			l.Info("migrated v13→v14")
+		}
+		if version < 15 {
+			if err := migrateV14toV15(db); err != nil {
+				return fmt.Errorf("migrate v14→v15: %w", err)
+			}
+			l.Info("migrated v14→v15")
+		}
+		if version < 16 {
+			if err := migrateV15toV16(db); err != nil {
+				return fmt.Errorf("migrate v15→v16: %w", err)
+			}
+			l.Info("migrated v15→v16")
+		}
+		if version < 17 {
+			if err := migrateV16toV17(db); err != nil {
+				return fmt.Errorf("migrate v16→v17: %w", err)
+			}
+			l.Info("migrated v16→v17")
+		}
+		if version < 18 {
+			if err := migrateV17toV18(db); err != nil {
+				return fmt.Errorf("migrate v17→v18: %w", err)
+			}
+			l.Info("migrated v17→v18")
+		}
+		if version < 19 {
+			if err := migrateV18toV19(db); err != nil {
+				return fmt.Errorf("migrate v18→v19: %w", err)
+			}
+			l.Info("migrated v18→v19")
+		}
+		if version < 20 {
+			if err := migrateV19toV20(db); err != nil {
+				return fmt.Errorf("migrate v19→v20: %w", err)
+			}
+			l.Info("migrated v19→v20")
+		}
+		if version < 21 {
+			if err := migrateV20toV21(db); err != nil {
+				return fmt.Errorf("migrate v20→v21: %w", err)
+			}
+			l.Info("migrated v20→v21")
+		}
+		if version < 22 {
+			if err := migrateV21toV22(db); err != nil {
+				return fmt.Errorf("migrate v21→v22: %w", err)
+			}
+			l.Info("migrated v21→v22")
+		}
+		if version < 23 {
+			if err := migrateV22toV23(db); err != nil {
+				return fmt.Errorf("migrate v22→v23: %w", err)
+			}
+			l.Info("migrated v22→v23")
+		}
+		if version < 24 {
+			if err := migrateV23toV24(db); err != nil {
+				return fmt.Errorf("migrate v23→v24: %w", err)
+			}
+			l.Info("migrated v23→v24")
+		}
+		if version < 25 {
+			if err := migrateV24toV25(db); err != nil {
+				return fmt.Errorf("migrate v24→v25: %w", err)
+			}
+			l.Info("migrated v24→v25")
+		}
+		if version < 26 {
+			if err := migrateV25toV26(db); err != nil {
+				return fmt.Errorf("migrate v25→v26: %w", err)
+			}
+			l.Info("migrated v25→v26")
+		}
+		if version < 27 {
+			if err := migrateV26toV27(db); err != nil {
+				return fmt.Errorf("migrate v26→v27: %w", err)
+			}
+			l.Info("migrated v26→v27")
+		}
+		if version < 28 {
+			if err := migrateV27toV28(db); err != nil {
+				return fmt.Errorf("migrate v27→v28: %w", err)
+			}
+			l.Info("migrated v27→v28")
+		}
+		if version < 29 {
+			if err := migrateV28toV29(db); err != nil {
+				return fmt.Errorf("migrate v28→v29: %w", err)
+			}
+			l.Info("migrated v28→v29")
+		}
+		if version < 30 {
+			if err := migrateV29toV30(db); err != nil {
+				return fmt.Errorf("migrate v29→v30: %w", err)
+			}
+			l.Info("migrated v29→v30")
+		}
+		if version < 31 {
+			if err := migrateV30toV31(db); err != nil {
+				return fmt.Errorf("migrate v30→v31: %w", err)
+			}
+			l.Info("migrated v30→v31")
+		}
+		if version < 32 {
+			if err := migrateV31toV32(db); err != nil {
+				return fmt.Errorf("migrate v31→v32: %w", err)
+			}
+			l.Info("migrated v31→v32")
+		}
 	} else {
 		l.Debug("schema up to date", slog.Int("version", version))
 	}
@@ -206,3 +731,828 @@ func migrateV2toV3(db *sql.DB) error {
 
 	return tx.Commit()
 }
+
+func migrateV3toV4(db *sql.DB) error {
+	// Add per-entry exclude flag.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`ALTER TABLE entries ADD COLUMN excluded INTEGER NOT NULL DEFAULT 0`,
+		`UPDATE meta SET value = '4' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV4toV5(db *sql.DB) error {
+	// Add the audit log.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE audit_log (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			time   INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			path   TEXT NOT NULL,
+			inode  INTEGER NOT NULL DEFAULT 0,
+			detail TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX idx_audit_log_time ON audit_log(time)`,
+		`UPDATE meta SET value = '5' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV5toV6(db *sql.DB) error {
+	// Add extracted media metadata.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE metadata (
+			entry_ino    INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			width        INTEGER,
+			height       INTEGER,
+			duration_ms  INTEGER,
+			taken_at     INTEGER,
+			extracted_at INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '6' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV6toV7(db *sql.DB) error {
+	// Add the user-settable starred flag.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`ALTER TABLE entries ADD COLUMN starred INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX idx_entries_starred ON entries(starred) WHERE starred = 1`,
+		`UPDATE meta SET value = '7' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV7toV8(db *sql.DB) error {
+	// Add free-form entry tags.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE tags (
+			id   INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE
+		)`,
+		`CREATE TABLE entry_tags (
+			entry_ino INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			tag_id    INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (entry_ino, tag_id)
+		)`,
+		`CREATE INDEX idx_entry_tags_tag ON entry_tags(tag_id)`,
+		`UPDATE meta SET value = '8' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV8toV9(db *sql.DB) error {
+	// Add the pipeline intent journal.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE journal (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			time   INTEGER NOT NULL,
+			op     TEXT NOT NULL,
+			path   TEXT NOT NULL,
+			inode  INTEGER NOT NULL DEFAULT 0,
+			target TEXT NOT NULL DEFAULT '',
+			done   INTEGER NOT NULL DEFAULT 0
+		)`,
+		`UPDATE meta SET value = '9' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV9toV10(db *sql.DB) error {
+	// Add conflict lineage.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE conflicts (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			time          INTEGER NOT NULL,
+			path          TEXT NOT NULL,
+			conflict_ino  INTEGER NOT NULL,
+			conflict_name TEXT NOT NULL,
+			winner_ino    INTEGER NOT NULL DEFAULT 0,
+			resolution    TEXT NOT NULL DEFAULT '',
+			resolved_at   INTEGER NOT NULL DEFAULT 0
+		)`,
+		`UPDATE meta SET value = '10' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV10toV11(db *sql.DB) error {
+	// Add per-directory scan fingerprints for incremental reconcile.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE dir_fingerprints (
+			path     TEXT PRIMARY KEY,
+			mtime    INTEGER NOT NULL,
+			children INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '11' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV11toV12(db *sql.DB) error {
+	// Add placeholder tracking for on-demand hydration.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE placeholders (
+			entry_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE
+		)`,
+		`UPDATE meta SET value = '12' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV12toV13(db *sql.DB) error {
+	// Add spokes with their own selections and reported copies.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE spokes (
+			id            TEXT PRIMARY KEY,
+			name          TEXT NOT NULL,
+			token_hash    TEXT NOT NULL UNIQUE,
+			registered_at INTEGER NOT NULL,
+			last_seen     INTEGER NOT NULL
+		)`,
+		`CREATE TABLE spoke_selections (
+			spoke_id  TEXT NOT NULL REFERENCES spokes(id) ON DELETE CASCADE,
+			entry_ino INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			PRIMARY KEY (spoke_id, entry_ino)
+		)`,
+		`CREATE TABLE spoke_views (
+			spoke_id     TEXT NOT NULL REFERENCES spokes(id) ON DELETE CASCADE,
+			entry_ino    INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			synced_mtime INTEGER NOT NULL,
+			checked_at   INTEGER NOT NULL,
+			PRIMARY KEY (spoke_id, entry_ino)
+		)`,
+		`UPDATE meta SET value = '13' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV13toV14(db *sql.DB) error {
+	// Record which Spaces copies are encrypted, and with which key.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`ALTER TABLE spaces_view ADD COLUMN cipher TEXT NOT NULL DEFAULT ''`,
+		`UPDATE meta SET value = '14' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV14toV15(db *sql.DB) error {
+	// Record which Spaces copies are compressed.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`ALTER TABLE spaces_view ADD COLUMN compression TEXT NOT NULL DEFAULT ''`,
+		`UPDATE meta SET value = '15' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV15toV16(db *sql.DB) error {
+	// Add backup jobs with the files each one exports.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE backup_jobs (
+			id         TEXT PRIMARY KEY,
+			target     TEXT NOT NULL,
+			target_dev INTEGER NOT NULL,
+			rules      TEXT NOT NULL DEFAULT '',
+			state      TEXT NOT NULL,
+			error      TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE backup_files (
+			job_id TEXT NOT NULL REFERENCES backup_jobs(id) ON DELETE CASCADE,
+			path   TEXT NOT NULL,
+			size   INTEGER NOT NULL,
+			mtime  INTEGER NOT NULL,
+			state  TEXT NOT NULL,
+			error  TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (job_id, path)
+		)`,
+		`UPDATE meta SET value = '16' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV16toV17(db *sql.DB) error {
+	// Add daily copy counters per top-level directory and direction.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE io_stats (
+			day       TEXT NOT NULL,
+			root      TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			bytes     INTEGER NOT NULL DEFAULT 0,
+			files     INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, root, direction)
+		)`,
+		`UPDATE meta SET value = '17' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV17toV18(db *sql.DB) error {
+	// Track when entries were registered and last changed, and keep lost
+	// entries as tombstones until maintenance purges them. Existing rows
+	// take their mtime as the best guess for both times.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`ALTER TABLE entries ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE entries ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE entries ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0`,
+		`UPDATE entries SET created_at = mtime, updated_at = mtime`,
+		`CREATE INDEX idx_entries_deleted ON entries(deleted_at) WHERE deleted_at != 0`,
+		`CREATE TRIGGER entries_reuse_tombstone BEFORE INSERT ON entries BEGIN
+			DELETE FROM entries WHERE inode = NEW.inode AND deleted_at != 0
+				AND (parent_ino != NEW.parent_ino OR name != NEW.name);
+		END`,
+		`UPDATE meta SET value = '18' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV18toV19(db *sql.DB) error {
+	// Log user selection changes so the last one can be undone.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE selection_ops (
+			id       INTEGER PRIMARY KEY AUTOINCREMENT,
+			time     INTEGER NOT NULL,
+			selected INTEGER NOT NULL
+		)`,
+		`CREATE TABLE selection_op_entries (
+			op_id      INTEGER NOT NULL REFERENCES selection_ops(id) ON DELETE CASCADE,
+			entry_ino  INTEGER NOT NULL REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			trash_path TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (op_id, entry_ino)
+		)`,
+		`CREATE INDEX idx_selection_op_entries_ino ON selection_op_entries(entry_ino)`,
+		`UPDATE meta SET value = '19' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV19toV20(db *sql.DB) error {
+	// Keep recent events so SSE clients can catch up on reconnect.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE events (
+			id    INTEGER PRIMARY KEY AUTOINCREMENT,
+			time  INTEGER NOT NULL,
+			type  TEXT NOT NULL,
+			path  TEXT NOT NULL DEFAULT '',
+			inode INTEGER NOT NULL DEFAULT 0,
+			data  TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX idx_events_time ON events(time)`,
+		`UPDATE meta SET value = '20' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV20toV21(db *sql.DB) error {
+	// Full-text index of synced documents.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE content_docs (
+			entry_ino  INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			mtime      INTEGER NOT NULL,
+			indexed_at INTEGER NOT NULL
+		)`,
+		`CREATE VIRTUAL TABLE content_fts USING fts5(body, tokenize = 'unicode61 remove_diacritics 2')`,
+		`CREATE TRIGGER content_docs_delete AFTER DELETE ON content_docs BEGIN
+			DELETE FROM content_fts WHERE rowid = OLD.entry_ino;
+		END`,
+		`CREATE TRIGGER content_docs_reinode AFTER UPDATE OF entry_ino ON content_docs BEGIN
+			DELETE FROM content_fts WHERE rowid = OLD.entry_ino;
+			DELETE FROM content_docs WHERE entry_ino = NEW.entry_ino;
+		END`,
+		`UPDATE meta SET value = '21' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV21toV22(db *sql.DB) error {
+	// Spaces copies made by transcode rules, linked to their originals.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE derivatives (
+			entry_ino    INTEGER PRIMARY KEY REFERENCES spaces_view(entry_ino) ON UPDATE CASCADE ON DELETE CASCADE,
+			original     TEXT NOT NULL,
+			transcode    TEXT NOT NULL,
+			source_mtime INTEGER NOT NULL,
+			created_at   INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '22' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV22toV23(db *sql.DB) error {
+	// Filters narrowing folder selections to some file types.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE selection_filters (
+			dir_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			filter  TEXT NOT NULL
+		)`,
+		`UPDATE meta SET value = '23' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV23toV24(db *sql.DB) error {
+	// New Spaces files held for confirmation before they are archived.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE unconfirmed (
+			path    TEXT PRIMARY KEY,
+			size    INTEGER NOT NULL,
+			mtime   INTEGER NOT NULL,
+			status  TEXT NOT NULL,
+			seen_at INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '24' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV24toV25(db *sql.DB) error {
+	// Deselect removals waiting out the grace period.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE pending_removals (
+			entry_ino INTEGER PRIMARY KEY REFERENCES spaces_view(entry_ino) ON UPDATE CASCADE ON DELETE CASCADE,
+			due_at    INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '25' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV25toV26(db *sql.DB) error {
+	// Index of trashed Spaces items with their original paths.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE trash (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			rel_path   TEXT NOT NULL,
+			entry_ino  INTEGER NOT NULL DEFAULT 0,
+			size       INTEGER NOT NULL DEFAULT 0,
+			mtime      INTEGER NOT NULL DEFAULT 0,
+			is_dir     INTEGER NOT NULL DEFAULT 0,
+			trash_path TEXT NOT NULL UNIQUE,
+			trashed_at INTEGER NOT NULL
+		)`,
+		`CREATE INDEX idx_trash_rel_path ON trash(rel_path)`,
+		`UPDATE meta SET value = '26' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV26toV27(db *sql.DB) error {
+	// Instance lock: one daemon per database.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE instance_lock (
+			id           INTEGER PRIMARY KEY CHECK (id = 1),
+			owner        TEXT NOT NULL,
+			host         TEXT NOT NULL,
+			pid          INTEGER NOT NULL,
+			started_at   INTEGER NOT NULL,
+			heartbeat_at INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '27' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV27toV28(db *sql.DB) error {
+	// Per-directory auto-select of new entries.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE auto_select_dirs (
+			dir_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE
+		)`,
+		`UPDATE meta SET value = '28' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV28toV29(db *sql.DB) error {
+	// Per-entry outcome of sampled copy verification.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE copy_verifications (
+			entry_ino   INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			ok          INTEGER NOT NULL,
+			direction   TEXT NOT NULL,
+			verified_at INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '29' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV29toV30(db *sql.DB) error {
+	// Large selected files waiting for confirmation before they are copied.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE large_copies (
+			entry_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			size      INTEGER NOT NULL,
+			confirmed INTEGER NOT NULL DEFAULT 0,
+			seen_at   INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '30' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV30toV31(db *sql.DB) error {
+	// Canaries placed, so one deleted can be told from one never placed.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`CREATE TABLE canaries (
+			rel_path TEXT PRIMARY KEY
+		)`,
+		`UPDATE meta SET value = '31' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:40], err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrateV31toV32(db *sql.DB) error {
+	// Directory fingerprints hash each child. The old ones are dropped,
+	// so the next seed reconciles everything once.
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := []string{
+		`DROP TABLE dir_fingerprints`,
+		`CREATE TABLE dir_fingerprints (
+			path     TEXT PRIMARY KEY,
+			hash     INTEGER NOT NULL,
+			children INTEGER NOT NULL
+		)`,
+		`UPDATE meta SET value = '32' WHERE key = 'schema_version'`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("exec %q: %w", stmt[:min(len(stmt), 40)], err)
+		}
+	}
+
+	return tx.Commit()
+}
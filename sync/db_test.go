@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenDB_ReadersAreReadOnly(t *testing.T) {
+	store := setupTestDB(t)
+	_, err := store.rdb.Exec("INSERT INTO meta (key, value) VALUES ('x', 'y')")
+	assert.Error(t, err)
+}
+
+func TestOpenDB_ReadsDoNotWaitForWrites(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+
+	// Hold the writer inside a transaction; reads still go through.
+	held := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- store.WithTx(func(tx *TxStore) error {
+			if err := tx.UpdateEntryMtime(1, 2, nil); err != nil {
+				return err
+			}
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	<-held
+	e, err := store.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), e.Mtime, "uncommitted write is not visible")
+	close(release)
+	require.NoError(t, <-done)
+
+	e, err = store.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), e.Mtime)
+	assert.Zero(t, store.DBStats().ReadWaits)
+}
+
+func TestRetryBusy_BacksOffAndCounts(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "busy.db")
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(0)&_pragma=journal_mode(WAL)&_txlock=immediate")
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	holder, other := open(), open()
+	_, err := holder.Exec("CREATE TABLE t (v INTEGER)")
+	require.NoError(t, err)
+	lock, err := holder.Begin()
+	require.NoError(t, err)
+
+	before := dbBusy.busy.Load()
+	retriesBefore := dbBusy.retries.Load()
+	go func() {
+		time.Sleep(2 * busyBackoff)
+		lock.Rollback() //nolint:errcheck
+	}()
+	err = retryBusy(func() error {
+		_, err := other.Exec("INSERT INTO t VALUES (1)")
+		return err
+	})
+	require.NoError(t, err, "write succeeds once the lock is released")
+	assert.Greater(t, dbBusy.busy.Load(), before)
+	assert.Greater(t, dbBusy.retries.Load(), retriesBefore)
+
+	// A lock held past every retry surfaces as busy.
+	lock, err = holder.Begin()
+	require.NoError(t, err)
+	defer lock.Rollback() //nolint:errcheck
+	err = retryBusy(func() error {
+		_, err := other.Exec("INSERT INTO t VALUES (2)")
+		return err
+	})
+	require.Error(t, err)
+	assert.True(t, isBusy(err))
+}
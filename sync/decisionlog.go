@@ -0,0 +1,166 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Decision is the machine-readable record of one RunPipeline run: the
+// state it found, the phases it ran and the state it left. The decision
+// log holds one per line, for offline analysis of convergence.
+type Decision struct {
+	Time       int64            `json:"time"` // nanoseconds, start of the run
+	Path       string           `json:"path"`
+	TraceID    string           `json:"traceId,omitempty"`
+	State      PipelineState    `json:"state"`
+	Actions    []DecisionAction `json:"actions"`
+	Final      *PipelineState   `json:"final,omitempty"` // nil if the run failed or ingested a Syncthing conflict
+	DurationMs int64            `json:"durationMs"`
+	Bytes      int64            `json:"bytes"` // copied by the run
+	Error      string           `json:"error,omitempty"`
+}
+
+// DecisionAction is one phase a pipeline run executed.
+type DecisionAction struct {
+	Phase      string `json:"phase"` // P0–P4 or "syncthing"
+	DurationMs int64  `json:"durationMs"`
+	Bytes      int64  `json:"bytes"`
+	Error      string `json:"error,omitempty"`
+}
+
+// decisionLog writes Decisions as NDJSON while the daemon runs with a
+// decision log configured.
+type decisionLog struct {
+	mu gosync.Mutex
+	w  io.WriteCloser
+}
+
+var decisions = &decisionLog{}
+
+// open starts logging decisions to a file at path, rotated at 100 MB with
+// 10 backups kept, until the returned func is called. An empty path
+// leaves the log off.
+func (d *decisionLog) open(path string) func() {
+	if path == "" {
+		return func() {}
+	}
+	os.MkdirAll(filepath.Dir(path), 0755) //nolint:errcheck // lumberjack reports it on write
+	w := &lumberjack.Logger{Filename: path, MaxSize: 100, MaxBackups: 10}
+	d.mu.Lock()
+	d.w = w
+	d.mu.Unlock()
+	return func() {
+		d.mu.Lock()
+		d.w = nil
+		d.mu.Unlock()
+		w.Close() //nolint:errcheck
+	}
+}
+
+func (d *decisionLog) enabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.w != nil
+}
+
+func (d *decisionLog) write(rec *Decision) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.w == nil {
+		return
+	}
+	if _, err := d.w.Write(line); err != nil {
+		sub("pipeline").Warn("decision log write failed", "err", err)
+	}
+}
+
+// decisionRun collects the Decision of the run in progress. Its methods
+// are no-ops on nil, which is what decisionFrom returns when the log is
+// off.
+type decisionRun struct {
+	rec   Decision
+	start time.Time
+	bytes atomic.Int64
+}
+
+type decisionKey struct{}
+
+// beginDecision starts the Decision for a run on relPath and returns ctx
+// carrying it, or ctx and nil when no decision log is open.
+func beginDecision(ctx context.Context, relPath string) (context.Context, *decisionRun) {
+	if !decisions.enabled() {
+		return ctx, nil
+	}
+	run := &decisionRun{rec: Decision{Time: nowNano(), Path: relPath, Actions: make([]DecisionAction, 0)}, start: time.Now()}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		run.rec.TraceID = sc.TraceID().String()
+	}
+	return context.WithValue(ctx, decisionKey{}, run), run
+}
+
+// decisionFrom returns the Decision run ctx carries, if any.
+func decisionFrom(ctx context.Context) *decisionRun {
+	run, _ := ctx.Value(decisionKey{}).(*decisionRun)
+	return run
+}
+
+// state records the state the run found.
+func (r *decisionRun) state(s State) {
+	if r != nil {
+		r.rec.State = pipelineState(s)
+	}
+}
+
+// addBytes counts n bytes copied by the run.
+func (r *decisionRun) addBytes(n int64) {
+	if r != nil {
+		r.bytes.Add(n)
+	}
+}
+
+// action runs one phase of the run, recording its duration, bytes and
+// error.
+func (r *decisionRun) action(phase string, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	start, bytes := time.Now(), r.bytes.Load()
+	err := fn()
+	a := DecisionAction{Phase: phase, DurationMs: time.Since(start).Milliseconds(), Bytes: r.bytes.Load() - bytes}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	r.rec.Actions = append(r.rec.Actions, a)
+	return err
+}
+
+// finish writes the Decision, with the final state for a run that
+// succeeded.
+func (r *decisionRun) finish(final *State, err error) {
+	if r == nil {
+		return
+	}
+	r.rec.DurationMs = time.Since(r.start).Milliseconds()
+	r.rec.Bytes = r.bytes.Load()
+	if err != nil {
+		r.rec.Error = err.Error()
+	} else if final != nil {
+		ps := pipelineState(*final)
+		r.rec.Final = &ps
+	}
+	decisions.write(&r.rec)
+}
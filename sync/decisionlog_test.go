@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readDecisions(t *testing.T, path string) []Decision {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var out []Decision
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var d Decision
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &d))
+		out = append(out, d)
+	}
+	require.NoError(t, sc.Err())
+	return out
+}
+
+func TestDecisionLog_RecordsPipelineRuns(t *testing.T) {
+	env := setupPipelineEnv(t)
+	logPath := filepath.Join(t.TempDir(), "log", "decisions.ndjson")
+	t.Cleanup(decisions.open(logPath))
+
+	env.writeArchive(t, "doc.txt", []byte("content"))
+	env.run(t, "doc.txt")
+	entries, _ := env.store.ListChildren(0)
+	require.Len(t, entries, 1)
+	require.NoError(t, env.store.SetSelected([]uint64{entries[0].Inode}, true))
+	env.run(t, "doc.txt")
+
+	recs := readDecisions(t, logPath)
+	require.Len(t, recs, 2)
+
+	reg := recs[0]
+	assert.Equal(t, "doc.txt", reg.Path)
+	assert.Equal(t, 2, reg.State.Scenario)
+	require.Len(t, reg.Actions, 1)
+	assert.Equal(t, "P1", reg.Actions[0].Phase)
+	require.NotNil(t, reg.Final)
+	assert.Equal(t, 15, reg.Final.Scenario)
+	assert.Zero(t, reg.Bytes)
+
+	sel := recs[1]
+	assert.True(t, sel.State.Selected)
+	require.Len(t, sel.Actions, 1)
+	assert.Equal(t, "P3", sel.Actions[0].Phase)
+	assert.Equal(t, int64(len("content")), sel.Actions[0].Bytes)
+	require.NotNil(t, sel.Final)
+	assert.Equal(t, 31, sel.Final.Scenario)
+	assert.Equal(t, int64(len("content")), sel.Bytes)
+}
+
+func TestDecisionLog_RecordsFailure(t *testing.T) {
+	env := setupPipelineEnv(t)
+	logPath := filepath.Join(t.TempDir(), "decisions.ndjson")
+	t.Cleanup(decisions.open(logPath))
+
+	env.writeArchive(t, "doc.txt", []byte("content"))
+	env.run(t, "doc.txt")
+	entries, _ := env.store.ListChildren(0)
+	require.NoError(t, env.store.SetSelected([]uint64{entries[0].Inode}, true))
+	// Spaces turned into a file: the copy can't create its destination.
+	require.NoError(t, os.RemoveAll(env.spacesRoot))
+	require.NoError(t, os.WriteFile(env.spacesRoot, nil, 0644))
+
+	err := RunPipeline(t.Context(), "doc.txt", env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil)
+	require.Error(t, err)
+
+	recs := readDecisions(t, logPath)
+	require.Len(t, recs, 2)
+	assert.Nil(t, recs[1].Final)
+	assert.NotEmpty(t, recs[1].Error)
+	require.NotEmpty(t, recs[1].Actions)
+	assert.NotEmpty(t, recs[1].Actions[0].Error)
+}
+
+func TestDecisionLog_OffByDefault(t *testing.T) {
+	_, run := beginDecision(t.Context(), "doc.txt")
+	assert.Nil(t, run)
+	run.state(State{})
+	run.addBytes(1)
+	run.finish(nil, nil)
+}
@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// GET /api/sync/diff is a read-only fsck of the sync state: both roots are
+// scanned afresh and compared with the registered entries and their
+// spaces_view rows, reporting what is on disk but untracked, tracked but
+// missing, or on disk with another mtime than recorded. Nothing is fixed;
+// a reconcile does that. The scans are held in memory for the comparison.
+
+// Diff kinds.
+const (
+	DiffUntracked = "untracked" // on disk, not tracked
+	DiffMissing   = "missing"   // tracked, not on disk
+	DiffMtime     = "mtime"     // on disk with another mtime than tracked
+)
+
+const (
+	defaultDiffLimit = 1000
+	maxDiffLimit     = 10000
+)
+
+// DiffItem is one discrepancy between the DB and a root.
+type DiffItem struct {
+	Kind      string `json:"kind"` // DiffUntracked, DiffMissing or DiffMtime
+	Root      string `json:"root"` // archives or spaces
+	Path      string `json:"path"`
+	Inode     uint64 `json:"inode,omitempty"`     // the entry, for tracked paths
+	DBMtime   int64  `json:"dbMtime,omitempty"`   // nanoseconds; entry mtime, or synced mtime for Spaces
+	DiskMtime int64  `json:"diskMtime,omitempty"` // nanoseconds
+}
+
+// DiffCounts counts the discrepancies of one root by kind.
+type DiffCounts struct {
+	Untracked int `json:"untracked"`
+	Missing   int `json:"missing"`
+	Mtime     int `json:"mtime"`
+}
+
+// DiffReport is the body of GET /api/sync/diff.
+type DiffReport struct {
+	Entries   int        `json:"entries"` // registered entries compared
+	Archives  DiffCounts `json:"archives"`
+	Spaces    DiffCounts `json:"spaces"`
+	Items     []DiffItem `json:"items"`     // sorted by root, kind and path
+	Truncated bool       `json:"truncated"` // more items than the limit
+	Lazy      bool       `json:"lazy"`      // lazy registration: unbrowsed Archives paths are untracked by design
+	Partial   bool       `json:"partial"`   // the entry walk hit a tree limit, so untracked paths aren't reported
+}
+
+func (c *DiffCounts) add(kind string) {
+	switch kind {
+	case DiffUntracked:
+		c.Untracked++
+	case DiffMissing:
+		c.Missing++
+	case DiffMtime:
+		c.Mtime++
+	}
+}
+
+// diffTree compares the DB with fresh scans of both roots.
+func (d *Daemon) diffTree(store *Store) (DiffReport, error) {
+	report := DiffReport{Lazy: d.lazy != nil}
+	archives, err := ScanDir(d.archivesRoot)
+	if err != nil {
+		return report, err
+	}
+	spaces, err := ScanDir(d.spacesRoot)
+	if err != nil {
+		return report, err
+	}
+	views, err := store.ListSpacesViews()
+	if err != nil {
+		return report, err
+	}
+	stubs, err := store.ListPlaceholders()
+	if err != nil {
+		return report, err
+	}
+	placeholders := make(map[uint64]bool, len(stubs))
+	for _, e := range stubs {
+		placeholders[e.Inode] = true
+	}
+	aGrain, sGrain := mtimeGrain(d.archivesRoot), mtimeGrain(d.spacesRoot)
+
+	note := func(item DiffItem) {
+		if item.Root == "archives" {
+			report.Archives.add(item.Kind)
+		} else {
+			report.Spaces.add(item.Kind)
+		}
+		report.Items = append(report.Items, item)
+	}
+	err = store.walkTree(0, "", func(e *Entry, relPath string) (bool, error) {
+		report.Entries++
+		diskPath := filepath.FromSlash(relPath)
+		isFile := e.Type != "dir"
+
+		a, onArchives := archives[diskPath]
+		delete(archives, diskPath)
+		switch {
+		case !onArchives:
+			note(DiffItem{Kind: DiffMissing, Root: "archives", Path: relPath, Inode: e.Inode, DBMtime: e.Mtime})
+		case isFile && !sameMtime(a.Mtime, e.Mtime, aGrain):
+			note(DiffItem{Kind: DiffMtime, Root: "archives", Path: relPath, Inode: e.Inode, DBMtime: e.Mtime, DiskMtime: a.Mtime})
+		}
+
+		s, onSpaces := spaces[diskPath]
+		delete(spaces, diskPath)
+		sv, tracked := views[e.Inode]
+		switch {
+		case tracked && !onSpaces:
+			note(DiffItem{Kind: DiffMissing, Root: "spaces", Path: relPath, Inode: e.Inode, DBMtime: sv.SyncedMtime})
+		case tracked && isFile && !sameMtime(s.Mtime, sv.SyncedMtime, sGrain):
+			note(DiffItem{Kind: DiffMtime, Root: "spaces", Path: relPath, Inode: e.Inode, DBMtime: sv.SyncedMtime, DiskMtime: s.Mtime})
+		case !tracked && onSpaces && isFile && !placeholders[e.Inode]:
+			note(DiffItem{Kind: DiffUntracked, Root: "spaces", Path: relPath, Inode: e.Inode, DiskMtime: s.Mtime})
+		}
+		return true, nil
+	})
+	if errors.Is(err, errTreeTruncated) {
+		// What's left of the scans may well be tracked, just not walked.
+		report.Partial = true
+	} else if err != nil {
+		return report, err
+	}
+	if !report.Partial {
+		for p, st := range archives {
+			note(DiffItem{Kind: DiffUntracked, Root: "archives", Path: filepath.ToSlash(p), DiskMtime: st.Mtime})
+		}
+		for p, st := range spaces {
+			note(DiffItem{Kind: DiffUntracked, Root: "spaces", Path: filepath.ToSlash(p), DiskMtime: st.Mtime})
+		}
+	}
+
+	sort.Slice(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if a.Root != b.Root {
+			return a.Root < b.Root
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Path < b.Path
+	})
+	return report, nil
+}
+
+// HandleDiff handles GET /api/sync/diff?limit=N
+// Reports how the DB and both roots disagree, listing up to N
+// discrepancies (default 1000, at most 10000) and counting all of them.
+func (h *Handlers) HandleDiff(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	limit := defaultDiffLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDiffLimit)
+	}
+	report, err := h.daemon.diffTree(h.store)
+	if err != nil {
+		l.Error("diff failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(report.Items) > limit {
+		report.Items, report.Truncated = report.Items[:limit], true
+	}
+	if report.Items == nil {
+		report.Items = []DiffItem{}
+	}
+	l.Info("HTTP diff", "entries", report.Entries, "archives", report.Archives, "spaces", report.Spaces)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report) //nolint:errcheck
+}
@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDiff_ReportsDiscrepancies(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Docs/", "Docs/ok.txt", "Docs/gone.txt", "Docs/old.txt", "Docs/synced.txt", "Docs/copy-lost.txt"},
+		map[string]bool{"Docs/synced.txt": true, "Docs/copy-lost.txt": true})
+	require.NoError(t, os.Remove(filepath.Join(archivesRoot, "Docs/gone.txt")))
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Docs/copy-lost.txt")))
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(archivesRoot, "Docs/old.txt"), later, later))
+	require.NoError(t, os.Chtimes(filepath.Join(spacesRoot, "Docs/synced.txt"), later, later))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/new.txt"), []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "Docs/ok.txt"), []byte("ok"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "stray.txt"), []byte("stray"), 0644))
+
+	w := httptest.NewRecorder()
+	h.HandleDiff(w, httptest.NewRequest("GET", "/api/sync/diff", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report DiffReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, 6, report.Entries)
+	assert.Equal(t, DiffCounts{Untracked: 1, Missing: 1, Mtime: 1}, report.Archives)
+	assert.Equal(t, DiffCounts{Untracked: 2, Missing: 1, Mtime: 1}, report.Spaces)
+	var got []string
+	for _, item := range report.Items {
+		got = append(got, item.Root+" "+item.Kind+" "+item.Path)
+	}
+	assert.Equal(t, []string{
+		"archives missing Docs/gone.txt",
+		"archives mtime Docs/old.txt",
+		"archives untracked Docs/new.txt",
+		"spaces missing Docs/copy-lost.txt",
+		"spaces mtime Docs/synced.txt",
+		"spaces untracked Docs/ok.txt",
+		"spaces untracked stray.txt",
+	}, got)
+	assert.NotZero(t, report.Items[1].DBMtime)
+	assert.Equal(t, later.UnixNano(), report.Items[1].DiskMtime)
+
+	// Read-only: the missing entry is still registered.
+	_, err := h.resolvePathToIno("Docs/gone.txt")
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.HandleDiff(w, httptest.NewRequest("GET", "/api/sync/diff?limit=2", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Len(t, report.Items, 2)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, 1, report.Archives.Untracked, "counts cover every item")
+}
+
+func TestHandleDiff_PartialWalkReportsNoUntracked(t *testing.T) {
+	restoreConfig(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt", "b.txt", "c.txt"}, nil)
+	cfg := currentConfig()
+	cfg.TreeMaxEntries = 1
+	require.NoError(t, setConfig(cfg))
+
+	w := httptest.NewRecorder()
+	h.HandleDiff(w, httptest.NewRequest("GET", "/api/sync/diff", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report DiffReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Partial)
+	assert.Equal(t, 1, report.Entries)
+	assert.Empty(t, report.Items, "entries the walk didn't reach aren't untracked")
+}
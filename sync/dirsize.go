@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	gosync "sync"
+)
+
+// dirSizeNode is a cached directory size and the directory's parent, so
+// invalidation can walk up without querying the DB.
+type dirSizeNode struct {
+	size   DirSize
+	parent uint64
+}
+
+// dirSizeCache holds recursive directory sizes. A directory missing from
+// the map is stale. When a directory is cached so are all of its
+// subdirectories, so invalidation walks up only until it meets a stale
+// ancestor. gen is bumped on every invalidation so a computation racing
+// with a mutation doesn't store its possibly outdated results.
+type dirSizeCache struct {
+	mu    gosync.Mutex
+	nodes map[uint64]dirSizeNode
+	gen   uint64
+}
+
+func (c *dirSizeCache) get(ino uint64) (dirSizeNode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.nodes[ino]
+	return n, ok
+}
+
+func (c *dirSizeCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put stores n unless the cache was invalidated since generation gen.
+func (c *dirSizeCache) put(ino uint64, n dirSizeNode, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	if c.nodes == nil {
+		c.nodes = make(map[uint64]dirSizeNode)
+	}
+	c.nodes[ino] = n
+}
+
+// invalidate marks dirIno and its cached ancestors stale.
+func (c *dirSizeCache) invalidate(dirIno uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for {
+		n, ok := c.nodes[dirIno]
+		if !ok {
+			return
+		}
+		delete(c.nodes, dirIno)
+		if dirIno == 0 {
+			return
+		}
+		dirIno = n.parent
+	}
+}
+
+// empty reports whether nothing is cached. It bumps gen like invalidate
+// so a computation in progress won't store results.
+func (c *dirSizeCache) empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	return len(c.nodes) == 0
+}
+
+// clear marks every directory stale.
+func (c *dirSizeCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.nodes = nil
+}
+
+// invalidateDirSizeOf marks the directories containing inode stale. With
+// self set, inode itself is dropped too when it is a directory.
+func (s *Store) invalidateDirSizeOf(inode uint64, self bool) {
+	if s.dirSizes.empty() {
+		return
+	}
+	var parent uint64
+	var typ string
+	err := s.rdb.QueryRowContext(s.context(), "SELECT parent_ino, type FROM entries WHERE inode = ?", inode).Scan(&parent, &typ)
+	if err != nil {
+		// Unknown entries contribute to no cached size.
+		return
+	}
+	if self && typ == "dir" {
+		s.dirSizes.invalidate(inode)
+	}
+	s.dirSizes.invalidate(parent)
+}
+
+// InvalidateDirSizes marks every cached directory size stale, e.g. after
+// a bulk change made outside the Store.
+func (s *Store) InvalidateDirSizes() {
+	s.dirSizes.clear()
+}
+
+// DirSize returns the recursive total and synced file bytes under dirIno
+// (0 = the whole tree). Cached sizes are reused; stale directories are
+// recomputed from their files plus their subdirectories' sizes.
+func (s *Store) DirSize(dirIno uint64) (DirSize, error) {
+	if n, ok := s.dirSizes.get(dirIno); ok {
+		return n.size, nil
+	}
+	var parent uint64
+	if dirIno != 0 {
+		var typ string
+		err := s.rdb.QueryRowContext(s.context(), "SELECT parent_ino, type FROM entries WHERE inode = ?", dirIno).Scan(&parent, &typ)
+		if err == sql.ErrNoRows {
+			return DirSize{}, fmt.Errorf("dir size: inode %d not found", dirIno)
+		}
+		if err != nil {
+			return DirSize{}, fmt.Errorf("dir size: %w", err)
+		}
+		if typ != "dir" {
+			return DirSize{}, fmt.Errorf("dir size: inode %d is not a directory", dirIno)
+		}
+	}
+	return s.computeDirSize(dirIno, parent, s.dirSizes.generation())
+}
+
+func (s *Store) computeDirSize(dirIno, parent, gen uint64) (DirSize, error) {
+	if n, ok := s.dirSizes.get(dirIno); ok {
+		return n.size, nil
+	}
+
+	var size DirSize
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT COALESCE(SUM(e.size), 0),
+		       COALESCE(SUM(CASE WHEN sv.entry_ino IS NOT NULL THEN e.size END), 0),
+		       COUNT(*)
+		FROM entries e LEFT JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE e.parent_ino = ? AND e.type != 'dir' AND e.deleted_at = 0
+	`, dirIno).Scan(&size.TotalBytes, &size.SyncedBytes, &size.Files)
+	if err != nil {
+		return DirSize{}, fmt.Errorf("dir size files: %w", err)
+	}
+
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT inode FROM entries WHERE parent_ino = ? AND type = 'dir' AND deleted_at = 0", dirIno)
+	if err != nil {
+		return DirSize{}, fmt.Errorf("dir size subdirs: %w", err)
+	}
+	var subdirs []uint64
+	for rows.Next() {
+		var ino uint64
+		if err := rows.Scan(&ino); err != nil {
+			rows.Close()
+			return DirSize{}, fmt.Errorf("scan subdir: %w", err)
+		}
+		// Guard against a self-parented row looping forever
+		if ino != dirIno {
+			subdirs = append(subdirs, ino)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return DirSize{}, fmt.Errorf("dir size subdirs: %w", err)
+	}
+
+	for _, ino := range subdirs {
+		child, err := s.computeDirSize(ino, dirIno, gen)
+		if err != nil {
+			return DirSize{}, err
+		}
+		size.TotalBytes += child.TotalBytes
+		size.SyncedBytes += child.SyncedBytes
+		size.Files += child.Files
+	}
+
+	s.dirSizes.put(dirIno, dirSizeNode{size: size, parent: parent}, gen)
+	return size, nil
+}
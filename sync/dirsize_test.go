@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedDirSizeTree builds A(1)/{f1(2) 10B synced, B(3)/{f2(4) 20B}}, C(5)/f3(6) 5B.
+func seedDirSizeTree(t *testing.T, store *Store) {
+	t.Helper()
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "A", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "f1", Type: "text", Size: ptr(int64(10)), Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 3, ParentIno: 1, Name: "B", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 4, ParentIno: 3, Name: "f2", Type: "text", Size: ptr(int64(20)), Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "C", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 6, ParentIno: 5, Name: "f3", Type: "text", Size: ptr(int64(5)), Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 2, SyncedMtime: 1, CheckedAt: 1}))
+}
+
+func TestDirSize_IncrementalInvalidation(t *testing.T) {
+	store := setupTestDB(t)
+	seedDirSizeTree(t, store)
+
+	size, err := store.DirSize(0)
+	require.NoError(t, err)
+	assert.Equal(t, DirSize{TotalBytes: 35, SyncedBytes: 10, Files: 3}, size)
+	for _, ino := range []uint64{0, 1, 3, 5} {
+		_, ok := store.dirSizes.get(ino)
+		assert.True(t, ok, "inode %d cached", ino)
+	}
+
+	// A change under B invalidates B, A and root but not the sibling C
+	require.NoError(t, store.UpdateEntryMtime(4, 2, ptr(int64(25))))
+	for ino, want := range map[uint64]bool{0: false, 1: false, 3: false, 5: true} {
+		_, ok := store.dirSizes.get(ino)
+		assert.Equal(t, want, ok, "inode %d cached", ino)
+	}
+	size, err = store.DirSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, DirSize{TotalBytes: 35, SyncedBytes: 10, Files: 2}, size)
+
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 4, SyncedMtime: 2, CheckedAt: 1}))
+	size, err = store.DirSize(3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), size.SyncedBytes)
+
+	// Moving B under C moves its bytes
+	require.NoError(t, store.MoveEntry(3, 5, "B"))
+	a, err := store.DirSize(1)
+	require.NoError(t, err)
+	c, err := store.DirSize(5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), a.TotalBytes)
+	assert.Equal(t, int64(30), c.TotalBytes)
+
+	require.NoError(t, store.DeleteSpacesView(2))
+	require.NoError(t, store.DeleteEntry(6))
+	size, err = store.DirSize(0)
+	require.NoError(t, err)
+	assert.Equal(t, DirSize{TotalBytes: 35, SyncedBytes: 25, Files: 2}, size)
+
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 7, ParentIno: 3, Name: "f4", Type: "text", Size: ptr(int64(1)), Mtime: 1}))
+	size, err = store.DirSize(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(36), size.TotalBytes)
+}
+
+func TestHandleDirSize(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	seedDirSizeTree(t, store)
+
+	w := httptest.NewRecorder()
+	h.HandleDirSize(w, httptest.NewRequest("GET", "/api/sync/dirsize/1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var size DirSize
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &size))
+	assert.Equal(t, DirSize{TotalBytes: 30, SyncedBytes: 10, Files: 2}, size)
+
+	w = httptest.NewRecorder()
+	h.HandleDirSize(w, httptest.NewRequest("GET", "/api/sync/dirsize/2", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleDirSize(w, httptest.NewRequest("GET", "/api/sync/dirsize/99", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
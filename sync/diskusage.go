@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"fmt"
+)
+
+// RootDisk is the usage of the file system a root lives on.
+type RootDisk struct {
+	Total  int64  `json:"total"`
+	Free   int64  `json:"free"` // available to unprivileged writers
+	Used   int64  `json:"used"`
+	FsType string `json:"fsType"` // e.g. ext4; "remote" for a remote root, "" if unknown
+}
+
+// TrashUsage is what the indexed trash holds.
+type TrashUsage struct {
+	Items int64 `json:"items"`
+	Bytes int64 `json:"bytes"` // files only; a trashed directory counts its files trashed before it
+}
+
+// DiskStats reports both roots apart, as they may be different devices.
+type DiskStats struct {
+	Archives RootDisk   `json:"archives"`
+	Spaces   RootDisk   `json:"spaces"`
+	Trash    TrashUsage `json:"trash"`
+}
+
+// rootDisk returns the usage of the file system at root, left zero if it
+// can't be read.
+func rootDisk(root string) RootDisk {
+	if isRemote(root) {
+		return RootDisk{FsType: "remote"}
+	}
+	disk, err := statDisk(root)
+	if err != nil {
+		sub("handlers").Debug("statfs failed", "root", root, "err", err)
+	}
+	return disk
+}
+
+// diskStats returns the usage of both roots and the trash.
+func (h *Handlers) diskStats() (DiskStats, error) {
+	trash, err := h.store.TrashUsage()
+	if err != nil {
+		return DiskStats{}, err
+	}
+	return DiskStats{Archives: rootDisk(h.archivesRoot), Spaces: rootDisk(h.spacesRoot), Trash: trash}, nil
+}
+
+// TrashUsage sums the trash index.
+func (s *Store) TrashUsage() (TrashUsage, error) {
+	var u TrashUsage
+	err := s.rdb.QueryRowContext(s.context(), "SELECT COUNT(*), COALESCE(SUM(size), 0) FROM trash").Scan(&u.Items, &u.Bytes)
+	if err != nil {
+		return u, fmt.Errorf("trash usage: %w", err)
+	}
+	return u, nil
+}
@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsTypes names the file system magic numbers seen in practice.
+var fsTypes = map[int64]string{
+	unix.EXT4_SUPER_MAGIC:      "ext4", // shared by ext2 and ext3
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.SMB_SUPER_MAGIC:       "smb",
+	unix.CIFS_SUPER_MAGIC:      "cifs",
+	unix.SMB2_SUPER_MAGIC:      "smb2",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.MSDOS_SUPER_MAGIC:     "vfat",
+	unix.EXFAT_SUPER_MAGIC:     "exfat",
+	unix.F2FS_SUPER_MAGIC:      "f2fs",
+	0x2fc12fc1:                 "zfs",
+	0x5346544e:                 "ntfs", // ntfs3
+}
+
+// statDisk reads the usage and type of the file system at path.
+func statDisk(path string) (RootDisk, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return RootDisk{}, err
+	}
+	bs := int64(st.Bsize)
+	disk := RootDisk{
+		Total: int64(st.Blocks) * bs,
+		Free:  int64(st.Bavail) * bs,
+		Used:  int64(st.Blocks-st.Bfree) * bs,
+	}
+	disk.FsType = fsTypes[int64(st.Type)]
+	if disk.FsType == "" {
+		disk.FsType = fmt.Sprintf("0x%x", st.Type)
+	}
+	return disk, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package sync
+
+import "syscall"
+
+// statDisk reads the usage of the file system at path; its type is only
+// named on Linux.
+func statDisk(path string) (RootDisk, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return RootDisk{}, err
+	}
+	bs := int64(st.Bsize)
+	return RootDisk{
+		Total: int64(st.Blocks) * bs,
+		Free:  int64(st.Bavail) * bs,
+		Used:  int64(st.Blocks-st.Bfree) * bs,
+	}, nil
+}
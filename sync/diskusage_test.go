@@ -0,0 +1,32 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleStats_ReportsEachRoot(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.RecordTrash(TrashItem{RelPath: "a.txt", Size: 100, TrashPath: "/trash/a.txt"}))
+	require.NoError(t, store.RecordTrash(TrashItem{RelPath: "dir", IsDir: true, TrashPath: "/trash/dir"}))
+
+	w := httptest.NewRecorder()
+	h.HandleStats(w, httptest.NewRequest("GET", "/api/sync/stats", nil))
+	var resp SyncStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	for name, disk := range map[string]RootDisk{"archives": resp.Disks.Archives, "spaces": resp.Disks.Spaces} {
+		assert.Positive(t, disk.Total, name)
+		assert.LessOrEqual(t, disk.Free, disk.Total, name)
+		assert.LessOrEqual(t, disk.Used, disk.Total, name)
+		if runtime.GOOS == "linux" {
+			assert.NotEmpty(t, disk.FsType, name)
+		}
+	}
+	assert.Equal(t, resp.Disks.Archives.Total, resp.DiskTotal)
+	assert.Equal(t, TrashUsage{Items: 2, Bytes: 100}, resp.Disks.Trash)
+}
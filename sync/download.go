@@ -0,0 +1,267 @@
+package sync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// errBundleTooLarge is returned when a download exceeds Config.DownloadMaxBytes.
+var errBundleTooLarge = errors.New("download exceeds size limit")
+
+// bundleItem is one file or directory in a download bundle.
+type bundleItem struct {
+	name    string // path inside the bundle, slash-separated
+	src     string // absolute Archives path
+	rel     string // path relative to the root, as workers hold it
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// collectBundle resolves inodes to bundle items from the Archives disk.
+// Each requested entry appears at the top level under its own name;
+// directories include their whole subtree. maxBytes <= 0 disables the limit.
+func collectBundle(store *Store, archivesRoot string, inodes []uint64, maxBytes int64) ([]bundleItem, int64, error) {
+	var items []bundleItem
+	var total int64
+	seen := make(map[string]bool)
+	for _, ino := range inodes {
+		entry, err := store.GetEntry(ino)
+		if err != nil {
+			return nil, 0, err
+		}
+		if entry == nil {
+			return nil, 0, fmt.Errorf("inode %d: not found", ino)
+		}
+		relRoot := store.RelPath(entry)
+		root := filepath.Join(archivesRoot, relRoot)
+		err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(entry.Name, filepath.ToSlash(rel))
+			if seen[name] {
+				return nil
+			}
+			seen[name] = true
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil // skip symlinks, devices, sockets
+			}
+			it := bundleItem{name: name, src: p, rel: filepath.Join(relRoot, rel), isDir: info.IsDir(), modTime: info.ModTime()}
+			if !it.isDir {
+				it.size = info.Size()
+				total += it.size
+				if maxBytes > 0 && total > maxBytes {
+					return errBundleTooLarge
+				}
+			}
+			items = append(items, it)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return items, total, nil
+}
+
+// writeZip streams items as a zip archive. Zip output isn't seekable,
+// so range requests are only supported for tar bundles. Each file is
+// read while holding its path with acquire.
+func writeZip(w io.Writer, items []bundleItem, acquire func(string) func()) error {
+	zw := zip.NewWriter(w)
+	for _, it := range items {
+		hdr := &zip.FileHeader{Name: it.name, Modified: it.modTime, Method: zip.Deflate}
+		if it.isDir {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if it.isDir {
+			continue
+		}
+		release := acquire(it.rel)
+		err = copyFileTo(fw, it.src)
+		release()
+		if err != nil {
+			return fmt.Errorf("zip %s: %w", it.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func copyFileTo(w io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// tarSegment is a contiguous piece of a virtual tar stream: either
+// in-memory bytes (headers, padding) or a byte range of a file.
+type tarSegment struct {
+	start int64 // offset within the stream
+	size  int64
+	data  []byte // non-nil for in-memory segments
+	src   string // file path for content segments
+	rel   string // src relative to the root
+}
+
+// tarBundle is a seekable tar stream assembled on the fly from Archives
+// files, so http.ServeContent can answer range requests without
+// materialising the archive. Files that shrink after planning are
+// zero-padded; files that grow are truncated to the planned size. A
+// file's path is held with acquire while it is open.
+type tarBundle struct {
+	segments []tarSegment
+	size     int64
+	offset   int64
+	acquire  func(string) func()
+
+	open    string // path of the currently open file
+	openF   *os.File
+	release func() // releases the open file's path
+}
+
+// newTarBundle lays out the tar stream for items.
+func newTarBundle(items []bundleItem, acquire func(string) func()) (*tarBundle, error) {
+	b := &tarBundle{acquire: acquire}
+	for _, it := range items {
+		hdr := &tar.Header{
+			Name:    it.name,
+			ModTime: it.modTime.Truncate(time.Second),
+			Mode:    0644,
+			Size:    it.size,
+		}
+		if it.isDir {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+		} else {
+			hdr.Typeflag = tar.TypeReg
+		}
+		var buf bytes.Buffer
+		if err := tar.NewWriter(&buf).WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("tar header %s: %w", it.name, err)
+		}
+		b.add(tarSegment{data: buf.Bytes()})
+		if it.size > 0 {
+			b.add(tarSegment{size: it.size, src: it.src, rel: it.rel})
+			if pad := (512 - it.size%512) % 512; pad > 0 {
+				b.add(tarSegment{data: make([]byte, pad)})
+			}
+		}
+	}
+	b.add(tarSegment{data: make([]byte, 1024)}) // end-of-archive marker
+	return b, nil
+}
+
+func (b *tarBundle) add(s tarSegment) {
+	if s.data != nil {
+		s.size = int64(len(s.data))
+	}
+	s.start = b.size
+	b.segments = append(b.segments, s)
+	b.size += s.size
+}
+
+// Read implements io.Reader.
+func (b *tarBundle) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && b.offset < b.size {
+		seg := b.segmentAt(b.offset)
+		within := b.offset - seg.start
+		chunk := p[n:min(int64(len(p)), int64(n)+seg.size-within)]
+		if seg.data != nil {
+			copy(chunk, seg.data[within:])
+		} else if err := b.readFile(seg, chunk, within); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		b.offset += int64(len(chunk))
+	}
+	return n, nil
+}
+
+// readFile fills chunk from seg's file at off, zero-padding past EOF.
+func (b *tarBundle) readFile(seg *tarSegment, chunk []byte, off int64) error {
+	if b.open != seg.src {
+		b.Close() //nolint:errcheck
+		release := b.acquire(seg.rel)
+		f, err := os.Open(seg.src)
+		if err != nil {
+			release()
+			return err
+		}
+		b.open, b.openF, b.release = seg.src, f, release
+	}
+	got, err := b.openF.ReadAt(chunk, off)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	clear(chunk[got:])
+	return nil
+}
+
+func (b *tarBundle) segmentAt(off int64) *tarSegment {
+	lo, hi := 0, len(b.segments)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if b.segments[mid].start <= off {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return &b.segments[lo]
+}
+
+// Seek implements io.Seeker.
+func (b *tarBundle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.offset = offset
+	return offset, nil
+}
+
+// Close releases the currently open source file and its path.
+func (b *tarBundle) Close() error {
+	if b.openF == nil {
+		return nil
+	}
+	err := b.openF.Close()
+	b.release()
+	b.open, b.openF, b.release = "", nil, nil
+	return err
+}
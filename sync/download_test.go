@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDownloadEnv registers Photos/{a.jpg,sub/b.jpg} and returns the Photos inode.
+func setupDownloadEnv(t *testing.T) (*Handlers, uint64) {
+	t.Helper()
+	h, store, archivesRoot, _ := setupHandlersEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "Photos", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Photos", "a.jpg"), bytes.Repeat([]byte("a"), 700), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Photos", "sub", "b.jpg"), []byte("bee"), 0644))
+
+	info, err := os.Stat(filepath.Join(archivesRoot, "Photos"))
+	require.NoError(t, err)
+	ino := info.Sys().(*syscall.Stat_t).Ino
+	require.NoError(t, store.UpsertEntry(Entry{Inode: ino, Name: "Photos", Type: "dir", Mtime: 1}))
+	return h, ino
+}
+
+func download(h *Handlers, query string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/sync/download?"+query, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.HandleDownload(w, req)
+	return w
+}
+
+func TestHandleDownload_Tar(t *testing.T) {
+	h, ino := setupDownloadEnv(t)
+	w := download(h, fmt.Sprintf("inodes=%d&format=tar", ino), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(w.Body.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+	assert.Equal(t, strings.Repeat("a", 700), files["Photos/a.jpg"])
+	assert.Equal(t, "bee", files["Photos/sub/b.jpg"])
+	assert.Contains(t, files, "Photos/sub/")
+
+	// A range request returns exactly the matching slice of the full stream
+	full := w.Body.Bytes()
+	w = download(h, fmt.Sprintf("inodes=%d&format=tar", ino), http.Header{"Range": {"bytes=500-1599"}})
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, full[500:1600], w.Body.Bytes())
+}
+
+func TestHandleDownload_Zip(t *testing.T) {
+	h, ino := setupDownloadEnv(t)
+	w := download(h, fmt.Sprintf("inodes=%d", ino), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"Photos/", "Photos/a.jpg", "Photos/sub/", "Photos/sub/b.jpg"}, names)
+}
+
+func TestHandleDownload_Errors(t *testing.T) {
+	restoreConfig(t)
+	h, ino := setupDownloadEnv(t)
+
+	assert.Equal(t, http.StatusBadRequest, download(h, "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, download(h, fmt.Sprintf("inodes=%d&format=rar", ino), nil).Code)
+	assert.Equal(t, http.StatusNotFound, download(h, "inodes=424242", nil).Code)
+
+	cfg := currentConfig()
+	cfg.DownloadMaxBytes = 100
+	require.NoError(t, setConfig(cfg))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, download(h, fmt.Sprintf("inodes=%d", ino), nil).Code)
+}
@@ -209,7 +209,7 @@ func TestE2E_Scenario12_Recovering_SDb_NoSel_SDirty(t *testing.T) {
 
 	// Remove Archives, modify Spaces (S_dirty)
 	os.Remove(filepath.Join(env.archivesRoot, "r12.txt"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "r12.txt", []byte("spaces modified"))
 	env.run(t, "r12.txt")
 
@@ -230,7 +230,7 @@ func TestE2E_Scenario14_Recovering_SDb_Sel_SDirty(t *testing.T) {
 	ino := entries[0].Inode
 
 	os.Remove(filepath.Join(env.archivesRoot, "r14.txt"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "r14.txt", []byte("spaces modified v2"))
 	env.run(t, "r14.txt")
 
@@ -266,7 +266,7 @@ func TestE2E_Scenario16_Archived_ADirty(t *testing.T) {
 	ino := entries[0].Inode
 
 	// Modify Archives → A_dirty
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "ad16.txt", []byte("v2 updated"))
 	env.run(t, "ad16.txt")
 
@@ -305,7 +305,7 @@ func TestE2E_Scenario18_Syncing_ADirty(t *testing.T) {
 	require.NoError(t, env.store.SetSelected([]uint64{ino}, true))
 
 	// Modify Archives before sync
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "s18.txt", []byte("v2 modified"))
 	env.run(t, "s18.txt")
 
@@ -346,7 +346,7 @@ func TestE2E_Scenario20_Repairing_NoSel_ADirty(t *testing.T) {
 	require.NoError(t, env.store.SetSelected([]uint64{ino}, false))
 
 	// Modify Archives + remove Spaces
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "rp20.txt", []byte("v2"))
 	os.Remove(filepath.Join(env.spacesRoot, "rp20.txt"))
 	env.run(t, "rp20.txt")
@@ -390,7 +390,7 @@ func TestE2E_Scenario22_Repairing_Sel_ADirty(t *testing.T) {
 	ino := entries[0].Inode
 
 	// Modify Archives + remove Spaces
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "rp22.txt", []byte("v2"))
 	os.Remove(filepath.Join(env.spacesRoot, "rp22.txt"))
 	env.run(t, "rp22.txt")
@@ -437,7 +437,7 @@ func TestE2E_Scenario24_Repairing_SDisk_NoSDb_NoSel_ADirty(t *testing.T) {
 
 	// Add Spaces, modify Archives
 	env.writeSpaces(t, "rp24.txt", []byte("v1"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "rp24.txt", []byte("v2"))
 	env.run(t, "rp24.txt")
 
@@ -478,7 +478,7 @@ func TestE2E_Scenario26_Repairing_SDisk_NoSDb_Sel_ADirty(t *testing.T) {
 
 	// Add Spaces, modify Archives
 	env.writeSpaces(t, "rp26.txt", []byte("v1"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "rp26.txt", []byte("v2 modified"))
 	env.run(t, "rp26.txt")
 
@@ -518,7 +518,7 @@ func TestE2E_Scenario28_Removing_SDirty(t *testing.T) {
 
 	require.NoError(t, env.store.SetSelected([]uint64{ino}, false))
 	// Modify Spaces (S_dirty)
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "rm28.txt", []byte("modified"))
 	env.run(t, "rm28.txt")
 
@@ -537,7 +537,7 @@ func TestE2E_Scenario29_Removing_ADirty(t *testing.T) {
 	ino := entries[0].Inode
 
 	require.NoError(t, env.store.SetSelected([]uint64{ino}, false))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "rm29.txt", []byte("archive updated"))
 	env.run(t, "rm29.txt")
 
@@ -559,9 +559,9 @@ func TestE2E_Scenario30_Conflict_NoSel(t *testing.T) {
 	require.NoError(t, env.store.SetSelected([]uint64{ino}, false))
 
 	// Both dirty
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "cf30.txt", []byte("archive v2"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "cf30.txt", []byte("spaces v2"))
 	env.run(t, "cf30.txt")
 
@@ -599,7 +599,7 @@ func TestE2E_Scenario32_Updating_SDirty(t *testing.T) {
 	env.writeSpaces(t, "up32.txt", []byte("orig"))
 	env.run(t, "up32.txt")
 
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "up32.txt", []byte("modified on spaces"))
 	env.run(t, "up32.txt")
 
@@ -614,7 +614,7 @@ func TestE2E_Scenario33_Updating_ADirty(t *testing.T) {
 	env.writeSpaces(t, "up33.txt", []byte("orig"))
 	env.run(t, "up33.txt")
 
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "up33.txt", []byte("modified on archives"))
 	env.run(t, "up33.txt")
 
@@ -629,9 +629,9 @@ func TestE2E_Scenario34_Conflict_Sel(t *testing.T) {
 	env.writeSpaces(t, "cf34.txt", []byte("orig"))
 	env.run(t, "cf34.txt")
 
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "cf34.txt", []byte("archive change"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "cf34.txt", []byte("spaces change"))
 	env.run(t, "cf34.txt")
 
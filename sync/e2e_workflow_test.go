@@ -62,7 +62,7 @@ func TestE2E_Flow_ModifyThenDeselect(t *testing.T) {
 	env.run(t, "mod.txt")
 
 	// Modify on Spaces
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "mod.txt", []byte("modified on spaces"))
 
 	// Sync S→A (#32)
@@ -115,9 +115,9 @@ func TestE2E_Sync_ConflictResolution(t *testing.T) {
 	env.run(t, "conflict.txt") // synced
 
 	// Both dirty
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "conflict.txt", []byte("archive version"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "conflict.txt", []byte("spaces version"))
 
 	env.run(t, "conflict.txt")
@@ -136,11 +136,49 @@ func TestE2E_Sync_ConflictResolution(t *testing.T) {
 	assert.Equal(t, []byte("archive version"), conflictGot,
 		"conflict copy should contain the old Archives version")
 
-	// NOTE: Known limitation — conflict file registration in p2 silently
-	// fails when the conflict file has the same inode as the original
-	// (rename preserves inode). A separate pipeline run on the conflict
-	// file also fails with UNIQUE constraint on inode PK.
-	// This is tracked as a known bug in the implementation.
+	// Conflict copy keeps the original inode; the winner gets the new one
+	original, _, err := lookupDB(env.store, env.archivesRoot, "conflict.txt")
+	require.NoError(t, err)
+	copyName := filepath.Base(matches[0])
+	copyEntry, copySV, err := lookupDB(env.store, env.archivesRoot, copyName)
+	require.NoError(t, err)
+	require.NotNil(t, copyEntry)
+	assert.Nil(t, copySV, "Spaces record moves to the winner")
+
+	conflicts, err := env.store.ListConflicts(true)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "conflict.txt", conflicts[0].Path)
+	assert.Equal(t, copyEntry.Inode, conflicts[0].ConflictIno)
+	assert.Equal(t, copyName, conflicts[0].ConflictName)
+	assert.Equal(t, original.Inode, conflicts[0].WinnerIno)
+
+	// Re-running the pipeline on the conflict copy is idempotent
+	env.run(t, copyName)
+	again, _, err := lookupDB(env.store, env.archivesRoot, copyName)
+	require.NoError(t, err)
+	assert.Equal(t, copyEntry.Inode, again.Inode)
+}
+
+// A conflict copy evaluated before its DB rename (watcher race) is
+// registered by moving the existing entry, not by a second insert.
+func TestE2E_Sync_RenamedInodeRegistersByMove(t *testing.T) {
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "a.txt", []byte("data"))
+	env.run(t, "a.txt")
+	before, _, err := lookupDB(env.store, env.archivesRoot, "a.txt")
+	require.NoError(t, err)
+
+	require.NoError(t, os.Rename(filepath.Join(env.archivesRoot, "a.txt"), filepath.Join(env.archivesRoot, "a_conflict-1.txt")))
+	env.run(t, "a_conflict-1.txt")
+
+	moved, _, err := lookupDB(env.store, env.archivesRoot, "a_conflict-1.txt")
+	require.NoError(t, err)
+	require.NotNil(t, moved)
+	assert.Equal(t, before.Inode, moved.Inode)
+	gone, _, err := lookupDB(env.store, env.archivesRoot, "a.txt")
+	require.NoError(t, err)
+	assert.Nil(t, gone)
 }
 
 // ============================================================
@@ -0,0 +1,283 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	gosync "sync"
+)
+
+// Encrypted Spaces copies are stored as a header followed by the file in
+// encryptChunkSize chunks, each sealed with AES-256-GCM. The nonce of a
+// chunk is the file's random prefix, the chunk counter and a final-chunk
+// flag, so chunks can't be reordered, dropped or truncated unnoticed.
+const (
+	encryptMagic     = "FBSE\x01"
+	encryptIDSize    = 8
+	encryptPrefixLen = 7
+	encryptHeaderLen = len(encryptMagic) + encryptIDSize + encryptPrefixLen
+	encryptChunkSize = 64 * 1024
+
+	// cipherAESGCM names the scheme in spaces_view.cipher, followed by
+	// ":<key id>".
+	cipherAESGCM = "aes-256-gcm"
+)
+
+// ErrWrongKey is returned when a Spaces copy was encrypted with a
+// different key than the one configured.
+var ErrWrongKey = errors.New("encrypted with a different key")
+
+// SpacesKey is the key Spaces copies are encrypted with.
+type SpacesKey struct {
+	aead cipher.AEAD
+	id   [encryptIDSize]byte
+}
+
+// NewSpacesKey returns a key for the 32 raw bytes of key.
+func NewSpacesKey(key []byte) (*SpacesKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	k := &SpacesKey{aead: aead}
+	sum := sha256.Sum256(key)
+	copy(k.id[:], sum[:])
+	return k, nil
+}
+
+// LoadSpacesKey reads a key file holding 64 hex characters, as written
+// by `openssl rand -hex 32`.
+func LoadSpacesKey(path string) (*SpacesKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read spaces key: %w", err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("spaces key %s: not hex: %w", path, err)
+	}
+	k, err := NewSpacesKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("spaces key %s: %w", path, err)
+	}
+	return k, nil
+}
+
+// Cipher is how spaces_view records copies encrypted with k.
+func (k *SpacesKey) Cipher() string {
+	return cipherName(k.id)
+}
+
+func cipherName(id [encryptIDSize]byte) string {
+	return cipherAESGCM + ":" + hex.EncodeToString(id[:])
+}
+
+var spacesKeyCache struct {
+	gosync.Mutex
+	path string
+	key  *SpacesKey
+}
+
+// spacesKey returns the configured SpacesEncryptionKey, loaded once per
+// path, or nil when Spaces copies are stored in plaintext.
+func spacesKey() (*SpacesKey, error) {
+	path := activeConfig.Load().SpacesEncryptionKey
+	if path == "" {
+		return nil, nil
+	}
+	c := &spacesKeyCache
+	c.Lock()
+	defer c.Unlock()
+	if c.path == path {
+		return c.key, nil
+	}
+	k, err := LoadSpacesKey(path)
+	if err != nil {
+		return nil, err
+	}
+	c.path, c.key = path, k
+	return k, nil
+}
+
+// chunkNonce is the nonce of chunk n of a file.
+func chunkNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptPrefixLen:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader reads src and returns the encrypted file.
+type encryptReader struct {
+	key    *SpacesKey
+	src    *bufio.Reader
+	prefix []byte
+	n      uint32
+	buf    []byte // plaintext chunk
+	out    bytes.Buffer
+	done   bool
+	err    error
+}
+
+func newEncryptReader(src io.Reader, key *SpacesKey) *encryptReader {
+	r := &encryptReader{key: key, src: bufio.NewReaderSize(src, encryptChunkSize), buf: make([]byte, encryptChunkSize)}
+	r.prefix = make([]byte, encryptPrefixLen)
+	if _, err := rand.Read(r.prefix); err != nil {
+		r.err = err
+	}
+	r.out.WriteString(encryptMagic)
+	r.out.Write(key.id[:])
+	r.out.Write(r.prefix)
+	return r
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.sealChunk()
+	}
+	return r.out.Read(p)
+}
+
+// sealChunk encrypts the next chunk into out. A chunk is the last one
+// when the source ends within or right after it.
+func (r *encryptReader) sealChunk() {
+	n, err := io.ReadFull(r.src, r.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.err = err
+		return
+	}
+	last := err != nil
+	if !last {
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			r.err = peekErr
+			return
+		}
+	}
+	r.out.Write(r.key.aead.Seal(nil, chunkNonce(r.prefix, r.n, last), r.buf[:n], nil))
+	r.n++
+	r.done = last
+}
+
+// decryptReader reads an encrypted file from src and returns the
+// plaintext, failing on any chunk that doesn't authenticate.
+type decryptReader struct {
+	key    *SpacesKey
+	src    *bufio.Reader
+	prefix []byte
+	n      uint32
+	buf    []byte // sealed chunk
+	out    bytes.Reader
+	done   bool
+	err    error
+}
+
+func newDecryptReader(src io.Reader, key *SpacesKey) *decryptReader {
+	size := encryptChunkSize + key.aead.Overhead()
+	r := &decryptReader{key: key, src: bufio.NewReaderSize(src, size), buf: make([]byte, size)}
+	header := make([]byte, encryptHeaderLen)
+	if _, err := io.ReadFull(r.src, header); err != nil {
+		r.err = fmt.Errorf("read header: %w", err)
+		return r
+	}
+	id, prefix, ok := parseEncryptHeader(header)
+	if !ok {
+		r.err = fmt.Errorf("not an encrypted copy")
+	} else if id != key.id {
+		r.err = fmt.Errorf("%w: %s", ErrWrongKey, hex.EncodeToString(id[:]))
+	}
+	r.prefix = prefix
+	return r
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.openChunk()
+	}
+	return r.out.Read(p)
+}
+
+func (r *decryptReader) openChunk() {
+	n, err := io.ReadFull(r.src, r.buf)
+	if err == io.EOF {
+		r.err = fmt.Errorf("truncated encrypted copy")
+		return
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		r.err = err
+		return
+	}
+	last := err != nil
+	if !last {
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			r.err = peekErr
+			return
+		}
+	}
+	plain, err := r.key.aead.Open(nil, chunkNonce(r.prefix, r.n, last), r.buf[:n], nil)
+	if err != nil {
+		r.err = fmt.Errorf("chunk %d: %w", r.n, err)
+		return
+	}
+	r.out.Reset(plain)
+	r.n++
+	r.done = last
+}
+
+// parseEncryptHeader splits a header into the key id and nonce prefix.
+func parseEncryptHeader(header []byte) (id [encryptIDSize]byte, prefix []byte, ok bool) {
+	if len(header) < encryptHeaderLen || string(header[:len(encryptMagic)]) != encryptMagic {
+		return id, nil, false
+	}
+	copy(id[:], header[len(encryptMagic):])
+	return id, header[len(encryptMagic)+encryptIDSize : encryptHeaderLen], true
+}
+
+// SafeCopyEncrypted is SafeCopy writing dst encrypted with key. The copy
+// keeps the source mtime; an interrupted copy always starts over.
+func SafeCopyEncrypted(ctx context.Context, src, dst string, key *SpacesKey, hasQueued func() bool) error {
+	return safeCopy(ctx, src, dst, hasQueued, func(r io.Reader) io.Reader { return newEncryptReader(r, key) })
+}
+
+// SafeCopyDecrypted is SafeCopy reading an src encrypted with key and
+// writing the plaintext to dst. A copy that fails to authenticate leaves
+// dst untouched.
+func SafeCopyDecrypted(ctx context.Context, src, dst string, key *SpacesKey, hasQueued func() bool) error {
+	return safeCopy(ctx, src, dst, hasQueued, func(r io.Reader) io.Reader { return newDecryptReader(r, key) })
+}
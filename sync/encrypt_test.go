@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpacesKey(t *testing.T) *SpacesKey {
+	t.Helper()
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+	k, err := NewSpacesKey(raw)
+	require.NoError(t, err)
+	return k
+}
+
+// enableEncryption writes a fresh key file and makes it the active key.
+func enableEncryption(t *testing.T) *SpacesKey {
+	t.Helper()
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "spaces.key")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(raw)+"\n"), 0600))
+	withConfig(t, func(c *Config) { c.SpacesEncryptionKey = path })
+	key, err := spacesKey()
+	require.NoError(t, err)
+	return key
+}
+
+func encrypt(t *testing.T, key *SpacesKey, plain []byte) []byte {
+	t.Helper()
+	sealed, err := io.ReadAll(newEncryptReader(bytes.NewReader(plain), key))
+	require.NoError(t, err)
+	return sealed
+}
+
+func TestEncrypt_RoundTrip(t *testing.T) {
+	key := testSpacesKey(t)
+	for _, size := range []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3 * encryptChunkSize} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		require.NoError(t, err)
+
+		sealed := encrypt(t, key, plain)
+		if size > 0 {
+			assert.NotContains(t, string(sealed), string(plain[:min(size, 64)]), "size %d", size)
+		}
+		got, err := io.ReadAll(newDecryptReader(bytes.NewReader(sealed), key))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+}
+
+func TestDecrypt_RejectsTamperingAndOtherKeys(t *testing.T) {
+	key := testSpacesKey(t)
+	plain := bytes.Repeat([]byte("x"), 2*encryptChunkSize)
+	sealed := encrypt(t, key, plain)
+
+	flipped := bytes.Clone(sealed)
+	flipped[encryptHeaderLen+10] ^= 1
+	_, err := io.ReadAll(newDecryptReader(bytes.NewReader(flipped), key))
+	assert.Error(t, err)
+
+	// Dropping the final chunk leaves a non-final chunk last
+	chunk := encryptChunkSize + key.aead.Overhead()
+	_, err = io.ReadAll(newDecryptReader(bytes.NewReader(sealed[:encryptHeaderLen+chunk]), key))
+	assert.Error(t, err)
+
+	_, err = io.ReadAll(newDecryptReader(bytes.NewReader(sealed), testSpacesKey(t)))
+	assert.ErrorIs(t, err, ErrWrongKey)
+
+	_, err = io.ReadAll(newDecryptReader(bytes.NewReader(plain), key))
+	assert.ErrorContains(t, err, "not an encrypted copy")
+}
+
+func TestLoadSpacesKey(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	require.NoError(t, os.WriteFile(good, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600))
+	k, err := LoadSpacesKey(good)
+	require.NoError(t, err)
+	assert.Regexp(t, `^aes-256-gcm:[0-9a-f]{16}$`, k.Cipher())
+
+	short := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(short, []byte("abcd"), 0600))
+	_, err = LoadSpacesKey(short)
+	assert.ErrorContains(t, err, "32 bytes")
+	_, err = LoadSpacesKey(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestPipeline_EncryptedSpaces(t *testing.T) {
+	key := enableEncryption(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	entry := registered(t, store, archivesRoot, "Docs/a.txt")
+	require.NoError(t, store.SetSelected([]uint64{entry.Inode}, true))
+	ctx := context.Background()
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+
+	spacesPath := filepath.Join(spacesRoot, "Docs", "a.txt")
+	sealed, err := os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "Docs/a.txt", "Spaces holds ciphertext")
+	aInfo, err := os.Stat(filepath.Join(archivesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	sInfo, err := os.Stat(spacesPath)
+	require.NoError(t, err)
+	assert.Equal(t, aInfo.ModTime(), sInfo.ModTime())
+	sv, err := store.GetSpacesView(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Equal(t, key.Cipher(), sv.Cipher)
+
+	// The content endpoint serves the decrypted Spaces copy
+	w := httptest.NewRecorder()
+	h.HandleGetContent(w, httptest.NewRequest("GET", "/api/sync/content/"+strconv.FormatUint(entry.Inode, 10), nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "Docs/a.txt", w.Body.String())
+
+	// An encrypted edit in Spaces reaches Archives as plaintext
+	require.NoError(t, os.WriteFile(spacesPath, encrypt(t, key, []byte("edited")), 0644))
+	later := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(spacesPath, later, later))
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+	got, err := os.ReadFile(filepath.Join(archivesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "edited", string(got))
+
+	// A plaintext file put into Spaces by hand is taken as is
+	require.NoError(t, os.WriteFile(spacesPath, []byte("by hand"), 0644))
+	later = later.Add(time.Second)
+	require.NoError(t, os.Chtimes(spacesPath, later, later))
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+	got, err = os.ReadFile(filepath.Join(archivesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "by hand", string(got))
+	sv, err = store.GetSpacesView(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Empty(t, sv.Cipher)
+}
+
+func TestSafeCopyDecrypted_WrongKeyLeavesDestination(t *testing.T) {
+	key := testSpacesKey(t)
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	require.NoError(t, os.WriteFile(src, []byte("secret"), 0644))
+	require.NoError(t, SafeCopyEncrypted(context.Background(), src, filepath.Join(dir, "sealed"), key, nil))
+	require.NoError(t, os.WriteFile(dst, []byte("old"), 0644))
+
+	err := SafeCopyDecrypted(context.Background(), filepath.Join(dir, "sealed"), dst, testSpacesKey(t), nil)
+	assert.ErrorIs(t, err, ErrWrongKey)
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(got))
+	assert.NoFileExists(t, dst+".sync-tmp")
+
+	require.NoError(t, SafeCopyDecrypted(context.Background(), filepath.Join(dir, "sealed"), dst, key, nil))
+	got, err = os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(got))
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"time"
+)
+
+// Selecting a large folder would stream one status event per file to
+// every SSE client. When a select or deselect queues more than
+// bulkEventThreshold paths under one entry, the bus tracks them as a bulk
+// job instead: their status events are swallowed, and each path's run
+// counts towards a progress event for the job's directory, published at
+// most every bulkProgressInterval and once more when the last path is
+// done. Smaller operations keep their per-file events.
+
+// bulkEventThreshold is the most paths an operation may queue and still
+// get per-file status events.
+const bulkEventThreshold = 100
+
+var (
+	// bulkProgressInterval is how often a bulk job's progress is published.
+	bulkProgressInterval = time.Second
+	// bulkIdleTimeout drops a bulk job whose paths stopped being run, e.g.
+	// because they were dropped from the queue.
+	bulkIdleTimeout = 10 * time.Minute
+)
+
+// bulkJob is a tracked bulk operation on the subtree at dir.
+type bulkJob struct {
+	job       string // ID of the selection job it is part of
+	dir       string
+	action    string // select or deselect
+	total     int
+	done      int
+	failed    int
+	published time.Time // last progress event
+	active    time.Time // last path done, or the start
+}
+
+// trackBulk starts a bulk job of action over paths, queued for the
+// subtree at dir by the selection job jobID. A path still part of an
+// earlier job moves to this one.
+func (b *EventBus) trackBulk(jobID, dir, action string, paths []string) {
+	now := nowFunc()
+	job := &bulkJob{job: jobID, dir: dir, action: action, total: len(paths), active: now}
+	b.bulkMu.Lock()
+	defer b.bulkMu.Unlock()
+	if b.bulk == nil {
+		b.bulk = make(map[string]*bulkJob)
+	}
+	for p, j := range b.bulk {
+		if now.Sub(j.active) >= bulkIdleTimeout {
+			delete(b.bulk, p)
+		}
+	}
+	for _, p := range paths {
+		if prev, ok := b.bulk[p]; ok {
+			prev.total--
+		}
+		b.bulk[p] = job
+	}
+	sub("events").Debug("bulk job tracked", "dir", dir, "action", action, "paths", len(paths))
+}
+
+// dropBulk stops tracking paths that will not run, e.g. of a cancelled
+// job.
+func (b *EventBus) dropBulk(paths []string) {
+	b.bulkMu.Lock()
+	defer b.bulkMu.Unlock()
+	for _, p := range paths {
+		if job, ok := b.bulk[p]; ok {
+			job.total--
+			delete(b.bulk, p)
+		}
+	}
+}
+
+// inBulk reports whether relPath belongs to a bulk job, whose events
+// are coalesced.
+func (b *EventBus) inBulk(relPath string) bool {
+	b.bulkMu.Lock()
+	defer b.bulkMu.Unlock()
+	_, ok := b.bulk[relPath]
+	return ok
+}
+
+// finished counts the run of relPath towards its bulk job, if any,
+// publishing the job's progress when due.
+func (b *EventBus) finished(relPath string, ok bool) {
+	now := nowFunc()
+	b.bulkMu.Lock()
+	job, tracked := b.bulk[relPath]
+	if !tracked {
+		b.bulkMu.Unlock()
+		return
+	}
+	delete(b.bulk, relPath)
+	job.done++
+	if !ok {
+		job.failed++
+	}
+	job.active = now
+	complete := job.done >= job.total
+	if !complete && now.Sub(job.published) < bulkProgressInterval {
+		b.bulkMu.Unlock()
+		return
+	}
+	job.published = now
+	ev := Event{Type: EventProgress, Path: job.dir, Time: now.UnixNano(), Data: map[string]any{
+		"job": job.job, "action": job.action, "done": job.done, "total": job.total, "failed": job.failed, "complete": complete,
+	}}
+	b.bulkMu.Unlock()
+	b.Publish(ev)
+}
+
+// publishStatus tells clients relPath reached status, e.g. synced.
+func publishStatus(relPath string, ino uint64, status string) {
+	events.Publish(Event{Type: EventStatus, Path: relPath, Inode: ino, Data: map[string]any{"status": status}})
+}
@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain returns the events waiting on ch.
+func drain(ch <-chan Event) []Event {
+	var out []Event
+	for {
+		select {
+		case ev := <-ch:
+			out = append(out, ev)
+		default:
+			return out
+		}
+	}
+}
+
+func TestEventBus_CoalescesBulkJobs(t *testing.T) {
+	clk := useFakeClock(t, time.Now())
+	bus := NewEventBus()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	paths := []string{"Projects/alpha", "Projects/alpha/a", "Projects/alpha/b", "Projects/alpha/c"}
+	bus.trackBulk("j1", "Projects/alpha", "select", paths)
+	for _, p := range paths[:2] {
+		bus.Publish(Event{Type: EventStatus, Path: p, Data: map[string]any{"status": "synced"}})
+		bus.finished(p, true)
+	}
+	evs := drain(ch)
+	require.Len(t, evs, 1, "status events are swallowed, progress is throttled")
+	assert.Equal(t, EventProgress, evs[0].Type)
+	assert.Equal(t, "Projects/alpha", evs[0].Path)
+	assert.Equal(t, 1, evs[0].Data["done"])
+	assert.Equal(t, 4, evs[0].Data["total"])
+
+	clk.Advance(bulkProgressInterval)
+	bus.finished("Projects/alpha/b", false)
+	bus.finished("Projects/alpha/c", true)
+	evs = drain(ch)
+	require.Len(t, evs, 2)
+	assert.Equal(t, 3, evs[0].Data["done"])
+	assert.Equal(t, 1, evs[0].Data["failed"])
+	assert.Equal(t, map[string]any{"job": "j1", "action": "select", "done": 4, "total": 4, "failed": 1, "complete": true}, evs[1].Data)
+
+	// Done paths are reported on their own again.
+	bus.Publish(Event{Type: EventStatus, Path: "Projects/alpha/a"})
+	bus.finished("Projects/alpha/a", true)
+	evs = drain(ch)
+	require.Len(t, evs, 1)
+	assert.Equal(t, EventStatus, evs[0].Type)
+}
+
+func TestEventBus_BulkJobTakesOverPaths(t *testing.T) {
+	useFakeClock(t, time.Now())
+	bus := NewEventBus()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	bus.trackBulk("j1", "a", "select", []string{"a", "a/x", "a/y"})
+	bus.trackBulk("j2", "a", "deselect", []string{"a", "a/x"})
+	bus.finished("a/y", true)
+	bus.finished("a", true)
+	bus.finished("a/x", true)
+	var complete []map[string]any
+	for _, ev := range drain(ch) {
+		if ev.Data["complete"] == true {
+			complete = append(complete, ev.Data)
+		}
+	}
+	assert.Equal(t, []map[string]any{
+		{"job": "j1", "action": "select", "done": 1, "total": 1, "failed": 0, "complete": true},
+		{"job": "j2", "action": "deselect", "done": 2, "total": 2, "failed": 0, "complete": true},
+	}, complete)
+}
+
+func TestEventBus_DropsIdleBulkJobs(t *testing.T) {
+	clk := useFakeClock(t, time.Now())
+	bus := NewEventBus()
+	bus.trackBulk("j1", "a", "select", []string{"a", "a/x"})
+	clk.Advance(bulkIdleTimeout)
+	bus.trackBulk("j2", "b", "select", []string{"b"})
+	assert.False(t, bus.inBulk("a/x"))
+	assert.True(t, bus.inBulk("b"))
+}
+
+func TestHandleSelect_TracksBulkJobs(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	t.Cleanup(func() {
+		events.bulkMu.Lock()
+		events.bulk = nil
+		events.bulkMu.Unlock()
+	})
+	for i := range bulkEventThreshold {
+		p := filepath.Join(archivesRoot, "Projects", "alpha", fmt.Sprintf("f%03d.txt", i))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte("x"), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "small.txt"), []byte("x"), 0644))
+	require.NoError(t, Seed(store, archivesRoot, spacesRoot))
+	projects := registered(t, store, archivesRoot, "Projects")
+	small := registered(t, store, archivesRoot, "small.txt")
+
+	body, _ := json.Marshal(SelectRequest{Inodes: []uint64{projects.Inode, small.Inode}})
+	w := httptest.NewRecorder()
+	h.HandleSelect(w, httptest.NewRequest("POST", "/api/sync/select", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, h.daemon.queue.Has("Projects/alpha/f000.txt"))
+	assert.True(t, events.inBulk("Projects"))
+	assert.True(t, events.inBulk("Projects/alpha/f099.txt"))
+	assert.False(t, events.inBulk("small.txt"), "small operations keep per-file events")
+}
+
+func TestPipeline_PublishesStatus(t *testing.T) {
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "a.txt", []byte("hello"))
+	env.run(t, "a.txt")
+	entry := registered(t, env.store, env.archivesRoot, "a.txt")
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "a.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "a.txt")
+	var got []any
+	for _, ev := range drain(ch) {
+		if ev.Type == EventStatus && ev.Path == "a.txt" {
+			got = append(got, ev.Data["status"])
+		}
+	}
+	assert.Equal(t, []any{"synced", "archived"}, got)
+}
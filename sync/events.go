@@ -0,0 +1,114 @@
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// Event types published on the event bus.
+const (
+	EventAutoArchived = "auto-archived"
+	EventMoved        = "moved"
+	EventAnomaly      = "anomaly"  // unusual Spaces activity, see AnomalyAlert
+	EventStatus       = "status"   // a path reached a status, e.g. synced; see publishStatus
+	EventProgress     = "progress" // progress of a bulk select or deselect, see trackBulk
+	EventJob          = "job"      // a selection job started, completed or was cancelled
+
+	// EventReset tells a reconnecting SSE client that events it missed
+	// are no longer logged, so it has to refetch its state.
+	EventReset = "reset"
+)
+
+// Event is a notification about a change in sync state, streamed to UI clients.
+type Event struct {
+	ID    int64          `json:"id,omitempty"` // sequence number in the event log, 0 if not logged
+	Type  string         `json:"type"`
+	Path  string         `json:"path,omitempty"`
+	Inode uint64         `json:"inode,omitempty"`
+	Time  int64          `json:"time"` // nanoseconds
+	Data  map[string]any `json:"data,omitempty"`
+}
+
+// eventBufferSize is the per-subscriber channel capacity. Slow subscribers
+// drop events rather than block publishers.
+const eventBufferSize = 64
+
+// eventLogRetention is how long published events stay in the event log
+// for SSE clients to replay after reconnecting.
+const eventLogRetention = time.Hour
+
+// EventBus fans out events to all current subscribers.
+type EventBus struct {
+	mu   gosync.Mutex
+	subs map[chan Event]struct{}
+	log  *Store // event log; nil while no daemon runs
+
+	bulkMu gosync.Mutex
+	bulk   map[string]*bulkJob // tracked bulk jobs by queued path
+}
+
+// events is the bus used by the daemon, pipeline and handlers.
+var events = NewEventBus()
+
+// NewEventBus creates an empty event bus.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a new subscriber. The returned func unsubscribes
+// and closes the channel.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// persistTo logs every published event to store until the returned func
+// is called. Events are numbered in the order subscribers receive them.
+func (b *EventBus) persistTo(store *Store) func() {
+	b.mu.Lock()
+	b.log = store
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		b.log = nil
+		b.mu.Unlock()
+	}
+}
+
+// Publish sends ev to every subscriber without blocking, logging it
+// first if the bus persists events. Status events of paths in a bulk job
+// are left to its progress events.
+func (b *EventBus) Publish(ev Event) {
+	if ev.Type == EventStatus && b.inBulk(ev.Path) {
+		return
+	}
+	if ev.Time == 0 {
+		ev.Time = nowNano()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.log != nil {
+		id, err := b.log.AppendEvent(ev, ev.Time-int64(eventLogRetention))
+		if err != nil {
+			sub("events").Warn("event not logged", "type", ev.Type, "path", ev.Path, "err", err)
+		}
+		ev.ID = id
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			sub("events").Warn("subscriber slow, event dropped", "type", ev.Type, "path", ev.Path)
+		}
+	}
+}
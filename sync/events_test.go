@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBus_PersistsAndNumbersEvents(t *testing.T) {
+	store := setupTestDB(t)
+	bus := NewEventBus()
+	defer bus.persistTo(store)()
+	ch, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	bus.Publish(Event{Type: EventMoved, Path: "a", Data: map[string]any{"from": "b"}})
+	bus.Publish(Event{Type: EventAutoArchived, Path: "c", Inode: 7})
+	first, second := <-ch, <-ch
+	assert.Equal(t, int64(1), first.ID)
+	assert.Equal(t, int64(2), second.ID)
+
+	evs, oldest, err := store.ListEventsAfter(1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), oldest)
+	require.Len(t, evs, 1)
+	assert.Equal(t, EventAutoArchived, evs[0].Type)
+	assert.Equal(t, uint64(7), evs[0].Inode)
+	evs, _, err = store.ListEventsAfter(0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"from": "b"}, evs[0].Data)
+}
+
+func TestEventBus_PrunesOldEvents(t *testing.T) {
+	store := setupTestDB(t)
+	bus := NewEventBus()
+	defer bus.persistTo(store)()
+
+	now := nowNano()
+	bus.Publish(Event{Type: EventMoved, Path: "old", Time: now - 2*int64(eventLogRetention)})
+	bus.Publish(Event{Type: EventMoved, Path: "new", Time: now})
+	evs, oldest, err := store.ListEventsAfter(0, 10)
+	require.NoError(t, err)
+	require.Len(t, evs, 1)
+	assert.Equal(t, "new", evs[0].Path)
+	assert.Equal(t, int64(2), oldest, "event 1 was pruned")
+}
+
+func TestHandleSSE_ReplaysMissedEvents(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	defer events.persistTo(store)()
+	for _, p := range []string{"a", "b", "c"} {
+		events.Publish(Event{Type: EventMoved, Path: p})
+	}
+
+	sse := func(lastID string) string {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // replay, then return instead of streaming
+		r := httptest.NewRequest("GET", "/api/sync/events", nil).WithContext(ctx)
+		if lastID != "" {
+			r.Header.Set("Last-Event-ID", lastID)
+		}
+		w := httptest.NewRecorder()
+		h.HandleSSE(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	body := sse("1")
+	assert.NotContains(t, body, "id: 1\n")
+	assert.Contains(t, body, "id: 2\nevent: moved\n")
+	assert.Contains(t, body, "id: 3\nevent: moved\n")
+	assert.Less(t, strings.Index(body, "id: 2"), strings.Index(body, "id: 3"))
+	assert.NotContains(t, body, EventReset)
+
+	assert.Empty(t, sse(""), "fresh clients get live events only")
+
+	_, err := store.db.Exec("DELETE FROM events WHERE id = 1")
+	require.NoError(t, err)
+	assert.Contains(t, sse("0"), "event: "+EventReset+"\n")
+}
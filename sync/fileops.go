@@ -8,9 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const copyChunkSize = 256 * 1024 // 256KB per chunk
+const copyChunkSize = 256 * 1024 // default 256KB per chunk, see Config.CopyChunkSize
 
 // slogDebug is a convenience alias for use in logEnabled() guards.
 const slogDebug = slog.LevelDebug
@@ -27,39 +30,188 @@ var ErrSourceModified = fmt.Errorf("source modified during copy")
 //
 // hasQueued is called between chunks to check if this path has been
 // re-queued (meaning a new event invalidated this copy). If nil, skipped.
+//
+// On local disks the tmp file's space is reserved before copying, so a
+// full disk fails the copy up front instead of most of the way in.
+//
+// Either side may be a remote file system. On a resumable destination an
+// interrupted copy keeps its temp file, and the next copy of the same
+// source version continues where it stopped. Local files of at least
+// Config.ParallelCopyMinMB are copied as Config.ParallelCopyStreams ranges
+// at once.
 func SafeCopy(ctx context.Context, src, dst string, hasQueued func() bool) error {
+	return safeCopy(ctx, src, dst, hasQueued, nil)
+}
+
+// safeCopy implements SafeCopy, writing what wrap makes of the source's
+// content when wrap is set. A wrapped copy can't be resumed.
+func safeCopy(ctx context.Context, src, dst string, hasQueued func() bool, wrap func(io.Reader) io.Reader) error {
+	return safeCopyChecked(ctx, src, dst, hasQueued, wrap, nil)
+}
+
+// safeCopyChecked is safeCopy that, when check is set, runs it on the
+// finished temp file before renaming it into place; a check failure
+// discards the copy and leaves dst as it was.
+func safeCopyChecked(ctx context.Context, src, dst string, hasQueued func() bool, wrap func(io.Reader) io.Reader, check func(tmpPath string) error) (err error) {
 	l := sub("fileops")
+	srcFS, dstFS := fsFor(src), fsFor(dst)
+
+	ctx, span := tracer().Start(ctx, "copy", trace.WithAttributes(attribute.String("sync.src", src), attribute.String("sync.dst", dst)))
+	defer func() { endSpan(span, err) }()
 
-	srcInfo, err := os.Stat(src)
+	srcInfo, err := srcFS.Stat(src)
 	if err != nil {
 		return fmt.Errorf("stat src: %w", err)
 	}
 	mtime1 := srcInfo.ModTime().UnixNano()
 	totalSize := srcInfo.Size()
+	span.SetAttributes(attribute.Int64("sync.size", totalSize))
 
 	l.Debug("SafeCopy start", "src", src, "dst", dst, "size", totalSize)
 	start := time.Now()
 
 	// Ensure destination directory exists
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+	if err := dstFS.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return fmt.Errorf("mkdir dst parent: %w", err)
 	}
 
 	tmpPath := dst + ".sync-tmp"
-	srcFile, err := os.Open(src)
+	r, ok := dstFS.(resumable)
+	resume := ok && r.Resumable() && wrap == nil
+	if !resume {
+		dstFS.Remove(tmpPath) //nolint:errcheck // start from scratch
+	}
+	removeTmp := func() {
+		dstFS.Remove(tmpPath)           //nolint:errcheck
+		dstFS.Remove(resumeMarker(dst)) //nolint:errcheck
+	}
+
+	var (
+		dev       uint64 // destination device, for chunks.observe
+		resumedAt int64  // bytes already in the tmp file when resuming
+	)
+	// finish checks a copy that read readN bytes of the source into
+	// tmpPath and, if it is whole and the source unchanged, renames it
+	// into place.
+	finish := func(readN int64, copyErr error) error {
+		if copyErr != nil {
+			if !resume {
+				removeTmp()
+			}
+			if ctx.Err() != nil {
+				l.Warn("SafeCopy aborted", "src", src, "dst", dst, "reason", "ctx cancelled", "resumable", resume)
+			} else {
+				l.Warn("SafeCopy aborted", "src", src, "dst", dst, "reason", copyErr.Error(), "resumable", resume)
+			}
+			return copyErr
+		}
+
+		// Verify source wasn't modified during copy
+		srcInfo2, err := srcFS.Stat(src)
+		if err != nil {
+			removeTmp()
+			return fmt.Errorf("re-stat src: %w", err)
+		}
+		mtime2 := srcInfo2.ModTime().UnixNano()
+		if mtime1 != mtime2 {
+			removeTmp()
+			l.Warn("SafeCopy source modified", "src", src, "mtime1", mtime1, "mtime2", mtime2)
+			return ErrSourceModified
+		}
+		l.Debug("SafeCopy mtime verified", "src", src, "mtime", mtime1)
+		if readN < totalSize {
+			// The source ended early without changing: a truncated read.
+			removeTmp()
+			return fmt.Errorf("read src: got %d of %d bytes", readN, totalSize)
+		}
+		if check != nil {
+			if err := check(tmpPath); err != nil {
+				removeTmp()
+				return err
+			}
+		}
+
+		// Preserve source mtime on destination
+		if err := dstFS.Chtimes(tmpPath, time.Now(), srcInfo.ModTime()); err != nil {
+			removeTmp()
+			return fmt.Errorf("chtimes tmp: %w", err)
+		}
+
+		if err := setSpacesOwner(dstFS, tmpPath, srcInfo); err != nil {
+			l.Warn("SafeCopy ownership not set", "dst", dst, "err", err)
+		}
+		// After the chown, which clears security.capability.
+		if err := carryXattrs(srcFS, dstFS, src, tmpPath); err != nil {
+			l.Warn("SafeCopy xattrs not copied", "src", src, "dst", dst, "err", err)
+		}
+
+		// Atomic rename
+		if err := dstFS.Rename(tmpPath, dst); err != nil {
+			removeTmp()
+			return fmt.Errorf("rename tmp to dst: %w", err)
+		}
+		if resume {
+			dstFS.Remove(resumeMarker(dst)) //nolint:errcheck
+		}
+		chunks.observe(dev, readN-resumedAt, time.Since(start))
+
+		l.Debug("SafeCopy complete", "src", src, "dst", dst, "size", totalSize, "durationMs", time.Since(start).Milliseconds())
+		return nil
+	}
+
+	if streams := parallelStreams(srcFS, dstFS, totalSize); wrap == nil && streams > 1 {
+		var chunk int
+		chunk, dev = chunks.chunkSize(dst, totalSize/int64(streams))
+		l.Debug("SafeCopy parallel", "dst", dst, "streams", streams, "chunk", chunk)
+		n, copyErr := copyRanges(ctx, src, tmpPath, totalSize, streams, chunk, hasQueued)
+		return finish(n, copyErr)
+	}
+
+	srcFile, err := openSource(srcFS, src)
 	if err != nil {
 		return fmt.Errorf("open src: %w", err)
 	}
 	defer srcFile.Close()
+	read := &readCounter{r: srcFile}
+	var in io.Reader = read
+	if wrap != nil {
+		in = wrap(read)
+	}
 
-	tmpFile, err := os.Create(tmpPath)
+	var copied int64
+	if resume {
+		if copied, err = resumeOffset(dstFS, dst, tmpPath, srcInfo); err != nil {
+			return err
+		}
+		if copied > 0 {
+			if _, err := io.CopyN(io.Discard, read, copied); err != nil {
+				return fmt.Errorf("skip copied part: %w", err)
+			}
+			l.Info("SafeCopy resuming", "src", src, "dst", dst, "offset", copied, "totalSize", totalSize)
+		}
+	}
+	tmpFile, _, err := dstFS.Append(tmpPath)
 	if err != nil {
 		return fmt.Errorf("create tmp: %w", err)
 	}
+	if f, ok := tmpFile.(*os.File); ok && wrap == nil {
+		// Reserve the rest of the file up front: less fragmentation, and a
+		// full disk fails the copy now rather than most of the way in.
+		if err := reserve(f, copied, totalSize-copied); err != nil {
+			tmpFile.Close()
+			if !resume {
+				removeTmp()
+			}
+			return fmt.Errorf("reserve tmp: %w", err)
+		}
+	}
 
-	buf := make([]byte, copyChunkSize)
+	var chunk int
+	chunk, dev = chunks.chunkSize(dst, totalSize-copied)
+	l.Debug("SafeCopy chunk size", "dst", dst, "chunk", chunk, "auto", activeConfig.Load().CopyChunkAuto)
+	buf := make([]byte, chunk)
+	resumedAt = copied
 	var copyErr error
-	var copied int64
 	chunkCount := 0
 	for {
 		// Check cancellation
@@ -79,13 +231,16 @@ func SafeCopy(ctx context.Context, src, dst string, hasQueued func() bool) error
 			break
 		}
 
-		n, readErr := srcFile.Read(buf)
+		n, readErr := in.Read(buf)
 		if n > 0 {
 			if _, writeErr := tmpFile.Write(buf[:n]); writeErr != nil {
 				copyErr = fmt.Errorf("write tmp: %w", writeErr)
 				break
 			}
 			copied += int64(n)
+			copyBytesMeter.Add(int64(n))
+			workBytes.Add(int64(n))
+			decisionFrom(ctx).addBytes(int64(n))
 			chunkCount++
 			// Log progress every ~1MB (4 chunks of 256KB)
 			if chunkCount%4 == 0 && logEnabled(slogDebug) {
@@ -101,76 +256,197 @@ func SafeCopy(ctx context.Context, src, dst string, hasQueued func() bool) error
 		}
 	}
 
-	tmpFile.Close()
+	if err := tmpFile.Close(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("close tmp: %w", err)
+	}
+
+	return finish(read.n, copyErr)
+}
+
+// readCounter counts the bytes read through it.
+type readCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// resumeMarker is the hidden file recording which source version the
+// partial temp file of dst holds.
+func resumeMarker(dst string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".sync-src")
+}
 
-	if copyErr != nil {
-		os.Remove(tmpPath)
-		if ctx.Err() != nil {
-			l.Warn("SafeCopy aborted", "src", src, "dst", dst, "reason", "ctx cancelled")
-		} else {
-			l.Warn("SafeCopy aborted", "src", src, "dst", dst, "reason", copyErr.Error())
+// resumeOffset returns how much of the source a partial temp file left
+// by an earlier copy already holds: its size if the marker names the same
+// source mtime and size, otherwise 0 after discarding the stale part and
+// recording the new source version.
+func resumeOffset(fs fileSystem, dst, tmpPath string, src os.FileInfo) (int64, error) {
+	want := fmt.Sprintf("%d %d", src.ModTime().UnixNano(), src.Size())
+	marker := resumeMarker(dst)
+	if tmp, err := fs.Stat(tmpPath); err == nil && tmp.Size() <= src.Size() {
+		if f, err := fs.Open(marker); err == nil {
+			got, err := io.ReadAll(io.LimitReader(f, 64))
+			f.Close()
+			if err == nil && string(got) == want {
+				return tmp.Size(), nil
+			}
 		}
-		return copyErr
 	}
 
-	// Verify source wasn't modified during copy
-	srcInfo2, err := os.Stat(src)
+	fs.Remove(tmpPath) //nolint:errcheck
+	fs.Remove(marker)  //nolint:errcheck
+	w, _, err := fs.Append(marker)
+	if err != nil {
+		return 0, fmt.Errorf("write resume marker: %w", err)
+	}
+	_, err = io.WriteString(w, want)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("re-stat src: %w", err)
+		return 0, fmt.Errorf("write resume marker: %w", err)
 	}
-	mtime2 := srcInfo2.ModTime().UnixNano()
-	if mtime1 != mtime2 {
-		os.Remove(tmpPath)
-		l.Warn("SafeCopy source modified", "src", src, "mtime1", mtime1, "mtime2", mtime2)
-		return ErrSourceModified
+	return 0, nil
+}
+
+// carryXattrs copies the extended attributes the destination root's
+// Config.ArchivesXattrs or SpacesXattrs asks for from src to tmpPath,
+// when both are local files.
+func carryXattrs(srcFS, dstFS fileSystem, src, tmpPath string) error {
+	x := activeConfig.Load().rootXattrs(tmpPath)
+	if !x.ACLs && !x.Security {
+		return nil
+	}
+	if _, ok := srcFS.(osFS); !ok {
+		return nil
+	}
+	if _, ok := dstFS.(osFS); !ok {
+		return nil
+	}
+	return copyXattrs(src, tmpPath, x)
+}
+
+// ErrDestinationExists is returned by SafeWrite when dst already exists.
+var ErrDestinationExists = fmt.Errorf("destination already exists")
+
+// SafeWrite writes r to dst atomically, with the same tmp-file-and-rename
+// semantics as SafeCopy: the data lands in dst.sync-tmp first, is synced,
+// and only then renamed into place, so watchers never see a partial file.
+// Unless overwrite is set, an existing dst is left alone and
+// ErrDestinationExists is returned. Returns the number of bytes written.
+func SafeWrite(ctx context.Context, r io.Reader, dst string, overwrite bool) (int64, error) {
+	l := sub("fileops")
+	fs := fsFor(dst)
+	if !overwrite {
+		if _, err := fs.Lstat(dst); err == nil {
+			return 0, ErrDestinationExists
+		}
+	}
+
+	tmpPath := dst + ".sync-tmp"
+	fs.Remove(tmpPath) //nolint:errcheck // start from scratch
+	tmpFile, _, err := fs.Append(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("create tmp: %w", err)
 	}
-	l.Debug("SafeCopy mtime verified", "src", src, "mtime", mtime1)
 
-	// Preserve source mtime on destination
-	if err := os.Chtimes(tmpPath, time.Now(), srcInfo.ModTime()); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("chtimes tmp: %w", err)
+	buf := make([]byte, activeConfig.Load().CopyChunkSize)
+	var written int64
+	var writeErr error
+	for {
+		if err := ctx.Err(); err != nil {
+			writeErr = err
+			break
+		}
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if _, err := tmpFile.Write(buf[:n]); err != nil {
+				writeErr = fmt.Errorf("write tmp: %w", err)
+				break
+			}
+			written += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			writeErr = fmt.Errorf("read: %w", readErr)
+			break
+		}
+	}
+	if f, ok := tmpFile.(interface{ Sync() error }); ok && writeErr == nil {
+		if err := f.Sync(); err != nil {
+			writeErr = fmt.Errorf("sync tmp: %w", err)
+		}
+	}
+	if err := tmpFile.Close(); err != nil && writeErr == nil {
+		writeErr = fmt.Errorf("close tmp: %w", err)
 	}
 
-	// Atomic rename
-	if err := os.Rename(tmpPath, dst); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("rename tmp to dst: %w", err)
+	if writeErr != nil {
+		fs.Remove(tmpPath) //nolint:errcheck
+		l.Warn("SafeWrite aborted", "dst", dst, "reason", writeErr.Error())
+		return written, writeErr
+	}
+	if !overwrite {
+		// Re-check: a file may have appeared while we were writing
+		if _, err := fs.Lstat(dst); err == nil {
+			fs.Remove(tmpPath) //nolint:errcheck
+			return written, ErrDestinationExists
+		}
+	}
+	if err := fs.Rename(tmpPath, dst); err != nil {
+		fs.Remove(tmpPath) //nolint:errcheck
+		return written, fmt.Errorf("rename tmp to dst: %w", err)
 	}
 
-	l.Debug("SafeCopy complete", "src", src, "dst", dst, "size", totalSize, "durationMs", time.Since(start).Milliseconds())
-	return nil
+	l.Debug("SafeWrite complete", "dst", dst, "size", written)
+	return written, nil
 }
 
 // SoftDelete moves a file to the trash directory (.trash/YYYY-MM-DD/).
 // Returns the final trash path.
 func SoftDelete(path, trashRoot string) (string, error) {
+	return SoftDeleteAt(path, trashRoot, filepath.Base(path))
+}
+
+// SoftDeleteAt is SoftDelete keeping the directories of relPath, the
+// item's path relative to its root, under the dated folder
+// (.trash/YYYY-MM-DD/dir/name), so items from different directories
+// don't collide and can be put back where they were.
+func SoftDeleteAt(path, trashRoot, relPath string) (string, error) {
 	l := sub("fileops")
 	l.Debug("SoftDelete start", "path", path)
+	fs := fsFor(path) // the trash root is mounted alongside its root
 
-	dateDir := filepath.Join(trashRoot, time.Now().Format("2006-01-02"))
-	if err := os.MkdirAll(dateDir, 0755); err != nil {
+	dateDir := filepath.Join(trashRoot, nowFunc().Format("2006-01-02"))
+	dir := filepath.Join(dateDir, filepath.Dir(relPath))
+	if err := fs.MkdirAll(dir, 0755); err != nil {
 		return "", fmt.Errorf("mkdir trash: %w", err)
 	}
 
 	base := filepath.Base(path)
-	trashPath := filepath.Join(dateDir, base)
+	trashPath := filepath.Join(dir, base)
 
 	// Handle name collision in trash
-	if _, err := os.Stat(trashPath); err == nil {
+	if _, err := fs.Stat(trashPath); err == nil {
 		for i := 1; ; i++ {
 			ext := filepath.Ext(base)
 			name := base[:len(base)-len(ext)]
-			trashPath = filepath.Join(dateDir, fmt.Sprintf("%s_%d%s", name, i, ext))
-			if _, err := os.Stat(trashPath); os.IsNotExist(err) {
+			trashPath = filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, i, ext))
+			if _, err := fs.Stat(trashPath); os.IsNotExist(err) {
 				break
 			}
 		}
 		l.Debug("SoftDelete collision", "base", base, "trashPath", trashPath)
 	}
 
-	if err := os.Rename(path, trashPath); err != nil {
+	if err := fs.Rename(path, trashPath); err != nil {
 		return "", fmt.Errorf("move to trash: %w", err)
 	}
 
@@ -186,10 +462,11 @@ func ConflictName(originalPath string) string {
 	base := filepath.Base(originalPath)
 	ext := filepath.Ext(base)
 	name := base[:len(base)-len(ext)]
+	fs := fsFor(dir)
 
 	for i := 1; ; i++ {
 		candidate := fmt.Sprintf("%s_conflict-%d%s", name, i, ext)
-		if _, err := os.Stat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+		if _, err := fs.Stat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
 			sub("fileops").Debug("ConflictName resolved", "original", originalPath, "conflictName", candidate)
 			return candidate
 		}
@@ -206,16 +483,17 @@ func RenameConflict(path string) (string, error) {
 	base := filepath.Base(path)
 	ext := filepath.Ext(base)
 	name := base[:len(base)-len(ext)]
+	fs := fsFor(path)
 
 	var newPath string
 	for i := 1; ; i++ {
 		newPath = filepath.Join(dir, fmt.Sprintf("%s_conflict-%d%s", name, i, ext))
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
+		if _, err := fs.Stat(newPath); os.IsNotExist(err) {
 			break
 		}
 	}
 
-	if err := os.Rename(path, newPath); err != nil {
+	if err := fs.Rename(path, newPath); err != nil {
 		return "", fmt.Errorf("rename conflict: %w", err)
 	}
 
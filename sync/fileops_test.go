@@ -2,6 +2,7 @@ package sync
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -108,6 +109,46 @@ func TestSafeCopy_HasQueuedAborts(t *testing.T) {
 	assert.Contains(t, err.Error(), "re-queued")
 }
 
+func TestSafeCopy_Parallel(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ParallelCopyMinMB, c.ParallelCopyStreams = 1, 4 })
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	dst := filepath.Join(dir, "dst.bin")
+
+	// Not a multiple of the streams or the chunk size, so the last range
+	// and chunk are short.
+	data := make([]byte, 3<<20+12345)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	require.NoError(t, os.WriteFile(src, data, 0644))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(src, past, past))
+
+	require.NoError(t, SafeCopy(context.Background(), src, dst, nil))
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	dstInfo, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Equal(t, past.UnixNano(), dstInfo.ModTime().UnixNano())
+	assert.NoFileExists(t, dst+".sync-tmp")
+}
+
+func TestSafeCopy_ParallelHasQueuedAborts(t *testing.T) {
+	withConfig(t, func(c *Config) { c.ParallelCopyMinMB, c.ParallelCopyStreams = 1, 4 })
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	dst := filepath.Join(dir, "dst.bin")
+	require.NoError(t, os.WriteFile(src, make([]byte, 2<<20), 0644))
+
+	err := SafeCopy(context.Background(), src, dst, func() bool { return true })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "re-queued")
+	assert.NoFileExists(t, dst)
+	assert.NoFileExists(t, dst+".sync-tmp")
+}
+
 func TestSoftDelete(t *testing.T) {
 	dir := t.TempDir()
 	trashRoot := filepath.Join(dir, ".trash")
@@ -151,6 +192,25 @@ func TestSoftDelete_NameCollision(t *testing.T) {
 	assert.Len(t, entries, 3)
 }
 
+func TestSoftDeleteAt_KeepsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	trashRoot := filepath.Join(dir, ".trash")
+	dateDir := filepath.Join(trashRoot, time.Now().Format("2006-01-02"))
+
+	for _, rel := range []string{"a/notes.txt", "b/notes.txt"} {
+		filePath := filepath.Join(dir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte(rel), 0644))
+
+		trashPath, err := SoftDeleteAt(filePath, trashRoot, rel)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dateDir, rel), trashPath, "no collision across directories")
+		got, err := os.ReadFile(trashPath)
+		require.NoError(t, err)
+		assert.Equal(t, rel, string(got))
+	}
+}
+
 func TestRenameConflict(t *testing.T) {
 	dir := t.TempDir()
 	filePath := filepath.Join(dir, "report.txt")
@@ -186,3 +246,56 @@ func TestRenameConflict_Multiple(t *testing.T) {
 	require.NoError(t, err)
 	assert.Contains(t, path2, "conflict-2")
 }
+
+// BenchmarkSafeCopy copies a 16 MB file at several Config.CopyChunkSize
+// values.
+func BenchmarkSafeCopy(b *testing.B) {
+	restoreConfig(b)
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	data := make([]byte, 16<<20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(b, os.WriteFile(src, data, 0644))
+
+	for _, chunk := range []int{4 << 10, 64 << 10, copyChunkSize, 1 << 20, 4 << 20} {
+		b.Run(fmt.Sprintf("chunk=%dKB", chunk>>10), func(b *testing.B) {
+			cfg := currentConfig()
+			cfg.CopyChunkSize = chunk
+			require.NoError(b, setConfig(cfg))
+			dst := filepath.Join(dir, "dst.bin")
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := SafeCopy(context.Background(), src, dst, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSafeCopy_Parallel(b *testing.B) {
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	data := make([]byte, 64<<20)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(b, os.WriteFile(src, data, 0644))
+
+	for _, streams := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("streams=%d", streams), func(b *testing.B) {
+			withConfig(b, func(c *Config) { c.ParallelCopyMinMB, c.ParallelCopyStreams = 1, streams })
+			dst := filepath.Join(dir, "dst.bin")
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := SafeCopy(context.Background(), src, dst, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TypeCategories lists the file categories ClassifyType can return for files.
+// Directories are always "dir".
+var TypeCategories = []string{
+	"video", "audio", "image", "pdf", "text", "code",
+	"archive", "spreadsheet", "ebook", "blob",
+}
+
+// validCategory reports whether c is a known file category.
+func validCategory(c string) bool {
+	for _, known := range TypeCategories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultTypeTable maps lowercase extensions (with dot) to categories.
+// Config.Types entries take precedence.
+var defaultTypeTable = buildTypeTable(map[string][]string{
+	"video": {".mp4", ".mkv", ".avi", ".mov", ".wmv", ".flv", ".webm", ".m4v", ".mpg", ".mpeg", ".m2ts", ".3gp"},
+	"audio": {".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a", ".opus", ".aiff", ".alac"},
+	"image": {".jpg", ".jpeg", ".png", ".gif", ".bmp", ".svg", ".webp", ".tiff", ".tif", ".ico", ".heic", ".heif", ".avif", ".raw", ".cr2", ".nef", ".arw", ".dng"},
+	"pdf":   {".pdf"},
+	"text":  {".txt", ".md", ".markdown", ".rst", ".log", ".json", ".xml", ".yaml", ".yml", ".toml", ".ini", ".conf", ".srt", ".vtt"},
+	"code": {".go", ".py", ".js", ".ts", ".jsx", ".tsx", ".html", ".htm", ".css", ".scss", ".sh", ".bash", ".zsh",
+		".c", ".h", ".cpp", ".hpp", ".cc", ".java", ".kt", ".rs", ".rb", ".php", ".vue", ".sql", ".swift", ".cs", ".lua", ".pl"},
+	"archive":     {".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar", ".iso", ".dmg"},
+	"spreadsheet": {".xls", ".xlsx", ".ods", ".csv", ".tsv", ".numbers"},
+	"ebook":       {".epub", ".mobi", ".azw", ".azw3", ".fb2", ".djvu", ".cbz", ".cbr"},
+})
+
+func buildTypeTable(byCategory map[string][]string) map[string]string {
+	table := make(map[string]string)
+	for category, exts := range byCategory {
+		for _, ext := range exts {
+			table[ext] = category
+		}
+	}
+	return table
+}
+
+// normalizeExt lowercases ext and ensures a leading dot.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// validateTypes checks Config.Types overrides.
+func validateTypes(types map[string]string) error {
+	for ext, category := range types {
+		if e := normalizeExt(ext); e == "" || e == "." {
+			return fmt.Errorf("empty extension")
+		}
+		if !validCategory(category) {
+			return fmt.Errorf("extension %q: unknown category %q", ext, category)
+		}
+	}
+	return nil
+}
+
+// TypeTable returns the effective extension → category table:
+// the built-in defaults overlaid with Config.Types.
+func TypeTable() map[string]string {
+	table := make(map[string]string, len(defaultTypeTable))
+	for ext, category := range defaultTypeTable {
+		table[ext] = category
+	}
+	for ext, category := range activeConfig.Load().Types {
+		table[normalizeExt(ext)] = category
+	}
+	return table
+}
+
+// ClassifyType determines the file type from its extension.
+// Returns "dir" or one of TypeCategories. Lookup order: Config.Types,
+// the built-in table, then the system MIME database.
+func ClassifyType(name string, isDir bool) string {
+	if isDir {
+		return "dir"
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return "blob"
+	}
+
+	for e, category := range activeConfig.Load().Types {
+		if normalizeExt(e) == ext {
+			return category
+		}
+	}
+	if category, ok := defaultTypeTable[ext]; ok {
+		return category
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		return "blob"
+	}
+	return classifyMIME(mimeType, ext)
+}
+
+// ClassifyFile classifies a file by name and, for extensionless files,
+// falls back to sniffing the leading bytes at path.
+func ClassifyFile(path, name string) string {
+	if filepath.Ext(name) != "" {
+		return ClassifyType(name, false)
+	}
+	return sniffType(path)
+}
+
+func classifyMIME(mimeType, ext string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	major, subtype, _ := strings.Cut(strings.TrimSpace(mimeType), "/")
+	switch major {
+	case "video":
+		return "video"
+	case "audio":
+		return "audio"
+	case "image":
+		return "image"
+	case "text":
+		return "text"
+	case "application":
+		return classifyApplication(subtype, ext)
+	}
+	return "blob"
+}
+
+func classifyApplication(subtype, ext string) string {
+	if subtype == "pdf" || ext == ".pdf" {
+		return "pdf"
+	}
+	if strings.Contains(subtype, "json") || strings.Contains(subtype, "xml") {
+		return "text"
+	}
+	if strings.Contains(subtype, "javascript") || strings.Contains(subtype, "typescript") {
+		return "code"
+	}
+	if strings.Contains(subtype, "zip") || strings.Contains(subtype, "compressed") ||
+		strings.Contains(subtype, "tar") || strings.Contains(subtype, "gzip") {
+		return "archive"
+	}
+	if strings.Contains(subtype, "epub") {
+		return "ebook"
+	}
+	return "blob"
+}
+
+// sniffLen is the number of leading bytes read by sniffType.
+const sniffLen = 512
+
+// magicSignatures are checked before http.DetectContentType, which
+// doesn't know archive formats beyond zip/gzip/rar.
+var magicSignatures = []struct {
+	offset   int
+	magic    []byte
+	category string
+}{
+	{0, []byte("%PDF-"), "pdf"},
+	{0, []byte("PK\x03\x04"), "archive"},
+	{0, []byte("\x1f\x8b"), "archive"},
+	{0, []byte("BZh"), "archive"},
+	{0, []byte("\xfd7zXZ\x00"), "archive"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "archive"},
+	{0, []byte("Rar!\x1a\x07"), "archive"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "archive"}, // zstd
+	{257, []byte("ustar"), "archive"},
+}
+
+// sniffType classifies a file by its leading bytes. Unreadable or
+// unrecognised content is "blob".
+func sniffType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "blob"
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "blob"
+	}
+	head = head[:n]
+
+	for _, sig := range magicSignatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.category
+		}
+	}
+	contentType := http.DetectContentType(head)
+	if contentType == "application/octet-stream" {
+		return "blob"
+	}
+	return classifyMIME(contentType, "")
+}
@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyType_ConfigOverride(t *testing.T) {
+	restoreConfig(t)
+	assert.Equal(t, "blob", ClassifyType("scene.blend", false))
+
+	cfg := currentConfig()
+	cfg.Types = map[string]string{"blend": "image", ".MD": "code"}
+	require.NoError(t, setConfig(cfg))
+	assert.Equal(t, "image", ClassifyType("scene.blend", false))
+	assert.Equal(t, "code", ClassifyType("README.md", false), "override beats built-in table")
+
+	cfg.Types = map[string]string{".x": "nope"}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestClassifyFile_SniffsExtensionless(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, data, 0644))
+		return p
+	}
+
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar")
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"pdfdoc", []byte("%PDF-1.7\n"), "pdf"},
+		{"zipped", []byte("PK\x03\x04rest"), "archive"},
+		{"tarball", tarHeader, "archive"},
+		{"pngfile", testPNG(t, 1, 1), "image"},
+		{"README", []byte("plain words\n"), "text"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0xff}, "blob"},
+		{"empty", nil, "blob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClassifyFile(write(tt.name, tt.data), tt.name))
+		})
+	}
+
+	// Extension wins over content
+	assert.Equal(t, "text", ClassifyFile(write("fake.txt", []byte("%PDF-1.7")), "fake.txt"))
+}
+
+func TestPatchConfig_TypesDoNotAliasActiveConfig(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.Types = map[string]string{".blend": "image"}
+	require.NoError(t, setConfig(cfg))
+	before := currentConfig().Types
+
+	_, err := patchConfig([]byte(`{"types":{".blend":"","cbl":"code"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{".blend": "image"}, before, "previous config untouched")
+	assert.Equal(t, map[string]string{"cbl": "code"}, currentConfig().Types)
+
+	_, err = patchConfig([]byte(`{"types":{".x":"bogus"}}`))
+	assert.Error(t, err)
+}
+
+func TestHandleTypes(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	w := httptest.NewRecorder()
+	h.HandleTypes(w, httptest.NewRequest("GET", "/api/sync/types", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `".epub":"ebook"`))
+}
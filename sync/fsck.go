@@ -0,0 +1,303 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"time"
+)
+
+// POST /api/sync/fsck runs the diff (see diff.go) and sorts every path it
+// found into the pipeline scenario it is in. With repair=true the paths
+// are then fed to the eval queue at a limited rate, pausing while the
+// queue holds more than a second's worth, so a post-crash repair doesn't
+// starve other work; the run tallies each path by the scenario it was
+// fixed from, or failed to be. With wait=true the request returns once
+// the repair is done, for scripting it from the command line.
+
+// fsckTick is how often a repair feeds the queue.
+var fsckTick = 100 * time.Millisecond
+
+const (
+	defaultFsckRate = 100 // paths per second
+	fsckRunsKept    = 10
+)
+
+// FsckReport is the summary of an fsck run.
+type FsckReport struct {
+	ID         string        `json:"id,omitempty"` // set for repairs, to read their progress
+	Repair     bool          `json:"repair"`
+	Entries    int           `json:"entries"` // registered entries compared
+	Archives   DiffCounts    `json:"archives"`
+	Spaces     DiffCounts    `json:"spaces"`
+	Paths      int           `json:"paths"`     // distinct paths with a discrepancy
+	Scenarios  map[int]int   `json:"scenarios"` // paths found per scenario
+	Queued     int           `json:"queued"`    // paths fed to the queue so far
+	Fixed      map[int]int   `json:"fixed"`     // paths run through the pipeline, by the scenario they were in
+	Failed     map[int]int   `json:"failed"`    // paths whose run failed, by scenario
+	Complete   bool          `json:"complete"`
+	StartedAt  int64         `json:"startedAt"`            // nanoseconds
+	FinishedAt int64         `json:"finishedAt,omitempty"` // nanoseconds
+	done       chan struct{} // closed once complete
+}
+
+type fsckPath struct {
+	relPath  string
+	size     int64
+	isDir    bool
+	scenario int
+}
+
+// fsckRuns tracks repairs until their last path has been through the
+// pipeline.
+type fsckRuns struct {
+	mu      gosync.Mutex
+	runs    map[string]*FsckReport
+	pending map[string]int // path -> scenario, of the running repair
+	running *FsckReport
+	order   []string // IDs, oldest first
+}
+
+func newFsckRuns() *fsckRuns {
+	return &fsckRuns{runs: make(map[string]*FsckReport)}
+}
+
+// start tracks report as the running repair of paths, unless one is
+// running already, which it returns instead.
+func (t *fsckRuns) start(report *FsckReport, paths []fsckPath) (running *FsckReport, err error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running != nil {
+		return t.running, nil
+	}
+	report.ID = hex.EncodeToString(b)
+	report.done = make(chan struct{})
+	t.pending = make(map[string]int, len(paths))
+	for _, p := range paths {
+		t.pending[p.relPath] = p.scenario
+	}
+	t.runs[report.ID] = report
+	t.order = append(t.order, report.ID)
+	t.running = report
+	if len(paths) == 0 {
+		t.completeLocked()
+	}
+	for len(t.order) > fsckRunsKept && t.runs[t.order[0]].Complete {
+		delete(t.runs, t.order[0])
+		t.order = t.order[1:]
+	}
+	return nil, nil
+}
+
+// queued counts n more paths of the running repair fed to the queue.
+func (t *fsckRuns) queued(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running != nil {
+		t.running.Queued += n
+	}
+}
+
+// finish records that the pipeline ran on relPath, failed or not.
+func (t *fsckRuns) finish(relPath string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sc, ok := t.pending[relPath]
+	if !ok {
+		return
+	}
+	delete(t.pending, relPath)
+	if failed {
+		t.running.Failed[sc]++
+	} else {
+		t.running.Fixed[sc]++
+	}
+	if len(t.pending) == 0 {
+		t.completeLocked()
+	}
+}
+
+// completeLocked ends the running repair. Caller must hold t.mu.
+func (t *fsckRuns) completeLocked() {
+	t.running.Complete = true
+	t.running.FinishedAt = nowNano()
+	close(t.running.done)
+	t.running, t.pending = nil, nil
+}
+
+// get returns a copy of the run with id, or false.
+func (t *fsckRuns) get(id string) (FsckReport, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report, ok := t.runs[id]
+	if !ok {
+		return FsckReport{}, false
+	}
+	return report.snapshot(), true
+}
+
+// snapshot copies the report. Caller must hold the lock guarding it.
+func (r *FsckReport) snapshot() FsckReport {
+	c := *r
+	c.Fixed, c.Failed = cloneCounts(r.Fixed), cloneCounts(r.Failed)
+	return c
+}
+
+func cloneCounts(m map[int]int) map[int]int {
+	c := make(map[int]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// fsck diffs the DB against both roots and sorts the paths found into
+// their scenarios, parents first.
+func (d *Daemon) fsck(store *Store) (*FsckReport, []fsckPath, error) {
+	diff, err := d.diffTree(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &FsckReport{
+		Entries:   diff.Entries,
+		Archives:  diff.Archives,
+		Spaces:    diff.Spaces,
+		Scenarios: make(map[int]int),
+		Fixed:     make(map[int]int),
+		Failed:    make(map[int]int),
+		StartedAt: nowNano(),
+	}
+	seen := make(map[string]bool)
+	var paths []fsckPath
+	for _, item := range diff.Items {
+		if seen[item.Path] {
+			continue
+		}
+		seen[item.Path] = true
+		entry, sv, err := lookupDB(store, d.archivesRoot, item.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		aMtime, aIsDir, _, aSize := statFile(filepath.Join(d.archivesRoot, item.Path))
+		sMtime, _, _, _ := statFile(filepath.Join(d.spacesRoot, item.Path))
+		p := fsckPath{relPath: item.Path, scenario: ComputeState(entry, sv, aMtime, sMtime).Scenario()}
+		if entry != nil {
+			p.size, p.isDir = sizeOrZero(entry.Size), entry.Type == "dir"
+		} else if aSize != nil {
+			p.size, p.isDir = *aSize, *aIsDir
+		}
+		report.Scenarios[p.scenario]++
+		paths = append(paths, p)
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return len(splitPath(paths[i].relPath)) < len(splitPath(paths[j].relPath))
+	})
+	report.Paths = len(paths)
+	return report, paths, nil
+}
+
+// feedRepair pushes paths to the queue at rate paths per second, a share
+// every tick, holding back while the queue holds more than rate paths.
+func (d *Daemon) feedRepair(paths []fsckPath, rate int, tick time.Duration) {
+	perTick := max(1, rate*int(tick)/int(time.Second))
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for len(paths) > 0 {
+		if d.queue.Len() <= rate {
+			n := min(perTick, len(paths))
+			for _, p := range paths[:n] {
+				d.queue.PushSized(p.relPath, p.size, p.isDir)
+			}
+			d.fsckRuns.queued(n)
+			paths = paths[n:]
+		}
+		if len(paths) > 0 {
+			<-ticker.C
+		}
+	}
+}
+
+// HandleFsck handles POST /api/sync/fsck?repair=true&rate=N&wait=true
+// Without repair it only reports the discrepancies by scenario. With
+// repair it queues them at up to N paths per second (default 100) and
+// returns 202 with the report to follow at GET /api/sync/fsck/{id}, or
+// with wait the final report once every path has run. A repair already
+// running is returned with 409.
+func (h *Handlers) HandleFsck(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	q := r.URL.Query()
+	repair := q.Get("repair") == "true"
+	rate := defaultFsckRate
+	if v := q.Get("rate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid rate", http.StatusBadRequest)
+			return
+		}
+		rate = n
+	}
+
+	report, paths, err := h.daemon.fsck(h.store)
+	if err != nil {
+		l.Error("fsck failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Info("HTTP fsck", "repair", repair, "entries", report.Entries, "paths", report.Paths, "scenarios", report.Scenarios)
+	w.Header().Set("Content-Type", "application/json")
+	if !repair {
+		report.Complete, report.FinishedAt = true, nowNano()
+		json.NewEncoder(w).Encode(report) //nolint:errcheck
+		return
+	}
+
+	report.Repair = true
+	running, err := h.daemon.fsckRuns.start(report, paths)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if running != nil {
+		snap, _ := h.daemon.fsckRuns.get(running.ID)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(snap) //nolint:errcheck
+		return
+	}
+	go h.daemon.feedRepair(paths, rate, fsckTick)
+	if q.Get("wait") == "true" {
+		select {
+		case <-report.done:
+		case <-r.Context().Done():
+			return
+		}
+		snap, _ := h.daemon.fsckRuns.get(report.ID)
+		json.NewEncoder(w).Encode(snap) //nolint:errcheck
+		return
+	}
+	snap, _ := h.daemon.fsckRuns.get(report.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snap) //nolint:errcheck
+}
+
+// HandleFsckStatus handles GET /api/sync/fsck/{id}
+// Returns the progress of a repair.
+func (h *Handlers) HandleFsckStatus(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.daemon.fsckRuns.get(path.Base(r.URL.Path))
+	if !ok {
+		http.Error(w, "fsck run not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report) //nolint:errcheck
+}
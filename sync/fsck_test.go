@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postFsck(t *testing.T, h *Handlers, query string, wantCode int) FsckReport {
+	t.Helper()
+	w := postJSON(h.HandleFsck, "/api/sync/fsck?"+query, "")
+	require.Equal(t, wantCode, w.Code, w.Body.String())
+	var report FsckReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return report
+}
+
+func TestHandleFsck_RepairsDiscrepancies(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/ok.txt", "Docs/copy.txt"}, map[string]bool{"Docs/copy.txt": true})
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/new.txt"), []byte("new"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Docs/copy.txt")))
+
+	report := postFsck(t, h, "", http.StatusOK)
+	assert.False(t, report.Repair)
+	assert.Equal(t, 2, report.Paths)
+	assert.Equal(t, map[int]int{2: 1, 21: 1}, report.Scenarios, "untracked in Archives; synced copy missing")
+	assert.Zero(t, h.daemon.Queue().Len(), "nothing is queued without repair")
+
+	prev := fsckTick
+	fsckTick = time.Millisecond
+	t.Cleanup(func() { fsckTick = prev })
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		h.daemon.worker(ctx, 0)
+	}()
+	defer func() { cancel(); <-stopped }()
+
+	report = postFsck(t, h, "repair=true&wait=true&rate=1", http.StatusOK)
+	assert.True(t, report.Complete)
+	assert.Equal(t, 2, report.Queued)
+	assert.Equal(t, map[int]int{2: 1, 21: 1}, report.Fixed)
+	assert.Empty(t, report.Failed)
+	got, ok := h.daemon.fsckRuns.get(report.ID)
+	require.True(t, ok)
+	assert.True(t, got.Complete)
+
+	diff, err := h.daemon.diffTree(store)
+	require.NoError(t, err)
+	assert.Empty(t, diff.Items, "the repair left no discrepancy")
+	report = postFsck(t, h, "repair=true", http.StatusAccepted)
+	assert.True(t, report.Complete, "nothing to repair")
+}
+
+func TestFsckRuns_OneRepairAtATime(t *testing.T) {
+	runs := newFsckRuns()
+	first := &FsckReport{Fixed: map[int]int{}, Failed: map[int]int{}}
+	running, err := runs.start(first, []fsckPath{{relPath: "a", scenario: 17}, {relPath: "b", scenario: 21}})
+	require.NoError(t, err)
+	require.Nil(t, running)
+	running, err = runs.start(&FsckReport{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, running.ID)
+
+	runs.finish("a", true)
+	runs.finish("other", false)
+	got, _ := runs.get(first.ID)
+	assert.False(t, got.Complete)
+	assert.Equal(t, map[int]int{17: 1}, got.Failed)
+	runs.finish("b", false)
+	got, _ = runs.get(first.ID)
+	assert.True(t, got.Complete)
+	assert.Equal(t, map[int]int{21: 1}, got.Fixed)
+	<-first.done
+}
@@ -1,41 +1,100 @@
 package sync
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
+	"time"
 )
 
 // SyncEntryResponse is a single entry in the API response.
 type SyncEntryResponse struct {
-	Inode              uint64 `json:"inode"`
-	Name               string `json:"name"`
-	Type               string `json:"type"`
-	Size               *int64 `json:"size"`
-	Mtime              int64  `json:"mtime"`
-	Selected           bool   `json:"selected"`
-	Status             string `json:"status"`
-	ChildTotalCount    *int   `json:"childTotalCount,omitempty"`
-	ChildSelectedCount *int   `json:"childSelectedCount,omitempty"`
+	Inode              uint64    `json:"inode"`
+	Name               string    `json:"name"`
+	Path               string    `json:"path"` // relative to the roots
+	Type               string    `json:"type"`
+	Size               *int64    `json:"size"`
+	Mtime              int64     `json:"mtime"`
+	Selected           bool      `json:"selected"`
+	Excluded           bool      `json:"excluded"`
+	Starred            bool      `json:"starred"`
+	Tags               []string  `json:"tags,omitempty"`
+	Status             string    `json:"status"`
+	ChildTotalCount    *int      `json:"childTotalCount,omitempty"`
+	ChildSelectedCount *int      `json:"childSelectedCount,omitempty"`
+	Selection          string    `json:"selection,omitempty"` // dirs only: all|partial|none
+	Metadata           *Metadata `json:"metadata,omitempty"`  // with ?metadata=1, media only
+	HeldUntil          int64     `json:"heldUntil,omitempty"` // nanoseconds, while held from syncing
+	RemovalAt          int64     `json:"removalAt,omitempty"` // nanoseconds, when a pending-removal Spaces copy is trashed
+
+	Filter     *SelectFilter `json:"filter,omitempty"`     // dirs only: the filter they were selected with
+	AutoSelect bool          `json:"autoSelect,omitempty"` // dirs only: new entries below are selected
+
+	Verification *Verification `json:"verification,omitempty"` // files only: outcome of verifying the latest copy, if sampled
+
+	InSpacesView bool   `json:"inSpacesView"`           // a spaces_view row records a synced copy
+	ArchiveMtime *int64 `json:"archiveMtime,omitempty"` // nanoseconds, on disk; nil if missing
+	SpacesMtime  *int64 `json:"spacesMtime,omitempty"`  // nanoseconds, on disk; nil if missing
 }
 
 // SyncStatsResponse holds aggregate sync statistics.
 type SyncStatsResponse struct {
-	DiskTotal    int64 `json:"diskTotal"`
+	DiskTotal    int64 `json:"diskTotal"` // the Archives file system; see Disks
 	DiskFree     int64 `json:"diskFree"`
 	ArchivesSize int64 `json:"archivesSize"`
 	SpacesSize   int64 `json:"spacesSize"`
+
+	Disks DiskStats `json:"disks"` // each root's file system, and the trash
+
+	QueueLen     int     `json:"queueLen"`
+	BytesPending int64   `json:"bytesPending"` // selected bytes not yet in Spaces
+	Throughput   float64 `json:"throughput"`   // copy bytes/s, rolling 60s
+	ItemsPerSec  float64 `json:"itemsPerSec"`  // pipeline runs/s, rolling 60s
+	EtaSeconds   int64   `json:"etaSeconds"`   // -1 = unknown
+
+	QueueAgeSecs int64        `json:"queueAgeSeconds"` // how long the oldest queued path has waited
+	Latency      QueueLatency `json:"latency"`         // queue wait and run time over the latest runs
+
+	CopyChunks []CopyChunkStat `json:"copyChunks,omitempty"` // copyChunkAuto: chunk size per destination device
+
+	RootClocks []RootClock `json:"rootClocks,omitempty"` // probed mtime granularity and clock skew per root
+
+	Idle     bool `json:"idle"`     // low-power mode: no user action for lowPowerIdleMinutes
+	Deferred bool `json:"deferred"` // low-power mode: background work waits for a user or a spinning disk
+
+	DB DBStats `json:"db"`
+
+	Verify VerifyStats `json:"verify"` // sampled copy verification since start
+
+	Watcher WatcherHealth `json:"watcher"` // watcher self-test, see watchprobe.go
+
+	Scenarios []ScenarioCount `json:"scenarios"`
 }
 
+// defaultReportThreshold is how long a path must stay non-terminal before
+// HandleReport lists it.
+const defaultReportThreshold = 5 * time.Minute
+
 // Handlers holds the HTTP handlers for the sync API.
 type Handlers struct {
 	store        *Store
 	daemon       *Daemon
 	archivesRoot string
 	spacesRoot   string
+
+	breakdown *breakdownCache
 }
 
 // NewHandlers creates the sync HTTP handlers.
@@ -45,20 +104,48 @@ func NewHandlers(store *Store, daemon *Daemon, archivesRoot, spacesRoot string)
 		daemon:       daemon,
 		archivesRoot: archivesRoot,
 		spacesRoot:   spacesRoot,
+		breakdown:    &breakdownCache{},
+	}
+}
+
+// forRequest returns the handlers with their store bound to r's context,
+// so the queries of a read the client abandoned are cancelled. Other
+// methods pair disk changes with DB updates, which must not stop halfway:
+// they keep the context's values but not its cancellation.
+func (h *Handlers) forRequest(r *http.Request) *Handlers {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		ctx = context.WithoutCancel(ctx)
 	}
+	c := *h
+	c.store = h.store.WithContext(ctx)
+	return &c
 }
 
 // HandleListEntries handles GET /api/sync/entries?path=<path> or ?parent_ino=<ino>
+// With metadata=1, extracted media metadata is included. Each tag=<name>
+// parameter restricts the listing to entries carrying that tag. With
+// status=<status>, the entries in that status under the directory are
+// listed instead of its children; see listEntriesInStatus.
 func (h *Handlers) HandleListEntries(w http.ResponseWriter, r *http.Request) {
 	l := sub("handlers")
+	h = h.forRequest(r)
 	pathParam := r.URL.Query().Get("path")
 	piParam := r.URL.Query().Get("parent_ino")
+	withMetadata := r.URL.Query().Get("metadata") == "1"
+	tagFilter := r.URL.Query()["tag"]
 	l.Info("HTTP list entries", "method", r.Method, "path", pathParam, "parentIno", piParam)
 
 	var parentIno uint64 // 0 = root
 	if pathParam != "" {
-		if pathParam != "/" {
-			ino, err := h.resolvePathToIno(pathParam)
+		relPath, err := h.checkPath(pathParam)
+		if err != nil {
+			l.Warn("list entries: unsafe path", "path", pathParam)
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		if relPath != "" {
+			ino, err := h.resolvePathToIno(relPath)
 			if err != nil {
 				l.Warn("list entries: path not found", "path", pathParam)
 				http.Error(w, "path not found", http.StatusNotFound)
@@ -75,7 +162,15 @@ func (h *Handlers) HandleListEntries(w http.ResponseWriter, r *http.Request) {
 		}
 		parentIno = pi
 	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		h.listEntriesInStatus(w, r, parentIno, status)
+		return
+	}
 
+	if err := h.daemon.ensureListed(parentIno); err != nil {
+		l.Warn("list entries: lazy registration failed", "parentIno", parentIno, "err", err)
+	}
+	h.daemon.touchActive(parentIno)
 	children, err := h.store.ListChildren(parentIno)
 	if err != nil {
 		l.Error("list entries failed", "err", err)
@@ -87,57 +182,174 @@ func (h *Handlers) HandleListEntries(w http.ResponseWriter, r *http.Request) {
 	parentRelPath := h.resolveRelPathFromIno(parentIno)
 
 	items := make([]SyncEntryResponse, 0, len(children))
-	for _, child := range children {
-		item := SyncEntryResponse{
-			Inode:    child.Inode,
-			Name:     child.Name,
-			Type:     child.Type,
-			Size:     child.Size,
-			Mtime:    child.Mtime,
-			Selected: child.Selected,
-		}
-
+	for i := range children {
+		child := &children[i]
 		// Build full relative path for this child
 		childRelPath := child.Name
 		if parentRelPath != "" {
 			childRelPath = parentRelPath + "/" + child.Name
 		}
 
-		// Compute status
-		sv, _ := h.store.GetSpacesView(child.Inode)
-		archiveMtime, _, _, _ := statFile(filepath.Join(h.archivesRoot, childRelPath))
-		spacesMtime, _, _, _ := statFile(filepath.Join(h.spacesRoot, childRelPath))
-		state := ComputeState(&child, sv, archiveMtime, spacesMtime)
-		item.Status = state.UIStatus()
-
-		// Add child counts for directories
-		if child.Type == "dir" {
-			total, sel, err := h.store.ChildCounts(child.Inode)
-			if err == nil {
-				item.ChildTotalCount = &total
-				item.ChildSelectedCount = &sel
-			}
+		item, err := h.entryResponse(child, childRelPath, withMetadata)
+		if err != nil {
+			l.Error("list entries: tags failed", "inode", child.Inode, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !hasAllTags(item.Tags, tagFilter) {
+			continue
 		}
-
 		items = append(items, item)
 	}
+	h.fillSelection(items)
 
 	l.Debug("list entries response", "count", len(items), "parentIno", parentIno)
+	resp := map[string]interface{}{"items": items}
+	if h.daemon.seedStatus.seeding() {
+		// Listings are partial until the startup seed has registered everything.
+		resp["seeding"] = true
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
-		"items": items,
-	})
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
+}
+
+// entryResponse builds the API shape of an entry at relPath, with its
+// status computed from the live disk state of both roots. It fails only
+// if the entry's tags can't be read. A directory's selection tri-state is
+// left to fillSelection, which computes it for a whole listing at once.
+func (h *Handlers) entryResponse(entry *Entry, relPath string, withMetadata bool) (SyncEntryResponse, error) {
+	item := SyncEntryResponse{
+		Inode:    entry.Inode,
+		Name:     entry.Name,
+		Path:     relPath,
+		Type:     entry.Type,
+		Size:     entry.Size,
+		Mtime:    entry.Mtime,
+		Selected: entry.Selected,
+		Excluded: entry.Excluded,
+		Starred:  entry.Starred,
+	}
+
+	tags, err := h.store.EntryTags(entry.Inode)
+	if err != nil {
+		return item, err
+	}
+	item.Tags = tags
+
+	// Compute status
+	sv, _ := h.store.GetSpacesView(entry.Inode)
+	archiveMtime, _, _, _ := statFile(filepath.Join(h.archivesRoot, relPath))
+	spacesMtime, _, _, _ := statFile(filepath.Join(h.spacesRoot, relPath))
+	state := ComputeState(entry, sv, archiveMtime, spacesMtime)
+	item.Status = state.UIStatus()
+	item.InSpacesView = sv != nil
+	item.ArchiveMtime, item.SpacesMtime = archiveMtime, spacesMtime
+	if !entry.Selected && state.SDisk {
+		if due, err := h.store.RemovalDue(entry.Inode); err == nil && due > 0 {
+			item.Status = StatusPendingRemoval
+			item.RemovalAt = due
+		}
+	}
+	if entry.Selected && !state.SDisk && entry.Type != "dir" {
+		if lc, err := h.store.LargeCopy(entry.Inode); err == nil && lc != nil && !lc.Confirmed {
+			item.Status = StatusNeedsConfirmation
+		}
+	}
+	if until := holds.heldUntil(entry.Inode); !until.IsZero() {
+		item.HeldUntil = until.UnixNano()
+	}
+	if entry.Type != "dir" {
+		if v, err := h.verification(entry.Inode); err == nil {
+			item.Verification = v
+		}
+	}
+
+	// Add child counts for directories
+	if entry.Type == "dir" {
+		total, sel, err := h.store.ChildCounts(entry.Inode)
+		if err == nil {
+			item.ChildTotalCount = &total
+			item.ChildSelectedCount = &sel
+		}
+		if filter, err := h.store.GetSelectionFilter(entry.Inode); err == nil {
+			item.Filter = filter
+		}
+		if auto, err := h.store.AutoSelect(entry.Inode); err == nil {
+			item.AutoSelect = auto
+		}
+	}
+
+	if withMetadata && entry.Type != "dir" {
+		if m, err := h.store.GetMetadata(entry.Inode); err == nil {
+			item.Metadata = m
+		}
+		if d, err := h.store.GetDerivative(entry.Inode); err == nil && d != nil {
+			if item.Metadata == nil {
+				item.Metadata = &Metadata{EntryIno: entry.Inode}
+			}
+			item.Metadata.Derivative = d
+		}
+	}
+	return item, nil
+}
+
+// fillSelection sets the selection tri-state of the directories among
+// items, computed in one query.
+func (h *Handlers) fillSelection(items []SyncEntryResponse) {
+	var dirs []uint64
+	for _, item := range items {
+		if item.Type == "dir" {
+			dirs = append(dirs, item.Inode)
+		}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	states, err := h.store.SelectionStates(dirs)
+	if err != nil {
+		sub("handlers").Warn("selection states failed", "dirs", len(dirs), "err", err)
+		return
+	}
+	for i := range items {
+		if items[i].Type == "dir" {
+			items[i].Selection = states[items[i].Inode]
+		}
+	}
+}
+
+// hasAllTags reports whether tags contains every name in want (case-insensitive).
+func hasAllTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if strings.EqualFold(t, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // resolvePathToIno walks down the entries tree to find the inode for a given path.
-// Returns 0 for root.
+// Returns 0 for root, and errUnsafePath for paths with ".." segments.
 func (h *Handlers) resolvePathToIno(path string) (uint64, error) {
-	parts := strings.Split(strings.Trim(path, "/"), "/")
+	relPath, err := safeRelPath(path)
+	if err != nil {
+		return 0, err
+	}
+	parts := strings.Split(relPath, "/")
 	var parentIno uint64
 	for _, part := range parts {
 		if part == "" {
 			continue
 		}
+		if err := h.daemon.ensureListed(parentIno); err != nil {
+			return 0, err
+		}
 		entry, err := h.store.GetEntryByPath(parentIno, part)
 		if err != nil || entry == nil {
 			return 0, err
@@ -160,9 +372,12 @@ func (h *Handlers) resolveRelPathFromIno(ino uint64) string {
 	return h.resolveRelPath(entry)
 }
 
-// HandleGetEntry handles GET /api/sync/entry/<inode>
+// HandleGetEntry handles GET /api/sync/entry/<inode>, returning the entry
+// in the same shape as a listing item. With metadata=1, extracted media
+// metadata is included.
 func (h *Handlers) HandleGetEntry(w http.ResponseWriter, r *http.Request) {
 	l := sub("handlers")
+	h = h.forRequest(r)
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) == 0 {
 		http.Error(w, "missing inode", http.StatusBadRequest)
@@ -188,44 +403,165 @@ func (h *Handlers) HandleGetEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	item, err := h.entryResponse(entry, h.resolveRelPath(entry), r.URL.Query().Get("metadata") == "1")
+	if err != nil {
+		l.Error("get entry: tags failed", "inode", ino, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := []SyncEntryResponse{item}
+	h.fillSelection(items)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items[0]) //nolint:errcheck
+}
+
+// EntryPatch is the request body for PATCH /api/sync/entry/<inode>.
+// Omitted fields are left unchanged.
+type EntryPatch struct {
+	Starred    *bool `json:"starred"`
+	AutoSelect *bool `json:"autoSelect"` // dirs only: select everything new below
+}
+
+// HandlePatchEntry handles PATCH /api/sync/entry/<inode>
+func (h *Handlers) HandlePatchEntry(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	ino, err := inodeFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid inode", http.StatusBadRequest)
+		return
+	}
+	var patch EntryPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		l.Warn("patch entry: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.store.GetEntry(ino)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	if patch.AutoSelect != nil && entry.Type != "dir" {
+		http.Error(w, "autoSelect applies to directories only", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP patch entry", "inode", ino, "starred", patch.Starred, "autoSelect", patch.AutoSelect)
+
+	if patch.Starred != nil {
+		if err := h.store.SetStarred([]uint64{ino}, *patch.Starred); err != nil {
+			l.Error("set starred failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry.Starred = *patch.Starred
+	}
+	if patch.AutoSelect != nil {
+		if err := h.store.SetAutoSelect(ino, *patch.AutoSelect); err != nil {
+			l.Error("set auto-select failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(entry) //nolint:errcheck
 }
 
+// StarredEntryResponse is a starred entry with its path from the root.
+type StarredEntryResponse struct {
+	Entry
+	Path string `json:"path"`
+}
+
+// HandleStarred handles GET /api/sync/starred
+func (h *Handlers) HandleStarred(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	entries, err := h.store.ListStarred()
+	if err != nil {
+		l.Error("list starred failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]StarredEntryResponse, 0, len(entries))
+	for i := range entries {
+		items = append(items, StarredEntryResponse{Entry: entries[i], Path: h.resolveRelPath(&entries[i])})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items}) //nolint:errcheck
+}
+
 // SelectRequest is the request body for select/deselect.
+// Exclude lists descendant inodes whose subtrees keep their current state.
 type SelectRequest struct {
-	Inodes []uint64 `json:"inodes"`
+	Inodes  []uint64      `json:"inodes"`
+	Exclude []uint64      `json:"exclude,omitempty"`
+	Filter  *SelectFilter `json:"filter,omitempty"` // select only: narrows the selected folders
 }
 
 // HandleSelect handles POST /api/sync/select
 func (h *Handlers) HandleSelect(w http.ResponseWriter, r *http.Request) {
 	l := sub("handlers")
+	h = h.forRequest(r)
 	var req SelectRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		l.Warn("select: bad body", "err", err)
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if req.Filter != nil {
+		if err := req.Filter.validate(); err != nil {
+			http.Error(w, "filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	l.Info("HTTP select", "inodes", req.Inodes, "count", len(req.Inodes))
+	l.Info("HTTP select", "inodes", req.Inodes, "count", len(req.Inodes), "filter", req.Filter)
 
-	if err := h.store.SetSelected(req.Inodes, true); err != nil {
+	for _, ino := range req.Inodes {
+		if err := h.daemon.ensureSubtree(ino); err != nil {
+			l.Error("select: lazy registration failed", "inode", ino, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	opID, err := h.store.SetSelectedFiltered(req.Inodes, req.Exclude, req.Filter)
+	if err != nil {
 		l.Error("select failed", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.daemon.snapshotSelection(req.Inodes)
 
 	// Push to eval queue — daemon worker will run pipeline
-	h.pushInodesToQueue(req.Inodes)
+	job, err := h.pushInodesToQueue(r.Context(), req.Inodes, "select", opID)
+	if err != nil {
+		l.Error("select: start job failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.pokeWatchScope()
 
-	l.Info("HTTP select complete", "count", len(req.Inodes))
+	l.Info("HTTP select complete", "count", len(req.Inodes), "job", job)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "job": job}) //nolint:errcheck
 }
 
 // HandleDeselect handles POST /api/sync/deselect
 func (h *Handlers) HandleDeselect(w http.ResponseWriter, r *http.Request) {
 	l := sub("handlers")
+	h = h.forRequest(r)
 	var req SelectRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		l.Warn("deselect: bad body", "err", err)
@@ -235,112 +571,1727 @@ func (h *Handlers) HandleDeselect(w http.ResponseWriter, r *http.Request) {
 
 	l.Info("HTTP deselect", "inodes", req.Inodes, "count", len(req.Inodes))
 
-	if err := h.store.SetSelected(req.Inodes, false); err != nil {
+	opID, err := h.store.SetSelectedUndoable(req.Inodes, false, req.Exclude)
+	if err != nil {
 		l.Error("deselect failed", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Push to eval queue — daemon worker will run pipeline
-	h.pushInodesToQueue(req.Inodes)
+	job, err := h.pushInodesToQueue(r.Context(), req.Inodes, "deselect", opID)
+	if err != nil {
+		l.Error("deselect: start job failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.pokeWatchScope()
 
-	l.Info("HTTP deselect complete", "count", len(req.Inodes))
+	l.Info("HTTP deselect complete", "count", len(req.Inodes), "job", job)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "job": job}) //nolint:errcheck
 }
 
-// HandleStats handles GET /api/sync/stats
-func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
-	l := sub("handlers")
-	l.Debug("HTTP stats")
+// HandleExclude handles POST /api/sync/exclude
+// Excluded entries are deselected and never synced, even when an
+// ancestor directory is selected later.
+func (h *Handlers) HandleExclude(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSetExcluded(w, r, true)
+}
 
-	archivesSize, err := h.store.AggregateTotalSize()
-	if err != nil {
-		l.Error("stats failed", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// HandleInclude handles POST /api/sync/include
+// It clears the exclude flag; selection is left unchanged.
+func (h *Handlers) HandleInclude(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSetExcluded(w, r, false)
+}
+
+func (h *Handlers) handleSetExcluded(w http.ResponseWriter, r *http.Request, excluded bool) {
+	l := sub("handlers")
+	var req SelectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("exclude: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	spacesSize, err := h.store.AggregateSelectedSize()
-	if err != nil {
-		l.Error("stats failed", "err", err)
+	l.Info("HTTP set excluded", "inodes", req.Inodes, "excluded", excluded)
+
+	if err := h.store.SetExcluded(req.Inodes, excluded); err != nil {
+		l.Error("set excluded failed", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var stat syscall.Statfs_t
-	var diskTotal, diskFree int64
-	if err := syscall.Statfs(h.archivesRoot, &stat); err == nil {
-		diskTotal = int64(stat.Blocks) * int64(stat.Bsize)
-		diskFree = int64(stat.Bavail) * int64(stat.Bsize)
+	// Excluding deselects the subtree — let the pipeline remove Spaces copies.
+	// appendChildJobs skips excluded children, so queue them explicitly.
+	if excluded {
+		for _, ino := range req.Inodes {
+			entry, err := h.store.GetEntry(ino)
+			if err != nil || entry == nil {
+				continue
+			}
+			relPath := h.resolveRelPath(entry)
+			h.daemon.Queue().PushTraced(r.Context(), relPath, sizeOrZero(entry.Size), entry.Type == "dir")
+			if entry.Type == "dir" {
+				h.pushSubtreeToQueue(r.Context(), ino, relPath)
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(SyncStatsResponse{ //nolint:errcheck
-		DiskTotal:    diskTotal,
-		DiskFree:     diskFree,
-		ArchivesSize: archivesSize,
-		SpacesSize:   spacesSize,
-	})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck
 }
 
-// pushInodesToQueue resolves inodes to relative paths and pushes them
-// to the eval queue for the daemon worker to process.
-func (h *Handlers) pushInodesToQueue(inodes []uint64) {
+// HoldRequest is the request body for hold/unhold.
+type HoldRequest struct {
+	Inodes     []uint64 `json:"inodes"`
+	TTLSeconds int      `json:"ttlSeconds,omitempty"` // hold only; 0 = 10 minutes, capped at 24h
+}
+
+// HoldResponse is an entry held from syncing.
+type HoldResponse struct {
+	Inode uint64 `json:"inode"`
+	Path  string `json:"path"`
+	Until int64  `json:"until"` // nanoseconds
+}
+
+// HandleHold handles POST /api/sync/hold
+// Held entries are left alone by the pipeline's change sync (P2) and
+// goal realization (P3) until the TTL runs out or they are unheld.
+func (h *Handlers) HandleHold(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleHold(w, r, true)
+}
+
+// HandleUnhold handles POST /api/sync/unhold
+func (h *Handlers) HandleUnhold(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleHold(w, r, false)
+}
+
+func (h *Handlers) handleHold(w http.ResponseWriter, r *http.Request, hold bool) {
 	l := sub("handlers")
-	for _, ino := range inodes {
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("hold: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP hold", "inodes", req.Inodes, "hold", hold, "ttlSeconds", req.TTLSeconds)
+
+	ttl := holdTTL(req.TTLSeconds)
+	items := make([]HoldResponse, 0, len(req.Inodes))
+	for _, ino := range req.Inodes {
 		entry, err := h.store.GetEntry(ino)
-		if err != nil || entry == nil {
-			continue
+		if err != nil {
+			l.Error("hold: get entry failed", "inode", ino, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			http.Error(w, fmt.Sprintf("entry %d not found", ino), http.StatusNotFound)
+			return
 		}
 		relPath := h.resolveRelPath(entry)
-		if relPath == "" {
+		if !hold {
+			h.daemon.Release(ino, relPath)
 			continue
 		}
+		until := h.daemon.Hold(ino, relPath, ttl)
+		items = append(items, HoldResponse{Inode: ino, Path: relPath, Until: until.UnixNano()})
+	}
 
-		h.daemon.Queue().Push(relPath)
-		l.Debug("queued for eval", "path", relPath, "inode", ino)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": items}) //nolint:errcheck
+}
 
-		if entry.Type == "dir" {
-			h.pushChildrenToQueue(ino, relPath)
+// HandleListHolds handles GET /api/sync/holds
+func (h *Handlers) HandleListHolds(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	active := holds.list()
+	items := make([]HoldResponse, 0, len(active))
+	for ino, until := range active {
+		entry, err := h.store.GetEntry(ino)
+		if err != nil || entry == nil {
+			continue
 		}
+		items = append(items, HoldResponse{Inode: ino, Path: h.resolveRelPath(entry), Until: until.UnixNano()})
 	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": items}) //nolint:errcheck
 }
 
-func (h *Handlers) resolveRelPath(entry *Entry) string {
-	if entry == nil {
-		return ""
-	}
+// maxTagLen bounds the length of a tag name in bytes.
+const maxTagLen = 64
 
-	// Walk up parent chain
-	var parts []string
-	current := entry
-	for current != nil {
-		parts = append([]string{current.Name}, parts...)
-		if current.ParentIno == 0 {
-			break
-		}
-		parent, err := h.store.GetEntry(current.ParentIno)
-		if err != nil || parent == nil {
-			break
+// TagRequest is the request body for tag/untag.
+type TagRequest struct {
+	Inodes []uint64 `json:"inodes"`
+	Tags   []string `json:"tags"`
+}
+
+// HandleTag handles POST /api/sync/tag
+func (h *Handlers) HandleTag(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSetTags(w, r, true)
+}
+
+// HandleUntag handles POST /api/sync/untag
+func (h *Handlers) HandleUntag(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSetTags(w, r, false)
+}
+
+func (h *Handlers) handleSetTags(w http.ResponseWriter, r *http.Request, add bool) {
+	l := sub("handlers")
+	var req TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("tag: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	tags := make([]string, 0, len(req.Tags))
+	for _, t := range req.Tags {
+		t = strings.TrimSpace(t)
+		if t == "" || len(t) > maxTagLen {
+			http.Error(w, fmt.Sprintf("invalid tag %q", t), http.StatusBadRequest)
+			return
 		}
-		current = parent
+		tags = append(tags, t)
+	}
+
+	l.Info("HTTP set tags", "inodes", req.Inodes, "tags", tags, "add", add)
+
+	var err error
+	if add {
+		err = h.store.TagEntries(req.Inodes, tags)
+	} else {
+		err = h.store.UntagEntries(req.Inodes, tags)
+	}
+	if err != nil {
+		l.Error("set tags failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	return strings.Join(parts, "/")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck
 }
 
-func (h *Handlers) pushChildrenToQueue(parentIno uint64, parentPath string) {
-	children, err := h.store.ListChildren(parentIno)
+// HandleListTags handles GET /api/sync/tags
+func (h *Handlers) HandleListTags(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	tags, err := h.store.ListTags()
 	if err != nil {
+		sub("handlers").Error("list tags failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	for _, child := range children {
-		childPath := parentPath + "/" + child.Name
-		h.daemon.Queue().Push(childPath)
-		if child.Type == "dir" {
-			h.pushChildrenToQueue(child.Inode, childPath)
-		}
+	if tags == nil {
+		tags = []Tag{}
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": tags}) //nolint:errcheck
 }
 
+// defaultSearchLimit and maxSearchLimit bound the hits HandleSearch returns.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// HandleSearch handles GET /api/sync/search?content=<words>&limit=<n>,
+// searching the text of synced documents. It needs Config.ContentIndex.
+func (h *Handlers) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	q := r.URL.Query()
+	content := strings.TrimSpace(q.Get("content"))
+	if content == "" {
+		http.Error(w, "content is required", http.StatusBadRequest)
+		return
+	}
+	if !currentConfig().ContentIndex {
+		http.Error(w, "content index is disabled", http.StatusNotFound)
+		return
+	}
+	limit := defaultSearchLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	hits, err := h.store.SearchContent(content, limit)
+	if err != nil {
+		l.Error("content search failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hits == nil {
+		hits = []ContentHit{}
+	}
+	for i := range hits {
+		hits[i].Path = h.resolveRelPath(&hits[i].Entry)
+	}
+	l.Debug("HTTP search", "content", content, "count", len(hits))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": hits}) //nolint:errcheck
+}
+
+// HandleListUnconfirmed handles GET /api/sync/unconfirmed: the new Spaces
+// files held by a hold policy, waiting to be confirmed or rejected.
+func (h *Handlers) HandleListUnconfirmed(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	items, err := h.store.ListUnconfirmed()
+	if err != nil {
+		sub("handlers").Error("list unconfirmed failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []Unconfirmed{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items}) //nolint:errcheck
+}
+
+// UnconfirmedRequest names held Spaces files by their paths.
+type UnconfirmedRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// HandleConfirm handles POST /api/sync/unconfirmed/confirm: the files are
+// copied into Archives and registered selected, as without the policy.
+func (h *Handlers) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.resolveUnconfirmed(w, r, UnconfirmedConfirmed)
+}
+
+// HandleReject handles POST /api/sync/unconfirmed/reject: the files are
+// moved to the trash without being archived.
+func (h *Handlers) HandleReject(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.resolveUnconfirmed(w, r, UnconfirmedRejected)
+}
+
+func (h *Handlers) resolveUnconfirmed(w http.ResponseWriter, r *http.Request, status string) {
+	l := sub("handlers")
+	var req UnconfirmedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Paths) == 0 {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	found, err := h.store.ResolveUnconfirmed(req.Paths, status)
+	if err != nil {
+		l.Error("resolve unconfirmed failed", "status", status, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, p := range found {
+		h.daemon.Queue().PushTraced(r.Context(), p, 0, false)
+	}
+	l.Info("HTTP unconfirmed resolved", "status", status, "count", len(found))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"resolved": len(found)}) //nolint:errcheck
+}
+
+// HandleDownload handles GET /api/sync/download?inodes=1,2&format=zip|tar
+// Streams the requested entries (directories recursively) from Archives.
+// Tar bundles support range requests; zip bundles are streamed only.
+// Each file is read while holding its path against the workers.
+func (h *Handlers) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	q := r.URL.Query()
+
+	var inodes []uint64
+	for _, s := range strings.Split(q.Get("inodes"), ",") {
+		if s == "" {
+			continue
+		}
+		ino, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid inodes", http.StatusBadRequest)
+			return
+		}
+		inodes = append(inodes, ino)
+	}
+	if len(inodes) == 0 {
+		http.Error(w, "missing inodes", http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar" {
+		http.Error(w, "format must be zip or tar", http.StatusBadRequest)
+		return
+	}
+
+	items, total, err := collectBundle(h.store, h.archivesRoot, inodes, currentConfig().DownloadMaxBytes)
+	if errors.Is(err, errBundleTooLarge) {
+		l.Warn("download rejected: too large", "inodes", inodes, "limit", currentConfig().DownloadMaxBytes)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		l.Warn("download: collect failed", "inodes", inodes, "err", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	l.Info("HTTP download", "inodes", inodes, "format", format, "files", len(items), "bytes", total)
+	filename := fmt.Sprintf("archives-%s.%s", nowFunc().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Accept-Ranges", "none")
+		if err := writeZip(w, items, h.daemon.acquirePath); err != nil {
+			l.Warn("download: zip stream failed", "err", err)
+		}
+		return
+	}
+
+	bundle, err := newTarBundle(items, h.daemon.acquirePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer bundle.Close()
+	var modTime time.Time
+	for _, it := range items {
+		if it.modTime.After(modTime) {
+			modTime = it.modTime
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	http.ServeContent(w, r, filename, modTime, bundle)
+}
+
+// cleanRelPath normalises a client-supplied relative path and guarantees
+// it stays inside the root it is joined to. "" and "/" mean the root.
+func cleanRelPath(p string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// validFileName rejects names that could escape the target directory or
+// collide with SafeCopy temp files.
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\") && !strings.HasSuffix(name, ".sync-tmp")
+}
+
+// HandleUpload handles POST /api/sync/upload?path=<dir>&select=1&overwrite=1
+// The multipart body's file parts are written into the Archives directory
+// at path and registered immediately, without waiting for the watcher.
+func (h *Handlers) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	q := r.URL.Query()
+	dirRel, err := h.checkPath(q.Get("path"))
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	selected := q.Get("select") == "1"
+	overwrite := q.Get("overwrite") == "1"
+
+	var parentIno uint64
+	if dirRel != "" {
+		ino, err := h.resolvePathToIno(dirRel)
+		if err != nil || ino == 0 {
+			http.Error(w, "target directory not found", http.StatusNotFound)
+			return
+		}
+		parent, err := h.store.GetEntry(ino)
+		if err != nil || parent == nil || parent.Type != "dir" {
+			http.Error(w, "target is not a directory", http.StatusBadRequest)
+			return
+		}
+		parentIno = ino
+	}
+
+	if limit := currentConfig().UploadMaxBytes; limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart body", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP upload", "path", dirRel, "select", selected, "overwrite", overwrite)
+
+	items := make([]Entry, 0)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "upload exceeds size limit", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue // not a file part
+		}
+		name := filepath.Base(part.FileName())
+		if !validFileName(name) {
+			part.Close()
+			http.Error(w, fmt.Sprintf("invalid file name %q", part.FileName()), http.StatusBadRequest)
+			return
+		}
+
+		relPath := name
+		if dirRel != "" {
+			relPath = dirRel + "/" + name
+		}
+		dst := filepath.Join(h.archivesRoot, relPath)
+		_, err = SafeWrite(r.Context(), part, dst, overwrite)
+		part.Close()
+		if errors.Is(err, ErrDestinationExists) {
+			http.Error(w, fmt.Sprintf("%s already exists", relPath), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "upload exceeds size limit", http.StatusRequestEntityTooLarge)
+				return
+			}
+			l.Error("upload write failed", "path", relPath, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		mtime, _, inode, size := statFile(dst)
+		if inode == nil {
+			http.Error(w, "stat uploaded file failed", http.StatusInternalServerError)
+			return
+		}
+		entry := Entry{
+			Inode:     *inode,
+			ParentIno: parentIno,
+			Name:      name,
+			Type:      ClassifyFile(dst, name),
+			Size:      size,
+			Mtime:     *mtime,
+			Selected:  selected,
+		}
+		if err := h.store.UpsertEntry(entry); err != nil {
+			l.Error("upload register failed", "path", relPath, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if selected {
+			// UpsertEntry keeps the flag of an existing row on overwrite
+			if err := h.store.SetSelected([]uint64{entry.Inode}, true); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		h.daemon.Queue().PushTraced(r.Context(), relPath, sizeOrZero(size), false)
+		l.Info("upload registered", "path", relPath, "inode", entry.Inode, "size", sizeOrZero(size), "selected", selected)
+		items = append(items, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items}) //nolint:errcheck
+}
+
+// MoveRequest is the request body for move. Paths are relative to the roots.
+type MoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// HandleMove handles POST /api/sync/move
+// Renames the entry in Archives and, if present, its Spaces copy, then
+// updates the DB in one transaction so the watcher's rename events are no-ops.
+// Both paths are held against the workers for the whole move.
+func (h *Handlers) HandleMove(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("move: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	from, errFrom := h.checkPath(req.From)
+	to, errTo := h.checkPath(req.To)
+	if errFrom != nil || errTo != nil || from == "" || to == "" || !validFileName(filepath.Base(to)) {
+		http.Error(w, "from and to must name entries below the root", http.StatusBadRequest)
+		return
+	}
+	if from == to {
+		http.Error(w, "from and to are the same", http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(to, from+"/") {
+		http.Error(w, "cannot move a directory into itself", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP move", "from", from, "to", to)
+	// Hold both paths, in the order workers take them, so no pipeline
+	// runs on either side while the move is half done.
+	release := h.daemon.acquirePaths(from, to)
+	defer release()
+
+	ino, err := h.resolvePathToIno(from)
+	if err != nil || ino == 0 {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+	entry, err := h.store.GetEntry(ino)
+	if err != nil || entry == nil {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+	newParentIno, err := resolveParentInoFromDB(h.store, to)
+	if err != nil {
+		http.Error(w, "target directory not found", http.StatusNotFound)
+		return
+	}
+	newName := filepath.Base(to)
+	if existing, err := h.store.GetEntryByPath(newParentIno, newName); err != nil || existing != nil {
+		http.Error(w, fmt.Sprintf("%s already exists", to), http.StatusConflict)
+		return
+	}
+
+	aFrom, aTo := filepath.Join(h.archivesRoot, from), filepath.Join(h.archivesRoot, to)
+	sFrom, sTo := filepath.Join(h.spacesRoot, from), filepath.Join(h.spacesRoot, to)
+	sfs := fsFor(h.spacesRoot)
+	if _, err := os.Lstat(aTo); err == nil {
+		http.Error(w, fmt.Sprintf("%s already exists", to), http.StatusConflict)
+		return
+	}
+	if _, err := sfs.Lstat(sTo); err == nil {
+		http.Error(w, fmt.Sprintf("%s already exists in Spaces", to), http.StatusConflict)
+		return
+	}
+
+	if err := os.Rename(aFrom, aTo); err != nil {
+		l.Error("move: archives rename failed", "from", from, "to", to, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	movedSpaces := false
+	if _, err := sfs.Lstat(sFrom); err == nil {
+		if err := sfs.MkdirAll(filepath.Dir(sTo), 0755); err == nil {
+			err = sfs.Rename(sFrom, sTo)
+		}
+		if err != nil {
+			l.Error("move: spaces rename failed, reverting", "from", from, "to", to, "err", err)
+			os.Rename(aTo, aFrom) //nolint:errcheck
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		movedSpaces = true
+	}
+
+	if err := h.store.MoveEntry(ino, newParentIno, newName); err != nil {
+		l.Error("move: db update failed, reverting", "from", from, "to", to, "err", err)
+		if movedSpaces {
+			sfs.Rename(sTo, sFrom) //nolint:errcheck
+		}
+		os.Rename(aTo, aFrom) //nolint:errcheck
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.daemon.pathCache.Clear()
+	pipelineStats.forget(from)
+
+	entry.ParentIno, entry.Name = newParentIno, newName
+	events.Publish(Event{Type: EventMoved, Path: to, Inode: ino, Data: map[string]any{"from": from}})
+	l.Info("move complete", "from", from, "to", to, "inode", ino, "spaces", movedSpaces)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry) //nolint:errcheck
+}
+
+// CopyRequest is the request body for copy. Paths are relative to the roots.
+// InheritSelection copies each source entry's selected flag; otherwise
+// the duplicate starts out archived.
+type CopyRequest struct {
+	From             string `json:"from"`
+	To               string `json:"to"`
+	InheritSelection bool   `json:"inheritSelection"`
+}
+
+// HandleCopy handles POST /api/sync/copy
+// Duplicates a file or directory within Archives with SafeCopy and
+// registers every copied entry.
+func (h *Handlers) HandleCopy(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("copy: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	from, errFrom := h.checkPath(req.From)
+	to, errTo := h.checkPath(req.To)
+	if errFrom != nil || errTo != nil || from == "" || to == "" || !validFileName(filepath.Base(to)) {
+		http.Error(w, "from and to must name entries below the root", http.StatusBadRequest)
+		return
+	}
+	if to == from || strings.HasPrefix(to, from+"/") {
+		http.Error(w, "cannot copy an entry into itself", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP copy", "from", from, "to", to, "inheritSelection", req.InheritSelection)
+	// Hold both paths, as a move does, so no pipeline runs on the source
+	// or the target while the copy is half done.
+	release := h.daemon.acquirePaths(from, to)
+	defer release()
+
+	srcIno, err := h.resolvePathToIno(from)
+	if err != nil || srcIno == 0 {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+	parentIno, err := resolveParentInoFromDB(h.store, to)
+	if err != nil {
+		http.Error(w, "target directory not found", http.StatusNotFound)
+		return
+	}
+	if _, err := os.Lstat(filepath.Join(h.archivesRoot, to)); err == nil {
+		http.Error(w, fmt.Sprintf("%s already exists", to), http.StatusConflict)
+		return
+	}
+
+	// Source selection by path relative to the copied root
+	srcSelected := map[string]bool{}
+	if req.InheritSelection {
+		src, err := h.store.GetEntry(srcIno)
+		if err == nil && src != nil {
+			srcSelected["."] = src.Selected
+			h.collectSelection(srcIno, "", srcSelected)
+		}
+	}
+
+	// What was copied before a failure stays registered, and syncs as
+	// selected.
+	copied, err := h.copyTree(r.Context(), from, to, parentIno, srcSelected)
+	for relPath, entry := range copied {
+		if entry.Selected {
+			h.daemon.Queue().PushTraced(r.Context(), relPath, sizeOrZero(entry.Size), entry.Type == "dir")
+		}
+	}
+	if err != nil {
+		l.Error("copy failed", "from", from, "to", to, "copied", len(copied), "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Info("copy complete", "from", from, "to", to, "entries", len(copied))
+
+	root := copied[to]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(root) //nolint:errcheck
+}
+
+// collectSelection records the selected flag of every descendant of
+// parentIno, keyed by slash path relative to the copied root.
+func (h *Handlers) collectSelection(parentIno uint64, prefix string, out map[string]bool) {
+	h.store.walkTree(parentIno, prefix, func(child *Entry, rel string) (bool, error) { //nolint:errcheck // best effort: unknown entries copy unselected
+		out[rel] = child.Selected
+		return true, nil
+	})
+}
+
+// copyTree copies the Archives subtree at from to to, then registers the
+// copied entries under their (new) parents in one batch. Returns the
+// registered entries by path, those copied before a failure included; a
+// copy that could not be registered is removed.
+func (h *Handlers) copyTree(ctx context.Context, from, to string, parentIno uint64, srcSelected map[string]bool) (map[string]Entry, error) {
+	copied := make(map[string]Entry)
+	var batch []Entry
+	var paths []string
+	inos := map[string]uint64{path.Dir(to): parentIno}
+	srcRoot := filepath.Join(h.archivesRoot, from)
+
+	err := filepath.Walk(srcRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcRoot, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		dstRel := path.Join(to, rel)
+		dst := filepath.Join(h.archivesRoot, dstRel)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("mkdir %s: %w", dstRel, err)
+			}
+		case info.Mode().IsRegular():
+			if strings.HasSuffix(info.Name(), ".sync-tmp") {
+				return nil
+			}
+			if err := SafeCopy(ctx, p, dst, nil); err != nil {
+				return fmt.Errorf("copy %s: %w", dstRel, err)
+			}
+		default:
+			return nil // skip symlinks, devices, sockets
+		}
+
+		mtime, _, inode, size := statFile(dst)
+		if inode == nil {
+			return fmt.Errorf("stat %s: inode unavailable", dstRel)
+		}
+		entry := Entry{
+			Inode:     *inode,
+			ParentIno: inos[path.Dir(dstRel)],
+			Name:      path.Base(dstRel),
+			Type:      ClassifyType(info.Name(), info.IsDir()),
+			Mtime:     *mtime,
+			Selected:  srcSelected[rel],
+		}
+		if info.IsDir() {
+			inos[dstRel] = *inode
+		} else {
+			entry.Type = ClassifyFile(dst, info.Name())
+			entry.Size = size
+		}
+		batch = append(batch, entry)
+		paths = append(paths, dstRel)
+		return nil
+	})
+	// Register whatever reached the disk, even when the walk failed part way.
+	if regErr := h.store.UpsertEntriesBatch(batch); regErr != nil {
+		if rmErr := os.RemoveAll(filepath.Join(h.archivesRoot, to)); rmErr != nil {
+			sub("handlers").Error("unregistered copy not removed", "path", to, "err", rmErr)
+		}
+		return copied, fmt.Errorf("register copy: %w", regErr)
+	}
+	for i, entry := range batch {
+		copied[paths[i]] = entry
+	}
+	return copied, err
+}
+
+// MkdirRequest is the request body for mkdir.
+type MkdirRequest struct {
+	Path   string `json:"path"`
+	Select bool   `json:"select"`
+}
+
+// HandleMkdir handles POST /api/sync/mkdir
+// Creates a directory in Archives (and in Spaces when selected) and
+// registers it immediately.
+func (h *Handlers) HandleMkdir(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req MkdirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("mkdir: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	relPath, err := h.checkPath(req.Path)
+	if err != nil || relPath == "" || !validFileName(filepath.Base(relPath)) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP mkdir", "path", relPath, "select", req.Select)
+
+	parentIno, err := resolveParentInoFromDB(h.store, relPath)
+	if err != nil {
+		http.Error(w, "parent directory not found", http.StatusNotFound)
+		return
+	}
+	archivePath := filepath.Join(h.archivesRoot, relPath)
+	if err := os.Mkdir(archivePath, 0755); err != nil {
+		if os.IsExist(err) {
+			http.Error(w, fmt.Sprintf("%s already exists", relPath), http.StatusConflict)
+			return
+		}
+		l.Error("mkdir failed", "path", relPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mtime, _, inode, _ := statFile(archivePath)
+	if inode == nil {
+		http.Error(w, "stat new directory failed", http.StatusInternalServerError)
+		return
+	}
+	entry := Entry{
+		Inode:     *inode,
+		ParentIno: parentIno,
+		Name:      filepath.Base(relPath),
+		Type:      "dir",
+		Mtime:     *mtime,
+		Selected:  req.Select,
+	}
+	if err := h.store.UpsertEntry(entry); err != nil {
+		l.Error("mkdir register failed", "path", relPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.Select {
+		spacesPath := filepath.Join(h.spacesRoot, relPath)
+		if err := fsFor(spacesPath).MkdirAll(spacesPath, 0755); err != nil {
+			l.Error("mkdir spaces failed", "path", relPath, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if spacesMtime, _, _, _ := statFile(spacesPath); spacesMtime != nil {
+			if err := h.store.UpsertSpacesView(SpacesView{EntryIno: entry.Inode, SyncedMtime: *spacesMtime, CheckedAt: nowNano()}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	l.Info("mkdir complete", "path", relPath, "inode", entry.Inode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry) //nolint:errcheck
+}
+
+// inodeFromPath parses the trailing /<inode> segment of the request path.
+func inodeFromPath(r *http.Request) (uint64, error) {
+	parts := strings.Split(r.URL.Path, "/")
+	return strconv.ParseUint(parts[len(parts)-1], 10, 64)
+}
+
+// contentEntry loads the file entry addressed by the request and the path
+// its content is served from: the Spaces copy when synced, else Archives.
+// The entry's path is held against the workers until release is called.
+func (h *Handlers) contentEntry(w http.ResponseWriter, r *http.Request) (*Entry, *SpacesView, string, func(), bool) {
+	ino, err := inodeFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid inode", http.StatusBadRequest)
+		return nil, nil, "", nil, false
+	}
+	entry, err := h.store.GetEntry(ino)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, "", nil, false
+	}
+	if entry == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil, nil, "", nil, false
+	}
+	if entry.Type == "dir" {
+		http.Error(w, "entry is a directory", http.StatusBadRequest)
+		return nil, nil, "", nil, false
+	}
+	relPath := h.resolveRelPath(entry)
+	release := h.daemon.acquirePath(relPath)
+	sv, err := h.store.GetSpacesView(ino)
+	if err != nil {
+		release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, "", nil, false
+	}
+	src := filepath.Join(h.archivesRoot, relPath)
+	if sv != nil {
+		spacesPath := filepath.Join(h.spacesRoot, relPath)
+		if _, err := os.Stat(spacesPath); err == nil && !isRemote(spacesPath) { // remote Spaces: serve the Archives copy
+			src = spacesPath
+		}
+	}
+	return entry, sv, src, release, true
+}
+
+// contentETag identifies a file version by its mtime and size.
+func contentETag(mtime, size int64) string {
+	return fmt.Sprintf(`"%d-%d"`, mtime, size)
+}
+
+// HandleGetContent handles GET /api/sync/content/<inode>
+// Serves the content of a small file, preferring the synced Spaces copy.
+// An encrypted or compressed Spaces copy is decoded on the way out.
+func (h *Handlers) HandleGetContent(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	entry, sv, src, release, ok := h.contentEntry(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	if sv != nil && (sv.Cipher != "" || sv.Compression != "") && src != filepath.Join(h.archivesRoot, h.resolveRelPath(entry)) {
+		h.serveDecoded(w, r, entry, src)
+		return
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		l.Warn("content: open failed", "path", src, "err", err)
+		http.Error(w, "file not found on disk", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if limit := currentConfig().ContentMaxBytes; limit > 0 && info.Size() > limit {
+		http.Error(w, "file exceeds content size limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	l.Debug("HTTP get content", "inode", entry.Inode, "src", src, "size", info.Size())
+	w.Header().Set("ETag", contentETag(info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, entry.Name, info.ModTime(), f)
+}
+
+// serveDecoded serves the file an encrypted or compressed Spaces copy at
+// spacesPath was made from, held in memory as content is limited to
+// small files.
+func (h *Handlers) serveDecoded(w http.ResponseWriter, r *http.Request, entry *Entry, spacesPath string) {
+	l := sub("handlers")
+	info, err := os.Stat(spacesPath)
+	if err != nil {
+		http.Error(w, "file not found on disk", http.StatusNotFound)
+		return
+	}
+	f, err := openSpaces(spacesPath)
+	if err != nil {
+		l.Warn("content: open failed", "path", spacesPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	var src io.Reader = f
+	limit := currentConfig().ContentMaxBytes
+	if limit > 0 {
+		src = io.LimitReader(f, limit+1)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		l.Warn("content: decode failed", "path", spacesPath, "err", err)
+		http.Error(w, "decode: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		http.Error(w, "file exceeds content size limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	l.Debug("HTTP get content", "inode", entry.Inode, "src", spacesPath, "size", len(data), "decoded", true)
+	w.Header().Set("ETag", contentETag(info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, entry.Name, info.ModTime(), bytes.NewReader(data))
+}
+
+// HandlePutContent handles PUT /api/sync/content/<inode>
+// Replaces the file content in Archives via SafeWrite and, when synced,
+// copies it to Spaces with SafeCopy, updating the entry and spaces_view
+// so the pipeline sees a converged state. The write needs an If-Match
+// header carrying the ETag from GET and is rejected if the file changed
+// in between, or with 409 while the Spaces copy read and the Archives
+// file differ, as the write would lose the change of one of them. The
+// path is held against the workers throughout.
+func (h *Handlers) HandlePutContent(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		http.Error(w, "If-Match required", http.StatusPreconditionRequired)
+		return
+	}
+	entry, sv, src, release, ok := h.contentEntry(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+	if info, err := os.Stat(src); err != nil || contentETag(info.ModTime().UnixNano(), info.Size()) != match {
+		http.Error(w, "file changed since it was read", http.StatusPreconditionFailed)
+		return
+	}
+	relPath := h.resolveRelPath(entry)
+	archivePath := filepath.Join(h.archivesRoot, relPath)
+	if src != archivePath {
+		aMtime, _, _, _ := statFile(archivePath)
+		sMtime, _, _, _ := statFile(src)
+		if st := ComputeState(entry, sv, aMtime, sMtime); !st.ADisk || st.ADirty || st.SDirty {
+			http.Error(w, "Spaces and Archives copies differ until synced", http.StatusConflict)
+			return
+		}
+	}
+	if limit := currentConfig().ContentMaxBytes; limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	l.Info("HTTP put content", "path", relPath, "inode", entry.Inode)
+
+	if _, err := SafeWrite(r.Context(), r.Body, archivePath, true); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "content exceeds size limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+		l.Error("content write failed", "path", relPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The rename gives the file a new inode; re-keying by path cascades
+	// to spaces_view and metadata.
+	mtime, _, inode, size := statFile(archivePath)
+	if inode == nil {
+		http.Error(w, "stat written file failed", http.StatusInternalServerError)
+		return
+	}
+	updated := *entry
+	updated.Inode, updated.Mtime, updated.Size = *inode, *mtime, size
+	if err := h.store.UpsertEntry(updated); err != nil {
+		l.Error("content register failed", "path", relPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sv != nil {
+		spacesPath := filepath.Join(h.spacesRoot, relPath)
+		derived, err := copyToSpaces(r.Context(), relPath, archivePath, spacesPath, nil)
+		if err != nil {
+			l.Error("content copy A->S failed", "path", relPath, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if spacesMtime, _, _, _ := statFile(spacesPath); spacesMtime != nil {
+			view := SpacesView{EntryIno: updated.Inode, SyncedMtime: *spacesMtime, CheckedAt: nowNano()}
+			view.Cipher, view.Compression = spacesEncoding(spacesPath)
+			err := h.store.WithTx(func(tx *TxStore) error {
+				if err := tx.UpsertSpacesView(view); err != nil {
+					return err
+				}
+				return tx.SetDerivative(updated.Inode, derived)
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	h.daemon.pathCache.Clear()
+	l.Info("content written", "path", relPath, "inode", updated.Inode, "size", sizeOrZero(size))
+
+	w.Header().Set("ETag", contentETag(updated.Mtime, sizeOrZero(size)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated) //nolint:errcheck
+}
+
+// HandleStats handles GET /api/sync/stats
+func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	l.Debug("HTTP stats")
+
+	archivesSize, err := h.store.AggregateTotalSize()
+	if err != nil {
+		l.Error("stats failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	spacesSize, err := h.store.AggregateSelectedSize()
+	if err != nil {
+		l.Error("stats failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bytesPending, err := h.store.PendingSyncSize()
+	if err != nil {
+		l.Error("stats failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	queueLen := h.daemon.Queue().Len()
+	throughput := copyBytesMeter.Rate()
+	itemsPerSec := itemsMeter.Rate()
+
+	disks, err := h.diskStats()
+	if err != nil {
+		l.Error("stats failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SyncStatsResponse{ //nolint:errcheck
+		DiskTotal:    disks.Archives.Total,
+		DiskFree:     disks.Archives.Free,
+		Disks:        disks,
+		ArchivesSize: archivesSize,
+		SpacesSize:   spacesSize,
+		QueueLen:     queueLen,
+		BytesPending: bytesPending,
+		Throughput:   throughput,
+		CopyChunks:   chunks.stats(),
+		RootClocks:   rootClocks(),
+		ItemsPerSec:  itemsPerSec,
+		EtaSeconds:   estimateETA(bytesPending, queueLen, throughput, itemsPerSec),
+		QueueAgeSecs: int64(h.daemon.Queue().OldestAge().Seconds()),
+		Latency:      queueLatency(),
+		Idle:         power.idle(),
+		Deferred:     power.deferWork(),
+		DB:           h.store.DBStats(),
+		Verify:       verifications.stats(),
+		Watcher:      watchProbes.health(),
+		Scenarios:    pipelineStats.counters(),
+	})
+}
+
+// HandleDirSize handles GET /api/sync/dirsize/<inode>
+// Returns the recursive total and synced bytes of a directory (0 = root),
+// served from the Store's incrementally invalidated cache.
+func (h *Handlers) HandleDirSize(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	ino, err := inodeFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid inode", http.StatusBadRequest)
+		return
+	}
+	if ino != 0 {
+		entry, err := h.store.GetEntry(ino)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if entry.Type != "dir" {
+			http.Error(w, "entry is not a directory", http.StatusBadRequest)
+			return
+		}
+	}
+
+	size, err := h.store.DirSize(ino)
+	if err != nil {
+		l.Error("dir size failed", "inode", ino, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Debug("HTTP dir size", "inode", ino, "totalBytes", size.TotalBytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(size) //nolint:errcheck
+}
+
+// HandleStatsBreakdown handles GET /api/sync/stats/breakdown?refresh=1
+// Returns total and synced bytes by type and by top-level directory.
+// Results are cached for breakdownTTL unless refresh is set.
+func (h *Handlers) HandleStatsBreakdown(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	resp, err := h.breakdown.get(h.store, r.URL.Query().Get("refresh") == "1")
+	if err != nil {
+		l.Error("stats breakdown failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
+}
+
+// HandleReport handles GET /api/sync/report?olderThan=<seconds>
+// It lists paths whose last pipeline run ended in a non-terminal scenario
+// and have stayed that way longer than the threshold.
+func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	threshold := defaultReportThreshold
+	if s := r.URL.Query().Get("olderThan"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 {
+			http.Error(w, "invalid olderThan", http.StatusBadRequest)
+			return
+		}
+		threshold = time.Duration(sec) * time.Second
+	}
+
+	stuck := pipelineStats.stuck(threshold)
+	l.Debug("HTTP report", "thresholdSec", int(threshold.Seconds()), "stuck", len(stuck))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"thresholdSec": int(threshold.Seconds()),
+		"converged":    len(stuck) == 0,
+		"scenarios":    pipelineStats.counters(),
+		"items":        stuck,
+	})
+}
+
+// HandleSeedStatus handles GET /api/sync/seed-status
+func (h *Handlers) HandleSeedStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.daemon.SeedStatus()) //nolint:errcheck
+}
+
+// HandleBlockStats handles GET /api/sync/blocks
+// Returns the block store figures of the last collection; 404 when
+// Spaces isn't a block store.
+func (h *Handlers) HandleBlockStats(w http.ResponseWriter, r *http.Request) {
+	if h.daemon.blocks == nil {
+		http.Error(w, "spaces block store not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.daemon.blocks.Stats()) //nolint:errcheck
+}
+
+// HandleReconcile handles POST /api/sync/reconcile[?path=<relPath>]
+// Re-queues every known entry, bypassing the directory fingerprints that
+// startup uses to skip unchanged subtrees. With a path, only that subtree
+// is queued, ahead of other work, and 202 returns a job whose progress
+// GET /api/sync/reconcile/{id} reports.
+func (h *Handlers) HandleReconcile(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	if p := r.URL.Query().Get("path"); p != "" {
+		relPath, err := h.checkPath(p)
+		if err != nil {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		if relPath == "" {
+			http.Error(w, "path must name a subtree; omit it to reconcile everything", http.StatusBadRequest)
+			return
+		}
+		job, ok, err := h.daemon.reconcileSubtree(relPath)
+		if err != nil {
+			l.Error("subtree reconcile failed", "path", relPath, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "path not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job) //nolint:errcheck
+		return
+	}
+	h.daemon.fullReconcile()
+	queued := h.daemon.Queue().Len()
+	l.Info("full reconcile requested", "queued", queued)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"queued": queued}) //nolint:errcheck
+}
+
+// HandleGetConfig handles GET /api/sync/config
+// Keys, tokens and their files are returned as redactedSecret.
+func (h *Handlers) HandleGetConfig(w http.ResponseWriter, r *http.Request) {
+	sub("handlers").Debug("HTTP get config")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentConfig().redacted()) //nolint:errcheck
+}
+
+// HandlePatchConfig handles PATCH /api/sync/config
+// The body is a partial Config; only runtime-tunable fields may change.
+func (h *Handlers) HandlePatchConfig(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP patch config", "bytes", len(body))
+
+	prevRules := currentConfig().Rules
+	cfg, err := patchConfig(body)
+	if err != nil {
+		l.Warn("patch config rejected", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !reflect.DeepEqual(prevRules, cfg.Rules) {
+		changed, err := reapplyRules(h.store)
+		if err != nil {
+			l.Error("reapply rules failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for relPath, entry := range changed {
+			h.daemon.Queue().PushTraced(r.Context(), relPath, sizeOrZero(entry.Size), false)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg.redacted()) //nolint:errcheck
+}
+
+// HandleTypes handles GET /api/sync/types
+// Returns the known categories and the effective extension table.
+// Overrides are changed via PATCH /api/sync/config {"types": {".ext": "category"}}.
+func (h *Handlers) HandleTypes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"categories": TypeCategories,
+		"extensions": TypeTable(),
+	})
+}
+
+// HandleGetLogLevel handles GET /api/sync/loglevel
+func (h *Handlers) HandleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetLogLevels()) //nolint:errcheck
+}
+
+// HandleSetLogLevel handles PUT /api/sync/loglevel
+// Body: {"global":"debug","components":{"watcher":"debug","pipeline":""}}
+func (h *Handlers) HandleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	var req LogLevels
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("loglevel: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := SetLogLevels(req); err != nil {
+		l.Warn("loglevel: invalid level", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetLogLevels()) //nolint:errcheck
+}
+
+// HandleErrors handles GET /api/sync/errors?since=<RFC3339>&comp=<comp>&level=<warn|error>&limit=<n>
+func (h *Handlers) HandleErrors(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	q := r.URL.Query()
+	var f ErrorFilter
+
+	if s := q.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		f.Since = t
+	}
+	f.Comp = q.Get("comp")
+	if s := q.Get("level"); s != "" {
+		if err := f.MinLevel.UnmarshalText([]byte(s)); err != nil {
+			http.Error(w, "invalid level", http.StatusBadRequest)
+			return
+		}
+	} else {
+		f.MinLevel = slog.LevelWarn
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+
+	items := RecentErrors(f)
+	l.Debug("HTTP errors", "comp", f.Comp, "count", len(items))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"items": items,
+	})
+}
+
+// HandleAudit handles GET /api/sync/audit?since=<RFC3339>&action=&limit=
+func (h *Handlers) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	q := r.URL.Query()
+
+	var since int64
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = t.UnixNano()
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	records, err := h.store.ListAudit(since, q.Get("action"), limit)
+	if err != nil {
+		l.Error("list audit failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []AuditRecord{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": records}) //nolint:errcheck
+}
+
+// HandleConflicts handles GET /api/sync/conflicts?all=1
+// Lists unresolved conflicts, or all of them with all=1.
+func (h *Handlers) HandleConflicts(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	conflicts, err := h.store.ListConflicts(r.URL.Query().Get("all") != "1")
+	if err != nil {
+		sub("handlers").Error("list conflicts failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": conflicts}) //nolint:errcheck
+}
+
+// ResolveConflictRequest is the request body for conflicts/resolve.
+type ResolveConflictRequest struct {
+	ID         int64  `json:"id"`
+	Resolution string `json:"resolution"`
+}
+
+// HandleResolveConflict handles POST /api/sync/conflicts/resolve
+// Records how the user settled a conflict; files are left as they are.
+func (h *Handlers) HandleResolveConflict(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req ResolveConflictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Resolution = strings.TrimSpace(req.Resolution)
+	if req.Resolution == "" {
+		http.Error(w, "resolution is required", http.StatusBadRequest)
+		return
+	}
+
+	l.Info("HTTP resolve conflict", "id", req.ID, "resolution", req.Resolution)
+
+	found, err := h.store.ResolveConflict(req.ID, req.Resolution)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck
+}
+
+// eventReplayPage is how many logged events HandleSSE reads at a time
+// when replaying.
+const eventReplayPage = 500
+
+// HandleSSE handles GET /api/sync/events as a Server-Sent Events stream.
+// A client reconnecting with Last-Event-ID (or ?lastEventId=N) first gets
+// the logged events it missed, or a reset event if some of them are no
+// longer logged.
+func (h *Handlers) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	var after int64
+	if lastID != "" {
+		n, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+
+	// Subscribe before reading the log so nothing falls in between.
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+	l.Debug("SSE client connected", "remote", r.RemoteAddr, "lastEventId", after)
+
+	send := func(ev Event) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			l.Warn("SSE marshal failed", "err", err)
+			return
+		}
+		if ev.ID != 0 {
+			fmt.Fprintf(w, "id: %d\n", ev.ID)
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	}
+	if lastID != "" {
+		for {
+			evs, oldest, err := h.store.ListEventsAfter(after, eventReplayPage)
+			if err != nil {
+				l.Warn("SSE replay failed", "err", err)
+				break
+			}
+			if oldest > after+1 {
+				send(Event{Type: EventReset, Time: nowNano()})
+			}
+			for _, ev := range evs {
+				send(ev)
+				after = ev.ID
+			}
+			if len(evs) < eventReplayPage {
+				break
+			}
+		}
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			l.Debug("SSE client disconnected", "remote", r.RemoteAddr)
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if ev.ID != 0 && ev.ID <= after {
+				continue // already replayed
+			}
+			send(ev)
+			flusher.Flush()
+		}
+	}
+}
+
+// pushInodesToQueue resolves inodes to relative paths and pushes them
+// to the eval queue for the daemon worker to process, tracked as a
+// selection job of action whose ID it returns; opID is the undo log
+// operation of the selection. A subtree of more than bulkEventThreshold
+// paths is tracked as a bulk job too, so SSE clients get its progress
+// rather than an event per file.
+func (h *Handlers) pushInodesToQueue(ctx context.Context, inodes []uint64, action string, opID int64) (string, error) {
+	l := sub("handlers")
+	var roots []string
+	var groups [][]queuedPath
+	for _, ino := range inodes {
+		entry, err := h.store.GetEntry(ino)
+		if err != nil || entry == nil {
+			continue
+		}
+		relPath := h.resolveRelPath(entry)
+		if relPath == "" {
+			continue
+		}
+
+		jobs := []queuedPath{{relPath, ino, sizeOrZero(entry.Size), entry.Type == "dir"}}
+		if entry.Type == "dir" {
+			jobs = h.appendChildJobs(jobs, ino, relPath)
+		}
+		roots = append(roots, relPath)
+		groups = append(groups, jobs)
+		if action == "deselect" {
+			pipelineStats.forget(relPath)
+		}
+	}
+
+	all := make([]queuedPath, 0, len(groups))
+	for _, jobs := range groups {
+		all = append(all, jobs...)
+	}
+	id, err := h.daemon.jobs.start(action, roots, all, opID)
+	if err != nil {
+		return "", err
+	}
+	for i, jobs := range groups {
+		if len(jobs) > bulkEventThreshold {
+			paths := make([]string, len(jobs))
+			for k, j := range jobs {
+				paths[k] = j.path
+			}
+			events.trackBulk(id, roots[i], action, paths)
+		}
+		for _, j := range jobs {
+			h.daemon.Queue().PushTraced(ctx, j.path, j.size, j.isDir)
+		}
+		l.Debug("queued for eval", "path", roots[i], "job", id, "paths", len(jobs))
+	}
+	return id, nil
+}
+
+func (h *Handlers) resolveRelPath(entry *Entry) string {
+	return h.store.RelPath(entry)
+}
+
+// pushSubtreeToQueue queues every descendant, including excluded ones.
+func (h *Handlers) pushSubtreeToQueue(ctx context.Context, parentIno uint64, parentPath string) {
+	err := h.store.walkTree(parentIno, parentPath, func(child *Entry, childPath string) (bool, error) {
+		h.daemon.Queue().PushTraced(ctx, childPath, sizeOrZero(child.Size), child.Type == "dir")
+		return true, nil
+	})
+	if err != nil {
+		sub("handlers").Warn("queue subtree failed", "path", parentPath, "err", err)
+	}
+}
+
+// queuedPath is a path to push with its entry and size information.
+type queuedPath struct {
+	path  string
+	ino   uint64
+	size  int64
+	isDir bool
+}
+
+// appendChildJobs appends the descendants to queue for a select/deselect,
+// skipping excluded subtrees since their state doesn't change.
+func (h *Handlers) appendChildJobs(jobs []queuedPath, parentIno uint64, parentPath string) []queuedPath {
+	err := h.store.walkTree(parentIno, parentPath, func(child *Entry, childPath string) (bool, error) {
+		if child.Excluded {
+			return false, nil
+		}
+		jobs = append(jobs, queuedPath{childPath, child.Inode, sizeOrZero(child.Size), child.Type == "dir"})
+		return true, nil
+	})
+	if err != nil {
+		sub("handlers").Warn("queue children failed", "path", parentPath, "err", err)
+	}
+	return jobs
+}
@@ -2,7 +2,9 @@ package sync
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -186,8 +188,168 @@ func TestHandleGetEntry(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var entry Entry
+	var entry SyncEntryResponse
 	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
 	assert.Equal(t, uint64(42), entry.Inode)
 	assert.Equal(t, "doc.txt", entry.Name)
+	assert.Equal(t, "doc.txt", entry.Path)
+	assert.Equal(t, "lost", entry.Status, "missing from both roots")
+	assert.Nil(t, entry.ArchiveMtime)
+	assert.False(t, entry.InSpacesView)
+}
+
+func TestHandleGetEntry_LiveDiskState(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "Docs"), 0755))
+	archivePath := filepath.Join(archivesRoot, "Docs", "a.txt")
+	require.NoError(t, os.WriteFile(archivePath, []byte("aaa"), 0644))
+	archiveMtime, _, _, _ := statFile(archivePath)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "Docs", Type: "dir", Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "a.txt", Type: "text", Size: ptr(int64(3)), Mtime: *archiveMtime, Selected: true}))
+
+	get := func(ino uint64) SyncEntryResponse {
+		w := httptest.NewRecorder()
+		h.HandleGetEntry(w, httptest.NewRequest("GET", fmt.Sprintf("/api/sync/entry/%d", ino), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var entry SyncEntryResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+		return entry
+	}
+
+	entry := get(2)
+	assert.Equal(t, "Docs/a.txt", entry.Path)
+	assert.Equal(t, "syncing", entry.Status, "selected, not yet in Spaces")
+	require.NotNil(t, entry.ArchiveMtime)
+	assert.Equal(t, *archiveMtime, *entry.ArchiveMtime)
+	assert.Nil(t, entry.SpacesMtime)
+
+	// The Spaces copy shows up without any DB change.
+	spacesPath := filepath.Join(spacesRoot, "Docs", "a.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(spacesPath), 0755))
+	require.NoError(t, os.WriteFile(spacesPath, []byte("aaa"), 0644))
+	entry = get(2)
+	assert.NotNil(t, entry.SpacesMtime)
+
+	dir := get(1)
+	require.NotNil(t, dir.ChildTotalCount)
+	assert.Equal(t, 1, *dir.ChildTotalCount)
+	assert.Equal(t, 1, *dir.ChildSelectedCount)
+}
+
+func TestHandleListEntries_ClientGone(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "the listing query is cancelled")
+
+	// Writes finish even if the client is gone.
+	w = httptest.NewRecorder()
+	h.HandlePatchEntry(w, httptest.NewRequest("PATCH", "/api/sync/entry/1", bytes.NewBufferString(`{"starred":true}`)).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandlePatchEntry_Starred(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "Docs", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "todo.md", Type: "text", Mtime: 1}))
+
+	w := httptest.NewRecorder()
+	h.HandlePatchEntry(w, httptest.NewRequest("PATCH", "/api/sync/entry/2", bytes.NewBufferString(`{"starred":true}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var entry Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.True(t, entry.Starred)
+	assert.False(t, entry.Selected)
+
+	w = httptest.NewRecorder()
+	h.HandleStarred(w, httptest.NewRequest("GET", "/api/sync/starred", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []StarredEntryResponse `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "Docs/todo.md", resp.Items[0].Path)
+	assert.Equal(t, uint64(2), resp.Items[0].Inode)
+
+	w = httptest.NewRecorder()
+	h.HandlePatchEntry(w, httptest.NewRequest("PATCH", "/api/sync/entry/99", bytes.NewBufferString(`{"starred":true}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleListEntries_TagFilter(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "b.txt", Type: "text", Mtime: 1}))
+
+	w := httptest.NewRecorder()
+	h.HandleTag(w, httptest.NewRequest("POST", "/api/sync/tag", bytes.NewBufferString(`{"inodes":[1],"tags":[" invoices "]}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleTag(w, httptest.NewRequest("POST", "/api/sync/tag", bytes.NewBufferString(`{"inodes":[2],"tags":[""]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?tag=Invoices", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []SyncEntryResponse `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "a.txt", resp.Items[0].Name)
+	assert.Equal(t, []string{"invoices"}, resp.Items[0].Tags)
+
+	w = httptest.NewRecorder()
+	h.HandleListTags(w, httptest.NewRequest("GET", "/api/sync/tags", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"items":[{"name":"invoices","count":1}]}`, w.Body.String())
+}
+
+func TestHandleConflicts_Resolve(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	id, err := store.RegisterConflict(Conflict{Path: "a.txt", ConflictIno: 1, ConflictName: "a_conflict-1.txt"}, nil, nil)
+	require.NoError(t, err)
+
+	list := func(query string) []Conflict {
+		w := httptest.NewRecorder()
+		h.HandleConflicts(w, httptest.NewRequest("GET", "/api/sync/conflicts"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Items []Conflict `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Items
+	}
+	require.Len(t, list(""), 1)
+
+	w := httptest.NewRecorder()
+	h.HandleResolveConflict(w, httptest.NewRequest("POST", "/api/sync/conflicts/resolve", bytes.NewBufferString(fmt.Sprintf(`{"id":%d,"resolution":"kept-both"}`, id))))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, list(""))
+	all := list("?all=1")
+	require.Len(t, all, 1)
+	assert.Equal(t, "kept-both", all[0].Resolution)
+
+	w = httptest.NewRecorder()
+	h.HandleResolveConflict(w, httptest.NewRequest("POST", "/api/sync/conflicts/resolve", bytes.NewBufferString(`{"id":999,"resolution":"x"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleReconcile_QueuesAllEntries(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	seedSelectionTree(t, store)
+
+	w := httptest.NewRecorder()
+	h.HandleReconcile(w, httptest.NewRequest("POST", "/api/sync/reconcile", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]int
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 4, resp["queued"])
 }
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// Hold TTL bounds: a hold without a TTL lasts defaultHoldTTL, and none
+// lasts longer than maxHoldTTL so a forgotten hold can't stop syncing.
+const (
+	defaultHoldTTL = 10 * time.Minute
+	maxHoldTTL     = 24 * time.Hour
+)
+
+// holdSet is the set of entries held from syncing, e.g. while a file is
+// being edited in Spaces. The pipeline skips P2 and P3 for a held entry;
+// a hold covers only the entry itself, not a directory's children. Holds
+// are kept in memory and keyed by inode, so they follow renames and end
+// with the process.
+type holdSet struct {
+	mu    gosync.Mutex
+	until map[uint64]time.Time
+}
+
+// holds is the hold set shared by the daemon, pipeline and handlers.
+var holds = &holdSet{until: make(map[uint64]time.Time)}
+
+// set holds ino until the given time, replacing any earlier hold.
+func (s *holdSet) set(ino uint64, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.until[ino] = until
+}
+
+// release drops the hold on ino and reports whether there was one.
+func (s *holdSet) release(ino uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.until[ino]
+	delete(s.until, ino)
+	return ok && nowFunc().Before(until)
+}
+
+// heldUntil returns when the hold on ino expires, or the zero time if it
+// isn't held. Expired holds are dropped.
+func (s *holdSet) heldUntil(ino uint64) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.until[ino]
+	if !ok {
+		return time.Time{}
+	}
+	if !nowFunc().Before(until) {
+		delete(s.until, ino)
+		return time.Time{}
+	}
+	return until
+}
+
+func (s *holdSet) held(ino uint64) bool {
+	return !s.heldUntil(ino).IsZero()
+}
+
+// list returns the active holds, dropping expired ones.
+func (s *holdSet) list() map[uint64]time.Time {
+	now := nowFunc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[uint64]time.Time, len(s.until))
+	for ino, until := range s.until {
+		if !now.Before(until) {
+			delete(s.until, ino)
+			continue
+		}
+		out[ino] = until
+	}
+	return out
+}
+
+// holdTTL clamps a requested TTL in seconds; 0 means the default.
+func holdTTL(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultHoldTTL
+	}
+	return min(time.Duration(seconds)*time.Second, maxHoldTTL)
+}
+
+// Hold holds the entry at relPath, inode ino, for ttl. Changes made
+// meanwhile are synced when the hold expires: the path is queued again
+// for then.
+func (d *Daemon) Hold(ino uint64, relPath string, ttl time.Duration) time.Time {
+	until := nowFunc().Add(ttl)
+	holds.set(ino, until)
+	d.queue.PushAfter(relPath, ttl)
+	sub("daemon").Info("entry held", "path", relPath, "inode", ino, "ttl", ttl)
+	return until
+}
+
+// Release ends the hold on the entry at relPath, inode ino, and queues
+// the path so pending changes sync right away.
+func (d *Daemon) Release(ino uint64, relPath string) {
+	if holds.release(ino) {
+		d.queue.Push(relPath)
+		sub("daemon").Info("entry released", "path", relPath, "inode", ino)
+	}
+}
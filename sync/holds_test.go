@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func clearHolds(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		for ino := range holds.list() {
+			holds.release(ino)
+		}
+	})
+}
+
+func TestPipeline_HeldEntrySkipsSync(t *testing.T) {
+	clearHolds(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/b.txt"}, map[string]bool{"Docs/a.txt": true})
+	a := registered(t, store, archivesRoot, "Docs/a.txt")
+	b := registered(t, store, archivesRoot, "Docs/b.txt")
+	d := h.daemon
+
+	d.Hold(a.Inode, "Docs/a.txt", time.Minute)
+	d.Hold(b.Inode, "Docs/b.txt", time.Minute)
+	require.NoError(t, store.SetSelected([]uint64{b.Inode}, true))
+
+	sA := filepath.Join(spacesRoot, "Docs/a.txt")
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.WriteFile(sA, []byte("mid-edit"), 0644))
+	require.NoError(t, os.Chtimes(sA, past, past))
+
+	for _, p := range []string{"Docs/a.txt", "Docs/b.txt"} {
+		require.NoError(t, RunPipeline(context.Background(), p, store, archivesRoot, spacesRoot, d.trashRoot, nil))
+	}
+	got, _ := os.ReadFile(filepath.Join(archivesRoot, "Docs/a.txt"))
+	assert.Equal(t, "Docs/a.txt", string(got), "edit not propagated while held")
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "Docs/b.txt"), "selection not realized while held")
+
+	d.queue.Drain()
+	d.Release(a.Inode, "Docs/a.txt")
+	d.Release(b.Inode, "Docs/b.txt")
+	assert.True(t, d.queue.Has("Docs/a.txt"))
+	assert.True(t, d.queue.Has("Docs/b.txt"))
+	for _, p := range []string{"Docs/a.txt", "Docs/b.txt"} {
+		require.NoError(t, RunPipeline(context.Background(), p, store, archivesRoot, spacesRoot, d.trashRoot, nil))
+	}
+	got, _ = os.ReadFile(filepath.Join(archivesRoot, "Docs/a.txt"))
+	assert.Equal(t, "mid-edit", string(got))
+	assert.FileExists(t, filepath.Join(spacesRoot, "Docs/b.txt"))
+}
+
+func TestHolds_Expire(t *testing.T) {
+	clearHolds(t)
+	holds.set(42, nowFunc().Add(time.Minute))
+	assert.True(t, holds.held(42))
+
+	useFakeClock(t, time.Now().Add(2*time.Minute))
+	assert.False(t, holds.held(42))
+	assert.Empty(t, holds.list())
+
+	assert.Equal(t, defaultHoldTTL, holdTTL(0))
+	assert.Equal(t, 30*time.Second, holdTTL(30))
+	assert.Equal(t, maxHoldTTL, holdTTL(7*24*3600))
+}
+
+func TestHandleHold(t *testing.T) {
+	clearHolds(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	a := registered(t, store, archivesRoot, "Docs/a.txt")
+
+	w := postJSON(h.HandleHold, "/api/sync/hold", fmt.Sprintf(`{"inodes":[%d],"ttlSeconds":60}`, a.Inode))
+	require.Equal(t, 200, w.Code)
+
+	var resp struct {
+		Items []HoldResponse `json:"items"`
+	}
+	w = httptest.NewRecorder()
+	h.HandleListHolds(w, httptest.NewRequest("GET", "/api/sync/holds", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "Docs/a.txt", resp.Items[0].Path)
+	assert.Greater(t, resp.Items[0].Until, time.Now().UnixNano())
+
+	w = httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?path=/Docs", nil))
+	var list struct {
+		Items []SyncEntryResponse `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.NotZero(t, list.Items[0].HeldUntil)
+
+	require.Equal(t, 200, postJSON(h.HandleUnhold, "/api/sync/unhold", fmt.Sprintf(`{"inodes":[%d]}`, a.Inode)).Code)
+	assert.False(t, holds.held(a.Inode))
+
+	assert.Equal(t, 404, postJSON(h.HandleHold, "/api/sync/hold", `{"inodes":[999999]}`).Code)
+}
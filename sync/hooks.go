@@ -0,0 +1,183 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HookWhen says whether a hook fires before or after its action.
+type HookWhen string
+
+const (
+	HookBefore HookWhen = "before"
+	HookAfter  HookWhen = "after"
+)
+
+// Pipeline actions hooks fire for.
+const (
+	HookCopy     = "copy"     // a copy between the roots
+	HookConflict = "conflict" // the Spaces side of a conflict copied over the renamed Archives file
+	HookTrash    = "trash"    // a Spaces file moved to the trash after deselection
+)
+
+// hookTimeout is how long a hook may run when it sets no timeout.
+const hookTimeout = 30 * time.Second
+
+// Hook runs a command or calls a URL around the copies and trash moves of
+// pipeline stages P2 and P3. Hooks run in the background: they see the
+// action but can't hold it up or fail it, and their failures are logged.
+type Hook struct {
+	Stages         []string `json:"stages" yaml:"stages" toml:"stages"`                         // P2, P3; empty = both
+	When           HookWhen `json:"when" yaml:"when" toml:"when"`                               // before|after, empty = after
+	Command        string   `json:"command" yaml:"command" toml:"command"`                      // run with the event as JSON on stdin and in FB_SYNC_HOOK_* variables
+	URL            string   `json:"url" yaml:"url" toml:"url"`                                  // POSTed the event as JSON instead
+	TimeoutSeconds int      `json:"timeoutSeconds" yaml:"timeoutSeconds" toml:"timeoutSeconds"` // 0 = 30
+}
+
+func (h Hook) validate() error {
+	if (h.Command == "") == (h.URL == "") {
+		return fmt.Errorf("set exactly one of command and url")
+	}
+	if h.URL != "" && !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return fmt.Errorf("url must be http:// or https://, got %q", h.URL)
+	}
+	for _, s := range h.Stages {
+		if s != "P2" && s != "P3" {
+			return fmt.Errorf("stages must be P2 or P3, got %q", s)
+		}
+	}
+	if h.When != "" && h.When != HookBefore && h.When != HookAfter {
+		return fmt.Errorf("when must be before or after, got %q", h.When)
+	}
+	if h.TimeoutSeconds < 0 || h.TimeoutSeconds > 3600 {
+		return fmt.Errorf("timeoutSeconds must be between 0 and 3600, got %d", h.TimeoutSeconds)
+	}
+	return nil
+}
+
+// fires reports whether h fires when for an action of stage.
+func (h Hook) fires(stage string, when HookWhen) bool {
+	hw := h.When
+	if hw == "" {
+		hw = HookAfter
+	}
+	return hw == when && (len(h.Stages) == 0 || slices.Contains(h.Stages, stage))
+}
+
+// HookEvent is what a hook is told about the action it fires for.
+type HookEvent struct {
+	Stage     string      `json:"stage"`
+	When      HookWhen    `json:"when"`
+	Action    string      `json:"action"`
+	Direction IODirection `json:"direction,omitempty"` // for copies
+	Path      string      `json:"path"`
+	Src       string      `json:"src"`
+	Dst       string      `json:"dst,omitempty"` // for a trash move, known only after it
+	Error     string      `json:"error,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// hooked runs fn, an action of ev.Stage, between the hooks configured for
+// before and after it.
+func hooked(ev HookEvent, fn func() error) error {
+	fireHooks(HookBefore, ev, nil)
+	err := fn()
+	fireHooks(HookAfter, ev, err)
+	return err
+}
+
+// fireHooks starts the hooks that fire when for ev, with err the
+// action's outcome. A completed action also wakes the content indexer.
+func fireHooks(when HookWhen, ev HookEvent, err error) {
+	if when == HookAfter && err == nil {
+		wakeContentIndex()
+	}
+	hooks := activeConfig.Load().Hooks
+	if len(hooks) == 0 {
+		return
+	}
+	ev.When, ev.Time = when, nowFunc()
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	for _, h := range hooks {
+		if h.fires(ev.Stage, when) {
+			go runHook(h, ev)
+		}
+	}
+}
+
+func runHook(h Hook, ev HookEvent) {
+	timeout := hookTimeout
+	if h.TimeoutSeconds > 0 {
+		timeout = time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	body, _ := json.Marshal(ev)
+
+	start := time.Now()
+	var err error
+	target := h.Command
+	if h.URL != "" {
+		target = h.URL
+		err = postHook(ctx, h.URL, body)
+	} else {
+		err = execHook(ctx, h.Command, ev, body)
+	}
+	l := sub("hooks")
+	if err != nil {
+		l.Warn("hook failed", "hook", target, "stage", ev.Stage, "when", ev.When, "action", ev.Action, "path", ev.Path, "err", err)
+		return
+	}
+	l.Debug("hook done", "hook", target, "stage", ev.Stage, "when", ev.When, "action", ev.Action, "path", ev.Path, "durationMs", time.Since(start).Milliseconds())
+}
+
+func execHook(ctx context.Context, command string, ev HookEvent, body []byte) error {
+	argv := strings.Fields(command)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		envPrefix+"HOOK_STAGE="+ev.Stage,
+		envPrefix+"HOOK_WHEN="+string(ev.When),
+		envPrefix+"HOOK_ACTION="+ev.Action,
+		envPrefix+"HOOK_DIRECTION="+string(ev.Direction),
+		envPrefix+"HOOK_PATH="+ev.Path,
+		envPrefix+"HOOK_SRC="+ev.Src,
+		envPrefix+"HOOK_DST="+ev.Dst,
+		envPrefix+"HOOK_ERROR="+ev.Error,
+	)
+	var out limitedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func postHook(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //nolint:errcheck // drained for reuse only
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
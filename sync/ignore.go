@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// defaultIgnorePatterns are editor and office temp files, skipped by the
+// watcher and scanner so saves don't churn the queue or get copied to
+// Archives before the editor deletes them.
+var defaultIgnorePatterns = []string{
+	"*~",        // emacs, gedit backups
+	"*.swp",     // vim swap files
+	"*.swo",     // vim swap files
+	"*.swx",     // vim swap files
+	"4913",      // vim write test
+	"*.tmp",     // generic temp files
+	"~$*",       // MS Office lock files
+	".~lock.*#", // LibreOffice lock files
+}
+
+// validateIgnorePatterns checks Config.IgnorePatterns.
+func validateIgnorePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if p == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// ignoredName reports whether name matches a built-in temp file pattern
+// or one of Config.IgnorePatterns.
+func ignoredName(name string) bool {
+	for _, p := range defaultIgnorePatterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	for _, p := range activeConfig.Load().IgnorePatterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
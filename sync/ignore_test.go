@@ -0,0 +1,42 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipScanName_TempFiles(t *testing.T) {
+	restoreConfig(t)
+	for _, name := range []string{"notes.txt~", "4913", "draft.tmp", "~$report.docx", ".~lock.sheet.ods#", ".notes.txt.swp", "x.sync-conflict-1.txt"} {
+		assert.True(t, skipScanName(name), name)
+	}
+	for _, name := range []string{"notes.txt", "tmp", "report.docx", "a~b.txt"} {
+		assert.False(t, skipScanName(name), name)
+	}
+
+	cfg := currentConfig()
+	cfg.IgnorePatterns = []string{"*.part", "Thumbs.db"}
+	require.NoError(t, setConfig(cfg))
+	assert.True(t, skipScanName("movie.mkv.part"))
+	assert.True(t, skipScanName("Thumbs.db"))
+
+	cfg.IgnorePatterns = []string{"[bad"}
+	assert.Error(t, setConfig(cfg))
+}
+
+func TestScanDir_SkipsTempFiles(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "build.tmp", "inner"), 0755))
+	for _, name := range []string{"a.txt", "a.txt~", "~$a.docx", "build.tmp/inner/x.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte("x"), 0644))
+	}
+
+	files, err := ScanDir(root)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Contains(t, files, "a.txt")
+}
@@ -0,0 +1,233 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	gosync "sync"
+	"time"
+)
+
+// Two daemons on the same database would copy and trash the same files
+// twice over. A daemon takes the single instance_lock row before doing
+// anything and keeps it with a heartbeat; a second one waits, serving the
+// API read-only, until the row is released or its heartbeat goes stale.
+
+var (
+	// instanceHeartbeatInterval is how often the lock holder renews it.
+	instanceHeartbeatInterval = 10 * time.Second
+	// instanceStaleAfter is how long a lock without heartbeat is honoured,
+	// covering a holder that crashed without releasing it.
+	instanceStaleAfter = time.Minute
+)
+
+// Instance is the daemon holding, or waiting on, the instance lock.
+type Instance struct {
+	ID          string `json:"id"`
+	Host        string `json:"host"`
+	PID         int    `json:"pid"`
+	StartedAt   int64  `json:"startedAt"`   // nanoseconds
+	HeartbeatAt int64  `json:"heartbeatAt"` // nanoseconds
+}
+
+// InstanceResponse is returned by GET /api/sync/instance.
+type InstanceResponse struct {
+	Self     Instance  `json:"self"`
+	Holder   *Instance `json:"holder"`   // nil while nobody holds the lock
+	ReadOnly bool      `json:"readOnly"` // another instance holds it
+}
+
+// instanceLock is a daemon's side of the lock. blocked is set while
+// another instance holds it.
+type instanceLock struct {
+	self Instance
+
+	mu      gosync.Mutex
+	blocked *Instance
+}
+
+func newInstanceLock() *instanceLock {
+	b := make([]byte, 8)
+	rand.Read(b) //nolint:errcheck // never fails on supported platforms
+	host, _ := os.Hostname()
+	return &instanceLock{self: Instance{ID: hex.EncodeToString(b), Host: host, PID: os.Getpid()}}
+}
+
+// blockedBy returns the instance holding the lock, if not this one.
+func (il *instanceLock) blockedBy() *Instance {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	return il.blocked
+}
+
+func (il *instanceLock) setBlocked(holder *Instance) {
+	il.mu.Lock()
+	il.blocked = holder
+	il.mu.Unlock()
+}
+
+// acquireInstance waits until the daemon holds the instance lock. It
+// returns false if ctx is done first.
+func (d *Daemon) acquireInstance(ctx context.Context) bool {
+	l := sub("instance")
+	il := d.instance
+	il.self.StartedAt = nowNano()
+	for {
+		holder, err := d.store.AcquireInstance(il.self, nowNano()-instanceStaleAfter.Nanoseconds())
+		switch {
+		case err != nil:
+			l.Error("instance lock failed", "err", err)
+		case holder == nil:
+			il.setBlocked(nil)
+			l.Info("instance lock acquired", "id", il.self.ID)
+			return true
+		case il.blockedBy() == nil:
+			il.setBlocked(holder)
+			l.Error("another sync daemon uses this database, serving read-only until it stops",
+				"holder", holder.ID, "host", holder.Host, "pid", holder.PID)
+		default:
+			il.setBlocked(holder)
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-clock.After(instanceHeartbeatInterval):
+		}
+	}
+}
+
+// releaseInstance gives up the instance lock on shutdown.
+func (d *Daemon) releaseInstance() {
+	if err := d.store.ReleaseInstance(d.instance.self.ID); err != nil {
+		sub("instance").Warn("instance lock release failed", "err", err)
+	}
+}
+
+// runInstanceHeartbeat renews the instance lock until ctx is done.
+// Should another instance have taken the lock meanwhile, lost is called:
+// two daemons must not go on side by side.
+func (d *Daemon) runInstanceHeartbeat(ctx context.Context, lost func()) {
+	l := sub("instance")
+	id := d.instance.self.ID
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(instanceHeartbeatInterval):
+			held, err := d.store.RenewInstance(id, nowNano())
+			if err != nil {
+				l.Warn("instance heartbeat failed", "err", err)
+				continue
+			}
+			if !held {
+				l.Error("instance lock taken over by another daemon, stopping", "id", id)
+				if holder, err := d.store.InstanceHolder(); err == nil && holder != nil {
+					d.instance.setBlocked(holder)
+				}
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// GuardInstance is middleware refusing changes through the API while
+// another daemon holds the instance lock.
+func (h *Handlers) GuardInstance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if holder := h.daemon.instance.blockedBy(); holder != nil {
+				http.Error(w, fmt.Sprintf("read-only: another sync daemon (%s, pid %d on %s) uses this database",
+					holder.ID, holder.PID, holder.Host), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleInstance handles GET /api/sync/instance
+func (h *Handlers) HandleInstance(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	il := h.daemon.instance
+	holder, err := h.store.InstanceHolder()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InstanceResponse{Self: il.self, Holder: holder, ReadOnly: il.blockedBy() != nil}) //nolint:errcheck
+}
+
+// AcquireInstance takes the instance lock for self unless another
+// instance holds it with a heartbeat after staleBefore, in nanoseconds.
+// It returns that instance, or nil once self holds the lock.
+func (s *Store) AcquireInstance(self Instance, staleBefore int64) (*Instance, error) {
+	var holder *Instance
+	err := s.WithTx(func(t *TxStore) error {
+		cur, err := scanInstance(t.tx.QueryRow(`SELECT owner, host, pid, started_at, heartbeat_at FROM instance_lock WHERE id = 1`))
+		if err != nil {
+			return err
+		}
+		if cur != nil && cur.ID != self.ID && cur.HeartbeatAt > staleBefore {
+			holder = cur
+			return nil
+		}
+		now := nowNano()
+		_, err = t.tx.Exec(`
+			INSERT INTO instance_lock (id, owner, host, pid, started_at, heartbeat_at) VALUES (1, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET owner = excluded.owner, host = excluded.host, pid = excluded.pid,
+				started_at = excluded.started_at, heartbeat_at = excluded.heartbeat_at
+		`, self.ID, self.Host, self.PID, self.StartedAt, now)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("acquire instance: %w", err)
+	}
+	return holder, nil
+}
+
+// RenewInstance records a heartbeat of the lock held by id, reporting
+// false if id no longer holds it.
+func (s *Store) RenewInstance(id string, now int64) (bool, error) {
+	res, err := s.exec("UPDATE instance_lock SET heartbeat_at = ? WHERE id = 1 AND owner = ?", now, id)
+	if err != nil {
+		return false, fmt.Errorf("renew instance: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ReleaseInstance drops the lock if id holds it.
+func (s *Store) ReleaseInstance(id string) error {
+	if _, err := s.exec("DELETE FROM instance_lock WHERE id = 1 AND owner = ?", id); err != nil {
+		return fmt.Errorf("release instance: %w", err)
+	}
+	return nil
+}
+
+// InstanceHolder returns the instance holding the lock, nil if none.
+func (s *Store) InstanceHolder() (*Instance, error) {
+	in, err := scanInstance(s.rdb.QueryRowContext(s.context(), `SELECT owner, host, pid, started_at, heartbeat_at FROM instance_lock WHERE id = 1`))
+	if err != nil {
+		return nil, fmt.Errorf("instance holder: %w", err)
+	}
+	return in, nil
+}
+
+func scanInstance(row *sql.Row) (*Instance, error) {
+	var in Instance
+	err := row.Scan(&in.ID, &in.Host, &in.PID, &in.StartedAt, &in.HeartbeatAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
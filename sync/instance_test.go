@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_InstanceLock(t *testing.T) {
+	store := setupTestDB(t)
+	a := Instance{ID: "a", Host: "h", PID: 1}
+	b := Instance{ID: "b", Host: "h", PID: 2}
+
+	holder, err := store.AcquireInstance(a, 0)
+	require.NoError(t, err)
+	assert.Nil(t, holder)
+	holder, err = store.AcquireInstance(b, 0)
+	require.NoError(t, err)
+	require.NotNil(t, holder)
+	assert.Equal(t, "a", holder.ID)
+
+	// A holder whose heartbeat went stale is taken over.
+	holder, err = store.AcquireInstance(b, nowNano()+1)
+	require.NoError(t, err)
+	assert.Nil(t, holder)
+	held, err := store.RenewInstance("a", nowNano())
+	require.NoError(t, err)
+	assert.False(t, held)
+	held, err = store.RenewInstance("b", nowNano())
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	require.NoError(t, store.ReleaseInstance("a"), "not the holder: no-op")
+	cur, err := store.InstanceHolder()
+	require.NoError(t, err)
+	require.NotNil(t, cur)
+	assert.Equal(t, "b", cur.ID)
+	require.NoError(t, store.ReleaseInstance("b"))
+	cur, err = store.InstanceHolder()
+	require.NoError(t, err)
+	assert.Nil(t, cur)
+}
+
+func TestDaemon_SecondInstanceReadOnly(t *testing.T) {
+	orig := instanceHeartbeatInterval
+	instanceHeartbeatInterval = 20 * time.Millisecond
+	t.Cleanup(func() { instanceHeartbeatInterval = orig })
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	first := NewDaemon(store, archivesRoot, spacesRoot)
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	done1 := make(chan struct{})
+	go func() { defer close(done1); first.Run(ctx1) }()
+	defer func() { cancel1(); <-done1 }()
+	require.Eventually(t, func() bool {
+		cur, _ := store.InstanceHolder()
+		return cur != nil && cur.ID == first.instance.self.ID
+	}, 5*time.Second, 10*time.Millisecond)
+
+	second := h.daemon
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done2 := make(chan struct{})
+	go func() { defer close(done2); second.Run(ctx2) }()
+	defer func() { cancel2(); <-done2 }()
+	require.Eventually(t, func() bool { return second.instance.blockedBy() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	guarded := h.GuardInstance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	guarded.ServeHTTP(w, httptest.NewRequest("POST", "/api/sync/select", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), first.instance.self.ID)
+	w = httptest.NewRecorder()
+	guarded.ServeHTTP(w, httptest.NewRequest("GET", "/api/sync/entries", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "reads still work")
+
+	w = httptest.NewRecorder()
+	h.HandleInstance(w, httptest.NewRequest("GET", "/api/sync/instance", nil))
+	var resp InstanceResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.ReadOnly)
+	assert.Equal(t, first.instance.self.ID, resp.Holder.ID)
+
+	// Once the first daemon stops, the second takes over.
+	cancel1()
+	<-done1
+	require.Eventually(t, func() bool {
+		cur, _ := store.InstanceHolder()
+		return cur != nil && cur.ID == second.instance.self.ID
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, second.instance.blockedBy())
+}
@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// ioFlushInterval is how often the copy counters are written to the store.
+var ioFlushInterval = time.Minute
+
+// ioDay is the UTC day t falls on, as io_stats keys it.
+func ioDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// ioRoot is the top-level directory relPath is under, "" for a file
+// directly under the root.
+func ioRoot(relPath string) string {
+	top, _, nested := strings.Cut(filepath.ToSlash(relPath), "/")
+	if !nested {
+		return ""
+	}
+	return top
+}
+
+type ioKey struct {
+	day, root string
+	dir       IODirection
+}
+
+// ioCounters accumulates completed copies between the roots until the
+// daemon flushes them into the store.
+type ioCounters struct {
+	mu      gosync.Mutex
+	pending map[ioKey]IOStat
+}
+
+// ioStats counts the copies made by copyToSpaces and copyFromSpaces.
+var ioStats = &ioCounters{pending: make(map[ioKey]IOStat)}
+
+// record counts a copy of size bytes of the file at relPath.
+func (c *ioCounters) record(relPath string, dir IODirection, size int64) {
+	c.add(IOStat{Day: ioDay(nowFunc()), Root: ioRoot(relPath), Direction: dir, Bytes: size, Files: 1})
+}
+
+func (c *ioCounters) add(stats ...IOStat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, st := range stats {
+		key := ioKey{st.Day, st.Root, st.Direction}
+		p := c.pending[key]
+		st.Bytes += p.Bytes
+		st.Files += p.Files
+		c.pending[key] = st
+	}
+}
+
+// take returns and clears the counts recorded since the last take.
+func (c *ioCounters) take() []IOStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]IOStat, 0, len(c.pending))
+	for _, st := range c.pending {
+		stats = append(stats, st)
+	}
+	clear(c.pending)
+	return stats
+}
+
+// flushIOStats writes the pending copy counts to the store and drops the
+// days that fell out of the retention window.
+func (d *Daemon) flushIOStats() error {
+	if stats := ioStats.take(); len(stats) > 0 {
+		if err := d.store.AddIOStats(stats); err != nil {
+			ioStats.add(stats...) // retried on the next flush
+			return err
+		}
+	}
+	days := currentConfig().IOStatsDays
+	return d.store.PruneIOStats(ioDay(nowFunc().AddDate(0, 0, 1-days)))
+}
+
+// runIOStats flushes the copy counters and verification outcomes every
+// ioFlushInterval. Run flushes them once more after the workers stop.
+func (d *Daemon) runIOStats(ctx context.Context) {
+	l := sub("iostats")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(ioFlushInterval)):
+			if err := d.flushIOStats(); err != nil {
+				l.Warn("flush io stats failed", "err", err)
+			}
+			if err := d.flushVerifications(); err != nil {
+				l.Warn("flush verifications failed", "err", err)
+			}
+		}
+	}
+}
+
+// IOStatsResponse is the body of GET /api/sync/stats/io.
+type IOStatsResponse struct {
+	Days   int                   `json:"days"`
+	Since  string                `json:"since"` // first day covered
+	Items  []IOStat              `json:"items"`
+	Totals map[IODirection]int64 `json:"totals"` // bytes per direction over the window
+}
+
+// HandleIOStats handles GET /api/sync/stats/io?days=N
+// Returns the bytes copied between the roots per day, top-level directory
+// and direction, over the last N days including today; N defaults to and
+// is capped at the ioStatsDays retention window.
+func (h *Handlers) HandleIOStats(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	days := currentConfig().IOStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "days must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		days = min(n, days)
+	}
+	if err := h.daemon.flushIOStats(); err != nil {
+		l.Warn("flush io stats failed", "err", err)
+	}
+	since := ioDay(nowFunc().AddDate(0, 0, 1-days))
+	items, err := h.store.ListIOStats(since)
+	if err != nil {
+		l.Error("io stats failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	totals := map[IODirection]int64{IOToSpaces: 0, IOToArchives: 0}
+	for _, st := range items {
+		totals[st.Direction] += st.Bytes
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IOStatsResponse{Days: days, Since: since, Items: items, Totals: totals}) //nolint:errcheck
+}
@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getIOStats(t *testing.T, h *Handlers, query string) IOStatsResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleIOStats(w, httptest.NewRequest("GET", "/api/sync/stats/io"+query, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp IOStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestIORoot(t *testing.T) {
+	assert.Equal(t, "", ioRoot("a.txt"))
+	assert.Equal(t, "Docs", ioRoot("Docs/a.txt"))
+	assert.Equal(t, "Docs", ioRoot("Docs/sub/b.txt"))
+}
+
+func TestIOStats_CountsCopiesPerRootAndDirection(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	ioStats.take() // left by other tests
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/sub/", "Docs/sub/bb.txt", "top.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{
+		registered(t, store, archivesRoot, "Docs").Inode,
+		registered(t, store, archivesRoot, "top.txt").Inode,
+	}, true))
+	for _, p := range []string{"Docs/a.txt", "Docs/sub/bb.txt", "top.txt"} {
+		require.NoError(t, RunPipeline(context.Background(), p, store, archivesRoot, spacesRoot, trash, nil))
+	}
+	require.FileExists(t, filepath.Join(spacesRoot, "top.txt"))
+
+	// A file created in Spaces is copied back
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "Docs", "new.txt"), []byte("new!!"), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "Docs/new.txt", store, archivesRoot, spacesRoot, trash, nil))
+	require.FileExists(t, filepath.Join(archivesRoot, "Docs", "new.txt"))
+
+	resp := getIOStats(t, h, "")
+	today := ioDay(nowFunc())
+	assert.Equal(t, 90, resp.Days)
+	assert.Equal(t, []IOStat{
+		{Day: today, Root: "", Direction: IOToSpaces, Bytes: int64(len("top.txt")), Files: 1},
+		{Day: today, Root: "Docs", Direction: IOToSpaces, Bytes: int64(len("Docs/a.txt") + len("Docs/sub/bb.txt")), Files: 2},
+		{Day: today, Root: "Docs", Direction: IOToArchives, Bytes: 5, Files: 1},
+	}, resp.Items)
+	assert.Equal(t, map[IODirection]int64{IOToSpaces: int64(len("top.txt") + len("Docs/a.txt") + len("Docs/sub/bb.txt")), IOToArchives: 5}, resp.Totals)
+
+	// Counts persist and accumulate across flushes
+	ioStats.record("Docs/c.txt", IOToSpaces, 100)
+	require.NoError(t, h.daemon.flushIOStats())
+	stats, err := store.ListIOStats(today)
+	require.NoError(t, err)
+	require.Len(t, stats, 3)
+	assert.Equal(t, int64(len("Docs/a.txt")+len("Docs/sub/bb.txt")+100), stats[1].Bytes)
+	assert.Equal(t, int64(3), stats[1].Files)
+}
+
+func TestIOStats_RetentionWindow(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	ioStats.take()
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.IOStatsDays = 7
+	require.NoError(t, setConfig(cfg))
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	useFakeClock(t, now)
+	require.NoError(t, store.AddIOStats([]IOStat{
+		{Day: "2026-03-01", Direction: IOToSpaces, Bytes: 1, Files: 1},
+		{Day: "2026-03-04", Direction: IOToSpaces, Bytes: 2, Files: 1},
+		{Day: "2026-03-08", Direction: IOToArchives, Bytes: 4, Files: 1},
+		{Day: "2026-03-10", Direction: IOToSpaces, Bytes: 8, Files: 1},
+	}))
+
+	resp := getIOStats(t, h, "")
+	assert.Equal(t, 7, resp.Days)
+	assert.Equal(t, "2026-03-04", resp.Since)
+	require.Len(t, resp.Items, 3)
+	assert.Equal(t, map[IODirection]int64{IOToSpaces: 10, IOToArchives: 4}, resp.Totals)
+
+	// Flushing dropped the day outside the window
+	stats, err := store.ListIOStats("")
+	require.NoError(t, err)
+	assert.Len(t, stats, 3)
+
+	resp = getIOStats(t, h, "?days=3")
+	assert.Equal(t, "2026-03-08", resp.Since)
+	assert.Len(t, resp.Items, 2)
+	assert.Equal(t, 7, getIOStats(t, h, "?days=30").Days, "capped at the retention window")
+
+	w := httptest.NewRecorder()
+	h.HandleIOStats(w, httptest.NewRequest("GET", "/api/sync/stats/io?days=0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+)
+
+// Cancelling a selection job, by ID or for the paths at or under a prefix,
+// drops its paths still queued and aborts the copies of those being run:
+// the worker's hasQueued check reports an aborted path as superseded, so
+// SafeCopy stops between chunks and leaves the destination as it was.
+// The entries of the paths that were not copied get back the selection
+// flag they had before the request, taken from the undo log; a copy that
+// finished before the abort reached it keeps its new state. Other jobs,
+// reconciles and repairs waiting on a path dropped count it as failed.
+
+// pathAbort is a cancelled run, to roll back if it didn't get through.
+type pathAbort struct {
+	ino      uint64
+	opID     int64
+	selected bool // what the job set
+}
+
+// CancelResponse is the body of a job cancel.
+type CancelResponse struct {
+	Jobs     []SelectionJob `json:"jobs"`     // the jobs cancelled from, as of after it
+	Skipped  int            `json:"skipped"`  // paths dropped from the queue
+	Aborted  int            `json:"aborted"`  // runs told to stop
+	Reverted int64          `json:"reverted"` // entries given their previous flag back
+}
+
+// cancelJobs cancels the job with id, or with id empty every running job,
+// for the paths at or under prefix (all for an empty prefix). ok is false
+// if no job has id.
+func (d *Daemon) cancelJobs(id, prefix string) (resp CancelResponse, ok bool, err error) {
+	cancels, ok := d.jobs.cancel(id, prefix, d.queue.Remove)
+	if !ok {
+		return resp, false, nil
+	}
+	resp.Jobs = []SelectionJob{}
+	for _, c := range cancels {
+		resp.Jobs = append(resp.Jobs, c.job)
+		selected := c.job.Action == "select"
+		var paths []string
+		inodes := make([]uint64, 0, len(c.skipped))
+		for _, p := range c.skipped {
+			paths = append(paths, p.path)
+			inodes = append(inodes, p.ino)
+		}
+		for _, p := range c.running {
+			paths = append(paths, p.path)
+			if d.abortRun(p.path, pathAbort{ino: p.ino, opID: c.opID, selected: selected}) {
+				resp.Aborted++
+			}
+		}
+		events.dropBulk(paths)
+		for _, p := range c.skipped {
+			d.dropped(p.path)
+		}
+		resp.Skipped += len(c.skipped)
+		if len(inodes) > 0 && c.opID != 0 {
+			n, err := d.store.RevertSelection(c.opID, selected, inodes)
+			if err != nil {
+				return resp, true, err
+			}
+			resp.Reverted += n
+		}
+	}
+	if resp.Reverted > 0 {
+		d.pokeWatchScope()
+	}
+	sub("daemon").Info("selection jobs cancelled", "id", id, "prefix", prefix,
+		"jobs", len(resp.Jobs), "skipped", resp.Skipped, "aborted", resp.Aborted, "reverted", resp.Reverted)
+	return resp, true, nil
+}
+
+// abortRun asks the run of relPath to stop, reporting false if none is in
+// progress.
+func (d *Daemon) abortRun(relPath string, a pathAbort) bool {
+	d.inflightMu.Lock()
+	defer d.inflightMu.Unlock()
+	if _, running := d.inflight[relPath]; !running {
+		return false
+	}
+	d.aborts[relPath] = a
+	return true
+}
+
+// abortRequested reports whether the run of relPath was asked to stop.
+func (d *Daemon) abortRequested(relPath string) bool {
+	d.inflightMu.Lock()
+	defer d.inflightMu.Unlock()
+	_, ok := d.aborts[relPath]
+	return ok
+}
+
+// finishAborted settles a run of relPath that ended with err once it has
+// released the path: if the run was asked to stop and failed to finish,
+// its entry gets its previous flag back and it reports true, the run
+// counting as done rather than failed.
+func (d *Daemon) finishAborted(relPath string, err error) bool {
+	d.inflightMu.Lock()
+	a, ok := d.aborts[relPath]
+	delete(d.aborts, relPath)
+	d.inflightMu.Unlock()
+	if !ok || err == nil {
+		return false
+	}
+	l := sub("daemon")
+	l.Info("run aborted by a cancelled job", "path", relPath, "err", err)
+	if a.opID != 0 {
+		if _, rerr := d.store.RevertSelection(a.opID, a.selected, []uint64{a.ino}); rerr != nil {
+			l.Error("revert aborted selection failed", "path", relPath, "err", rerr)
+		}
+	}
+	return true
+}
+
+// HandleCancelSelectionJob handles POST /api/sync/jobs/{id}/cancel, and
+// DELETE /api/sync/jobs/{id}
+// It stops a running select or deselect; the paths it already ran on
+// keep their new state.
+func (h *Handlers) HandleCancelSelectionJob(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	if id == "cancel" {
+		id = path.Base(path.Dir(r.URL.Path))
+	}
+	h.cancelJobs(w, id, "")
+}
+
+// HandleCancelJobsUnder handles POST /api/sync/jobs/cancel?path=
+// It stops the running selects and deselects for the paths at or under
+// path.
+func (h *Handlers) HandleCancelJobsUnder(w http.ResponseWriter, r *http.Request) {
+	prefix, err := safeRelPath(r.URL.Query().Get("path"))
+	if err != nil || prefix == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	h.cancelJobs(w, "", prefix)
+}
+
+func (h *Handlers) cancelJobs(w http.ResponseWriter, id, prefix string) {
+	l := sub("handlers")
+	resp, ok, err := h.daemon.cancelJobs(id, prefix)
+	if err != nil {
+		l.Error("cancel jobs failed", "id", id, "prefix", prefix, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	l.Info("HTTP cancel jobs", "id", id, "prefix", prefix, "jobs", len(resp.Jobs), "skipped", resp.Skipped, "aborted", resp.Aborted)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
+}
@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cancelJobs(t *testing.T, h *Handlers, handler http.HandlerFunc, url string) CancelResponse {
+	t.Helper()
+	w := postJSON(handler, url, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp CancelResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func selected(t *testing.T, h *Handlers, relPath string) bool {
+	t.Helper()
+	ino, err := h.resolvePathToIno(relPath)
+	require.NoError(t, err)
+	entry, err := h.store.GetEntry(ino)
+	require.NoError(t, err)
+	return entry.Selected
+}
+
+func TestCancelJobsUnder_DropsOnlyThePrefix(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Projects/", "Projects/alpha/", "Projects/alpha/a.txt", "Projects/alphabet.txt", "Projects/beta/", "Projects/beta/b.txt"}, nil)
+	ino, err := h.resolvePathToIno("Projects")
+	require.NoError(t, err)
+	id := selectJob(t, h, ino)
+
+	resp := cancelJobs(t, h, h.HandleCancelJobsUnder, "/api/sync/jobs/cancel?path=Projects/alpha")
+	assert.Equal(t, 2, resp.Skipped)
+	assert.Equal(t, int64(2), resp.Reverted)
+	require.Len(t, resp.Jobs, 1)
+	assert.Equal(t, JobRunning, resp.Jobs[0].State)
+	assert.Equal(t, 4, resp.Jobs[0].Pending)
+	q := h.daemon.queue
+	assert.False(t, q.Has("Projects/alpha"))
+	assert.False(t, q.Has("Projects/alpha/a.txt"))
+	assert.True(t, q.Has("Projects/alphabet.txt"))
+	assert.False(t, selected(t, h, "Projects/alpha/a.txt"))
+	assert.True(t, selected(t, h, "Projects/beta/b.txt"))
+
+	resp = cancelJobs(t, h, h.HandleCancelSelectionJob, "/api/sync/jobs/"+id+"/cancel")
+	assert.Equal(t, 4, resp.Skipped)
+	require.Len(t, resp.Jobs, 1)
+	assert.Equal(t, JobCancelled, resp.Jobs[0].State)
+	assert.Equal(t, 6, resp.Jobs[0].Skipped)
+	assert.Zero(t, q.Len())
+	assert.False(t, selected(t, h, "Projects"))
+	assert.False(t, selected(t, h, "Projects/beta/b.txt"))
+
+	w := postJSON(h.HandleCancelJobsUnder, "/api/sync/jobs/cancel", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCancelSelectionJob_FinishesRepair(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/copy.txt"}, map[string]bool{"Docs/copy.txt": true})
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Docs/copy.txt")))
+	report := postFsck(t, h, "repair=true&rate=100", http.StatusAccepted)
+	require.Eventually(t, func() bool { return h.daemon.queue.Has("Docs/copy.txt") }, time.Second, time.Millisecond)
+	ino, err := h.resolvePathToIno("Docs")
+	require.NoError(t, err)
+	id := selectJob(t, h, ino)
+
+	// The job's cancel drops the path the repair waits on too
+	cancelJobs(t, h, h.HandleCancelSelectionJob, "/api/sync/jobs/"+id+"/cancel")
+	assert.False(t, h.daemon.queue.Has("Docs/copy.txt"))
+	got, ok := h.daemon.fsckRuns.get(report.ID)
+	require.True(t, ok)
+	assert.True(t, got.Complete)
+	assert.Equal(t, map[int]int{21: 1}, got.Failed)
+	postFsck(t, h, "repair=true&rate=100", http.StatusAccepted)
+}
+
+func TestCancelSelectionJob_AbortsRunningCopy(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"big.bin"}, nil)
+	ino, err := h.resolvePathToIno("big.bin")
+	require.NoError(t, err)
+	id := selectJob(t, h, ino)
+
+	d := h.daemon
+	job, ok := d.queue.PopJob(nil)
+	require.True(t, ok)
+	release := d.acquirePath(job.Path)
+	resp := cancelJobs(t, h, h.HandleCancelSelectionJob, "/api/sync/jobs/"+id+"/cancel")
+	assert.Equal(t, 1, resp.Aborted)
+	assert.Equal(t, JobCancelled, resp.Jobs[0].State)
+
+	hasQueued := func() bool { return d.queue.Has(job.Path) || d.abortRequested(job.Path) }
+	err = RunPipeline(context.Background(), job.Path, store, archivesRoot, spacesRoot, d.trashRoot, hasQueued)
+	require.Error(t, err)
+	release()
+	assert.True(t, d.finishAborted(job.Path, err))
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "big.bin"))
+	assert.False(t, selected(t, h, "big.bin"), "the uncopied file is deselected again")
+	assert.False(t, d.finishAborted(job.Path, err), "the abort is settled once")
+}
+
+func TestFinishAborted_KeepsCompletedRuns(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	d := h.daemon
+	release := d.acquirePath("a.txt")
+	assert.True(t, d.abortRun("a.txt", pathAbort{ino: 1, opID: 1, selected: true}))
+	release()
+	assert.False(t, d.finishAborted("a.txt", nil))
+	assert.False(t, d.abortRequested("a.txt"))
+	assert.False(t, d.abortRun("a.txt", pathAbort{}), "not running")
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Journaled pipeline operations.
+const (
+	// IntentConflictRename renames an Archives file to its conflict name
+	// (Intent.Target) and then records the conflict in the DB.
+	IntentConflictRename = "conflict-rename"
+	// IntentTrash moves a Spaces copy to the trash and then drops its
+	// spaces_view record.
+	IntentTrash = "trash"
+)
+
+// journaled runs a pipeline step under the intent journal: the intent is
+// recorded, disk performs the disk action, the intent is marked done, db
+// brings the DB in line, and the intent is removed in the same
+// transaction. A crash at any point leaves a journal row that
+// replayJournal can finish from, or none once db has committed.
+func journaled(store *Store, in Intent, disk func() error, db func(tx *TxStore) error) error {
+	id, err := store.BeginIntent(in)
+	if err != nil {
+		return err
+	}
+	if err := disk(); err != nil {
+		// The disk action failed before taking effect; nothing to replay.
+		store.CompleteIntent(id) //nolint:errcheck
+		return err
+	}
+	// The disk action took effect: the DB follows it even if the run is
+	// cancelled meanwhile.
+	store = store.WithContext(context.WithoutCancel(store.context()))
+	if err := store.MarkIntentDone(id); err != nil {
+		return err
+	}
+	return store.WithTx(func(tx *TxStore) error {
+		if err := db(tx); err != nil {
+			return err
+		}
+		return tx.CompleteIntent(id)
+	})
+}
+
+// replayJournal finishes intents left behind by a crash. Intents whose
+// disk action completed (recorded as done, or observed on disk) get their
+// DB update applied; the rest are discarded. Returns the relative paths
+// touched so the caller can re-evaluate them.
+func replayJournal(store *Store, archivesRoot, spacesRoot string) ([]string, error) {
+	l := sub("journal")
+	intents, err := store.ListIntents()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, in := range intents {
+		done := in.Done
+		switch in.Op {
+		case IntentConflictRename:
+			conflictRel := filepath.Join(filepath.Dir(in.Path), in.Target)
+			if !done {
+				_, _, inode, _ := statFile(filepath.Join(archivesRoot, conflictRel))
+				done = inode != nil && *inode == in.Inode
+			}
+			paths = append(paths, in.Path, conflictRel)
+		case IntentTrash:
+			if !done {
+				mtime, _, _, _ := statFile(filepath.Join(spacesRoot, in.Path))
+				done = mtime == nil
+			}
+			paths = append(paths, in.Path)
+		default:
+			l.Warn("unknown journal op, discarding", "op", in.Op, "path", in.Path)
+		}
+		// The DB update and the intent's removal commit together.
+		err := store.WithTx(func(tx *TxStore) error {
+			switch {
+			case !done:
+			case in.Op == IntentConflictRename:
+				// The winner, if copied, is registered when the path is re-evaluated.
+				c := Conflict{Path: in.Path, ConflictIno: in.Inode, ConflictName: in.Target}
+				if _, err := tx.RegisterConflict(c, nil, nil); errors.Is(err, sql.ErrNoRows) {
+					// The entry has been dropped since: nothing to rename.
+				} else if err != nil {
+					return fmt.Errorf("replay %s %s: %w", in.Op, in.Path, err)
+				}
+			case in.Op == IntentTrash:
+				if err := tx.DeleteSpacesView(in.Inode); err != nil {
+					return fmt.Errorf("replay %s %s: %w", in.Op, in.Path, err)
+				}
+			}
+			return tx.CompleteIntent(in.ID)
+		})
+		if err != nil {
+			return paths, err
+		}
+		l.Info("journal intent replayed", "op", in.Op, "path", in.Path, "inode", in.Inode, "applied", done)
+	}
+	return paths, nil
+}
@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournaled_FailedDiskActionLeavesNoIntent(t *testing.T) {
+	store := setupTestDB(t)
+	err := journaled(store, Intent{Op: IntentTrash, Path: "a.txt"},
+		func() error { return errors.New("boom") },
+		func(*TxStore) error { t.Fatal("db step must not run"); return nil })
+	require.Error(t, err)
+	intents, err := store.ListIntents()
+	require.NoError(t, err)
+	assert.Empty(t, intents)
+}
+
+func TestReplayJournal(t *testing.T) {
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Docs/", "Docs/report.txt", "old.txt", "keep.txt"},
+		map[string]bool{"old.txt": true, "keep.txt": true})
+	report, _, err := lookupDB(store, archivesRoot, "Docs/report.txt")
+	require.NoError(t, err)
+	old, oldSV, err := lookupDB(store, archivesRoot, "old.txt")
+	require.NoError(t, err)
+	require.NotNil(t, oldSV)
+	keep, keepSV, err := lookupDB(store, archivesRoot, "keep.txt")
+	require.NoError(t, err)
+	require.NotNil(t, keepSV)
+
+	// Crash after the conflict rename hit disk but before it was marked done
+	require.NoError(t, os.Rename(filepath.Join(archivesRoot, "Docs/report.txt"), filepath.Join(archivesRoot, "Docs/report_conflict-1.txt")))
+	_, err = store.BeginIntent(Intent{Op: IntentConflictRename, Path: "Docs/report.txt", Inode: report.Inode, Target: "report_conflict-1.txt"})
+	require.NoError(t, err)
+
+	// Crash after trashing, before the spaces_view was dropped
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "old.txt")))
+	id, err := store.BeginIntent(Intent{Op: IntentTrash, Path: "old.txt", Inode: old.Inode})
+	require.NoError(t, err)
+	require.NoError(t, store.MarkIntentDone(id))
+
+	// Crash before the trash move happened: nothing to apply
+	_, err = store.BeginIntent(Intent{Op: IntentTrash, Path: "keep.txt", Inode: keep.Inode})
+	require.NoError(t, err)
+
+	paths, err := replayJournal(store, archivesRoot, spacesRoot)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Docs/report.txt", "Docs/report_conflict-1.txt", "old.txt", "keep.txt"}, paths)
+
+	e, err := store.GetEntry(report.Inode)
+	require.NoError(t, err)
+	assert.Equal(t, "report_conflict-1.txt", e.Name)
+	sv, err := store.GetSpacesView(old.Inode)
+	require.NoError(t, err)
+	assert.Nil(t, sv)
+	sv, err = store.GetSpacesView(keep.Inode)
+	require.NoError(t, err)
+	assert.NotNil(t, sv)
+
+	intents, err := store.ListIntents()
+	require.NoError(t, err)
+	assert.Empty(t, intents)
+}
@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Selecting a directory that happens to hold a disk image shouldn't start
+// a copy of terabytes without a word. With Config.MaxAutoCopyMB set, P3
+// copies a selected file larger than that into Spaces only once it was
+// confirmed: until then it is recorded in large_copies, reported as
+// "requires-confirmation", and left out of Spaces. Confirmation is per
+// file and lasts until it is deselected, so selecting it again asks again.
+
+// StatusNeedsConfirmation is the UI status of a selected file too large to
+// be copied into Spaces without confirmation.
+const StatusNeedsConfirmation = "requires-confirmation"
+
+// LargeCopy is a selected file over maxAutoCopyMB.
+type LargeCopy struct {
+	Inode     uint64 `json:"inode"`
+	Path      string `json:"path"` // relative to the roots
+	Size      int64  `json:"size"`
+	Confirmed bool   `json:"confirmed"`
+	SeenAt    int64  `json:"seenAt"` // nanoseconds
+}
+
+// gateLargeCopy reports whether P3 must leave the selected file at
+// archivePath out of Spaces for want of confirmation, recording it as
+// waiting on first sight.
+func gateLargeCopy(store *Store, entry *Entry, relPath, archivePath string) (bool, error) {
+	limit := int64(activeConfig.Load().MaxAutoCopyMB) << 20
+	if limit == 0 {
+		return false, nil
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil || info.Size() <= limit {
+		return false, nil // a vanished source fails the copy instead
+	}
+	lc, err := store.LargeCopy(entry.Inode)
+	if err != nil {
+		return false, err
+	}
+	if lc != nil {
+		return !lc.Confirmed, nil
+	}
+	if err := store.PutLargeCopy(entry.Inode, info.Size()); err != nil {
+		return false, err
+	}
+	sub("P3").Warn("selected file over maxAutoCopyMB, waiting for confirmation", "path", relPath, "size", info.Size())
+	publishStatus(relPath, entry.Inode, StatusNeedsConfirmation)
+	return true, nil
+}
+
+// PutLargeCopy records a large selected file as waiting for confirmation.
+func (s *Store) PutLargeCopy(entryIno uint64, size int64) error {
+	_, err := s.exec(`
+		INSERT INTO large_copies (entry_ino, size, seen_at) VALUES (?, ?, ?)
+		ON CONFLICT(entry_ino) DO UPDATE SET size = excluded.size
+	`, entryIno, size, nowNano())
+	if err != nil {
+		return fmt.Errorf("put large copy: %w", err)
+	}
+	return nil
+}
+
+// LargeCopy returns the large file recorded for entryIno, or nil.
+func (s *Store) LargeCopy(entryIno uint64) (*LargeCopy, error) {
+	lc := &LargeCopy{Inode: entryIno}
+	err := s.rdb.QueryRowContext(s.context(), "SELECT size, confirmed, seen_at FROM large_copies WHERE entry_ino = ?", entryIno).
+		Scan(&lc.Size, &lc.Confirmed, &lc.SeenAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get large copy: %w", err)
+	}
+	return lc, nil
+}
+
+// ListLargeCopies returns the selected large files still waiting for
+// confirmation, oldest first, without their paths.
+func (s *Store) ListLargeCopies() ([]LargeCopy, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT l.entry_ino, l.size, l.seen_at
+		FROM large_copies l JOIN entries e ON e.inode = l.entry_ino
+		WHERE NOT l.confirmed AND e.selected AND e.deleted_at = 0
+		ORDER BY l.seen_at, l.entry_ino
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list large copies: %w", err)
+	}
+	defer rows.Close()
+
+	var items []LargeCopy
+	for rows.Next() {
+		var lc LargeCopy
+		if err := rows.Scan(&lc.Inode, &lc.Size, &lc.SeenAt); err != nil {
+			return nil, fmt.Errorf("scan large copy: %w", err)
+		}
+		items = append(items, lc)
+	}
+	return items, rows.Err()
+}
+
+// ConfirmLargeCopies confirms the waiting large files among inodes. It
+// returns the inodes it confirmed.
+func (s *Store) ConfirmLargeCopies(inodes []uint64) ([]uint64, error) {
+	var found []uint64
+	err := s.WithTx(func(t *TxStore) error {
+		for _, ino := range inodes {
+			res, err := t.tx.Exec("UPDATE large_copies SET confirmed = 1 WHERE entry_ino = ? AND NOT confirmed", ino)
+			if err != nil {
+				return fmt.Errorf("confirm large copy: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				found = append(found, ino)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// forgetLargeCopies drops the records of deselected large files, so
+// selecting them again needs a new confirmation.
+func forgetLargeCopies(tx *sql.Tx, inodes []uint64) error {
+	if len(inodes) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(inodes) // a []uint64 always encodes
+	if _, err := tx.Exec("DELETE FROM large_copies WHERE entry_ino IN (SELECT value FROM json_each(?))", string(b)); err != nil {
+		return fmt.Errorf("forget large copies: %w", err)
+	}
+	return nil
+}
+
+// HandleListLargeCopies handles GET /api/sync/large: the selected files
+// over maxAutoCopyMB waiting for confirmation before they are copied.
+func (h *Handlers) HandleListLargeCopies(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	items, err := h.store.ListLargeCopies()
+	if err != nil {
+		sub("handlers").Error("list large copies failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []LargeCopy{}
+	}
+	for i := range items {
+		if e, err := h.store.GetEntry(items[i].Inode); err == nil && e != nil {
+			items[i].Path = h.store.RelPath(e)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items}) //nolint:errcheck
+}
+
+// LargeCopyRequest names large files by their inodes.
+type LargeCopyRequest struct {
+	Inodes []uint64 `json:"inodes"`
+}
+
+// HandleConfirmLargeCopies handles POST /api/sync/large/confirm: the
+// files are copied into Spaces like any other selected file.
+func (h *Handlers) HandleConfirmLargeCopies(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	l := sub("handlers")
+	var req LargeCopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Inodes) == 0 {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	found, err := h.store.ConfirmLargeCopies(req.Inodes)
+	if err != nil {
+		l.Error("confirm large copies failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, ino := range found {
+		e, err := h.store.GetEntry(ino)
+		if err != nil || e == nil {
+			continue
+		}
+		h.daemon.Queue().PushTraced(r.Context(), h.store.RelPath(e), sizeOrZero(e.Size), false)
+	}
+	l.Info("HTTP large copies confirmed", "count", len(found))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"confirmed": len(found)}) //nolint:errcheck
+}
@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_LargeCopyWaitsForConfirmation(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.MaxAutoCopyMB = 1
+	require.NoError(t, setConfig(cfg))
+
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "big.img", bytes.Repeat([]byte("x"), 1<<20+1))
+	env.writeArchive(t, "small.txt", []byte("small"))
+	env.run(t, "big.img")
+	env.run(t, "small.txt")
+	big := registered(t, env.store, env.archivesRoot, "big.img")
+	small := registered(t, env.store, env.archivesRoot, "small.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{big.Inode, small.Inode}, true))
+	env.run(t, "big.img")
+	env.run(t, "small.txt")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "small.txt"))
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "big.img"))
+
+	h := NewHandlers(env.store, NewDaemon(env.store, env.archivesRoot, env.spacesRoot), env.archivesRoot, env.spacesRoot)
+	w := httptest.NewRecorder()
+	h.HandleListLargeCopies(w, httptest.NewRequest("GET", "/api/sync/large", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct{ Items []LargeCopy }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, big.Inode, list.Items[0].Inode)
+	assert.Equal(t, "big.img", list.Items[0].Path)
+	assert.Equal(t, int64(1<<20+1), list.Items[0].Size)
+
+	entry, err := env.store.GetEntry(big.Inode)
+	require.NoError(t, err)
+	item, err := h.entryResponse(entry, "big.img", false)
+	require.NoError(t, err)
+	assert.Equal(t, StatusNeedsConfirmation, item.Status)
+
+	// Running again doesn't copy it either.
+	env.run(t, "big.img")
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "big.img"))
+
+	w = postJSON(h.HandleConfirmLargeCopies, "/api/sync/large/confirm", fmt.Sprintf(`{"inodes":[%d,%d]}`, big.Inode, small.Inode))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"confirmed":1}`, w.Body.String())
+	assert.True(t, h.daemon.queue.Has("big.img"))
+	env.run(t, "big.img")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "big.img"))
+	item, err = h.entryResponse(entry, "big.img", false)
+	require.NoError(t, err)
+	assert.Equal(t, "synced", item.Status)
+}
+
+func TestStore_DeselectForgetsLargeCopy(t *testing.T) {
+	store := setupTestDB(t)
+	size := int64(10)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "big.img", Type: "binary", Size: &size, Mtime: 1}))
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+	require.NoError(t, store.PutLargeCopy(1, size))
+	found, err := store.ConfirmLargeCopies([]uint64{1})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1}, found)
+	lc, err := store.LargeCopy(1)
+	require.NoError(t, err)
+	require.NotNil(t, lc)
+	assert.True(t, lc.Confirmed)
+
+	require.NoError(t, store.SetSelected([]uint64{1}, false))
+	lc, err = store.LargeCopy(1)
+	require.NoError(t, err)
+	assert.Nil(t, lc, "selecting it again needs a new confirmation")
+}
+
+func TestHandleConfirmLargeCopies_BadRequest(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	w := postJSON(h.HandleConfirmLargeCopies, "/api/sync/large/confirm", `{"inodes":[]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"slices"
+	gosync "sync"
+	"time"
+)
+
+// latencySamples is how many of the latest pipeline runs queue latency
+// percentiles are computed over.
+const latencySamples = 1024
+
+// durationRing holds the latest latencySamples durations.
+type durationRing struct {
+	mu      gosync.Mutex
+	samples [latencySamples]time.Duration
+	n       int // samples recorded, capped at len(samples)
+	next    int
+}
+
+func (r *durationRing) add(d time.Duration) {
+	r.mu.Lock()
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % len(r.samples)
+	r.n = min(r.n+1, len(r.samples))
+	r.mu.Unlock()
+}
+
+// percentiles returns the p50 and p95 of the samples, 0 without any.
+func (r *durationRing) percentiles() (p50, p95 time.Duration) {
+	r.mu.Lock()
+	s := slices.Clone(r.samples[:r.n])
+	r.mu.Unlock()
+	if len(s) == 0 {
+		return 0, 0
+	}
+	slices.Sort(s)
+	at := func(p int) time.Duration { return s[(len(s)-1)*p/100] }
+	return at(50), at(95)
+}
+
+func (r *durationRing) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+var (
+	// queueWait is how long popped paths waited in the eval queue.
+	queueWait = &durationRing{}
+	// runTime is how long the workers took to process them.
+	runTime = &durationRing{}
+)
+
+// QueueLatency reports, over the latest pipeline runs, how long paths
+// waited in the eval queue and how long processing them took: with
+// waits growing while runs stay short, the workers aren't keeping up.
+type QueueLatency struct {
+	Samples   int   `json:"samples"`
+	WaitP50Ms int64 `json:"waitP50Ms"`
+	WaitP95Ms int64 `json:"waitP95Ms"`
+	RunP50Ms  int64 `json:"runP50Ms"`
+	RunP95Ms  int64 `json:"runP95Ms"`
+}
+
+func queueLatency() QueueLatency {
+	w50, w95 := queueWait.percentiles()
+	r50, r95 := runTime.percentiles()
+	return QueueLatency{
+		Samples:   runTime.count(),
+		WaitP50Ms: w50.Milliseconds(),
+		WaitP95Ms: w95.Milliseconds(),
+		RunP50Ms:  r50.Milliseconds(),
+		RunP95Ms:  r95.Milliseconds(),
+	}
+}
@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationRing_Percentiles(t *testing.T) {
+	r := &durationRing{}
+	p50, p95 := r.percentiles()
+	assert.Zero(t, p50)
+	assert.Zero(t, p95)
+
+	for i := 1; i <= 100; i++ {
+		r.add(time.Duration(i) * time.Millisecond)
+	}
+	p50, p95 = r.percentiles()
+	assert.Equal(t, 50*time.Millisecond, p50)
+	assert.Equal(t, 95*time.Millisecond, p95)
+
+	// Only the latest samples count.
+	for range latencySamples {
+		r.add(time.Second)
+	}
+	assert.Equal(t, latencySamples, r.count())
+	p50, _ = r.percentiles()
+	assert.Equal(t, time.Second, p50)
+}
+
+func TestEvalQueue_JobCarriesQueuedAt(t *testing.T) {
+	clk := useFakeClock(t, time.Unix(1000, 0))
+	q := NewEvalQueue()
+	q.Push("a.txt")
+	clk.Advance(time.Second)
+	job, ok := q.PopJob(make(chan struct{}))
+	require.True(t, ok)
+	assert.Equal(t, time.Unix(1000, 0).UnixNano(), job.QueuedAt)
+}
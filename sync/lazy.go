@@ -0,0 +1,249 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// lazyIndex tracks, for Config.LazyRegistration, the directories whose
+// direct children have been registered during this run. Directory 0 is
+// the root.
+type lazyIndex struct {
+	mu     gosync.Mutex
+	listed map[uint64]bool
+}
+
+func newLazyIndex() *lazyIndex {
+	return &lazyIndex{listed: make(map[uint64]bool)}
+}
+
+func (x *lazyIndex) isListed(dirIno uint64) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.listed[dirIno]
+}
+
+func (x *lazyIndex) markListed(dirInos ...uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, ino := range dirInos {
+		x.listed[ino] = true
+	}
+}
+
+// lazyScan lists only the Archives directories a lazy seed must register:
+// the root and every directory on the way to a Spaces entry. Returns the
+// listed children keyed by relative path, like ScanDir, and the listed
+// directories ("" for the root).
+func lazyScan(archivesRoot string, spacesFiles map[string]FileStat) (map[string]FileStat, []string, error) {
+	dirs := map[string]bool{"": true}
+	for relPath, stat := range spacesFiles {
+		if stat.IsDir {
+			dirs[relPath] = true
+		}
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	files := make(map[string]FileStat)
+	var listed []string
+	for dir := range dirs {
+		children, err := listDir(archivesRoot, dir)
+		if os.IsNotExist(err) {
+			continue // Spaces-only directory, copied by seed phase 3
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("list %s: %w", dir, err)
+		}
+		for relPath, stat := range children {
+			files[relPath] = stat
+		}
+		listed = append(listed, dir)
+	}
+	return files, listed, nil
+}
+
+// ensureListed registers the direct children of directory dirIno from
+// the Archives disk, once per run. A no-op unless lazy registration is on.
+func (d *Daemon) ensureListed(dirIno uint64) error {
+	if d.lazy == nil || d.lazy.isListed(dirIno) {
+		return nil
+	}
+	relDir, ok, err := d.lazyDirPath(dirIno)
+	if err != nil || !ok {
+		return err
+	}
+	files, err := listDir(d.archivesRoot, relDir)
+	if os.IsNotExist(err) {
+		return nil // pipeline cleans up the entry
+	}
+	if err != nil {
+		return fmt.Errorf("list %s: %w", relDir, err)
+	}
+	if err := d.registerScanned(relDir, dirIno, files); err != nil {
+		return err
+	}
+	d.lazy.markListed(dirIno)
+	return nil
+}
+
+// ensureSubtree registers the whole Archives subtree of directory dirIno,
+// so selecting it reaches every descendant. A no-op unless lazy
+// registration is on.
+func (d *Daemon) ensureSubtree(dirIno uint64) error {
+	if d.lazy == nil {
+		return nil
+	}
+	relDir, ok, err := d.lazyDirPath(dirIno)
+	if err != nil || !ok {
+		return err
+	}
+	scanned, err := ScanDir(filepath.Join(d.archivesRoot, relDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("scan %s: %w", relDir, err)
+	}
+	files := make(map[string]FileStat, len(scanned))
+	dirInos := []uint64{dirIno}
+	for relPath, stat := range scanned {
+		files[filepath.Join(relDir, relPath)] = stat
+		if stat.IsDir {
+			dirInos = append(dirInos, stat.Inode)
+		}
+	}
+	if err := d.registerScanned(relDir, dirIno, files); err != nil {
+		return err
+	}
+	d.lazy.markListed(dirInos...)
+	return nil
+}
+
+// lazyDirPath returns the relative path of directory dirIno ("" for the
+// root), or false if it isn't a registered directory.
+func (d *Daemon) lazyDirPath(dirIno uint64) (string, bool, error) {
+	if dirIno == 0 {
+		return "", true, nil
+	}
+	entry, err := d.store.GetEntry(dirIno)
+	if err != nil {
+		return "", false, err
+	}
+	if entry == nil || entry.Type != "dir" {
+		return "", false, nil
+	}
+	return d.store.RelPath(entry), true, nil
+}
+
+// registerScanned registers scanned Archives entries below relDir, whose
+// inode is dirIno. Entries are selected when they exist in Spaces, an
+// auto-select rule matches or, without one, the selection filter of a
+// folder above them does or a folder above is an auto-select one, as P1
+// would; entries selected so are queued.
+func (d *Daemon) registerScanned(relDir string, dirIno uint64, files map[string]FileStat) error {
+	var dirs, plain []pathEntry
+	for relPath, stat := range files {
+		if stat.IsDir {
+			dirs = append(dirs, pathEntry{relPath, stat})
+		} else {
+			plain = append(plain, pathEntry{relPath, stat})
+		}
+	}
+	sortByDepth(dirs)
+
+	rules := activeConfig.Load().Rules
+	// Directories new in this batch carry no filter or flag of their own.
+	filter, err := d.store.SelectionFilterFor(dirIno)
+	if err != nil {
+		return err
+	}
+	auto, err := d.store.AutoSelectFor(dirIno)
+	if err != nil {
+		return err
+	}
+	var ruleSelected []string
+	entries := make([]Entry, 0, len(files))
+	for _, pe := range append(dirs, plain...) {
+		parentIno := dirIno
+		if parent := filepath.Dir(pe.relPath); parent != relDir && parent != "." {
+			parentIno = files[parent].Inode
+		}
+		e := Entry{
+			Inode:     pe.stat.Inode,
+			ParentIno: parentIno,
+			Name:      pe.stat.Name,
+			Type:      "dir",
+			Mtime:     pe.stat.Mtime,
+		}
+		spacesMtime, _, _, _ := statFile(filepath.Join(d.spacesRoot, pe.relPath))
+		e.Selected = spacesMtime != nil
+		if pe.stat.IsDir {
+			if !e.Selected && auto {
+				e.Selected = true
+				ruleSelected = append(ruleSelected, pe.relPath)
+			}
+		} else {
+			size := pe.stat.Size
+			e.Size = &size
+			e.Type = ClassifyFile(filepath.Join(d.archivesRoot, pe.relPath), pe.stat.Name)
+			if !e.Selected {
+				switch evalRules(rules, pe.relPath, size, pe.stat.Mtime) {
+				case RuleSelect:
+					e.Selected = true
+				case "":
+					e.Selected = auto || (filter != nil && filter.matches(pe.stat.Name, e.Type, size))
+				}
+				if e.Selected {
+					ruleSelected = append(ruleSelected, pe.relPath)
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := d.store.UpsertEntriesBatch(entries); err != nil {
+		return fmt.Errorf("register %s: %w", relDir, err)
+	}
+	d.queue.PushMany(ruleSelected)
+	sub("lazy").Debug("registered directory", "path", relDir, "entries", len(entries), "ruleSelected", len(ruleSelected))
+	return nil
+}
+
+// runLazyCompletion registers the directories nobody has browsed yet,
+// breadth-first from the root, until the whole tree is registered or ctx
+// is cancelled.
+func (d *Daemon) runLazyCompletion(ctx context.Context) {
+	l := sub("lazy")
+	l.Info("background registration started")
+	start := time.Now()
+	pending := []uint64{0}
+	var dirs int
+	for len(pending) > 0 {
+		if ctx.Err() != nil {
+			return
+		}
+		dirIno := pending[0]
+		pending = pending[1:]
+		if err := d.ensureListed(dirIno); err != nil {
+			l.Warn("background registration failed", "dirIno", dirIno, "err", err)
+			continue
+		}
+		dirs++
+		children, err := d.store.ListChildren(dirIno)
+		if err != nil {
+			l.Warn("background registration list failed", "dirIno", dirIno, "err", err)
+			continue
+		}
+		for _, child := range children {
+			if child.Type == "dir" {
+				pending = append(pending, child.Inode)
+			}
+		}
+	}
+	l.Info("background registration complete", "dirs", dirs, "durationMs", time.Since(start).Milliseconds())
+}
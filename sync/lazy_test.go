@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupLazyEnv lays out Archives big/{x/deep.txt, y.txt} and docs/a.txt,
+// with docs/a.txt also in Spaces, and runs a lazy seed.
+func setupLazyEnv(t *testing.T) (*Handlers, *Store, string) {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.LazyRegistration = true })
+
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	require.NotNil(t, h.daemon.lazy)
+	for _, p := range []string{"big/x/deep.txt", "big/y.txt", "docs/a.txt"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, filepath.Dir(p)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, p), []byte(p), 0644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(spacesRoot, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "docs", "a.txt"), []byte("docs/a.txt"), 0644))
+
+	changed, err := seed(store, archivesRoot, spacesRoot, nil, h.daemon.lazy)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	return h, store, archivesRoot
+}
+
+func registered(t *testing.T, store *Store, archivesRoot, relPath string) *Entry {
+	t.Helper()
+	e, _, err := lookupDB(store, archivesRoot, relPath)
+	require.NoError(t, err)
+	return e
+}
+
+func TestLazySeed_RegistersOnlySpacesAncestry(t *testing.T) {
+	_, store, archivesRoot := setupLazyEnv(t)
+
+	assert.NotNil(t, registered(t, store, archivesRoot, "big"))
+	a := registered(t, store, archivesRoot, "docs/a.txt")
+	require.NotNil(t, a)
+	assert.True(t, a.Selected)
+	assert.Nil(t, registered(t, store, archivesRoot, "big/y.txt"), "unbrowsed directory stays unregistered")
+}
+
+func TestLazy_BrowseRegistersChildren(t *testing.T) {
+	h, store, archivesRoot := setupLazyEnv(t)
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?path=/big/x", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []SyncEntryResponse `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "deep.txt", resp.Items[0].Name)
+
+	y := registered(t, store, archivesRoot, "big/y.txt")
+	require.NotNil(t, y, "ancestors are listed on the way down")
+	assert.False(t, y.Selected)
+}
+
+func TestLazy_SelectRegistersSubtree(t *testing.T) {
+	h, store, archivesRoot := setupLazyEnv(t)
+	big := registered(t, store, archivesRoot, "big")
+
+	body, _ := json.Marshal(SelectRequest{Inodes: []uint64{big.Inode}})
+	w := httptest.NewRecorder()
+	h.HandleSelect(w, httptest.NewRequest("POST", "/api/sync/select", strings.NewReader(string(body))))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	deep := registered(t, store, archivesRoot, "big/x/deep.txt")
+	require.NotNil(t, deep)
+	assert.True(t, deep.Selected)
+}
+
+func TestLazy_BackgroundCompletion(t *testing.T) {
+	h, store, archivesRoot := setupLazyEnv(t)
+
+	h.daemon.runLazyCompletion(context.Background())
+	for _, p := range []string{"big/x", "big/x/deep.txt", "big/y.txt"} {
+		assert.NotNil(t, registered(t, store, archivesRoot, p), p)
+	}
+}
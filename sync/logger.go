@@ -5,28 +5,110 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"sort"
+	gosync "sync"
 )
 
 // logger is the package-level structured logger for all sync operations.
 // Defaults to a no-op (discard) handler until InitLogger is called.
 var logger *slog.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
 
+// levels holds the live global and per-component log levels.
+var levels = &levelControl{global: slog.LevelInfo, comps: make(map[string]slog.Level)}
+
 // InitLogger configures the sync package logger.
 // Always enables console output: INFO→stdout, WARN/ERROR→stderr.
 // If debugWriter is non-nil, also writes DEBUG+ level logs to it.
+// ERROR (and optionally WARN) records are also kept for RecentErrors.
+// Levels can be changed afterwards with SetLogLevels; without a debug
+// writer the console follows the live level, with one the console stays
+// at INFO and the writer receives everything the live level allows.
 func InitLogger(debugWriter io.Writer) {
+	consoleFloor, initial := slog.LevelDebug, slog.LevelInfo
+	if debugWriter != nil {
+		consoleFloor, initial = slog.LevelInfo, slog.LevelDebug
+	}
+	levels.reset(initial)
+
 	console := &consoleHandler{
-		stdout: slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		floor:  consoleFloor,
+		stdout: slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: consoleFloor}),
 		stderr: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}),
 	}
 
+	ring := &ringHandler{ring: recentErrors}
+
 	if debugWriter == nil {
-		logger = slog.New(console)
+		logger = slog.New(&levelHandler{next: &multiHandler{handlers: []slog.Handler{console, ring}}})
 		return
 	}
 
 	file := slog.NewTextHandler(debugWriter, &slog.HandlerOptions{Level: slog.LevelDebug})
-	logger = slog.New(&multiHandler{handlers: []slog.Handler{console, file}})
+	logger = slog.New(&levelHandler{next: &multiHandler{handlers: []slog.Handler{console, file, ring}}})
+}
+
+// LogLevels is the snapshot of live log levels reported by GetLogLevels.
+type LogLevels struct {
+	Global     string            `json:"global"`
+	Components map[string]string `json:"components"`
+}
+
+// GetLogLevels returns the current global and per-component levels.
+func GetLogLevels() LogLevels {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+	out := LogLevels{Global: levels.global.String(), Components: make(map[string]string, len(levels.comps))}
+	for comp, lvl := range levels.comps {
+		out.Components[comp] = lvl.String()
+	}
+	return out
+}
+
+// SetLogLevels applies level changes at runtime. An empty global keeps the
+// current global level; an empty component level removes that override.
+func SetLogLevels(req LogLevels) error {
+	var global *slog.Level
+	if req.Global != "" {
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(req.Global)); err != nil {
+			return err
+		}
+		global = &lvl
+	}
+	comps := make(map[string]*slog.Level, len(req.Components))
+	for comp, s := range req.Components {
+		if s == "" {
+			comps[comp] = nil
+			continue
+		}
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(s)); err != nil {
+			return err
+		}
+		comps[comp] = &lvl
+	}
+
+	levels.mu.Lock()
+	if global != nil {
+		levels.global = *global
+	}
+	for comp, lvl := range comps {
+		if lvl == nil {
+			delete(levels.comps, comp)
+		} else {
+			levels.comps[comp] = *lvl
+		}
+	}
+	levels.mu.Unlock()
+
+	cur := GetLogLevels()
+	names := make([]string, 0, len(cur.Components))
+	for comp := range cur.Components {
+		names = append(names, comp)
+	}
+	sort.Strings(names)
+	sub("logger").Info("log levels changed", "global", cur.Global, "components", names)
+	return nil
 }
 
 // sub returns a child logger tagged with the given component name.
@@ -34,21 +116,87 @@ func sub(component string) *slog.Logger {
 	return logger.With("comp", component)
 }
 
-// logEnabled reports whether the given log level is enabled.
+// logEnabled reports whether the given log level is enabled for any component.
 // Use this to guard expensive DEBUG logging in hot paths.
 func logEnabled(level slog.Level) bool {
 	return logger.Enabled(context.Background(), level)
 }
 
+// --- levelControl: live global + per-component levels ---
+
+type levelControl struct {
+	mu     gosync.RWMutex
+	global slog.Level
+	comps  map[string]slog.Level
+}
+
+func (c *levelControl) reset(global slog.Level) {
+	c.mu.Lock()
+	c.global = global
+	c.comps = make(map[string]slog.Level)
+	c.mu.Unlock()
+}
+
+// enabled reports whether level passes for comp ("" = no component).
+// For "" the lowest configured level is used so logEnabled guards pass
+// whenever any component would log.
+func (c *levelControl) enabled(comp string, level slog.Level) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if comp == "" {
+		min := c.global
+		for _, lvl := range c.comps {
+			if lvl < min {
+				min = lvl
+			}
+		}
+		return level >= min
+	}
+	if lvl, ok := c.comps[comp]; ok {
+		return level >= lvl
+	}
+	return level >= c.global
+}
+
+// --- levelHandler: gates records by the live level of their "comp" attr ---
+
+type levelHandler struct {
+	next slog.Handler
+	comp string
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return levels.enabled(h.comp, level) && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	comp := h.comp
+	for _, a := range attrs {
+		if a.Key == "comp" {
+			comp = a.Value.String()
+		}
+	}
+	return &levelHandler{next: h.next.WithAttrs(attrs), comp: comp}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), comp: h.comp}
+}
+
 // --- consoleHandler: routes INFO→stdout, WARN+→stderr ---
 
 type consoleHandler struct {
+	floor  slog.Level
 	stdout slog.Handler
 	stderr slog.Handler
 }
 
 func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= slog.LevelInfo
+	return level >= h.floor
 }
 
 func (h *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
@@ -60,6 +208,7 @@ func (h *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
 
 func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &consoleHandler{
+		floor:  h.floor,
 		stdout: h.stdout.WithAttrs(attrs),
 		stderr: h.stderr.WithAttrs(attrs),
 	}
@@ -67,6 +216,7 @@ func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 
 func (h *consoleHandler) WithGroup(name string) slog.Handler {
 	return &consoleHandler{
+		floor:  h.floor,
 		stdout: h.stdout.WithGroup(name),
 		stderr: h.stderr.WithGroup(name),
 	}
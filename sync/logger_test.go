@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogger installs a buffer-backed logger gated by the live levels.
+func captureLogger(t *testing.T, global slog.Level) *bytes.Buffer {
+	t.Helper()
+	prev := logger
+	var buf bytes.Buffer
+	logger = slog.New(&levelHandler{next: slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})})
+	levels.reset(global)
+	t.Cleanup(func() {
+		logger = prev
+		levels.reset(slog.LevelInfo)
+	})
+	return &buf
+}
+
+func TestLogLevels_PerComponent(t *testing.T) {
+	buf := captureLogger(t, slog.LevelInfo)
+
+	sub("watcher").Debug("hidden")
+	assert.False(t, logEnabled(slog.LevelDebug))
+
+	require.NoError(t, SetLogLevels(LogLevels{Components: map[string]string{"watcher": "debug"}}))
+	assert.True(t, logEnabled(slog.LevelDebug), "guards pass when any component is at debug")
+
+	sub("watcher").Debug("visible")
+	sub("pipeline").Debug("still hidden")
+
+	out := buf.String()
+	assert.NotContains(t, out, "msg=hidden")
+	assert.Contains(t, out, "msg=visible")
+	assert.NotContains(t, out, "still hidden")
+
+	got := GetLogLevels()
+	assert.Equal(t, "INFO", got.Global)
+	assert.Equal(t, "DEBUG", got.Components["watcher"])
+}
+
+func TestLogLevels_GlobalAndClear(t *testing.T) {
+	buf := captureLogger(t, slog.LevelInfo)
+
+	require.NoError(t, SetLogLevels(LogLevels{Global: "warn", Components: map[string]string{"db": "debug"}}))
+	sub("store").Info("dropped")
+	sub("db").Debug("kept")
+
+	require.NoError(t, SetLogLevels(LogLevels{Components: map[string]string{"db": ""}}))
+	sub("db").Info("dropped again")
+
+	out := buf.String()
+	assert.False(t, strings.Contains(out, "msg=dropped"))
+	assert.Contains(t, out, "msg=kept")
+	assert.Empty(t, GetLogLevels().Components)
+
+	assert.Error(t, SetLogLevels(LogLevels{Global: "loud"}))
+}
@@ -0,0 +1,278 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memFS is an in-memory fileSystem for tests: mounted over a set of
+// roots, the scanner and fileops work on it without touching the disk,
+// with mtimes from the package clock and inodes handed out in order.
+type memFS struct {
+	mu      gosync.Mutex
+	files   map[string]*memFile
+	lastIno uint64
+}
+
+type memFile struct {
+	ino   uint64
+	dir   bool
+	data  []byte
+	mtime time.Time
+}
+
+// mountMemFS mounts a new memFS over roots for the rest of the test.
+func mountMemFS(t *testing.T, roots ...string) *memFS {
+	t.Helper()
+	fs := &memFS{files: make(map[string]*memFile)}
+	for _, r := range roots {
+		require.NoError(t, fs.MkdirAll(r, 0755))
+	}
+	t.Cleanup(mountFS(fs, roots...))
+	return fs
+}
+
+func (fs *memFS) info(name string, f *memFile) os.FileInfo {
+	mode := os.FileMode(0644)
+	if f.dir {
+		mode = os.ModeDir | 0755
+	}
+	size := int64(len(f.data))
+	return &remoteInfo{name: filepath.Base(name), size: size, mode: mode, mtime: f.mtime, sys: &syscall.Stat_t{Ino: f.ino, Size: size}}
+}
+
+func (fs *memFS) get(name string) (*memFile, error) {
+	f, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// create adds a file at name, whose parent must be a dir.
+func (fs *memFS) create(name string, dir bool) (*memFile, error) {
+	name = filepath.Clean(name)
+	if p, err := fs.get(filepath.Dir(name)); err != nil || !p.dir {
+		return nil, &os.PathError{Op: "create", Path: name, Err: syscall.ENOENT}
+	}
+	fs.lastIno++
+	f := &memFile{ino: fs.lastIno, dir: dir, mtime: nowFunc()}
+	fs.files[name] = f
+	return f, nil
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.info(name, f), nil
+}
+
+func (fs *memFS) Lstat(name string) (os.FileInfo, error) { return fs.Stat(name) }
+
+func (fs *memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = filepath.Clean(name)
+	if f, err := fs.get(name); err != nil {
+		return nil, err
+	} else if !f.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	var infos []os.FileInfo
+	for p, f := range fs.files {
+		if p != name && filepath.Dir(p) == name {
+			infos = append(infos, fs.info(p, f))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs *memFS) Open(name string) (io.ReadCloser, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(f.data))), nil
+}
+
+func (fs *memFS) Append(name string) (io.WriteCloser, int64, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, err := fs.get(name)
+	if err != nil {
+		if f, err = fs.create(name, false); err != nil {
+			return nil, 0, err
+		}
+	}
+	return &memWriter{fs: fs, f: f}, int64(len(f.data)), nil
+}
+
+func (fs *memFS) Rename(from, to string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if _, err := fs.get(from); err != nil {
+		return err
+	}
+	if p, err := fs.get(filepath.Dir(to)); err != nil || !p.dir {
+		return &os.PathError{Op: "rename", Path: to, Err: syscall.ENOENT}
+	}
+	for p, f := range fs.files {
+		if p == from || strings.HasPrefix(p, from+string(filepath.Separator)) {
+			delete(fs.files, p)
+			fs.files[to+p[len(from):]] = f
+		}
+	}
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, err := fs.get(name); err != nil {
+		return err
+	}
+	for p := range fs.files {
+		if filepath.Dir(p) == name && p != name {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFS) MkdirAll(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var missing []string
+	for p := filepath.Clean(name); ; p = filepath.Dir(p) {
+		if f, err := fs.get(p); err == nil {
+			if !f.dir {
+				return &os.PathError{Op: "mkdir", Path: p, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		if filepath.Dir(p) == p {
+			fs.files[p] = &memFile{dir: true, mtime: nowFunc()}
+			break
+		}
+		missing = append(missing, p)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if _, err := fs.create(missing[i], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, err := fs.get(name)
+	if err != nil {
+		return err
+	}
+	f.mtime = mtime
+	return nil
+}
+
+// write puts content at name, creating its parents, as a user would.
+func (fs *memFS) write(t *testing.T, name string, content []byte) {
+	t.Helper()
+	require.NoError(t, fs.MkdirAll(filepath.Dir(name), 0755))
+	w, _, err := fs.Append(name)
+	require.NoError(t, err)
+	fs.mu.Lock()
+	w.(*memWriter).f.data = nil
+	fs.mu.Unlock()
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+// memWriter appends to a memFile, moving its mtime to the clock's now.
+type memWriter struct {
+	fs *memFS
+	f  *memFile
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.f.data = append(w.f.data, p...)
+	w.f.mtime = nowFunc()
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error { return nil }
+
+func TestMemFS_ScanDir(t *testing.T) {
+	clk := useFakeClock(t, time.Unix(1_700_000_000, 0))
+	fs := mountMemFS(t, "/mem/Archives")
+	fs.write(t, "/mem/Archives/a.txt", []byte("a"))
+	clk.Advance(time.Second)
+	fs.write(t, "/mem/Archives/d/b.txt", []byte("bb"))
+	fs.write(t, "/mem/Archives/.hidden/c.txt", []byte("c"))
+	fs.write(t, "/mem/Archives/d/b.txt.sync-tmp", []byte("partial"))
+
+	stats, err := ScanDir("/mem/Archives")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.txt", "d", "d/b.txt"}, mapKeys(stats))
+	assert.Equal(t, int64(2), stats["d/b.txt"].Size)
+	assert.Equal(t, time.Unix(1_700_000_001, 0).UnixNano(), stats["d/b.txt"].Mtime)
+	assert.True(t, stats["d"].IsDir)
+	assert.NotEqual(t, stats["a.txt"].Inode, stats["d/b.txt"].Inode)
+}
+
+func TestMemFS_CopyAndTrash(t *testing.T) {
+	restoreConfig(t)
+	clk := useFakeClock(t, time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local))
+	fs := mountMemFS(t, "/mem/Archives", "/mem/Spaces", "/mem/.trash")
+	fs.write(t, "/mem/Archives/doc.txt", []byte("content"))
+	mtime := clk.Now()
+	clk.Advance(time.Hour)
+
+	require.NoError(t, SafeCopy(context.Background(), "/mem/Archives/doc.txt", "/mem/Spaces/docs/doc.txt", nil))
+	info, err := fs.Stat("/mem/Spaces/docs/doc.txt")
+	require.NoError(t, err)
+	assert.Equal(t, mtime, info.ModTime(), "copy keeps the source mtime")
+	_, err = fs.Stat("/mem/Spaces/docs/doc.txt.sync-tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	trashPath, err := SoftDelete("/mem/Spaces/docs/doc.txt", "/mem/.trash")
+	require.NoError(t, err)
+	assert.Equal(t, "/mem/.trash/2026-03-10/doc.txt", trashPath)
+	rc, err := fs.Open(trashPath)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(rc)
+	assert.Equal(t, "content", string(got))
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,280 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register decoder for DecodeConfig
+	_ "image/jpeg" // register decoder for DecodeConfig
+	_ "image/png"  // register decoder for DecodeConfig
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dsoprea/go-exif/v3"
+	_ "golang.org/x/image/bmp"  // register decoder for DecodeConfig
+	_ "golang.org/x/image/tiff" // register decoder for DecodeConfig
+	_ "golang.org/x/image/webp" // register decoder for DecodeConfig
+)
+
+const (
+	// metadataInterval is how often the metadata worker looks for new media entries.
+	metadataInterval = 30 * time.Second
+	// metadataBatch is the number of entries probed per worker pass.
+	metadataBatch = 100
+	// exifScanLimit bounds how much of an image is read when searching for EXIF.
+	exifScanLimit = 256 * 1024
+)
+
+// probeMetadata extracts media metadata from the file at path using pure-Go
+// probes chosen by entry type. Unsupported formats yield empty metadata, not an error.
+func probeMetadata(path, entryType string) (Metadata, error) {
+	m := Metadata{ExtractedAt: nowNano()}
+	f, err := os.Open(path)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	switch entryType {
+	case "image":
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			m.Width, m.Height = &cfg.Width, &cfg.Height
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return m, err
+		}
+		if t, ok := exifTakenAt(f); ok {
+			m.TakenAt = &t
+		}
+	case "video", "audio":
+		var d time.Duration
+		var ok bool
+		if ext == ".wav" {
+			d, ok = wavDuration(f)
+		} else {
+			d, ok = mp4Duration(f)
+		}
+		if ok {
+			ms := d.Milliseconds()
+			m.DurationMs = &ms
+		}
+	}
+	return m, nil
+}
+
+// exifTakenAt returns EXIF DateTimeOriginal (or DateTime) in nanoseconds.
+// EXIF dates carry no zone and are interpreted as local time.
+func exifTakenAt(r io.Reader) (int64, bool) {
+	head, err := io.ReadAll(io.LimitReader(r, exifScanLimit))
+	if err != nil {
+		return 0, false
+	}
+	raw, err := exif.SearchAndExtractExif(head)
+	if err != nil {
+		return 0, false
+	}
+	tags, _, err := exif.GetFlatExifData(raw, nil)
+	if err != nil && len(tags) == 0 {
+		return 0, false
+	}
+	var fallback string
+	for _, tag := range tags {
+		switch tag.TagName {
+		case "DateTimeOriginal":
+			if t, err := time.ParseInLocation("2006:01:02 15:04:05", tag.FormattedFirst, time.Local); err == nil {
+				return t.UnixNano(), true
+			}
+		case "DateTime":
+			fallback = tag.FormattedFirst
+		}
+	}
+	if t, err := time.ParseInLocation("2006:01:02 15:04:05", fallback, time.Local); err == nil {
+		return t.UnixNano(), true
+	}
+	return 0, false
+}
+
+// mp4Duration reads the duration from the mvhd box of an ISO BMFF file
+// (mp4, mov, m4a, m4v). Large boxes such as mdat are skipped, not read.
+func mp4Duration(r io.ReadSeeker) (time.Duration, bool) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	moov, moovEnd, ok := findBox(r, 0, end, "moov")
+	if !ok {
+		return 0, false
+	}
+	mvhd, _, ok := findBox(r, moov, moovEnd, "mvhd")
+	if !ok {
+		return 0, false
+	}
+	if _, err := r.Seek(mvhd, io.SeekStart); err != nil {
+		return 0, false
+	}
+	var buf [32]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, false
+	}
+	var timescale uint32
+	var duration uint64
+	if buf[0] == 1 {
+		timescale = binary.BigEndian.Uint32(buf[20:24])
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(buf[12:16])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), true
+}
+
+// findBox scans sibling boxes in [start, end) for typ and returns the
+// offsets of its payload.
+func findBox(r io.ReadSeeker, start, end int64, typ string) (payload, payloadEnd int64, ok bool) {
+	for off := start; off+8 <= end; {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return 0, 0, false
+		}
+		var hdr [16]byte
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			return 0, 0, false
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		headerLen := int64(8)
+		switch size {
+		case 0: // box extends to end
+			size = end - off
+		case 1: // 64-bit size follows
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return 0, 0, false
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || off+size > end {
+			return 0, 0, false
+		}
+		if string(hdr[4:8]) == typ {
+			return off + headerLen, off + size, true
+		}
+		off += size
+	}
+	return 0, 0, false
+}
+
+// wavDuration reads the duration of a RIFF/WAVE file from its fmt and data chunks.
+func wavDuration(r io.Reader) (time.Duration, bool) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, false
+	}
+	if !bytes.Equal(hdr[0:4], []byte("RIFF")) || !bytes.Equal(hdr[8:12], []byte("WAVE")) {
+		return 0, false
+	}
+	var byteRate uint32
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, false
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 12 || size > 1024 {
+				return 0, false
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return 0, false
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, false
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), true
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return 0, false
+			}
+		}
+		if size%2 == 1 { // chunks are word-aligned
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return 0, false
+			}
+		}
+	}
+}
+
+// extractMetadataBatch probes up to metadataBatch pending media entries.
+// Returns the number of entries processed.
+func extractMetadataBatch(ctx context.Context, store *Store, archivesRoot string) (int, error) {
+	l := sub("metadata")
+	pending, err := store.ListMetadataPending(metadataBatch)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pending {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		entry := &pending[i]
+		relPath := store.RelPath(entry)
+		m, err := probeMetadata(filepath.Join(archivesRoot, relPath), entry.Type)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			l.Warn("probe failed", "path", relPath, "err", err)
+		}
+		// Record even empty results so unsupported files aren't re-probed
+		// until their mtime changes.
+		m.EntryIno = entry.Inode
+		m.ExtractedAt = max(m.ExtractedAt, entry.Mtime)
+		if err := store.UpsertMetadata(m); err != nil {
+			return i, fmt.Errorf("store metadata for %s: %w", relPath, err)
+		}
+		if logEnabled(slog.LevelDebug) {
+			l.Debug("metadata extracted", "path", relPath, "width", m.Width, "height", m.Height, "durationMs", m.DurationMs)
+		}
+	}
+	return len(pending), nil
+}
+
+// runMetadataWorker extracts metadata for new or changed media entries
+// while Config.Metadata is enabled, until ctx is cancelled. A full batch
+// is followed immediately by the next.
+func (d *Daemon) runMetadataWorker(ctx context.Context) {
+	l := sub("metadata")
+	l.Info("metadata worker started")
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if !currentConfig().Metadata || power.deferWork() {
+			timer.Reset(power.stretch(metadataInterval))
+			continue
+		}
+		n, err := extractMetadataBatch(ctx, d.store, d.archivesRoot)
+		if err != nil && ctx.Err() == nil {
+			l.Error("metadata batch failed", "err", err)
+		}
+		if n == metadataBatch {
+			timer.Reset(0)
+		} else {
+			timer.Reset(power.stretch(metadataInterval))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+// testWAV builds a PCM WAV header for the given byte rate and data length.
+func testWAV(byteRate, dataLen uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataLen)) //nolint:errcheck
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16)) //nolint:errcheck
+	fmtBody := make([]byte, 16)
+	binary.LittleEndian.PutUint32(fmtBody[8:12], byteRate)
+	buf.Write(fmtBody)
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, dataLen) //nolint:errcheck
+	buf.Write(make([]byte, dataLen))
+	return buf.Bytes()
+}
+
+// testMP4 builds ftyp + mdat + moov/mvhd (version 0) boxes.
+func testMP4(timescale, duration uint32) []byte {
+	box := func(typ string, payload []byte) []byte {
+		b := make([]byte, 8, 8+len(payload))
+		binary.BigEndian.PutUint32(b[:4], uint32(8+len(payload)))
+		copy(b[4:8], typ)
+		return append(b, payload...)
+	}
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:20], duration)
+
+	var out []byte
+	out = append(out, box("ftyp", []byte("isom\x00\x00\x02\x00"))...)
+	out = append(out, box("mdat", make([]byte, 1024))...)
+	out = append(out, box("moov", box("mvhd", mvhd))...)
+	return out
+}
+
+func TestProbeMetadata(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, data, 0644))
+		return p
+	}
+
+	m, err := probeMetadata(write("a.png", testPNG(t, 32, 16)), "image")
+	require.NoError(t, err)
+	require.NotNil(t, m.Width)
+	assert.Equal(t, 32, *m.Width)
+	assert.Equal(t, 16, *m.Height)
+	assert.Nil(t, m.TakenAt, "PNG carries no EXIF")
+
+	m, err = probeMetadata(write("a.wav", testWAV(1000, 2500)), "audio")
+	require.NoError(t, err)
+	require.NotNil(t, m.DurationMs)
+	assert.Equal(t, int64(2500), *m.DurationMs)
+
+	m, err = probeMetadata(write("a.mp4", testMP4(600, 6000)), "video")
+	require.NoError(t, err)
+	require.NotNil(t, m.DurationMs)
+	assert.Equal(t, int64(10_000), *m.DurationMs)
+
+	m, err = probeMetadata(write("bad.mp4", []byte("not a video")), "video")
+	require.NoError(t, err)
+	assert.Nil(t, m.DurationMs)
+}
+
+func TestExtractMetadataBatch(t *testing.T) {
+	h, store, archivesRoot, _ := setupHandlersEnv(t)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "pic.png"), testPNG(t, 4, 3), 0644))
+	mtime := time.Now().UnixNano()
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "pic.png", Type: "image", Mtime: mtime}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "doc.txt", Type: "text", Mtime: mtime}))
+
+	n, err := extractMetadataBatch(context.Background(), store, archivesRoot)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "only media entries are probed")
+
+	n, err = extractMetadataBatch(context.Background(), store, archivesRoot)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "up-to-date metadata is not re-probed")
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?metadata=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Items []SyncEntryResponse `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Items, 2)
+	for _, item := range resp.Items {
+		if item.Name == "pic.png" {
+			require.NotNil(t, item.Metadata)
+			assert.Equal(t, 4, *item.Metadata.Width)
+		} else {
+			assert.Nil(t, item.Metadata)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMkdir(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/"}, nil)
+	docsIno, err := h.resolvePathToIno("Docs")
+	require.NoError(t, err)
+
+	w := postJSON(h.HandleMkdir, "/api/sync/mkdir", `{"path":"Docs/New","select":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var e Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, docsIno, e.ParentIno)
+	assert.Equal(t, "dir", e.Type)
+	assert.True(t, e.Selected)
+
+	for _, root := range []string{archivesRoot, spacesRoot} {
+		info, err := os.Stat(filepath.Join(root, "Docs", "New"))
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+	}
+	sv, err := store.GetSpacesView(e.Inode)
+	require.NoError(t, err)
+	assert.NotNil(t, sv)
+
+	// Already-converged: the pipeline finds nothing to do (#31 synced)
+	env := &pipelineEnv{store: store, archivesRoot: archivesRoot, spacesRoot: spacesRoot}
+	env.run(t, "Docs/New")
+	entry, sv, err := lookupDB(store, archivesRoot, "Docs/New")
+	require.NoError(t, err)
+	aMtime, _, _, _ := statFile(filepath.Join(archivesRoot, "Docs", "New"))
+	sMtime, _, _, _ := statFile(filepath.Join(spacesRoot, "Docs", "New"))
+	assert.Equal(t, 31, ComputeState(entry, sv, aMtime, sMtime).Scenario())
+
+	assert.Equal(t, http.StatusConflict, postJSON(h.HandleMkdir, "/api/sync/mkdir", `{"path":"Docs/New"}`).Code)
+	assert.Equal(t, http.StatusNotFound, postJSON(h.HandleMkdir, "/api/sync/mkdir", `{"path":"Nope/New"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postJSON(h.HandleMkdir, "/api/sync/mkdir", `{"path":"/"}`).Code)
+}
@@ -1,25 +1,198 @@
 package sync
 
-import "time"
-
-// nowFunc is the time source, replaceable in tests.
-var nowFunc = time.Now
-
 // Entry represents a file or directory in the Archives catalog.
 // The inode is from the Archives filesystem only.
 type Entry struct {
 	Inode     uint64  `json:"inode"`
 	ParentIno uint64  `json:"parentIno"`
 	Name      string  `json:"name"`
-	Type      string  `json:"type"` // "dir"|"video"|"audio"|"image"|"pdf"|"text"|"blob"
+	Type      string  `json:"type"` // "dir" or one of TypeCategories
 	Size      *int64  `json:"size"` // nil for directories
 	Mtime     int64   `json:"mtime"` // nanoseconds
 	Selected  bool    `json:"selected"`
+	Excluded  bool    `json:"excluded"` // never synced, survives recursive selects
+	Starred   bool    `json:"starred"`  // user favorite, independent of selection
+	CreatedAt int64   `json:"createdAt"` // nanoseconds, when the entry was first registered
+	UpdatedAt int64   `json:"updatedAt"` // nanoseconds, last change to the row
+	DeletedAt int64   `json:"deletedAt,omitempty"` // nanoseconds; set on tombstones of lost entries
 }
 
 // SpacesView tracks the Spaces copy metadata for a given entry.
 type SpacesView struct {
+	EntryIno    uint64 `json:"entryIno"`
+	SyncedMtime int64  `json:"syncedMtime"`           // nanoseconds
+	CheckedAt   int64  `json:"checkedAt"`             // nanoseconds
+	Cipher      string `json:"cipher,omitempty"`      // e.g. "aes-256-gcm:<key id>" for an encrypted copy, "" for plaintext
+	Compression string `json:"compression,omitempty"` // "zstd" for a compressed copy
+}
+
+// AuditRecord is one automatic or user action recorded in the audit log.
+type AuditRecord struct {
+	ID     int64  `json:"id"`
+	Time   int64  `json:"time"` // nanoseconds
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Inode  uint64 `json:"inode,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Metadata holds media properties extracted from an image, video or audio entry.
+// Fields are nil when the probe could not determine them.
+type Metadata struct {
+	EntryIno    uint64 `json:"-"`
+	Width       *int   `json:"width,omitempty"`
+	Height      *int   `json:"height,omitempty"`
+	DurationMs  *int64 `json:"durationMs,omitempty"`
+	TakenAt     *int64 `json:"takenAt,omitempty"` // nanoseconds, from EXIF DateTimeOriginal
+	ExtractedAt int64  `json:"extractedAt"`       // nanoseconds
+
+	Derivative *Derivative `json:"derivative,omitempty"` // the Spaces copy is made from the original by a transcode rule
+}
+
+// Tag is a user-defined label and the number of entries carrying it.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// UsageBucket is the total and synced (present in Spaces) file bytes of one
+// group in a storage breakdown.
+type UsageBucket struct {
+	Key         string `json:"key"`             // type name, or top-level directory name ("" = root files)
+	Inode       uint64 `json:"inode,omitempty"` // top-level directory inode
+	TotalBytes  int64  `json:"totalBytes"`
+	SyncedBytes int64  `json:"syncedBytes"`
+	Files       int    `json:"files"`
+}
+
+// DirSize is the recursive size of a directory's files.
+type DirSize struct {
+	TotalBytes  int64 `json:"totalBytes"`
+	SyncedBytes int64 `json:"syncedBytes"` // files with a Spaces copy
+	Files       int   `json:"files"`
+}
+
+// SelectionOp is a user select or deselect in the undo log, with the
+// entries whose flag it actually changed.
+type SelectionOp struct {
+	ID       int64             `json:"id"`
+	Time     int64             `json:"time"`     // nanoseconds
+	Selected bool              `json:"selected"` // the value the operation set
+	Changes  []SelectionChange `json:"-"`
+}
+
+// SelectionChange is one entry flipped by a SelectionOp. TrashPath is set
+// once P3 moved the entry's Spaces copy to the trash because of it.
+type SelectionChange struct {
+	Inode     uint64
+	TrashPath string
+}
+
+// Intent is a journaled pipeline step: recorded before its disk action,
+// marked done after it, and removed once the DB reflects it.
+type Intent struct {
+	ID     int64
+	Time   int64 // nanoseconds
+	Op     string
+	Path   string // relative path the step acts on
+	Inode  uint64
+	Target string // op-specific, e.g. the conflict name
+	Done   bool   // disk action completed
+}
+
+// Conflict links a conflict copy to the path it came from and the entry
+// that replaced it there. The conflict copy keeps the original inode.
+type Conflict struct {
+	ID           int64  `json:"id"`
+	Time         int64  `json:"time"` // nanoseconds
+	Path         string `json:"path"` // original relative path
+	ConflictIno  uint64 `json:"conflictIno"`
+	ConflictName string `json:"conflictName"`
+	WinnerIno    uint64 `json:"winnerIno,omitempty"` // 0 if not registered yet
+	Resolution   string `json:"resolution,omitempty"`
+	ResolvedAt   int64  `json:"resolvedAt,omitempty"`
+}
+
+// DirFingerprint summarises a directory at seed time: a hash of the
+// directory's mtime and of the name, size and mtime of each direct child,
+// and the number of children, across both roots. Reconcile skips
+// directories whose fingerprint is unchanged since the previous seed.
+type DirFingerprint struct {
+	Hash     int64 // sum of the hashes, as children come in no set order
+	Children int
+}
+
+// Spoke is a remote machine holding its own selections from this
+// Archives hub.
+type Spoke struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	RegisteredAt int64  `json:"registeredAt"` // nanoseconds
+	LastSeen     int64  `json:"lastSeen"`     // nanoseconds
+}
+
+// SpokeView is a spoke's reported copy of an entry, the per-spoke
+// counterpart of SpacesView.
+type SpokeView struct {
+	SpokeID     string `json:"spokeId"`
 	EntryIno    uint64 `json:"entryIno"`
 	SyncedMtime int64  `json:"syncedMtime"` // nanoseconds
 	CheckedAt   int64  `json:"checkedAt"`   // nanoseconds
 }
+
+// BackupState is the state of a backup job or of one of its files.
+type BackupState string
+
+const (
+	BackupPending   BackupState = "pending"
+	BackupRunning   BackupState = "running"
+	BackupDone      BackupState = "done"
+	BackupFailed    BackupState = "failed"
+	BackupCancelled BackupState = "cancelled"
+)
+
+// BackupJob is a one-off export of the selection, or of the files
+// matching Rules, to Target. The files it copies are fixed when it is
+// created; the counts are filled in from them.
+type BackupJob struct {
+	ID          string           `json:"id"`
+	Target      string           `json:"target"`
+	TargetDev   uint64           `json:"-"`               // device of Target when created
+	Rules       []AutoSelectRule `json:"rules,omitempty"` // empty: the selection
+	State       BackupState      `json:"state"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   int64            `json:"createdAt"` // nanoseconds
+	UpdatedAt   int64            `json:"updatedAt"` // nanoseconds
+	Files       int              `json:"files"`
+	Bytes       int64            `json:"bytes"`
+	DoneFiles   int              `json:"doneFiles"`
+	DoneBytes   int64            `json:"doneBytes"`
+	FailedFiles int              `json:"failedFiles"`
+}
+
+// BackupFile is a file a backup job copies, by its path relative to the
+// Archives root and to the target.
+type BackupFile struct {
+	Path  string      `json:"path"`
+	Size  int64       `json:"size"`
+	Mtime int64       `json:"mtime"` // nanoseconds, when planned
+	State BackupState `json:"state"`
+	Error string      `json:"error,omitempty"`
+}
+
+// IODirection is which way a copy between the roots went.
+type IODirection string
+
+const (
+	IOToSpaces   IODirection = "a2s" // Archives → Spaces
+	IOToArchives IODirection = "s2a" // Spaces → Archives
+)
+
+// IOStat counts the copies of one day, top-level directory and direction.
+type IOStat struct {
+	Day       string      `json:"day"`  // YYYY-MM-DD, UTC
+	Root      string      `json:"root"` // top-level directory, "" for files under the root
+	Direction IODirection `json:"direction"`
+	Bytes     int64       `json:"bytes"`
+	Files     int64       `json:"files"`
+}
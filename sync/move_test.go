@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerPaths writes files under both roots as requested and runs the
+// pipeline on each path (parents first) so they are registered.
+func registerPaths(t *testing.T, store *Store, archivesRoot, spacesRoot string, paths []string, inSpaces map[string]bool) {
+	t.Helper()
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	for _, p := range paths {
+		if strings.HasSuffix(p, "/") {
+			require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, p), 0755))
+		} else {
+			a := filepath.Join(archivesRoot, p)
+			require.NoError(t, os.MkdirAll(filepath.Dir(a), 0755))
+			require.NoError(t, os.WriteFile(a, []byte(p), 0644))
+			if inSpaces[p] {
+				s := filepath.Join(spacesRoot, p)
+				require.NoError(t, os.MkdirAll(filepath.Dir(s), 0755))
+				require.NoError(t, os.WriteFile(s, []byte(p), 0644))
+			}
+		}
+	}
+	for _, p := range paths {
+		require.NoError(t, RunPipeline(context.Background(), strings.TrimSuffix(p, "/"), store, archivesRoot, spacesRoot, trash, nil))
+	}
+}
+
+func postJSON(handler http.HandlerFunc, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+func TestHandleMove_RenamesBothRoots(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Other/", "Docs/a.txt"}, map[string]bool{"Docs/a.txt": true})
+	ino, err := h.resolvePathToIno("Docs/a.txt")
+	require.NoError(t, err)
+	svBefore, err := store.GetSpacesView(ino)
+	require.NoError(t, err)
+	require.NotNil(t, svBefore)
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w := postJSON(h.HandleMove, "/api/sync/move", `{"from":"Docs/a.txt","to":"Other/b.txt"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var moved Entry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &moved))
+	assert.Equal(t, ino, moved.Inode)
+	assert.Equal(t, "b.txt", moved.Name)
+
+	for _, root := range []string{archivesRoot, spacesRoot} {
+		_, err := os.Stat(filepath.Join(root, "Other", "b.txt"))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(root, "Docs", "a.txt"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	newIno, err := h.resolvePathToIno("Other/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, ino, newIno, "inode preserved")
+	sv, err := store.GetSpacesView(ino)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Equal(t, svBefore.SyncedMtime, sv.SyncedMtime)
+
+	ev := <-ch
+	assert.Equal(t, EventMoved, ev.Type)
+	assert.Equal(t, "Other/b.txt", ev.Path)
+	assert.Equal(t, "Docs/a.txt", ev.Data["from"])
+
+	// Re-evaluating both paths keeps the moved entry selected
+	env := &pipelineEnv{store: store, archivesRoot: archivesRoot, spacesRoot: spacesRoot}
+	env.run(t, "Docs/a.txt")
+	env.run(t, "Other/b.txt")
+	e, _ := store.GetEntry(ino)
+	require.NotNil(t, e)
+	assert.True(t, e.Selected)
+}
+
+func TestHandleMove_WaitsForWorker(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+
+	// A worker holds the destination.
+	release := h.daemon.acquirePath("Docs/b.txt")
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postJSON(h.HandleMove, "/api/sync/move", `{"from":"Docs/a.txt","to":"Docs/b.txt"}`)
+	}()
+	select {
+	case <-done:
+		t.Fatal("moved while a worker held the destination")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.FileExists(t, filepath.Join(archivesRoot, "Docs", "a.txt"))
+
+	release()
+	w := <-done
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.FileExists(t, filepath.Join(archivesRoot, "Docs", "b.txt"))
+}
+
+func TestHandleMove_Rejects(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/b.txt"}, nil)
+
+	cases := map[string]struct {
+		body string
+		code int
+	}{
+		"missing source": {`{"from":"nope","to":"x"}`, http.StatusNotFound},
+		"missing parent": {`{"from":"Docs/a.txt","to":"Nowhere/a.txt"}`, http.StatusNotFound},
+		"exists":         {`{"from":"Docs/a.txt","to":"Docs/b.txt"}`, http.StatusConflict},
+		"into itself":    {`{"from":"Docs","to":"Docs/sub"}`, http.StatusBadRequest},
+		"root":           {`{"from":"/","to":"x"}`, http.StatusBadRequest},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.code, postJSON(h.HandleMove, "/api/sync/move", tc.body).Code)
+		})
+	}
+}
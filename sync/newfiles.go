@@ -0,0 +1,207 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NewFilePolicy says what becomes of a file created in Spaces, which by
+// default P0 copies into Archives and registers selected.
+type NewFilePolicy string
+
+const (
+	// NewFileArchive copies it into Archives at once.
+	NewFileArchive NewFilePolicy = "archive"
+	// NewFileHold leaves it in Spaces, unconfirmed, until a user confirms
+	// or rejects it.
+	NewFileHold NewFilePolicy = "hold"
+	// NewFileReject moves it to the trash without archiving it.
+	NewFileReject NewFilePolicy = "reject"
+)
+
+// valid reports whether p is a known new-file policy.
+func (p NewFilePolicy) valid() bool {
+	return p == NewFileArchive || p == NewFileHold || p == NewFileReject
+}
+
+// Event types for new Spaces files the policy stops.
+const (
+	EventUnconfirmed = "unconfirmed"
+	EventRejected    = "rejected"
+)
+
+// NewFileRule sets the policy for files created in a Spaces directory and
+// below it. The rule with the deepest directory wins.
+type NewFileRule struct {
+	Path   string        `json:"path" yaml:"path" toml:"path"`       // relative to the root, "" = everywhere
+	Policy NewFilePolicy `json:"policy" yaml:"policy" toml:"policy"` // archive|hold|reject
+}
+
+func (r NewFileRule) validate() error {
+	if !r.Policy.valid() {
+		return fmt.Errorf("policy must be one of archive, hold, reject, got %q", r.Policy)
+	}
+	if filepath.IsAbs(r.Path) || strings.Contains("/"+filepath.ToSlash(r.Path)+"/", "/../") {
+		return fmt.Errorf("path must be relative to the Spaces root, got %q", r.Path)
+	}
+	return nil
+}
+
+// newFilePolicy returns the policy for a new Spaces file at relPath.
+func newFilePolicy(rules []NewFileRule, relPath string) NewFilePolicy {
+	policy, depth := NewFileArchive, -1
+	for _, r := range rules {
+		dir := strings.Trim(filepath.ToSlash(r.Path), "/")
+		d := 0
+		if dir != "" {
+			if !strings.HasPrefix(relPath, dir+"/") {
+				continue
+			}
+			d = strings.Count(dir, "/") + 1
+		}
+		if d > depth {
+			policy, depth = r.Policy, d
+		}
+	}
+	return policy
+}
+
+// Unconfirmed is a new Spaces file held by NewFileHold.
+type Unconfirmed struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"` // nanoseconds
+	Status string `json:"status"`
+	SeenAt int64  `json:"seenAt"` // nanoseconds
+}
+
+// Statuses of an Unconfirmed file.
+const (
+	UnconfirmedWaiting   = "unconfirmed"
+	UnconfirmedConfirmed = "confirmed"
+	UnconfirmedRejected  = "rejected"
+)
+
+// gateNewFile applies the new-file policy to a Spaces file without an
+// entry, before P0 copies it into Archives. It returns true if the file
+// is not to be copied: held waiting for a user, or rejected and moved to
+// the trash under trashRoot.
+func gateNewFile(store *Store, relPath, spacesPath, trashRoot string) (bool, error) {
+	info, err := fsFor(spacesPath).Stat(spacesPath)
+	if err != nil || info.IsDir() {
+		return false, nil
+	}
+	l := sub("P0")
+	u, err := store.GetUnconfirmed(relPath)
+	if err != nil {
+		return false, err
+	}
+	policy := newFilePolicy(activeConfig.Load().NewFiles, relPath)
+	switch {
+	case u != nil && u.Status == UnconfirmedConfirmed:
+		l.Info("new Spaces file confirmed", "path", relPath)
+		return false, nil
+	case u != nil && u.Status == UnconfirmedRejected, u == nil && policy == NewFileReject:
+		item := TrashItem{RelPath: relPath}
+		item.stat(spacesPath)
+		trashPath, err := SoftDeleteAt(spacesPath, trashRoot, relPath)
+		if err != nil {
+			return true, fmt.Errorf("reject new file: %w", err)
+		}
+		item.TrashPath = trashPath
+		if err := store.RecordTrash(item); err != nil {
+			return true, err
+		}
+		if err := store.DeleteUnconfirmed(relPath); err != nil {
+			return true, err
+		}
+		l.Warn("new Spaces file rejected, moved to trash", "path", relPath, "trashPath", trashPath)
+		events.Publish(Event{Type: EventRejected, Path: relPath, Data: map[string]any{"trashPath": trashPath}})
+		return true, nil
+	case u != nil:
+		// Still waiting; keep the listing current with edits.
+		return true, store.PutUnconfirmed(relPath, info.Size(), info.ModTime().UnixNano())
+	case policy == NewFileHold:
+		if err := store.PutUnconfirmed(relPath, info.Size(), info.ModTime().UnixNano()); err != nil {
+			return true, err
+		}
+		l.Info("new Spaces file held unconfirmed", "path", relPath)
+		events.Publish(Event{Type: EventUnconfirmed, Path: relPath})
+		return true, nil
+	}
+	return false, nil
+}
+
+// PutUnconfirmed records a held Spaces file, or updates its size and mtime.
+func (s *Store) PutUnconfirmed(path string, size, mtime int64) error {
+	_, err := s.exec(`
+		INSERT INTO unconfirmed (path, size, mtime, status, seen_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET size = excluded.size, mtime = excluded.mtime
+	`, path, size, mtime, UnconfirmedWaiting, nowNano())
+	if err != nil {
+		return fmt.Errorf("put unconfirmed: %w", err)
+	}
+	return nil
+}
+
+// GetUnconfirmed returns the held file at path, or nil.
+func (s *Store) GetUnconfirmed(path string) (*Unconfirmed, error) {
+	u := &Unconfirmed{Path: path}
+	err := s.rdb.QueryRowContext(s.context(), "SELECT size, mtime, status, seen_at FROM unconfirmed WHERE path = ?", path).
+		Scan(&u.Size, &u.Mtime, &u.Status, &u.SeenAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get unconfirmed: %w", err)
+	}
+	return u, nil
+}
+
+// ListUnconfirmed returns the held files, oldest first.
+func (s *Store) ListUnconfirmed() ([]Unconfirmed, error) {
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT path, size, mtime, status, seen_at FROM unconfirmed ORDER BY seen_at, path")
+	if err != nil {
+		return nil, fmt.Errorf("list unconfirmed: %w", err)
+	}
+	defer rows.Close()
+
+	var items []Unconfirmed
+	for rows.Next() {
+		var u Unconfirmed
+		if err := rows.Scan(&u.Path, &u.Size, &u.Mtime, &u.Status, &u.SeenAt); err != nil {
+			return nil, fmt.Errorf("scan unconfirmed: %w", err)
+		}
+		items = append(items, u)
+	}
+	return items, rows.Err()
+}
+
+// ResolveUnconfirmed sets the status of held files to confirmed or
+// rejected, for the pipeline to act on. It returns the paths it found.
+func (s *Store) ResolveUnconfirmed(paths []string, status string) ([]string, error) {
+	var found []string
+	err := s.WithTx(func(t *TxStore) error {
+		for _, p := range paths {
+			res, err := t.tx.Exec("UPDATE unconfirmed SET status = ? WHERE path = ?", status, p)
+			if err != nil {
+				return fmt.Errorf("resolve unconfirmed: %w", err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				found = append(found, p)
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// DeleteUnconfirmed forgets the held file at path.
+func (s *Store) DeleteUnconfirmed(path string) error {
+	if _, err := s.exec("DELETE FROM unconfirmed WHERE path = ?", path); err != nil {
+		return fmt.Errorf("delete unconfirmed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_NewFileHold(t *testing.T) {
+	withConfig(t, func(c *Config) { c.NewFiles = []NewFileRule{{Path: "inbox", Policy: NewFileHold}} })
+	env := setupPipelineEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(env.archivesRoot, "inbox"), 0755))
+	env.run(t, "inbox")
+	env.writeSpaces(t, "inbox/scan.txt", []byte("dropped in"))
+	env.writeSpaces(t, "notes.txt", []byte("elsewhere"))
+
+	env.run(t, "inbox/scan.txt")
+	env.run(t, "notes.txt")
+	assert.NoFileExists(t, filepath.Join(env.archivesRoot, "inbox", "scan.txt"))
+	assert.FileExists(t, filepath.Join(env.archivesRoot, "notes.txt"), "no rule: archived")
+	items, err := env.store.ListUnconfirmed()
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "inbox/scan.txt", items[0].Path)
+	assert.Equal(t, UnconfirmedWaiting, items[0].Status)
+	assert.EqualValues(t, len("dropped in"), items[0].Size)
+
+	env.run(t, "inbox/scan.txt")
+	assert.NoFileExists(t, filepath.Join(env.archivesRoot, "inbox", "scan.txt"), "held until confirmed")
+
+	found, err := env.store.ResolveUnconfirmed([]string{"inbox/scan.txt", "inbox/other.txt"}, UnconfirmedConfirmed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"inbox/scan.txt"}, found)
+	env.run(t, "inbox/scan.txt")
+	got, err := os.ReadFile(filepath.Join(env.archivesRoot, "inbox", "scan.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "dropped in", string(got))
+	assert.True(t, registered(t, env.store, env.archivesRoot, "inbox/scan.txt").Selected)
+	items, err = env.store.ListUnconfirmed()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestPipeline_NewFileReject(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.NewFiles = []NewFileRule{{Policy: NewFileReject}, {Path: "keep", Policy: NewFileHold}}
+	})
+	env := setupPipelineEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(env.archivesRoot, "keep"), 0755))
+	env.run(t, "keep")
+
+	env.writeSpaces(t, "junk.bin", []byte("unwanted"))
+	env.run(t, "junk.bin")
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "junk.bin"))
+	assert.NoFileExists(t, filepath.Join(env.archivesRoot, "junk.bin"))
+	trashed, err := filepath.Glob(filepath.Join(env.trashRoot, "*"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, trashed, "rejected files go to the trash")
+
+	// The deeper hold rule wins; a held file can be rejected later.
+	env.writeSpaces(t, "keep/a.txt", []byte("a"))
+	env.writeSpaces(t, "keep/b.txt", []byte("b"))
+	env.run(t, "keep/a.txt")
+	env.run(t, "keep/b.txt")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "keep", "a.txt"))
+	_, err = env.store.ResolveUnconfirmed([]string{"keep/a.txt"}, UnconfirmedRejected)
+	require.NoError(t, err)
+	env.run(t, "keep/a.txt")
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "keep", "a.txt"))
+	assert.NoFileExists(t, filepath.Join(env.archivesRoot, "keep", "a.txt"))
+
+	// Deleted from Spaces while waiting, a held file is forgotten.
+	require.NoError(t, os.Remove(filepath.Join(env.spacesRoot, "keep", "b.txt")))
+	env.run(t, "keep/b.txt")
+	items, err := env.store.ListUnconfirmed()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestHandleUnconfirmed(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.PutUnconfirmed("inbox/a.txt", 3, 100))
+
+	w := httptest.NewRecorder()
+	h.HandleListUnconfirmed(w, httptest.NewRequest("GET", "/api/sync/unconfirmed", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Items []Unconfirmed `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, UnconfirmedWaiting, list.Items[0].Status)
+
+	w = httptest.NewRecorder()
+	h.HandleConfirm(w, httptest.NewRequest("POST", "/api/sync/unconfirmed/confirm", bytes.NewReader([]byte(`{}`))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ := json.Marshal(UnconfirmedRequest{Paths: []string{"inbox/a.txt"}})
+	w = httptest.NewRecorder()
+	h.HandleConfirm(w, httptest.NewRequest("POST", "/api/sync/unconfirmed/confirm", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	u, err := store.GetUnconfirmed("inbox/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, UnconfirmedConfirmed, u.Status)
+}
+
+func TestNewFilePolicy(t *testing.T) {
+	rules := []NewFileRule{
+		{Path: "inbox/", Policy: NewFileHold},
+		{Path: "inbox/trusted", Policy: NewFileArchive},
+		{Path: "tmp", Policy: NewFileReject},
+	}
+	assert.Equal(t, NewFileArchive, newFilePolicy(rules, "a.txt"))
+	assert.Equal(t, NewFileHold, newFilePolicy(rules, "inbox/a.txt"))
+	assert.Equal(t, NewFileArchive, newFilePolicy(rules, "inbox/trusted/a.txt"))
+	assert.Equal(t, NewFileArchive, newFilePolicy(rules, "tmpfile"))
+	assert.Equal(t, NewFileReject, newFilePolicy(rules, "tmp/x"))
+
+	cfg := DefaultConfig()
+	cfg.NewFiles = []NewFileRule{{Path: "inbox", Policy: "ask"}}
+	assert.Error(t, cfg.Validate())
+	cfg.NewFiles = []NewFileRule{{Path: "../outside", Policy: NewFileHold}}
+	assert.Error(t, cfg.Validate())
+	cfg.NewFiles = rules
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sync
+
+// openForWrite returns false: open-file detection needs Linux /proc.
+func openForWrite(string) bool {
+	return false
+}
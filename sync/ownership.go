@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// OwnerPolicy says who owns the files SafeCopy and undo restores write
+// into Spaces.
+type OwnerPolicy string
+
+const (
+	// OwnerDaemon leaves them to the user the daemon runs as.
+	OwnerDaemon OwnerPolicy = "daemon"
+	// OwnerPreserve gives them the Archives file's owner. Only a daemon
+	// running as root can, so elsewhere it acts as OwnerDaemon.
+	OwnerPreserve OwnerPolicy = "preserve"
+	// OwnerFixed gives them SpacesOwner.UID and GID.
+	OwnerFixed OwnerPolicy = "fixed"
+	// OwnerMap translates the Archives file's owner through SpacesOwner's
+	// tables; ids missing from them are kept.
+	OwnerMap OwnerPolicy = "map"
+)
+
+// valid reports whether p is a known ownership policy.
+func (p OwnerPolicy) valid() bool {
+	return p == OwnerDaemon || p == OwnerPreserve || p == OwnerFixed || p == OwnerMap
+}
+
+// IDMap maps one Archives uid or gid to the one its Spaces copies get.
+type IDMap struct {
+	From int `json:"from" yaml:"from" toml:"from"`
+	To   int `json:"to" yaml:"to" toml:"to"`
+}
+
+// SpacesOwner sets the ownership policy for Spaces writes. Ownership is
+// only set on local Spaces files owned by a Unix uid and gid.
+type SpacesOwner struct {
+	Policy OwnerPolicy `json:"policy" yaml:"policy" toml:"policy"` // daemon|preserve|fixed|map
+	UID    int         `json:"uid" yaml:"uid" toml:"uid"`          // owner with the fixed policy
+	GID    int         `json:"gid" yaml:"gid" toml:"gid"`          // group with the fixed policy
+	UIDMap []IDMap     `json:"uidMap" yaml:"uidMap" toml:"uidMap"` // owners translated by the map policy
+	GIDMap []IDMap     `json:"gidMap" yaml:"gidMap" toml:"gidMap"` // groups translated by the map policy
+}
+
+func (o SpacesOwner) validate() error {
+	if !o.Policy.valid() {
+		return fmt.Errorf("policy must be one of daemon, preserve, fixed, map, got %q", o.Policy)
+	}
+	if o.UID < 0 || o.GID < 0 {
+		return fmt.Errorf("uid and gid must not be negative, got %d:%d", o.UID, o.GID)
+	}
+	if o.Policy == OwnerMap && len(o.UIDMap) == 0 && len(o.GIDMap) == 0 {
+		return fmt.Errorf("the map policy needs uidMap or gidMap")
+	}
+	for _, m := range append(append([]IDMap(nil), o.UIDMap...), o.GIDMap...) {
+		if m.From < 0 || m.To < 0 {
+			return fmt.Errorf("mapped ids must not be negative, got %d -> %d", m.From, m.To)
+		}
+	}
+	return nil
+}
+
+// owner returns the uid and gid a Spaces copy of a file owned by uid:gid
+// gets, or false to leave the copy as written.
+func (o SpacesOwner) owner(uid, gid int) (int, int, bool) {
+	switch o.Policy {
+	case OwnerPreserve:
+		return uid, gid, os.Geteuid() == 0
+	case OwnerFixed:
+		return o.UID, o.GID, true
+	case OwnerMap:
+		return mapID(o.UIDMap, uid), mapID(o.GIDMap, gid), true
+	}
+	return 0, 0, false
+}
+
+func mapID(table []IDMap, id int) int {
+	for _, m := range table {
+		if m.From == id {
+			return m.To
+		}
+	}
+	return id
+}
+
+// setSpacesOwner gives name, a Spaces file on dstFS, the owner the
+// policy picks for a copy of src. Anything but a local Spaces file, or a
+// src without a Unix owner, is left alone.
+func setSpacesOwner(dstFS fileSystem, name string, src os.FileInfo) error {
+	cfg := activeConfig.Load()
+	if cfg.SpacesOwner.Policy == OwnerDaemon || !underRoot(name, cfg.SpacesRoot) {
+		return nil
+	}
+	if _, local := dstFS.(osFS); !local {
+		return nil
+	}
+	st, ok := src.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	uid, gid, ok := cfg.SpacesOwner.owner(int(st.Uid), int(st.Gid))
+	if !ok {
+		return nil
+	}
+	if err := os.Lchown(name, uid, gid); err != nil {
+		return fmt.Errorf("chown %d:%d: %w", uid, gid, err)
+	}
+	return nil
+}
+
+// setSpacesOwnerTree applies the ownership policy to a tree restored to
+// spacesPath: each file gets the owner picked for its Archives
+// counterpart under archivesPath, or for itself when there is none.
+// Failures are logged and skipped.
+func setSpacesOwnerTree(spacesPath, archivesPath string) {
+	cfg := activeConfig.Load()
+	dstFS := fsFor(spacesPath)
+	if _, local := dstFS.(osFS); !local || cfg.SpacesOwner.Policy == OwnerDaemon || !underRoot(spacesPath, cfg.SpacesRoot) {
+		return
+	}
+	l := sub("fileops")
+	filepath.WalkDir(spacesPath, func(p string, d fs.DirEntry, err error) error { //nolint:errcheck // failures are logged per file
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(spacesPath, p)
+		src, serr := fsFor(archivesPath).Lstat(filepath.Join(archivesPath, rel))
+		if serr != nil {
+			if src, serr = os.Lstat(p); serr != nil {
+				return nil
+			}
+		}
+		if err := setSpacesOwner(dstFS, p, src); err != nil {
+			l.Warn("Spaces ownership not set", "path", p, "err", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ownerOf(t *testing.T, path string) (int, int) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	require.NoError(t, err)
+	st := info.Sys().(*syscall.Stat_t)
+	return int(st.Uid), int(st.Gid)
+}
+
+func TestSpacesOwner_Validate(t *testing.T) {
+	assert.NoError(t, SpacesOwner{Policy: OwnerDaemon}.validate())
+	assert.Error(t, SpacesOwner{Policy: "root"}.validate())
+	assert.Error(t, SpacesOwner{Policy: OwnerFixed, UID: -1}.validate())
+	assert.Error(t, SpacesOwner{Policy: OwnerMap}.validate(), "map needs a table")
+	assert.Error(t, SpacesOwner{Policy: OwnerMap, UIDMap: []IDMap{{From: 1000, To: -2}}}.validate())
+	assert.NoError(t, SpacesOwner{Policy: OwnerMap, GIDMap: []IDMap{{From: 1000, To: 100}}}.validate())
+}
+
+func TestSpacesOwner_Owner(t *testing.T) {
+	o := SpacesOwner{Policy: OwnerMap, UIDMap: []IDMap{{From: 1000, To: 2000}}, GIDMap: []IDMap{{From: 50, To: 60}}}
+	uid, gid, ok := o.owner(1000, 51)
+	assert.True(t, ok)
+	assert.Equal(t, 2000, uid)
+	assert.Equal(t, 51, gid, "unmapped ids are kept")
+
+	_, _, ok = SpacesOwner{Policy: OwnerDaemon}.owner(1000, 50)
+	assert.False(t, ok)
+	uid, gid, ok = SpacesOwner{Policy: OwnerFixed, UID: 7, GID: 8}.owner(1000, 50)
+	assert.True(t, ok)
+	assert.Equal(t, []int{7, 8}, []int{uid, gid})
+}
+
+func TestSafeCopy_SpacesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown to another user needs root")
+	}
+	restoreConfig(t)
+	dir := t.TempDir()
+	archives, spaces := filepath.Join(dir, "Archives"), filepath.Join(dir, "Spaces")
+	src := filepath.Join(archives, "a.txt")
+	require.NoError(t, os.MkdirAll(archives, 0755))
+	require.NoError(t, os.WriteFile(src, []byte("content"), 0644))
+	require.NoError(t, os.Chown(src, 1000, 1000))
+
+	withConfig(t, func(c *Config) { c.SpacesRoot, c.SpacesOwner = spaces, SpacesOwner{Policy: OwnerPreserve} })
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "a.txt"), nil))
+	uid, gid := ownerOf(t, filepath.Join(spaces, "a.txt"))
+	assert.Equal(t, []int{1000, 1000}, []int{uid, gid})
+
+	withConfig(t, func(c *Config) {
+		c.SpacesRoot, c.SpacesOwner = spaces, SpacesOwner{Policy: OwnerFixed, UID: 1234, GID: 5678}
+	})
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "b.txt"), nil))
+	uid, gid = ownerOf(t, filepath.Join(spaces, "b.txt"))
+	assert.Equal(t, []int{1234, 5678}, []int{uid, gid})
+
+	withConfig(t, func(c *Config) {
+		c.SpacesRoot, c.SpacesOwner = spaces, SpacesOwner{Policy: OwnerMap, UIDMap: []IDMap{{From: 1000, To: 2000}}}
+	})
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "c.txt"), nil))
+	uid, gid = ownerOf(t, filepath.Join(spaces, "c.txt"))
+	assert.Equal(t, []int{2000, 1000}, []int{uid, gid})
+
+	// Copies out of Spaces keep the daemon's ownership.
+	require.NoError(t, SafeCopy(context.Background(), filepath.Join(spaces, "c.txt"), filepath.Join(archives, "back.txt"), nil))
+	uid, _ = ownerOf(t, filepath.Join(archives, "back.txt"))
+	assert.Equal(t, 0, uid)
+}
+
+func TestSetSpacesOwnerTree(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown to another user needs root")
+	}
+	restoreConfig(t)
+	dir := t.TempDir()
+	archives, spaces := filepath.Join(dir, "Archives"), filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(filepath.Join(archives, "d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archives, "d", "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.Chown(filepath.Join(archives, "d", "a.txt"), 1001, 1002))
+	require.NoError(t, os.MkdirAll(filepath.Join(spaces, "d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(spaces, "d", "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(spaces, "d", "gone.txt"), []byte("g"), 0644))
+
+	withConfig(t, func(c *Config) {
+		c.SpacesRoot, c.SpacesOwner = spaces, SpacesOwner{Policy: OwnerMap, UIDMap: []IDMap{{From: 0, To: 3000}, {From: 1001, To: 4000}}}
+	})
+	setSpacesOwnerTree(filepath.Join(spaces, "d"), filepath.Join(archives, "d"))
+	uid, gid := ownerOf(t, filepath.Join(spaces, "d", "a.txt"))
+	assert.Equal(t, []int{4000, 1002}, []int{uid, gid}, "mapped from the Archives counterpart")
+	uid, _ = ownerOf(t, filepath.Join(spaces, "d", "gone.txt"))
+	assert.Equal(t, 3000, uid, "no counterpart: mapped from its own owner")
+	uid, _ = ownerOf(t, filepath.Join(spaces, "d"))
+	assert.Equal(t, 3000, uid)
+}
+
+func TestPatchConfig_SpacesOwnerStartupOnly(t *testing.T) {
+	withConfig(t, func(c *Config) { c.SpacesOwner = SpacesOwner{Policy: OwnerDaemon} })
+	for _, patch := range []string{
+		`{"spacesOwner":{"policy":"fixed","uid":0,"gid":0}}`,
+		`{"spacesOwner":{"policy":"preserve"}}`,
+	} {
+		_, err := patchConfig([]byte(patch))
+		assert.Error(t, err, patch)
+	}
+	assert.Equal(t, SpacesOwner{Policy: OwnerDaemon}, currentConfig().SpacesOwner)
+}
@@ -0,0 +1,116 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	gosync "sync"
+	"sync/atomic"
+)
+
+// parallelStreams returns how many ranges to copy a file of size bytes
+// from srcFS to dstFS as, or 1 to copy it as one stream. Only copies
+// between local disks are split: they can read and write at any offset.
+func parallelStreams(srcFS, dstFS fileSystem, size int64) int {
+	cfg := activeConfig.Load()
+	if cfg.ParallelCopyMinMB <= 0 || size < int64(cfg.ParallelCopyMinMB)<<20 {
+		return 1
+	}
+	if _, ok := srcFS.(osFS); !ok {
+		return 1
+	}
+	if _, ok := dstFS.(osFS); !ok {
+		return 1
+	}
+	return cfg.ParallelCopyStreams
+}
+
+// rangeCopy is one parallel copy between local files.
+type rangeCopy struct {
+	in, out   *os.File
+	chunk     int
+	hasQueued func() bool
+	dropCache bool         // drop each chunk read from the page cache
+	read      atomic.Int64 // bytes read by all ranges
+}
+
+// copyRanges copies the size bytes of src into tmpPath as streams
+// contiguous ranges, each read and written at its offset by its own
+// goroutine in chunk-sized pieces. tmpPath is preallocated first, so the
+// ranges land in place without the file growing under them. It returns
+// the bytes read: fewer than size if the source ended early. The first
+// error, cancellation or re-queue stops all ranges.
+func copyRanges(ctx context.Context, src, tmpPath string, size int64, streams, chunk int, hasQueued func() bool) (int64, error) {
+	reads := activeConfig.Load().rootReads(src)
+	in, err := openLocal(src, reads.NoAtime)
+	if err != nil {
+		return 0, fmt.Errorf("open src: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("create tmp: %w", err)
+	}
+	if err := preallocate(out, size); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("preallocate tmp: %w", err)
+	}
+
+	c := &rangeCopy{in: in, out: out, chunk: chunk, hasQueued: hasQueued, dropCache: reads.DropCache}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg gosync.WaitGroup
+	span := (size + int64(streams) - 1) / int64(streams)
+	for off := int64(0); off < size; off += span {
+		end := min(off+span, size)
+		wg.Go(func() {
+			if err := c.copyRange(ctx, off, end); err != nil {
+				cancel(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	copyErr := context.Cause(ctx)
+	if err := out.Close(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("close tmp: %w", err)
+	}
+	return c.read.Load(), copyErr
+}
+
+// copyRange copies [off, end) of the source to the same offsets of the
+// tmp file. A source ending early stops it without error.
+func (c *rangeCopy) copyRange(ctx context.Context, off, end int64) error {
+	buf := make([]byte, min(int64(c.chunk), end-off))
+	for off < end {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if c.hasQueued != nil && c.hasQueued() {
+			return fmt.Errorf("path re-queued, aborting copy")
+		}
+
+		n, readErr := c.in.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+		if n > 0 {
+			if _, err := c.out.WriteAt(buf[:n], off); err != nil {
+				return fmt.Errorf("write tmp: %w", err)
+			}
+			if c.dropCache {
+				dropCache(c.in, off, int64(n))
+			}
+			off += int64(n)
+			c.read.Add(int64(n))
+			copyBytesMeter.Add(int64(n))
+			workBytes.Add(int64(n))
+			decisionFrom(ctx).addBytes(int64(n))
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("read src: %w", readErr)
+		}
+	}
+	return nil
+}
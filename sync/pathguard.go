@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errUnsafePath is returned for client-supplied paths that would leave
+// the roots.
+var errUnsafePath = errors.New("path escapes the root")
+
+// safeRelPath validates a client-supplied path and returns it cleaned and
+// relative to the roots; "" is the root. A leading "/" is root-relative,
+// as in listings, so absolute paths only ever name something below the
+// roots. ".." segments and NUL bytes are refused rather than cleaned
+// away, so a traversal attempt fails instead of landing elsewhere.
+func safeRelPath(p string) (string, error) {
+	p = filepath.ToSlash(p)
+	if strings.ContainsRune(p, 0) {
+		return "", errUnsafePath
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", errUnsafePath
+		}
+	}
+	return cleanRelPath(p), nil
+}
+
+// joinUnderRoot joins relPath to root and checks that no symlink on the way
+// leads out of root. The deepest existing ancestor is resolved, so paths
+// about to be created are checked too. relPath must already be clean,
+// as safeRelPath returns it.
+func joinUnderRoot(root, relPath string) (string, error) {
+	path := filepath.Join(root, relPath)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// No root on disk, nothing to escape through.
+		return path, nil
+	}
+	existing := path
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !underRoot(real, realRoot) {
+				return "", errUnsafePath
+			}
+			return path, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			// A dangling symlink: where it leads can't be checked.
+			return "", errUnsafePath
+		}
+		if existing == root {
+			return path, nil
+		}
+		existing = filepath.Dir(existing)
+	}
+}
+
+// checkPath validates a client-supplied path for the handlers: it returns
+// the path cleaned per safeRelPath, once it stays within both roots.
+func (h *Handlers) checkPath(p string) (string, error) {
+	relPath, err := safeRelPath(p)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range []string{h.archivesRoot, h.spacesRoot} {
+		if _, err := joinUnderRoot(root, relPath); err != nil {
+			return "", err
+		}
+	}
+	return relPath, nil
+}
@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeRelPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":              "",
+		"/":             "",
+		"Docs/a.txt":    "Docs/a.txt",
+		"/Docs/":        "Docs",
+		"Docs//./a.txt": "Docs/a.txt",
+		"/etc/passwd":   "etc/passwd", // root-relative
+		"a..b/..c":      "a..b/..c",
+	} {
+		got, err := safeRelPath(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"..", "../x", "Docs/../../x", "/../etc", "Docs/..", "a\x00b"} {
+		_, err := safeRelPath(in)
+		assert.ErrorIs(t, err, errUnsafePath, in)
+	}
+}
+
+func TestCheckPath_SymlinkEscape(t *testing.T) {
+	h, _, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "Docs"), 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(archivesRoot, "out")))
+	require.NoError(t, os.Symlink(filepath.Join(archivesRoot, "Docs"), filepath.Join(archivesRoot, "docs-link")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "gone"), filepath.Join(spacesRoot, "dangling")))
+
+	for _, p := range []string{"Docs", "Docs/new/file.txt", "docs-link/a.txt", "missing/x"} {
+		_, err := h.checkPath(p)
+		assert.NoError(t, err, p)
+	}
+	for _, p := range []string{"out", "out/secret.txt", "dangling", "../" + filepath.Base(outside)} {
+		_, err := h.checkPath(p)
+		assert.ErrorIs(t, err, errUnsafePath, p)
+	}
+}
+
+func TestHandlers_RejectTraversal(t *testing.T) {
+	h, _, archivesRoot, _ := setupHandlersEnv(t)
+	require.NoError(t, os.Symlink(t.TempDir(), filepath.Join(archivesRoot, "out")))
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?path=../..", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	_, err := h.resolvePathToIno("Docs/../../etc")
+	assert.ErrorIs(t, err, errUnsafePath)
+
+	for _, body := range []string{`{"path":"../New"}`, `{"path":"out/New"}`} {
+		assert.Equal(t, http.StatusBadRequest, postJSON(h.HandleMkdir, "/api/sync/mkdir", body).Code, body)
+	}
+	assert.Equal(t, http.StatusBadRequest, postJSON(h.HandleMove, "/api/sync/move", `{"from":"a.txt","to":"../a.txt"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, postJSON(h.HandleCopy, "/api/sync/copy", `{"from":"out/a.txt","to":"a.txt"}`).Code)
+	assert.NoDirExists(t, filepath.Join(filepath.Dir(archivesRoot), "New"))
+}
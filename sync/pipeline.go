@@ -2,11 +2,16 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"syscall"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // logState logs all 7 state variables at DEBUG level.
@@ -28,42 +33,74 @@ func logState(l *slog.Logger, msg, relPath string, state State) {
 // RunPipeline evaluates a single relative path through P0→P4.
 // It gathers the 7 variables, determines the scenario, and executes
 // the appropriate actions to converge toward the target state.
-func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot, spacesRoot, trashRoot string, hasQueued func() bool) error {
+func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot, spacesRoot, trashRoot string, hasQueued func() bool) (err error) {
 	l := sub("pipeline")
 	l.Debug("pipeline start", "path", relPath)
 
+	ctx, run := beginDecision(ctx, relPath)
+	var final, current *State
+	defer func() {
+		if err != nil && !errors.Is(err, ErrSourceUnstable) && ctx.Err() == nil {
+			pipelineStats.recordFailed(relPath, current, err)
+		}
+		run.finish(final, err)
+	}()
+	store = store.WithContext(ctx)
+
+	if syncthingConflict(filepath.Base(relPath)) {
+		return runStage(ctx, "syncthing", relPath, func(ctx context.Context) error {
+			return ingestSyncthingConflict(ctx, store, relPath, archivesRoot, spacesRoot, hasQueued)
+		})
+	}
+
 	archivePath := filepath.Join(archivesRoot, relPath)
 	spacesPath := filepath.Join(spacesRoot, relPath)
 
 	// Gather disk state
-	archiveMtime, archiveIsDir, archiveInode, archiveSize := statFile(archivePath)
-	spacesMtime, _, _, _ := statFile(spacesPath)
+	archiveMtime, archiveIsDir, archiveInode, archiveSize, err := statPath(archivePath)
+	if err != nil {
+		return fmt.Errorf("stat archives: %w", err)
+	}
+	spacesMtime, _, _, _, err := statPath(spacesPath)
+	if err != nil {
+		return fmt.Errorf("stat spaces: %w", err)
+	}
 
 	// Gather DB state
-	entry, sv, err := lookupDB(store, archivesRoot, relPath)
+	entry, sv, err := lookupPipeline(store, archivesRoot, relPath)
 	if err != nil {
 		return fmt.Errorf("db lookup: %w", err)
 	}
 
 	// Compute state
 	state := ComputeState(entry, sv, archiveMtime, spacesMtime)
+	current = &state // re-gathered after each stage
 	scenario := state.Scenario()
+	run.state(state)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("sync.scenario", scenario))
 
 	if logEnabled(slog.LevelDebug) {
 		logState(l, "state gathered", relPath, state)
 	}
 	l.Info("pipeline evaluated", "path", relPath, "scenario", scenario, "status", state.UIStatus())
+	pipelineStats.recordEvaluated(scenario)
 
 	// P0: Archives disk recovery (A_disk=0)
 	if !state.ADisk {
 		l.Debug("P0 enter: archives recovery", "path", relPath, "S_disk", state.SDisk)
-		if err := p0(ctx, store, entry, sv, relPath, archivePath, spacesPath, state, hasQueued); err != nil {
+		if err := runStage(ctx, "P0", relPath, func(ctx context.Context) error {
+			return p0(ctx, store, entry, sv, relPath, archivePath, spacesPath, trashRoot, state, hasQueued)
+		}); err != nil {
 			return fmt.Errorf("P0: %w", err)
 		}
 		// Re-gather state after P0 actions
-		archiveMtime, archiveIsDir, archiveInode, archiveSize = statFile(archivePath)
-		spacesMtime, _, _, _ = statFile(spacesPath)
-		entry, sv, err = lookupDB(store, archivesRoot, relPath)
+		if archiveMtime, archiveIsDir, archiveInode, archiveSize, err = statPath(archivePath); err != nil {
+			return fmt.Errorf("stat archives post-P0: %w", err)
+		}
+		if spacesMtime, _, _, _, err = statPath(spacesPath); err != nil {
+			return fmt.Errorf("stat spaces post-P0: %w", err)
+		}
+		entry, sv, err = lookupPipeline(store, archivesRoot, relPath)
 		if err != nil {
 			return fmt.Errorf("db lookup post-P0: %w", err)
 		}
@@ -76,11 +113,13 @@ func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot
 	// P1: DB registration (A_db=0, A_disk=1 guaranteed after P0)
 	if !state.ADb && state.ADisk {
 		l.Debug("P1 enter: DB registration", "path", relPath, "inode", archiveInode, "isDir", archiveIsDir)
-		if err := p1(store, relPath, archivesRoot, archiveInode, archiveIsDir, archiveSize, archiveMtime, state); err != nil {
+		if err := runStage(ctx, "P1", relPath, func(context.Context) error {
+			return p1(store, relPath, archivesRoot, archiveInode, archiveIsDir, archiveSize, archiveMtime, state)
+		}); err != nil {
 			return fmt.Errorf("P1: %w", err)
 		}
 		// Re-gather
-		entry, sv, err = lookupDB(store, archivesRoot, relPath)
+		entry, sv, err = lookupPipeline(store, archivesRoot, relPath)
 		if err != nil {
 			return fmt.Errorf("db lookup post-P1: %w", err)
 		}
@@ -90,16 +129,38 @@ func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot
 		}
 	}
 
+	// A held entry keeps its changes on both sides until the hold ends.
+	held := entry != nil && holds.held(entry.Inode)
+	if held && (state.ADirty || state.SDirty || entry.Selected != state.SDisk) {
+		l.Info("entry held, skipping P2/P3", "path", relPath, "inode", entry.Inode)
+	}
+	// After an anomaly alert with autoPause, Spaces changes wait likewise
+	// until it is acknowledged; in safe mode, after a canary tripped, all
+	// changes do.
+	if !held && state.SDirty {
+		anomalies.spacesChanged(relPath)
+	}
+	if !held && entry != nil && (state.ADirty || state.SDirty || entry.Selected != state.SDisk) && anomalies.hold(relPath, state.SDirty) {
+		l.Warn("paused after anomaly alert, skipping P2/P3", "path", relPath)
+		held = true
+	}
+
 	// P2: Change sync (A_dirty or S_dirty)
-	if state.ADirty || state.SDirty {
+	if !held && (state.ADirty || state.SDirty) {
 		l.Debug("P2 enter: change sync", "path", relPath, "A_dirty", state.ADirty, "S_dirty", state.SDirty)
-		if err := p2(ctx, store, entry, sv, relPath, archivePath, spacesPath, archivesRoot, state, hasQueued); err != nil {
+		if err := runStage(ctx, "P2", relPath, func(ctx context.Context) error {
+			return p2(ctx, store, entry, sv, relPath, archivePath, spacesPath, archivesRoot, trashRoot, state, hasQueued)
+		}); err != nil {
 			return fmt.Errorf("P2: %w", err)
 		}
 		// Re-gather
-		archiveMtime, _, _, _ = statFile(archivePath)
-		spacesMtime, _, _, _ = statFile(spacesPath)
-		entry, sv, err = lookupDB(store, archivesRoot, relPath)
+		if archiveMtime, _, _, _, err = statPath(archivePath); err != nil {
+			return fmt.Errorf("stat archives post-P2: %w", err)
+		}
+		if spacesMtime, _, _, _, err = statPath(spacesPath); err != nil {
+			return fmt.Errorf("stat spaces post-P2: %w", err)
+		}
+		entry, sv, err = lookupPipeline(store, archivesRoot, relPath)
 		if err != nil {
 			return fmt.Errorf("db lookup post-P2: %w", err)
 		}
@@ -110,14 +171,18 @@ func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot
 	}
 
 	// P3: Goal realization (selected ≠ S_disk)
-	if entry != nil && entry.Selected != state.SDisk {
+	if !held && entry != nil && entry.Selected != state.SDisk {
 		l.Debug("P3 enter: goal realization", "path", relPath, "selected", entry.Selected, "S_disk", state.SDisk)
-		if err := p3(ctx, store, entry, sv, relPath, archivePath, spacesPath, trashRoot, state, hasQueued); err != nil {
+		if err := runStage(ctx, "P3", relPath, func(ctx context.Context) error {
+			return p3(ctx, store, entry, sv, relPath, archivePath, spacesPath, trashRoot, state, hasQueued)
+		}); err != nil {
 			return fmt.Errorf("P3: %w", err)
 		}
 		// Re-gather
-		spacesMtime, _, _, _ = statFile(spacesPath)
-		entry, sv, err = lookupDB(store, archivesRoot, relPath)
+		if spacesMtime, _, _, _, err = statPath(spacesPath); err != nil {
+			return fmt.Errorf("stat spaces post-P3: %w", err)
+		}
+		entry, sv, err = lookupPipeline(store, archivesRoot, relPath)
 		if err != nil {
 			return fmt.Errorf("db lookup post-P3: %w", err)
 		}
@@ -130,26 +195,66 @@ func RunPipeline(ctx context.Context, relPath string, store *Store, archivesRoot
 	// P4: DB consistency (S_db ≠ S_disk)
 	if state.SDb != state.SDisk {
 		l.Debug("P4 enter: DB consistency", "path", relPath, "S_db", state.SDb, "S_disk", state.SDisk)
-		if err := p4(store, entry, sv, relPath, spacesPath, state); err != nil {
+		if err := runStage(ctx, "P4", relPath, func(context.Context) error {
+			return p4(store, entry, sv, relPath, spacesPath, state)
+		}); err != nil {
 			return fmt.Errorf("P4: %w", err)
 		}
 		l.Debug("P4 done", "path", relPath)
+		entry, sv, err = lookupPipeline(store, archivesRoot, relPath)
+		if err != nil {
+			return fmt.Errorf("db lookup post-P4: %w", err)
+		}
+		state = ComputeState(entry, sv, archiveMtime, spacesMtime)
 	}
 
-	l.Debug("pipeline complete", "path", relPath)
+	pipelineStats.recordFinal(relPath, state)
+	l.Debug("pipeline complete", "path", relPath, "finalScenario", state.Scenario())
+	final = &state
 	return nil
 }
 
+// runStage runs one pipeline stage for relPath in a child span of ctx,
+// recording it as an action of the run's Decision.
+func runStage(ctx context.Context, stage, relPath string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer().Start(ctx, stage, trace.WithAttributes(attribute.String("sync.path", relPath)))
+	err := decisionFrom(ctx).action(stage, func() error { return fn(ctx) })
+	endSpan(span, err)
+	return err
+}
+
 // p0 handles Archives disk recovery when A_disk=0.
-func p0(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath, archivePath, spacesPath string, state State, hasQueued func() bool) error {
+func p0(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath, archivePath, spacesPath, trashRoot string, state State, hasQueued func() bool) error {
 	l := sub("P0")
 	if state.SDisk {
+		if entry == nil {
+			// A new Spaces file: the policy for its directory may hold it.
+			if stopped, err := gateNewFile(store, relPath, spacesPath, trashRoot); err != nil || stopped {
+				return err
+			}
+		}
+		if entry != nil {
+			if d, err := store.GetDerivative(entry.Inode); err != nil {
+				return err
+			} else if d != nil {
+				l.Warn("Archives original lost, not recovering it from its Spaces derivative", "path", relPath, "transcode", d.Transcode)
+				return nil
+			}
+		}
 		// S_disk=1 → copy S→A to recover
 		l.Info("recovering from Spaces", "path", relPath)
-		if err := SafeCopy(ctx, spacesPath, archivePath, hasQueued); err != nil {
+		if err := checkStable(spacesPath); err != nil {
+			return err
+		}
+		if err := copyFromSpaces(ctx, relPath, spacesPath, archivePath, hasQueued); err != nil {
 			return err
 		}
 		l.Debug("SafeCopy S->A done", "path", relPath)
+		if entry == nil {
+			if err := store.DeleteUnconfirmed(relPath); err != nil {
+				return err
+			}
+		}
 		// Update entries mtime/size if entry exists
 		if entry != nil {
 			info, err := os.Stat(archivePath)
@@ -167,22 +272,32 @@ func p0(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath
 
 	// S_disk=0, A_disk=0 → both gone
 	if entry != nil {
-		// Clean up DB records
+		// Clean up DB records; the entry is kept as a tombstone until
+		// maintenance purges it.
 		if sv != nil {
-			if err := store.DeleteSpacesView(sv.EntryIno); err != nil {
-				return fmt.Errorf("delete spaces_view: %w", err)
-			}
-			l.Info("deleting lost entry and spaces_view", "path", relPath, "inode", entry.Inode)
+			l.Info("tombstoning lost entry, deleting spaces_view", "path", relPath, "inode", entry.Inode)
 		} else {
-			l.Info("deleting lost entry", "path", relPath, "inode", entry.Inode)
+			l.Info("tombstoning lost entry", "path", relPath, "inode", entry.Inode)
 		}
-		if err := store.DeleteEntry(entry.Inode); err != nil {
-			return fmt.Errorf("delete entry: %w", err)
-		}
-	} else {
+		return store.WithTx(func(tx *TxStore) error {
+			if sv != nil {
+				if err := tx.DeleteSpacesView(sv.EntryIno); err != nil {
+					return fmt.Errorf("delete spaces_view: %w", err)
+				}
+			}
+			if err := tx.TombstoneEntry(entry.Inode); err != nil {
+				return fmt.Errorf("tombstone entry: %w", err)
+			}
+			return nil
+		})
+	}
+	// A held file deleted from Spaces is no longer waiting.
+	if u, err := store.GetUnconfirmed(relPath); err != nil || u == nil {
 		l.Debug("no-op: no DB records", "path", relPath)
+		return err
 	}
-	return nil
+	l.Info("unconfirmed file gone from Spaces", "path", relPath)
+	return store.DeleteUnconfirmed(relPath)
 }
 
 // p1 handles DB registration when A_db=0 and A_disk=1.
@@ -203,15 +318,73 @@ func p1(store *Store, relPath, archivesRoot string, inode *uint64, isDir *bool,
 	if isDir != nil && *isDir {
 		entryType = "dir"
 	} else {
-		entryType = ClassifyType(filepath.Base(relPath), false)
+		entryType = ClassifyFile(filepath.Join(archivesRoot, relPath), filepath.Base(relPath))
 	}
 
 	sel := state.SDisk // S_disk=1 → sel=1, S_disk=0 → sel=0
+	action := RuleAction("")
+	if !sel && entryType != "dir" {
+		// Archives-only file: let auto-select rules decide
+		switch action = evalRules(activeConfig.Load().Rules, relPath, sizeOrZero(size), *mtime); action {
+		case RuleSelect:
+			l.Debug("auto-select rule matched", "path", relPath)
+			sel = true
+		case "":
+			// No rule: a filtered folder selection takes in matching files.
+			filter, err := store.SelectionFilterFor(parentIno)
+			if err != nil {
+				return err
+			}
+			if filter != nil && filter.matches(filepath.Base(relPath), entryType, sizeOrZero(size)) {
+				l.Debug("selection filter matched", "path", relPath)
+				sel = true
+			}
+		}
+	}
+	if !sel && action == "" {
+		// Unless a rule deselected it, an auto-select folder takes in
+		// everything new.
+		auto, err := store.AutoSelectFor(parentIno)
+		if err != nil {
+			return err
+		}
+		if auto {
+			l.Debug("auto-select folder", "path", relPath)
+			sel = true
+		}
+	}
 	var sizePtr *int64
 	if size != nil && !(isDir != nil && *isDir) {
 		sizePtr = size
 	}
 
+	// Same inode already registered elsewhere: the file was renamed (e.g. a
+	// conflict copy seen before its DB update), so move the entry instead
+	// of violating the inode key. Hard links keep their first path. An
+	// entry of the other kind (file vs dir) is for something deleted since,
+	// whose inode the disk reused: drop it and register afresh.
+	if existing, err := store.GetEntry(*inode); err != nil {
+		return fmt.Errorf("lookup inode: %w", err)
+	} else if existing != nil && (existing.Type == "dir") != (entryType == "dir") {
+		l.Info("dropping stale entry with reused inode", "path", relPath, "inode", *inode, "was", store.RelPath(existing))
+		if err := store.WithTx(func(tx *TxStore) error {
+			if err := tx.DeleteSpacesView(*inode); err != nil {
+				return err
+			}
+			return tx.DeleteEntry(*inode)
+		}); err != nil {
+			return err
+		}
+	} else if existing != nil {
+		oldPath := filepath.Join(archivesRoot, store.RelPath(existing))
+		if _, _, oldIno, _ := statFile(oldPath); oldIno == nil || *oldIno != *inode {
+			l.Info("registering renamed entry", "path", relPath, "inode", *inode, "from", store.RelPath(existing))
+			return store.MoveEntry(*inode, parentIno, filepath.Base(relPath))
+		}
+		l.Debug("skip: inode already registered at another path", "path", relPath, "inode", *inode)
+		return nil
+	}
+
 	l.Info("registering entry", "path", relPath, "inode", *inode, "type", entryType, "selected", sel, "parentIno", parentIno)
 	return store.UpsertEntry(Entry{
 		Inode:     *inode,
@@ -225,108 +398,176 @@ func p1(store *Store, relPath, archivesRoot string, inode *uint64, isDir *bool,
 }
 
 // p2 handles change synchronization when A_dirty or S_dirty.
-func p2(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath, archivePath, spacesPath, archivesRoot string, state State, hasQueued func() bool) error {
+func p2(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath, archivePath, spacesPath, archivesRoot, trashRoot string, state State, hasQueued func() bool) error {
 	l := sub("P2")
+	// Wait for in-progress writes on the changed side(s) to finish before
+	// syncing, so a half-written file neither propagates nor conflicts.
+	if state.ADirty {
+		if err := checkStable(archivePath); err != nil {
+			return err
+		}
+	}
+	if state.SDirty {
+		if err := checkStable(spacesPath); err != nil {
+			return err
+		}
+	}
+	if state.SDisk && (state.ADirty || state.SDirty) {
+		if same, err := mtimeOnlyChange(archivePath, spacesPath); err != nil {
+			return err
+		} else if same {
+			l.Warn("future mtime but content unchanged, recording without copying", "path", relPath)
+			aMtime, _, _, aSize := statFile(archivePath)
+			sMtime, _, _, _ := statFile(spacesPath)
+			if aMtime == nil || sMtime == nil {
+				return nil // gone since, the next run sees it
+			}
+			return store.WithTx(func(tx *TxStore) error {
+				if err := tx.UpdateEntryMtime(entry.Inode, *aMtime, aSize); err != nil {
+					return err
+				}
+				return tx.UpsertSpacesView(SpacesView{EntryIno: entry.Inode, SyncedMtime: *sMtime, CheckedAt: nowNano()})
+			})
+		}
+	}
+	if state.SDirty && entry.Type != "dir" {
+		if d, err := store.GetDerivative(entry.Inode); err != nil {
+			return err
+		} else if d != nil {
+			// A derivative never replaces its original: keep the edit in
+			// Spaces, or replace it with a new derivative if Archives
+			// changed too.
+			l.Warn("Spaces copy is a derivative, not copying it to Archives", "path", relPath, "original", d.Original)
+			if state.ADirty {
+				state.SDirty = false
+			} else {
+				sMtime, _, _, _ := statFile(spacesPath)
+				if sMtime == nil || sv == nil {
+					return nil
+				}
+				sv.SyncedMtime, sv.CheckedAt = *sMtime, nowNano()
+				return store.UpsertSpacesView(*sv)
+			}
+		}
+	}
+	if state.SDirty && entry.Type != "dir" {
+		// Spaces is the exposed side: scan what would be copied from it.
+		if quarantined, err := scanFromSpaces(ctx, relPath, spacesPath, trashRoot); err != nil || quarantined {
+			return err
+		}
+	}
 	if state.ADirty && state.SDirty {
 		// Both dirty → conflict
 		l.Warn("conflict: both dirty", "path", relPath)
 
-		// 1) DB: update existing entry's name to conflict name
+		// 1) Disk: rename archive to conflict name (journaled)
+		// 2) SafeCopy S→A (Spaces wins) → new file with new inode
+		// 3) DB: conflict copy keeps the original inode under the conflict
+		//    name, the winner is registered under the new inode, atomically
 		conflictName := ConflictName(archivePath)
-		if err := store.UpdateEntryName(entry.Inode, conflictName); err != nil {
-			return fmt.Errorf("update entry name for conflict: %w", err)
-		}
-		l.Debug("renamed DB entry", "inode", entry.Inode, "newName", conflictName)
-
-		// 2) Disk: rename archive to conflict name
 		conflictPath := filepath.Join(filepath.Dir(archivePath), conflictName)
-		if err := os.Rename(archivePath, conflictPath); err != nil {
-			return fmt.Errorf("rename conflict: %w", err)
-		}
-		l.Debug("renamed archive file", "from", archivePath, "to", conflictPath)
-
-		// 3) SafeCopy S→A (Spaces wins) → creates new file with new inode
-		if err := SafeCopy(ctx, spacesPath, archivePath, hasQueued); err != nil {
-			return fmt.Errorf("copy S→A after conflict: %w", err)
-		}
-		l.Debug("SafeCopy S->A after conflict", "path", relPath)
-
-		// 4) Register the new archive file (new inode) in DB
-		aInfo, err := os.Stat(archivePath)
+		var copyErr error
+		var winner *Entry
+		var winnerSV *SpacesView
+		err := journaled(store, Intent{Op: IntentConflictRename, Path: relPath, Inode: entry.Inode, Target: conflictName},
+			func() error {
+				if err := os.Rename(archivePath, conflictPath); err != nil {
+					return fmt.Errorf("rename conflict: %w", err)
+				}
+				l.Debug("renamed archive file", "from", archivePath, "to", conflictPath)
+				// The copy stays out of the DB transaction; its failure is
+				// reported once the DB follows the rename.
+				copyErr = hooked(HookEvent{Stage: "P2", Action: HookConflict, Direction: IOToArchives, Path: relPath, Src: spacesPath, Dst: archivePath}, func() error {
+					return copyFromSpaces(ctx, relPath, spacesPath, archivePath, hasQueued)
+				})
+				if copyErr == nil {
+					l.Debug("SafeCopy S->A after conflict", "path", relPath)
+					winner, winnerSV, copyErr = conflictWinner(entry, archivePath, spacesPath)
+				}
+				return nil
+			},
+			func(tx *TxStore) error {
+				// Register even if the copy failed so the DB follows the rename.
+				id, err := tx.RegisterConflict(Conflict{Path: relPath, ConflictIno: entry.Inode, ConflictName: conflictName}, winner, winnerSV)
+				if err != nil {
+					return fmt.Errorf("register conflict: %w", err)
+				}
+				if winner != nil {
+					l.Info("conflict resolved", "path", relPath, "newInode", winner.Inode, "oldInode", entry.Inode, "conflictID", id)
+				}
+				return nil
+			})
 		if err != nil {
-			return fmt.Errorf("stat new archive: %w", err)
-		}
-		newStat, ok := aInfo.Sys().(*syscall.Stat_t)
-		if !ok {
-			return fmt.Errorf("failed to get inode for new archive")
-		}
-		if err := store.UpsertEntry(Entry{
-			Inode:     newStat.Ino,
-			ParentIno: entry.ParentIno,
-			Name:      entry.Name,
-			Type:      entry.Type,
-			Size:      ptrInt64(aInfo.Size()),
-			Mtime:     aInfo.ModTime().UnixNano(),
-			Selected:  true,
-		}); err != nil {
-			return fmt.Errorf("register new archive entry: %w", err)
+			return err
 		}
-		l.Info("conflict resolved", "path", relPath, "newInode", newStat.Ino, "oldInode", entry.Inode)
-
-		// 5) Update spaces_view for the new entry
-		if sv != nil {
-			sInfo, err := os.Stat(spacesPath)
-			if err == nil {
-				sv.EntryIno = newStat.Ino
-				sv.SyncedMtime = sInfo.ModTime().UnixNano()
-				sv.CheckedAt = nowNano()
-				if err := store.UpsertSpacesView(*sv); err != nil {
-					return fmt.Errorf("update spaces_view: %w", err)
-				}
-				l.Debug("spaces_view updated for conflict winner", "inode", newStat.Ino)
-			}
+		if copyErr != nil {
+			return fmt.Errorf("copy S→A after conflict: %w", copyErr)
 		}
 		return nil
 	}
 
 	if state.ADirty {
-		// Archives changed — update DB
+		// Archives changed — propagate first, then record the new version
+		// and the Spaces copy together, so a failed copy leaves the entry
+		// dirty and it is retried.
 		info, err := os.Stat(archivePath)
 		if err != nil {
 			return fmt.Errorf("stat archive: %w", err)
 		}
 		l.Debug("archive changed", "path", relPath, "oldMtime", entry.Mtime, "newMtime", info.ModTime().UnixNano(), "newSize", info.Size())
-		if err := store.UpdateEntryMtime(entry.Inode, info.ModTime().UnixNano(), ptrInt64(info.Size())); err != nil {
-			return fmt.Errorf("update entry mtime: %w", err)
-		}
-		entry.Mtime = info.ModTime().UnixNano()
-		entry.Size = ptrInt64(info.Size())
-
-		// If selected and S_disk=1, propagate change to Spaces
-		if entry.Selected && state.SDisk {
+		propagate := entry.Selected && state.SDisk
+		var derived *Derivative
+		if propagate {
 			l.Info("propagating A->S", "path", relPath)
-			if err := SafeCopy(ctx, archivePath, spacesPath, hasQueued); err != nil {
+			err := hooked(HookEvent{Stage: "P2", Action: HookCopy, Direction: IOToSpaces, Path: relPath, Src: archivePath, Dst: spacesPath}, func() (err error) {
+				derived, err = copyToSpaces(ctx, relPath, archivePath, spacesPath, hasQueued)
+				return err
+			})
+			if err != nil {
 				return fmt.Errorf("copy A→S: %w", err)
 			}
+		} else {
+			l.Debug("no propagation", "path", relPath, "selected", entry.Selected, "S_disk", state.SDisk)
+		}
+		err = store.WithTx(func(tx *TxStore) error {
+			if err := tx.UpdateEntryMtime(entry.Inode, info.ModTime().UnixNano(), ptrInt64(info.Size())); err != nil {
+				return fmt.Errorf("update entry mtime: %w", err)
+			}
+			if !propagate {
+				return nil
+			}
+			if err := tx.ClearPlaceholder(entry.Inode); err != nil {
+				return err
+			}
 			if sv != nil {
-				spInfo, err := os.Stat(spacesPath)
-				if err == nil {
+				if spInfo, err := fsFor(spacesPath).Stat(spacesPath); err == nil {
 					sv.SyncedMtime = spInfo.ModTime().UnixNano()
 					sv.CheckedAt = nowNano()
-					if err := store.UpsertSpacesView(*sv); err != nil {
+					sv.Cipher, sv.Compression = spacesEncoding(spacesPath)
+					if err := tx.UpsertSpacesView(*sv); err != nil {
 						return fmt.Errorf("update spaces_view: %w", err)
 					}
+					if err := tx.SetDerivative(entry.Inode, derived); err != nil {
+						return err
+					}
 				}
 			}
-		} else {
-			l.Debug("DB updated, no propagation", "path", relPath, "selected", entry.Selected, "S_disk", state.SDisk)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
+		entry.Mtime = info.ModTime().UnixNano()
+		entry.Size = ptrInt64(info.Size())
 		return nil
 	}
 
 	// S_dirty only — Spaces changed, propagate S→A
 	l.Info("propagating S->A", "path", relPath)
-	if err := SafeCopy(ctx, spacesPath, archivePath, hasQueued); err != nil {
+	err := hooked(HookEvent{Stage: "P2", Action: HookCopy, Direction: IOToArchives, Path: relPath, Src: spacesPath, Dst: archivePath}, func() error {
+		return copyFromSpaces(ctx, relPath, spacesPath, archivePath, hasQueued)
+	})
+	if err != nil {
 		return fmt.Errorf("copy S→A: %w", err)
 	}
 	return updateEntryFromDisk(store, entry, archivePath, sv, spacesPath)
@@ -344,48 +585,147 @@ func p3(ctx context.Context, store *Store, entry *Entry, sv *SpacesView, relPath
 		if err != nil {
 			return fmt.Errorf("re-check entry: %w", err)
 		}
+		if err := foldDirSelection(store, freshEntry); err != nil {
+			return fmt.Errorf("re-check entry: %w", err)
+		}
 		if freshEntry == nil || !freshEntry.Selected {
 			l.Info("deselected before copy, skipping", "path", relPath, "inode", entry.Inode)
 			return nil
 		}
+		if freshEntry.Excluded {
+			l.Info("excluded, skipping copy", "path", relPath, "inode", entry.Inode)
+			return nil
+		}
 
+		var derived *Derivative
 		if entry.Type == "dir" {
 			// For directories, just create
-			if err := os.MkdirAll(spacesPath, 0755); err != nil {
+			if err := fsFor(spacesPath).MkdirAll(spacesPath, 0755); err != nil {
 				return fmt.Errorf("mkdir spaces: %w", err)
 			}
 			l.Debug("mkdir Spaces", "path", spacesPath)
 		} else {
-			if err := SafeCopy(ctx, archivePath, spacesPath, hasQueued); err != nil {
-				return fmt.Errorf("copy A→S: %w", err)
+			restored, err := restoreFromTrash(store, entry, relPath, archivePath, spacesPath)
+			if err != nil {
+				return err
+			}
+			if !restored {
+				if err := checkStable(archivePath); err != nil {
+					return err
+				}
+				stub, err := createPlaceholder(store, entry.Inode, archivePath, spacesPath)
+				if err != nil {
+					return fmt.Errorf("placeholder: %w", err)
+				}
+				if !stub {
+					wait, err := gateLargeCopy(store, entry, relPath, archivePath)
+					if err != nil {
+						return fmt.Errorf("large copy: %w", err)
+					}
+					if wait {
+						return nil
+					}
+					err = hooked(HookEvent{Stage: "P3", Action: HookCopy, Direction: IOToSpaces, Path: relPath, Src: archivePath, Dst: spacesPath}, func() (err error) {
+						derived, err = copyToSpaces(ctx, relPath, archivePath, spacesPath, hasQueued)
+						return err
+					})
+					if err != nil {
+						return fmt.Errorf("copy A→S: %w", err)
+					}
+					l.Debug("SafeCopy A->S done", "path", relPath)
+				}
 			}
-			l.Debug("SafeCopy A->S done", "path", relPath)
 		}
 
 		// Update spaces_view
-		spInfo, err := os.Stat(spacesPath)
+		spInfo, err := fsFor(spacesPath).Stat(spacesPath)
 		if err == nil {
-			if err := store.UpsertSpacesView(SpacesView{
+			view := SpacesView{
 				EntryIno:    entry.Inode,
 				SyncedMtime: spInfo.ModTime().UnixNano(),
 				CheckedAt:   nowNano(),
-			}); err != nil {
-				return fmt.Errorf("upsert spaces_view: %w", err)
+			}
+			view.Cipher, view.Compression = spacesEncoding(spacesPath)
+			err := store.WithTx(func(tx *TxStore) error {
+				if err := tx.UpsertSpacesView(view); err != nil {
+					return fmt.Errorf("upsert spaces_view: %w", err)
+				}
+				if entry.Type == "dir" {
+					return nil
+				}
+				return tx.SetDerivative(entry.Inode, derived)
+			})
+			if err != nil {
+				return err
 			}
 			l.Debug("spaces_view upserted", "inode", entry.Inode)
+			publishStatus(relPath, entry.Inode, "synced")
 		}
 		return nil
 	}
 
 	if !entry.Selected && state.SDisk {
+		// A stub holds nothing worth keeping: remove it outright.
+		if stub, err := store.IsPlaceholder(entry.Inode); err != nil {
+			return err
+		} else if stub && isStub(spacesPath) {
+			l.Info("removing placeholder from Spaces", "path", relPath)
+			if err := os.Remove(spacesPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove placeholder: %w", err)
+			}
+			err := store.WithTx(func(tx *TxStore) error {
+				if err := tx.ClearPlaceholder(entry.Inode); err != nil {
+					return err
+				}
+				if sv == nil {
+					return nil
+				}
+				return tx.DeleteSpacesView(sv.EntryIno)
+			})
+			if err == nil {
+				publishStatus(relPath, entry.Inode, "archived")
+			}
+			return err
+		}
+
+		if pending, err := removalPending(store, entry, sv, relPath); err != nil || pending {
+			return err
+		}
+
 		// Need to remove from Spaces
 		l.Info("removing from Spaces", "path", relPath)
-		trashPath, err := SoftDelete(spacesPath, trashRoot)
-		if err != nil {
-			return fmt.Errorf("soft delete: %w", err)
+		item := TrashItem{RelPath: relPath, EntryIno: entry.Inode}
+		err := journaled(store, Intent{Op: IntentTrash, Path: relPath, Inode: entry.Inode},
+			func() error {
+				ev := HookEvent{Stage: "P3", Action: HookTrash, Path: relPath, Src: spacesPath}
+				fireHooks(HookBefore, ev, nil)
+				item.stat(spacesPath)
+				trashPath, err := SoftDeleteAt(spacesPath, trashRoot, relPath)
+				item.TrashPath = trashPath
+				ev.Dst = trashPath
+				fireHooks(HookAfter, ev, err)
+				if err != nil {
+					return fmt.Errorf("soft delete: %w", err)
+				}
+				l.Debug("soft-deleted", "path", relPath, "trashPath", trashPath)
+				return nil
+			},
+			func(tx *TxStore) error {
+				if err := tx.RecordSelectionTrash(entry.Inode, item.TrashPath); err != nil {
+					return err
+				}
+				if err := tx.RecordTrash(item); err != nil {
+					return err
+				}
+				if sv == nil {
+					return nil
+				}
+				return tx.DeleteSpacesView(sv.EntryIno)
+			})
+		if err == nil {
+			publishStatus(relPath, entry.Inode, "archived")
 		}
-		l.Debug("soft-deleted", "path", relPath, "trashPath", trashPath)
-		return nil
+		return err
 	}
 
 	return nil
@@ -400,16 +740,25 @@ func p4(store *Store, entry *Entry, sv *SpacesView, relPath, spacesPath string,
 			l.Debug("skip: no entry", "path", relPath)
 			return nil
 		}
-		spInfo, err := os.Stat(spacesPath)
+		spInfo, err := fsFor(spacesPath).Stat(spacesPath)
 		if err != nil {
 			return fmt.Errorf("stat spaces: %w", err)
 		}
 		l.Info("creating spaces_view", "path", relPath, "inode", entry.Inode)
-		return store.UpsertSpacesView(SpacesView{
+		view := SpacesView{
 			EntryIno:    entry.Inode,
 			SyncedMtime: spInfo.ModTime().UnixNano(),
 			CheckedAt:   nowNano(),
-		})
+		}
+		if entry.Type != "dir" && !sameMtime(view.SyncedMtime, entry.Mtime, max(mtimeGrain(spacesPath), mtimeGrain(activeConfig.Load().ArchivesRoot))) {
+			// Not a copy of the Archives version (copies keep its mtime):
+			// record that version as synced, so the Spaces file is
+			// S_dirty and P2 syncs it like any Spaces edit.
+			l.Info("Spaces file differs from Archives, treating it as an edit", "path", relPath)
+			view.SyncedMtime = entry.Mtime
+		}
+		view.Cipher, view.Compression = spacesEncoding(spacesPath)
+		return store.UpsertSpacesView(view)
 	}
 
 	if !state.SDisk && state.SDb {
@@ -487,6 +836,35 @@ func resolveParentInoFromDB(store *Store, relPath string) (uint64, error) {
 	return parentIno, nil
 }
 
+// lookupPipeline is lookupDB with the entry's selection as the pipeline
+// acts on it: see foldDirSelection.
+func lookupPipeline(store *Store, archivesRoot, relPath string) (*Entry, *SpacesView, error) {
+	entry, sv, err := lookupDB(store, archivesRoot, relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := foldDirSelection(store, entry); err != nil {
+		return nil, nil, err
+	}
+	return entry, sv, nil
+}
+
+// foldDirSelection sets a directory entry's Selected from its descendants:
+// a directory holding selected entries stays in Spaces as their parent
+// even if not selected itself, and leaves once none is. Files and empty
+// directories keep their own flag.
+func foldDirSelection(store *Store, entry *Entry) error {
+	if entry == nil || entry.Type != "dir" {
+		return nil
+	}
+	state, err := store.SelectionState(entry.Inode)
+	if err != nil {
+		return err
+	}
+	entry.Selected = state != SelectionNone
+	return nil
+}
+
 // splitPath splits a relative path into its components.
 func splitPath(relPath string) []string {
 	var parts []string
@@ -501,11 +879,22 @@ func splitPath(relPath string) []string {
 }
 
 // statFile returns mtime, isDir, inode, size for a path.
-// All return nil if the file doesn't exist.
+// All return nil if the file doesn't exist or can't be stat'ed.
 func statFile(path string) (mtime *int64, isDir *bool, inode *uint64, size *int64) {
-	info, err := os.Stat(path)
+	mtime, isDir, inode, size, _ = statPath(path)
+	return
+}
+
+// statPath is statFile that also returns why a path that may exist could
+// not be stat'ed, e.g. an I/O error or a dropped remote, so it isn't
+// taken for absent. A missing path, or one below a file, is no error.
+func statPath(path string) (mtime *int64, isDir *bool, inode *uint64, size *int64, err error) {
+	info, err := fsFor(path).Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, nil, nil, nil, nil
+	}
 	if err != nil {
-		return nil, nil, nil, nil
+		return nil, nil, nil, nil, err
 	}
 
 	m := info.ModTime().UnixNano()
@@ -523,26 +912,62 @@ func statFile(path string) (mtime *int64, isDir *bool, inode *uint64, size *int6
 	return
 }
 
+// conflictWinner builds the entry and spaces_view for the Spaces version
+// copied into archivePath after a conflict.
+func conflictWinner(entry *Entry, archivePath, spacesPath string) (*Entry, *SpacesView, error) {
+	aInfo, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stat new archive: %w", err)
+	}
+	newStat, ok := aInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, nil, fmt.Errorf("failed to get inode for new archive")
+	}
+	winner := &Entry{
+		Inode:     newStat.Ino,
+		ParentIno: entry.ParentIno,
+		Name:      entry.Name,
+		Type:      entry.Type,
+		Size:      ptrInt64(aInfo.Size()),
+		Mtime:     aInfo.ModTime().UnixNano(),
+		Selected:  true,
+	}
+	var sv *SpacesView
+	if sInfo, err := fsFor(spacesPath).Stat(spacesPath); err == nil {
+		sv = &SpacesView{EntryIno: winner.Inode, SyncedMtime: sInfo.ModTime().UnixNano(), CheckedAt: nowNano()}
+		sv.Cipher, sv.Compression = spacesEncoding(spacesPath)
+	}
+	return winner, sv, nil
+}
+
 // updateEntryFromDisk refreshes entry mtime/size from Archives disk
-// and spaces_view from Spaces disk.
+// and spaces_view from Spaces disk, in one transaction.
 func updateEntryFromDisk(store *Store, entry *Entry, archivePath string, sv *SpacesView, spacesPath string) error {
 	aInfo, err := os.Stat(archivePath)
 	if err != nil {
 		return fmt.Errorf("stat archive: %w", err)
 	}
-	if err := store.UpdateEntryMtime(entry.Inode, aInfo.ModTime().UnixNano(), ptrInt64(aInfo.Size())); err != nil {
-		return fmt.Errorf("update entry: %w", err)
-	}
-
-	if sv != nil {
-		sInfo, err := os.Stat(spacesPath)
-		if err == nil {
-			sv.SyncedMtime = sInfo.ModTime().UnixNano()
-			sv.CheckedAt = nowNano()
-			if err := store.UpsertSpacesView(*sv); err != nil {
-				return fmt.Errorf("update spaces_view: %w", err)
-			}
+	err = store.WithTx(func(tx *TxStore) error {
+		if err := tx.UpdateEntryMtime(entry.Inode, aInfo.ModTime().UnixNano(), ptrInt64(aInfo.Size())); err != nil {
+			return fmt.Errorf("update entry: %w", err)
 		}
+		if sv == nil {
+			return nil
+		}
+		sInfo, err := fsFor(spacesPath).Stat(spacesPath)
+		if err != nil {
+			return nil
+		}
+		sv.SyncedMtime = sInfo.ModTime().UnixNano()
+		sv.CheckedAt = nowNano()
+		sv.Cipher, sv.Compression = spacesEncoding(spacesPath)
+		if err := tx.UpsertSpacesView(*sv); err != nil {
+			return fmt.Errorf("update spaces_view: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	sub("pipeline").Debug("entry refreshed from disk", "path", archivePath, "inode", entry.Inode)
 	return nil
@@ -550,6 +975,14 @@ func updateEntryFromDisk(store *Store, entry *Entry, archivePath string, sv *Spa
 
 func ptrInt64(v int64) *int64 { return &v }
 
+// sizeOrZero dereferences a nullable size (nil for directories).
+func sizeOrZero(size *int64) int64 {
+	if size == nil {
+		return 0
+	}
+	return *size
+}
+
 func nowNano() int64 {
 	return nowFunc().UnixNano()
 }
@@ -2,6 +2,7 @@ package sync
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -17,9 +18,10 @@ type pipelineEnv struct {
 	archivesRoot string
 	spacesRoot   string
 	trashRoot    string
+	clock        *fakeClock // stamps the files the env writes
 }
 
-func setupPipelineEnv(t *testing.T) *pipelineEnv {
+func setupPipelineEnv(t testing.TB) *pipelineEnv {
 	t.Helper()
 	dir := t.TempDir()
 	archivesRoot := filepath.Join(dir, "Archives")
@@ -34,27 +36,37 @@ func setupPipelineEnv(t *testing.T) *pipelineEnv {
 		archivesRoot: archivesRoot,
 		spacesRoot:   spacesRoot,
 		trashRoot:    trashRoot,
+		clock:        useFakeClock(t, time.Now()),
 	}
 }
 
-func (env *pipelineEnv) run(t *testing.T, relPath string) {
+func (env *pipelineEnv) run(t testing.TB, relPath string) {
 	t.Helper()
 	err := RunPipeline(context.Background(), relPath, env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil)
 	require.NoError(t, err)
 }
 
-func (env *pipelineEnv) writeArchive(t *testing.T, relPath string, content []byte) {
+func (env *pipelineEnv) writeArchive(t testing.TB, relPath string, content []byte) {
 	t.Helper()
-	p := filepath.Join(env.archivesRoot, relPath)
-	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
-	require.NoError(t, os.WriteFile(p, content, 0644))
+	env.write(t, filepath.Join(env.archivesRoot, relPath), content)
 }
 
-func (env *pipelineEnv) writeSpaces(t *testing.T, relPath string, content []byte) {
+func (env *pipelineEnv) writeSpaces(t testing.TB, relPath string, content []byte) {
 	t.Helper()
-	p := filepath.Join(env.spacesRoot, relPath)
-	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
-	require.NoError(t, os.WriteFile(p, content, 0644))
+	env.write(t, filepath.Join(env.spacesRoot, relPath), content)
+}
+
+// write puts content at path with the env clock's time as its mtime, so
+// files written without advancing the clock share an mtime, as copies
+// do, and a write after an Advance always looks modified.
+func (env *pipelineEnv) write(t testing.TB, path string, content []byte) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	if env.clock != nil {
+		now := env.clock.Now()
+		require.NoError(t, os.Chtimes(path, now, now))
+	}
 }
 
 func (env *pipelineEnv) fileExists(path string) bool {
@@ -165,7 +177,7 @@ func TestPipeline_SDirty(t *testing.T) {
 	require.NotNil(t, sv)
 
 	// Modify Spaces file (S_dirty)
-	time.Sleep(10 * time.Millisecond) // ensure different mtime
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "sync.txt", []byte("modified on spaces"))
 
 	// Run pipeline: should propagate S→A
@@ -190,7 +202,7 @@ func TestPipeline_ADirty(t *testing.T) {
 	entry := entries[0]
 
 	// Modify Archives (A_dirty)
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "file.txt", []byte("v2 from archives"))
 
 	// Run pipeline
@@ -230,7 +242,7 @@ func TestPipeline_P0Recovery(t *testing.T) {
 	assert.Equal(t, []byte("original"), got)
 }
 
-// P0 lost: A_disk=0, S_disk=0 → delete DB records
+// P0 lost: A_disk=0, S_disk=0 → tombstone DB records
 func TestPipeline_P0Lost(t *testing.T) {
 	env := setupPipelineEnv(t)
 
@@ -245,12 +257,30 @@ func TestPipeline_P0Lost(t *testing.T) {
 	// Delete from disk
 	os.Remove(filepath.Join(env.archivesRoot, "lost.txt"))
 
-	// Run pipeline: P0 should clean up DB
+	// Run pipeline: P0 should tombstone the entry
 	env.run(t, "lost.txt")
 
 	e, err := env.store.GetEntry(inode)
 	require.NoError(t, err)
-	assert.Nil(t, e, "entry should be deleted from DB")
+	assert.Nil(t, e, "tombstoned entry should be hidden")
+}
+
+// A new dir whose inode the disk reused from a deleted file is registered
+// as a dir, not taken for that file renamed.
+func TestPipeline_P1ReusedInode(t *testing.T) {
+	env := setupPipelineEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(env.archivesRoot, "d"), 0755))
+	_, _, inode, _ := statFile(filepath.Join(env.archivesRoot, "d"))
+	require.NotNil(t, inode)
+	require.NoError(t, env.store.UpsertEntry(Entry{Inode: *inode, Name: "gone.txt", Type: "text", Mtime: 1}))
+
+	env.run(t, "d")
+
+	e, err := env.store.GetEntry(*inode)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, "d", e.Name)
+	assert.Equal(t, "dir", e.Type)
 }
 
 // Demonstrates that UpdateEntryName handles rename correctly:
@@ -307,9 +337,9 @@ func TestPipeline_P2Conflict(t *testing.T) {
 	assert.Equal(t, "file.txt", entries[0].Name)
 
 	// Make both sides dirty
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeArchive(t, "file.txt", []byte("v2 from archives"))
-	time.Sleep(10 * time.Millisecond)
+	env.clock.Advance(time.Second)
 	env.writeSpaces(t, "file.txt", []byte("v2 from spaces"))
 
 	// Run pipeline → P2 conflict
@@ -374,3 +404,37 @@ func TestSplitPath(t *testing.T) {
 		})
 	}
 }
+
+// BenchmarkRunPipeline_Synced runs the pipeline on a file that is already
+// synced, at increasing depths: with nothing to do, the cost is the state
+// gathering, i.e. the stats and the DB lookup of the path.
+func BenchmarkRunPipeline_Synced(b *testing.B) {
+	for _, depth := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			env := setupPipelineEnv(b)
+			rel := "doc.txt"
+			for i := depth; i > 0; i-- {
+				rel = filepath.Join(fmt.Sprintf("d%d", i), rel)
+			}
+			env.writeArchive(b, rel, []byte("content"))
+			env.writeSpaces(b, rel, []byte("content"))
+			var dirs []string
+			for d := filepath.Dir(rel); d != "."; d = filepath.Dir(d) {
+				dirs = append([]string{d}, dirs...)
+			}
+			for _, d := range append(dirs, rel) {
+				env.run(b, d)
+			}
+			entry, sv, err := lookupPipeline(env.store, env.archivesRoot, rel)
+			require.NoError(b, err)
+			require.NotNil(b, entry)
+			require.NotNil(b, sv, "synced before timing")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := RunPipeline(context.Background(), rel, env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
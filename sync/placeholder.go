@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// hydrator serves placeholder stubs in Spaces: it intercepts the first
+// open of an armed stub and fills it from Archives before the open
+// returns, so readers never see the empty content.
+type hydrator interface {
+	arm(spacesPath string) error
+	Close() error
+}
+
+var (
+	hydratorMu     gosync.Mutex
+	activeHydrator hydrator // nil unless Config.Placeholders and supported
+)
+
+func setHydrator(h hydrator) {
+	hydratorMu.Lock()
+	defer hydratorMu.Unlock()
+	activeHydrator = h
+}
+
+func currentHydrator() hydrator {
+	hydratorMu.Lock()
+	defer hydratorMu.Unlock()
+	return activeHydrator
+}
+
+// createPlaceholder writes a stub for archivePath at spacesPath: a sparse
+// file with the Archives size and mtime, armed so its first open hydrates
+// it. Returns false, leaving nothing behind, when no hydrator is running,
+// the file is empty or arming fails; the caller copies instead.
+func createPlaceholder(store *Store, inode uint64, archivePath, spacesPath string) (bool, error) {
+	h := currentHydrator()
+	if h == nil {
+		return false, nil
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return false, fmt.Errorf("stat archive: %w", err)
+	}
+	if info.Size() == 0 || !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(spacesPath), 0755); err != nil {
+		return false, fmt.Errorf("mkdir: %w", err)
+	}
+	f, err := os.OpenFile(spacesPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return false, fmt.Errorf("create stub: %w", err)
+	}
+	err = f.Truncate(info.Size())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(spacesPath, time.Now(), info.ModTime())
+	}
+	if err == nil {
+		err = h.arm(spacesPath)
+	}
+	if err == nil {
+		err = store.MarkPlaceholder(inode)
+	}
+	if err != nil {
+		os.Remove(spacesPath) //nolint:errcheck
+		sub("P3").Warn("placeholder failed, copying instead", "path", spacesPath, "err", err)
+		return false, nil
+	}
+	sub("P3").Debug("placeholder created", "path", spacesPath, "size", info.Size())
+	return true, nil
+}
+
+// startHydrator starts on-demand hydration when Config.Placeholders is
+// on, re-arming the stubs left by the previous run. Without fanotify
+// permission events (non-Linux, no CAP_SYS_ADMIN) selected files are
+// copied in full as usual.
+func (d *Daemon) startHydrator(ctx context.Context) {
+	l := sub("daemon")
+	if !activeConfig.Load().Placeholders {
+		return
+	}
+	h, err := newHydrator(d.store, d.archivesRoot, d.spacesRoot)
+	if err != nil {
+		l.Warn("placeholders unavailable, selected files are copied in full", "err", err)
+		return
+	}
+	d.restorePlaceholders(h)
+	setHydrator(h)
+	go func() {
+		<-ctx.Done()
+		setHydrator(nil)
+		h.Close() //nolint:errcheck
+	}()
+	l.Info("placeholder hydration started")
+}
+
+// restorePlaceholders arms the stubs recorded in the DB with h. Entries
+// whose Spaces file is no longer a stub are forgotten.
+func (d *Daemon) restorePlaceholders(h hydrator) {
+	l := sub("daemon")
+	entries, err := d.store.ListPlaceholders()
+	if err != nil {
+		l.Error("list placeholders failed", "err", err)
+		return
+	}
+	var armed int
+	for i := range entries {
+		spacesPath := filepath.Join(d.spacesRoot, d.store.RelPath(&entries[i]))
+		if isStub(spacesPath) {
+			if err := h.arm(spacesPath); err == nil {
+				armed++
+				continue
+			}
+		}
+		if err := d.store.ClearPlaceholder(entries[i].Inode); err != nil {
+			l.Warn("clear placeholder failed", "path", spacesPath, "err", err)
+		}
+	}
+	l.Info("placeholders restored", "armed", armed, "dropped", len(entries)-armed)
+}
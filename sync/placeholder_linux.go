@@ -0,0 +1,195 @@
+package sync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyHydrator hydrates stubs from FAN_OPEN_PERM events on inode
+// marks, one per armed stub. The kernel opens each event's fd read-write
+// without generating events, so the stub is filled through it; the
+// opener is let through once the content is in place, or gets EPERM if
+// Archives can't be read.
+type fanotifyHydrator struct {
+	f            *os.File
+	fd           int
+	store        *Store
+	archivesRoot string
+	spacesReal   string // Spaces root with symlinks resolved, as event paths are
+}
+
+// newHydrator needs Linux with fanotify permission events and
+// CAP_SYS_ADMIN.
+func newHydrator(store *Store, archivesRoot, spacesRoot string) (hydrator, error) {
+	spacesReal, err := filepath.EvalSymlinks(spacesRoot)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_CONTENT|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDWR|unix.O_LARGEFILE)
+	if err != nil {
+		return nil, fmt.Errorf("fanotify_init: %w", err)
+	}
+	h := &fanotifyHydrator{
+		f:            os.NewFile(uintptr(fd), "fanotify-hydrate"),
+		fd:           fd,
+		store:        store,
+		archivesRoot: archivesRoot,
+		spacesReal:   spacesReal,
+	}
+	go h.readLoop()
+	return h, nil
+}
+
+func (h *fanotifyHydrator) arm(spacesPath string) error {
+	if err := unix.FanotifyMark(h.fd, unix.FAN_MARK_ADD, unix.FAN_OPEN_PERM, unix.AT_FDCWD, spacesPath); err != nil {
+		return fmt.Errorf("fanotify_mark %s: %w", spacesPath, err)
+	}
+	return nil
+}
+
+func (h *fanotifyHydrator) Close() error {
+	return h.f.Close()
+}
+
+// readLoop answers permission events until Close. Each open is served on
+// its own goroutine so a large file doesn't hold up other opens.
+func (h *fanotifyHydrator) readLoop() {
+	l := sub("hydrate")
+	const metaLen = 24 // sizeof(struct fanotify_event_metadata)
+	buf := make([]byte, 4096)
+	for {
+		n, err := h.f.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			l.Error("fanotify read failed", "err", err)
+			return
+		}
+		for b := buf[:n]; len(b) >= metaLen; {
+			evLen := int(binary.NativeEndian.Uint32(b[0:4]))
+			if evLen < metaLen || evLen > len(b) {
+				l.Error("fanotify: malformed event")
+				break
+			}
+			mask := binary.NativeEndian.Uint64(b[8:16])
+			fd := int(int32(binary.NativeEndian.Uint32(b[16:20])))
+			b = b[evLen:]
+			if fd < 0 {
+				continue
+			}
+			if mask&unix.FAN_OPEN_PERM == 0 {
+				unix.Close(fd) //nolint:errcheck
+				continue
+			}
+			go h.serve(fd)
+		}
+	}
+}
+
+// serve hydrates the stub behind fd and answers the permission event.
+func (h *fanotifyHydrator) serve(fd int) {
+	defer unix.Close(fd) //nolint:errcheck
+	response := uint32(unix.FAN_ALLOW)
+	if err := h.hydrate(fd); err != nil {
+		sub("hydrate").Error("hydration failed, open denied", "err", err)
+		response = unix.FAN_DENY
+	}
+	var resp [8]byte // struct fanotify_response
+	binary.NativeEndian.PutUint32(resp[0:4], uint32(int32(fd)))
+	binary.NativeEndian.PutUint32(resp[4:8], response)
+	if _, err := h.f.Write(resp[:]); err != nil && !errors.Is(err, os.ErrClosed) {
+		sub("hydrate").Error("fanotify response failed", "err", err)
+	}
+}
+
+// hydrate copies the Archives file into the stub open at fd, keeping the
+// stub's mtime so the pipeline sees no Spaces change, then disarms it.
+func (h *fanotifyHydrator) hydrate(fd int) error {
+	l := sub("hydrate")
+	proc := fmt.Sprintf("/proc/self/fd/%d", fd)
+	spacesPath, err := os.Readlink(proc)
+	if err != nil {
+		return fmt.Errorf("resolve event fd: %w", err)
+	}
+	relPath, ok := strings.CutPrefix(spacesPath, h.spacesReal+string(filepath.Separator))
+	if !ok {
+		return nil // not ours; let it open
+	}
+	defer unix.FanotifyMark(h.fd, unix.FAN_MARK_REMOVE, unix.FAN_OPEN_PERM, unix.AT_FDCWD, proc) //nolint:errcheck
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("fstat %s: %w", relPath, err)
+	}
+	if st.Blocks == 0 && st.Size > 0 {
+		src, err := os.Open(filepath.Join(h.archivesRoot, relPath))
+		if err != nil {
+			return fmt.Errorf("open archive: %w", err)
+		}
+		defer src.Close()
+		n, err := copyToFD(fd, src)
+		if err != nil {
+			return fmt.Errorf("hydrate %s: %w", relPath, err)
+		}
+		if n != st.Size {
+			if err := unix.Ftruncate(fd, n); err != nil {
+				return fmt.Errorf("truncate %s: %w", relPath, err)
+			}
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, proc, []unix.Timespec{st.Atim, st.Mtim}, 0); err != nil {
+			return fmt.Errorf("restore mtime %s: %w", relPath, err)
+		}
+		l.Info("hydrated", "path", relPath, "bytes", n)
+	}
+
+	entry, _, err := lookupDB(h.store, h.archivesRoot, relPath)
+	if err == nil && entry != nil {
+		err = h.store.ClearPlaceholder(entry.Inode)
+	}
+	if err != nil {
+		l.Warn("clear placeholder failed", "path", relPath, "err", err)
+	}
+	return nil
+}
+
+// copyToFD writes src to fd from offset 0. The event fd is used directly
+// because wrapping it in an os.File would close it behind serve's back.
+func copyToFD(fd int, src io.Reader) (int64, error) {
+	buf := make([]byte, activeConfig.Load().CopyChunkSize)
+	var off int64
+	for {
+		n, rerr := src.Read(buf)
+		for p := buf[:n]; len(p) > 0; {
+			w, err := unix.Pwrite(fd, p, off)
+			if err != nil {
+				return off, err
+			}
+			p = p[w:]
+			off += int64(w)
+		}
+		if rerr == io.EOF {
+			return off, nil
+		}
+		if rerr != nil {
+			return off, rerr
+		}
+	}
+}
+
+// isStub reports whether path is an unhydrated placeholder: a non-empty
+// regular file with no blocks allocated.
+func isStub(path string) bool {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return false
+	}
+	return st.Mode&unix.S_IFMT == unix.S_IFREG && st.Size > 0 && st.Blocks == 0
+}
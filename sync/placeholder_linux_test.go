@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestHydrator installs a hydrator for the test, skipping where
+// fanotify permission events aren't available.
+func startTestHydrator(t *testing.T, store *Store, archivesRoot, spacesRoot string) hydrator {
+	t.Helper()
+	h, err := newHydrator(store, archivesRoot, spacesRoot)
+	if err != nil {
+		t.Skipf("fanotify hydration unavailable: %v", err)
+	}
+	setHydrator(h)
+	t.Cleanup(func() {
+		setHydrator(nil)
+		h.Close() //nolint:errcheck
+	})
+	return h
+}
+
+func TestPlaceholder_HydratesOnOpen(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	startTestHydrator(t, store, archivesRoot, spacesRoot)
+	a := registered(t, store, archivesRoot, "Docs/a.txt")
+	trash := h.daemon.trashRoot
+
+	require.NoError(t, store.SetSelected([]uint64{a.Inode}, true))
+	require.NoError(t, RunPipeline(context.Background(), "Docs/a.txt", store, archivesRoot, spacesRoot, trash, nil))
+
+	sPath := filepath.Join(spacesRoot, "Docs/a.txt")
+	require.True(t, isStub(sPath), "selected file starts as a stub")
+	stub, err := store.IsPlaceholder(a.Inode)
+	require.NoError(t, err)
+	assert.True(t, stub)
+	before, err := os.Stat(sPath)
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(sPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Docs/a.txt", string(got))
+	assert.False(t, isStub(sPath))
+	stub, err = store.IsPlaceholder(a.Inode)
+	require.NoError(t, err)
+	assert.False(t, stub, "hydration clears the placeholder")
+
+	after, err := os.Stat(sPath)
+	require.NoError(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime(), "no Spaces change for the pipeline to pick up")
+	require.NoError(t, RunPipeline(context.Background(), "Docs/a.txt", store, archivesRoot, spacesRoot, trash, nil))
+	got, _ = os.ReadFile(filepath.Join(archivesRoot, "Docs/a.txt"))
+	assert.Equal(t, "Docs/a.txt", string(got))
+}
+
+func TestPlaceholder_DeselectRemovesStub(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	startTestHydrator(t, store, archivesRoot, spacesRoot)
+	a := registered(t, store, archivesRoot, "Docs/a.txt")
+	trash := h.daemon.trashRoot
+
+	require.NoError(t, store.SetSelected([]uint64{a.Inode}, true))
+	require.NoError(t, RunPipeline(context.Background(), "Docs/a.txt", store, archivesRoot, spacesRoot, trash, nil))
+	require.True(t, isStub(filepath.Join(spacesRoot, "Docs/a.txt")))
+
+	require.NoError(t, store.SetSelected([]uint64{a.Inode}, false))
+	require.NoError(t, RunPipeline(context.Background(), "Docs/a.txt", store, archivesRoot, spacesRoot, trash, nil))
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "Docs/a.txt"))
+	assert.NoDirExists(t, trash, "stubs are not soft-deleted")
+	stub, err := store.IsPlaceholder(a.Inode)
+	require.NoError(t, err)
+	assert.False(t, stub)
+}
+
+func TestPlaceholder_RestoreRearms(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/b.txt"}, nil)
+	first := startTestHydrator(t, store, archivesRoot, spacesRoot)
+	a := registered(t, store, archivesRoot, "Docs/a.txt")
+	b := registered(t, store, archivesRoot, "Docs/b.txt")
+	trash := h.daemon.trashRoot
+
+	require.NoError(t, store.SetSelected([]uint64{a.Inode, b.Inode}, true))
+	for _, p := range []string{"Docs/a.txt", "Docs/b.txt"} {
+		require.NoError(t, RunPipeline(context.Background(), p, store, archivesRoot, spacesRoot, trash, nil))
+	}
+
+	// Restart: the old marks go with the old fanotify group, and b's stub
+	// was replaced meanwhile.
+	setHydrator(nil)
+	require.NoError(t, first.Close())
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Docs/b.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "Docs/b.txt"), []byte("real"), 0644))
+
+	second := startTestHydrator(t, store, archivesRoot, spacesRoot)
+	h.daemon.restorePlaceholders(second)
+
+	got, err := os.ReadFile(filepath.Join(spacesRoot, "Docs/a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Docs/a.txt", string(got))
+	stub, err := store.IsPlaceholder(b.Inode)
+	require.NoError(t, err)
+	assert.False(t, stub, "no longer a stub on disk")
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sync
+
+import "errors"
+
+// newHydrator fails: on-demand hydration needs Linux fanotify.
+func newHydrator(*Store, string, string) (hydrator, error) {
+	return nil, errors.New("placeholders need fanotify permission events (Linux)")
+}
+
+// isStub returns false: stubs are only created on Linux.
+func isStub(string) bool {
+	return false
+}
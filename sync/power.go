@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"net/http"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+)
+
+// In low-power mode the daemon stretches its timers by lowPowerStretch
+// once no user has acted for Config.LowPowerIdleMinutes, and leaves work
+// nobody asked for queued while the roots' disks are asleep, so a laptop
+// can spin its disk down. Any user action ends the idle spell.
+const lowPowerStretch = 10
+
+// lowPowerRecheck is how often deferred work looks whether the disks
+// were woken by something else, or the idle spell ended.
+var lowPowerRecheck = 30 * time.Second
+
+// powerState tracks user activity for low-power mode.
+type powerState struct {
+	lastActive atomic.Int64 // unix nanoseconds of the last user action
+
+	mu    gosync.Mutex
+	roots []string
+	wake  func() // releases deferred work, see watch
+}
+
+// power is the host's power state; the running daemon registers with it.
+var power = &powerState{}
+
+// diskActive reports whether the disk holding path is known to be spun
+// up. Replaced in tests.
+var diskActive = sysDiskActive
+
+// watch registers the roots whose disks deferred work needs awake, and
+// wake, called on every user action. It counts as one itself. The
+// returned func undoes the registration.
+func (p *powerState) watch(roots []string, wake func()) func() {
+	p.mu.Lock()
+	p.roots, p.wake = roots, wake
+	p.mu.Unlock()
+	p.touch()
+	return func() {
+		p.mu.Lock()
+		p.roots, p.wake = nil, nil
+		p.mu.Unlock()
+	}
+}
+
+// touch records a user action.
+func (p *powerState) touch() {
+	p.lastActive.Store(nowFunc().UnixNano())
+	p.mu.Lock()
+	wake := p.wake
+	p.mu.Unlock()
+	if wake != nil {
+		wake()
+	}
+}
+
+// idle reports whether low-power mode is on and no user has acted for
+// Config.LowPowerIdleMinutes.
+func (p *powerState) idle() bool {
+	cfg := currentConfig()
+	if !cfg.LowPower {
+		return false
+	}
+	since := time.Duration(nowFunc().UnixNano() - p.lastActive.Load())
+	return since >= time.Duration(cfg.LowPowerIdleMinutes)*time.Minute
+}
+
+// stretch returns d, lengthened while idle.
+func (p *powerState) stretch(d time.Duration) time.Duration {
+	if p.idle() {
+		return d * lowPowerStretch
+	}
+	return d
+}
+
+// deferWork reports whether queued work nobody asked for should wait:
+// while idle, unless every root's disk is already spinning.
+func (p *powerState) deferWork() bool {
+	if !p.idle() {
+		return false
+	}
+	p.mu.Lock()
+	roots := p.roots
+	p.mu.Unlock()
+	for _, root := range roots {
+		if !diskActive(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackActivity is middleware marking requests as user actions for
+// low-power mode: everything but GETs, and GETs that read file content.
+// The spoke protocol is machine traffic and doesn't count.
+func (h *Handlers) TrackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if !strings.Contains(p, "/sync/spoke/") &&
+			(r.Method != http.MethodGet || strings.HasSuffix(p, "/sync/download") || strings.Contains(p, "/sync/content/")) {
+			power.touch()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
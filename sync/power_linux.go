@@ -0,0 +1,32 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysDiskActive reads the runtime power state of the block device holding
+// path from sysfs. A disk that doesn't report one, or a path not on a
+// block device, counts as asleep: low-power mode can't tell it's safe.
+func sysDiskActive(path string) bool {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return false
+	}
+	dev, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev)))
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(dev, "partition")); err == nil {
+		dev = filepath.Dir(dev) // the disk the partition is on
+	}
+	status, err := os.ReadFile(filepath.Join(dev, "device", "power", "runtime_status"))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(status)) == "active"
+}
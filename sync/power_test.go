@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupLowPower enables low-power mode with a fake clock and disk state.
+func setupLowPower(t *testing.T) (advance func(time.Duration), spinning *atomic.Bool) {
+	t.Helper()
+	withConfig(t, func(c *Config) { c.LowPower, c.LowPowerIdleMinutes = true, 10 })
+
+	clk := useFakeClock(t, time.Now())
+	origDisk := diskActive
+	spinning = &atomic.Bool{}
+	t.Cleanup(func() { diskActive = origDisk })
+	diskActive = func(string) bool { return spinning.Load() }
+	t.Cleanup(power.watch([]string{"/archives", "/spaces"}, nil))
+	return clk.Advance, spinning
+}
+
+func TestPower_IdleStretchesTimers(t *testing.T) {
+	advance, _ := setupLowPower(t)
+	assert.False(t, power.idle())
+	assert.Equal(t, time.Second, power.stretch(time.Second))
+
+	advance(11 * time.Minute)
+	assert.True(t, power.idle())
+	assert.Equal(t, lowPowerStretch*time.Second, power.stretch(time.Second))
+
+	power.touch()
+	assert.False(t, power.idle())
+
+	advance(11 * time.Minute)
+	cfg := currentConfig()
+	cfg.LowPower = false
+	require.NoError(t, setConfig(cfg))
+	assert.False(t, power.idle(), "only in low-power mode")
+}
+
+func TestPower_DefersWorkWhileDisksSleep(t *testing.T) {
+	advance, spinning := setupLowPower(t)
+	assert.False(t, power.deferWork(), "a user is active")
+	advance(11 * time.Minute)
+	assert.True(t, power.deferWork())
+	spinning.Store(true)
+	assert.False(t, power.deferWork(), "the disks are up anyway")
+}
+
+func TestEvalQueue_HoldPopsPromotedOnly(t *testing.T) {
+	q := NewEvalQueue()
+	var held atomic.Bool
+	held.Store(true)
+	q.SetHold(held.Load)
+	q.Push("background.txt")
+	q.Push("user.txt")
+	q.Promote("user.txt")
+
+	done := make(chan struct{})
+	defer close(done)
+	path, ok := q.Pop(done)
+	require.True(t, ok)
+	assert.Equal(t, "user.txt", path)
+
+	popped := make(chan string, 1)
+	go func() {
+		p, _ := q.Pop(done)
+		popped <- p
+	}()
+	select {
+	case p := <-popped:
+		t.Fatalf("held path %q popped", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+	held.Store(false)
+	q.Wake()
+	select {
+	case p := <-popped:
+		assert.Equal(t, "background.txt", p)
+	case <-time.After(time.Second):
+		t.Fatal("path not released")
+	}
+}
+
+func TestPower_UserActionReleasesQueue(t *testing.T) {
+	advance, _ := setupLowPower(t)
+	q := NewEvalQueue()
+	q.SetHold(power.deferWork)
+	t.Cleanup(power.watch([]string{"/archives"}, q.Wake))
+	advance(11 * time.Minute)
+	q.Push("a.txt")
+
+	done := make(chan struct{})
+	defer close(done)
+	popped := make(chan string, 1)
+	go func() {
+		p, _ := q.Pop(done)
+		popped <- p
+	}()
+	select {
+	case p := <-popped:
+		t.Fatalf("deferred path %q popped", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h := &Handlers{}
+	h.TrackActivity(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/sync/select", nil))
+	select {
+	case p := <-popped:
+		assert.Equal(t, "a.txt", p)
+	case <-time.After(time.Second):
+		t.Fatal("user action didn't release the queue")
+	}
+}
+
+func TestTrackActivity(t *testing.T) {
+	advance, _ := setupLowPower(t)
+	h := &Handlers{}
+	next := http.NotFoundHandler()
+	for _, tc := range []struct {
+		method, path string
+		user         bool
+	}{
+		{"GET", "/api/sync/stats", false},
+		{"GET", "/api/sync/events", false},
+		{"POST", "/api/sync/spoke/report", false},
+		{"GET", "/api/sync/spoke/content/5", false},
+		{"POST", "/api/sync/select", true},
+		{"PATCH", "/api/sync/config", true},
+		{"GET", "/api/sync/download", true},
+		{"GET", "/api/sync/content/5", true},
+	} {
+		advance(11 * time.Minute)
+		require.True(t, power.idle())
+		h.TrackActivity(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.user, !power.idle(), "%s %s", tc.method, tc.path)
+	}
+}
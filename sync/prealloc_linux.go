@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes for f and extends it to that size. On
+// file systems without fallocate it only extends f; running out of space
+// is an error either way.
+func preallocate(f *os.File, size int64) error {
+	err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == nil || isNoSpace(err) {
+		return err
+	}
+	return f.Truncate(size)
+}
+
+// reserve reserves size bytes of f from off without changing its size,
+// so appends land in space already allocated. File systems without
+// fallocate reserve nothing.
+func reserve(f *os.File, off, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	if err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, off, size); isNoSpace(err) {
+		return err
+	}
+	return nil
+}
+
+// isNoSpace reports whether err is the disk or the quota running out.
+func isNoSpace(err error) bool {
+	return errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.EDQUOT)
+}
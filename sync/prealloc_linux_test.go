@@ -0,0 +1,38 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReserve_KeepsSize(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "f.sync-tmp"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, reserve(f, 0, 4<<20))
+	info, err := f.Stat()
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "appends still start at the end")
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Blocks == 0 {
+		t.Skip("file system doesn't support fallocate")
+	}
+	assert.GreaterOrEqual(t, st.Blocks*512, int64(4<<20))
+}
+
+// TestIsNoSpace checks the errors reserve hands back. Actually running a
+// disk out of space would do so for everything else running on it.
+func TestIsNoSpace(t *testing.T) {
+	assert.True(t, isNoSpace(&os.PathError{Op: "fallocate", Path: "f", Err: unix.ENOSPC}))
+	assert.True(t, isNoSpace(fmt.Errorf("reserve tmp: %w", unix.EDQUOT)))
+	assert.False(t, isNoSpace(unix.EOPNOTSUPP))
+	assert.False(t, isNoSpace(nil))
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sync
+
+import "os"
+
+// preallocate extends f to size bytes.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+// reserve does nothing: space is only reserved ahead on Linux.
+func reserve(f *os.File, off, size int64) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// A problems view lists the entries in a troubled state wherever they are
+// in the tree. Statuses aren't stored but computed from both disks, so
+// GET /api/sync/entries?status= walks each root once into a pathIndex,
+// as a full reconcile does, and judges every registered entry against
+// them; only the matches are stat'ed again to build their responses.
+
+// problemStatuses are the statuses the entries listing filters by.
+var problemStatuses = map[string]bool{
+	"conflict":   true,
+	"recovering": true,
+	"lost":       true,
+	"repairing":  true,
+}
+
+const (
+	defaultStatusLimit = 500
+	maxStatusLimit     = 5000
+)
+
+// errStatusLimit stops the tree walk once enough entries matched.
+var errStatusLimit = errors.New("status limit reached")
+
+// statusMatch is an entry found in the status listed.
+type statusMatch struct {
+	entry   Entry
+	relPath string
+}
+
+// entriesInStatus returns up to limit entries at or under the directory
+// rootIno at rootPath whose status is status, and whether more matched.
+func (h *Handlers) entriesInStatus(rootIno uint64, rootPath, status string, limit int) ([]statusMatch, bool, error) {
+	archives, spaces, err := h.daemon.reconcileIndexes()
+	if err != nil {
+		return nil, false, err
+	}
+	views, err := h.store.ListSpacesViews()
+	if err != nil {
+		return nil, false, err
+	}
+	var matches []statusMatch
+	truncated := false
+	err = h.store.walkTree(rootIno, rootPath, func(e *Entry, relPath string) (bool, error) {
+		var sv *SpacesView
+		if v, ok := views[e.Inode]; ok {
+			sv = &v
+		}
+		if ComputeState(e, sv, archives.mtime(relPath), spaces.mtime(relPath)).UIStatus() != status {
+			return true, nil
+		}
+		if len(matches) == limit {
+			truncated = true
+			return false, errStatusLimit
+		}
+		matches = append(matches, statusMatch{*e, relPath})
+		return true, nil
+	})
+	if errors.Is(err, errTreeTruncated) {
+		truncated = true
+	} else if err != nil && !errors.Is(err, errStatusLimit) {
+		return nil, false, err
+	}
+	return matches, truncated, nil
+}
+
+// listEntriesInStatus handles GET /api/sync/entries?status=<status>
+// Lists the entries in status across the tree, or under the directory
+// given by path or parent_ino: up to limit (default 500, at most 5000),
+// with truncated set if there were more.
+func (h *Handlers) listEntriesInStatus(w http.ResponseWriter, r *http.Request, parentIno uint64, status string) {
+	l := sub("handlers")
+	if !problemStatuses[status] {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	limit := defaultStatusLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxStatusLimit)
+	}
+	matches, truncated, err := h.entriesInStatus(parentIno, h.resolveRelPathFromIno(parentIno), status, limit)
+	if err != nil {
+		l.Error("list entries by status failed", "status", status, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]SyncEntryResponse, 0, len(matches))
+	for i := range matches {
+		m := &matches[i]
+		item, err := h.entryResponse(&m.entry, m.relPath, q.Get("metadata") == "1")
+		if err != nil {
+			l.Error("list entries: tags failed", "inode", m.entry.Inode, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status may have moved on since the roots were walked.
+		if item.Status != status || !hasAllTags(item.Tags, q["tag"]) {
+			continue
+		}
+		items = append(items, item)
+	}
+	h.fillSelection(items)
+	l.Debug("list entries by status response", "status", status, "count", len(items), "truncated", truncated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": items, "truncated": truncated}) //nolint:errcheck
+}
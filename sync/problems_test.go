@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listInStatus(t *testing.T, h *Handlers, query string) ([]SyncEntryResponse, bool) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?"+query, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Items     []SyncEntryResponse `json:"items"`
+		Truncated bool                `json:"truncated"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Items, resp.Truncated
+}
+
+func itemPaths(items []SyncEntryResponse) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = item.Path
+	}
+	return out
+}
+
+func TestHandleListEntries_ByStatus(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	files := []string{"Docs/deep/lost.txt", "Docs/deep/ok.txt", "Docs/lost.txt", "Media/gone.jpg", "Media/both.jpg"}
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		append([]string{"Docs/", "Docs/deep/", "Media/"}, files...), map[string]bool{"Media/gone.jpg": true, "Media/both.jpg": true})
+	media, err := h.resolvePathToIno("Media")
+	require.NoError(t, err)
+	require.NoError(t, store.SetSelected([]uint64{media}, true))
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Media/gone.jpg", "Media/both.jpg"}, nil)
+
+	require.NoError(t, os.Remove(filepath.Join(archivesRoot, "Docs/deep/lost.txt")))
+	require.NoError(t, os.Remove(filepath.Join(archivesRoot, "Docs/lost.txt")))
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Media/gone.jpg")))
+	later := time.Now().Add(time.Hour)
+	for _, root := range []string{archivesRoot, spacesRoot} {
+		require.NoError(t, os.Chtimes(filepath.Join(root, "Media/both.jpg"), later, later))
+	}
+
+	items, truncated := listInStatus(t, h, "status=lost")
+	assert.ElementsMatch(t, []string{"Docs/deep/lost.txt", "Docs/lost.txt"}, itemPaths(items))
+	assert.False(t, truncated)
+	assert.Equal(t, "lost", items[0].Status)
+	items, _ = listInStatus(t, h, "status=repairing")
+	assert.Equal(t, []string{"Media/gone.jpg"}, itemPaths(items))
+	items, _ = listInStatus(t, h, "status=conflict")
+	assert.Equal(t, []string{"Media/both.jpg"}, itemPaths(items))
+	items, _ = listInStatus(t, h, "status=recovering")
+	assert.Empty(t, items)
+
+	items, _ = listInStatus(t, h, "status=lost&path=Docs/deep")
+	assert.Equal(t, []string{"Docs/deep/lost.txt"}, itemPaths(items))
+	items, truncated = listInStatus(t, h, "status=lost&limit=1")
+	assert.Len(t, items, 1)
+	assert.True(t, truncated)
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries?status=synced", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,66 +1,168 @@
 package sync
 
 import (
+	"container/heap"
+	"context"
 	"log/slog"
+	"sort"
 	gosync "sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueueOrder selects the order in which EvalQueue pops paths.
+type QueueOrder string
+
+const (
+	// OrderFIFO pops paths in the order they were pushed.
+	OrderFIFO QueueOrder = "fifo"
+	// OrderSmallFirst pops the smallest files first, favouring responsiveness.
+	OrderSmallFirst QueueOrder = "small-first"
+	// OrderLargeFirst pops the largest files first, favouring throughput.
+	OrderLargeFirst QueueOrder = "large-first"
 )
 
+// valid reports whether o is a known ordering policy.
+func (o QueueOrder) valid() bool {
+	return o == OrderFIFO || o == OrderSmallFirst || o == OrderLargeFirst
+}
+
+// Job is one unit of work popped from the EvalQueue.
+type Job struct {
+	Path  string
+	From  string            // set for a rename paired by the watcher: the old path
+	Trace trace.SpanContext // span of the request that queued the path, if any
+
+	QueuedAt int64 // nanoseconds, when the path was queued
+}
+
+// queueItem is a queued path with the metadata used for ordering.
+type queueItem struct {
+	path     string
+	from     string // old path of a paired rename, see PushMove
+	promoted bool   // pops before everything not promoted, see Promote
+	seq      uint64 // push order
+	size     int64  // file size, 0 if unknown
+	isDir    bool
+	trace    trace.SpanContext // see PushTraced
+	queuedAt int64             // nanoseconds, first push since the last pop
+	attempts int               // earlier runs of the path that failed, see Finished
+	index    int               // heap index
+}
+
 // EvalQueue is a thread-safe set-based queue of relative paths to evaluate.
-// Duplicates are automatically deduplicated. Pop returns paths in FIFO order.
+// Duplicates are automatically deduplicated. Promoted paths pop first;
+// otherwise pop order follows Config.QueueOrder (FIFO by default); with a
+// size-based order, directories pop before files (in push order) so
+// parents are registered before children.
 type EvalQueue struct {
 	mu     gosync.Mutex
-	set    map[string]struct{}
-	order  []string
+	set    map[string]*queueItem
+	items  itemHeap
+	seq    uint64
+	sizer  func(path string) (size int64, isDir bool)
+	hold   func() bool   // while true, only promoted paths pop
 	notify chan struct{} // signaled when items are added
+
+	failures map[string]int // failed runs per path since its last success
 }
 
 // NewEvalQueue creates a new eval queue.
 func NewEvalQueue() *EvalQueue {
 	return &EvalQueue{
-		set:    make(map[string]struct{}),
-		notify: make(chan struct{}, 1),
+		set:      make(map[string]*queueItem),
+		items:    itemHeap{order: OrderFIFO},
+		notify:   make(chan struct{}, 1),
+		failures: make(map[string]int),
 	}
 }
 
+// SetSizer installs the function used to size paths pushed without
+// size information. It is only consulted for non-FIFO orders.
+func (q *EvalQueue) SetSizer(sizer func(path string) (int64, bool)) {
+	q.mu.Lock()
+	q.sizer = sizer
+	q.mu.Unlock()
+}
+
+// SetHold installs a check that holds back unpromoted paths while it
+// returns true, e.g. to leave a sleeping disk alone in low-power mode.
+// Held paths pop once it returns false, checked on every push and
+// Wake and every lowPowerRecheck.
+func (q *EvalQueue) SetHold(hold func() bool) {
+	q.mu.Lock()
+	q.hold = hold
+	q.mu.Unlock()
+}
+
+// Wake makes a blocked Pop look at the queue again, e.g. when a hold
+// may have ended.
+func (q *EvalQueue) Wake() {
+	q.signal()
+}
+
 // Push adds a path to the queue. If the path is already queued, this is a no-op.
 func (q *EvalQueue) Push(path string) {
+	size, isDir := q.sizeOf(path)
+	q.PushSized(path, size, isDir)
+}
+
+// PushSized adds a path with known size information.
+// If the path is already queued, this is a no-op.
+func (q *EvalQueue) PushSized(path string, size int64, isDir bool) {
+	q.PushTraced(context.Background(), path, size, isDir)
+}
+
+// PushTraced is PushSized from a traced request: the job carries ctx's
+// span, so the pipeline run it leads to joins the request's trace. A path
+// already queued takes the span if it has none yet.
+func (q *EvalQueue) PushTraced(ctx context.Context, path string, size int64, isDir bool) {
+	sc := trace.SpanContextFromContext(ctx)
 	q.mu.Lock()
-	if _, exists := q.set[path]; exists {
+	if it, exists := q.set[path]; exists {
+		if !it.trace.IsValid() {
+			it.trace = sc
+		}
 		q.mu.Unlock()
 		if logEnabled(slog.LevelDebug) {
 			sub("queue").Debug("push dedup", "path", path)
 		}
 		return
 	}
-	q.set[path] = struct{}{}
-	q.order = append(q.order, path)
-	newLen := len(q.order)
+	q.pushLocked(path, size, isDir)
+	q.set[path].trace = sc
+	newLen := len(q.items.list)
 	q.mu.Unlock()
 
 	if logEnabled(slog.LevelDebug) {
 		sub("queue").Debug("push", "path", path, "queueLen", newLen)
 	}
 
-	// Non-blocking signal
-	select {
-	case q.notify <- struct{}{}:
-	default:
-	}
+	q.signal()
 }
 
 // PushMany adds multiple paths to the queue.
 func (q *EvalQueue) PushMany(paths []string) {
+	type sized struct {
+		size  int64
+		isDir bool
+	}
+	sizes := make([]sized, len(paths))
+	for i, path := range paths {
+		sizes[i].size, sizes[i].isDir = q.sizeOf(path)
+	}
+
 	q.mu.Lock()
 	added := 0
-	for _, path := range paths {
+	for i, path := range paths {
 		if _, exists := q.set[path]; exists {
 			continue
 		}
-		q.set[path] = struct{}{}
-		q.order = append(q.order, path)
+		q.pushLocked(path, sizes[i].size, sizes[i].isDir)
 		added++
 	}
-	newLen := len(q.order)
+	newLen := len(q.items.list)
 	q.mu.Unlock()
 
 	if logEnabled(slog.LevelDebug) {
@@ -68,28 +170,118 @@ func (q *EvalQueue) PushMany(paths []string) {
 	}
 
 	if added > 0 {
-		select {
-		case q.notify <- struct{}{}:
-		default:
+		q.signal()
+	}
+}
+
+// PushAfter queues path once delay has passed, e.g. to retry a file that
+// is still being written.
+func (q *EvalQueue) PushAfter(path string, delay time.Duration) {
+	time.AfterFunc(delay, func() { q.Push(path) })
+}
+
+// PushMove queues a rename from → to as a single job keyed by to, so the
+// worker can move the entry instead of deleting and re-registering it.
+// A queued plain evaluation of either path is folded into the move.
+func (q *EvalQueue) PushMove(from, to string) {
+	size, isDir := q.sizeOf(to)
+	q.mu.Lock()
+	if it, ok := q.set[from]; ok && it.from == "" {
+		heap.Remove(&q.items, it.index)
+		delete(q.set, from)
+	}
+	if it, ok := q.set[to]; ok {
+		it.from = from
+	} else {
+		q.pushLocked(to, size, isDir)
+		q.set[to].from = from
+	}
+	q.mu.Unlock()
+
+	if logEnabled(slog.LevelDebug) {
+		sub("queue").Debug("push move", "from", from, "to", to)
+	}
+	q.signal()
+}
+
+// Promote moves queued paths ahead of all unpromoted work, e.g. a user's
+// edits in Spaces ahead of a bulk Archives import. Paths not queued are
+// ignored.
+func (q *EvalQueue) Promote(paths ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range paths {
+		if it, ok := q.set[p]; ok && !it.promoted {
+			it.promoted = true
+			heap.Fix(&q.items, it.index)
 		}
 	}
 }
 
+// pushLocked inserts a new item. Caller must hold q.mu.
+func (q *EvalQueue) pushLocked(path string, size int64, isDir bool) {
+	q.seq++
+	it := &queueItem{path: path, seq: q.seq, size: size, isDir: isDir, queuedAt: nowNano(), attempts: q.failures[path]}
+	q.set[path] = it
+	heap.Push(&q.items, it)
+}
+
+// sizeOf returns the size of path via the sizer, or (0, false) when no
+// sizer is installed or the order is FIFO (size is irrelevant).
+func (q *EvalQueue) sizeOf(path string) (int64, bool) {
+	q.mu.Lock()
+	sizer := q.sizer
+	q.mu.Unlock()
+	if sizer == nil || activeConfig.Load().QueueOrder == OrderFIFO {
+		return 0, false
+	}
+	return sizer(path)
+}
+
+// signal wakes a blocked Pop without blocking.
+func (q *EvalQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
 // Pop removes and returns the next path. Blocks until a path is available
-// or the done channel is closed. Returns ("", false) when done.
+// or the done channel is closed. Returns ("", false) when done. A move
+// pops as its destination path; use PopJob to see both.
 func (q *EvalQueue) Pop(done <-chan struct{}) (string, bool) {
+	job, ok := q.PopJob(done)
+	return job.Path, ok
+}
+
+// PopJob is Pop returning the whole job, including a move's old path.
+func (q *EvalQueue) PopJob(done <-chan struct{}) (Job, bool) {
 	for {
 		q.mu.Lock()
-		if len(q.order) > 0 {
-			path := q.order[0]
-			q.order = q.order[1:]
-			delete(q.set, path)
-			remaining := len(q.order)
+		hold := q.hold
+		q.mu.Unlock()
+		held := hold != nil && hold()
+
+		q.mu.Lock()
+		if order := activeConfig.Load().QueueOrder; order != q.items.order {
+			q.items.order = order
+			heap.Init(&q.items)
+			sub("queue").Info("queue order changed", "order", order, "queueLen", len(q.items.list))
+		}
+		if len(q.items.list) > 0 && (!held || q.items.list[0].promoted) {
+			it := heap.Pop(&q.items).(*queueItem)
+			delete(q.set, it.path)
+			remaining := len(q.items.list)
 			q.mu.Unlock()
 			if logEnabled(slog.LevelDebug) {
-				sub("queue").Debug("pop", "path", path, "queueLen", remaining)
+				sub("queue").Debug("pop", "path", it.path, "from", it.from, "queueLen", remaining)
 			}
-			return path, true
+			return Job{Path: it.path, From: it.from, Trace: it.trace, QueuedAt: it.queuedAt}, true
+		}
+		var recheck <-chan time.Time
+		if len(q.items.list) > 0 {
+			recheck = time.After(lowPowerRecheck)
+			sub("queue").Debug("pop held", "queueLen", len(q.items.list))
 		}
 		q.mu.Unlock()
 
@@ -97,9 +289,10 @@ func (q *EvalQueue) Pop(done <-chan struct{}) (string, bool) {
 		select {
 		case <-done:
 			sub("queue").Debug("pop cancelled")
-			return "", false
+			return Job{}, false
 		case <-q.notify:
 			// Loop back to check queue
+		case <-recheck:
 		}
 	}
 }
@@ -116,19 +309,159 @@ func (q *EvalQueue) Has(path string) bool {
 func (q *EvalQueue) Len() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return len(q.order)
+	return len(q.items.list)
 }
 
-// Drain removes and returns all queued paths.
+// Drain removes and returns all queued paths in push order. Moves are
+// returned as their destination path.
 func (q *EvalQueue) Drain() []string {
 	q.mu.Lock()
-	result := q.order
-	q.order = nil
-	q.set = make(map[string]struct{})
+	list := q.items.list
+	q.items.list = nil
+	q.set = make(map[string]*queueItem)
 	q.mu.Unlock()
 
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	result := make([]string, len(list))
+	for i, it := range list {
+		result[i] = it.path
+	}
+
 	if logEnabled(slog.LevelDebug) {
 		sub("queue").Debug("drain", "count", len(result))
 	}
 	return result
 }
+
+// Finished records how a popped path's run ended, so the attempts of a
+// path that keeps failing show when it is queued again.
+func (q *EvalQueue) Finished(path string, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if ok {
+		delete(q.failures, path)
+	} else {
+		q.failures[path]++
+	}
+}
+
+// Remove drops a queued path, e.g. one whose run keeps failing, and
+// forgets its failures. It reports whether the path was queued.
+func (q *EvalQueue) Remove(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.failures, path)
+	it, ok := q.set[path]
+	if !ok {
+		return false
+	}
+	heap.Remove(&q.items, it.index)
+	delete(q.set, path)
+	return true
+}
+
+// QueuedItem is a queued path as listed by Peek.
+type QueuedItem struct {
+	Path     string `json:"path"`
+	From     string `json:"from,omitempty"` // old path of a paired rename
+	QueuedAt int64  `json:"queuedAt"`       // nanoseconds
+	Attempts int    `json:"attempts"`       // earlier runs that failed
+}
+
+// Peek returns up to n of the paths next to pop, promoted and the rest
+// separately, in pop order, without removing them.
+func (q *EvalQueue) Peek(n int) (promoted, rest []QueuedItem) {
+	q.mu.Lock()
+	cp := make([]queueItem, len(q.items.list))
+	for i, it := range q.items.list {
+		cp[i] = *it
+	}
+	order := q.items.order
+	q.mu.Unlock()
+
+	h := itemHeap{list: make([]*queueItem, len(cp)), order: order}
+	for i := range cp {
+		h.list[i] = &cp[i]
+	}
+	sort.Slice(h.list, h.Less)
+	promoted, rest = []QueuedItem{}, []QueuedItem{}
+	for _, it := range h.list {
+		item := QueuedItem{Path: it.path, From: it.from, QueuedAt: it.queuedAt, Attempts: it.attempts}
+		switch {
+		case it.promoted && len(promoted) < n:
+			promoted = append(promoted, item)
+		case !it.promoted && len(rest) < n:
+			rest = append(rest, item)
+		}
+	}
+	return promoted, rest
+}
+
+// OldestAge returns how long the longest-waiting queued path has been
+// queued, 0 when the queue is empty.
+func (q *EvalQueue) OldestAge() time.Duration {
+	q.mu.Lock()
+	oldest := int64(0)
+	for _, it := range q.items.list {
+		if oldest == 0 || it.queuedAt < oldest {
+			oldest = it.queuedAt
+		}
+	}
+	q.mu.Unlock()
+	if oldest == 0 {
+		return 0
+	}
+	return time.Duration(nowNano() - oldest)
+}
+
+// --- itemHeap: container/heap ordered by QueueOrder ---
+
+type itemHeap struct {
+	list  []*queueItem
+	order QueueOrder
+}
+
+func (h itemHeap) Len() int { return len(h.list) }
+
+func (h itemHeap) Less(i, j int) bool {
+	a, b := h.list[i], h.list[j]
+	if a.promoted != b.promoted {
+		return a.promoted
+	}
+	if h.order == OrderFIFO {
+		return a.seq < b.seq
+	}
+	if a.isDir != b.isDir {
+		return a.isDir // directories first so parents register before children
+	}
+	if !a.isDir && a.size != b.size {
+		switch h.order {
+		case OrderSmallFirst:
+			return a.size < b.size
+		case OrderLargeFirst:
+			return a.size > b.size
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (h itemHeap) Swap(i, j int) {
+	h.list[i], h.list[j] = h.list[j], h.list[i]
+	h.list[i].index = i
+	h.list[j].index = j
+}
+
+func (h *itemHeap) Push(x any) {
+	it := x.(*queueItem)
+	it.index = len(h.list)
+	h.list = append(h.list, it)
+}
+
+func (h *itemHeap) Pop() any {
+	old := h.list
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	h.list = old[:n-1]
+	return it
+}
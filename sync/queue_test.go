@@ -1,6 +1,9 @@
 package sync
 
 import (
+	"fmt"
+	gosync "sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -117,3 +120,166 @@ func TestEvalQueue_Drain(t *testing.T) {
 	assert.Len(t, drained, 2)
 	assert.Equal(t, 0, q.Len())
 }
+
+func popAll(t *testing.T, q *EvalQueue) []string {
+	t.Helper()
+	done := make(chan struct{})
+	var out []string
+	for q.Len() > 0 {
+		p, ok := q.Pop(done)
+		require.True(t, ok)
+		out = append(out, p)
+	}
+	return out
+}
+
+func TestEvalQueue_SmallFirst(t *testing.T) {
+	withConfig(t, func(c *Config) { c.QueueOrder = OrderSmallFirst })
+	q := NewEvalQueue()
+
+	q.PushSized("big.iso", 1<<30, false)
+	q.PushSized("dir", 0, true)
+	q.PushSized("mid.mp4", 1<<20, false)
+	q.PushSized("small.txt", 10, false)
+	q.PushSized("dir/child", 0, true)
+
+	assert.Equal(t, []string{"dir", "dir/child", "small.txt", "mid.mp4", "big.iso"}, popAll(t, q))
+}
+
+func TestEvalQueue_LargeFirstWithSizer(t *testing.T) {
+	withConfig(t, func(c *Config) { c.QueueOrder = OrderLargeFirst })
+	q := NewEvalQueue()
+	sizes := map[string]int64{"a": 1, "b": 300, "c": 20}
+	q.SetSizer(func(p string) (int64, bool) { return sizes[p], false })
+
+	q.PushMany([]string{"a", "b", "c"})
+	assert.Equal(t, []string{"b", "c", "a"}, popAll(t, q))
+}
+
+func TestEvalQueue_OrderChangeReheaps(t *testing.T) {
+	withConfig(t, func(c *Config) { c.QueueOrder = OrderFIFO })
+	q := NewEvalQueue()
+	q.PushSized("first", 500, false)
+	q.PushSized("second", 5, false)
+
+	cfg := currentConfig()
+	cfg.QueueOrder = OrderSmallFirst
+	require.NoError(t, setConfig(cfg))
+
+	assert.Equal(t, []string{"second", "first"}, popAll(t, q))
+	assert.Empty(t, q.Drain())
+}
+
+func TestEvalQueue_PushMove(t *testing.T) {
+	q := NewEvalQueue()
+	q.Push("old.txt")
+	q.Push("other.txt")
+	q.PushMove("old.txt", "new.txt")
+	assert.Equal(t, 2, q.Len(), "queued source folds into the move")
+	assert.False(t, q.Has("old.txt"))
+
+	done := make(chan struct{})
+	job, ok := q.PopJob(done)
+	require.True(t, ok)
+	assert.Equal(t, Job{Path: "other.txt", QueuedAt: job.QueuedAt}, job)
+	job, ok = q.PopJob(done)
+	require.True(t, ok)
+	assert.Equal(t, Job{Path: "new.txt", From: "old.txt", QueuedAt: job.QueuedAt}, job)
+
+	q.Push("b.txt")
+	q.PushMove("a.txt", "b.txt")
+	job, _ = q.PopJob(done)
+	assert.Equal(t, Job{Path: "b.txt", From: "a.txt", QueuedAt: job.QueuedAt}, job, "queued destination becomes a move")
+}
+
+func TestEvalQueue_Promote(t *testing.T) {
+	q := NewEvalQueue()
+	q.PushMany([]string{"bulk/1", "bulk/2", "edit.txt"})
+	q.Promote("edit.txt", "not-queued")
+
+	done := make(chan struct{})
+	path, _ := q.Pop(done)
+	assert.Equal(t, "edit.txt", path)
+	path, _ = q.Pop(done)
+	assert.Equal(t, "bulk/1", path)
+}
+
+// BenchmarkEvalQueue_Contention pushes from parallel goroutines while
+// four workers pop, as the watcher, rescans and workers do. Paths repeat,
+// so some pushes are deduplicated.
+func BenchmarkEvalQueue_Contention(b *testing.B) {
+	q := NewEvalQueue()
+	done := make(chan struct{})
+	var workers gosync.WaitGroup
+	for range 4 {
+		workers.Go(func() {
+			for {
+				if _, ok := q.Pop(done); !ok {
+					return
+				}
+			}
+		})
+	}
+	paths := make([]string, 4096)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("dir%d/file%d.txt", i%64, i)
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Push(paths[next.Add(1)%int64(len(paths))])
+		}
+	})
+	b.StopTimer()
+	close(done)
+	workers.Wait()
+}
+
+func TestEvalQueue_PeekAndRemove(t *testing.T) {
+	clk := useFakeClock(t, time.Unix(1000, 0))
+	q := NewEvalQueue()
+	q.Push("a.txt")
+	clk.Advance(time.Minute)
+	q.Push("b.txt")
+	q.Push("c.txt")
+	q.Promote("c.txt")
+
+	// A failed run shows as an attempt once the path is queued again.
+	done := make(chan struct{})
+	path, ok := q.Pop(done)
+	require.True(t, ok)
+	require.Equal(t, "c.txt", path)
+	q.Finished("c.txt", false)
+	q.Push("c.txt")
+
+	promoted, rest := q.Peek(10)
+	assert.Empty(t, promoted)
+	require.Len(t, rest, 3)
+	assert.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, []string{rest[0].Path, rest[1].Path, rest[2].Path})
+	assert.Equal(t, time.Unix(1000, 0).UnixNano(), rest[0].QueuedAt)
+	assert.Equal(t, 1, rest[2].Attempts)
+	assert.Equal(t, time.Minute, q.OldestAge())
+	assert.Equal(t, 3, q.Len(), "peek leaves the queue alone")
+
+	q.Promote("b.txt")
+	promoted, rest = q.Peek(1)
+	assert.Equal(t, []QueuedItem{{Path: "b.txt", QueuedAt: time.Unix(1060, 0).UnixNano()}}, promoted)
+	assert.Len(t, rest, 1)
+
+	assert.True(t, q.Remove("a.txt"))
+	assert.False(t, q.Remove("a.txt"))
+	assert.False(t, q.Has("a.txt"))
+	assert.Equal(t, 2, q.Len())
+	path, ok = q.Pop(done)
+	require.True(t, ok)
+	assert.Equal(t, "b.txt", path, "heap intact after remove")
+
+	q.Finished("c.txt", true)
+	q.Remove("c.txt")
+	q.Push("c.txt")
+	_, rest = q.Peek(1)
+	assert.Zero(t, rest[0].Attempts)
+	q.Drain()
+	assert.Zero(t, q.OldestAge())
+}
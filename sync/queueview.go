@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// defaultQueuePeek and maxQueuePeek bound the items GET /api/sync/queue
+// lists per tier.
+const (
+	defaultQueuePeek = 50
+	maxQueuePeek     = 1000
+)
+
+// QueueResponse is returned by GET /api/sync/queue.
+type QueueResponse struct {
+	Len              int          `json:"len"`
+	OldestAgeSeconds int64        `json:"oldestAgeSeconds"` // how long the longest-waiting path has been queued
+	Promoted         []QueuedItem `json:"promoted"`         // pop before everything else, in pop order
+	Queued           []QueuedItem `json:"queued"`           // the rest, in pop order
+}
+
+// HandleQueue handles GET /api/sync/queue?limit=N
+// Lists the next N paths (default 50, at most 1000) of each tier of the
+// eval queue, to see what is waiting and what keeps failing.
+func (h *Handlers) HandleQueue(w http.ResponseWriter, r *http.Request) {
+	limit := defaultQueuePeek
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxQueuePeek)
+	}
+	q := h.daemon.Queue()
+	promoted, queued := q.Peek(limit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QueueResponse{ //nolint:errcheck
+		Len:              q.Len(),
+		OldestAgeSeconds: int64(q.OldestAge().Seconds()),
+		Promoted:         promoted,
+		Queued:           queued,
+	})
+}
+
+// HandleDropQueueItem handles DELETE /api/sync/queue/item?path=<relPath>
+// Drops a queued path, such as one whose run fails every time; it is
+// queued again by its next change or reconcile. The jobs and repairs
+// waiting on it count it as failed.
+func (h *Handlers) HandleDropQueueItem(w http.ResponseWriter, r *http.Request) {
+	relPath, err := h.checkPath(r.URL.Query().Get("path"))
+	if err != nil || relPath == "" {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if !h.daemon.Queue().Remove(relPath) {
+		http.Error(w, "path not queued", http.StatusNotFound)
+		return
+	}
+	h.daemon.dropped(relPath)
+	sub("handlers").Info("HTTP drop queue item", "path", relPath)
+	w.WriteHeader(http.StatusNoContent)
+}
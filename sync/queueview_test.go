@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleQueue(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	q := h.daemon.Queue()
+	q.PushMany([]string{"a.txt", "b.txt", "c.txt"})
+	q.Promote("c.txt")
+
+	w := httptest.NewRecorder()
+	h.HandleQueue(w, httptest.NewRequest("GET", "/api/sync/queue?limit=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp QueueResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Len)
+	require.Len(t, resp.Promoted, 1)
+	assert.Equal(t, "c.txt", resp.Promoted[0].Path)
+	require.Len(t, resp.Queued, 1)
+	assert.Equal(t, "a.txt", resp.Queued[0].Path)
+
+	w = httptest.NewRecorder()
+	h.HandleQueue(w, httptest.NewRequest("GET", "/api/sync/queue?limit=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleDropQueueItem(w, httptest.NewRequest("DELETE", "/api/sync/queue/item?path=a.txt", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, q.Has("a.txt"))
+
+	w = httptest.NewRecorder()
+	h.HandleDropQueueItem(w, httptest.NewRequest("DELETE", "/api/sync/queue/item?path=a.txt", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	h.HandleDropQueueItem(w, httptest.NewRequest("DELETE", "/api/sync/queue/item?path=../x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleDropQueueItem_FinishesRepair(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/copy.txt"}, map[string]bool{"Docs/copy.txt": true})
+	require.NoError(t, os.Remove(filepath.Join(spacesRoot, "Docs/copy.txt")))
+	report := postFsck(t, h, "repair=true&rate=100", http.StatusAccepted)
+	q := h.daemon.Queue()
+	require.Eventually(t, func() bool { return q.Has("Docs/copy.txt") }, time.Second, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	h.HandleDropQueueItem(w, httptest.NewRequest("DELETE", "/api/sync/queue/item?path=Docs/copy.txt", nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	got, ok := h.daemon.fsckRuns.get(report.ID)
+	require.True(t, ok)
+	assert.True(t, got.Complete)
+	assert.Equal(t, map[int]int{21: 1}, got.Failed)
+
+	// The next repair is not refused as one already running
+	postFsck(t, h, "repair=true&rate=100", http.StatusAccepted)
+}
@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	gosync "sync"
+	"time"
+)
+
+// LogEntry is a captured WARN/ERROR log record.
+type LogEntry struct {
+	Time  time.Time         `json:"time"`
+	Level string            `json:"level"`
+	Comp  string            `json:"comp"`
+	Msg   string            `json:"msg"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// ErrorFilter narrows a RecentErrors query. Zero values match everything.
+type ErrorFilter struct {
+	Since    time.Time
+	Comp     string
+	MinLevel slog.Level // records below this level are skipped
+	Limit    int        // 0 = no limit; newest entries are kept
+}
+
+// errorRing is a fixed-capacity ring of the most recent log entries.
+// Capacity follows Config.ErrorBufferSize and is adjusted on the next add.
+type errorRing struct {
+	mu      gosync.Mutex
+	entries []LogEntry // ring storage, len == capacity once full
+	next    int        // index of the next write once full
+}
+
+// recentErrors collects ERROR (and optionally WARN) records from the logger.
+var recentErrors = &errorRing{}
+
+func (r *errorRing) add(e LogEntry) {
+	size := activeConfig.Load().ErrorBufferSize
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if size != cap(r.entries) {
+		r.resize(size)
+	}
+	if size <= 0 {
+		return
+	}
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % size
+}
+
+// resize keeps the newest entries that fit in the new capacity.
+// Caller must hold r.mu.
+func (r *errorRing) resize(size int) {
+	ordered := r.orderedLocked()
+	if len(ordered) > size {
+		ordered = ordered[len(ordered)-max(size, 0):]
+	}
+	r.entries = make([]LogEntry, len(ordered), max(size, 0))
+	copy(r.entries, ordered)
+	r.next = 0
+}
+
+// orderedLocked returns entries oldest → newest. Caller must hold r.mu.
+func (r *errorRing) orderedLocked() []LogEntry {
+	out := make([]LogEntry, 0, len(r.entries))
+	if len(r.entries) < cap(r.entries) {
+		return append(out, r.entries...)
+	}
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+// query returns matching entries, newest first.
+func (r *errorRing) query(f ErrorFilter) []LogEntry {
+	r.mu.Lock()
+	ordered := r.orderedLocked()
+	r.mu.Unlock()
+
+	out := make([]LogEntry, 0)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		e := ordered[i]
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			continue
+		}
+		if f.Comp != "" && e.Comp != f.Comp {
+			continue
+		}
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(e.Level)); err == nil && lvl < f.MinLevel {
+			continue
+		}
+		out = append(out, e)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// RecentErrors returns captured WARN/ERROR entries matching f, newest first.
+func RecentErrors(f ErrorFilter) []LogEntry {
+	return recentErrors.query(f)
+}
+
+// --- ringHandler: captures WARN/ERROR records into recentErrors ---
+
+type ringHandler struct {
+	ring  *errorRing
+	attrs []slog.Attr
+}
+
+func (h *ringHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return true
+	}
+	return level >= slog.LevelWarn && activeConfig.Load().ErrorBufferWarn
+}
+
+func (h *ringHandler) Handle(_ context.Context, r slog.Record) error {
+	e := LogEntry{Time: r.Time, Level: r.Level.String(), Msg: r.Message}
+	collect := func(a slog.Attr) bool {
+		if a.Key == "comp" {
+			e.Comp = a.Value.String()
+			return true
+		}
+		if e.Attrs == nil {
+			e.Attrs = make(map[string]string)
+		}
+		e.Attrs[a.Key] = a.Value.String()
+		return true
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	r.Attrs(collect)
+	h.ring.add(e)
+	return nil
+}
+
+func (h *ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	merged = append(merged, h.attrs...)
+	merged = append(merged, attrs...)
+	return &ringHandler{ring: h.ring, attrs: merged}
+}
+
+func (h *ringHandler) WithGroup(string) slog.Handler {
+	return h
+}
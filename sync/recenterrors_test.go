@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorRing_WrapsAndOrders(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.ErrorBufferSize = 3
+	require.NoError(t, setConfig(cfg))
+
+	r := &errorRing{}
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		r.add(LogEntry{Time: base.Add(time.Duration(i) * time.Second), Level: "ERROR", Msg: fmt.Sprint(i)})
+	}
+
+	got := r.query(ErrorFilter{})
+	require.Len(t, got, 3)
+	assert.Equal(t, "4", got[0].Msg, "newest first")
+	assert.Equal(t, "2", got[2].Msg)
+
+	// Shrinking keeps the newest entries
+	cfg.ErrorBufferSize = 2
+	require.NoError(t, setConfig(cfg))
+	r.add(LogEntry{Time: base.Add(5 * time.Second), Level: "ERROR", Msg: "5"})
+	got = r.query(ErrorFilter{})
+	require.Len(t, got, 2)
+	assert.Equal(t, "5", got[0].Msg)
+	assert.Equal(t, "4", got[1].Msg)
+
+	// Since filter
+	got = r.query(ErrorFilter{Since: base.Add(5 * time.Second)})
+	require.Len(t, got, 1)
+}
+
+func TestRingHandler_CapturesComponentAndWarn(t *testing.T) {
+	restoreConfig(t)
+	prevRing := recentErrors
+	recentErrors = &errorRing{}
+	t.Cleanup(func() { recentErrors = prevRing })
+
+	prev := logger
+	logger = slog.New(&ringHandler{ring: recentErrors})
+	t.Cleanup(func() { logger = prev })
+
+	sub("watcher").Warn("ignored warn")
+	sub("pipeline").Error("boom", "path", "a.txt")
+
+	cfg := currentConfig()
+	cfg.ErrorBufferWarn = true
+	require.NoError(t, setConfig(cfg))
+	sub("watcher").Warn("captured warn")
+
+	got := RecentErrors(ErrorFilter{})
+	require.Len(t, got, 2)
+	assert.Equal(t, "captured warn", got[0].Msg)
+	assert.Equal(t, "pipeline", got[1].Comp)
+	assert.Equal(t, "a.txt", got[1].Attrs["path"])
+
+	got = RecentErrors(ErrorFilter{Comp: "pipeline"})
+	require.Len(t, got, 1)
+	got = RecentErrors(ErrorFilter{MinLevel: slog.LevelError})
+	require.Len(t, got, 1)
+	assert.Equal(t, "boom", got[0].Msg)
+}
+
+func TestHandleErrors_Filters(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+
+	w := httptest.NewRecorder()
+	h.HandleErrors(w, httptest.NewRequest("GET", "/api/sync/errors?comp=watcher&limit=5", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string][]LogEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotNil(t, resp["items"])
+
+	w = httptest.NewRecorder()
+	h.HandleErrors(w, httptest.NewRequest("GET", "/api/sync/errors?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	gosync "sync"
+)
+
+// Queueing every entry on a full reconcile has each pipeline run stat both
+// roots for it, most of them to find nothing changed. Instead each root is
+// walked once into a pathIndex, and only the entries whose state, judged
+// from the indexes and the DB, isn't settled are queued.
+
+// pathIndex holds the mtimes of the paths found on a root, keyed by a
+// 64-bit hash of the relPath rather than the path itself to stay compact
+// on large trees. A collision could only hide a missing path behind one
+// with the very same mtime.
+type pathIndex map[uint64]int64
+
+func pathKey(relPath string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(relPath)) //nolint:errcheck // never fails
+	return h.Sum64()
+}
+
+// buildPathIndex walks root into a pathIndex.
+func buildPathIndex(root string) (pathIndex, error) {
+	idx := make(pathIndex)
+	err := walkScan(root, func(relPath string, stat FileStat) {
+		idx[pathKey(relPath)] = stat.Mtime
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// mtime returns the indexed mtime of relPath, nil if it wasn't found.
+func (idx pathIndex) mtime(relPath string) *int64 {
+	m, ok := idx[pathKey(relPath)]
+	if !ok {
+		return nil
+	}
+	return &m
+}
+
+// reconcileIndexes walks both roots at once into their indexes.
+func (d *Daemon) reconcileIndexes() (archives, spaces pathIndex, err error) {
+	var aErr, sErr error
+	var wg gosync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		archives, aErr = buildPathIndex(d.archivesRoot)
+	}()
+	go func() {
+		defer wg.Done()
+		spaces, sErr = buildPathIndex(d.spacesRoot)
+	}()
+	wg.Wait()
+	if aErr != nil {
+		aErr = fmt.Errorf("archives: %w", aErr)
+	}
+	if sErr != nil {
+		sErr = fmt.Errorf("spaces: %w", sErr)
+	}
+	return archives, spaces, errors.Join(aErr, sErr)
+}
+
+// reconcileIndexed queues the entries whose state computed from the
+// indexes isn't settled.
+func (d *Daemon) reconcileIndexed(archives, spaces pathIndex) {
+	l := sub("daemon")
+	views, err := d.store.ListSpacesViews()
+	if err != nil {
+		l.Warn("reconcile list spaces_view failed, queueing every entry", "err", err)
+		d.reconcileChildren(0, "")
+		return
+	}
+	checked, queued := 0, 0
+	err = d.store.walkTree(0, "", func(child *Entry, relPath string) (bool, error) {
+		d.pathCache.Set(child.Inode, relPath)
+		checked++
+		var sv *SpacesView
+		if v, ok := views[child.Inode]; ok {
+			sv = &v
+		}
+		if ComputeState(child, sv, archives.mtime(relPath), spaces.mtime(relPath)).Settled() {
+			return true, nil
+		}
+		d.queue.PushSized(relPath, sizeOrZero(child.Size), child.Type == "dir")
+		queued++
+		l.Debug("reconcile queued", "path", relPath, "inode", child.Inode, "type", child.Type)
+		return true, nil
+	})
+	if errors.Is(err, errTreeTruncated) {
+		l.Warn("reconcile compared part of the tree", "checked", checked)
+	} else if err != nil {
+		l.Error("reconcile list failed", "err", err)
+	}
+	l.Info("reconcile compared against root indexes", "checked", checked, "queued", queued)
+}
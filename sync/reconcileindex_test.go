@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathIndex(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "x.txt"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "x.txt.sync-tmp"), []byte("x"), 0644))
+
+	idx, err := buildPathIndex(root)
+	require.NoError(t, err)
+	assert.Len(t, idx, 2)
+	info, err := os.Stat(filepath.Join(root, "a", "x.txt"))
+	require.NoError(t, err)
+	require.NotNil(t, idx.mtime("a/x.txt"))
+	assert.Equal(t, info.ModTime().UnixNano(), *idx.mtime("a/x.txt"))
+	assert.NotNil(t, idx.mtime("a"))
+	assert.Nil(t, idx.mtime("a/missing.txt"))
+	assert.Nil(t, idx.mtime("a/x.txt.sync-tmp"))
+
+	_, err = buildPathIndex(filepath.Join(root, "gone"))
+	assert.Error(t, err)
+}
+
+func TestDaemon_FullReconcileQueuesDrift(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	for _, p := range []string{"same.txt", "changed.txt", "gone.txt", "synced.txt", "wanted.txt"} {
+		env.writeArchive(t, p, []byte("v1"))
+		env.run(t, p)
+	}
+	synced := registered(t, env.store, env.archivesRoot, "synced.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{synced.Inode}, true))
+	env.run(t, "synced.txt")
+	require.FileExists(t, filepath.Join(env.spacesRoot, "synced.txt"))
+
+	wanted := registered(t, env.store, env.archivesRoot, "wanted.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{wanted.Inode}, true))
+	env.clock.Advance(time.Second)
+	env.writeArchive(t, "changed.txt", []byte("v2"))
+	require.NoError(t, os.Remove(filepath.Join(env.archivesRoot, "gone.txt")))
+
+	d := NewDaemon(env.store, env.archivesRoot, env.spacesRoot)
+	d.fullReconcile()
+	assert.ElementsMatch(t, []string{"changed.txt", "gone.txt", "wanted.txt"}, d.queue.Drain())
+
+	// Without an index of Spaces, everything is queued.
+	require.NoError(t, os.RemoveAll(env.spacesRoot))
+	d.fullReconcile()
+	assert.Len(t, d.queue.Drain(), 5)
+}
@@ -0,0 +1,180 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"path/filepath"
+	gosync "sync"
+)
+
+// reconcileJobsKept is how many finished subtree reconciles are kept for
+// their status to be read.
+const reconcileJobsKept = 50
+
+// ReconcileJob is a subtree queued for re-evaluation by
+// POST /api/sync/reconcile?path=, with its progress.
+type ReconcileJob struct {
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	Total      int    `json:"total"`
+	Done       int    `json:"done"`
+	Failed     int    `json:"failed"`
+	Complete   bool   `json:"complete"`
+	StartedAt  int64  `json:"startedAt"`            // nanoseconds
+	FinishedAt int64  `json:"finishedAt,omitempty"` // nanoseconds
+}
+
+type reconcileJob struct {
+	ReconcileJob
+	pending map[string]bool
+}
+
+// reconcileJobs tracks subtree reconciles until their last path has been
+// through the pipeline.
+type reconcileJobs struct {
+	mu    gosync.Mutex
+	jobs  map[string]*reconcileJob
+	order []string // IDs, oldest first
+}
+
+func newReconcileJobs() *reconcileJobs {
+	return &reconcileJobs{jobs: make(map[string]*reconcileJob)}
+}
+
+// start tracks a job covering paths and returns its ID.
+func (t *reconcileJobs) start(root string, paths []string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	job := &reconcileJob{
+		ReconcileJob: ReconcileJob{ID: hex.EncodeToString(b), Path: root, Total: len(paths), StartedAt: nowNano()},
+		pending:      make(map[string]bool, len(paths)),
+	}
+	for _, p := range paths {
+		job.pending[p] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[job.ID] = job
+	t.order = append(t.order, job.ID)
+	t.trimLocked()
+	return job.ID, nil
+}
+
+// finish records that the pipeline ran on relPath, failed or not.
+func (t *reconcileJobs) finish(relPath string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, job := range t.jobs {
+		if !job.pending[relPath] {
+			continue
+		}
+		delete(job.pending, relPath)
+		job.Done++
+		if failed {
+			job.Failed++
+		}
+		if len(job.pending) == 0 {
+			job.Complete = true
+			job.FinishedAt = nowNano()
+		}
+	}
+}
+
+// get returns the job with id, or false.
+func (t *reconcileJobs) get(id string) (ReconcileJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return ReconcileJob{}, false
+	}
+	return job.ReconcileJob, true
+}
+
+// trimLocked forgets the oldest finished jobs beyond reconcileJobsKept.
+// Caller must hold t.mu.
+func (t *reconcileJobs) trimLocked() {
+	excess := len(t.order) - reconcileJobsKept
+	kept := t.order[:0]
+	for _, id := range t.order {
+		if excess > 0 && t.jobs[id].Complete {
+			delete(t.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+}
+
+// reconcileSubtree queues relPath and every registered entry under it
+// ahead of other work, and returns the ID of the job tracking them.
+// ok is false when the path is neither registered nor on either disk.
+func (d *Daemon) reconcileSubtree(relPath string) (job ReconcileJob, ok bool, err error) {
+	entry, _, err := lookupDB(d.store, d.archivesRoot, relPath)
+	if err != nil {
+		return job, false, err
+	}
+	if entry == nil {
+		aMtime, _, _, _ := statFile(filepath.Join(d.archivesRoot, relPath))
+		sMtime, _, _, _ := statFile(filepath.Join(d.spacesRoot, relPath))
+		if aMtime == nil && sMtime == nil {
+			return job, false, nil
+		}
+	}
+	paths := []string{relPath}
+	if entry != nil && entry.Type == "dir" {
+		if paths, err = d.collectSubtree(entry.Inode, relPath, paths); err != nil {
+			return job, false, err
+		}
+	}
+	id, err := d.reconciles.start(relPath, paths)
+	if err != nil {
+		return job, false, err
+	}
+	d.queue.PushMany(paths)
+	d.queue.Promote(paths...)
+	sub("daemon").Info("subtree reconcile queued", "path", relPath, "id", id, "paths", len(paths))
+	job, _ = d.reconciles.get(id)
+	return job, true, nil
+}
+
+// collectSubtree appends the paths of the registered entries under the
+// directory dirIno at dirPath, parents before children.
+func (d *Daemon) collectSubtree(dirIno uint64, dirPath string, paths []string) ([]string, error) {
+	if err := d.ensureListed(dirIno); err != nil {
+		return nil, err
+	}
+	children, err := d.store.ListChildren(dirIno)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		childPath := path.Join(dirPath, child.Name)
+		paths = append(paths, childPath)
+		d.pathCache.Set(child.Inode, childPath)
+		if child.Type == "dir" {
+			if paths, err = d.collectSubtree(child.Inode, childPath, paths); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return paths, nil
+}
+
+// HandleReconcileStatus handles GET /api/sync/reconcile/{id}
+// Returns the progress of a subtree reconcile.
+func (h *Handlers) HandleReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.daemon.reconciles.get(path.Base(r.URL.Path))
+	if !ok {
+		http.Error(w, "reconcile job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job) //nolint:errcheck
+}
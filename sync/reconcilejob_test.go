@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reconcileStatus(t *testing.T, h *Handlers, id string) ReconcileJob {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleReconcileStatus(w, httptest.NewRequest("GET", "/api/sync/reconcile/"+id, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var job ReconcileJob
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	return job
+}
+
+func TestReconcileSubtree_QueuesOnlySubtreeAndTracksIt(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot,
+		[]string{"Projects/", "Projects/alpha/", "Projects/alpha/a.txt", "Projects/alpha/sub/", "Projects/alpha/sub/b.txt", "Projects/beta.txt"}, nil)
+	q := h.daemon.Queue()
+	q.Push("Other/unrelated.txt")
+
+	w := postJSON(h.HandleReconcile, "/api/sync/reconcile?path=Projects/alpha", "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var job ReconcileJob
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, "Projects/alpha", job.Path)
+	assert.Equal(t, 4, job.Total)
+	assert.False(t, job.Complete)
+	assert.False(t, q.Has("Projects/beta.txt"))
+
+	// The subtree is popped ahead of what was already queued.
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	var popped []string
+	for i := 0; i < job.Total; i++ {
+		j, ok := q.PopJob(nil)
+		require.True(t, ok)
+		popped = append(popped, j.Path)
+		require.NoError(t, RunPipeline(context.Background(), j.Path, store, archivesRoot, spacesRoot, trash, nil))
+		h.daemon.reconciles.finish(j.Path, false)
+	}
+	sort.Strings(popped)
+	assert.Equal(t, []string{"Projects/alpha", "Projects/alpha/a.txt", "Projects/alpha/sub", "Projects/alpha/sub/b.txt"}, popped)
+	assert.True(t, q.Has("Other/unrelated.txt"))
+
+	got := reconcileStatus(t, h, job.ID)
+	assert.True(t, got.Complete)
+	assert.Equal(t, 4, got.Done)
+	assert.Zero(t, got.Failed)
+	assert.NotZero(t, got.FinishedAt)
+}
+
+func TestReconcileSubtree_UnknownPath(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+
+	w := postJSON(h.HandleReconcile, "/api/sync/reconcile?path=missing", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleReconcileStatus(w, httptest.NewRequest("GET", "/api/sync/reconcile/0123abcd", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReconcileJobs_CountsFailuresAndTrimsFinished(t *testing.T) {
+	jobs := newReconcileJobs()
+	id, err := jobs.start("a", []string{"a", "a/b"})
+	require.NoError(t, err)
+	jobs.finish("a", true)
+	jobs.finish("a/b", false)
+	jobs.finish("a/b", false) // not pending any more
+	job, ok := jobs.get(id)
+	require.True(t, ok)
+	assert.Equal(t, 2, job.Done)
+	assert.Equal(t, 1, job.Failed)
+	assert.True(t, job.Complete)
+
+	running, err := jobs.start("running", []string{"running"})
+	require.NoError(t, err)
+	for i := 0; i < reconcileJobsKept; i++ {
+		p := fmt.Sprintf("p%d", i)
+		_, err := jobs.start(p, []string{p})
+		require.NoError(t, err)
+		jobs.finish(p, false)
+	}
+	_, ok = jobs.get(id)
+	assert.False(t, ok, "oldest finished job is forgotten")
+	_, ok = jobs.get(running)
+	assert.True(t, ok, "unfinished jobs are kept")
+}
@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+// mountRemote routes the Spaces and trash roots to Config.SpacesRemote
+// when it is set. The returned func unmounts and disconnects; it is a
+// no-op for a local Spaces.
+func (d *Daemon) mountRemote() (func(), error) {
+	cfg := currentConfig()
+	if cfg.SpacesRemote == "" {
+		return func() {}, nil
+	}
+	fs, err := newSSHFS(cfg)
+	if err != nil {
+		return nil, err
+	}
+	unmount := mountFS(fs, d.spacesRoot, d.trashRoot)
+	sub("daemon").Info("remote Spaces mounted", "remote", cfg.SpacesRemote, "spaces", d.spacesRoot)
+	return func() {
+		unmount()
+		fs.Close() //nolint:errcheck
+	}, nil
+}
+
+// runRemoteScan stands in for the watcher on a remote Spaces: every
+// Config.SpacesRemoteScanSeconds it lists the remote tree and queues
+// whatever changed there since it was last synced.
+func (d *Daemon) runRemoteScan(ctx context.Context) {
+	l := sub("watcher")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(time.Duration(currentConfig().SpacesRemoteScanSeconds) * time.Second)):
+		}
+		start := time.Now()
+		queued, err := d.scanRemote(ctx)
+		if err != nil {
+			l.Warn("remote scan failed", "err", err)
+			continue
+		}
+		l.Info("remote scan complete", "queued", queued, "durationMs", time.Since(start).Milliseconds())
+	}
+}
+
+// scanRemote compares the remote Spaces tree with the spaces_view rows.
+// Files that are new, whose mtime differs from the synced one, or whose
+// synced copy is gone are queued. Returns the number queued.
+func (d *Daemon) scanRemote(ctx context.Context) (int, error) {
+	remote, err := ScanDir(d.spacesRoot)
+	if err != nil {
+		return 0, err
+	}
+	views, err := d.store.ListSpacesViews()
+	if err != nil {
+		return 0, err
+	}
+
+	var queued int
+	for relPath, stat := range remote {
+		if ctx.Err() != nil {
+			return queued, ctx.Err()
+		}
+		entry, sv, err := lookupDB(d.store, d.archivesRoot, relPath)
+		if err != nil {
+			return queued, err
+		}
+		if entry != nil && sv != nil {
+			delete(views, entry.Inode)
+			if stat.IsDir || sameMtime(sv.SyncedMtime, stat.Mtime, mtimeGrain(d.spacesRoot)) {
+				continue
+			}
+		}
+		d.queue.PushSized(relPath, stat.Size, stat.IsDir)
+		queued++
+	}
+	for ino := range views {
+		entry, err := d.store.GetEntry(ino)
+		if err != nil {
+			return queued, err
+		}
+		if entry == nil {
+			continue
+		}
+		d.queue.PushSized(d.store.RelPath(entry), sizeOrZero(entry.Size), entry.Type == "dir")
+		queued++
+	}
+	return queued, nil
+}
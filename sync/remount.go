@@ -0,0 +1,196 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Watches die silently with the filesystem they are on: when a root on a
+// removable disk is unmounted and mounted again, the watcher keeps
+// running but never hears from it. superviseWatcher polls the roots'
+// identity and, once a root changed and is back, replaces the watcher
+// and re-seeds so changes made meanwhile are picked up.
+
+// remountCheckInterval is how often the watched roots are checked for a
+// remount.
+var remountCheckInterval = 10 * time.Second
+
+// rootMount identifies the directory a root is and the filesystem it
+// is on.
+type rootMount struct {
+	dev, ino uint64
+	mounted  bool // a mount point: on another device than its parent
+}
+
+// statMount returns the identity of root, false if it is missing.
+func statMount(root string) (rootMount, bool) {
+	devIno := func(path string) (uint64, uint64, bool) {
+		info, err := fsFor(path).Stat(path)
+		if err != nil {
+			return 0, 0, false
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0, 0, false
+		}
+		return uint64(stat.Dev), stat.Ino, true //nolint:unconvert // Dev is int32 on some platforms
+	}
+	dev, ino, ok := devIno(root)
+	if !ok {
+		return rootMount{}, false
+	}
+	parentDev, _, ok := devIno(filepath.Dir(root))
+	return rootMount{dev: dev, ino: ino, mounted: ok && parentDev != dev}, true
+}
+
+// present reports whether the root last seen as was is there now, as
+// cur. A mount point whose disk went leaves an empty directory behind,
+// which doesn't count.
+func (was rootMount) present(cur rootMount, ok bool) bool {
+	return ok && (cur.mounted || !was.mounted)
+}
+
+// watchedRoots returns the roots the watcher watches.
+func (d *Daemon) watchedRoots() []string {
+	if isRemote(d.spacesRoot) {
+		return []string{d.archivesRoot}
+	}
+	return []string{d.archivesRoot, d.spacesRoot}
+}
+
+// newWatcher creates the watcher for the roots, scoped as configured.
+func (d *Daemon) newWatcher() (*Watcher, error) {
+	w, err := NewWatcher(d.archivesRoot, d.spacesRoot, d.queue)
+	if err != nil {
+		return nil, err
+	}
+	if currentConfig().WatchScoped && !w.WholeTree() {
+		w.SetScope(d.watchScope, d.scopeChanged)
+	}
+	d.watcher.Store(w)
+	return w, nil
+}
+
+// watched reports whether the current watcher watches dir.
+func (d *Daemon) watched(dir string) bool {
+	w := d.watcher.Load()
+	return w != nil && w.Watched(dir)
+}
+
+// superviseWatcher runs w until ctx is done, replacing it whenever a
+// watched root is remounted or it stops on its own.
+func (d *Daemon) superviseWatcher(ctx context.Context, w *Watcher) {
+	l := sub("watcher")
+	roots := d.watchedRoots()
+	mounts := make([]rootMount, len(roots))
+	for i, root := range roots {
+		mounts[i], _ = statMount(root)
+	}
+	for restarted := false; ; restarted = true {
+		wctx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := w.Start(wctx); err != nil && wctx.Err() == nil {
+				l.Warn("watcher stopped unexpectedly", "err", err)
+			}
+		}()
+		if restarted {
+			// With the new watches up, nothing slips in between.
+			d.reseed()
+		}
+		changed := d.awaitRootChange(ctx, done, roots, mounts)
+		stop()
+		<-done
+		w.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if changed != "" {
+			l.Warn("root changed, watches torn down until it is back", "root", changed)
+		} else {
+			// Stopped on its own: don't spin if it keeps failing.
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(remountCheckInterval):
+			}
+		}
+		if !d.awaitRoots(ctx, roots, mounts) {
+			return
+		}
+		for {
+			var err error
+			if w, err = d.newWatcher(); err == nil {
+				break
+			}
+			l.Warn("watcher re-creation failed, retrying", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(remountCheckInterval):
+			}
+		}
+		l.Info("watcher re-created", "root", changed)
+	}
+}
+
+// awaitRootChange waits for a root to go or to be replaced by another
+// directory or filesystem, or for the watcher to stop (done). It returns
+// the root that changed, "" if the watcher stopped or ctx is done.
+func (d *Daemon) awaitRootChange(ctx context.Context, done <-chan struct{}, roots []string, mounts []rootMount) string {
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-done:
+			return ""
+		case <-clock.After(remountCheckInterval):
+		}
+		for i, root := range roots {
+			cur, ok := statMount(root)
+			if !mounts[i].present(cur, ok) || cur.dev != mounts[i].dev || cur.ino != mounts[i].ino {
+				return root
+			}
+		}
+	}
+}
+
+// awaitRoots waits until every root is present, recording where each is
+// now. It returns false if ctx is done first.
+func (d *Daemon) awaitRoots(ctx context.Context, roots []string, mounts []rootMount) bool {
+	for {
+		back := true
+		for i, root := range roots {
+			cur, ok := statMount(root)
+			if !mounts[i].present(cur, ok) {
+				back = false
+				break
+			}
+			mounts[i] = cur
+		}
+		if back {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-clock.After(remountCheckInterval):
+		}
+	}
+}
+
+// reseed registers what changed on the roots while they were unwatched
+// and queues it, as at startup.
+func (d *Daemon) reseed() {
+	changed, err := seed(d.store, d.archivesRoot, d.spacesRoot, &d.seedStatus, d.lazy)
+	if err != nil {
+		sub("watcher").Error("re-seed after remount failed, reconciling everything", "err", err)
+		d.fullReconcile()
+		return
+	}
+	d.reconcile(changed)
+}
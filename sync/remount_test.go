@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootMount_Present(t *testing.T) {
+	mounted := rootMount{dev: 1, ino: 2, mounted: true}
+	assert.False(t, mounted.present(rootMount{}, false), "missing")
+	assert.False(t, mounted.present(rootMount{dev: 9, ino: 3}, true), "the empty mount point left behind")
+	assert.True(t, mounted.present(rootMount{dev: 5, ino: 2, mounted: true}, true), "mounted again")
+	assert.True(t, rootMount{dev: 1, ino: 2}.present(rootMount{dev: 1, ino: 7}, true), "recreated in place")
+
+	dir := t.TempDir()
+	m, ok := statMount(dir)
+	require.True(t, ok)
+	assert.NotZero(t, m.ino)
+	_, ok = statMount(filepath.Join(dir, "missing"))
+	assert.False(t, ok)
+}
+
+func TestDaemon_WatcherRestartsOnRootChange(t *testing.T) {
+	orig := remountCheckInterval
+	remountCheckInterval = 20 * time.Millisecond
+	t.Cleanup(func() { remountCheckInterval = orig })
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(archivesRoot, 0755))
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+
+	store := setupTestDB(t)
+	d := NewDaemon(store, archivesRoot, spacesRoot)
+	w, err := d.newWatcher()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		d.superviseWatcher(ctx, w)
+	}()
+	defer func() { cancel(); <-stopped }()
+	time.Sleep(100 * time.Millisecond)
+
+	// The disk comes back as a new directory, with a file written while
+	// it was away.
+	require.NoError(t, os.Rename(archivesRoot, filepath.Join(dir, "Archives.old")))
+	require.NoError(t, os.MkdirAll(archivesRoot, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "offline.txt"), []byte("o"), 0644))
+
+	assert.Eventually(t, func() bool {
+		e, _ := store.GetEntryByPath(0, "offline.txt")
+		return e != nil
+	}, 5*time.Second, 20*time.Millisecond, "re-seeded once the root is back")
+	assert.NotSame(t, w, d.watcher.Load())
+
+	// The new watcher hears from the new root.
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "live.txt"), []byte("l"), 0644))
+	assert.Eventually(t, func() bool { return d.queue.Has("live.txt") }, 5*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// With Config.DeselectGraceMinutes set, deselecting a file doesn't trash
+// its Spaces copy at once: P3 records when the removal falls due and
+// leaves the copy in place, reported as "pending-removal", until then.
+// Selecting the entry again cancels the removal. The schedule is stored
+// with the entry's spaces_view row, so it survives restarts and goes with
+// the Spaces copy however that is removed.
+
+// pendingRemovalInterval is how often removals that fell due are queued.
+var pendingRemovalInterval = time.Minute
+
+// StatusPendingRemoval is the UI status of a deselected entry whose Spaces
+// copy waits out the grace period.
+const StatusPendingRemoval = "pending-removal"
+
+// removalPending reports whether P3 should leave the Spaces copy of a
+// deselected entry for now, scheduling its removal on first sight. A copy
+// without a spaces_view row was never synced and is removed at once.
+func removalPending(store *Store, entry *Entry, sv *SpacesView, relPath string) (bool, error) {
+	grace := time.Duration(activeConfig.Load().DeselectGraceMinutes) * time.Minute
+	if grace == 0 || sv == nil {
+		return false, nil
+	}
+	due, err := store.RemovalDue(entry.Inode)
+	if err != nil {
+		return false, err
+	}
+	now := nowFunc()
+	if due == 0 {
+		due = now.Add(grace).UnixNano()
+		if err := store.SetRemovalDue(entry.Inode, due); err != nil {
+			return false, err
+		}
+		sub("P3").Info("deselected, Spaces copy removed after grace period", "path", relPath, "grace", grace)
+		return true, nil
+	}
+	return now.UnixNano() < due, nil
+}
+
+// queueDueRemovals queues the entries whose removal fell due, so P3
+// trashes their Spaces copies.
+func (d *Daemon) queueDueRemovals() error {
+	due, err := d.store.ListDueRemovals(nowNano())
+	if err != nil {
+		return err
+	}
+	for i := range due {
+		relPath := d.store.RelPath(&due[i])
+		d.queue.PushSized(relPath, sizeOrZero(due[i].Size), due[i].Type == "dir")
+	}
+	if len(due) > 0 {
+		sub("removals").Info("queued due removals", "count", len(due))
+	}
+	return nil
+}
+
+// runPendingRemovals queues due removals every pendingRemovalInterval.
+// It isn't stretched in low-power mode: users wait for the countdown.
+func (d *Daemon) runPendingRemovals(ctx context.Context) {
+	l := sub("removals")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(pendingRemovalInterval):
+			if err := d.queueDueRemovals(); err != nil {
+				l.Warn("queue due removals failed", "err", err)
+			}
+		}
+	}
+}
+
+// RemovalDue returns when the Spaces copy of a deselected entry is due
+// for removal, in nanoseconds, or 0 if none is scheduled.
+func (s *Store) RemovalDue(entryIno uint64) (int64, error) {
+	var due int64
+	err := s.rdb.QueryRowContext(s.context(), "SELECT due_at FROM pending_removals WHERE entry_ino = ?", entryIno).Scan(&due)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("removal due: %w", err)
+	}
+	return due, nil
+}
+
+// SetRemovalDue schedules the removal of an entry's Spaces copy.
+func (s *Store) SetRemovalDue(entryIno uint64, due int64) error {
+	_, err := s.exec(`
+		INSERT INTO pending_removals (entry_ino, due_at) VALUES (?, ?)
+		ON CONFLICT(entry_ino) DO UPDATE SET due_at = excluded.due_at
+	`, entryIno, due)
+	if err != nil {
+		return fmt.Errorf("set removal due: %w", err)
+	}
+	return nil
+}
+
+// ListDueRemovals returns the deselected entries whose removal is due at
+// now, in nanoseconds.
+func (s *Store) ListDueRemovals(now int64) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM pending_removals p JOIN entries e ON e.inode = p.entry_ino
+		WHERE p.due_at <= ? AND NOT e.selected AND e.deleted_at = 0
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("list due removals: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// cancelRemovals drops the scheduled removals of entries selected again.
+func cancelRemovals(tx *sql.Tx, inodes []uint64) error {
+	if len(inodes) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(inodes) // a []uint64 always encodes
+	if _, err := tx.Exec("DELETE FROM pending_removals WHERE entry_ino IN (SELECT value FROM json_each(?))", string(b)); err != nil {
+		return fmt.Errorf("cancel removals: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_DeselectGracePeriod(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.DeselectGraceMinutes = 10
+	require.NoError(t, setConfig(cfg))
+	env := setupPipelineEnv(t)
+	spacesPath := filepath.Join(env.spacesRoot, "draft.txt")
+	env.writeArchive(t, "draft.txt", []byte("text"))
+	env.run(t, "draft.txt")
+	entry := registered(t, env.store, env.archivesRoot, "draft.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "draft.txt")
+	require.FileExists(t, spacesPath)
+
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "draft.txt")
+	assert.FileExists(t, spacesPath, "kept for the grace period")
+	due, err := env.store.RemovalDue(entry.Inode)
+	require.NoError(t, err)
+	assert.Equal(t, env.clock.Now().Add(10*time.Minute).UnixNano(), due)
+
+	// Reselecting cancels the removal; deselecting again restarts it.
+	env.clock.Advance(9 * time.Minute)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	due, err = env.store.RemovalDue(entry.Inode)
+	require.NoError(t, err)
+	assert.Zero(t, due)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "draft.txt")
+	env.clock.Advance(9 * time.Minute)
+	env.run(t, "draft.txt")
+	assert.FileExists(t, spacesPath)
+
+	d := NewDaemon(env.store, env.archivesRoot, env.spacesRoot)
+	require.NoError(t, d.queueDueRemovals())
+	assert.Zero(t, d.queue.Len(), "not due yet")
+	env.clock.Advance(time.Minute)
+	require.NoError(t, d.queueDueRemovals())
+	assert.True(t, d.queue.Has("draft.txt"))
+
+	env.run(t, "draft.txt")
+	assert.NoFileExists(t, spacesPath)
+	due, err = env.store.RemovalDue(entry.Inode)
+	require.NoError(t, err)
+	assert.Zero(t, due, "the schedule goes with the Spaces copy")
+}
+
+func TestHandleListEntries_PendingRemoval(t *testing.T) {
+	restoreConfig(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	env := &pipelineEnv{store: store, archivesRoot: archivesRoot, spacesRoot: spacesRoot, trashRoot: t.TempDir()}
+	env.writeArchive(t, "a.txt", []byte("a"))
+	env.run(t, "a.txt")
+	entry := registered(t, store, archivesRoot, "a.txt")
+	require.NoError(t, store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "a.txt")
+	cfg := currentConfig()
+	cfg.DeselectGraceMinutes = 5
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "a.txt")
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries", nil))
+	var resp map[string][]SyncEntryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp["items"], 1)
+	assert.Equal(t, StatusPendingRemoval, resp["items"][0].Status)
+	assert.NotZero(t, resp["items"][0].RemovalAt)
+}
+
+func TestConfig_DeselectGraceValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DeselectGraceMinutes = -1
+	assert.Error(t, cfg.Validate())
+	cfg.DeselectGraceMinutes = 30
+	assert.NoError(t, cfg.Validate())
+}
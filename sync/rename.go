@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// applyMove carries a rename the watcher paired over to the DB and the
+// other root, keeping the entry's inode and selection. It verifies the
+// pairing on disk first: either the Archives file now at to has the
+// inode registered at from (moved in Archives), or the Spaces copy moved
+// from → to while Archives still holds it at from (moved in Spaces).
+// Returns false, leaving everything untouched, when the pairing doesn't
+// hold; the caller then evaluates both paths as usual.
+func applyMove(store *Store, archivesRoot, spacesRoot, from, to string) (bool, error) {
+	l := sub("pipeline")
+	entry, _, err := lookupDB(store, archivesRoot, from)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	newParentIno, err := resolveParentInoFromDB(store, to)
+	if err != nil {
+		return false, nil // destination directory not registered yet
+	}
+	newName := filepath.Base(to)
+	if existing, err := store.GetEntryByPath(newParentIno, newName); err != nil {
+		return false, err
+	} else if existing != nil {
+		return false, nil // replaced another entry; let the pipeline sort it out
+	}
+
+	aFrom, aTo := filepath.Join(archivesRoot, from), filepath.Join(archivesRoot, to)
+	sFrom, sTo := filepath.Join(spacesRoot, from), filepath.Join(spacesRoot, to)
+	sfs := fsFor(sFrom)
+	_, _, toIno, _ := statFile(aTo)
+	switch {
+	case toIno != nil && *toIno == entry.Inode:
+		// Moved in Archives: follow with the Spaces copy, if any.
+		_, fromErr := sfs.Lstat(sFrom)
+		_, toErr := sfs.Lstat(sTo)
+		if fromErr == nil && os.IsNotExist(toErr) {
+			err := sfs.MkdirAll(filepath.Dir(sTo), 0755)
+			if err == nil {
+				err = sfs.Rename(sFrom, sTo)
+			}
+			if err != nil {
+				return false, fmt.Errorf("move spaces copy: %w", err)
+			}
+		}
+	case toIno == nil:
+		// Moved in Spaces: follow in Archives, which keeps the inode.
+		_, _, fromIno, _ := statFile(aFrom)
+		if fromIno == nil || *fromIno != entry.Inode {
+			return false, nil
+		}
+		if _, err := sfs.Lstat(sFrom); err == nil {
+			return false, nil
+		}
+		if _, err := sfs.Lstat(sTo); err != nil {
+			return false, nil
+		}
+		if err := os.MkdirAll(filepath.Dir(aTo), 0755); err != nil {
+			return false, fmt.Errorf("move archives file: %w", err)
+		}
+		if err := os.Rename(aFrom, aTo); err != nil {
+			return false, fmt.Errorf("move archives file: %w", err)
+		}
+	default:
+		return false, nil
+	}
+
+	if err := store.MoveEntry(entry.Inode, newParentIno, newName); err != nil {
+		return false, err
+	}
+	l.Info("applied rename", "from", from, "to", to, "inode", entry.Inode, "selected", entry.Selected)
+	return true, nil
+}
+
+// runMove handles a move job: applies the rename if the pairing holds,
+// then evaluates the destination and the source so both converge either
+// way. The destination goes first so P1 sees the inode's old path.
+func (d *Daemon) runMove(ctx context.Context, from, to string) error {
+	l := sub("daemon")
+	release := d.acquirePaths(from, to)
+	defer release()
+
+	moved, err := applyMove(d.store, d.archivesRoot, d.spacesRoot, from, to)
+	if err != nil {
+		l.Warn("rename not applied, evaluating paths separately", "from", from, "to", to, "err", err)
+	}
+	if moved {
+		d.pathCache.Clear()
+		pipelineStats.forget(from)
+		entry, _, _ := lookupDB(d.store, d.archivesRoot, to)
+		if entry != nil {
+			events.Publish(Event{Type: EventMoved, Path: to, Inode: entry.Inode, Data: map[string]any{"from": from}})
+		}
+	}
+	for _, p := range []string{to, from} {
+		hasQueued := func() bool { return d.queue.Has(p) }
+		if err := RunPipeline(ctx, p, d.store, d.archivesRoot, d.spacesRoot, d.trashRoot, hasQueued); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_RunMove_Archives(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Other/", "Docs/a.txt"}, map[string]bool{"Docs/a.txt": true})
+	before, _, err := lookupDB(store, archivesRoot, "Docs/a.txt")
+	require.NoError(t, err)
+	require.True(t, before.Selected)
+
+	require.NoError(t, os.Rename(filepath.Join(archivesRoot, "Docs/a.txt"), filepath.Join(archivesRoot, "Other/b.txt")))
+	require.NoError(t, h.daemon.runMove(context.Background(), "Docs/a.txt", "Other/b.txt"))
+
+	after, sv, err := lookupDB(store, archivesRoot, "Other/b.txt")
+	require.NoError(t, err)
+	require.NotNil(t, after)
+	assert.Equal(t, before.Inode, after.Inode)
+	assert.True(t, after.Selected, "selection survives the rename")
+	assert.NotNil(t, sv)
+	assert.FileExists(t, filepath.Join(spacesRoot, "Other/b.txt"))
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "Docs/a.txt"))
+	gone, _, _ := lookupDB(store, archivesRoot, "Docs/a.txt")
+	assert.Nil(t, gone)
+}
+
+func TestDaemon_RunMove_Spaces(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, map[string]bool{"Docs/a.txt": true})
+	before, _, _ := lookupDB(store, archivesRoot, "Docs/a.txt")
+
+	require.NoError(t, os.Rename(filepath.Join(spacesRoot, "Docs/a.txt"), filepath.Join(spacesRoot, "Docs/b.txt")))
+	require.NoError(t, h.daemon.runMove(context.Background(), "Docs/a.txt", "Docs/b.txt"))
+
+	after, _, _ := lookupDB(store, archivesRoot, "Docs/b.txt")
+	require.NotNil(t, after)
+	assert.Equal(t, before.Inode, after.Inode, "Archives file moved, not copied")
+	assert.True(t, after.Selected)
+	assert.FileExists(t, filepath.Join(archivesRoot, "Docs/b.txt"))
+	assert.NoFileExists(t, filepath.Join(archivesRoot, "Docs/a.txt"))
+}
+
+func TestApplyMove_UnverifiedPairing(t *testing.T) {
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+
+	// Unrelated new file: inode differs, nothing is moved.
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "b.txt"), []byte("b"), 0644))
+	moved, err := applyMove(store, archivesRoot, spacesRoot, "a.txt", "b.txt")
+	require.NoError(t, err)
+	assert.False(t, moved)
+	e, _, _ := lookupDB(store, archivesRoot, "a.txt")
+	assert.NotNil(t, e)
+
+	moved, err = applyMove(store, archivesRoot, spacesRoot, "missing.txt", "c.txt")
+	require.NoError(t, err)
+	assert.False(t, moved)
+}
+
+func TestWatcher_PairsRenames(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.DebounceMs = 50
+	require.NoError(t, setConfig(cfg))
+
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(archivesRoot, 0755))
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "a.txt"), []byte("a"), 0644))
+
+	queue := NewEvalQueue()
+	w, err := NewWatcher(archivesRoot, spacesRoot, queue)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.Rename(filepath.Join(archivesRoot, "a.txt"), filepath.Join(archivesRoot, "b.txt")))
+	require.NoError(t, os.Rename(filepath.Join(archivesRoot, "b.txt"), filepath.Join(archivesRoot, "c.txt")))
+
+	done := make(chan struct{})
+	time.AfterFunc(2*time.Second, func() { close(done) })
+	job, ok := queue.PopJob(done)
+	require.True(t, ok)
+	assert.Equal(t, Job{Path: "c.txt", From: "a.txt", QueuedAt: job.QueuedAt}, job)
+	assert.Equal(t, 0, queue.Len())
+}
@@ -0,0 +1,237 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// mtimeGrainSteps are the mtime granularities told apart, finest first:
+// ext4, xfs and btrfs keep nanoseconds, exFAT 10ms, FAT 2s.
+var mtimeGrainSteps = []time.Duration{
+	time.Nanosecond, time.Microsecond, time.Millisecond,
+	10 * time.Millisecond, time.Second, 2 * time.Second,
+}
+
+// grainProbeTime is what the probe file's mtime is set to: an odd second
+// with nanoseconds, so each step stores it as a different multiple.
+var grainProbeTime = time.Unix(1_700_000_001, 123_456_789)
+
+const (
+	// minSkew is the smallest clock skew recorded for a root, beyond its
+	// mtime granularity: below it the probe only sees timestamp coarseness.
+	minSkew = time.Second
+
+	// futureMtimeSlack is how far past its root's clock an mtime may be
+	// before it counts as implausible, e.g. from a camera with a bad clock.
+	futureMtimeSlack = time.Minute
+)
+
+// rootTime is what the probe found out about one root's timestamps.
+type rootTime struct {
+	grain time.Duration // mtime granularity
+	skew  time.Duration // how far the root's clock is ahead of ours
+}
+
+// rootTimes holds the probed rootTime of each root.
+var rootTimes = struct {
+	mu    gosync.RWMutex
+	roots map[string]rootTime
+}{roots: make(map[string]rootTime)}
+
+// RootClock is the probed mtime granularity and clock skew of one root.
+type RootClock struct {
+	Root        string `json:"root"`
+	Granularity int64  `json:"granularity"` // nanoseconds
+	Skew        int64  `json:"skew"`        // nanoseconds the root's clock is ahead
+}
+
+// probeRootTime probes the file system holding root with a file: the
+// mtime it is created with tells the root's clock skew, and the mtime it
+// reads back after setting one tells the granularity, as the coarsest
+// step that value is a multiple of.
+func probeRootTime(root string) (rootTime, error) {
+	fs := fsFor(root)
+	probe := filepath.Join(root, ".mtime-probe.sync-tmp")
+	w, _, err := fs.Append(probe)
+	if err != nil {
+		return rootTime{}, fmt.Errorf("create mtime probe: %w", err)
+	}
+	w.Close()
+	defer fs.Remove(probe) //nolint:errcheck
+	info, err := fs.Stat(probe)
+	if err != nil {
+		return rootTime{}, fmt.Errorf("stat mtime probe: %w", err)
+	}
+	skew := -sinceNow(info.ModTime())
+	if err := fs.Chtimes(probe, grainProbeTime, grainProbeTime); err != nil {
+		return rootTime{}, fmt.Errorf("set mtime probe: %w", err)
+	}
+	if info, err = fs.Stat(probe); err != nil {
+		return rootTime{}, fmt.Errorf("stat mtime probe: %w", err)
+	}
+	got := info.ModTime().UnixNano()
+	rt := rootTime{grain: time.Nanosecond}
+	for _, step := range mtimeGrainSteps[1:] {
+		if got%int64(step) != 0 {
+			break
+		}
+		rt.grain = step
+	}
+	if skew.Abs() >= rt.grain+minSkew {
+		rt.skew = skew
+	}
+	return rt, nil
+}
+
+// detectRootTimes probes and records the rootTime of each existing root,
+// logging coarse timestamps and skewed clocks, and returns a func
+// forgetting them. A root that can't be probed is compared exactly.
+func detectRootTimes(roots ...string) func() {
+	l := sub("mtime")
+	var detected []string
+	for _, root := range roots {
+		if _, err := fsFor(root).Stat(root); err != nil {
+			continue // not created yet, e.g. the trash
+		}
+		rt, err := probeRootTime(root)
+		if err != nil {
+			l.Warn("mtime granularity unknown, comparing exactly", "root", root, "err", err)
+			continue
+		}
+		if rt.grain > time.Nanosecond {
+			l.Info("coarse mtime granularity, comparing with tolerance", "root", root, "granularity", rt.grain)
+		}
+		if rt.skew != 0 {
+			l.Warn("root clock skewed, adjusting mtime ages", "root", root, "skew", rt.skew)
+		}
+		root = filepath.Clean(root)
+		rootTimes.mu.Lock()
+		rootTimes.roots[root] = rt
+		rootTimes.mu.Unlock()
+		detected = append(detected, root)
+	}
+	return func() {
+		rootTimes.mu.Lock()
+		defer rootTimes.mu.Unlock()
+		for _, root := range detected {
+			delete(rootTimes.roots, root)
+		}
+	}
+}
+
+// rootTimeOf returns the rootTime of the innermost root holding path, or
+// exact timestamps and no skew when it isn't known.
+func rootTimeOf(path string) rootTime {
+	rt := rootTime{grain: time.Nanosecond}
+	if path == "" {
+		return rt
+	}
+	path = filepath.Clean(path)
+	rootTimes.mu.RLock()
+	defer rootTimes.mu.RUnlock()
+	var best string
+	for root, t := range rootTimes.roots {
+		if len(root) > len(best) && (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) {
+			best, rt = root, t
+		}
+	}
+	return rt
+}
+
+// mtimeGrain returns the mtime granularity of the root holding path, or
+// 1ns when it isn't known.
+func mtimeGrain(path string) time.Duration {
+	return rootTimeOf(path).grain
+}
+
+// mtimeAge returns how long ago, by the clock of the root holding path,
+// mtime was. It is negative for an mtime in the root's future.
+func mtimeAge(path string, mtime time.Time) time.Duration {
+	return sinceNow(mtime) + rootTimeOf(path).skew
+}
+
+// futureMtime reports whether mtime of the file at path is implausibly
+// far in its root's future.
+func futureMtime(path string, mtime time.Time) bool {
+	return mtimeAge(path, mtime) < -futureMtimeSlack
+}
+
+// rootClocks returns the probed roots, by path.
+func rootClocks() []RootClock {
+	rootTimes.mu.RLock()
+	defer rootTimes.mu.RUnlock()
+	out := make([]RootClock, 0, len(rootTimes.roots))
+	for root, rt := range rootTimes.roots {
+		out = append(out, RootClock{Root: root, Granularity: int64(rt.grain), Skew: int64(rt.skew)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Root < out[j].Root })
+	return out
+}
+
+// sameMtime reports whether mtimes a and b are the same moment, read
+// back from file systems keeping it to within grain: the coarser side
+// may have truncated or rounded the other's value, but not by a full
+// step.
+func sameMtime(a, b int64, grain time.Duration) bool {
+	d := a - b
+	if d < 0 {
+		d = -d
+	}
+	return d < max(int64(grain), 1)
+}
+
+// mtimeOnlyChange reports whether a path that looks changed may only
+// have had its mtime go implausible: one side's mtime is in its root's
+// future, and both sides still hold the same bytes, by size and hash.
+// Timestamps from a bad clock can't be trusted to order versions, so for
+// such a path the content breaks the tie.
+func mtimeOnlyChange(archivePath, spacesPath string) (bool, error) {
+	aInfo, err := fsFor(archivePath).Stat(archivePath)
+	if err != nil {
+		return false, nil
+	}
+	sInfo, err := fsFor(spacesPath).Stat(spacesPath)
+	if err != nil {
+		return false, nil
+	}
+	if !futureMtime(archivePath, aInfo.ModTime()) && !futureMtime(spacesPath, sInfo.ModTime()) {
+		return false, nil
+	}
+	if aInfo.IsDir() || sInfo.IsDir() || aInfo.Size() != sInfo.Size() {
+		return false, nil
+	}
+	if cipher, compression := spacesEncoding(spacesPath); cipher != "" || compression != "" {
+		return false, nil // stored differently, can't be compared as is
+	}
+	aSum, err := hashFile(archivePath)
+	if err != nil {
+		return false, err
+	}
+	sSum, err := hashFile(spacesPath)
+	if err != nil {
+		return false, err
+	}
+	return aSum == sSum, nil
+}
+
+// hashFile returns the SHA-256 of the file at path.
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := fsFor(path).Open(path)
+	if err != nil {
+		return sum, fmt.Errorf("hash %s: %w", filepath.Base(path), err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, progressReader{f}); err != nil {
+		return sum, fmt.Errorf("hash %s: %w", filepath.Base(path), err)
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coarseFS reads mtimes back truncated to grain, as FAT does.
+type coarseFS struct {
+	fileSystem
+	grain time.Duration
+}
+
+type coarseInfo struct {
+	os.FileInfo
+	grain time.Duration
+}
+
+func (i coarseInfo) ModTime() time.Time {
+	ns := i.FileInfo.ModTime().UnixNano()
+	return time.Unix(0, ns-ns%int64(i.grain))
+}
+
+func (f coarseFS) Stat(name string) (os.FileInfo, error) {
+	info, err := f.fileSystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return coarseInfo{info, f.grain}, nil
+}
+
+func (f coarseFS) Lstat(name string) (os.FileInfo, error) { return f.Stat(name) }
+
+func (f coarseFS) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := f.fileSystem.ReadDir(name)
+	for i := range infos {
+		infos[i] = coarseInfo{infos[i], f.grain}
+	}
+	return infos, err
+}
+
+func TestProbeRootTime_Grain(t *testing.T) {
+	for _, grain := range mtimeGrainSteps {
+		t.Run(grain.String(), func(t *testing.T) {
+			root := t.TempDir()
+			t.Cleanup(mountFS(coarseFS{osFS{}, grain}, root))
+			rt, err := probeRootTime(root)
+			require.NoError(t, err)
+			assert.Equal(t, grain, rt.grain)
+			assert.Zero(t, rt.skew)
+			assert.NoFileExists(t, filepath.Join(root, ".mtime-probe.sync-tmp"))
+		})
+	}
+}
+
+func TestSameMtime(t *testing.T) {
+	assert.True(t, sameMtime(5, 5, time.Nanosecond))
+	assert.False(t, sameMtime(5, 6, time.Nanosecond))
+	assert.False(t, sameMtime(5, 6, 0), "unknown compares exactly")
+
+	a := time.Unix(1_700_000_001, 999_000_000).UnixNano()
+	assert.True(t, sameMtime(a, time.Unix(1_700_000_000, 0).UnixNano(), 2*time.Second), "truncated")
+	assert.True(t, sameMtime(a, time.Unix(1_700_000_002, 0).UnixNano(), 2*time.Second), "rounded up")
+	assert.False(t, sameMtime(a, time.Unix(1_700_000_004, 0).UnixNano(), 2*time.Second))
+}
+
+// A copy on a FAT Spaces carries a truncated mtime. Registering it again
+// must not take it for a Spaces edit and copy it back over Archives.
+func TestPipeline_CoarseSpacesMtime(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	cfg := currentConfig()
+	cfg.ArchivesRoot, cfg.SpacesRoot = env.archivesRoot, env.spacesRoot
+	require.NoError(t, setConfig(cfg))
+	t.Cleanup(mountFS(coarseFS{osFS{}, 2 * time.Second}, env.spacesRoot))
+	t.Cleanup(detectRootTimes(env.archivesRoot, env.spacesRoot))
+	require.Equal(t, 2*time.Second, mtimeGrain(filepath.Join(env.spacesRoot, "doc.txt")))
+
+	env.clock.Set(time.Unix(1_700_000_001, 500_000_000))
+	env.writeArchive(t, "doc.txt", []byte("content"))
+	env.run(t, "doc.txt")
+	entries, err := env.store.ListChildren(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, env.store.SetSelected([]uint64{entries[0].Inode}, true))
+	env.run(t, "doc.txt")
+	st, _ := soakState(t, env, "doc.txt")
+	require.Equal(t, 31, st.Scenario())
+
+	// Lose the spaces_view, as after restoring the DB from a backup.
+	require.NoError(t, env.store.DeleteSpacesView(entries[0].Inode))
+	env.run(t, "doc.txt")
+	env.run(t, "doc.txt")
+
+	st, entry := soakState(t, env, "doc.txt")
+	assert.Equal(t, 31, st.Scenario())
+	aMtime, _, _, _ := statFile(filepath.Join(env.archivesRoot, "doc.txt"))
+	require.NotNil(t, aMtime)
+	assert.Equal(t, time.Unix(1_700_000_001, 500_000_000).UnixNano(), *aMtime, "Archives left alone")
+	assert.Equal(t, *aMtime, entry.Mtime)
+}
+
+// skewFS reads mtimes back skew ahead, as from a server with a fast clock.
+type skewFS struct {
+	fileSystem
+	skew time.Duration
+}
+
+type skewInfo struct {
+	os.FileInfo
+	skew time.Duration
+}
+
+func (i skewInfo) ModTime() time.Time { return i.FileInfo.ModTime().Add(i.skew) }
+
+func (f skewFS) Stat(name string) (os.FileInfo, error) {
+	info, err := f.fileSystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return skewInfo{info, f.skew}, nil
+}
+
+func TestProbeRootTime_Skew(t *testing.T) {
+	clk := useFakeClock(t, time.Unix(1_700_000_000, 0))
+	mem := &memFS{files: make(map[string]*memFile)}
+	require.NoError(t, mem.MkdirAll("/mem/nfs", 0755))
+	t.Cleanup(mountFS(skewFS{mem, 5 * time.Minute}, "/mem/nfs"))
+	t.Cleanup(detectRootTimes("/mem/nfs"))
+
+	assert.Equal(t, []RootClock{{Root: "/mem/nfs", Granularity: 1, Skew: int64(5 * time.Minute)}}, rootClocks())
+	p := "/mem/nfs/a.txt"
+	assert.Equal(t, time.Duration(0), mtimeAge(p, clk.Now().Add(5*time.Minute)), "just written on the server")
+	assert.False(t, futureMtime(p, clk.Now().Add(5*time.Minute)))
+	assert.True(t, futureMtime(p, clk.Now().Add(5*time.Minute+2*futureMtimeSlack)))
+	assert.False(t, futureMtime("/elsewhere/a.txt", clk.Now().Add(time.Second)))
+}
+
+// Both sides touched by a bad clock with the content unchanged: the
+// content breaks the tie, so there is no conflict and nothing is copied.
+func TestPipeline_FutureMtimeSameContent(t *testing.T) {
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "IMG_0001.jpg", []byte("jpeg"))
+	env.run(t, "IMG_0001.jpg")
+	entries, err := env.store.ListChildren(0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, env.store.SetSelected([]uint64{entries[0].Inode}, true))
+	env.run(t, "IMG_0001.jpg")
+
+	future := env.clock.Now().AddDate(1, 0, 0)
+	require.NoError(t, os.Chtimes(filepath.Join(env.archivesRoot, "IMG_0001.jpg"), future, future))
+	require.NoError(t, os.Chtimes(filepath.Join(env.spacesRoot, "IMG_0001.jpg"), future, future.Add(time.Second)))
+	st, _ := soakState(t, env, "IMG_0001.jpg")
+	require.True(t, st.ADirty && st.SDirty)
+	env.clock.Advance(time.Minute)
+	env.run(t, "IMG_0001.jpg")
+
+	st, entry := soakState(t, env, "IMG_0001.jpg")
+	assert.Equal(t, 31, st.Scenario())
+	assert.Equal(t, future.UnixNano(), entry.Mtime)
+	children, err := env.store.ListChildren(0)
+	require.NoError(t, err)
+	assert.Len(t, children, 1, "no conflict copy")
+	info, err := os.Stat(filepath.Join(env.spacesRoot, "IMG_0001.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, future.Add(time.Second).UnixNano(), info.ModTime().UnixNano(), "Spaces not copied over")
+}
@@ -0,0 +1,189 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RuleAction is what an auto-select rule does to a matching file.
+type RuleAction string
+
+const (
+	RuleSelect   RuleAction = "select"
+	RuleDeselect RuleAction = "deselect"
+)
+
+// AutoSelectRule selects or deselects files by extension, size, path prefix
+// and age. All non-zero criteria must match. Rules only apply to files.
+// A select rule can also store the Spaces copies of its files compressed,
+// or put derivatives made by a transcode command there instead.
+type AutoSelectRule struct {
+	Action     RuleAction `json:"action" yaml:"action" toml:"action"`
+	Extensions []string   `json:"extensions,omitempty" yaml:"extensions" toml:"extensions"` // without dot, case-insensitive
+	PathPrefix string     `json:"pathPrefix,omitempty" yaml:"pathPrefix" toml:"pathPrefix"` // relative to the Archives root
+	MinSize    int64      `json:"minSize,omitempty" yaml:"minSize" toml:"minSize"`          // bytes, inclusive
+	MaxSize    int64      `json:"maxSize,omitempty" yaml:"maxSize" toml:"maxSize"`          // bytes, inclusive
+	MinAgeDays int        `json:"minAgeDays,omitempty" yaml:"minAgeDays" toml:"minAgeDays"` // mtime at least N days ago
+	MaxAgeDays int        `json:"maxAgeDays,omitempty" yaml:"maxAgeDays" toml:"maxAgeDays"` // mtime at most N days ago
+	Compress   bool       `json:"compress,omitempty" yaml:"compress" toml:"compress"`       // zstd-compress Spaces copies, select rules only
+	Transcode  string     `json:"transcode,omitempty" yaml:"transcode" toml:"transcode"`    // command writing {dst} from {src} to put in Spaces instead, select rules only
+}
+
+// validate checks a single rule.
+func (r AutoSelectRule) validate() error {
+	if r.Action != RuleSelect && r.Action != RuleDeselect {
+		return fmt.Errorf("action must be select or deselect, got %q", r.Action)
+	}
+	if r.Compress && r.Action != RuleSelect {
+		return fmt.Errorf("compress only applies to select rules")
+	}
+	if r.Transcode != "" {
+		if r.Action != RuleSelect {
+			return fmt.Errorf("transcode only applies to select rules")
+		}
+		if r.Compress {
+			return fmt.Errorf("a rule can compress or transcode, not both")
+		}
+		if err := validateTranscode(r.Transcode); err != nil {
+			return err
+		}
+	}
+	if r.MinSize < 0 || r.MaxSize < 0 || r.MinAgeDays < 0 || r.MaxAgeDays < 0 {
+		return fmt.Errorf("size and age bounds must not be negative")
+	}
+	if r.MaxSize > 0 && r.MinSize > r.MaxSize {
+		return fmt.Errorf("minSize %d exceeds maxSize %d", r.MinSize, r.MaxSize)
+	}
+	if r.MaxAgeDays > 0 && r.MinAgeDays > r.MaxAgeDays {
+		return fmt.Errorf("minAgeDays %d exceeds maxAgeDays %d", r.MinAgeDays, r.MaxAgeDays)
+	}
+	return nil
+}
+
+// matches reports whether a file at relPath with the given size and
+// mtime (nanoseconds) satisfies every criterion of the rule.
+func (r AutoSelectRule) matches(relPath string, size int64, mtime int64, now time.Time) bool {
+	if r.PathPrefix != "" {
+		prefix := strings.Trim(filepath.ToSlash(r.PathPrefix), "/")
+		if prefix != "" && relPath != prefix && !strings.HasPrefix(relPath, prefix+"/") {
+			return false
+		}
+	}
+	if len(r.Extensions) > 0 {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(relPath)), ".")
+		found := false
+		for _, want := range r.Extensions {
+			if strings.EqualFold(strings.TrimPrefix(want, "."), ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.MinSize > 0 && size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+	age := now.Sub(time.Unix(0, mtime))
+	if r.MinAgeDays > 0 && age < time.Duration(r.MinAgeDays)*24*time.Hour {
+		return false
+	}
+	if r.MaxAgeDays > 0 && age > time.Duration(r.MaxAgeDays)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// evalRules returns the action of the first matching rule, or "" if none match.
+func evalRules(rules []AutoSelectRule, relPath string, size int64, mtime int64) RuleAction {
+	now := nowFunc()
+	for _, r := range rules {
+		if r.matches(relPath, size, mtime, now) {
+			return r.Action
+		}
+	}
+	return ""
+}
+
+// compressRule reports whether the first rule matching a file asks for
+// its Spaces copy to be compressed.
+func compressRule(rules []AutoSelectRule, relPath string, size int64, mtime int64) bool {
+	now := nowFunc()
+	for _, r := range rules {
+		if r.matches(relPath, size, mtime, now) {
+			return r.Compress
+		}
+	}
+	return false
+}
+
+// anyTranscodeRule reports whether any rule transcodes Spaces copies.
+func anyTranscodeRule(rules []AutoSelectRule) bool {
+	for _, r := range rules {
+		if r.Transcode != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// anyCompressRule reports whether any rule compresses Spaces copies.
+func anyCompressRule(rules []AutoSelectRule) bool {
+	for _, r := range rules {
+		if r.Compress {
+			return true
+		}
+	}
+	return false
+}
+
+// reapplyRules walks every registered file and applies the active rules,
+// updating the selection of files whose matching rule disagrees with it.
+// Excluded entries and files without a matching rule are left alone.
+// Returns the changed entries keyed by relative path so the caller can queue them.
+func reapplyRules(store *Store) (map[string]Entry, error) {
+	rules := currentConfig().Rules
+	changed := make(map[string]Entry)
+	if len(rules) == 0 {
+		return changed, nil
+	}
+	err := reapplyRulesUnder(store, rules, 0, "", changed)
+	if errors.Is(err, errTreeTruncated) {
+		sub("rules").Warn("rules reapplied to part of the tree", "changed", len(changed))
+	} else if err != nil {
+		return nil, err
+	}
+	sub("rules").Info("rules reapplied", "rules", len(rules), "changed", len(changed))
+	return changed, nil
+}
+
+func reapplyRulesUnder(store *Store, rules []AutoSelectRule, parentIno uint64, parentPath string, changed map[string]Entry) error {
+	return store.walkTree(parentIno, parentPath, func(e *Entry, relPath string) (bool, error) {
+		child := *e
+		if child.Excluded {
+			return false, nil
+		}
+		if child.Type == "dir" {
+			return true, nil
+		}
+
+		action := evalRules(rules, relPath, sizeOrZero(child.Size), child.Mtime)
+		want := action == RuleSelect
+		if action == "" || want == child.Selected {
+			return false, nil
+		}
+		if err := store.SetSelected([]uint64{child.Inode}, want); err != nil {
+			return false, fmt.Errorf("apply rule to %s: %w", relPath, err)
+		}
+		child.Selected = want
+		changed[relPath] = child
+		return false, nil
+	})
+}
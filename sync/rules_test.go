@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoSelectRule_Matches(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	day := int64(24 * time.Hour)
+	fresh := now.UnixNano() - day
+	stale := now.UnixNano() - 30*day
+
+	tests := []struct {
+		name  string
+		rule  AutoSelectRule
+		path  string
+		size  int64
+		mtime int64
+		want  bool
+	}{
+		{"ext match", AutoSelectRule{Extensions: []string{"md"}}, "a/b.MD", 1, fresh, true},
+		{"ext with dot", AutoSelectRule{Extensions: []string{".md"}}, "b.md", 1, fresh, true},
+		{"ext miss", AutoSelectRule{Extensions: []string{"md"}}, "b.txt", 1, fresh, false},
+		{"prefix match", AutoSelectRule{PathPrefix: "Notes/"}, "Notes/x.md", 1, fresh, true},
+		{"prefix is segment", AutoSelectRule{PathPrefix: "Notes"}, "NotesOld/x.md", 1, fresh, false},
+		{"min size", AutoSelectRule{MinSize: 100}, "x", 99, fresh, false},
+		{"max size", AutoSelectRule{MaxSize: 100}, "x", 100, fresh, true},
+		{"max age", AutoSelectRule{MaxAgeDays: 7}, "x", 1, stale, false},
+		{"min age", AutoSelectRule{MinAgeDays: 7}, "x", 1, stale, true},
+		{"all criteria", AutoSelectRule{Extensions: []string{"md"}, PathPrefix: "Notes", MaxSize: 10}, "Notes/a.md", 20, fresh, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.matches(tt.path, tt.size, tt.mtime, now))
+		})
+	}
+}
+
+func TestEvalRules_FirstMatchWins(t *testing.T) {
+	rules := []AutoSelectRule{
+		{Action: RuleDeselect, MinSize: 1000},
+		{Action: RuleSelect, Extensions: []string{"md"}},
+	}
+	mtime := time.Now().UnixNano()
+	assert.Equal(t, RuleSelect, evalRules(rules, "a.md", 10, mtime))
+	assert.Equal(t, RuleDeselect, evalRules(rules, "big.md", 5000, mtime))
+	assert.Equal(t, RuleAction(""), evalRules(rules, "a.txt", 10, mtime))
+}
+
+func TestConfigValidate_Rules(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Rules = []AutoSelectRule{{Action: "maybe"}}
+	assert.Error(t, cfg.Validate())
+
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, MinSize: 10, MaxSize: 5}}
+	assert.Error(t, cfg.Validate())
+
+	cfg.Rules = []AutoSelectRule{{Action: RuleDeselect, Compress: true}}
+	assert.Error(t, cfg.Validate(), "only selected files have Spaces copies")
+
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"md"}, Compress: true}}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestPipeline_P1_AutoSelectRule(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"md"}, PathPrefix: "Notes"}}
+	require.NoError(t, setConfig(cfg))
+
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "Notes/a.md", []byte("note"))
+	env.writeArchive(t, "Notes/b.txt", []byte("text"))
+	env.run(t, "Notes")
+	env.run(t, "Notes/a.md")
+	env.run(t, "Notes/b.txt")
+
+	_, err := os.Stat(filepath.Join(env.spacesRoot, "Notes", "a.md"))
+	assert.NoError(t, err, "matching file synced on registration")
+	_, err = os.Stat(filepath.Join(env.spacesRoot, "Notes", "b.txt"))
+	assert.True(t, os.IsNotExist(err), "non-matching file stays archived")
+}
+
+func TestHandlePatchConfig_ReappliesRules(t *testing.T) {
+	restoreConfig(t)
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "big.iso", Type: "blob", Size: ptr(int64(10_000)), Mtime: 1, Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "small.iso", Type: "blob", Size: ptr(int64(10)), Mtime: 1, Selected: true}))
+
+	body := `{"rules":[{"action":"deselect","minSize":1000}]}`
+	w := httptest.NewRecorder()
+	h.HandlePatchConfig(w, httptest.NewRequest("PATCH", "/api/sync/config", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	e, _ := store.GetEntry(1)
+	assert.False(t, e.Selected)
+	e, _ = store.GetEntry(2)
+	assert.True(t, e.Selected)
+	assert.True(t, h.daemon.Queue().Has("big.iso"))
+	assert.False(t, h.daemon.Queue().Has("small.iso"))
+}
@@ -0,0 +1,188 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EventQuarantined is published when P2 finds malware in a Spaces file
+// and quarantines it instead of copying it into Archives.
+const EventQuarantined = "quarantined"
+
+// quarantineDir is where quarantined Spaces files go, under the trash
+// root so it is on the same disk or remote as Spaces.
+const quarantineDir = "quarantine"
+
+// scanner checks the content of a Spaces file before P2 copies it into
+// Archives.
+type scanner interface {
+	// scan reads the file relPath from r and returns the name of what it
+	// found in it, or "" if it is clean.
+	scan(ctx context.Context, relPath string, r io.Reader) (string, error)
+}
+
+// scannerFor returns the scanner cfg sets up, or nil for none.
+func scannerFor(cfg *Config) scanner {
+	switch {
+	case cfg.ScanCommand != "":
+		return commandScanner{argv: strings.Fields(cfg.ScanCommand)}
+	case cfg.ScanClamd != "":
+		return clamdScanner{addr: cfg.ScanClamd}
+	}
+	return nil
+}
+
+// commandScanner runs an external command with the file's content on
+// stdin and its relative path in FB_SYNC_SCAN_PATH. Exit status 0 means
+// clean and 1 infected, as with clamscan; the last line the command
+// printed names the finding. Anything else is a failed scan.
+type commandScanner struct {
+	argv []string
+}
+
+// scanOutputMax bounds how much of a scan command's output is kept.
+const scanOutputMax = 64 << 10
+
+func (c commandScanner) scan(ctx context.Context, relPath string, r io.Reader) (string, error) {
+	cmd := exec.CommandContext(ctx, c.argv[0], c.argv[1:]...)
+	cmd.Stdin = r
+	cmd.Env = append(os.Environ(), envPrefix+"SCAN_PATH="+relPath)
+	var out limitedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exit) && exit.ExitCode() == 1 && ctx.Err() == nil:
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if found := strings.TrimSpace(lines[len(lines)-1]); found != "" {
+			return found, nil
+		}
+		return "unnamed finding", nil
+	}
+	return "", fmt.Errorf("scan command: %w: %s", err, strings.TrimSpace(out.String()))
+}
+
+// limitedBuffer keeps the first scanOutputMax bytes written to it.
+type limitedBuffer struct{ bytes.Buffer }
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := scanOutputMax - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// clamdScanner streams the file to a clamd daemon with INSTREAM, over a
+// Unix socket when addr is a path and TCP otherwise.
+type clamdScanner struct {
+	addr string
+}
+
+// clamdChunk is the size of the chunks streamed to clamd.
+const clamdChunk = 64 << 10
+
+func (c clamdScanner) scan(ctx context.Context, relPath string, r io.Reader) (string, error) {
+	network := "tcp"
+	if filepath.IsAbs(c.addr) {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.addr)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck // fails only on a closed conn
+	}
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				// clamd hangs up once the stream is over its size limit,
+				// and says so in its reply.
+				break
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read: %w", err)
+		}
+	}
+	conn.Write([]byte{0, 0, 0, 0}) //nolint:errcheck // the reply tells
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil && len(reply) == 0 {
+		return "", fmt.Errorf("clamd reply: %w", err)
+	}
+	msg := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	msg = strings.TrimPrefix(msg, "stream: ")
+	switch {
+	case msg == "OK":
+		return "", nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return strings.TrimSuffix(msg, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", msg)
+}
+
+// scanFromSpaces scans the Spaces file relPath before P2 copies it into
+// Archives, if a scanner is configured. A file found infected is moved to
+// the quarantine under trashRoot, logged as an error and published as
+// EventQuarantined, and true returned: the pipeline then sees it gone
+// from Spaces. A scan that fails returns its error, so the file is not
+// copied until a later run scans it.
+func scanFromSpaces(ctx context.Context, relPath, spacesPath, trashRoot string) (bool, error) {
+	cfg := activeConfig.Load()
+	sc := scannerFor(cfg)
+	if sc == nil {
+		return false, nil
+	}
+	l := sub("scan")
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ScanTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	r, err := openSpaces(spacesPath)
+	if err != nil {
+		return false, fmt.Errorf("open for scan: %w", err)
+	}
+	start := time.Now()
+	found, err := sc.scan(ctx, relPath, r)
+	r.Close()
+	if err != nil {
+		l.Warn("scan failed, not copying to Archives", "path", relPath, "err", err)
+		return false, fmt.Errorf("scan: %w", err)
+	}
+	if found == "" {
+		l.Debug("scan clean", "path", relPath, "durationMs", time.Since(start).Milliseconds())
+		return false, nil
+	}
+
+	quarantinePath, err := SoftDeleteAt(spacesPath, filepath.Join(trashRoot, quarantineDir), relPath)
+	if err != nil {
+		return false, fmt.Errorf("quarantine: %w", err)
+	}
+	l.Error("malware found in Spaces, quarantined instead of copying to Archives", "path", relPath, "found", found, "quarantinePath", quarantinePath)
+	events.Publish(Event{Type: EventQuarantined, Path: relPath, Data: map[string]any{"found": found, "quarantinePath": quarantinePath}})
+	return true, nil
+}
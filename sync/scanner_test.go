@@ -102,10 +102,15 @@ func TestClassifyType(t *testing.T) {
 		{"photo.gif", false, "image"},
 		{"doc.pdf", false, "pdf"},
 		{"readme.txt", false, "text"},
-		{"code.go", false, "text"},
+		{"code.go", false, "code"},
 		{"data.json", false, "text"},
-		{"page.html", false, "text"},
-		{"style.css", false, "text"},
+		{"page.html", false, "code"},
+		{"style.css", false, "code"},
+		{"notes.md", false, "text"},
+		{"backup.tar.gz", false, "archive"},
+		{"budget.xlsx", false, "spreadsheet"},
+		{"table.csv", false, "spreadsheet"},
+		{"novel.epub", false, "ebook"},
 		{"unknown.xyz", false, "blob"},
 		{"noext", false, "blob"},
 	}
@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// numScenarios is the number of scenarios in the truth table (1-34).
+const numScenarios = 34
+
+// ScenarioCount is the occurrence counter for one scenario.
+type ScenarioCount struct {
+	Scenario int    `json:"scenario"`
+	Status   string `json:"status"`
+	Count    int64  `json:"count"`
+	LastSeen int64  `json:"lastSeen"` // nanoseconds
+}
+
+// UnconvergedEntry is a path whose pipeline run ended in a non-terminal
+// scenario or failed.
+type UnconvergedEntry struct {
+	Path     string `json:"path"`
+	Scenario int    `json:"scenario"`
+	Status   string `json:"status"`
+	Since    int64  `json:"since"`           // nanoseconds, first non-terminal result
+	LastSeen int64  `json:"lastSeen"`        // nanoseconds, most recent evaluation
+	Error    string `json:"error,omitempty"` // why the most recent run failed
+}
+
+// unconvergedMaxAge is how long an unconverged path is tracked without
+// being evaluated again. Paths that went away without a run of their own,
+// such as those under a removed directory, age out after it.
+const unconvergedMaxAge = 24 * time.Hour
+
+// unconvergedSweepInterval is how often recordFinal drops aged paths.
+const unconvergedSweepInterval = time.Hour
+
+// isTerminalScenario reports whether a scenario is a convergence target:
+// nonexistent (#1), archived (#15) or synced (#31).
+func isTerminalScenario(sc int) bool {
+	return sc == 1 || sc == 15 || sc == 31
+}
+
+// scenarioStats counts evaluated scenarios and tracks paths whose pipeline
+// run failed or did not reach a terminal scenario.
+type scenarioStats struct {
+	mu          gosync.Mutex
+	counts      [numScenarios + 1]int64
+	lastSeen    [numScenarios + 1]int64
+	unconverged map[string]*UnconvergedEntry
+	lastSweep   int64 // nanoseconds, last time aged entries were dropped
+}
+
+var pipelineStats = newScenarioStats()
+
+func newScenarioStats() *scenarioStats {
+	return &scenarioStats{unconverged: make(map[string]*UnconvergedEntry)}
+}
+
+// recordEvaluated counts the scenario a path was in when evaluation started.
+func (s *scenarioStats) recordEvaluated(sc int) {
+	if sc < 1 || sc > numScenarios {
+		return
+	}
+	now := nowNano()
+	s.mu.Lock()
+	s.counts[sc]++
+	s.lastSeen[sc] = now
+	s.mu.Unlock()
+}
+
+// recordFinal tracks the scenario a path ended in after the pipeline ran.
+func (s *scenarioStats) recordFinal(path string, state State) {
+	sc := state.Scenario()
+	now := nowNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now-s.lastSweep > unconvergedSweepInterval.Nanoseconds() {
+		s.sweep(now)
+	}
+	if sc == 1 {
+		s.forgetLocked(path) // gone, and so is anything that was under it
+		return
+	}
+	if isTerminalScenario(sc) {
+		delete(s.unconverged, path)
+		return
+	}
+	u := s.trackLocked(path, now)
+	u.Scenario = sc
+	u.Status = state.UIStatus()
+	u.Error = ""
+}
+
+// recordFailed tracks a path whose pipeline run failed with err, in the
+// scenario it was last found in; state is nil if the run failed before it
+// was evaluated.
+func (s *scenarioStats) recordFailed(path string, state *State, err error) {
+	now := nowNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.trackLocked(path, now)
+	if state != nil {
+		u.Scenario = state.Scenario()
+		u.Status = state.UIStatus()
+	} else if u.Status == "" {
+		u.Status = "unknown"
+	}
+	u.Error = err.Error()
+}
+
+// trackLocked returns the tracked entry of path, adding it if new, as
+// evaluated at now. Caller must hold s.mu.
+func (s *scenarioStats) trackLocked(path string, now int64) *UnconvergedEntry {
+	u, ok := s.unconverged[path]
+	if !ok {
+		u = &UnconvergedEntry{Path: path, Since: now}
+		s.unconverged[path] = u
+	}
+	u.LastSeen = now
+	return u
+}
+
+// forget stops tracking path and the paths under it, for a path that was
+// removed, renamed or deselected: its old result no longer applies.
+func (s *scenarioStats) forget(path string) {
+	s.mu.Lock()
+	s.forgetLocked(path)
+	s.mu.Unlock()
+}
+
+func (s *scenarioStats) forgetLocked(path string) {
+	delete(s.unconverged, path)
+	prefix := path + "/"
+	for p := range s.unconverged {
+		if strings.HasPrefix(p, prefix) {
+			delete(s.unconverged, p)
+		}
+	}
+}
+
+// sweep drops the paths not evaluated within unconvergedMaxAge of now.
+func (s *scenarioStats) sweep(now int64) {
+	s.lastSweep = now
+	for p, u := range s.unconverged {
+		if now-u.LastSeen > unconvergedMaxAge.Nanoseconds() {
+			delete(s.unconverged, p)
+		}
+	}
+}
+
+// counters returns the per-scenario counters for scenarios seen at least once.
+func (s *scenarioStats) counters() []ScenarioCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ScenarioCount, 0)
+	for sc := 1; sc <= numScenarios; sc++ {
+		if s.counts[sc] == 0 {
+			continue
+		}
+		out = append(out, ScenarioCount{
+			Scenario: sc,
+			Status:   scenarioStatus(sc),
+			Count:    s.counts[sc],
+			LastSeen: s.lastSeen[sc],
+		})
+	}
+	return out
+}
+
+// stuck returns paths that have been non-terminal for longer than threshold,
+// oldest first.
+func (s *scenarioStats) stuck(threshold time.Duration) []UnconvergedEntry {
+	cutoff := nowNano() - threshold.Nanoseconds()
+	s.mu.Lock()
+	out := make([]UnconvergedEntry, 0)
+	for _, u := range s.unconverged {
+		if u.Since <= cutoff {
+			out = append(out, *u)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Since < out[j].Since })
+	return out
+}
+
+// scenarioStatus returns the UI status label for a scenario number.
+func scenarioStatus(sc int) string {
+	for _, st := range allStates() {
+		if st.Scenario() == sc {
+			return st.UIStatus()
+		}
+	}
+	return "unknown"
+}
+
+// allStates enumerates every combination of the 7 state variables.
+func allStates() []State {
+	states := make([]State, 0, 128)
+	for bits := 0; bits < 128; bits++ {
+		states = append(states, State{
+			ADisk:    bits&1 != 0,
+			ADb:      bits&2 != 0,
+			SDisk:    bits&4 != 0,
+			SDb:      bits&8 != 0,
+			Selected: bits&16 != 0,
+			ADirty:   bits&32 != 0,
+			SDirty:   bits&64 != 0,
+		})
+	}
+	return states
+}
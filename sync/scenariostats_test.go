@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetPipelineStats(t *testing.T) {
+	t.Helper()
+	prev := pipelineStats
+	pipelineStats = newScenarioStats()
+	t.Cleanup(func() { pipelineStats = prev })
+}
+
+func TestScenarioStats_PipelineConverges(t *testing.T) {
+	resetPipelineStats(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "a.txt", []byte("a"))
+
+	// #2 untracked → registered → archived
+	env.run(t, "a.txt")
+
+	counts := pipelineStats.counters()
+	require.Len(t, counts, 1)
+	assert.Equal(t, 2, counts[0].Scenario)
+	assert.Equal(t, "untracked", counts[0].Status)
+	assert.Equal(t, int64(1), counts[0].Count)
+	assert.Empty(t, pipelineStats.stuck(0), "archived is terminal")
+}
+
+func TestScenarioStats_TracksFailedRuns(t *testing.T) {
+	resetPipelineStats(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "Docs/a.txt", []byte("a"))
+	env.run(t, "Docs")
+	env.run(t, "Docs/a.txt")
+	entry, _, err := lookupDB(env.store, env.archivesRoot, "Docs/a.txt")
+	require.NoError(t, err)
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+
+	// A file where the Spaces directory should be fails the copy
+	env.writeSpaces(t, "Docs", []byte("in the way"))
+	err = RunPipeline(context.Background(), "Docs/a.txt", env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil)
+	require.Error(t, err)
+	stuck := pipelineStats.stuck(0)
+	require.Len(t, stuck, 1)
+	assert.Equal(t, "Docs/a.txt", stuck[0].Path)
+	assert.Equal(t, 17, stuck[0].Scenario)
+	assert.Equal(t, err.Error(), stuck[0].Error)
+
+	require.NoError(t, os.Remove(filepath.Join(env.spacesRoot, "Docs")))
+	env.run(t, "Docs/a.txt")
+	assert.Empty(t, pipelineStats.stuck(0))
+}
+
+func TestScenarioStats_StuckThreshold(t *testing.T) {
+	resetPipelineStats(t)
+	base := time.Now()
+	clk := useFakeClock(t, base)
+
+	// Selected but missing from Spaces (#17 syncing)
+	pipelineStats.recordFinal("stuck.txt", State{ADisk: true, ADb: true, Selected: true})
+	assert.Empty(t, pipelineStats.stuck(time.Minute))
+
+	clk.Set(base.Add(2 * time.Minute))
+	pipelineStats.recordFinal("stuck.txt", State{ADisk: true, ADb: true, Selected: true})
+	stuck := pipelineStats.stuck(time.Minute)
+	require.Len(t, stuck, 1)
+	assert.Equal(t, 17, stuck[0].Scenario)
+	assert.Equal(t, base.UnixNano(), stuck[0].Since)
+
+	// Reaching a terminal scenario clears it
+	pipelineStats.recordFinal("stuck.txt", State{ADisk: true, ADb: true, SDisk: true, SDb: true, Selected: true})
+	assert.Empty(t, pipelineStats.stuck(0))
+}
+
+func TestScenarioStats_ForgetsGonePaths(t *testing.T) {
+	resetPipelineStats(t)
+	base := time.Now()
+	clk := useFakeClock(t, base)
+	syncing := State{ADisk: true, ADb: true, Selected: true}
+	for _, p := range []string{"Docs/a.txt", "Docs/b.txt", "Docsx.txt", "Old/c.txt", "d.txt", "e.txt"} {
+		pipelineStats.recordFinal(p, syncing)
+	}
+	paths := func() []string {
+		var out []string
+		for _, u := range pipelineStats.stuck(0) {
+			out = append(out, u.Path)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	// The directory was removed: nonexistent now, and so are its files.
+	pipelineStats.recordFinal("Docs", State{})
+	pipelineStats.forget("Old")   // renamed or deselected
+	pipelineStats.forget("d.txt") // ditto
+	assert.Equal(t, []string{"Docsx.txt", "e.txt"}, paths())
+
+	// Paths not evaluated for a day age out.
+	clk.Set(base.Add(unconvergedMaxAge / 2))
+	pipelineStats.recordFinal("e.txt", syncing)
+	clk.Set(base.Add(unconvergedMaxAge + time.Minute))
+	pipelineStats.recordFinal("f.txt", syncing)
+	assert.Equal(t, []string{"e.txt", "f.txt"}, paths())
+}
+
+func TestHandleReport(t *testing.T) {
+	resetPipelineStats(t)
+	h, _, _, _ := setupHandlersEnv(t)
+	pipelineStats.recordFinal("x.txt", State{ADisk: true, SDisk: true})
+
+	w := httptest.NewRecorder()
+	h.HandleReport(w, httptest.NewRequest("GET", "/api/sync/report?olderThan=0", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Converged bool               `json:"converged"`
+		Items     []UnconvergedEntry `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Converged)
+	require.Len(t, resp.Items, 1)
+	assert.Equal(t, "x.txt", resp.Items[0].Path)
+
+	w = httptest.NewRecorder()
+	h.HandleReport(w, httptest.NewRequest("GET", "/api/sync/report?olderThan=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,167 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+)
+
+// stallThreshold is how long the worker may go without progress while the
+// queue is non-empty before it is considered stalled.
+const stallThreshold = 5 * time.Minute
+
+// workBytes counts the bytes copied and hashed by the workers. The
+// monitor counts it moving as progress, so a copy or hash of a large
+// file that outlasts stallThreshold isn't taken for a stall.
+var workBytes atomic.Int64
+
+// progressReader reads from r, counting what it reads in workBytes.
+type progressReader struct{ r io.Reader }
+
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	workBytes.Add(int64(n))
+	return n, err
+}
+
+// stallCheckInterval is how often stalls are checked when the systemd
+// watchdog is not enabled.
+const stallCheckInterval = 30 * time.Second
+
+// sdNotify sends a state string to the systemd notification socket
+// named by $NOTIFY_SOCKET. It is a no-op when not running under systemd.
+// Returns true if the message was delivered.
+func sdNotify(state string) bool {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false
+	}
+	// Abstract namespace sockets are prefixed with '@'
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		sub("sdnotify").Warn("sd_notify dial failed", "socket", socket, "err", err)
+		return false
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		sub("sdnotify").Warn("sd_notify write failed", "state", state, "err", err)
+		return false
+	}
+	sub("sdnotify").Debug("sd_notify sent", "state", state)
+	return true
+}
+
+// watchdogInterval returns the systemd watchdog interval from $WATCHDOG_USEC.
+// Returns 0 if the watchdog is disabled or belongs to another process.
+func watchdogInterval() time.Duration {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Microsecond
+}
+
+// ProgressMonitor tracks worker progress and detects stalls: the queue
+// is non-empty but the worker hasn't finished an item, nor moved any
+// workBytes, for too long.
+type ProgressMonitor struct {
+	lastProgress atomic.Int64 // unix nanoseconds
+	lastBytes    atomic.Int64 // workBytes when last checked
+	threshold    time.Duration
+	queueLen     func() int
+
+	mu      gosync.Mutex
+	stalled bool
+}
+
+// NewProgressMonitor creates a monitor that reports a stall when
+// queueLen() > 0 and no progress was recorded within threshold.
+func NewProgressMonitor(threshold time.Duration, queueLen func() int) *ProgressMonitor {
+	m := &ProgressMonitor{threshold: threshold, queueLen: queueLen}
+	m.lastProgress.Store(nowNano())
+	m.lastBytes.Store(workBytes.Load())
+	return m
+}
+
+// Tick records that the worker made progress.
+func (m *ProgressMonitor) Tick() {
+	m.lastProgress.Store(nowNano())
+}
+
+// Stalled reports whether the worker is currently stalled.
+// A transition into or out of the stalled state is logged once.
+func (m *ProgressMonitor) Stalled() bool {
+	if n := workBytes.Load(); m.lastBytes.Swap(n) != n {
+		m.Tick()
+	}
+	idle := time.Duration(nowNano() - m.lastProgress.Load())
+	stalled := m.queueLen() > 0 && idle > m.threshold
+
+	m.mu.Lock()
+	changed := stalled != m.stalled
+	m.stalled = stalled
+	m.mu.Unlock()
+
+	if changed {
+		l := sub("watchdog")
+		if stalled {
+			l.Error("worker stalled", "idleSec", int64(idle.Seconds()), "queueLen", m.queueLen())
+		} else {
+			l.Info("worker recovered from stall")
+		}
+	}
+	return stalled
+}
+
+// LastProgress returns the time of the last recorded progress.
+func (m *ProgressMonitor) LastProgress() time.Time {
+	return time.Unix(0, m.lastProgress.Load())
+}
+
+// runWatchdog periodically checks the monitor for stalls. If the systemd
+// watchdog is enabled (interval > 0), it is stroked at half the interval as
+// long as the worker isn't stalled; when stalled, the stroke is withheld so
+// systemd can restart the service. Blocks until ctx is cancelled.
+func runWatchdog(ctx context.Context, interval time.Duration, monitor *ProgressMonitor) {
+	l := sub("watchdog")
+	tick := stallCheckInterval
+	if interval > 0 {
+		tick = interval / 2
+		l.Info("systemd watchdog enabled", "intervalMs", interval.Milliseconds())
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if monitor.Stalled() {
+				sdNotify("STATUS=worker stalled")
+				continue
+			}
+			if interval > 0 {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}
+}
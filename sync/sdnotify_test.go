@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSdNotify_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.False(t, sdNotify("READY=1"))
+}
+
+func TestSdNotify_SendsState(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockPath, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", sockPath)
+	require.True(t, sdNotify("READY=1"))
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "")
+	assert.Equal(t, time.Duration(0), watchdogInterval())
+
+	t.Setenv("WATCHDOG_USEC", "2000000")
+	assert.Equal(t, 2*time.Second, watchdogInterval())
+
+	// Watchdog addressed to a different process
+	t.Setenv("WATCHDOG_PID", "1")
+	assert.Equal(t, time.Duration(0), watchdogInterval())
+}
+
+func TestProgressMonitor_Stall(t *testing.T) {
+	base := time.Now()
+	clk := useFakeClock(t, base)
+
+	queueLen := 0
+	m := NewProgressMonitor(time.Minute, func() int { return queueLen })
+
+	// Idle with empty queue is never a stall
+	clk.Set(base.Add(10 * time.Minute))
+	assert.False(t, m.Stalled())
+
+	// Non-empty queue without progress beyond threshold is a stall
+	queueLen = 3
+	assert.True(t, m.Stalled())
+
+	// Progress clears the stall
+	m.Tick()
+	assert.False(t, m.Stalled())
+	assert.Equal(t, nowNano(), m.LastProgress().UnixNano())
+}
+
+func TestProgressMonitor_BytesMovingIsProgress(t *testing.T) {
+	base := time.Now()
+	clk := useFakeClock(t, base)
+	m := NewProgressMonitor(time.Minute, func() int { return 1 })
+
+	// A long copy: no item finishes, but bytes keep moving.
+	for i := 1; i <= 10; i++ {
+		clk.Set(base.Add(time.Duration(i) * 30 * time.Second))
+		_, err := io.Copy(io.Discard, progressReader{strings.NewReader("chunk")})
+		require.NoError(t, err)
+		assert.False(t, m.Stalled(), "after %d checks", i)
+	}
+
+	// The copy hangs.
+	clk.Set(base.Add(12 * time.Minute))
+	assert.True(t, m.Stalled())
+}
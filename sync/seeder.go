@@ -2,33 +2,67 @@ package sync
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
 // Seed performs the initial database population by scanning both
 // Archives and Spaces directories.
 func Seed(store *Store, archivesPath, spacesPath string) error {
+	_, err := seed(store, archivesPath, spacesPath, nil, nil)
+	return err
+}
+
+// seed is Seed that also records directory fingerprints and returns the
+// directories whose fingerprint changed since the previous seed. A nil
+// map means there is nothing to compare against (first boot). Progress is
+// reported to progress, which may be nil.
+//
+// With a lazy index, only the Archives directories leading to Spaces
+// entries are registered and recorded in it; the rest is registered on
+// demand. Fingerprints are dropped then, and only entries with pending
+// selection work need reconciling.
+func seed(store *Store, archivesPath, spacesPath string, progress *seedTracker, lazy *lazyIndex) (changed map[string]bool, err error) {
 	l := sub("seeder")
-	l.Info("seed starting", "archivesPath", archivesPath, "spacesPath", spacesPath)
+	progress.start()
+	defer func() { progress.finish(err) }()
+	l.Info("seed starting", "archivesPath", archivesPath, "spacesPath", spacesPath, "lazy", lazy != nil)
 	start := time.Now()
 
+	var archiveFiles map[string]FileStat
 	scanStart := time.Now()
-	archiveFiles, err := ScanDir(archivesPath)
-	if err != nil {
-		return fmt.Errorf("scan archives: %w", err)
+	if lazy == nil {
+		archiveFiles, err = scanDir(archivesPath, progress.scanned)
+		if err != nil {
+			return nil, fmt.Errorf("scan archives: %w", err)
+		}
+		l.Info("seed archives scanned", "entries", len(archiveFiles), "durationMs", time.Since(scanStart).Milliseconds())
 	}
-	l.Info("seed archives scanned", "entries", len(archiveFiles), "durationMs", time.Since(scanStart).Milliseconds())
 
 	scanStart = time.Now()
-	spacesFiles, err := ScanDir(spacesPath)
+	progress.phase(SeedScanSpaces)
+	spacesFiles, err := scanDir(spacesPath, progress.scanned)
 	if err != nil {
-		return fmt.Errorf("scan spaces: %w", err)
+		return nil, fmt.Errorf("scan spaces: %w", err)
 	}
 	l.Info("seed spaces scanned", "entries", len(spacesFiles), "durationMs", time.Since(scanStart).Milliseconds())
 
+	var listedDirs []string
+	if lazy != nil {
+		scanStart = time.Now()
+		progress.phase(SeedScanArchives)
+		archiveFiles, listedDirs, err = lazyScan(archivesPath, spacesFiles)
+		if err != nil {
+			return nil, fmt.Errorf("scan archives: %w", err)
+		}
+		l.Info("seed archives listed", "dirs", len(listedDirs), "entries", len(archiveFiles), "durationMs", time.Since(scanStart).Milliseconds())
+	}
+
 	// Build a set of Spaces relative paths for quick lookup
 	spacesSet := make(map[string]bool, len(spacesFiles))
 	for relPath := range spacesFiles {
@@ -52,47 +86,79 @@ func Seed(store *Store, archivesPath, spacesPath string) error {
 	// Sort dirs by depth
 	sortByDepth(dirs)
 
-	// Insert directories first
+	// Entries new under an auto-select directory are selected, files
+	// unless a rule deselects them; those registered before keep their
+	// selection through the upsert. The depth order marks each directory
+	// before its children are seen.
+	rules := currentConfig().Rules
+	autoDirs, err := store.AutoSelectDirs()
+	if err != nil {
+		return nil, err
+	}
+	autoSelected := make(map[string]bool)
+	inAutoDir := func(relPath string) bool {
+		parent := filepath.Dir(relPath)
+		return parent != "." && autoSelected[parent]
+	}
+
+	// Insert directories first, then files, in batched transactions.
+	// Parents resolve from the scan, and the depth order keeps each
+	// directory ahead of its children.
+	batch := make([]Entry, 0, len(dirs)+len(files))
 	for _, pe := range dirs {
 		parentIno, err := resolveParentIno(store, archivesPath, pe.relPath, archiveFiles)
 		if err != nil {
-			return fmt.Errorf("resolve parent for %s: %w", pe.relPath, err)
+			return nil, fmt.Errorf("resolve parent for %s: %w", pe.relPath, err)
 		}
+		auto := inAutoDir(pe.relPath)
+		autoSelected[pe.relPath] = auto || autoDirs[pe.stat.Inode]
 		inSpaces := spacesSet[pe.relPath]
-		if err := store.UpsertEntry(Entry{
+		batch = append(batch, Entry{
 			Inode:     pe.stat.Inode,
 			ParentIno: parentIno,
 			Name:      pe.stat.Name,
 			Type:      "dir",
 			Mtime:     pe.stat.Mtime,
-			Selected:  inSpaces,
-		}); err != nil {
-			return fmt.Errorf("insert dir %s: %w", pe.relPath, err)
-		}
-		l.Debug("seed insert dir", "path", pe.relPath, "inode", pe.stat.Inode, "selected", inSpaces)
+			Selected:  inSpaces || auto,
+		})
+		l.Debug("seed insert dir", "path", pe.relPath, "inode", pe.stat.Inode, "selected", inSpaces || auto)
 	}
 
-	// Insert files
 	for _, pe := range files {
 		parentIno, err := resolveParentIno(store, archivesPath, pe.relPath, archiveFiles)
 		if err != nil {
-			return fmt.Errorf("resolve parent for %s: %w", pe.relPath, err)
+			return nil, fmt.Errorf("resolve parent for %s: %w", pe.relPath, err)
 		}
-		inSpaces := spacesSet[pe.relPath]
 		size := pe.stat.Size
-		fileType := ClassifyType(pe.stat.Name, false)
-		if err := store.UpsertEntry(Entry{
+		selected := spacesSet[pe.relPath] ||
+			(inAutoDir(pe.relPath) && evalRules(rules, pe.relPath, size, pe.stat.Mtime) != RuleDeselect)
+		fileType := ClassifyFile(filepath.Join(archivesPath, pe.relPath), pe.stat.Name)
+		batch = append(batch, Entry{
 			Inode:     pe.stat.Inode,
 			ParentIno: parentIno,
 			Name:      pe.stat.Name,
 			Type:      fileType,
 			Size:      &size,
 			Mtime:     pe.stat.Mtime,
-			Selected:  inSpaces,
-		}); err != nil {
-			return fmt.Errorf("insert file %s: %w", pe.relPath, err)
+			Selected:  selected,
+		})
+		l.Debug("seed insert file", "path", pe.relPath, "inode", pe.stat.Inode, "type", fileType, "selected", selected)
+	}
+	progress.phase(SeedInsertEntries)
+	progress.total(len(batch))
+	insertStart := time.Now()
+	for start := 0; start < len(batch); start += upsertBatchSize {
+		chunk := batch[start:min(start+upsertBatchSize, len(batch))]
+		if err := store.UpsertEntriesBatch(chunk); err != nil {
+			return nil, fmt.Errorf("insert archives entries: %w", err)
+		}
+		progress.inserted(len(chunk))
+	}
+	l.Info("seed phase 1 complete", "entries", len(batch), "durationMs", time.Since(insertStart).Milliseconds())
+	if lazy != nil {
+		for _, dir := range listedDirs {
+			lazy.markListed(archiveFiles[dir].Inode) // zero value for the root
 		}
-		l.Debug("seed insert file", "path", pe.relPath, "inode", pe.stat.Inode, "type", fileType, "selected", inSpaces)
 	}
 
 	// Debug: log match/miss stats and sample misses to diagnose path format issues
@@ -123,24 +189,29 @@ func Seed(store *Store, archivesPath, spacesPath string) error {
 	// This runs BEFORE Spaces-only processing so that already-synced files are
 	// immediately visible as "synced" to the pipeline worker.
 	l.Info("seed phase 2: spaces_view")
-	now := time.Now().UnixNano()
-	var svCount int
+	progress.phase(SeedSpacesView)
+	now := nowNano()
+	var views []SpacesView
 	for relPath, spStat := range spacesFiles {
 		archStat, inArchive := archiveFiles[relPath]
 		if !inArchive {
 			continue
 		}
-		if err := store.UpsertSpacesView(SpacesView{
+		view := SpacesView{
 			EntryIno:    archStat.Inode,
 			SyncedMtime: spStat.Mtime,
 			CheckedAt:   now,
-		}); err != nil {
-			return fmt.Errorf("insert spaces_view for %s: %w", relPath, err)
 		}
-		svCount++
+		if !spStat.IsDir {
+			view.Cipher, view.Compression = spacesEncoding(filepath.Join(spacesPath, relPath))
+		}
+		views = append(views, view)
 		l.Debug("seed spaces_view created", "path", relPath, "inode", archStat.Inode)
 	}
-	l.Info("seed phase 2 complete", "spacesViewCount", svCount)
+	if err := store.UpsertSpacesViewsBatch(views); err != nil {
+		return nil, fmt.Errorf("insert spaces_view: %w", err)
+	}
+	l.Info("seed phase 2 complete", "spacesViewCount", len(views))
 
 	// Phase 3: Handle Spaces-only files (scenario #3) — SafeCopy S→A + INSERT + spaces_view
 	var spacesOnlyDirs, spacesOnlyFiles []pathEntry
@@ -156,21 +227,22 @@ func Seed(store *Store, archivesPath, spacesPath string) error {
 
 	if len(spacesOnlyDirs)+len(spacesOnlyFiles) > 0 {
 		l.Info("seed phase 3: spaces-only", "dirs", len(spacesOnlyDirs), "files", len(spacesOnlyFiles))
+		progress.phase(SeedSpacesOnly)
 
 		// Dirs first (shallow → deep)
 		sortByDepth(spacesOnlyDirs)
 		for _, pe := range spacesOnlyDirs {
 			archDir := filepath.Join(archivesPath, pe.relPath)
 			if err := os.MkdirAll(archDir, 0755); err != nil {
-				return fmt.Errorf("mkdir archives %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("mkdir archives %s: %w", pe.relPath, err)
 			}
 			aMtime, _, aInode, _ := statFile(archDir)
 			if aInode == nil {
-				return fmt.Errorf("stat archives dir %s: inode unavailable", pe.relPath)
+				return nil, fmt.Errorf("stat archives dir %s: inode unavailable", pe.relPath)
 			}
 			parentIno, err := resolveParentInoFromDB(store, pe.relPath)
 			if err != nil {
-				return fmt.Errorf("resolve parent for spaces-only dir %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("resolve parent for spaces-only dir %s: %w", pe.relPath, err)
 			}
 			if err := store.UpsertEntry(Entry{
 				Inode:     *aInode,
@@ -180,14 +252,14 @@ func Seed(store *Store, archivesPath, spacesPath string) error {
 				Mtime:     *aMtime,
 				Selected:  true,
 			}); err != nil {
-				return fmt.Errorf("insert spaces-only dir %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("insert spaces-only dir %s: %w", pe.relPath, err)
 			}
 			if err := store.UpsertSpacesView(SpacesView{
 				EntryIno:    *aInode,
 				SyncedMtime: pe.stat.Mtime,
 				CheckedAt:   now,
 			}); err != nil {
-				return fmt.Errorf("insert spaces_view for spaces-only dir %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("insert spaces_view for spaces-only dir %s: %w", pe.relPath, err)
 			}
 			l.Debug("seed spaces-only dir", "path", pe.relPath, "inode", *aInode)
 		}
@@ -196,44 +268,128 @@ func Seed(store *Store, archivesPath, spacesPath string) error {
 		for _, pe := range spacesOnlyFiles {
 			src := filepath.Join(spacesPath, pe.relPath)
 			dst := filepath.Join(archivesPath, pe.relPath)
-			if err := SafeCopy(context.Background(), src, dst, nil); err != nil {
-				return fmt.Errorf("seed copy S→A %s: %w", pe.relPath, err)
+			if err := copyFromSpaces(context.Background(), pe.relPath, src, dst, nil); err != nil {
+				return nil, fmt.Errorf("seed copy S→A %s: %w", pe.relPath, err)
 			}
 			l.Debug("seed spaces-only file copied", "path", pe.relPath)
 
 			aMtime, _, aInode, _ := statFile(dst)
 			if aInode == nil {
-				return fmt.Errorf("stat archives file %s after copy: inode unavailable", pe.relPath)
+				return nil, fmt.Errorf("stat archives file %s after copy: inode unavailable", pe.relPath)
 			}
 			parentIno, err := resolveParentInoFromDB(store, pe.relPath)
 			if err != nil {
-				return fmt.Errorf("resolve parent for spaces-only file %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("resolve parent for spaces-only file %s: %w", pe.relPath, err)
 			}
 			size := pe.stat.Size
 			if err := store.UpsertEntry(Entry{
 				Inode:     *aInode,
 				ParentIno: parentIno,
 				Name:      pe.stat.Name,
-				Type:      ClassifyType(pe.stat.Name, false),
+				Type:      ClassifyFile(dst, pe.stat.Name),
 				Size:      &size,
 				Mtime:     *aMtime,
 				Selected:  true,
 			}); err != nil {
-				return fmt.Errorf("insert spaces-only file %s: %w", pe.relPath, err)
+				return nil, fmt.Errorf("insert spaces-only file %s: %w", pe.relPath, err)
 			}
-			if err := store.UpsertSpacesView(SpacesView{
+			view := SpacesView{
 				EntryIno:    *aInode,
 				SyncedMtime: *aMtime,
 				CheckedAt:   now,
-			}); err != nil {
-				return fmt.Errorf("insert spaces_view for spaces-only file %s: %w", pe.relPath, err)
+			}
+			view.Cipher, view.Compression = spacesEncoding(src)
+			if err := store.UpsertSpacesView(view); err != nil {
+				return nil, fmt.Errorf("insert spaces_view for spaces-only file %s: %w", pe.relPath, err)
 			}
 			l.Debug("seed spaces-only file registered", "path", pe.relPath, "inode", *aInode)
 		}
 	}
 
+	if lazy != nil {
+		// Partial scans make no usable fingerprints; a later full seed
+		// must not skip directories that were never registered.
+		if err := store.ReplaceDirFingerprints(nil); err != nil {
+			return nil, err
+		}
+		changed = map[string]bool{}
+	} else if changed, err = updateFingerprints(store, archivesPath, spacesPath, archiveFiles, spacesFiles); err != nil {
+		return nil, err
+	}
+
 	l.Info("seed complete", "archiveEntries", len(archiveFiles), "spacesEntries", len(spacesFiles), "durationMs", time.Since(start).Milliseconds())
-	return nil
+	return changed, nil
+}
+
+// updateFingerprints records the fingerprint of every scanned directory
+// and returns the directories whose fingerprint is new or changed, or nil
+// when no fingerprints were recorded before.
+func updateFingerprints(store *Store, archivesPath, spacesPath string, archiveFiles, spacesFiles map[string]FileStat) (map[string]bool, error) {
+	prev, err := store.LoadDirFingerprints()
+	if err != nil {
+		return nil, err
+	}
+	cur := make(map[string]DirFingerprint)
+	fold := func(dir string, hash uint64, child bool) {
+		fp := cur[dir]
+		fp.Hash += int64(hash)
+		if child {
+			fp.Children++
+		}
+		cur[dir] = fp
+	}
+	for side, files := range []map[string]FileStat{archiveFiles, spacesFiles} {
+		if mtime, _, _, _ := statFile([]string{archivesPath, spacesPath}[side]); mtime != nil {
+			fold("", fingerprintHash(side, "", 0, *mtime, true), false)
+		}
+		for relPath, stat := range files {
+			if stat.IsDir {
+				fold(relPath, fingerprintHash(side, "", 0, stat.Mtime, true), false)
+			}
+			parent := filepath.Dir(relPath)
+			if parent == "." {
+				parent = ""
+			}
+			// Subdirectories carry their own fingerprint; only their name
+			// counts here.
+			if stat.IsDir {
+				fold(parent, fingerprintHash(side, filepath.Base(relPath), 0, 0, true), true)
+			} else {
+				fold(parent, fingerprintHash(side, filepath.Base(relPath), stat.Size, stat.Mtime, false), true)
+			}
+		}
+	}
+	if err := store.ReplaceDirFingerprints(cur); err != nil {
+		return nil, err
+	}
+	if len(prev) == 0 {
+		return nil, nil
+	}
+
+	changed := make(map[string]bool)
+	for dir, fp := range cur {
+		if old, ok := prev[dir]; !ok || old != fp {
+			changed[dir] = true
+		}
+	}
+	sub("seeder").Info("seed fingerprints compared", "dirs", len(cur), "changed", len(changed))
+	return changed, nil
+}
+
+// fingerprintHash hashes one child of a directory in the root numbered
+// side, or with an empty name the directory itself.
+func fingerprintHash(side int, name string, size, mtime int64, isDir bool) uint64 {
+	var buf [18]byte
+	buf[0] = byte(side)
+	binary.LittleEndian.PutUint64(buf[1:], uint64(size))
+	binary.LittleEndian.PutUint64(buf[9:], uint64(mtime))
+	if isDir {
+		buf[17] = 1
+	}
+	h := fnv.New64a()
+	h.Write(buf[:])       //nolint:errcheck // a hash never fails
+	h.Write([]byte(name)) //nolint:errcheck
+	return h.Sum64()
 }
 
 // resolveParentIno finds the parent inode for a given relative path.
@@ -252,16 +408,9 @@ func resolveParentIno(store *Store, root, relPath string, files map[string]FileS
 }
 
 func sortByDepth(entries []pathEntry) {
-	// Simple insertion sort — number of dirs is typically small
-	for i := 1; i < len(entries); i++ {
-		key := entries[i]
-		j := i - 1
-		for j >= 0 && depth(entries[j].relPath) > depth(key.relPath) {
-			entries[j+1] = entries[j]
-			j--
-		}
-		entries[j+1] = key
-	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return depth(entries[i].relPath) < depth(entries[j].relPath)
+	})
 }
 
 func depth(path string) int {
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	gosync "sync"
+)
+
+// Seed phases reported by SeedStatus, in order.
+const (
+	SeedPending       = "pending"
+	SeedScanArchives  = "scan-archives"
+	SeedScanSpaces    = "scan-spaces"
+	SeedInsertEntries = "insert-entries"
+	SeedSpacesView    = "spaces-view"
+	SeedSpacesOnly    = "spaces-only"
+	SeedDone          = "done"
+	SeedFailed        = "failed"
+)
+
+// SeedStatus is the progress of the startup seed.
+type SeedStatus struct {
+	Phase      string `json:"phase"`
+	Scanned    int64  `json:"scanned"`  // entries found in both roots so far
+	Total      int64  `json:"total"`    // Archives entries to insert, known after scanning
+	Inserted   int64  `json:"inserted"` // Archives entries inserted so far
+	StartedAt  int64  `json:"startedAt,omitempty"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// seedTracker records seed progress for the status API. A nil tracker
+// ignores updates, so Seed can run without one.
+type seedTracker struct {
+	mu     gosync.Mutex
+	status SeedStatus
+}
+
+func (t *seedTracker) update(fn func(s *SeedStatus)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	fn(&t.status)
+	t.mu.Unlock()
+}
+
+func (t *seedTracker) start() {
+	t.update(func(s *SeedStatus) {
+		*s = SeedStatus{Phase: SeedScanArchives, StartedAt: nowNano()}
+	})
+}
+
+func (t *seedTracker) phase(p string) {
+	t.update(func(s *SeedStatus) { s.Phase = p })
+}
+
+func (t *seedTracker) scanned() {
+	t.update(func(s *SeedStatus) { s.Scanned++ })
+}
+
+func (t *seedTracker) total(n int) {
+	t.update(func(s *SeedStatus) { s.Total = int64(n) })
+}
+
+func (t *seedTracker) inserted(n int) {
+	t.update(func(s *SeedStatus) { s.Inserted += int64(n) })
+}
+
+func (t *seedTracker) finish(err error) {
+	t.update(func(s *SeedStatus) {
+		s.Phase = SeedDone
+		if err != nil {
+			s.Phase = SeedFailed
+			s.Error = err.Error()
+		}
+		s.FinishedAt = nowNano()
+	})
+}
+
+// snapshot returns the current status; "pending" before the seed starts.
+func (t *seedTracker) snapshot() SeedStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.status
+	if s.Phase == "" {
+		s.Phase = SeedPending
+	}
+	return s
+}
+
+// seeding reports whether a seed is running, so listings may be partial.
+func (t *seedTracker) seeding() bool {
+	switch t.snapshot().Phase {
+	case SeedPending, SeedDone, SeedFailed:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeed_ReportsProgress(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "docs", "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "b.txt"), []byte("b"), 0644))
+
+	_, err := seed(store, archivesRoot, spacesRoot, &h.daemon.seedStatus, nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.HandleSeedStatus(w, httptest.NewRequest("GET", "/api/sync/seed-status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var status SeedStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, SeedDone, status.Phase)
+	assert.Equal(t, int64(3), status.Scanned)
+	assert.Equal(t, int64(2), status.Total)
+	assert.Equal(t, int64(2), status.Inserted)
+	assert.NotZero(t, status.FinishedAt)
+	assert.Empty(t, status.Error)
+}
+
+func TestSeedTracker_SeedingMarker(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	list := func() map[string]any {
+		w := httptest.NewRecorder()
+		h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Equal(t, SeedPending, h.daemon.SeedStatus().Phase)
+	assert.NotContains(t, list(), "seeding")
+
+	h.daemon.seedStatus.start()
+	assert.Equal(t, true, list()["seeding"])
+
+	h.daemon.seedStatus.finish(errors.New("disk gone"))
+	assert.NotContains(t, list(), "seeding")
+	status := h.daemon.SeedStatus()
+	assert.Equal(t, SeedFailed, status.Phase)
+	assert.Equal(t, "disk gone", status.Error)
+}
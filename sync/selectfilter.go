@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SelectFilter narrows the selection of a directory to the files of some
+// extensions, types or sizes. All non-empty criteria must match. It is
+// stored on the directory and also decides which files appearing under
+// it later are selected.
+type SelectFilter struct {
+	Extensions []string `json:"extensions,omitempty"` // without dot, case-insensitive
+	Types      []string `json:"types,omitempty"`      // TypeCategories
+	MaxSize    int64    `json:"maxSize,omitempty"`    // bytes, inclusive; 0 = any size
+}
+
+func (f SelectFilter) validate() error {
+	for _, t := range f.Types {
+		if !validCategory(t) {
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	for _, ext := range f.Extensions {
+		if strings.Trim(ext, ". ") == "" {
+			return fmt.Errorf("empty extension")
+		}
+	}
+	if f.MaxSize < 0 {
+		return fmt.Errorf("maxSize must not be negative, got %d", f.MaxSize)
+	}
+	return nil
+}
+
+// normalized returns f with its extensions lowercased and undotted.
+func (f SelectFilter) normalized() SelectFilter {
+	exts := make([]string, 0, len(f.Extensions))
+	for _, ext := range f.Extensions {
+		exts = append(exts, strings.ToLower(strings.TrimLeft(strings.TrimSpace(ext), ".")))
+	}
+	f.Extensions = exts
+	return f
+}
+
+// matches reports whether a file with the given name, type and size
+// passes the filter. Directories never do: they are selected through
+// their files.
+func (f SelectFilter) matches(name, entryType string, size int64) bool {
+	if entryType == "dir" {
+		return false
+	}
+	if len(f.Extensions) > 0 {
+		lower, found := strings.ToLower(name), false
+		for _, ext := range f.Extensions {
+			if strings.HasSuffix(lower, "."+ext) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == entryType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return f.MaxSize == 0 || size <= f.MaxSize
+}
+
+// sql returns the condition, on the columns of an entries row, that f
+// matches it, and its arguments; "" for no filter. It agrees with matches.
+func (f *SelectFilter) sql() (string, []any) {
+	if f == nil {
+		return "", nil
+	}
+	clause := " AND type != 'dir'"
+	var args []any
+	if len(f.Extensions) > 0 {
+		b, _ := json.Marshal(f.Extensions) // a []string always encodes
+		clause += " AND EXISTS (SELECT 1 FROM json_each(?) WHERE substr(lower(name), -length(value) - 1) = '.' || value)"
+		args = append(args, string(b))
+	}
+	if len(f.Types) > 0 {
+		b, _ := json.Marshal(f.Types)
+		clause += " AND type IN (SELECT value FROM json_each(?))"
+		args = append(args, string(b))
+	}
+	if f.MaxSize > 0 {
+		clause += " AND COALESCE(size, 0) <= ?"
+		args = append(args, f.MaxSize)
+	}
+	return clause, args
+}
+
+// SetSelectedFiltered is SetSelectedUndoable selecting, with the selection
+// of the directories among inodes narrowed to the files filter matches.
+// The filter is stored on them for files appearing later; a nil filter
+// selects everything, as SetSelectedUndoable does.
+func (s *Store) SetSelectedFiltered(inodes, exclude []uint64, filter *SelectFilter) (int64, error) {
+	if filter != nil {
+		f := filter.normalized()
+		filter = &f
+	}
+	return s.setSelected(inodes, true, exclude, filter, true)
+}
+
+// setSelectionFilter replaces the filters of dirIno and the directories
+// under it, but those in skip, with filter, or drops them if it is nil.
+func setSelectionFilter(tx *sql.Tx, dirIno uint64, filter *SelectFilter, skip string) error {
+	_, err := tx.Exec(`
+		WITH RECURSIVE sub(inode) AS (
+			SELECT ?
+			UNION ALL
+			SELECT e.inode FROM entries e JOIN sub ON e.parent_ino = sub.inode
+			WHERE e.type = 'dir' AND e.inode NOT IN (SELECT value FROM json_each(?))
+		)
+		DELETE FROM selection_filters WHERE dir_ino IN (SELECT inode FROM sub)
+	`, dirIno, skip)
+	if err != nil {
+		return fmt.Errorf("clear selection filters: %w", err)
+	}
+	if filter == nil {
+		return nil
+	}
+	b, _ := json.Marshal(filter)
+	if _, err := tx.Exec("INSERT INTO selection_filters (dir_ino, filter) VALUES (?, ?)", dirIno, string(b)); err != nil {
+		return fmt.Errorf("store selection filter: %w", err)
+	}
+	return nil
+}
+
+// GetSelectionFilter returns the filter stored on a directory, or nil.
+func (s *Store) GetSelectionFilter(dirIno uint64) (*SelectFilter, error) {
+	var raw string
+	err := s.rdb.QueryRowContext(s.context(), "SELECT filter FROM selection_filters WHERE dir_ino = ?", dirIno).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get selection filter: %w", err)
+	}
+	return decodeSelectFilter(raw)
+}
+
+// SelectionFilterFor returns the filter of the nearest directory from
+// dirIno up that has one, which decides if a file appearing in dirIno is
+// selected; nil if none has.
+func (s *Store) SelectionFilterFor(dirIno uint64) (*SelectFilter, error) {
+	var raw string
+	err := s.rdb.QueryRowContext(s.context(), `
+		WITH RECURSIVE up(inode, parent_ino, depth) AS (
+			SELECT inode, parent_ino, 0 FROM entries WHERE inode = ?
+			UNION ALL
+			SELECT e.inode, e.parent_ino, up.depth + 1 FROM entries e JOIN up ON e.inode = up.parent_ino
+			WHERE up.parent_ino != 0
+		)
+		SELECT f.filter FROM up JOIN selection_filters f ON f.dir_ino = up.inode
+		ORDER BY up.depth LIMIT 1
+	`, dirIno).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("selection filter for %d: %w", dirIno, err)
+	}
+	return decodeSelectFilter(raw)
+}
+
+func decodeSelectFilter(raw string) (*SelectFilter, error) {
+	var f SelectFilter
+	if err := json.Unmarshal([]byte(raw), &f); err != nil {
+		return nil, fmt.Errorf("decode selection filter: %w", err)
+	}
+	return &f, nil
+}
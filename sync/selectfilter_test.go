@@ -0,0 +1,104 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSelectedFiltered(t *testing.T) {
+	store := setupTestDB(t)
+	for _, e := range []Entry{
+		{Inode: 10, Name: "scans", Type: "dir", Mtime: 1},
+		{Inode: 11, ParentIno: 10, Name: "a.pdf", Type: "pdf", Size: ptr(int64(50)), Mtime: 1},
+		{Inode: 12, ParentIno: 10, Name: "a.jpg", Type: "image", Size: ptr(int64(50)), Mtime: 1},
+		{Inode: 13, ParentIno: 10, Name: "2024", Type: "dir", Mtime: 1},
+		{Inode: 14, ParentIno: 13, Name: "B.PDF", Type: "pdf", Size: ptr(int64(80)), Mtime: 1},
+		{Inode: 15, ParentIno: 13, Name: "huge.pdf", Type: "pdf", Size: ptr(int64(500)), Mtime: 1},
+	} {
+		require.NoError(t, store.UpsertEntry(e))
+	}
+
+	opID, err := store.SetSelectedFiltered([]uint64{10}, nil, &SelectFilter{Extensions: []string{".PDF"}, MaxSize: 100})
+	require.NoError(t, err)
+	assert.NotZero(t, opID)
+	for ino, want := range map[uint64]bool{11: true, 12: false, 14: true, 15: false} {
+		e, err := store.GetEntry(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, e.Selected, e.Name)
+	}
+
+	f, err := store.SelectionFilterFor(13)
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	assert.Equal(t, []string{"pdf"}, f.Extensions, "stored normalized")
+	f, err = store.GetSelectionFilter(13)
+	require.NoError(t, err)
+	assert.Nil(t, f, "only the selected folder holds the filter")
+
+	// Deselecting drops the filter.
+	require.NoError(t, store.SetSelected([]uint64{10}, false))
+	f, err = store.SelectionFilterFor(13)
+	require.NoError(t, err)
+	assert.Nil(t, f)
+}
+
+func TestPipeline_SelectionFilterTakesNewFiles(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "scans/old.pdf", []byte("%PDF-1.4 old"))
+	env.run(t, "scans")
+	env.run(t, "scans/old.pdf")
+	dir := registered(t, env.store, env.archivesRoot, "scans")
+	_, err := env.store.SetSelectedFiltered([]uint64{dir.Inode}, nil, &SelectFilter{Types: []string{"pdf"}})
+	require.NoError(t, err)
+	env.run(t, "scans/old.pdf")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "scans", "old.pdf"))
+
+	env.writeArchive(t, "scans/new.pdf", []byte("%PDF-1.4 new"))
+	env.writeArchive(t, "scans/notes.txt", []byte("not a scan"))
+	env.run(t, "scans/new.pdf")
+	env.run(t, "scans/notes.txt")
+	assert.True(t, registered(t, env.store, env.archivesRoot, "scans/new.pdf").Selected)
+	assert.False(t, registered(t, env.store, env.archivesRoot, "scans/notes.txt").Selected)
+	env.run(t, "scans/new.pdf")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "scans", "new.pdf"))
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "scans", "notes.txt"))
+
+	// A deselect rule still wins.
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleDeselect, MinSize: 1000}}
+	require.NoError(t, setConfig(cfg))
+	env.writeArchive(t, "scans/big.pdf", append([]byte("%PDF-1.4 "), bytes.Repeat([]byte("x"), 1000)...))
+	env.run(t, "scans/big.pdf")
+	assert.False(t, registered(t, env.store, env.archivesRoot, "scans/big.pdf").Selected)
+}
+
+func TestHandleSelect_Filter(t *testing.T) {
+	h, store, _, _ := setupHandlersEnv(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 10, Name: "scans", Type: "dir", Mtime: 1}))
+
+	post := func(req SelectRequest) int {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		h.HandleSelect(w, httptest.NewRequest("POST", "/api/sync/select", bytes.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, post(SelectRequest{Inodes: []uint64{10}, Filter: &SelectFilter{Types: []string{"scan"}}}))
+	assert.Equal(t, http.StatusBadRequest, post(SelectRequest{Inodes: []uint64{10}, Filter: &SelectFilter{MaxSize: -1}}))
+	require.Equal(t, http.StatusOK, post(SelectRequest{Inodes: []uint64{10}, Filter: &SelectFilter{Extensions: []string{"pdf"}}}))
+
+	w := httptest.NewRecorder()
+	h.HandleListEntries(w, httptest.NewRequest("GET", "/api/sync/entries", nil))
+	var resp map[string][]SyncEntryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp["items"], 1)
+	require.NotNil(t, resp["items"][0].Filter)
+	assert.Equal(t, []string{"pdf"}, resp["items"][0].Filter.Extensions)
+}
@@ -0,0 +1,267 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	gosync "sync"
+)
+
+// A select or deselect flips the selection flags at once and queues every
+// path under the given entries; the pipeline then copies or removes them
+// in the background. The paths of one request are tracked as a selection
+// job, whose ID the request returns: its progress is read with
+// GET /api/sync/jobs/{id} and published as job events when it starts,
+// completes or is cancelled; see jobcancel.go for cancelling.
+
+// selectionJobsKept is how many finished selection jobs are kept for
+// their status to be read.
+const selectionJobsKept = 50
+
+// Selection job states.
+const (
+	JobRunning   = "running"
+	JobComplete  = "complete"
+	JobCancelled = "cancelled"
+)
+
+// SelectionJob is the progress of a select or deselect request.
+type SelectionJob struct {
+	ID         string   `json:"id"`
+	Action     string   `json:"action"` // select or deselect
+	Paths      []string `json:"paths"`  // the selected or deselected entries
+	State      string   `json:"state"`
+	Total      int      `json:"total"`   // paths queued
+	Pending    int      `json:"pending"` // paths yet to run
+	Done       int      `json:"done"`
+	Failed     int      `json:"failed"`
+	Skipped    int      `json:"skipped"`              // paths cancelled before they ran
+	Bytes      int64    `json:"bytes"`                // size of the files queued
+	BytesDone  int64    `json:"bytesDone"`            // size of the files done
+	StartedAt  int64    `json:"startedAt"`            // nanoseconds
+	FinishedAt int64    `json:"finishedAt,omitempty"` // nanoseconds
+}
+
+type selectionJob struct {
+	SelectionJob
+	opID    int64                 // the undo log operation, 0 if nothing changed
+	pending map[string]queuedPath // by path
+}
+
+// selectionJobs tracks selection jobs until their last path has been
+// through the pipeline.
+type selectionJobs struct {
+	mu    gosync.Mutex
+	jobs  map[string]*selectionJob
+	order []string // IDs, oldest first
+}
+
+func newSelectionJobs() *selectionJobs {
+	return &selectionJobs{jobs: make(map[string]*selectionJob)}
+}
+
+// start tracks a job of action on the entries at roots, queuing paths,
+// and returns its ID. opID is the undo log operation that flipped their
+// flags.
+func (t *selectionJobs) start(action string, roots []string, paths []queuedPath, opID int64) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	job := &selectionJob{
+		SelectionJob: SelectionJob{ID: hex.EncodeToString(b), Action: action, Paths: roots, State: JobRunning, StartedAt: nowNano()},
+		opID:         opID,
+		pending:      make(map[string]queuedPath, len(paths)),
+	}
+	for _, p := range paths {
+		if _, dup := job.pending[p.path]; dup {
+			continue
+		}
+		job.pending[p.path] = p
+		if !p.isDir {
+			job.Bytes += p.size
+		}
+	}
+	job.Total = len(job.pending)
+	job.Pending = job.Total
+	if job.Total == 0 {
+		job.State, job.FinishedAt = JobComplete, job.StartedAt
+	}
+	t.mu.Lock()
+	t.jobs[job.ID] = job
+	t.order = append(t.order, job.ID)
+	t.trimLocked()
+	snap := job.SelectionJob
+	t.mu.Unlock()
+	publishJob(snap)
+	return job.ID, nil
+}
+
+// finish records that the pipeline ran on relPath, failed or not.
+func (t *selectionJobs) finish(relPath string, failed bool) {
+	var completed []SelectionJob
+	t.mu.Lock()
+	for _, job := range t.jobs {
+		p, ok := job.pending[relPath]
+		if !ok {
+			continue
+		}
+		delete(job.pending, relPath)
+		job.Pending--
+		job.Done++
+		if failed {
+			job.Failed++
+		}
+		if !p.isDir {
+			job.BytesDone += p.size
+		}
+		if job.Pending == 0 {
+			job.State, job.FinishedAt = JobComplete, nowNano()
+			completed = append(completed, job.SelectionJob)
+		}
+	}
+	t.mu.Unlock()
+	for _, job := range completed {
+		publishJob(job)
+	}
+}
+
+// jobCancel is what cancelling took out of a job.
+type jobCancel struct {
+	job     SelectionJob
+	opID    int64
+	skipped []queuedPath // dropped from the queue
+	running []queuedPath // being run, to abort
+}
+
+// cancel stops the running job with id, or with id empty every running
+// job, as far as their pending paths are at or under prefix (all for an
+// empty prefix). A path drop takes out of the queue is skipped; one it
+// doesn't is being run. A job left with no pending paths is cancelled.
+// ok is false if no job has id.
+func (t *selectionJobs) cancel(id, prefix string, drop func(relPath string) bool) (cancels []jobCancel, ok bool) {
+	t.mu.Lock()
+	jobs := t.jobs
+	if id != "" {
+		job, found := t.jobs[id]
+		if !found {
+			t.mu.Unlock()
+			return nil, false
+		}
+		jobs = map[string]*selectionJob{id: job}
+	}
+	var snaps []SelectionJob
+	for _, job := range jobs {
+		if job.State != JobRunning {
+			continue
+		}
+		c := jobCancel{opID: job.opID}
+		for p, qp := range job.pending {
+			if prefix != "" && p != prefix && !strings.HasPrefix(p, prefix+"/") {
+				continue
+			}
+			if drop(p) {
+				c.skipped = append(c.skipped, qp)
+			} else {
+				c.running = append(c.running, qp)
+			}
+			delete(job.pending, p)
+		}
+		if len(c.skipped)+len(c.running) == 0 {
+			continue
+		}
+		job.Pending = len(job.pending)
+		job.Skipped += len(c.skipped) + len(c.running)
+		if job.Pending == 0 {
+			job.State, job.FinishedAt = JobCancelled, nowNano()
+			snaps = append(snaps, job.SelectionJob)
+		}
+		c.job = job.SelectionJob
+		cancels = append(cancels, c)
+	}
+	t.mu.Unlock()
+	for _, snap := range snaps {
+		publishJob(snap)
+	}
+	return cancels, true
+}
+
+// get returns the job with id, or false.
+func (t *selectionJobs) get(id string) (SelectionJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[id]
+	if !ok {
+		return SelectionJob{}, false
+	}
+	return job.SelectionJob, true
+}
+
+// trimLocked forgets the oldest finished jobs beyond selectionJobsKept.
+// Caller must hold t.mu.
+func (t *selectionJobs) trimLocked() {
+	excess := len(t.order) - selectionJobsKept
+	kept := t.order[:0]
+	for _, id := range t.order {
+		if excess > 0 && t.jobs[id].State != JobRunning {
+			delete(t.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+}
+
+// publishJob tells clients job started, completed or was cancelled.
+func publishJob(job SelectionJob) {
+	events.Publish(Event{Type: EventJob, Data: map[string]any{
+		"id": job.ID, "action": job.Action, "state": job.State,
+		"total": job.Total, "done": job.Done, "failed": job.Failed, "skipped": job.Skipped,
+	}})
+}
+
+// RevertSelection gives the entries among inodes that the undo log
+// operation opID flipped to selected their previous flag back, and drops
+// them from the operation so undoing it leaves them be. It returns how
+// many entries changed.
+func (s *Store) RevertSelection(opID int64, selected bool, inodes []uint64) (int64, error) {
+	b, _ := json.Marshal(inodes) // a []uint64 always encodes
+	var n int64
+	err := s.WithTx(func(t *TxStore) error {
+		res, err := t.tx.Exec(`
+			UPDATE entries SET selected = ?, updated_at = ?
+			WHERE inode IN (SELECT value FROM json_each(?))
+			  AND inode IN (SELECT entry_ino FROM selection_op_entries WHERE op_id = ?)
+			  AND selected = ?
+		`, !selected, nowNano(), string(b), opID, selected)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = t.tx.Exec("DELETE FROM selection_op_entries WHERE op_id = ? AND entry_ino IN (SELECT value FROM json_each(?))", opID, string(b))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("revert selection: %w", err)
+	}
+	return n, nil
+}
+
+// HandleSelectionJob handles GET /api/sync/jobs/{id}
+// Returns the progress of a select or deselect.
+func (h *Handlers) HandleSelectionJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.daemon.jobs.get(path.Base(r.URL.Path))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job) //nolint:errcheck
+}
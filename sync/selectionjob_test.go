@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selectJob selects inodes through the handler and returns the job ID.
+func selectJob(t *testing.T, h *Handlers, inodes ...uint64) string {
+	t.Helper()
+	body, _ := json.Marshal(SelectRequest{Inodes: inodes})
+	w := postJSON(h.HandleSelect, "/api/sync/select", string(body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotEmpty(t, resp["job"])
+	return resp["job"]
+}
+
+func selectionJobStatus(t *testing.T, h *Handlers, id string) SelectionJob {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleSelectionJob(w, httptest.NewRequest("GET", "/api/sync/jobs/"+id, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var job SelectionJob
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	return job
+}
+
+// runQueued runs the pipeline on n queued paths as the worker would.
+func runQueued(t *testing.T, h *Handlers, n int) {
+	t.Helper()
+	trash := filepath.Join(filepath.Dir(h.spacesRoot), ".trash")
+	for range n {
+		job, ok := h.daemon.queue.PopJob(nil)
+		require.True(t, ok)
+		err := RunPipeline(context.Background(), job.Path, h.store, h.archivesRoot, h.spacesRoot, trash, nil)
+		h.daemon.jobs.finish(job.Path, err != nil)
+	}
+}
+
+func TestSelectionJob_TracksProgress(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Projects/", "Projects/a.txt", "Projects/sub/", "Projects/sub/bb.txt"}, nil)
+	ino, err := h.resolvePathToIno("Projects")
+	require.NoError(t, err)
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	id := selectJob(t, h, ino)
+	job := selectionJobStatus(t, h, id)
+	assert.Equal(t, "select", job.Action)
+	assert.Equal(t, []string{"Projects"}, job.Paths)
+	assert.Equal(t, JobRunning, job.State)
+	assert.Equal(t, 4, job.Total)
+	assert.Equal(t, 4, job.Pending)
+	assert.Equal(t, int64(len("Projects/a.txt")+len("Projects/sub/bb.txt")), job.Bytes)
+
+	runQueued(t, h, 4)
+	job = selectionJobStatus(t, h, id)
+	assert.Equal(t, JobComplete, job.State)
+	assert.Zero(t, job.Pending)
+	assert.Equal(t, 4, job.Done)
+	assert.Equal(t, job.Bytes, job.BytesDone)
+	assert.NotZero(t, job.FinishedAt)
+	assert.FileExists(t, filepath.Join(spacesRoot, "Projects", "sub", "bb.txt"))
+
+	var states []any
+	for _, ev := range drain(ch) {
+		if ev.Type == EventJob && ev.Data["id"] == id {
+			states = append(states, ev.Data["state"])
+		}
+	}
+	assert.Equal(t, []any{JobRunning, JobComplete}, states)
+}
+
+func TestSelectionJob_CancelRestoresSkippedEntries(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	paths := []string{"Projects/"}
+	for i := range 3 {
+		paths = append(paths, fmt.Sprintf("Projects/f%d.txt", i))
+	}
+	registerPaths(t, store, archivesRoot, spacesRoot, append(paths, "Projects/kept.txt"), nil)
+	kept, err := h.resolvePathToIno("Projects/kept.txt")
+	require.NoError(t, err)
+	require.NoError(t, store.SetSelected([]uint64{kept}, true))
+	ino, err := h.resolvePathToIno("Projects")
+	require.NoError(t, err)
+
+	id := selectJob(t, h, ino)
+	runQueued(t, h, 2) // the directory goes first, then one of its files
+	w := httptest.NewRecorder()
+	h.HandleCancelSelectionJob(w, httptest.NewRequest("DELETE", "/api/sync/jobs/"+id, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	job := selectionJobStatus(t, h, id)
+	assert.Equal(t, JobCancelled, job.State)
+	assert.Equal(t, 2, job.Done)
+	assert.Equal(t, 3, job.Skipped)
+	assert.Zero(t, h.daemon.queue.Len())
+
+	// The skipped files are deselected again, but the one selected before.
+	synced := 0
+	for _, p := range paths[1:] {
+		fileIno, err := h.resolvePathToIno(p)
+		require.NoError(t, err)
+		entry, err := store.GetEntry(fileIno)
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(spacesRoot, p))
+		assert.Equal(t, err == nil, entry.Selected, p)
+		if entry.Selected {
+			synced++
+		}
+	}
+	assert.Equal(t, 1, synced)
+	entry, err := store.GetEntry(kept)
+	require.NoError(t, err)
+	assert.True(t, entry.Selected)
+
+	w = httptest.NewRecorder()
+	h.HandleCancelSelectionJob(w, httptest.NewRequest("DELETE", "/api/sync/jobs/0123abcd", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSelectionJobs_TrimsFinished(t *testing.T) {
+	jobs := newSelectionJobs()
+	first, err := jobs.start("select", []string{"a"}, []queuedPath{{path: "a"}}, 0)
+	require.NoError(t, err)
+	running, err := jobs.start("select", []string{"b"}, []queuedPath{{path: "b"}}, 0)
+	require.NoError(t, err)
+	jobs.finish("a", false)
+	for range selectionJobsKept {
+		_, err := jobs.start("select", nil, nil, 0)
+		require.NoError(t, err)
+	}
+	_, ok := jobs.get(first)
+	assert.False(t, ok)
+	_, ok = jobs.get(running)
+	assert.True(t, ok, "running jobs are kept")
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"path"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// selectionSnapshotTTL bounds how long a snapshot waits for its
+// directory to be evaluated; the queue normally gets there much sooner.
+const selectionSnapshotTTL = time.Hour
+
+// dirSnapshot is the set of children a directory had when a select
+// covered it.
+type dirSnapshot struct {
+	children map[uint64]bool
+	at       time.Time
+}
+
+// selectionSnapshots remembers, per directory a select just covered, the
+// children registered at that moment. The post-P3 verification uses it to
+// tell children that appeared during the operation from ones left
+// unselected on purpose (the select's exclude list, or a deselect since).
+type selectionSnapshots struct {
+	mu   gosync.Mutex
+	dirs map[string]dirSnapshot // relPath → snapshot
+}
+
+func (s *selectionSnapshots) put(relPath string, children map[uint64]bool) {
+	now := nowFunc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirs == nil {
+		s.dirs = make(map[string]dirSnapshot)
+	}
+	for p, snap := range s.dirs {
+		if now.Sub(snap.at) >= selectionSnapshotTTL {
+			delete(s.dirs, p) // never evaluated, e.g. moved away meanwhile
+		}
+	}
+	s.dirs[relPath] = dirSnapshot{children: children, at: now}
+}
+
+// take removes and returns the snapshot for relPath.
+func (s *selectionSnapshots) take(relPath string) (map[uint64]bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.dirs[relPath]
+	if ok {
+		delete(s.dirs, relPath)
+	}
+	return snap.children, ok
+}
+
+// snapshotSelection records the children of every selected directory in
+// the subtrees of inodes, right after they were selected.
+func (d *Daemon) snapshotSelection(inodes []uint64) {
+	for _, ino := range inodes {
+		entry, err := d.store.GetEntry(ino)
+		if err != nil || entry == nil || entry.Type != "dir" || !entry.Selected {
+			continue
+		}
+		d.snapshotDir(entry.Inode, d.store.RelPath(entry))
+	}
+}
+
+func (d *Daemon) snapshotDir(dirIno uint64, relPath string) {
+	children, err := d.store.ListChildren(dirIno)
+	if err != nil {
+		sub("daemon").Warn("selection snapshot: list failed", "path", relPath, "err", err)
+		return
+	}
+	known := make(map[uint64]bool, len(children))
+	for _, child := range children {
+		known[child.Inode] = true
+		if child.Type == "dir" && child.Selected {
+			d.snapshotDir(child.Inode, path.Join(relPath, child.Name))
+		}
+	}
+	d.selSnaps.put(relPath, known)
+}
+
+// verifySelection is the post-P3 pass for a directory a select covered:
+// it re-lists the directory's children on disk and in the DB and selects
+// and queues every child that wasn't there when the select ran, so files
+// created while the selection was being copied are synced too. A no-op
+// for paths without a pending snapshot or directories deselected since.
+func (d *Daemon) verifySelection(relPath string) {
+	l := sub("daemon")
+	known, ok := d.selSnaps.take(relPath)
+	if !ok {
+		return
+	}
+	entry, _, err := lookupDB(d.store, d.archivesRoot, relPath)
+	if err != nil || entry == nil || entry.Type != "dir" || !entry.Selected {
+		return
+	}
+	disk, err := listDir(d.archivesRoot, relPath)
+	if err != nil {
+		return // directory vanished; the pipeline cleans up
+	}
+	children, err := d.store.ListChildren(entry.Inode)
+	if err != nil {
+		l.Warn("selection verify: list failed", "path", relPath, "err", err)
+		return
+	}
+
+	// Register what is on disk but not in the DB yet, subtrees included.
+	registered := make(map[string]bool, len(children))
+	for _, child := range children {
+		registered[child.Name] = true
+	}
+	unregistered := make(map[string]FileStat)
+	for p, stat := range disk {
+		if registered[stat.Name] {
+			continue
+		}
+		unregistered[p] = stat
+		if !stat.IsDir {
+			continue
+		}
+		scanned, err := ScanDir(filepath.Join(d.archivesRoot, p))
+		if err != nil {
+			continue
+		}
+		for sp, sstat := range scanned {
+			unregistered[filepath.Join(p, sp)] = sstat
+		}
+	}
+	if len(unregistered) > 0 {
+		if err := d.registerScanned(relPath, entry.Inode, unregistered); err != nil {
+			l.Warn("selection verify: register failed", "path", relPath, "err", err)
+			return
+		}
+		if children, err = d.store.ListChildren(entry.Inode); err != nil {
+			l.Warn("selection verify: list failed", "path", relPath, "err", err)
+			return
+		}
+	}
+
+	var late []Entry
+	for _, child := range children {
+		if !known[child.Inode] && !child.Selected && !child.Excluded {
+			late = append(late, child)
+		}
+	}
+	if len(late) == 0 {
+		return
+	}
+
+	inodes := make([]uint64, len(late))
+	for i := range late {
+		inodes[i] = late[i].Inode
+	}
+	if err := d.store.SetSelected(inodes, true); err != nil {
+		l.Warn("selection verify: select failed", "path", relPath, "err", err)
+		return
+	}
+	for _, child := range late {
+		childPath := path.Join(relPath, child.Name)
+		d.queue.PushSized(childPath, sizeOrZero(child.Size), child.Type == "dir")
+		if child.Type == "dir" {
+			d.reconcileChildren(child.Inode, childPath)
+		}
+	}
+	l.Info("selection verify: queued children that appeared during the select", "path", relPath, "count", len(late))
+}
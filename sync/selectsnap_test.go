@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySelection_PicksUpLateChildren(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/skip.txt"}, nil)
+	docs := registered(t, store, archivesRoot, "Docs")
+	skip := registered(t, store, archivesRoot, "Docs/skip.txt")
+
+	w := postJSON(h.HandleSelect, "/api/sync/select", fmt.Sprintf(`{"inodes":[%d],"exclude":[%d]}`, docs.Inode, skip.Inode))
+	require.Equal(t, 200, w.Code)
+
+	// Created while the selection is being copied: one file registered by
+	// P1 (unselected, as it isn't in Spaces), one file and a directory the
+	// DB hasn't seen at all.
+	d := h.daemon
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/p1.txt"), []byte("p1"), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "Docs/p1.txt", store, archivesRoot, spacesRoot, d.trashRoot, nil))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/new.txt"), []byte("new"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "Docs/sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/sub/deep.txt"), []byte("deep"), 0644))
+	require.False(t, registered(t, store, archivesRoot, "Docs/p1.txt").Selected)
+
+	d.queue.Drain()
+	require.NoError(t, RunPipeline(context.Background(), "Docs", store, archivesRoot, spacesRoot, d.trashRoot, nil))
+	d.verifySelection("Docs")
+
+	for _, p := range []string{"Docs/p1.txt", "Docs/new.txt", "Docs/sub", "Docs/sub/deep.txt"} {
+		e := registered(t, store, archivesRoot, p)
+		require.NotNil(t, e, p)
+		assert.True(t, e.Selected, p)
+		assert.True(t, d.queue.Has(p), p)
+	}
+	assert.False(t, registered(t, store, archivesRoot, "Docs/skip.txt").Selected, "excluded at select time")
+	assert.False(t, d.queue.Has("Docs/a.txt"))
+
+	// The snapshot is consumed: nothing happens on the next evaluation.
+	d.queue.Drain()
+	d.verifySelection("Docs")
+	assert.Equal(t, 0, d.queue.Len())
+}
+
+func TestVerifySelection_SkipsDeselected(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, nil)
+	docs := registered(t, store, archivesRoot, "Docs")
+
+	require.Equal(t, 200, postJSON(h.HandleSelect, "/api/sync/select", fmt.Sprintf(`{"inodes":[%d]}`, docs.Inode)).Code)
+	require.Equal(t, 200, postJSON(h.HandleDeselect, "/api/sync/deselect", fmt.Sprintf(`{"inodes":[%d]}`, docs.Inode)).Code)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Docs/new.txt"), []byte("new"), 0644))
+
+	h.daemon.queue.Drain()
+	h.daemon.verifySelection("Docs")
+	assert.Nil(t, registered(t, store, archivesRoot, "Docs/new.txt"))
+	assert.Equal(t, 0, h.daemon.queue.Len())
+}
@@ -0,0 +1,229 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+)
+
+// PipelineState is a State as the simulate endpoint reports it.
+type PipelineState struct {
+	ADisk    bool   `json:"aDisk"`
+	ADb      bool   `json:"aDb"`
+	SDisk    bool   `json:"sDisk"`
+	SDb      bool   `json:"sDb"`
+	Selected bool   `json:"selected"`
+	ADirty   bool   `json:"aDirty"`
+	SDirty   bool   `json:"sDirty"`
+	Scenario int    `json:"scenario"`
+	Status   string `json:"status"`
+}
+
+func pipelineState(s State) PipelineState {
+	return PipelineState{
+		ADisk: s.ADisk, ADb: s.ADb, SDisk: s.SDisk, SDb: s.SDb,
+		Selected: s.Selected, ADirty: s.ADirty, SDirty: s.SDirty,
+		Scenario: s.Scenario(), Status: s.UIStatus(),
+	}
+}
+
+// PlannedStep is one action RunPipeline would take.
+type PlannedStep struct {
+	Phase   string `json:"phase"` // P0–P4, or "hold" and "syncthing"
+	Action  string `json:"action"`
+	Blocked string `json:"blocked,omitempty"` // why it would wait and be requeued instead
+}
+
+// Simulation is what RunPipeline would do with a path right now.
+type Simulation struct {
+	Path       string        `json:"path"`
+	Entry      *Entry        `json:"entry,omitempty"`
+	SpacesView *SpacesView   `json:"spacesView,omitempty"`
+	Held       bool          `json:"held"`
+	Queued     bool          `json:"queued"`
+	State      PipelineState `json:"state"`
+	Plan       []PlannedStep `json:"plan"`
+	Final      PipelineState `json:"final"` // predicted state once the plan ran
+}
+
+// simulatePipeline gathers the state of relPath as RunPipeline does and
+// walks P0→P4 on it without touching the disks or the DB. Each phase's
+// effect on the state is predicted rather than re-gathered, so the plan
+// is what the pipeline intends, assuming its copies succeed.
+func simulatePipeline(store *Store, relPath, archivesRoot, spacesRoot string) (*Simulation, error) {
+	archivePath := filepath.Join(archivesRoot, relPath)
+	spacesPath := filepath.Join(spacesRoot, relPath)
+	archiveMtime, archiveIsDir, archiveInode, archiveSize := statFile(archivePath)
+	spacesMtime, _, _, _ := statFile(spacesPath)
+	entry, sv, err := lookupPipeline(store, archivesRoot, relPath)
+	if err != nil {
+		return nil, fmt.Errorf("db lookup: %w", err)
+	}
+	state := ComputeState(entry, sv, archiveMtime, spacesMtime)
+	isDir := archiveIsDir != nil && *archiveIsDir || entry != nil && entry.Type == "dir"
+	sim := &Simulation{Path: relPath, Entry: entry, SpacesView: sv, State: pipelineState(state), Plan: make([]PlannedStep, 0)}
+	plan := func(phase, action string, stablePath string) {
+		step := PlannedStep{Phase: phase, Action: action}
+		if stablePath != "" {
+			if err := checkStable(stablePath); err != nil {
+				step.Blocked = err.Error()
+			}
+		}
+		sim.Plan = append(sim.Plan, step)
+	}
+
+	if syncthingConflict(filepath.Base(relPath)) {
+		plan("syncthing", "ingest the Syncthing conflict copy into Archives as a conflict", spacesPath)
+		sim.Final = sim.State
+		return sim, nil
+	}
+
+	// P0: Archives disk recovery
+	recovered := false
+	if !state.ADisk {
+		switch {
+		case state.SDisk:
+			plan("P0", "recover Archives by copying S→A", spacesPath)
+			state.ADisk, state.ADirty = true, false
+			recovered = true
+		case entry != nil:
+			plan("P0", "tombstone the lost entry and delete its spaces_view", "")
+			state = State{}
+		default:
+			plan("P0", "nothing to do: no file and no DB records", "")
+		}
+	}
+
+	// P1: DB registration
+	if !state.ADb && state.ADisk {
+		switch {
+		case recovered:
+			plan("P1", "register the recovered file, selected as it is in Spaces", "")
+			state.ADb, state.Selected = true, true
+		case archiveInode == nil || archiveMtime == nil:
+			plan("P1", "skip registration: no inode or mtime", "")
+		default:
+			sel := state.SDisk
+			reason := ""
+			if state.SDisk {
+				reason = ", selected as it is in Spaces"
+			} else if !isDir && evalRules(activeConfig.Load().Rules, relPath, sizeOrZero(archiveSize), *archiveMtime) == RuleSelect {
+				sel = true
+				reason = ", selected by an auto-select rule"
+			}
+			existing, err := store.GetEntry(*archiveInode)
+			if err != nil {
+				return nil, fmt.Errorf("lookup inode: %w", err)
+			}
+			if existing != nil {
+				oldRel := store.RelPath(existing)
+				if _, _, oldIno, _ := statFile(filepath.Join(archivesRoot, oldRel)); oldIno != nil && *oldIno == *archiveInode {
+					plan("P1", fmt.Sprintf("skip registration: inode %d is registered at %s", *archiveInode, oldRel), "")
+					break
+				}
+				plan("P1", fmt.Sprintf("move the entry of inode %d here from %s", *archiveInode, oldRel), "")
+				sel = existing.Selected
+			} else {
+				plan("P1", fmt.Sprintf("register inode %d%s", *archiveInode, reason), "")
+			}
+			state.ADb, state.Selected, state.ADirty = true, sel, false
+		}
+	}
+
+	// A held entry keeps its changes on both sides until the hold ends.
+	sim.Held = entry != nil && holds.held(entry.Inode)
+	if sim.Held && (state.ADirty || state.SDirty || state.Selected != state.SDisk) {
+		plan("hold", "entry held, skipping P2 and P3", "")
+	}
+
+	// P2: Change sync
+	if !sim.Held && (state.ADirty || state.SDirty) {
+		var stable string
+		if state.SDirty {
+			stable = spacesPath
+		}
+		if state.ADirty {
+			stable = archivePath
+		}
+		switch {
+		case state.ADirty && state.SDirty:
+			plan("P2", fmt.Sprintf("conflict: rename the Archives file to %s and copy S→A", ConflictName(archivePath)), stable)
+		case state.ADirty && state.Selected && state.SDisk:
+			plan("P2", "update the entry from Archives and copy A→S", stable)
+		case state.ADirty:
+			plan("P2", "update the entry from Archives", stable)
+		default:
+			plan("P2", "copy S→A", stable)
+		}
+		state.ADirty, state.SDirty = false, false
+	}
+
+	// P3: Goal realization
+	stub := false
+	if entry != nil && !state.Selected && state.SDisk {
+		if stub, err = store.IsPlaceholder(entry.Inode); err != nil {
+			return nil, err
+		}
+	}
+	if !sim.Held && state.ADb && state.Selected != state.SDisk {
+		switch {
+		case state.Selected && entry != nil && entry.Excluded:
+			plan("P3", "skip the copy: excluded", "")
+		case state.Selected && isDir:
+			plan("P3", "create the directory in Spaces", "")
+			state.SDisk, state.SDb = true, true
+		case state.Selected && currentHydrator() != nil && sizeOrZero(archiveSize) > 0:
+			plan("P3", "create a placeholder in Spaces", archivePath)
+			state.SDisk, state.SDb = true, true
+		case state.Selected:
+			plan("P3", "copy A→S", archivePath)
+			state.SDisk, state.SDb = true, true
+		case stub && isStub(spacesPath):
+			plan("P3", "remove the placeholder from Spaces", "")
+			state.SDisk, state.SDb = false, false
+		default:
+			plan("P3", "move the Spaces copy to the trash", "")
+			state.SDisk, state.SDb = false, false
+		}
+	}
+
+	// P4: DB consistency
+	if state.SDb != state.SDisk {
+		switch {
+		case state.SDisk && !state.ADb:
+			plan("P4", "skip: no entry to record the Spaces copy for", "")
+		case state.SDisk:
+			plan("P4", "create the missing spaces_view", "")
+			state.SDb = true
+		default:
+			plan("P4", "remove the stale spaces_view", "")
+			state.SDb = false
+		}
+	}
+
+	sim.Final = pipelineState(state)
+	return sim, nil
+}
+
+// HandleSimulate handles GET /api/sync/simulate?path=<relPath>
+// Returns the state RunPipeline would gather for the path, the scenario
+// and the P0–P4 actions it would take, without running any of them.
+func (h *Handlers) HandleSimulate(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	relPath, err := h.checkPath(r.URL.Query().Get("path"))
+	if err != nil || relPath == "" {
+		http.Error(w, "path required", http.StatusBadRequest)
+		return
+	}
+	sim, err := simulatePipeline(h.store, relPath, h.archivesRoot, h.spacesRoot)
+	if err != nil {
+		l.Error("simulate failed", "path", relPath, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sim.Queued = h.daemon.Queue().Has(relPath)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sim) //nolint:errcheck
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func simulate(t *testing.T, h *Handlers, relPath string) Simulation {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleSimulate(w, httptest.NewRequest("GET", "/api/sync/simulate?path="+relPath, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var sim Simulation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sim))
+	return sim
+}
+
+func planActions(sim Simulation) []string {
+	actions := make([]string, len(sim.Plan))
+	for i, step := range sim.Plan {
+		actions[i] = step.Phase + ": " + step.Action
+	}
+	return actions
+}
+
+func TestSimulate_PlansWithoutActing(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "a.txt").Inode}, true))
+
+	sim := simulate(t, h, "a.txt")
+	assert.Equal(t, 17, sim.State.Scenario)
+	assert.Equal(t, "syncing", sim.State.Status)
+	require.NotNil(t, sim.Entry)
+	assert.True(t, sim.Entry.Selected)
+	assert.Equal(t, []string{"P3: copy A→S"}, planActions(sim))
+	assert.Equal(t, 31, sim.Final.Scenario)
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "a.txt"))
+}
+
+func TestSimulate_UnregisteredFile(t *testing.T) {
+	h, _, archivesRoot, _ := setupHandlersEnv(t)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "new.txt"), []byte("x"), 0644))
+
+	sim := simulate(t, h, "new.txt")
+	assert.Equal(t, 2, sim.State.Scenario)
+	assert.Nil(t, sim.Entry)
+	require.Len(t, sim.Plan, 1)
+	assert.Equal(t, "P1", sim.Plan[0].Phase)
+	assert.Contains(t, sim.Plan[0].Action, "register inode")
+	assert.Equal(t, 15, sim.Final.Scenario)
+}
+
+func TestSimulate_ConflictAndUnstable(t *testing.T) {
+	restoreConfig(t)
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, map[string]bool{"a.txt": true})
+	earlier := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(archivesRoot, "a.txt"), earlier, earlier))
+	require.NoError(t, os.Chtimes(filepath.Join(spacesRoot, "a.txt"), earlier, earlier.Add(time.Second)))
+
+	sim := simulate(t, h, "a.txt")
+	assert.Equal(t, 34, sim.State.Scenario)
+	require.Len(t, sim.Plan, 1)
+	assert.Contains(t, sim.Plan[0].Action, "conflict: rename the Archives file to a_conflict-1.txt")
+	assert.Empty(t, sim.Plan[0].Blocked)
+
+	cfg := currentConfig()
+	cfg.StableMs = 600_000
+	require.NoError(t, setConfig(cfg))
+	now := time.Now()
+	require.NoError(t, os.Chtimes(filepath.Join(archivesRoot, "a.txt"), now, now))
+	assert.Contains(t, simulate(t, h, "a.txt").Plan[0].Blocked, "still being written")
+}
+
+// The predicted final state is the one RunPipeline reaches.
+func TestSimulate_PredictsPipeline(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/sel.txt", "Docs/desel.txt", "Docs/lost.txt", "Docs/edit.txt"},
+		map[string]bool{"Docs/desel.txt": true, "Docs/edit.txt": true})
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "Docs/sel.txt").Inode}, true))
+	require.NoError(t, store.SetSelected([]uint64{registered(t, store, archivesRoot, "Docs/desel.txt").Inode}, false))
+	require.NoError(t, os.Remove(filepath.Join(archivesRoot, "Docs", "lost.txt")))
+	earlier := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(spacesRoot, "Docs", "edit.txt"), earlier, earlier))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "Docs", "spaces-only.txt"), []byte("s"), 0644))
+
+	for _, p := range []string{"Docs/sel.txt", "Docs/desel.txt", "Docs/lost.txt", "Docs/edit.txt", "Docs/spaces-only.txt"} {
+		sim := simulate(t, h, p)
+		require.NotEmpty(t, sim.Plan, p)
+		require.NoError(t, RunPipeline(context.Background(), p, store, archivesRoot, spacesRoot, trash, nil))
+		after := simulate(t, h, p)
+		assert.Equal(t, sim.Final.Scenario, after.State.Scenario, "%s: planned %v", p, planActions(sim))
+	}
+}
+
+func TestSimulate_RequiresPath(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	w := httptest.NewRecorder()
+	h.HandleSimulate(w, httptest.NewRequest("GET", "/api/sync/simulate", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
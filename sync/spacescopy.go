@@ -0,0 +1,184 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A Spaces copy may be encoded on its way from Archives: compressed when
+// its compress rule says so, then encrypted when a Spaces key is set.
+// Each encoding starts with its own marker, so a copy is decoded from its
+// content alone and files put into Spaces by hand pass through as is.
+
+// spacesEncoder returns what copying the Archives file relPath to Spaces
+// wraps its content in, or nil for a plain copy.
+func spacesEncoder(relPath, archivePath string) (func(io.Reader) io.Reader, error) {
+	key, err := spacesKey()
+	if err != nil {
+		return nil, err
+	}
+	compress := false
+	if rules := activeConfig.Load().Rules; anyCompressRule(rules) {
+		if info, err := os.Stat(archivePath); err == nil {
+			compress = compressRule(rules, relPath, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	if key == nil && !compress {
+		return nil, nil
+	}
+	return func(r io.Reader) io.Reader {
+		if compress {
+			r = newCompressReader(r)
+		}
+		if key != nil {
+			r = newEncryptReader(r, key)
+		}
+		return r
+	}, nil
+}
+
+// decodeSpaces wraps r, the content of a Spaces copy, to yield the file
+// it was made from. Failures surface on Read.
+func decodeSpaces(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(encryptHeaderLen); len(head) == encryptHeaderLen {
+		if _, _, ok := parseEncryptHeader(head); ok {
+			key, err := spacesKey()
+			if err == nil && key == nil {
+				err = fmt.Errorf("encrypted copy but no spacesEncryptionKey")
+			}
+			if err != nil {
+				return errReader{err}
+			}
+			br = bufio.NewReader(newDecryptReader(br, key))
+		}
+	}
+	if head, _ := br.Peek(len(compressMagic)); string(head) == compressMagic {
+		br.Discard(len(compressMagic)) //nolint:errcheck // just peeked
+		return newDecompressReader(br)
+	}
+	return br
+}
+
+// spacesEncoding reports how the Spaces file at path is encoded, for
+// spaces_view: the cipher and compression, "" when it is stored as is.
+// Without a Spaces key or compress rule, copies aren't inspected.
+func spacesEncoding(path string) (cipher, compression string) {
+	cfg := activeConfig.Load()
+	if cfg.SpacesEncryptionKey == "" && !anyCompressRule(cfg.Rules) {
+		return "", ""
+	}
+	f, err := fsFor(path).Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(encryptHeaderLen); len(head) == encryptHeaderLen {
+		if id, _, ok := parseEncryptHeader(head); ok {
+			cipher = cipherName(id)
+			key, err := spacesKey()
+			if err != nil || key == nil || key.id != id {
+				return cipher, "" // can't see inside
+			}
+			br = bufio.NewReader(newDecryptReader(br, key))
+		}
+	}
+	if head, _ := br.Peek(len(compressMagic)); string(head) == compressMagic {
+		compression = compressZstd
+	}
+	return cipher, compression
+}
+
+// copyToSpaces copies the Archives file relPath to Spaces, encoded as
+// spacesEncoder says, verifies it if sampled (see verify.go) and counts it
+// in ioStats. When a transcode rule matches the file, the copy is a
+// derivative made by its command, and is returned for the caller to
+// record; a plain copy returns nil.
+func copyToSpaces(ctx context.Context, relPath, archivePath, spacesPath string, hasQueued func() bool) (*Derivative, error) {
+	wrap, err := spacesEncoder(relPath, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	src := archivePath
+	var derived *Derivative
+	if rules := activeConfig.Load().Rules; anyTranscodeRule(rules) {
+		if info, err := os.Stat(archivePath); err == nil {
+			if tmpl := transcodeRule(rules, relPath, info.Size(), info.ModTime().UnixNano()); tmpl != "" {
+				dst, cleanup, err := transcode(ctx, tmpl, archivePath)
+				if err != nil {
+					return nil, err
+				}
+				defer cleanup()
+				src = dst
+				derived = &Derivative{Original: relPath, Transcode: tmpl, SourceMtime: info.ModTime().UnixNano(), CreatedAt: nowNano()}
+			}
+		}
+	}
+	var check func(string) error
+	var verified *bool
+	if derived == nil {
+		if info, err := os.Stat(archivePath); err == nil && verifySampled(info.Size()) {
+			check = verifyCheck(relPath, func() (io.ReadCloser, error) { return os.Open(archivePath) }, openSpaces,
+				func(ok bool) { verified = &ok })
+		}
+	}
+	err = safeCopyChecked(ctx, src, spacesPath, hasQueued, wrap, check)
+	if err == nil || verified != nil {
+		verifications.record(archivePath, IOToSpaces, verified)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordCopy(relPath, IOToSpaces, archivePath)
+	return derived, nil
+}
+
+// copyFromSpaces copies the Spaces file relPath to dst in Archives,
+// decoding it, verifies it if sampled and counts it in ioStats.
+func copyFromSpaces(ctx context.Context, relPath, spacesPath, dst string, hasQueued func() bool) error {
+	var check func(string) error
+	var verified *bool
+	if info, err := fsFor(spacesPath).Stat(spacesPath); err == nil && verifySampled(info.Size()) {
+		check = verifyCheck(relPath, func() (io.ReadCloser, error) { return openSpaces(spacesPath) },
+			func(tmpPath string) (io.ReadCloser, error) { return fsFor(tmpPath).Open(tmpPath) },
+			func(ok bool) { verified = &ok })
+	}
+	err := safeCopyChecked(ctx, spacesPath, dst, hasQueued, decodeSpaces, check)
+	if err == nil || verified != nil {
+		verifications.record(dst, IOToArchives, verified)
+	}
+	if err != nil {
+		return err
+	}
+	recordCopy(relPath, IOToArchives, dst)
+	return nil
+}
+
+// recordCopy counts a copy by the size of its Archives side, the file as
+// the user sees it whatever Spaces stores.
+func recordCopy(relPath string, dir IODirection, archivePath string) {
+	if info, err := fsFor(archivePath).Stat(archivePath); err == nil {
+		ioStats.record(relPath, dir, info.Size())
+	}
+}
+
+// openSpaces opens a Spaces file for reading the file it was made from.
+func openSpaces(spacesPath string) (io.ReadCloser, error) {
+	f, err := fsFor(spacesPath).Open(spacesPath)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{decodeSpaces(f), f}, nil
+}
+
+// errReader fails every Read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,269 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpokeClient is the spoke side of the hub/spoke protocol: it keeps root
+// on this machine matching the spoke's selection on the hub.
+type SpokeClient struct {
+	hub   string // the hub's sync API, e.g. https://hub:8080/api/sync
+	token string
+	root  string
+	http  *http.Client
+}
+
+// RegisterSpoke registers a new spoke called name with the hub. The
+// returned token authenticates every later request and is not shown
+// again.
+func RegisterSpoke(ctx context.Context, hub, name string) (*SpokeRegisterResponse, error) {
+	c := &SpokeClient{hub: strings.TrimSuffix(hub, "/"), http: http.DefaultClient}
+	var resp SpokeRegisterResponse
+	if err := c.do(ctx, "POST", "/spokes", SpokeRegisterRequest{Name: name}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// NewSpokeClient returns a client syncing root from the hub as the
+// spoke holding token.
+func NewSpokeClient(hub, token, root string) *SpokeClient {
+	return &SpokeClient{
+		hub:   strings.TrimSuffix(hub, "/"),
+		token: token,
+		root:  root,
+		http:  &http.Client{Timeout: 0}, // downloads can be large
+	}
+}
+
+// do sends a JSON request and decodes a JSON response into out, if set.
+func (c *SpokeClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.hub+path, body)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set(SpokeTokenHeader, c.token)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Entries lists the hub's entries under parentIno (0 = root).
+func (c *SpokeClient) Entries(ctx context.Context, parentIno uint64) ([]SpokeEntry, error) {
+	var resp struct {
+		Items []SpokeEntry `json:"items"`
+	}
+	err := c.do(ctx, "GET", "/spoke/entries?parent_ino="+strconv.FormatUint(parentIno, 10), nil, &resp)
+	return resp.Items, err
+}
+
+// Hydrate adds inodes, with their subtrees, to this spoke's selection.
+// Their files are copied by the next Sync.
+func (c *SpokeClient) Hydrate(ctx context.Context, inodes []uint64) error {
+	return c.do(ctx, "POST", "/spoke/hydrate", SelectRequest{Inodes: inodes}, nil)
+}
+
+// Release removes inodes from this spoke's selection. Their copies are
+// removed by the next Sync.
+func (c *SpokeClient) Release(ctx context.Context, inodes []uint64) error {
+	return c.do(ctx, "POST", "/spoke/release", SelectRequest{Inodes: inodes}, nil)
+}
+
+// Sync fetches the hub's plan for this spoke, downloads and removes
+// files to carry it out and reports the result. Files that fail are
+// retried by the next Sync; their errors are returned joined.
+func (c *SpokeClient) Sync(ctx context.Context) (fetched, removed int, err error) {
+	l := sub("spoke")
+	var plan SpokePlan
+	if err := c.do(ctx, "GET", "/spoke/plan", nil, &plan); err != nil {
+		return 0, 0, err
+	}
+
+	var report SpokeReport
+	var errs []error
+	for _, f := range plan.Fetch {
+		if ctx.Err() != nil {
+			break
+		}
+		mtime, err := c.fetch(ctx, f)
+		if err != nil {
+			l.Warn("spoke fetch failed", "path", f.Path, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
+			continue
+		}
+		report.Synced = append(report.Synced, SpokeFile{Inode: f.Inode, Path: f.Path, Mtime: mtime})
+	}
+	for _, f := range plan.Remove {
+		dst, err := c.localPath(f.Path)
+		if err == nil {
+			if err = os.Remove(dst); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
+			continue
+		}
+		report.Removed = append(report.Removed, f.Inode)
+	}
+
+	if len(report.Synced) > 0 || len(report.Removed) > 0 {
+		// Report even if ctx was cancelled so finished copies aren't redone
+		if err := c.do(context.WithoutCancel(ctx), "POST", "/spoke/report", report, nil); err != nil {
+			errs = append(errs, fmt.Errorf("report: %w", err))
+		}
+	}
+	l.Info("spoke sync complete", "fetched", len(report.Synced), "removed", len(report.Removed), "failed", len(errs))
+	return len(report.Synced), len(report.Removed), errors.Join(errs...)
+}
+
+// localPath maps a hub relative path below root, refusing paths that
+// would escape it.
+func (c *SpokeClient) localPath(relPath string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(relPath))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path %q", relPath)
+	}
+	return filepath.Join(c.root, clean), nil
+}
+
+// fetch downloads f into root through a temp file, continuing a partial
+// one left by an interrupted fetch of the same version. A partial file
+// carries the mtime of its version, so bytes of another one are never
+// continued from. Returns the mtime of the version downloaded.
+func (c *SpokeClient) fetch(ctx context.Context, f SpokeFile) (int64, error) {
+	dst, err := c.localPath(f.Path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	tmpPath := dst + ".sync-tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer tmp.Close()
+	offset, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	restart := func() error {
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		_, err := tmp.Seek(0, io.SeekStart)
+		return err
+	}
+	if offset > 0 {
+		if info, err := tmp.Stat(); err != nil || info.ModTime().UnixNano() != f.Mtime {
+			if err := restart(); err != nil {
+				return 0, err
+			}
+			offset = 0
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.hub+"/spoke/content/"+strconv.FormatUint(f.Inode, 10), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(SpokeTokenHeader, c.token)
+	if offset > 0 {
+		// Only continue if the hub still has the planned version
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", spokeETag(f.Mtime))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if err := restart(); err != nil {
+			return 0, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(tmpPath) //nolint:errcheck // longer than the file; start over next time
+		return 0, fmt.Errorf("partial download outgrew the file")
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("GET content: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	mtime, err := strconv.ParseInt(strings.Trim(resp.Header.Get("ETag"), `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad ETag %q", resp.Header.Get("ETag"))
+	}
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		// Keep the partial file to continue from, marked with its version
+		os.Chtimes(tmpPath, time.Now(), time.Unix(0, mtime)) //nolint:errcheck // unmarked, it is started over
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chtimes(tmpPath, time.Now(), time.Unix(0, mtime)); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return 0, err
+	}
+	return mtime, nil
+}
+
+// Run syncs every interval until ctx is cancelled.
+func (c *SpokeClient) Run(ctx context.Context, interval time.Duration) {
+	l := sub("spoke")
+	l.Info("spoke started", "hub", c.hub, "root", c.root)
+	for {
+		if _, _, err := c.Sync(ctx); err != nil && ctx.Err() == nil {
+			l.Warn("spoke sync failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(interval)):
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Spokes are other machines that keep their own selections from this
+// Archives hub. A spoke registers once and then authenticates with its
+// token in the SpokeTokenHeader. It pulls entry listings, asks for
+// hydration of the entries it selects, fetches the plan of files to copy
+// or drop, downloads content and reports back what it holds. The hub
+// keeps each spoke's selections and reported copies (spoke_views) apart
+// from its own Spaces.
+
+// SpokeTokenHeader carries a spoke's token on spoke requests.
+const SpokeTokenHeader = "X-Spoke-Token"
+
+// maxSpokeNameLen bounds the length of a spoke name in bytes.
+const maxSpokeNameLen = 64
+
+// SpokeRegisterRequest is the request body for spoke registration.
+type SpokeRegisterRequest struct {
+	Name string `json:"name"`
+}
+
+// SpokeRegisterResponse carries the new spoke and its token, which is
+// only ever returned here.
+type SpokeRegisterResponse struct {
+	Spoke
+	Token string `json:"token"`
+}
+
+// SpokeEntry is an entry as listed to a spoke, with the spoke's own
+// selection and synced copy.
+type SpokeEntry struct {
+	Inode       uint64 `json:"inode"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Size        *int64 `json:"size"`
+	Mtime       int64  `json:"mtime"`
+	Selected    bool   `json:"selected"`              // selected by this spoke
+	SyncedMtime int64  `json:"syncedMtime,omitempty"` // reported copy; 0 = none
+}
+
+// SpokeFile is a file in a spoke's plan.
+type SpokeFile struct {
+	Inode uint64 `json:"inode"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size,omitempty"`
+	Mtime int64  `json:"mtime,omitempty"`
+}
+
+// SpokePlan is what a spoke must do to match its selection: files to
+// fetch (missing or outdated) and copies to remove (no longer selected).
+type SpokePlan struct {
+	Fetch  []SpokeFile `json:"fetch"`
+	Remove []SpokeFile `json:"remove"`
+}
+
+// SpokeReport is the request body of a spoke's state report.
+type SpokeReport struct {
+	Synced  []SpokeFile `json:"synced"`  // copies held, with the mtime copied
+	Removed []uint64    `json:"removed"` // copies dropped
+}
+
+// SpokeStatus is a registered spoke with a summary of its state.
+type SpokeStatus struct {
+	Spoke
+	Selected int `json:"selected"` // entries selected directly
+	Synced   int `json:"synced"`   // copies reported
+}
+
+// newSpokeToken returns a random token and the hash stored for it.
+func newSpokeToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashSpokeToken(token), nil
+}
+
+func hashSpokeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleRegisterSpoke handles POST /api/sync/spokes
+func (h *Handlers) HandleRegisterSpoke(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	var req SpokeRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		l.Warn("register spoke: bad body", "err", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxSpokeNameLen {
+		http.Error(w, fmt.Sprintf("name must be 1-%d bytes", maxSpokeNameLen), http.StatusBadRequest)
+		return
+	}
+
+	token, hash, err := newSpokeToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := nowFunc().UnixNano()
+	sp := Spoke{ID: hex.EncodeToString(id), Name: req.Name, RegisteredAt: now, LastSeen: now}
+	if err := h.store.RegisterSpoke(sp, hash); err != nil {
+		l.Error("register spoke failed", "name", req.Name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Info("spoke registered", "id", sp.ID, "name", sp.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SpokeRegisterResponse{Spoke: sp, Token: token}) //nolint:errcheck
+}
+
+// HandleListSpokes handles GET /api/sync/spokes
+func (h *Handlers) HandleListSpokes(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	spokes, err := h.store.ListSpokes()
+	if err != nil {
+		l.Error("list spokes failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := make([]SpokeStatus, 0, len(spokes))
+	for _, sp := range spokes {
+		selected, err := h.store.ListSpokeSelections(sp.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views, err := h.store.ListSpokeViews(sp.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, SpokeStatus{Spoke: sp, Selected: len(selected), Synced: len(views)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": items}) //nolint:errcheck
+}
+
+// HandleDeleteSpoke handles DELETE /api/sync/spokes/<id>
+// The spoke's token stops working; its copies are left where they are.
+func (h *Handlers) HandleDeleteSpoke(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	ok, err := h.store.DeleteSpoke(id)
+	if err != nil {
+		l.Error("delete spoke failed", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	l.Info("spoke deleted", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// spokeFromRequest authenticates a spoke request by its token, writing
+// 401 if it doesn't name a registered spoke.
+func (h *Handlers) spokeFromRequest(w http.ResponseWriter, r *http.Request) (*Spoke, bool) {
+	token := r.Header.Get(SpokeTokenHeader)
+	if token == "" {
+		http.Error(w, "missing "+SpokeTokenHeader, http.StatusUnauthorized)
+		return nil, false
+	}
+	sp, err := h.store.SpokeByToken(hashSpokeToken(token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if sp == nil {
+		http.Error(w, "unknown spoke", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err := h.store.TouchSpoke(sp.ID, nowFunc().UnixNano()); err != nil {
+		sub("handlers").Warn("touch spoke failed", "id", sp.ID, "err", err)
+	}
+	return sp, true
+}
+
+// HandleSpokeEntries handles GET /api/sync/spoke/entries?parent_ino=<ino>
+func (h *Handlers) HandleSpokeEntries(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	sp, ok := h.spokeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var parentIno uint64
+	if pi := r.URL.Query().Get("parent_ino"); pi != "" {
+		var err error
+		if parentIno, err = strconv.ParseUint(pi, 10, 64); err != nil {
+			http.Error(w, "invalid parent_ino", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.daemon.ensureListed(parentIno); err != nil {
+		l.Warn("spoke entries: lazy registration failed", "parentIno", parentIno, "err", err)
+	}
+	children, err := h.store.ListChildren(parentIno)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	selected, err := h.spokeSelected(sp.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	views, err := h.store.ListSpokeViews(sp.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := make([]SpokeEntry, 0, len(children))
+	for _, c := range children {
+		items = append(items, SpokeEntry{
+			Inode:       c.Inode,
+			Name:        c.Name,
+			Type:        c.Type,
+			Size:        c.Size,
+			Mtime:       c.Mtime,
+			Selected:    selected[c.Inode],
+			SyncedMtime: views[c.Inode].SyncedMtime,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"items": items}) //nolint:errcheck
+}
+
+// spokeSelected returns the inodes a spoke selected directly.
+func (h *Handlers) spokeSelected(spokeID string) (map[uint64]bool, error) {
+	entries, err := h.store.ListSpokeSelections(spokeID)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[uint64]bool, len(entries))
+	for _, e := range entries {
+		selected[e.Inode] = true
+	}
+	return selected, nil
+}
+
+// HandleSpokeHydrate handles POST /api/sync/spoke/hydrate
+// Adds the inodes, with their subtrees, to the spoke's selection; the
+// next plan lists their files to fetch.
+func (h *Handlers) HandleSpokeHydrate(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSpokeSelect(w, r, true)
+}
+
+// HandleSpokeRelease handles POST /api/sync/spoke/release
+func (h *Handlers) HandleSpokeRelease(w http.ResponseWriter, r *http.Request) {
+	h = h.forRequest(r)
+	h.handleSpokeSelect(w, r, false)
+}
+
+func (h *Handlers) handleSpokeSelect(w http.ResponseWriter, r *http.Request, selected bool) {
+	l := sub("handlers")
+	sp, ok := h.spokeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req SelectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, ino := range req.Inodes {
+		entry, err := h.store.GetEntry(ino)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			http.Error(w, fmt.Sprintf("entry %d not found", ino), http.StatusNotFound)
+			return
+		}
+	}
+	if err := h.store.SetSpokeSelected(sp.ID, req.Inodes, selected); err != nil {
+		l.Error("spoke select failed", "spoke", sp.ID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Info("HTTP spoke select", "spoke", sp.ID, "inodes", req.Inodes, "selected", selected)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSpokePlan handles GET /api/sync/spoke/plan
+func (h *Handlers) HandleSpokePlan(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	sp, ok := h.spokeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	plan, err := h.spokePlan(sp.ID)
+	if err != nil {
+		l.Error("spoke plan failed", "spoke", sp.ID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Debug("HTTP spoke plan", "spoke", sp.ID, "fetch", len(plan.Fetch), "remove", len(plan.Remove))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan) //nolint:errcheck
+}
+
+// spokePlan compares the files under a spoke's selection, minus excluded
+// entries, with its reported copies.
+func (h *Handlers) spokePlan(spokeID string) (*SpokePlan, error) {
+	roots, err := h.store.ListSpokeSelections(spokeID)
+	if err != nil {
+		return nil, err
+	}
+	views, err := h.store.ListSpokeViews(spokeID)
+	if err != nil {
+		return nil, err
+	}
+	plan := &SpokePlan{Fetch: []SpokeFile{}, Remove: []SpokeFile{}}
+	seen := make(map[uint64]bool)
+	var walk func(e *Entry, relPath string) error
+	walk = func(e *Entry, relPath string) error {
+		if e.Excluded || seen[e.Inode] {
+			return nil
+		}
+		seen[e.Inode] = true
+		if e.Type != "dir" {
+			if v, ok := views[e.Inode]; !ok || v.SyncedMtime != e.Mtime {
+				plan.Fetch = append(plan.Fetch, SpokeFile{Inode: e.Inode, Path: relPath, Size: sizeOrZero(e.Size), Mtime: e.Mtime})
+			}
+			return nil
+		}
+		if err := h.daemon.ensureListed(e.Inode); err != nil {
+			return err
+		}
+		children, err := h.store.ListChildren(e.Inode)
+		if err != nil {
+			return err
+		}
+		for i := range children {
+			if err := walk(&children[i], relPath+"/"+children[i].Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := range roots {
+		if err := walk(&roots[i], h.resolveRelPath(&roots[i])); err != nil {
+			return nil, err
+		}
+	}
+	for ino := range views {
+		if seen[ino] {
+			continue
+		}
+		entry, err := h.store.GetEntry(ino)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			plan.Remove = append(plan.Remove, SpokeFile{Inode: ino, Path: h.resolveRelPath(entry)})
+		}
+	}
+	sort.Slice(plan.Fetch, func(i, j int) bool { return plan.Fetch[i].Path < plan.Fetch[j].Path })
+	sort.Slice(plan.Remove, func(i, j int) bool { return plan.Remove[i].Path < plan.Remove[j].Path })
+	return plan, nil
+}
+
+// spokeETag identifies the version HandleSpokeContent serves by its
+// mtime, which the spoke reads back to stamp its copy.
+func spokeETag(mtime int64) string {
+	return fmt.Sprintf(`"%d"`, mtime)
+}
+
+// HandleSpokeContent handles GET /api/sync/spoke/content/<inode>
+// Streams the Archives file, with Range support so an interrupted
+// download can continue.
+func (h *Handlers) HandleSpokeContent(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	if _, ok := h.spokeFromRequest(w, r); !ok {
+		return
+	}
+	ino, err := inodeFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid inode", http.StatusBadRequest)
+		return
+	}
+	entry, err := h.store.GetEntry(ino)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entry == nil || entry.Type == "dir" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	src := filepath.Join(h.archivesRoot, h.resolveRelPath(entry))
+	f, err := os.Open(src)
+	if err != nil {
+		l.Warn("spoke content: open failed", "path", src, "err", err)
+		http.Error(w, "file not found on disk", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", spokeETag(info.ModTime().UnixNano()))
+	http.ServeContent(w, r, entry.Name, info.ModTime(), f)
+}
+
+// HandleSpokeReport handles POST /api/sync/spoke/report
+func (h *Handlers) HandleSpokeReport(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	sp, ok := h.spokeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req SpokeReport
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	now := nowFunc().UnixNano()
+	views := make([]SpokeView, 0, len(req.Synced))
+	for _, f := range req.Synced {
+		views = append(views, SpokeView{SpokeID: sp.ID, EntryIno: f.Inode, SyncedMtime: f.Mtime, CheckedAt: now})
+	}
+	if err := h.store.UpsertSpokeViews(views); err != nil {
+		l.Error("spoke report failed", "spoke", sp.ID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.DeleteSpokeViews(sp.ID, req.Removed); err != nil {
+		l.Error("spoke report failed", "spoke", sp.ID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	l.Info("HTTP spoke report", "spoke", sp.ID, "synced", len(req.Synced), "removed", len(req.Removed))
+	w.WriteHeader(http.StatusNoContent)
+}
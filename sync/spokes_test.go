@@ -0,0 +1,244 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHub serves the spoke routes of h under /api/sync.
+func startHub(t *testing.T, h *Handlers) string {
+	t.Helper()
+	srv := httptest.NewServer(hubMux(h))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/api/sync"
+}
+
+// hubMux routes the spoke routes of h under /api/sync.
+func hubMux(h *Handlers) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/sync/spokes", h.HandleRegisterSpoke)
+	mux.HandleFunc("GET /api/sync/spokes", h.HandleListSpokes)
+	mux.HandleFunc("DELETE /api/sync/spokes/{id}", h.HandleDeleteSpoke)
+	mux.HandleFunc("GET /api/sync/spoke/entries", h.HandleSpokeEntries)
+	mux.HandleFunc("POST /api/sync/spoke/hydrate", h.HandleSpokeHydrate)
+	mux.HandleFunc("POST /api/sync/spoke/release", h.HandleSpokeRelease)
+	mux.HandleFunc("GET /api/sync/spoke/plan", h.HandleSpokePlan)
+	mux.HandleFunc("GET /api/sync/spoke/content/{inode}", h.HandleSpokeContent)
+	mux.HandleFunc("POST /api/sync/spoke/report", h.HandleSpokeReport)
+	return mux
+}
+
+// cutWriter drops the connection after limit body bytes, as a network
+// failure would.
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.limit {
+		w.limit -= len(p)
+		return w.ResponseWriter.Write(p)
+	}
+	w.ResponseWriter.Write(p[:w.limit]) //nolint:errcheck
+	w.ResponseWriter.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestSpoke_HydrateSyncRelease(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt", "Docs/b.txt", "Other.txt"}, nil)
+	hub := startHub(t, h)
+	ctx := context.Background()
+
+	reg, err := RegisterSpoke(ctx, hub, "laptop")
+	require.NoError(t, err)
+	require.NotEmpty(t, reg.Token)
+	spokeRoot := t.TempDir()
+	c := NewSpokeClient(hub, reg.Token, spokeRoot)
+
+	entries, err := c.Entries(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	docs := registered(t, store, archivesRoot, "Docs")
+	require.NoError(t, c.Hydrate(ctx, []uint64{docs.Inode}))
+
+	fetched, removed, err := c.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetched)
+	assert.Zero(t, removed)
+	got, err := os.ReadFile(filepath.Join(spokeRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Docs/a.txt", string(got))
+	_, err = os.Stat(filepath.Join(spokeRoot, "Other.txt"))
+	assert.True(t, os.IsNotExist(err), "only the spoke's selection is copied")
+
+	// The hub's own selection and Spaces are untouched
+	assert.False(t, registered(t, store, archivesRoot, "Docs").Selected)
+	_, err = os.Stat(filepath.Join(spacesRoot, "Docs", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	views, err := store.ListSpokeViews(reg.ID)
+	require.NoError(t, err)
+	assert.Len(t, views, 2)
+	entries, err = c.Entries(ctx, docs.Inode)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.Equal(t, e.Mtime, e.SyncedMtime, e.Name)
+	}
+
+	// Converged: nothing to do
+	fetched, removed, err = c.Sync(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, fetched+removed)
+
+	// A change in Archives is fetched again
+	a := filepath.Join(archivesRoot, "Docs", "a.txt")
+	require.NoError(t, os.WriteFile(a, []byte("changed"), 0644))
+	later := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(a, later, later))
+	require.NoError(t, RunPipeline(ctx, "Docs/a.txt", store, archivesRoot, spacesRoot, "", nil))
+	fetched, _, err = c.Sync(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetched)
+	got, err = os.ReadFile(filepath.Join(spokeRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(got))
+
+	require.NoError(t, c.Release(ctx, []uint64{docs.Inode}))
+	fetched, removed, err = c.Sync(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, fetched)
+	assert.Equal(t, 2, removed)
+	_, err = os.Stat(filepath.Join(spokeRoot, "Docs", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+	views, err = store.ListSpokeViews(reg.ID)
+	require.NoError(t, err)
+	assert.Empty(t, views)
+}
+
+func TestSpoke_SelectionsAreIndependent(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt", "b.txt"}, nil)
+	hub := startHub(t, h)
+	ctx := context.Background()
+
+	one, err := RegisterSpoke(ctx, hub, "one")
+	require.NoError(t, err)
+	two, err := RegisterSpoke(ctx, hub, "two")
+	require.NoError(t, err)
+	c1 := NewSpokeClient(hub, one.Token, t.TempDir())
+	c2 := NewSpokeClient(hub, two.Token, t.TempDir())
+	require.NoError(t, c1.Hydrate(ctx, []uint64{registered(t, store, archivesRoot, "a.txt").Inode}))
+	require.NoError(t, c2.Hydrate(ctx, []uint64{registered(t, store, archivesRoot, "b.txt").Inode}))
+	_, _, err = c1.Sync(ctx)
+	require.NoError(t, err)
+	_, _, err = c2.Sync(ctx)
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(c1.root, "a.txt"))
+	assert.NoFileExists(t, filepath.Join(c1.root, "b.txt"))
+	assert.FileExists(t, filepath.Join(c2.root, "b.txt"))
+	assert.NoFileExists(t, filepath.Join(c2.root, "a.txt"))
+
+	spokes, err := store.ListSpokes()
+	require.NoError(t, err)
+	require.Len(t, spokes, 2)
+
+	// A deleted spoke's token stops working
+	req, err := http.NewRequest("DELETE", hub+"/spokes/"+one.ID, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, _, err = c1.Sync(ctx)
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestSpoke_ResumesPartialDownload(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"big.bin"}, nil)
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "big.bin"), []byte("0123456789"), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "big.bin", store, archivesRoot, spacesRoot, "", nil))
+	hub := startHub(t, h)
+	ctx := context.Background()
+
+	reg, err := RegisterSpoke(ctx, hub, "laptop")
+	require.NoError(t, err)
+	c := NewSpokeClient(hub, reg.Token, t.TempDir())
+	require.NoError(t, c.Hydrate(ctx, []uint64{registered(t, store, archivesRoot, "big.bin").Inode}))
+
+	// A partial file of the same version is continued, not restarted
+	info, err := os.Stat(filepath.Join(archivesRoot, "big.bin"))
+	require.NoError(t, err)
+	tmp := filepath.Join(c.root, "big.bin.sync-tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("XXXX"), 0644))
+	require.NoError(t, os.Chtimes(tmp, time.Now(), info.ModTime()))
+	_, _, err = c.Sync(ctx)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(c.root, "big.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "XXXX456789", string(got))
+}
+
+func TestSpoke_RestartsPartialDownloadOfOtherVersion(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"big.bin"}, nil)
+	src := filepath.Join(archivesRoot, "big.bin")
+	require.NoError(t, os.WriteFile(src, []byte("AAAAAAAAAA"), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "big.bin", store, archivesRoot, spacesRoot, "", nil))
+	var limit atomic.Int32
+	limit.Store(4)
+	mux := hubMux(h)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := int(limit.Load()); n > 0 && strings.Contains(r.URL.Path, "/spoke/content/") {
+			w = &cutWriter{ResponseWriter: w, limit: n}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	hub := srv.URL + "/api/sync"
+	ctx := context.Background()
+
+	reg, err := RegisterSpoke(ctx, hub, "laptop")
+	require.NoError(t, err)
+	c := NewSpokeClient(hub, reg.Token, t.TempDir())
+	require.NoError(t, c.Hydrate(ctx, []uint64{registered(t, store, archivesRoot, "big.bin").Inode}))
+	_, _, err = c.Sync(ctx)
+	require.Error(t, err)
+
+	// The file changes before the next interrupted fetch
+	require.NoError(t, os.WriteFile(src, []byte("BBBBBBBBBB"), 0644))
+	require.NoError(t, os.Chtimes(src, time.Now(), time.Now().Add(time.Hour)))
+	require.NoError(t, RunPipeline(context.Background(), "big.bin", store, archivesRoot, spacesRoot, "", nil))
+	_, _, err = c.Sync(ctx)
+	require.Error(t, err)
+
+	limit.Store(0)
+	_, _, err = c.Sync(ctx)
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(c.root, "big.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "BBBBBBBBBB", string(got))
+}
+
+func TestSpoke_RejectsBadToken(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	hub := startHub(t, h)
+
+	_, _, err := NewSpokeClient(hub, "nope", t.TempDir()).Sync(context.Background())
+	assert.ErrorContains(t, err, "401")
+	_, err = RegisterSpoke(context.Background(), hub, " ")
+	assert.ErrorContains(t, err, "400")
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// ErrSourceUnstable is returned when a file about to be copied is still
+// being written. The worker requeues the path after Config.StableMs.
+var ErrSourceUnstable = errors.New("source still being written")
+
+// openCheckWindow bounds the open-for-write check to recently modified
+// files: a writer that hasn't touched the file for this long is
+// treated as done.
+const openCheckWindow = time.Minute
+
+// minUnstableRetry is the requeue delay when Config.StableMs is 0.
+const minUnstableRetry = 2 * time.Second
+
+// checkStable reports ErrSourceUnstable if path was modified within
+// Config.StableMs, or, with Config.OpenWriteCheck, is open for writing by
+// another process. A missing file is left to the caller. Ages are taken
+// by the clock of path's root; a file whose mtime is in that clock's
+// future is aged by its inode change time instead, if known.
+func checkStable(path string) error {
+	info, err := fsFor(path).Stat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	cfg := activeConfig.Load()
+	age := mtimeAge(path, info.ModTime())
+	if age < -futureMtimeSlack {
+		sub("stability").Warn("future mtime, ignoring it", "path", path, "mtime", info.ModTime(), "ahead", (-age).Round(time.Second))
+		age = openCheckWindow
+		if ctime := changeTime(info); ctime != 0 {
+			age = mtimeAge(path, time.Unix(0, ctime))
+		}
+	}
+	if quiet := time.Duration(cfg.StableMs) * time.Millisecond; age < quiet {
+		return fmt.Errorf("%w: %s modified %s ago", ErrSourceUnstable, filepath.Base(path), age.Round(time.Millisecond))
+	}
+	if cfg.OpenWriteCheck && age < openCheckWindow && !isRemote(path) && openForWrite(path) {
+		return fmt.Errorf("%w: %s is open for writing", ErrSourceUnstable, filepath.Base(path))
+	}
+	return nil
+}
+
+// unstableRetry returns how long to wait before re-evaluating a path
+// that failed checkStable.
+func unstableRetry() time.Duration {
+	return max(time.Duration(activeConfig.Load().StableMs)*time.Millisecond, minUnstableRetry)
+}
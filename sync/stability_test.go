@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStable_QuietPeriod(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.StableMs = 5_000
+	require.NoError(t, setConfig(cfg))
+
+	p := filepath.Join(t.TempDir(), "download.bin")
+	require.NoError(t, os.WriteFile(p, []byte("partial"), 0644))
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	clk := useFakeClock(t, info.ModTime().Add(4999*time.Millisecond))
+	assert.ErrorIs(t, checkStable(p), ErrSourceUnstable)
+	assert.Equal(t, 5*time.Second, unstableRetry())
+
+	clk.Advance(time.Millisecond)
+	assert.NoError(t, checkStable(p))
+	assert.NoError(t, checkStable(filepath.Join(t.TempDir(), "missing")))
+}
+
+// A bad camera clock must not hold a file back forever: with its mtime in
+// the future, the file is aged by when it was last changed.
+func TestCheckStable_FutureMtime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs ctime")
+	}
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.StableMs = 5_000
+	require.NoError(t, setConfig(cfg))
+
+	p := filepath.Join(t.TempDir(), "IMG_0001.jpg")
+	require.NoError(t, os.WriteFile(p, []byte("jpeg"), 0644))
+	future := time.Now().AddDate(1, 0, 0)
+	require.NoError(t, os.Chtimes(p, future, future))
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	ctime := time.Unix(0, changeTime(info))
+
+	clk := useFakeClock(t, ctime.Add(time.Second))
+	assert.ErrorIs(t, checkStable(p), ErrSourceUnstable, "just written")
+	clk.Advance(5 * time.Second)
+	assert.NoError(t, checkStable(p))
+}
+
+func TestCheckStable_OpenForWrite(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+	restoreConfig(t)
+	p := filepath.Join(t.TempDir(), "download.bin")
+	require.NoError(t, os.WriteFile(p, []byte("partial"), 0644))
+	assert.NoError(t, checkStable(p), "closed file is stable")
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	writer := exec.Command("sleep", "10")
+	writer.Stdout = f
+	require.NoError(t, writer.Start())
+	f.Close()
+	t.Cleanup(func() { writer.Process.Kill(); writer.Wait() }) //nolint:errcheck
+
+	assert.ErrorIs(t, checkStable(p), ErrSourceUnstable)
+
+	cfg := currentConfig()
+	cfg.OpenWriteCheck = false
+	require.NoError(t, setConfig(cfg))
+	assert.NoError(t, checkStable(p))
+}
+
+func TestPipeline_DefersCopyOfUnstableFile(t *testing.T) {
+	restoreConfig(t)
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "big.iso"), []byte("partial"), 0644))
+	require.NoError(t, RunPipeline(context.Background(), "big.iso", store, archivesRoot, spacesRoot, trash, nil))
+	entry, _, err := lookupDB(store, archivesRoot, "big.iso")
+	require.NoError(t, err)
+	require.NoError(t, store.SetSelected([]uint64{entry.Inode}, true))
+
+	cfg := currentConfig()
+	cfg.StableMs = 60_000
+	require.NoError(t, setConfig(cfg))
+	err = RunPipeline(context.Background(), "big.iso", store, archivesRoot, spacesRoot, trash, nil)
+	assert.True(t, errors.Is(err, ErrSourceUnstable), "got %v", err)
+	assert.NoFileExists(t, filepath.Join(spacesRoot, "big.iso"))
+
+	cfg.StableMs = 0
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, RunPipeline(context.Background(), "big.iso", store, archivesRoot, spacesRoot, trash, nil))
+	assert.FileExists(t, filepath.Join(spacesRoot, "big.iso"))
+}
+
+func TestEvalQueue_PushAfter(t *testing.T) {
+	q := NewEvalQueue()
+	q.PushAfter("later.txt", 20*time.Millisecond)
+	assert.Equal(t, 0, q.Len())
+	assert.Eventually(t, func() bool { return q.Has("later.txt") }, time.Second, 5*time.Millisecond)
+}
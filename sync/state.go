@@ -132,6 +132,13 @@ func (s State) Scenario() int {
 	return 34 // A_dirty=1, S_dirty=1
 }
 
+// Settled reports whether the pipeline has nothing to do in this state:
+// archived (15) or synced (31).
+func (s State) Settled() bool {
+	sc := s.Scenario()
+	return sc == 15 || sc == 31
+}
+
 // UIStatus returns the human-readable status label for this state.
 func (s State) UIStatus() string {
 	sc := s.Scenario()
@@ -167,8 +174,11 @@ func (s State) UIStatus() string {
 
 // ComputeState builds a State from the entry, spaces_view, and disk stat results.
 // archiveStat and spacesStat are nil when the file doesn't exist on disk.
+// Mtimes are compared within the granularity detected for each root.
 func ComputeState(entry *Entry, sv *SpacesView, archiveMtime *int64, spacesMtime *int64) State {
 	st := State{}
+	cfg := activeConfig.Load()
+	aGrain, sGrain := mtimeGrain(cfg.ArchivesRoot), mtimeGrain(cfg.SpacesRoot)
 
 	st.ADisk = archiveMtime != nil
 	st.ADb = entry != nil
@@ -181,14 +191,14 @@ func ComputeState(entry *Entry, sv *SpacesView, archiveMtime *int64, spacesMtime
 		// so dirty detection is meaningless for them.
 		if entry.Type != "dir" {
 			if archiveMtime != nil {
-				st.ADirty = *archiveMtime != entry.Mtime
+				st.ADirty = !sameMtime(*archiveMtime, entry.Mtime, aGrain)
 			}
 			if sv != nil && spacesMtime != nil {
-				st.SDirty = *spacesMtime != sv.SyncedMtime
+				st.SDirty = !sameMtime(*spacesMtime, sv.SyncedMtime, sGrain)
 			}
 		}
 	} else if sv != nil && spacesMtime != nil {
-		st.SDirty = *spacesMtime != sv.SyncedMtime
+		st.SDirty = !sameMtime(*spacesMtime, sv.SyncedMtime, sGrain)
 	}
 
 	return st
@@ -0,0 +1,31 @@
+package sync
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed statuspage
+var statusPageFS embed.FS
+
+// StatusPage serves the built-in status page, to be mounted at
+// /sync/status with the prefix stripped: stats, queue depth, recent
+// errors and open conflicts, read from the sync API, for deployments
+// without the filebrowser frontend.
+func (h *Handlers) StatusPage() http.Handler {
+	files, _ := fs.Sub(statusPageFS, "statuspage") // embedded, always there
+	static := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			// The page links the API relative to /sync/status/. The
+			// browser resolves the location against the URL it asked for,
+			// base URL included.
+			w.Header().Set("Location", "status/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		static.ServeHTTP(w, r)
+	})
+}
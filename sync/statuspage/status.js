@@ -0,0 +1,97 @@
+// Renders the sync API's stats, recent errors and open conflicts. The
+// page is served at <base>/sync/status/, so the API is two levels up.
+"use strict";
+
+const api = "../../api/sync/";
+const refreshMs = 5000;
+
+function bytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${n.toFixed(i ? 1 : 0)} ${units[i]}`;
+}
+
+function duration(s) {
+  if (s < 0) return "unknown";
+  const h = Math.floor(s / 3600), m = Math.floor((s % 3600) / 60);
+  return h ? `${h}h ${m}m` : m ? `${m}m ${s % 60}s` : `${s}s`;
+}
+
+function nanoTime(ns) {
+  return new Date(ns / 1e6).toLocaleString();
+}
+
+function cell(row, text, cls) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (cls) td.className = cls;
+}
+
+function fill(table, items, render, emptyText) {
+  const body = document.querySelector(`#${table} tbody`);
+  body.replaceChildren();
+  if (!items.length) {
+    cell(body.insertRow(), emptyText, "empty").colSpan = 4;
+    return;
+  }
+  for (const it of items) render(body.insertRow(), it);
+}
+
+async function get(path) {
+  const resp = await fetch(api + path);
+  if (!resp.ok) throw new Error(`${path}: ${resp.status} ${await resp.text()}`);
+  return resp.json();
+}
+
+async function refresh() {
+  const updated = document.getElementById("updated");
+  try {
+    const [stats, errors, conflicts] = await Promise.all([
+      get("stats"),
+      get("errors?limit=50"),
+      get("conflicts"),
+    ]);
+
+    const dl = document.getElementById("stats");
+    dl.replaceChildren();
+    for (const [k, v] of [
+      ["Queue", `${stats.queueLen} paths, ${bytes(stats.bytesPending)} pending`],
+      ["Throughput", `${bytes(stats.throughput)}/s, ${stats.itemsPerSec.toFixed(1)} items/s`],
+      ["ETA", duration(stats.etaSeconds)],
+      ["Archives", bytes(stats.archivesSize)],
+      ["Spaces", bytes(stats.spacesSize)],
+      ["Disk", `${bytes(stats.diskFree)} free of ${bytes(stats.diskTotal)}`],
+      ["Power", stats.deferred ? "deferred" : stats.idle ? "idle" : "active"],
+    ]) {
+      dl.append(Object.assign(document.createElement("dt"), { textContent: k }));
+      dl.append(Object.assign(document.createElement("dd"), { textContent: v }));
+    }
+
+    fill("errors", errors.items, (row, e) => {
+      cell(row, new Date(e.time).toLocaleString());
+      cell(row, e.level, e.level === "ERROR" ? "error" : "warn");
+      cell(row, e.comp);
+      const attrs = Object.entries(e.attrs || {}).map(([k, v]) => `${k}=${v}`).join(" ");
+      cell(row, attrs ? `${e.msg} (${attrs})` : e.msg);
+    }, "No recent errors.");
+
+    fill("conflicts", conflicts.items, (row, c) => {
+      cell(row, nanoTime(c.time));
+      cell(row, c.path);
+      cell(row, c.conflictName);
+    }, "No open conflicts.");
+
+    updated.textContent = `Updated ${new Date().toLocaleTimeString()}`;
+    updated.className = "";
+  } catch (err) {
+    updated.textContent = `Refresh failed: ${err.message}`;
+    updated.className = "error";
+  }
+}
+
+refresh();
+setInterval(refresh, refreshMs);
@@ -0,0 +1,31 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPage(t *testing.T) {
+	h, _, _, _ := setupHandlersEnv(t)
+	page := http.StripPrefix("/sync/status", h.StatusPage())
+
+	w := httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/sync/status", nil))
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "status/", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/sync/status/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<script src="status.js">`, "no inline script: the CSP forbids it")
+
+	w = httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/sync/status/status.js", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, endpoint := range []string{`get("stats")`, `get("errors`, `get("conflicts")`} {
+		assert.Contains(t, w.Body.String(), endpoint)
+	}
+}
@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// fileSystem is the file access the pipeline needs on a root. Spaces may
+// live on another machine (Config.SpacesRemote); everything else is the
+// local disk. FileInfo.Sys() returns a *syscall.Stat_t on every
+// implementation so inode lookups work unchanged.
+type fileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	// Append opens name for writing at its end, creating it if needed,
+	// and returns the size it already had.
+	Append(name string) (io.WriteCloser, int64, error)
+	Rename(from, to string) error
+	Remove(name string) error
+	MkdirAll(name string, perm os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// treeScanner is implemented by file systems that list a whole tree more
+// cheaply than directory by directory.
+type treeScanner interface {
+	ScanTree(root string) (map[string]FileStat, error)
+}
+
+// resumable is implemented by file systems on which interrupted copies
+// keep their partial temp file, to be continued by the next attempt.
+type resumable interface {
+	Resumable() bool
+}
+
+// osFS is the local disk.
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error)  { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+func (osFS) Rename(from, to string) error           { return os.Rename(from, to) }
+func (osFS) Remove(name string) error               { return os.Remove(name) }
+
+func (osFS) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+func (osFS) Append(name string) (io.WriteCloser, int64, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) {
+	des, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(des))
+	for _, d := range des {
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			continue // removed since ReadDir
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// mount routes the paths below its roots to fs.
+type mount struct {
+	roots []string
+	fs    fileSystem
+}
+
+var (
+	mountsMu gosync.RWMutex
+	mounts   []mount
+)
+
+// mountFS routes every path under roots to fs, e.g. the Spaces and trash
+// roots to a remote machine. It returns a func undoing the mount.
+func mountFS(fs fileSystem, roots ...string) func() {
+	m := mount{fs: fs}
+	for _, r := range roots {
+		m.roots = append(m.roots, filepath.Clean(r))
+	}
+	mountsMu.Lock()
+	mounts = append(mounts, m)
+	mountsMu.Unlock()
+	return func() {
+		mountsMu.Lock()
+		defer mountsMu.Unlock()
+		for i := range mounts {
+			if mounts[i].fs == fs {
+				mounts = append(mounts[:i], mounts[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// fsFor returns the file system holding path.
+func fsFor(path string) fileSystem {
+	mountsMu.RLock()
+	defer mountsMu.RUnlock()
+	for _, m := range mounts {
+		for _, root := range m.roots {
+			if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+				return m.fs
+			}
+		}
+	}
+	return osFS{}
+}
+
+// isRemote reports whether path is anything but plain files on the local
+// disk: a remote machine, or the block store. Such a Spaces is scanned
+// rather than watched, and its content served from Archives.
+func isRemote(path string) bool {
+	_, local := fsFor(path).(osFS)
+	return !local
+}
@@ -0,0 +1,453 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// exitNotExist is the exit status the remote commands use for a missing
+// path, mapped back to fs.ErrNotExist.
+const exitNotExist = 44
+
+// sshDialTimeout bounds connecting and authenticating to the remote.
+const sshDialTimeout = 15 * time.Second
+
+// statFormat is the find -printf format parsed by parseStat: type, mode,
+// size, inode, mtime, atime and the name or relative path.
+const statFormat = `%y\t%m\t%s\t%i\t%T@\t%A@\t`
+
+// sshFS is a root on another machine reached over SSH, for
+// Config.SpacesRemote. Every operation is one shell command on the
+// remote, which needs GNU coreutils and findutils; file data streams
+// through cat. Connections come from a small pool and are redialed when
+// they drop. Interrupted copies to it resume.
+type sshFS struct {
+	pool *sshPool
+}
+
+// newSSHFS connects to cfg.SpacesRemote ("user@host[:port]") with the
+// private key cfg.SpacesRemoteKey, verifying the host against
+// cfg.SpacesRemoteKnownHosts.
+func newSSHFS(cfg Config) (*sshFS, error) {
+	user, addr, ok := strings.Cut(cfg.SpacesRemote, "@")
+	if !ok || user == "" || addr == "" {
+		return nil, fmt.Errorf("spacesRemote must be user@host[:port], got %q", cfg.SpacesRemote)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	key, err := os.ReadFile(cfg.SpacesRemoteKey)
+	if err != nil {
+		return nil, fmt.Errorf("read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key: %w", err)
+	}
+	hostKeys, err := knownhosts.New(cfg.SpacesRemoteKnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	pool := &sshPool{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeys,
+			Timeout:         sshDialTimeout,
+		},
+		clients: make([]*ssh.Client, cfg.SpacesRemotePool),
+	}
+	if _, _, err := pool.client(); err != nil {
+		return nil, err // fail fast on bad credentials or host key
+	}
+	sub("remote").Info("connected to remote Spaces", "addr", addr, "user", user, "pool", cfg.SpacesRemotePool)
+	return &sshFS{pool: pool}, nil
+}
+
+// sshPool hands out SSH connections round-robin, dialing lazily.
+type sshPool struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu      gosync.Mutex
+	clients []*ssh.Client
+	next    int
+}
+
+func (p *sshPool) client() (*ssh.Client, int, error) {
+	p.mu.Lock()
+	slot := p.next
+	p.next = (p.next + 1) % len(p.clients)
+	c := p.clients[slot]
+	p.mu.Unlock()
+	if c != nil {
+		return c, slot, nil
+	}
+
+	c, err := ssh.Dial("tcp", p.addr, p.config)
+	if err != nil {
+		return nil, slot, fmt.Errorf("ssh dial %s: %w", p.addr, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old := p.clients[slot]; old != nil {
+		c.Close() // raced with another dial for this slot
+		return old, slot, nil
+	}
+	p.clients[slot] = c
+	return c, slot, nil
+}
+
+// drop discards the connection in slot if it is still c.
+func (p *sshPool) drop(slot int, c *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[slot] == c {
+		p.clients[slot] = nil
+		c.Close()
+	}
+}
+
+// session opens a session, redialing once if the connection dropped.
+func (p *sshPool) session() (*ssh.Session, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var c *ssh.Client
+		var slot int
+		if c, slot, err = p.client(); err != nil {
+			return nil, err
+		}
+		var s *ssh.Session
+		if s, err = c.NewSession(); err == nil {
+			return s, nil
+		}
+		sub("remote").Warn("ssh session failed, reconnecting", "addr", p.addr, "err", err)
+		p.drop(slot, c)
+	}
+	return nil, fmt.Errorf("ssh session: %w", err)
+}
+
+// Close closes every pooled connection.
+func (p *sshPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, c := range p.clients {
+		if c != nil {
+			c.Close()
+			p.clients[i] = nil
+		}
+	}
+	return nil
+}
+
+func (f *sshFS) Close() error { return f.pool.Close() }
+
+// Resumable reports true: a dropped connection shouldn't restart a large
+// transfer from scratch.
+func (f *sshFS) Resumable() bool { return true }
+
+// run runs cmd on the remote with stdin and returns its stdout.
+func (f *sshFS) run(op, name, cmd string, stdin io.Reader) ([]byte, error) {
+	s, err := f.pool.session()
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer s.Close()
+	var stdout, stderr bytes.Buffer
+	s.Stdin, s.Stdout, s.Stderr = stdin, &stdout, &stderr
+	if err := s.Run(cmd); err != nil {
+		return nil, remoteError(op, name, err, &stderr)
+	}
+	return stdout.Bytes(), nil
+}
+
+// remoteError maps a failed remote command to a *fs.PathError, with
+// fs.ErrNotExist for exitNotExist so os.IsNotExist works.
+func remoteError(op, name string, err error, stderr *bytes.Buffer) error {
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) {
+		if exit.ExitStatus() == exitNotExist {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = errors.New(msg)
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *sshFS) Stat(name string) (os.FileInfo, error)  { return f.stat("stat", name, "-L ") }
+func (f *sshFS) Lstat(name string) (os.FileInfo, error) { return f.stat("lstat", name, "") }
+
+func (f *sshFS) stat(op, name, follow string) (os.FileInfo, error) {
+	q := shellQuote(name)
+	test := "[ -e " + q + " ]"
+	if follow == "" {
+		test += " || [ -L " + q + " ]"
+	}
+	out, err := f.run(op, name, fmt.Sprintf("%s || exit %d; find %s%s -maxdepth 0 -printf '%s%%f\\0'", test, exitNotExist, follow, q, statFormat), nil)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := parseStats(out)
+	if err != nil || len(infos) != 1 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("unexpected stat output %q", out)}
+	}
+	infos[0].name = path.Base(name)
+	return infos[0], nil
+}
+
+func (f *sshFS) ReadDir(name string) ([]os.FileInfo, error) {
+	q := shellQuote(name)
+	out, err := f.run("readdir", name, fmt.Sprintf("[ -d %s ] || exit %d; find %s -mindepth 1 -maxdepth 1 -printf '%s%%f\\0'", q, exitNotExist, q, statFormat), nil)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := parseStats(out)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	list := make([]os.FileInfo, len(infos))
+	for i := range infos {
+		list[i] = infos[i]
+	}
+	return list, nil
+}
+
+// ScanTree lists the whole tree under root with a single find.
+func (f *sshFS) ScanTree(root string) (map[string]FileStat, error) {
+	q := shellQuote(root)
+	out, err := f.run("scan", root, fmt.Sprintf("[ -d %s ] || exit %d; find %s -mindepth 1 -printf '%s%%P\\0'", q, exitNotExist, q, statFormat), nil)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := parseStats(out)
+	if err != nil {
+		return nil, &fs.PathError{Op: "scan", Path: root, Err: err}
+	}
+	files := make(map[string]FileStat, len(infos))
+	for _, info := range infos {
+		files[info.name] = FileStat{
+			Inode: info.sys.Ino,
+			Name:  path.Base(info.name),
+			Size:  info.size,
+			Mtime: info.mtime.UnixNano(),
+			IsDir: info.IsDir(),
+		}
+	}
+	return files, nil
+}
+
+// Open starts cat without waiting for it, so a missing file is reported
+// by the first Read rather than by Open.
+func (f *sshFS) Open(name string) (io.ReadCloser, error) {
+	s, err := f.pool.session()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	r := &sshReader{name: name, s: s}
+	s.Stderr = &r.stderr
+	stdout, err := s.StdoutPipe()
+	if err == nil {
+		q := shellQuote(name)
+		err = s.Start(fmt.Sprintf("[ -f %s ] || exit %d; exec cat -- %s", q, exitNotExist, q))
+	}
+	if err != nil {
+		s.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	r.r = stdout
+	return r, nil
+}
+
+// sshReader streams a remote file; the command's exit status is checked
+// at EOF so a failed cat isn't mistaken for a short file.
+type sshReader struct {
+	name   string
+	s      *ssh.Session
+	r      io.Reader
+	stderr bytes.Buffer
+}
+
+func (r *sshReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		if werr := r.s.Wait(); werr != nil {
+			return n, remoteError("read", r.name, werr, &r.stderr)
+		}
+	}
+	return n, err
+}
+
+func (r *sshReader) Close() error { return r.s.Close() }
+
+func (f *sshFS) Append(name string) (io.WriteCloser, int64, error) {
+	var size int64
+	if info, err := f.Lstat(name); err == nil {
+		size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	s, err := f.pool.session()
+	if err != nil {
+		return nil, 0, &fs.PathError{Op: "append", Path: name, Err: err}
+	}
+	w := &sshWriter{name: name, s: s}
+	s.Stderr = &w.stderr
+	stdin, err := s.StdinPipe()
+	if err == nil {
+		err = s.Start("exec cat >> " + shellQuote(name))
+	}
+	if err != nil {
+		s.Close()
+		return nil, 0, &fs.PathError{Op: "append", Path: name, Err: err}
+	}
+	w.w = stdin
+	return w, size, nil
+}
+
+// sshWriter streams into a remote file; Close waits for the remote side
+// to have written everything.
+type sshWriter struct {
+	name   string
+	s      *ssh.Session
+	w      io.WriteCloser
+	stderr bytes.Buffer
+}
+
+func (w *sshWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+
+func (w *sshWriter) Close() error {
+	defer w.s.Close()
+	if err := w.w.Close(); err != nil {
+		return &fs.PathError{Op: "write", Path: w.name, Err: err}
+	}
+	if err := w.s.Wait(); err != nil {
+		return remoteError("write", w.name, err, &w.stderr)
+	}
+	return nil
+}
+
+func (f *sshFS) Rename(from, to string) error {
+	q := shellQuote(from)
+	_, err := f.run("rename", from, fmt.Sprintf("[ -e %s ] || [ -L %s ] || exit %d; mv -f -T -- %s %s", q, q, exitNotExist, q, shellQuote(to)), nil)
+	return err
+}
+
+func (f *sshFS) Remove(name string) error {
+	q := shellQuote(name)
+	_, err := f.run("remove", name, fmt.Sprintf("[ -e %s ] || [ -L %s ] || exit %d; if [ -d %s ] && [ ! -L %s ]; then rmdir -- %s; else rm -f -- %s; fi",
+		q, q, exitNotExist, q, q, q, q), nil)
+	return err
+}
+
+func (f *sshFS) MkdirAll(name string, perm os.FileMode) error {
+	_, err := f.run("mkdir", name, fmt.Sprintf("mkdir -p -m %o -- %s", perm.Perm(), shellQuote(name)), nil)
+	return err
+}
+
+func (f *sshFS) Chtimes(name string, atime, mtime time.Time) error {
+	q := shellQuote(name)
+	_, err := f.run("chtimes", name, fmt.Sprintf("[ -e %s ] || exit %d; touch -c -m -d @%s -- %s && touch -c -a -d @%s -- %s",
+		q, exitNotExist, unixDecimal(mtime), q, unixDecimal(atime), q), nil)
+	return err
+}
+
+// unixDecimal formats t as seconds.nanoseconds since the epoch, for touch -d @.
+func unixDecimal(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// remoteInfo is an os.FileInfo parsed from find -printf output.
+type remoteInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mtime time.Time
+	atime int64 // nanoseconds
+	sys   *syscall.Stat_t
+}
+
+func (i *remoteInfo) Name() string       { return i.name }
+func (i *remoteInfo) Size() int64        { return i.size }
+func (i *remoteInfo) Mode() os.FileMode  { return i.mode }
+func (i *remoteInfo) ModTime() time.Time { return i.mtime }
+func (i *remoteInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *remoteInfo) Sys() any           { return i.sys }
+
+// parseStats parses NUL-terminated statFormat records.
+func parseStats(out []byte) ([]*remoteInfo, error) {
+	var infos []*remoteInfo
+	for _, rec := range strings.Split(string(out), "\x00") {
+		if rec == "" {
+			continue
+		}
+		fields := strings.SplitN(rec, "\t", 7)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("malformed stat record %q", rec)
+		}
+		perm, err1 := strconv.ParseUint(fields[1], 8, 32)
+		size, err2 := strconv.ParseInt(fields[2], 10, 64)
+		ino, err3 := strconv.ParseUint(fields[3], 10, 64)
+		mtime, err4 := parseUnixDecimal(fields[4])
+		atime, err5 := parseUnixDecimal(fields[5])
+		if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+			return nil, fmt.Errorf("malformed stat record %q: %w", rec, err)
+		}
+		mode := os.FileMode(perm) & os.ModePerm
+		switch fields[0] {
+		case "d":
+			mode |= os.ModeDir
+		case "l":
+			mode |= os.ModeSymlink
+		case "f":
+		default:
+			mode |= os.ModeIrregular
+		}
+		infos = append(infos, &remoteInfo{
+			name:  fields[6],
+			size:  size,
+			mode:  mode,
+			mtime: time.Unix(0, mtime),
+			atime: atime,
+			sys:   &syscall.Stat_t{Ino: ino, Size: size},
+		})
+	}
+	return infos, nil
+}
+
+// parseUnixDecimal parses find's %T@ ("1700000000.1234567890") into
+// nanoseconds since the epoch.
+func parseUnixDecimal(s string) (int64, error) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	frac = (frac + "000000000")[:9]
+	nsec, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sec*int64(time.Second) + nsec, nil
+}
@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startTestSSHServer runs an SSH server on localhost that executes
+// "exec" requests with sh, accepting only the returned config's key.
+func startTestSSHServer(t *testing.T) Config {
+	t.Helper()
+	if out, err := exec.Command("find", "--version").Output(); err != nil || !strings.Contains(string(out), "GNU") {
+		t.Skip("needs GNU findutils")
+	}
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSSHPub, err := ssh.NewPublicKey(clientPub)
+	require.NoError(t, err)
+
+	keyBlock, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(keyBlock), 0600))
+
+	server := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientSSHPub.Marshal()) {
+				return nil, assert.AnError
+			}
+			return nil, nil
+		},
+	}
+	server.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSH(conn, server)
+		}
+	}()
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{ln.Addr().String()}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600))
+
+	cfg := DefaultConfig()
+	cfg.SpacesRemote = "test@" + ln.Addr().String()
+	cfg.SpacesRemoteKey = keyFile
+	cfg.SpacesRemoteKnownHosts = knownHostsFile
+	return cfg
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only") //nolint:errcheck
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range chReqs {
+				if req.Type != "exec" || len(req.Payload) < 4 {
+					req.Reply(false, nil) //nolint:errcheck
+					continue
+				}
+				req.Reply(true, nil) //nolint:errcheck
+				runTestExec(ch, string(req.Payload[4:]))
+				return
+			}
+		}()
+	}
+}
+
+// runTestExec runs cmd with the channel as its stdio, then reports the
+// exit status and closes the channel.
+func runTestExec(ch ssh.Channel, cmd string) {
+	defer ch.Close()
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdout, c.Stderr = ch, ch.Stderr()
+	stdin, err := c.StdinPipe()
+	if err == nil {
+		err = c.Start()
+	}
+	if err != nil {
+		return
+	}
+	go func() {
+		io.Copy(stdin, ch) //nolint:errcheck
+		stdin.Close()
+	}()
+	var status uint32
+	if err := c.Wait(); err != nil {
+		status = 1
+		if exit, ok := err.(*exec.ExitError); ok {
+			status = uint32(exit.ExitCode())
+		}
+	}
+	payload := binary.BigEndian.AppendUint32(nil, status)
+	ch.SendRequest("exit-status", false, payload) //nolint:errcheck
+}
+
+func TestSSHFS_Operations(t *testing.T) {
+	cfg := startTestSSHServer(t)
+	fs, err := newSSHFS(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+	root := t.TempDir()
+
+	require.NoError(t, fs.MkdirAll(filepath.Join(root, "a b", "c"), 0755))
+	w, size, err := fs.Append(filepath.Join(root, "a b", "it's.txt"))
+	require.NoError(t, err)
+	assert.Zero(t, size)
+	_, err = w.Write([]byte("hello "))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	w, size, err = fs.Append(filepath.Join(root, "a b", "it's.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), size)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	file := filepath.Join(root, "a b", "it's.txt")
+	mtime := time.Unix(1700000000, 123456789)
+	require.NoError(t, fs.Chtimes(file, mtime, mtime))
+
+	info, err := fs.Stat(file)
+	require.NoError(t, err)
+	local, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, "it's.txt", info.Name())
+	assert.Equal(t, int64(11), info.Size())
+	assert.Equal(t, mtime.UnixNano(), info.ModTime().UnixNano())
+	assert.Equal(t, local.Sys().(*syscall.Stat_t).Ino, info.Sys().(*syscall.Stat_t).Ino, "Sys carries the remote inode")
+	assert.Equal(t, mtime.UnixNano(), accessTime(info))
+
+	r, err := fs.Open(file)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "hello world", string(data))
+
+	list, err := fs.ReadDir(filepath.Join(root, "a b"))
+	require.NoError(t, err)
+	names := map[string]bool{}
+	for _, fi := range list {
+		names[fi.Name()] = fi.IsDir()
+	}
+	assert.Equal(t, map[string]bool{"c": true, "it's.txt": false}, names)
+
+	tree, err := fs.ScanTree(root)
+	require.NoError(t, err)
+	assert.Len(t, tree, 3)
+	assert.Equal(t, int64(11), tree[filepath.Join("a b", "it's.txt")].Size)
+	assert.True(t, tree[filepath.Join("a b", "c")].IsDir)
+
+	moved := filepath.Join(root, "a b", "c", "moved.txt")
+	require.NoError(t, fs.Rename(file, moved))
+	_, err = fs.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+	require.NoError(t, fs.Remove(moved))
+	require.NoError(t, fs.Remove(filepath.Join(root, "a b", "c")))
+	assert.True(t, os.IsNotExist(fs.Remove(moved)))
+	r, err = fs.Open(moved)
+	require.NoError(t, err, "a missing file surfaces on the first read")
+	_, err = io.ReadAll(r)
+	r.Close()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSafeCopy_RemoteResumes(t *testing.T) {
+	cfg := startTestSSHServer(t)
+	fs, err := newSSHFS(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	defer mountFS(fs, dstDir)()
+
+	src := filepath.Join(srcDir, "big.bin")
+	content := strings.Repeat("0123456789", 1000)
+	require.NoError(t, os.WriteFile(src, []byte(content), 0644))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	// Leave what an interrupted copy of the first half would have; the
+	// marked-up prefix shows it was kept rather than copied again
+	dst := filepath.Join(dstDir, "big.bin")
+	partial := strings.Repeat("x", 5000)
+	require.NoError(t, os.WriteFile(dst+".sync-tmp", []byte(partial), 0644))
+	marker := fmt.Sprintf("%d %d", mtime.UnixNano(), len(content))
+	require.NoError(t, os.WriteFile(resumeMarker(dst), []byte(marker), 0644))
+
+	require.NoError(t, SafeCopy(context.Background(), src, dst, nil))
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, partial+content[5000:], string(got))
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.Equal(t, mtime.UnixNano(), info.ModTime().UnixNano())
+	_, err = os.Stat(resumeMarker(dst))
+	assert.True(t, os.IsNotExist(err), "marker removed after completion")
+}
@@ -1,46 +1,247 @@
 package sync
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 )
 
-// Store provides CRUD operations on the sync database.
+// entryColumns is the column list matching scanEntry.
+const entryColumns = "inode, parent_ino, name, type, size, mtime, selected, excluded, starred, created_at, updated_at, deleted_at"
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanEntry scans a row selected with entryColumns.
+func scanEntry(r rowScanner, e *Entry) error {
+	return r.Scan(&e.Inode, &e.ParentIno, &e.Name, &e.Type, &e.Size, &e.Mtime, &e.Selected, &e.Excluded, &e.Starred, &e.CreatedAt, &e.UpdatedAt, &e.DeletedAt)
+}
+
+// Store provides CRUD operations on the sync database. Writes use the
+// single writer connection and reads the read-only pool.
 type Store struct {
-	db *sql.DB
+	db       *sql.DB
+	rdb      *sql.DB
+	dirSizes *dirSizeCache
+	ctx      context.Context // nil: never cancelled
 }
 
 // NewStore creates a Store backed by the given database.
-func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+func NewStore(db *DB) *Store {
+	return &Store{db: db.DB, rdb: db.read, dirSizes: &dirSizeCache{}}
+}
+
+// WithContext returns the Store with its queries, writes and
+// transactions bound to ctx: they stop with an error once ctx is done,
+// so an abandoned request doesn't keep its queries running. The copy
+// shares the database and caches with s.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// context returns the context the Store is bound to.
+func (s *Store) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// storeFault, when set, is called before every write and fails it with
+// the error it returns. It is a seam for fault-injection tests.
+var storeFault func() error
+
+// exec runs a write statement on the writer, retrying while the
+// database is busy.
+func (s *Store) exec(query string, args ...any) (res sql.Result, err error) {
+	if storeFault != nil {
+		if err := storeFault(); err != nil {
+			return nil, err
+		}
+	}
+	err = retryBusy(func() error {
+		res, err = s.db.ExecContext(s.context(), query, args...)
+		return err
+	})
+	return res, err
+}
+
+// begin starts a write transaction. Transactions begin IMMEDIATE, so a
+// busy database shows here rather than at the first write.
+func (s *Store) begin() (tx *sql.Tx, err error) {
+	if storeFault != nil {
+		if err := storeFault(); err != nil {
+			return nil, err
+		}
+	}
+	err = retryBusy(func() error {
+		tx, err = s.db.BeginTx(s.context(), nil)
+		return err
+	})
+	return tx, err
+}
+
+// DBStats reports pool waits and busy retries since startup.
+func (s *Store) DBStats() DBStats {
+	r, w := s.rdb.Stats(), s.db.Stats()
+	return DBStats{
+		ReadOpen:    r.OpenConnections,
+		ReadInUse:   r.InUse,
+		ReadWaits:   r.WaitCount,
+		ReadWaitMs:  r.WaitDuration.Milliseconds(),
+		WriteWaits:  w.WaitCount,
+		WriteWaitMs: w.WaitDuration.Milliseconds(),
+		Busy:        dbBusy.busy.Load(),
+		BusyRetries: dbBusy.retries.Load(),
+		BackoffMs:   time.Duration(dbBusy.backoff.Load()).Milliseconds(),
+	}
 }
 
 // UpsertEntry inserts or updates an entry keyed by path (parent_ino + name).
 // Handles rm+touch: same path, new inode → ON CONFLICT updates inode.
+// A tombstone at the path is brought back to life.
 func (s *Store) UpsertEntry(e Entry) error {
 	l := sub("store")
 	l.Debug("UpsertEntry", "inode", e.Inode, "parentIno", e.ParentIno, "name", e.Name, "type", e.Type, "selected", e.Selected)
-	_, err := s.db.Exec(`
-		INSERT INTO entries (inode, parent_ino, name, type, size, mtime, selected)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(parent_ino, name) DO UPDATE SET
-			inode = excluded.inode,
-			type  = excluded.type,
-			size  = excluded.size,
-			mtime = excluded.mtime
-	`, e.Inode, e.ParentIno, e.Name, e.Type, e.Size, e.Mtime, e.Selected)
+	_, err := s.exec(upsertEntrySQL, upsertEntryArgs(&e, nowNano())...)
 	if err != nil {
 		l.Error("UpsertEntry failed", "inode", e.Inode, "name", e.Name, "err", err)
 		return fmt.Errorf("upsert entry: %w", err)
 	}
+	s.dirSizes.invalidate(e.ParentIno)
+	return nil
+}
+
+// upsertEntrySQL revives a tombstone at the same path, keeping its user
+// flags but taking the new selection. A tombstone holding the inode
+// elsewhere is dropped by the entries_reuse_tombstone trigger.
+const upsertEntrySQL = `
+	INSERT INTO entries (inode, parent_ino, name, type, size, mtime, selected, excluded, starred, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(parent_ino, name) DO UPDATE SET
+		inode      = excluded.inode,
+		type       = excluded.type,
+		size       = excluded.size,
+		mtime      = excluded.mtime,
+		selected   = CASE WHEN entries.deleted_at != 0 THEN excluded.selected ELSE entries.selected END,
+		updated_at = excluded.updated_at,
+		deleted_at = 0
+`
+
+// upsertEntryArgs binds e to upsertEntrySQL, with now as its creation
+// and update time.
+func upsertEntryArgs(e *Entry, now int64) []any {
+	return []any{e.Inode, e.ParentIno, e.Name, e.Type, e.Size, e.Mtime, e.Selected, e.Excluded, e.Starred, now, now}
+}
+
+// upsertBatchSize is the number of rows written per transaction by the
+// batch upserts, bounding how long a single write holds the DB lock.
+const upsertBatchSize = 1000
+
+// upsertRowsPerStmt is the number of rows bound into one multi-row INSERT,
+// well below SQLite's host parameter limit.
+const upsertRowsPerStmt = 100
+
+// UpsertEntriesBatch upserts entries like UpsertEntry, using multi-row
+// prepared statements inside one transaction per upsertBatchSize chunk.
+// Parents must precede their children. Chunks committed before an error
+// are kept.
+func (s *Store) UpsertEntriesBatch(entries []Entry) error {
+	sub("store").Debug("UpsertEntriesBatch", "count", len(entries))
+	defer s.dirSizes.clear()
+	now := nowNano()
+	for start := 0; start < len(entries); start += upsertBatchSize {
+		chunk := entries[start:min(start+upsertBatchSize, len(entries))]
+		err := s.execBatch(upsertEntrySQL, len(chunk), func(i int) []any {
+			return upsertEntryArgs(&chunk[i], now)
+		})
+		if err != nil {
+			return fmt.Errorf("upsert entries: %w", err)
+		}
+	}
 	return nil
 }
 
+// execBatch runs the single-row upsert query for n rows in one
+// transaction, with args(i) supplying the arguments of row i. Rows are
+// bound upsertRowsPerStmt at a time by repeating the VALUES tuple; rows
+// within a statement apply in order, as separate Execs would.
+func (s *Store) execBatch(query string, n int, args func(i int) []any) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmts := make(map[int]*sql.Stmt)
+	defer func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+	}()
+	for start := 0; start < n; start += upsertRowsPerStmt {
+		rows := min(upsertRowsPerStmt, n-start)
+		stmt, ok := stmts[rows]
+		if !ok {
+			if stmt, err = tx.Prepare(multiRowValues(query, rows)); err != nil {
+				return fmt.Errorf("prepare: %w", err)
+			}
+			stmts[rows] = stmt
+		}
+		var bound []any
+		for i := start; i < start+rows; i++ {
+			bound = append(bound, args(i)...)
+		}
+		if _, err := stmt.Exec(bound...); err != nil {
+			return fmt.Errorf("rows %d-%d: %w", start, start+rows-1, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// execEach runs query once per row in one transaction, for statements
+// execBatch can't fold into a multi-row VALUES list.
+func (s *Store) execEach(query string, n int, args func(i int) []any) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("prepare: %w", err)
+	}
+	defer stmt.Close()
+	for i := 0; i < n; i++ {
+		if _, err := stmt.Exec(args(i)...); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// multiRowValues repeats the VALUES tuple of a single-row INSERT rows times.
+func multiRowValues(query string, rows int) string {
+	const marker = "VALUES "
+	i := strings.Index(query, marker) + len(marker)
+	j := i + strings.Index(query[i:], ")") + 1
+	tuple := query[i:j]
+	return query[:i] + strings.Repeat(tuple+", ", rows-1) + tuple + query[j:]
+}
+
 // UpdateEntryName updates only the name of an existing entry.
 func (s *Store) UpdateEntryName(inode uint64, newName string) error {
 	sub("store").Debug("UpdateEntryName", "inode", inode, "newName", newName)
-	_, err := s.db.Exec(`UPDATE entries SET name = ? WHERE inode = ?`, newName, inode)
+	_, err := s.exec(`UPDATE entries SET name = ?, updated_at = ? WHERE inode = ?`, newName, nowNano(), inode)
 	if err != nil {
 		return fmt.Errorf("update entry name: %w", err)
 	}
@@ -50,22 +251,23 @@ func (s *Store) UpdateEntryName(inode uint64, newName string) error {
 // UpdateEntryMtime updates only the mtime and size of an existing entry.
 func (s *Store) UpdateEntryMtime(inode uint64, mtime int64, size *int64) error {
 	sub("store").Debug("UpdateEntryMtime", "inode", inode, "mtime", mtime, "size", size)
-	_, err := s.db.Exec(`
-		UPDATE entries SET mtime = ?, size = ? WHERE inode = ?
-	`, mtime, size, inode)
+	_, err := s.exec(`
+		UPDATE entries SET mtime = ?, size = ?, updated_at = ? WHERE inode = ?
+	`, mtime, size, nowNano(), inode)
 	if err != nil {
 		return fmt.Errorf("update entry mtime: %w", err)
 	}
+	s.invalidateDirSizeOf(inode, false)
 	return nil
 }
 
 // GetEntry retrieves an entry by inode.
 func (s *Store) GetEntry(inode uint64) (*Entry, error) {
 	e := &Entry{}
-	err := s.db.QueryRow(`
-		SELECT inode, parent_ino, name, type, size, mtime, selected
-		FROM entries WHERE inode = ?
-	`, inode).Scan(&e.Inode, &e.ParentIno, &e.Name, &e.Type, &e.Size, &e.Mtime, &e.Selected)
+	err := scanEntry(s.rdb.QueryRowContext(s.context(), `
+		SELECT `+entryColumns+`
+		FROM entries WHERE inode = ? AND deleted_at = 0
+	`, inode), e)
 	if err == sql.ErrNoRows {
 		if logEnabled(slog.LevelDebug) {
 			sub("store").Debug("GetEntry", "inode", inode, "found", false)
@@ -85,10 +287,10 @@ func (s *Store) GetEntry(inode uint64) (*Entry, error) {
 // Use parentIno=0 for root-level entries.
 func (s *Store) GetEntryByPath(parentIno uint64, name string) (*Entry, error) {
 	e := &Entry{}
-	err := s.db.QueryRow(`
-		SELECT inode, parent_ino, name, type, size, mtime, selected
-		FROM entries WHERE parent_ino = ? AND name = ?
-	`, parentIno, name).Scan(&e.Inode, &e.ParentIno, &e.Name, &e.Type, &e.Size, &e.Mtime, &e.Selected)
+	err := scanEntry(s.rdb.QueryRowContext(s.context(), `
+		SELECT `+entryColumns+`
+		FROM entries WHERE parent_ino = ? AND name = ? AND deleted_at = 0
+	`, parentIno, name), e)
 	if err == sql.ErrNoRows {
 		if logEnabled(slog.LevelDebug) {
 			sub("store").Debug("GetEntryByPath", "parentIno", parentIno, "name", name, "found", false)
@@ -104,22 +306,86 @@ func (s *Store) GetEntryByPath(parentIno uint64, name string) (*Entry, error) {
 	return e, nil
 }
 
-// DeleteEntry removes an entry by inode.
+// RelPath builds the relative path of entry by walking up its parent chain.
+func (s *Store) RelPath(entry *Entry) string {
+	if entry == nil {
+		return ""
+	}
+
+	// Walk up parent chain
+	var parts []string
+	current := entry
+	for current != nil {
+		parts = append([]string{current.Name}, parts...)
+		if current.ParentIno == 0 {
+			break
+		}
+		parent, err := s.GetEntry(current.ParentIno)
+		if err != nil || parent == nil {
+			break
+		}
+		current = parent
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// DeleteEntry removes an entry by inode, tombstone or not. Lost entries
+// are tombstoned with TxStore.TombstoneEntry instead.
 func (s *Store) DeleteEntry(inode uint64) error {
 	sub("store").Debug("DeleteEntry", "inode", inode)
-	_, err := s.db.Exec("DELETE FROM entries WHERE inode = ?", inode)
+	s.invalidateDirSizeOf(inode, true)
+	_, err := s.exec("DELETE FROM entries WHERE inode = ?", inode)
 	if err != nil {
 		return fmt.Errorf("delete entry: %w", err)
 	}
 	return nil
 }
 
+// ListTombstones returns up to limit tombstoned entries, most recently
+// deleted first.
+func (s *Store) ListTombstones(limit int) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT "+entryColumns+" FROM entries WHERE deleted_at != 0 ORDER BY deleted_at DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("list tombstones: %w", err)
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan tombstone: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PurgeTombstones drops the tombstones deleted before the cutoff, with
+// their tags and other dependent rows, and returns how many were dropped.
+func (s *Store) PurgeTombstones(before int64) (int64, error) {
+	res, err := s.exec("DELETE FROM entries WHERE deleted_at != 0 AND deleted_at < ?", before)
+	if err != nil {
+		return 0, fmt.Errorf("purge tombstones: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// MoveEntry reparents and/or renames an entry, refreshing its spaces_view
+// check time, in one transaction. The inode (and so the spaces_view row
+// and any descendants) is unchanged.
+func (s *Store) MoveEntry(inode, newParentIno uint64, newName string) error {
+	return s.WithTx(func(tx *TxStore) error {
+		return tx.MoveEntry(inode, newParentIno, newName)
+	})
+}
+
 // ListChildren returns all direct children of the given parent inode.
 // Use parentIno=0 for root-level entries.
 func (s *Store) ListChildren(parentIno uint64) ([]Entry, error) {
-	rows, err := s.db.Query(`
-		SELECT inode, parent_ino, name, type, size, mtime, selected
-		FROM entries WHERE parent_ino = ?
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT `+entryColumns+`
+		FROM entries WHERE parent_ino = ? AND deleted_at = 0
 		ORDER BY type = 'dir' DESC, name ASC
 	`, parentIno)
 	if err != nil {
@@ -130,7 +396,7 @@ func (s *Store) ListChildren(parentIno uint64) ([]Entry, error) {
 	var entries []Entry
 	for rows.Next() {
 		var e Entry
-		if err := rows.Scan(&e.Inode, &e.ParentIno, &e.Name, &e.Type, &e.Size, &e.Mtime, &e.Selected); err != nil {
+		if err := scanEntry(rows, &e); err != nil {
 			return nil, fmt.Errorf("scan entry: %w", err)
 		}
 		entries = append(entries, e)
@@ -141,98 +407,393 @@ func (s *Store) ListChildren(parentIno uint64) ([]Entry, error) {
 	return entries, rows.Err()
 }
 
+// ListChildrenAfter returns up to limit live children of parentIno whose
+// names sort after after, by name: one page of a directory too large to
+// list at once.
+func (s *Store) ListChildrenAfter(parentIno uint64, after string, limit int) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT `+entryColumns+`
+		FROM entries WHERE parent_ino = ? AND name > ? AND deleted_at = 0
+		ORDER BY name ASC LIMIT ?
+	`, parentIno, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list children: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
 // SetSelected updates the selected flag for the given inodes.
 // If recursive is true, all descendants of directory entries are also updated.
 func (s *Store) SetSelected(inodes []uint64, selected bool) error {
+	return s.SetSelectedExcept(inodes, selected, nil)
+}
+
+// SetSelectedExcept is SetSelected that leaves the excluded inodes and their
+// subtrees untouched, e.g. select "Projects/" but keep "Projects/tmp" as is.
+// Entries with the persistent excluded flag are always skipped when selecting.
+func (s *Store) SetSelectedExcept(inodes []uint64, selected bool, exclude []uint64) error {
+	_, err := s.setSelected(inodes, selected, exclude, nil, false)
+	return err
+}
+
+// SetSelectedUndoable is SetSelectedExcept for a user operation: the
+// entries it flips are recorded in the undo log, which keeps the last
+// selectionUndoDepth operations. It returns the operation's ID, or 0 if
+// nothing changed.
+func (s *Store) SetSelectedUndoable(inodes []uint64, selected bool, exclude []uint64) (int64, error) {
+	return s.setSelected(inodes, selected, exclude, nil, true)
+}
+
+// setSelected backs the SetSelected family. It also replaces the selection
+// filters of the directories among inodes and under them with filter,
+// which only narrows a selection.
+func (s *Store) setSelected(inodes []uint64, selected bool, exclude []uint64, filter *SelectFilter, logged bool) (int64, error) {
 	l := sub("store")
-	l.Debug("SetSelected", "inodes", inodes, "selected", selected)
+	l.Debug("SetSelected", "inodes", inodes, "selected", selected, "exclude", exclude, "filter", filter)
+	if !selected {
+		filter = nil
+	}
+
+	skip := make(map[uint64]bool, len(exclude))
+	for _, ino := range exclude {
+		skip[ino] = true
+	}
+	skipJSON := "[]"
+	if len(exclude) > 0 {
+		b, _ := json.Marshal(exclude) // a []uint64 always encodes
+		skipJSON = string(b)
+	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.begin()
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return 0, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback() //nolint:errcheck
 
+	var changed []uint64
 	for _, ino := range inodes {
-		if _, err := tx.Exec("UPDATE entries SET selected = ? WHERE inode = ?", selected, ino); err != nil {
-			return fmt.Errorf("update selected: %w", err)
+		if skip[ino] {
+			continue
+		}
+		var cur, excluded bool
+		var entryType string
+		err := tx.QueryRow("SELECT selected, excluded, type FROM entries WHERE inode = ?", ino).Scan(&cur, &excluded, &entryType)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, fmt.Errorf("check excluded: %w", err)
+		}
+		if selected && excluded {
+			l.Info("SetSelected skipping excluded entry", "inode", ino)
+			continue
+		}
+		if _, err := tx.Exec("UPDATE entries SET selected = ?, updated_at = ? WHERE inode = ?", selected, nowNano(), ino); err != nil {
+			return 0, fmt.Errorf("update selected: %w", err)
+		}
+		if err == nil && cur != selected {
+			changed = append(changed, ino)
+		}
+		if entryType != "dir" {
+			continue
 		}
 		// Recursively update children
-		if err := setSelectedRecursive(tx, ino, selected); err != nil {
-			return err
+		if err := setSelectedRecursive(tx, ino, selected, skipJSON, filter, &changed); err != nil {
+			return 0, err
+		}
+		if err := setSelectionFilter(tx, ino, filter, skipJSON); err != nil {
+			return 0, err
 		}
 	}
 
+	if selected {
+		if err := cancelRemovals(tx, changed); err != nil {
+			return 0, err
+		}
+	} else if err := forgetLargeCopies(tx, changed); err != nil {
+		return 0, err
+	}
+
+	var opID int64
+	if logged && len(changed) > 0 {
+		if opID, err = logSelectionOp(tx, selected, changed); err != nil {
+			return 0, err
+		}
+	}
 	if err := tx.Commit(); err != nil {
-		return err
+		return 0, err
+	}
+	l.Debug("SetSelected committed", "inodeCount", len(inodes), "changed", len(changed))
+	return opID, nil
+}
+
+// setSelectedRecursive sets the selected flag on the descendants of
+// parentIno in one statement however large the subtree, appending the
+// ones whose flag flipped to changed if it isn't nil. It doesn't go into
+// the inodes in skip, a JSON array, nor, when selecting, into excluded
+// entries, and only selects the files filter matches if it isn't nil.
+// Like walkTree, it goes Config.TreeMaxDepth levels below parentIno.
+// Rows already holding the flag are left as they are.
+func setSelectedRecursive(tx *sql.Tx, parentIno uint64, selected bool, skip string, filter *SelectFilter, changed *[]uint64) error {
+	cond, condArgs := filter.sql()
+	args := append([]any{parentIno, skip, selected, activeConfig.Load().TreeMaxDepth, skip, selected, selected, nowNano(), selected}, condArgs...)
+	rows, err := tx.Query(`
+		WITH RECURSIVE sub(inode, type, depth) AS (
+			SELECT inode, type, 1 FROM entries
+			WHERE parent_ino = ? AND deleted_at = 0
+			  AND inode NOT IN (SELECT value FROM json_each(?)) AND NOT (? AND excluded)
+			UNION ALL
+			SELECT e.inode, e.type, sub.depth + 1 FROM entries e JOIN sub ON sub.type = 'dir' AND e.parent_ino = sub.inode AND sub.depth < ?
+			WHERE e.deleted_at = 0
+			  AND e.inode NOT IN (SELECT value FROM json_each(?)) AND NOT (? AND e.excluded)
+		)
+		UPDATE entries SET selected = ?, updated_at = ?
+		WHERE inode IN (SELECT inode FROM sub) AND selected != ?`+cond+`
+		RETURNING inode
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("update subtree selected: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var ino uint64
+		if err := rows.Scan(&ino); err != nil {
+			return fmt.Errorf("scan updated child: %w", err)
+		}
+		n++
+		if changed != nil {
+			*changed = append(*changed, ino)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("update subtree selected: %w", err)
+	}
+	if logEnabled(slog.LevelDebug) {
+		sub("store").Debug("SetSelected recursive", "parentIno", parentIno, "changed", n)
 	}
-	l.Debug("SetSelected committed", "inodeCount", len(inodes))
 	return nil
 }
 
-func setSelectedRecursive(tx *sql.Tx, parentIno uint64, selected bool) error {
-	rows, err := tx.Query("SELECT inode, type FROM entries WHERE parent_ino = ?", parentIno)
+// selectionUndoDepth is how many selection operations the undo log keeps.
+const selectionUndoDepth = 20
+
+// logSelectionOp records a selection operation and the entries it
+// changed, dropping the oldest operations beyond selectionUndoDepth.
+func logSelectionOp(tx *sql.Tx, selected bool, changed []uint64) (int64, error) {
+	res, err := tx.Exec("INSERT INTO selection_ops (time, selected) VALUES (?, ?)", nowNano(), selected)
+	if err != nil {
+		return 0, fmt.Errorf("log selection op: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO selection_op_entries (op_id, entry_ino) VALUES (?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("prepare selection op entries: %w", err)
+	}
+	defer stmt.Close()
+	for _, ino := range changed {
+		if _, err := stmt.Exec(id, ino); err != nil {
+			return 0, fmt.Errorf("log selection op entry: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM selection_ops WHERE id NOT IN (SELECT id FROM selection_ops ORDER BY id DESC LIMIT ?)
+	`, selectionUndoDepth); err != nil {
+		return 0, fmt.Errorf("prune selection ops: %w", err)
+	}
+	return id, nil
+}
+
+// UndoSelection reverts the most recent operation in the undo log and
+// removes it from the log: the entries it changed get their previous
+// flag back, except that excluded entries are not re-selected. It returns
+// the operation, with the Spaces copies it trashed, or nil if the log is
+// empty.
+func (s *Store) UndoSelection() (*SelectionOp, error) {
+	tx, err := s.begin()
 	if err != nil {
-		return fmt.Errorf("query children: %w", err)
+		return nil, fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback() //nolint:errcheck
 
-	var children []struct {
-		inode uint64
-		typ   string
+	var op SelectionOp
+	err = tx.QueryRow("SELECT id, time, selected FROM selection_ops ORDER BY id DESC LIMIT 1").Scan(&op.ID, &op.Time, &op.Selected)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("last selection op: %w", err)
+	}
+	rows, err := tx.Query("SELECT entry_ino, trash_path FROM selection_op_entries WHERE op_id = ?", op.ID)
+	if err != nil {
+		return nil, fmt.Errorf("selection op entries: %w", err)
 	}
 	for rows.Next() {
-		var c struct {
-			inode uint64
-			typ   string
-		}
-		if err := rows.Scan(&c.inode, &c.typ); err != nil {
+		var c SelectionChange
+		if err := rows.Scan(&c.Inode, &c.TrashPath); err != nil {
 			rows.Close()
-			return fmt.Errorf("scan child: %w", err)
+			return nil, fmt.Errorf("scan selection op entry: %w", err)
 		}
-		children = append(children, c)
+		op.Changes = append(op.Changes, c)
 	}
 	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	if logEnabled(slog.LevelDebug) {
-		sub("store").Debug("SetSelected recursive", "parentIno", parentIno, "childCount", len(children))
+	for _, c := range op.Changes {
+		if _, err := tx.Exec(`
+			UPDATE entries SET selected = ?, updated_at = ? WHERE inode = ? AND (? OR excluded = 0)
+		`, !op.Selected, nowNano(), c.Inode, op.Selected); err != nil {
+			return nil, fmt.Errorf("undo selection: %w", err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM selection_ops WHERE id = ?", op.ID); err != nil {
+		return nil, fmt.Errorf("delete selection op: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// SetExcluded sets or clears the persistent exclude flag on the given inodes.
+// Excluding also deselects the entry and its whole subtree so P3 removes
+// any Spaces copies; clearing leaves selection untouched.
+func (s *Store) SetExcluded(inodes []uint64, excluded bool) error {
+	l := sub("store")
+	l.Debug("SetExcluded", "inodes", inodes, "excluded", excluded)
+
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback() //nolint:errcheck
 
-	for _, c := range children {
-		if _, err := tx.Exec("UPDATE entries SET selected = ? WHERE inode = ?", selected, c.inode); err != nil {
-			return fmt.Errorf("update child selected: %w", err)
+	for _, ino := range inodes {
+		if _, err := tx.Exec("UPDATE entries SET excluded = ?, updated_at = ? WHERE inode = ?", excluded, nowNano(), ino); err != nil {
+			return fmt.Errorf("update excluded: %w", err)
 		}
-		if c.typ == "dir" {
-			if err := setSelectedRecursive(tx, c.inode, selected); err != nil {
-				return err
-			}
+		if !excluded {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE entries SET selected = 0 WHERE inode = ?", ino); err != nil {
+			return fmt.Errorf("deselect excluded: %w", err)
 		}
+		if err := setSelectedRecursive(tx, ino, false, "[]", nil, nil); err != nil {
+			return err
+		}
+		if err := setSelectionFilter(tx, ino, nil, "[]"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
 	}
+	l.Debug("SetExcluded committed", "inodeCount", len(inodes))
 	return nil
 }
 
+// SetStarred sets or clears the starred flag on the given inodes.
+// Unlike selection it is never propagated to descendants.
+func (s *Store) SetStarred(inodes []uint64, starred bool) error {
+	l := sub("store")
+	l.Debug("SetStarred", "inodes", inodes, "starred", starred)
+
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, ino := range inodes {
+		if _, err := tx.Exec("UPDATE entries SET starred = ?, updated_at = ? WHERE inode = ?", starred, nowNano(), ino); err != nil {
+			return fmt.Errorf("update starred: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListStarred returns all starred entries across the tree, directories first.
+func (s *Store) ListStarred() ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT `+entryColumns+`
+		FROM entries WHERE starred = 1 AND deleted_at = 0
+		ORDER BY type = 'dir' DESC, name ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list starred: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
 // UpsertSpacesView inserts or updates a spaces_view record.
 func (s *Store) UpsertSpacesView(sv SpacesView) error {
 	sub("store").Debug("UpsertSpacesView", "entryIno", sv.EntryIno, "syncedMtime", sv.SyncedMtime)
-	_, err := s.db.Exec(`
-		INSERT INTO spaces_view (entry_ino, synced_mtime, checked_at)
-		VALUES (?, ?, ?)
-		ON CONFLICT(entry_ino) DO UPDATE SET
-			synced_mtime = excluded.synced_mtime,
-			checked_at   = excluded.checked_at
-	`, sv.EntryIno, sv.SyncedMtime, sv.CheckedAt)
+	_, err := s.exec(upsertSpacesViewSQL, sv.EntryIno, sv.SyncedMtime, sv.CheckedAt, sv.Cipher, sv.Compression)
 	if err != nil {
 		return fmt.Errorf("upsert spaces view: %w", err)
 	}
+	s.invalidateDirSizeOf(sv.EntryIno, false)
+	return nil
+}
+
+const upsertSpacesViewSQL = `
+	INSERT INTO spaces_view (entry_ino, synced_mtime, checked_at, cipher, compression)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(entry_ino) DO UPDATE SET
+		synced_mtime = excluded.synced_mtime,
+		checked_at   = excluded.checked_at,
+		cipher       = excluded.cipher,
+		compression  = excluded.compression
+`
+
+// UpsertSpacesViewsBatch upserts spaces_view rows in chunked transactions,
+// like UpsertEntriesBatch.
+func (s *Store) UpsertSpacesViewsBatch(views []SpacesView) error {
+	sub("store").Debug("UpsertSpacesViewsBatch", "count", len(views))
+	defer s.dirSizes.clear()
+	for start := 0; start < len(views); start += upsertBatchSize {
+		chunk := views[start:min(start+upsertBatchSize, len(views))]
+		err := s.execBatch(upsertSpacesViewSQL, len(chunk), func(i int) []any {
+			return []any{chunk[i].EntryIno, chunk[i].SyncedMtime, chunk[i].CheckedAt, chunk[i].Cipher, chunk[i].Compression}
+		})
+		if err != nil {
+			return fmt.Errorf("upsert spaces views: %w", err)
+		}
+	}
 	return nil
 }
 
 // GetSpacesView retrieves the spaces_view for a given entry inode.
 func (s *Store) GetSpacesView(entryIno uint64) (*SpacesView, error) {
 	sv := &SpacesView{}
-	err := s.db.QueryRow(`
-		SELECT entry_ino, synced_mtime, checked_at
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT entry_ino, synced_mtime, checked_at, cipher, compression
 		FROM spaces_view WHERE entry_ino = ?
-	`, entryIno).Scan(&sv.EntryIno, &sv.SyncedMtime, &sv.CheckedAt)
+	`, entryIno).Scan(&sv.EntryIno, &sv.SyncedMtime, &sv.CheckedAt, &sv.Cipher, &sv.Compression)
 	if err == sql.ErrNoRows {
 		if logEnabled(slog.LevelDebug) {
 			sub("store").Debug("GetSpacesView", "entryIno", entryIno, "found", false)
@@ -251,18 +812,19 @@ func (s *Store) GetSpacesView(entryIno uint64) (*SpacesView, error) {
 // DeleteSpacesView removes the spaces_view for a given entry inode.
 func (s *Store) DeleteSpacesView(entryIno uint64) error {
 	sub("store").Debug("DeleteSpacesView", "entryIno", entryIno)
-	_, err := s.db.Exec("DELETE FROM spaces_view WHERE entry_ino = ?", entryIno)
+	_, err := s.exec("DELETE FROM spaces_view WHERE entry_ino = ?", entryIno)
 	if err != nil {
 		return fmt.Errorf("delete spaces view: %w", err)
 	}
+	s.invalidateDirSizeOf(entryIno, false)
 	return nil
 }
 
 // AggregateSelectedSize returns the total size of all selected file entries.
 func (s *Store) AggregateSelectedSize() (int64, error) {
 	var total sql.NullInt64
-	err := s.db.QueryRow(`
-		SELECT SUM(size) FROM entries WHERE selected = 1 AND type != 'dir'
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT SUM(size) FROM entries WHERE selected = 1 AND type != 'dir' AND deleted_at = 0
 	`).Scan(&total)
 	if err != nil {
 		return 0, fmt.Errorf("aggregate selected size: %w", err)
@@ -278,8 +840,8 @@ func (s *Store) AggregateSelectedSize() (int64, error) {
 // AggregateTotalSize returns the total size of all file entries (excluding directories).
 func (s *Store) AggregateTotalSize() (int64, error) {
 	var total sql.NullInt64
-	err := s.db.QueryRow(`
-		SELECT SUM(size) FROM entries WHERE type != 'dir'
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT SUM(size) FROM entries WHERE type != 'dir' AND deleted_at = 0
 	`).Scan(&total)
 	if err != nil {
 		return 0, fmt.Errorf("aggregate total size: %w", err)
@@ -290,18 +852,1042 @@ func (s *Store) AggregateTotalSize() (int64, error) {
 	return total.Int64, nil
 }
 
-// ChildCounts returns the total count and selected count of children
-// for the given parent inode.
-func (s *Store) ChildCounts(parentIno uint64) (total int, selectedCount int, err error) {
-	err = s.db.QueryRow(`
-		SELECT COUNT(*), COALESCE(SUM(selected), 0)
-		FROM entries WHERE parent_ino = ?
-	`, parentIno).Scan(&total, &selectedCount)
+// PendingSyncSize returns the total size of selected files that have no
+// Spaces copy recorded yet, i.e. bytes still to be copied A→S.
+func (s *Store) PendingSyncSize() (int64, error) {
+	var total int64
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT COALESCE(SUM(e.size), 0)
+		FROM entries e LEFT JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE e.selected = 1 AND e.type != 'dir' AND sv.entry_ino IS NULL AND e.deleted_at = 0
+	`).Scan(&total)
 	if err != nil {
-		return 0, 0, fmt.Errorf("child counts: %w", err)
+		return 0, fmt.Errorf("pending sync size: %w", err)
 	}
-	if logEnabled(slog.LevelDebug) {
-		sub("store").Debug("ChildCounts", "parentIno", parentIno, "total", total, "selected", selectedCount)
+	sub("store").Debug("PendingSyncSize", "total", total)
+	return total, nil
+}
+
+// RegisterConflict records a conflict in one transaction: the original
+// entry is renamed to c.ConflictName under its existing inode, and, if
+// winner is set, the replacement file is registered at the original name
+// and takes over the Spaces record (winnerSV). Returns the conflict ID.
+func (s *Store) RegisterConflict(c Conflict, winner *Entry, winnerSV *SpacesView) (id int64, err error) {
+	err = s.WithTx(func(tx *TxStore) error {
+		id, err = tx.RegisterConflict(c, winner, winnerSV)
+		return err
+	})
+	return id, err
+}
+
+// ListConflicts returns recorded conflicts, newest first. With
+// unresolvedOnly, resolved conflicts are skipped.
+func (s *Store) ListConflicts(unresolvedOnly bool) ([]Conflict, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT id, time, path, conflict_ino, conflict_name, winner_ino, resolution, resolved_at
+		FROM conflicts WHERE (? = 0 OR resolution = '')
+		ORDER BY id DESC
+	`, unresolvedOnly)
+	if err != nil {
+		return nil, fmt.Errorf("list conflicts: %w", err)
 	}
-	return total, selectedCount, nil
+	defer rows.Close()
+
+	conflicts := make([]Conflict, 0)
+	for rows.Next() {
+		var c Conflict
+		if err := rows.Scan(&c.ID, &c.Time, &c.Path, &c.ConflictIno, &c.ConflictName, &c.WinnerIno, &c.Resolution, &c.ResolvedAt); err != nil {
+			return nil, fmt.Errorf("scan conflict: %w", err)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
+
+// ResolveConflict records how a conflict was resolved. Returns false if
+// no such conflict exists.
+func (s *Store) ResolveConflict(id int64, resolution string) (bool, error) {
+	res, err := s.exec("UPDATE conflicts SET resolution = ?, resolved_at = ? WHERE id = ?", resolution, nowNano(), id)
+	if err != nil {
+		return false, fmt.Errorf("resolve conflict: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// BeginIntent journals a pipeline step before its disk action and
+// returns the intent ID.
+func (s *Store) BeginIntent(in Intent) (int64, error) {
+	if in.Time == 0 {
+		in.Time = nowNano()
+	}
+	sub("store").Debug("BeginIntent", "op", in.Op, "path", in.Path, "inode", in.Inode)
+	res, err := s.exec(`
+		INSERT INTO journal (time, op, path, inode, target) VALUES (?, ?, ?, ?, ?)
+	`, in.Time, in.Op, in.Path, in.Inode, in.Target)
+	if err != nil {
+		return 0, fmt.Errorf("begin intent: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// MarkIntentDone records that the intent's disk action completed.
+func (s *Store) MarkIntentDone(id int64) error {
+	if _, err := s.exec("UPDATE journal SET done = 1 WHERE id = ?", id); err != nil {
+		return fmt.Errorf("mark intent done: %w", err)
+	}
+	return nil
+}
+
+// CompleteIntent removes a finished (or abandoned) intent.
+func (s *Store) CompleteIntent(id int64) error {
+	if _, err := s.exec("DELETE FROM journal WHERE id = ?", id); err != nil {
+		return fmt.Errorf("complete intent: %w", err)
+	}
+	return nil
+}
+
+// ListIntents returns all outstanding intents, oldest first.
+func (s *Store) ListIntents() ([]Intent, error) {
+	rows, err := s.rdb.QueryContext(s.context(), "SELECT id, time, op, path, inode, target, done FROM journal ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("list intents: %w", err)
+	}
+	defer rows.Close()
+
+	var intents []Intent
+	for rows.Next() {
+		var in Intent
+		if err := rows.Scan(&in.ID, &in.Time, &in.Op, &in.Path, &in.Inode, &in.Target, &in.Done); err != nil {
+			return nil, fmt.Errorf("scan intent: %w", err)
+		}
+		intents = append(intents, in)
+	}
+	return intents, rows.Err()
+}
+
+// AppendAudit records an action in the audit log. A zero Time is set to now.
+func (s *Store) AppendAudit(rec AuditRecord) error {
+	if rec.Time == 0 {
+		rec.Time = nowNano()
+	}
+	sub("store").Debug("AppendAudit", "action", rec.Action, "path", rec.Path)
+	_, err := s.exec(`
+		INSERT INTO audit_log (time, action, path, inode, detail) VALUES (?, ?, ?, ?, ?)
+	`, rec.Time, rec.Action, rec.Path, rec.Inode, rec.Detail)
+	if err != nil {
+		return fmt.Errorf("append audit: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns audit records newer than since (nanoseconds), newest
+// first, optionally filtered by action. limit <= 0 means no limit.
+func (s *Store) ListAudit(since int64, action string, limit int) ([]AuditRecord, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT id, time, action, path, inode, detail FROM audit_log
+		WHERE time > ? AND (? = '' OR action = ?)
+		ORDER BY id DESC LIMIT ?
+	`, since, action, action, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list audit: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AuditRecord
+	for rows.Next() {
+		var rec AuditRecord
+		if err := rows.Scan(&rec.ID, &rec.Time, &rec.Action, &rec.Path, &rec.Inode, &rec.Detail); err != nil {
+			return nil, fmt.Errorf("scan audit: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// AppendEvent records ev in the event log, dropping the events older than
+// keepSince (nanoseconds), and returns its sequence number.
+func (s *Store) AppendEvent(ev Event, keepSince int64) (int64, error) {
+	var data []byte
+	if len(ev.Data) > 0 {
+		var err error
+		if data, err = json.Marshal(ev.Data); err != nil {
+			return 0, fmt.Errorf("marshal event data: %w", err)
+		}
+	}
+	tx, err := s.begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`
+		INSERT INTO events (time, type, path, inode, data) VALUES (?, ?, ?, ?, ?)
+	`, ev.Time, ev.Type, ev.Path, ev.Inode, string(data))
+	if err != nil {
+		return 0, fmt.Errorf("append event: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM events WHERE time < ?", keepSince); err != nil {
+		return 0, fmt.Errorf("prune events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListEventsAfter returns up to limit logged events with a sequence
+// number above after, oldest first, and the first sequence number still
+// logged (the next one to be assigned if the log is empty): events below
+// it were pruned.
+func (s *Store) ListEventsAfter(after int64, limit int) ([]Event, int64, error) {
+	var oldest int64
+	if err := s.rdb.QueryRowContext(s.context(), `
+		SELECT COALESCE((SELECT MIN(id) FROM events), (SELECT seq + 1 FROM sqlite_sequence WHERE name = 'events'), 1)
+	`).Scan(&oldest); err != nil {
+		return nil, 0, fmt.Errorf("oldest event: %w", err)
+	}
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT id, time, type, path, inode, data FROM events WHERE id > ? ORDER BY id LIMIT ?
+	`, after, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list events: %w", err)
+	}
+	defer rows.Close()
+
+	var evs []Event
+	for rows.Next() {
+		var ev Event
+		var data string
+		if err := rows.Scan(&ev.ID, &ev.Time, &ev.Type, &ev.Path, &ev.Inode, &data); err != nil {
+			return nil, 0, fmt.Errorf("scan event: %w", err)
+		}
+		if data != "" {
+			if err := json.Unmarshal([]byte(data), &ev.Data); err != nil {
+				return nil, 0, fmt.Errorf("event %d data: %w", ev.ID, err)
+			}
+		}
+		evs = append(evs, ev)
+	}
+	return evs, oldest, rows.Err()
+}
+
+// UpsertMetadata inserts or replaces the metadata row of an entry.
+func (s *Store) UpsertMetadata(m Metadata) error {
+	sub("store").Debug("UpsertMetadata", "inode", m.EntryIno)
+	_, err := s.exec(`
+		INSERT INTO metadata (entry_ino, width, height, duration_ms, taken_at, extracted_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(entry_ino) DO UPDATE SET
+			width        = excluded.width,
+			height       = excluded.height,
+			duration_ms  = excluded.duration_ms,
+			taken_at     = excluded.taken_at,
+			extracted_at = excluded.extracted_at
+	`, m.EntryIno, m.Width, m.Height, m.DurationMs, m.TakenAt, m.ExtractedAt)
+	if err != nil {
+		return fmt.Errorf("upsert metadata: %w", err)
+	}
+	return nil
+}
+
+// GetMetadata returns the metadata of an entry, or nil if none was extracted.
+func (s *Store) GetMetadata(entryIno uint64) (*Metadata, error) {
+	m := &Metadata{EntryIno: entryIno}
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT width, height, duration_ms, taken_at, extracted_at FROM metadata WHERE entry_ino = ?
+	`, entryIno).Scan(&m.Width, &m.Height, &m.DurationMs, &m.TakenAt, &m.ExtractedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get metadata: %w", err)
+	}
+	return m, nil
+}
+
+// ListMetadataPending returns image/video/audio entries whose metadata is
+// missing or older than the entry's mtime, up to limit.
+func (s *Store) ListMetadataPending(limit int) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e LEFT JOIN metadata m ON m.entry_ino = e.inode
+		WHERE e.type IN ('image', 'video', 'audio') AND e.deleted_at = 0
+		  AND (m.entry_ino IS NULL OR m.extracted_at < e.mtime)
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list metadata pending: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Directory selection tri-state values returned by SelectionState.
+const (
+	SelectionAll     = "all"
+	SelectionPartial = "partial"
+	SelectionNone    = "none"
+)
+
+// SelectionState computes the tri-state of a directory from all of its
+// descendants: "all" if every descendant is selected, "none" if none are,
+// "partial" otherwise. An empty directory reflects its own flag.
+func (s *Store) SelectionState(dirIno uint64) (string, error) {
+	states, err := s.SelectionStates([]uint64{dirIno})
+	if err != nil {
+		return "", err
+	}
+	return states[dirIno], nil
+}
+
+// SelectionStates computes SelectionState for each of dirInos, walking
+// all of their subtrees in one query, as a listing needs for the
+// directories it shows.
+func (s *Store) SelectionStates(dirInos []uint64) (map[uint64]string, error) {
+	inos, err := json.Marshal(dirInos)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.rdb.QueryContext(s.context(), `
+		WITH RECURSIVE sub(root, inode) AS (
+			SELECT parent_ino, inode FROM entries
+			WHERE parent_ino IN (SELECT value FROM json_each(?)) AND deleted_at = 0
+			UNION ALL
+			SELECT sub.root, e.inode FROM entries e JOIN sub ON e.parent_ino = sub.inode WHERE e.deleted_at = 0
+		)
+		SELECT d.inode, d.selected, COUNT(e.inode), COALESCE(SUM(e.selected), 0)
+		FROM entries d
+		LEFT JOIN sub ON sub.root = d.inode
+		LEFT JOIN entries e ON e.inode = sub.inode
+		WHERE d.inode IN (SELECT value FROM json_each(?))
+		GROUP BY d.inode
+	`, string(inos), string(inos))
+	if err != nil {
+		return nil, fmt.Errorf("selection state: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[uint64]string, len(dirInos))
+	for _, ino := range dirInos {
+		states[ino] = SelectionNone
+	}
+	for rows.Next() {
+		var ino uint64
+		var sel bool
+		var total, selectedCount int
+		if err := rows.Scan(&ino, &sel, &total, &selectedCount); err != nil {
+			return nil, fmt.Errorf("selection state: %w", err)
+		}
+		var state string
+		switch {
+		case total == 0:
+			state = SelectionNone
+			if sel {
+				state = SelectionAll
+			}
+		case selectedCount == total:
+			state = SelectionAll
+		case selectedCount == 0:
+			state = SelectionNone
+		default:
+			state = SelectionPartial
+		}
+		states[ino] = state
+		if logEnabled(slog.LevelDebug) {
+			sub("store").Debug("SelectionState", "inode", ino, "total", total, "selected", selectedCount, "state", state)
+		}
+	}
+	return states, rows.Err()
+}
+
+// ChildCounts returns the total count and selected count of children
+// for the given parent inode.
+func (s *Store) ChildCounts(parentIno uint64) (total int, selectedCount int, err error) {
+	err = s.rdb.QueryRowContext(s.context(), `
+		SELECT COUNT(*), COALESCE(SUM(selected), 0)
+		FROM entries WHERE parent_ino = ? AND deleted_at = 0
+	`, parentIno).Scan(&total, &selectedCount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("child counts: %w", err)
+	}
+	if logEnabled(slog.LevelDebug) {
+		sub("store").Debug("ChildCounts", "parentIno", parentIno, "total", total, "selected", selectedCount)
+	}
+	return total, selectedCount, nil
+}
+
+// TagEntries attaches tags to the given inodes, creating tags as needed.
+// Tag names are matched case-insensitively; the first spelling is kept.
+func (s *Store) TagEntries(inodes []uint64, tags []string) error {
+	l := sub("store")
+	l.Debug("TagEntries", "inodes", inodes, "tags", tags)
+
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT(name) DO NOTHING", tag); err != nil {
+			return fmt.Errorf("insert tag: %w", err)
+		}
+		for _, ino := range inodes {
+			if _, err := tx.Exec(`
+				INSERT OR IGNORE INTO entry_tags (entry_ino, tag_id)
+				SELECT e.inode, t.id FROM entries e, tags t
+				WHERE e.inode = ? AND e.deleted_at = 0 AND t.name = ?
+			`, ino, tag); err != nil {
+				return fmt.Errorf("tag entry: %w", err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// UntagEntries removes tags from the given inodes. Tags left without
+// entries are deleted.
+func (s *Store) UntagEntries(inodes []uint64, tags []string) error {
+	l := sub("store")
+	l.Debug("UntagEntries", "inodes", inodes, "tags", tags)
+
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, tag := range tags {
+		for _, ino := range inodes {
+			if _, err := tx.Exec(`
+				DELETE FROM entry_tags
+				WHERE entry_ino = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+			`, ino, tag); err != nil {
+				return fmt.Errorf("untag entry: %w", err)
+			}
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM entry_tags)"); err != nil {
+		return fmt.Errorf("prune tags: %w", err)
+	}
+	return tx.Commit()
+}
+
+// EntryTags returns the tags on an entry, sorted by name.
+func (s *Store) EntryTags(inode uint64) ([]string, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT t.name FROM entry_tags et JOIN tags t ON t.id = et.tag_id
+		WHERE et.entry_ino = ?
+		ORDER BY t.name
+	`, inode)
+	if err != nil {
+		return nil, fmt.Errorf("entry tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// ListTags returns all tags in use with their entry counts, sorted by name.
+func (s *Store) ListTags() ([]Tag, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT t.name, COUNT(*) FROM tags t JOIN entry_tags et ON et.tag_id = t.id
+		GROUP BY t.id
+		ORDER BY t.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// UsageByType returns file bytes grouped by entry type, largest first.
+// Synced bytes count files with a spaces_view record.
+func (s *Store) UsageByType() ([]UsageBucket, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.type, COALESCE(SUM(e.size), 0),
+		       COALESCE(SUM(CASE WHEN sv.entry_ino IS NOT NULL THEN e.size END), 0),
+		       COUNT(*)
+		FROM entries e LEFT JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE e.type != 'dir' AND e.deleted_at = 0
+		GROUP BY e.type
+		ORDER BY 2 DESC, e.type
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("usage by type: %w", err)
+	}
+	return scanUsageBuckets(rows, false)
+}
+
+// UsageByTopDir returns file bytes grouped by top-level directory, largest
+// first. Files directly under the root are grouped under an empty key.
+func (s *Store) UsageByTopDir() ([]UsageBucket, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		WITH RECURSIVE tree(inode, top) AS (
+			SELECT inode, CASE WHEN type = 'dir' THEN inode ELSE 0 END
+			FROM entries WHERE parent_ino = 0 AND deleted_at = 0
+			UNION ALL
+			SELECT e.inode, t.top FROM entries e JOIN tree t ON e.parent_ino = t.inode WHERE e.deleted_at = 0
+		)
+		SELECT COALESCE(top.name, ''), t.top, COALESCE(SUM(e.size), 0),
+		       COALESCE(SUM(CASE WHEN sv.entry_ino IS NOT NULL THEN e.size END), 0),
+		       COUNT(*)
+		FROM tree t
+		JOIN entries e ON e.inode = t.inode
+		LEFT JOIN entries top ON top.inode = t.top
+		LEFT JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE e.type != 'dir'
+		GROUP BY t.top
+		ORDER BY 3 DESC, 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("usage by top dir: %w", err)
+	}
+	return scanUsageBuckets(rows, true)
+}
+
+func scanUsageBuckets(rows *sql.Rows, withInode bool) ([]UsageBucket, error) {
+	defer rows.Close()
+	buckets := make([]UsageBucket, 0)
+	for rows.Next() {
+		var b UsageBucket
+		dest := []any{&b.Key, &b.TotalBytes, &b.SyncedBytes, &b.Files}
+		if withInode {
+			dest = []any{&b.Key, &b.Inode, &b.TotalBytes, &b.SyncedBytes, &b.Files}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan usage: %w", err)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// LoadDirFingerprints returns the fingerprints recorded by the previous
+// seed, keyed by relative directory path ("" for the root).
+func (s *Store) LoadDirFingerprints() (map[string]DirFingerprint, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `SELECT path, hash, children FROM dir_fingerprints`)
+	if err != nil {
+		return nil, fmt.Errorf("load dir fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	fps := make(map[string]DirFingerprint)
+	for rows.Next() {
+		var path string
+		var fp DirFingerprint
+		if err := rows.Scan(&path, &fp.Hash, &fp.Children); err != nil {
+			return nil, fmt.Errorf("scan dir fingerprint: %w", err)
+		}
+		fps[path] = fp
+	}
+	return fps, rows.Err()
+}
+
+// ReplaceDirFingerprints replaces all recorded fingerprints with fps.
+func (s *Store) ReplaceDirFingerprints(fps map[string]DirFingerprint) error {
+	sub("store").Debug("ReplaceDirFingerprints", "count", len(fps))
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`DELETE FROM dir_fingerprints`); err != nil {
+		return fmt.Errorf("clear dir fingerprints: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO dir_fingerprints (path, hash, children) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare dir fingerprints: %w", err)
+	}
+	defer stmt.Close()
+	for path, fp := range fps {
+		if _, err := stmt.Exec(path, fp.Hash, fp.Children); err != nil {
+			return fmt.Errorf("insert dir fingerprint %s: %w", path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListUnconverged returns entries whose selection doesn't match Spaces in
+// the DB: selected without a spaces_view row, or deselected with one.
+// Their pipeline work is pending regardless of what changed on disk.
+func (s *Store) ListUnconverged() ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e LEFT JOIN spaces_view sv ON sv.entry_ino = e.inode
+		WHERE (e.selected = 1) != (sv.entry_ino IS NOT NULL) AND e.deleted_at = 0
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list unconverged: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ListSelectionRoots returns the top-most selected entries: selected
+// entries whose parent isn't selected.
+func (s *Store) ListSelectionRoots() ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e LEFT JOIN entries p ON p.inode = e.parent_ino AND p.deleted_at = 0
+		WHERE e.selected = 1 AND e.deleted_at = 0 AND (p.inode IS NULL OR p.selected = 0)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list selection roots: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// MarkPlaceholder records that the Spaces copy of an entry is a stub
+// waiting to be hydrated from Archives.
+func (s *Store) MarkPlaceholder(inode uint64) error {
+	if _, err := s.exec(`INSERT OR IGNORE INTO placeholders (entry_ino) VALUES (?)`, inode); err != nil {
+		return fmt.Errorf("mark placeholder: %w", err)
+	}
+	return nil
+}
+
+// ClearPlaceholder forgets a placeholder, e.g. once it is hydrated.
+func (s *Store) ClearPlaceholder(inode uint64) error {
+	if _, err := s.exec(`DELETE FROM placeholders WHERE entry_ino = ?`, inode); err != nil {
+		return fmt.Errorf("clear placeholder: %w", err)
+	}
+	return nil
+}
+
+// IsPlaceholder reports whether the Spaces copy of an entry is a stub.
+func (s *Store) IsPlaceholder(inode uint64) (bool, error) {
+	var n int
+	err := s.rdb.QueryRowContext(s.context(), `SELECT COUNT(*) FROM placeholders WHERE entry_ino = ?`, inode).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("is placeholder: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ListPlaceholders returns the entries whose Spaces copies are stubs.
+func (s *Store) ListPlaceholders() ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e JOIN placeholders p ON p.entry_ino = e.inode
+		WHERE e.deleted_at = 0
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list placeholders: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ListSpacesViews returns every spaces_view row keyed by entry inode.
+func (s *Store) ListSpacesViews() (map[uint64]SpacesView, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `SELECT entry_ino, synced_mtime, checked_at, cipher, compression FROM spaces_view`)
+	if err != nil {
+		return nil, fmt.Errorf("list spaces views: %w", err)
+	}
+	defer rows.Close()
+
+	views := make(map[uint64]SpacesView)
+	for rows.Next() {
+		var sv SpacesView
+		if err := rows.Scan(&sv.EntryIno, &sv.SyncedMtime, &sv.CheckedAt, &sv.Cipher, &sv.Compression); err != nil {
+			return nil, fmt.Errorf("scan spaces view: %w", err)
+		}
+		views[sv.EntryIno] = sv
+	}
+	return views, rows.Err()
+}
+
+// RegisterSpoke adds a spoke identified by the SHA-256 of its token.
+func (s *Store) RegisterSpoke(sp Spoke, tokenHash string) error {
+	_, err := s.exec(`
+		INSERT INTO spokes (id, name, token_hash, registered_at, last_seen)
+		VALUES (?, ?, ?, ?, ?)
+	`, sp.ID, sp.Name, tokenHash, sp.RegisteredAt, sp.LastSeen)
+	if err != nil {
+		return fmt.Errorf("register spoke: %w", err)
+	}
+	return nil
+}
+
+// SpokeByToken returns the spoke whose token hashes to tokenHash, or nil.
+func (s *Store) SpokeByToken(tokenHash string) (*Spoke, error) {
+	sp := &Spoke{}
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT id, name, registered_at, last_seen FROM spokes WHERE token_hash = ?
+	`, tokenHash).Scan(&sp.ID, &sp.Name, &sp.RegisteredAt, &sp.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("spoke by token: %w", err)
+	}
+	return sp, nil
+}
+
+// TouchSpoke records that the spoke was just heard from.
+func (s *Store) TouchSpoke(id string, now int64) error {
+	if _, err := s.exec(`UPDATE spokes SET last_seen = ? WHERE id = ?`, now, id); err != nil {
+		return fmt.Errorf("touch spoke: %w", err)
+	}
+	return nil
+}
+
+// ListSpokes returns every registered spoke, by name.
+func (s *Store) ListSpokes() ([]Spoke, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `SELECT id, name, registered_at, last_seen FROM spokes ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list spokes: %w", err)
+	}
+	defer rows.Close()
+
+	var spokes []Spoke
+	for rows.Next() {
+		var sp Spoke
+		if err := rows.Scan(&sp.ID, &sp.Name, &sp.RegisteredAt, &sp.LastSeen); err != nil {
+			return nil, fmt.Errorf("scan spoke: %w", err)
+		}
+		spokes = append(spokes, sp)
+	}
+	return spokes, rows.Err()
+}
+
+// DeleteSpoke removes a spoke with its selections and views. Returns
+// false if there was no such spoke.
+func (s *Store) DeleteSpoke(id string) (bool, error) {
+	res, err := s.exec(`DELETE FROM spokes WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete spoke: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetSpokeSelected adds inodes to, or removes them from, a spoke's
+// selection. Selecting a directory selects its whole subtree.
+func (s *Store) SetSpokeSelected(spokeID string, inodes []uint64, selected bool) error {
+	query := `DELETE FROM spoke_selections WHERE spoke_id = ? AND entry_ino = ?`
+	if selected {
+		query = `INSERT OR IGNORE INTO spoke_selections (spoke_id, entry_ino) VALUES (?, ?)`
+	}
+	return s.execEach(query, len(inodes), func(i int) []any {
+		return []any{spokeID, inodes[i]}
+	})
+}
+
+// ListSpokeSelections returns the entries a spoke selected directly.
+func (s *Store) ListSpokeSelections(spokeID string) ([]Entry, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT e.inode, e.parent_ino, e.name, e.type, e.size, e.mtime, e.selected, e.excluded, e.starred, e.created_at, e.updated_at, e.deleted_at
+		FROM entries e JOIN spoke_selections ss ON ss.entry_ino = e.inode
+		WHERE ss.spoke_id = ? AND e.deleted_at = 0
+	`, spokeID)
+	if err != nil {
+		return nil, fmt.Errorf("list spoke selections: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := scanEntry(rows, &e); err != nil {
+			return nil, fmt.Errorf("scan entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// UpsertSpokeViews records the copies a spoke reported holding. Views of
+// entries no longer in the catalog are dropped.
+func (s *Store) UpsertSpokeViews(views []SpokeView) error {
+	return s.execEach(`
+		INSERT INTO spoke_views (spoke_id, entry_ino, synced_mtime, checked_at)
+		SELECT ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM entries WHERE inode = ? AND deleted_at = 0)
+		ON CONFLICT(spoke_id, entry_ino) DO UPDATE SET
+			synced_mtime = excluded.synced_mtime,
+			checked_at = excluded.checked_at
+	`, len(views), func(i int) []any {
+		v := views[i]
+		return []any{v.SpokeID, v.EntryIno, v.SyncedMtime, v.CheckedAt, v.EntryIno}
+	})
+}
+
+// DeleteSpokeViews forgets the copies a spoke reported removing.
+func (s *Store) DeleteSpokeViews(spokeID string, inodes []uint64) error {
+	return s.execEach(`DELETE FROM spoke_views WHERE spoke_id = ? AND entry_ino = ?`, len(inodes), func(i int) []any {
+		return []any{spokeID, inodes[i]}
+	})
+}
+
+// ListSpokeViews returns a spoke's reported copies keyed by entry inode.
+func (s *Store) ListSpokeViews(spokeID string) (map[uint64]SpokeView, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT spoke_id, entry_ino, synced_mtime, checked_at FROM spoke_views WHERE spoke_id = ?
+	`, spokeID)
+	if err != nil {
+		return nil, fmt.Errorf("list spoke views: %w", err)
+	}
+	defer rows.Close()
+
+	views := make(map[uint64]SpokeView)
+	for rows.Next() {
+		var v SpokeView
+		if err := rows.Scan(&v.SpokeID, &v.EntryIno, &v.SyncedMtime, &v.CheckedAt); err != nil {
+			return nil, fmt.Errorf("scan spoke view: %w", err)
+		}
+		views[v.EntryIno] = v
+	}
+	return views, rows.Err()
+}
+
+// CreateBackupJob records a job together with the files it copies.
+func (s *Store) CreateBackupJob(job BackupJob, files []BackupFile) error {
+	var rules string
+	if len(job.Rules) > 0 {
+		b, err := json.Marshal(job.Rules)
+		if err != nil {
+			return err
+		}
+		rules = string(b)
+	}
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.Exec(`
+		INSERT INTO backup_jobs (id, target, target_dev, rules, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Target, job.TargetDev, rules, job.State, job.CreatedAt, job.UpdatedAt); err != nil {
+		return fmt.Errorf("create backup job: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO backup_files (job_id, path, size, mtime, state) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare: %w", err)
+	}
+	defer stmt.Close()
+	for _, f := range files {
+		if _, err := stmt.Exec(job.ID, f.Path, f.Size, f.Mtime, BackupPending); err != nil {
+			return fmt.Errorf("add backup file %s: %w", f.Path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+const backupJobColumns = `
+	j.id, j.target, j.target_dev, j.rules, j.state, j.error, j.created_at, j.updated_at,
+	COUNT(f.path), COALESCE(SUM(f.size), 0),
+	COUNT(CASE WHEN f.state = 'done' THEN 1 END), COALESCE(SUM(CASE WHEN f.state = 'done' THEN f.size END), 0),
+	COUNT(CASE WHEN f.state = 'failed' THEN 1 END)
+	FROM backup_jobs j LEFT JOIN backup_files f ON f.job_id = j.id`
+
+func scanBackupJob(row interface{ Scan(...any) error }, job *BackupJob) error {
+	var rules string
+	if err := row.Scan(&job.ID, &job.Target, &job.TargetDev, &rules, &job.State, &job.Error, &job.CreatedAt, &job.UpdatedAt,
+		&job.Files, &job.Bytes, &job.DoneFiles, &job.DoneBytes, &job.FailedFiles); err != nil {
+		return err
+	}
+	if rules != "" {
+		return json.Unmarshal([]byte(rules), &job.Rules)
+	}
+	return nil
+}
+
+// GetBackupJob returns a job with its counts, or nil.
+func (s *Store) GetBackupJob(id string) (*BackupJob, error) {
+	job := &BackupJob{}
+	err := scanBackupJob(s.rdb.QueryRowContext(s.context(), `SELECT `+backupJobColumns+` WHERE j.id = ? GROUP BY j.id`, id), job)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get backup job: %w", err)
+	}
+	return job, nil
+}
+
+// ListBackupJobs returns every job with its counts, oldest first.
+func (s *Store) ListBackupJobs() ([]BackupJob, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `SELECT `+backupJobColumns+` GROUP BY j.id ORDER BY j.created_at, j.id`)
+	if err != nil {
+		return nil, fmt.Errorf("list backup jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []BackupJob
+	for rows.Next() {
+		var job BackupJob
+		if err := scanBackupJob(rows, &job); err != nil {
+			return nil, fmt.Errorf("scan backup job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// SetBackupJobState moves a job to state, recording errMsg.
+func (s *Store) SetBackupJobState(id string, state BackupState, errMsg string, now int64) error {
+	if _, err := s.exec(`UPDATE backup_jobs SET state = ?, error = ?, updated_at = ? WHERE id = ?`, state, errMsg, now, id); err != nil {
+		return fmt.Errorf("set backup job state: %w", err)
+	}
+	return nil
+}
+
+// ListBackupFiles returns a job's files in state, by path; limit <= 0
+// means all of them.
+func (s *Store) ListBackupFiles(jobID string, state BackupState, limit int) ([]BackupFile, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT path, size, mtime, state, error FROM backup_files
+		WHERE job_id = ? AND state = ? ORDER BY path LIMIT ?
+	`, jobID, state, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list backup files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []BackupFile
+	for rows.Next() {
+		var f BackupFile
+		if err := rows.Scan(&f.Path, &f.Size, &f.Mtime, &f.State, &f.Error); err != nil {
+			return nil, fmt.Errorf("scan backup file: %w", err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// SetBackupFileState records the outcome of copying one file of a job.
+func (s *Store) SetBackupFileState(jobID, path string, state BackupState, errMsg string) error {
+	if _, err := s.exec(`UPDATE backup_files SET state = ?, error = ? WHERE job_id = ? AND path = ?`, state, errMsg, jobID, path); err != nil {
+		return fmt.Errorf("set backup file state: %w", err)
+	}
+	return nil
+}
+
+// RetryBackupFiles puts a job's failed files back to pending.
+func (s *Store) RetryBackupFiles(jobID string) error {
+	if _, err := s.exec(`UPDATE backup_files SET state = 'pending', error = '' WHERE job_id = ? AND state = 'failed'`, jobID); err != nil {
+		return fmt.Errorf("retry backup files: %w", err)
+	}
+	return nil
+}
+
+// DeleteBackupJob removes a job with its files. Returns false if there
+// was no such job.
+func (s *Store) DeleteBackupJob(id string) (bool, error) {
+	res, err := s.exec(`DELETE FROM backup_jobs WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete backup job: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AddIOStats adds the counts of stats onto the recorded ones.
+func (s *Store) AddIOStats(stats []IOStat) error {
+	return s.execEach(`
+		INSERT INTO io_stats (day, root, direction, bytes, files) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(day, root, direction) DO UPDATE SET
+			bytes = bytes + excluded.bytes,
+			files = files + excluded.files
+	`, len(stats), func(i int) []any {
+		st := stats[i]
+		return []any{st.Day, st.Root, st.Direction, st.Bytes, st.Files}
+	})
+}
+
+// ListIOStats returns the counters of days from since on, oldest first.
+func (s *Store) ListIOStats(since string) ([]IOStat, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT day, root, direction, bytes, files FROM io_stats
+		WHERE day >= ? ORDER BY day, root, direction
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("list io stats: %w", err)
+	}
+	defer rows.Close()
+	stats := make([]IOStat, 0)
+	for rows.Next() {
+		var st IOStat
+		if err := rows.Scan(&st.Day, &st.Root, &st.Direction, &st.Bytes, &st.Files); err != nil {
+			return nil, fmt.Errorf("scan io stat: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// PruneIOStats drops the counters of days before before.
+func (s *Store) PruneIOStats(before string) error {
+	_, err := s.exec(`DELETE FROM io_stats WHERE day < ?`, before)
+	return err
 }
@@ -1,6 +1,9 @@
 package sync
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -9,7 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func setupTestDB(t *testing.T) *Store {
+func setupTestDB(t testing.TB) *Store {
 	t.Helper()
 	dir := t.TempDir()
 	db, err := openDBAt(filepath.Join(dir, "test-sync.db"))
@@ -41,7 +44,7 @@ func TestOpenDB_CreatesSchema(t *testing.T) {
 	var version string
 	err = db.QueryRow("SELECT value FROM meta WHERE key = 'schema_version'").Scan(&version)
 	require.NoError(t, err)
-	assert.Equal(t, "3", version)
+	assert.Equal(t, "32", version)
 }
 
 func TestOpenDB_Idempotent(t *testing.T) {
@@ -139,6 +142,107 @@ func TestUpsertEntry_OnConflict(t *testing.T) {
 	assert.Equal(t, int64(3000), newEntry.Mtime)
 }
 
+func TestUpsertEntriesBatch(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "old", Type: "text", Mtime: 1}))
+
+	entries := []Entry{{Inode: 10, Name: "dir", Type: "dir", Mtime: 1}}
+	for i := 0; i < upsertBatchSize+5; i++ {
+		entries = append(entries, Entry{
+			Inode: uint64(100 + i), ParentIno: 10, Name: fmt.Sprintf("f%d.txt", i),
+			Type: "text", Size: ptr(int64(i)), Mtime: 2,
+		})
+	}
+	// Same path as inode 1: re-keyed like UpsertEntry.
+	entries = append(entries, Entry{Inode: 2, Name: "old", Type: "text", Mtime: 3})
+	require.NoError(t, store.UpsertEntriesBatch(entries))
+
+	children, err := store.ListChildren(10)
+	require.NoError(t, err)
+	assert.Len(t, children, upsertBatchSize+5)
+	e, err := store.GetEntry(2)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, int64(3), e.Mtime)
+
+	require.NoError(t, store.UpsertSpacesViewsBatch([]SpacesView{{EntryIno: 10, SyncedMtime: 1}, {EntryIno: 100, SyncedMtime: 2}}))
+	sv, err := store.GetSpacesView(100)
+	require.NoError(t, err)
+	require.NotNil(t, sv)
+	assert.Equal(t, int64(2), sv.SyncedMtime)
+
+	err = store.UpsertEntriesBatch([]Entry{{Inode: 500, ParentIno: 999, Name: "orphan", Type: "text"}})
+	assert.Error(t, err, "missing parent violates the foreign key")
+}
+
+func benchmarkEntries(n int) []Entry {
+	entries := []Entry{{Inode: 1, Name: "root", Type: "dir", Mtime: 1}}
+	for i := 0; i < n; i++ {
+		entries = append(entries, Entry{Inode: uint64(i + 2), ParentIno: 1, Name: fmt.Sprintf("f%d", i), Type: "blob", Mtime: 1})
+	}
+	return entries
+}
+
+func BenchmarkUpsertEntry_Single(b *testing.B) {
+	entries := benchmarkEntries(5000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := openDBAt(filepath.Join(b.TempDir(), "bench.db"))
+		require.NoError(b, err)
+		b.StartTimer()
+		store := NewStore(db)
+		for _, e := range entries {
+			require.NoError(b, store.UpsertEntry(e))
+		}
+		db.Close()
+	}
+}
+
+func BenchmarkUpsertEntriesBatch(b *testing.B) {
+	entries := benchmarkEntries(5000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, err := openDBAt(filepath.Join(b.TempDir(), "bench.db"))
+		require.NoError(b, err)
+		b.StartTimer()
+		require.NoError(b, NewStore(db).UpsertEntriesBatch(entries))
+		db.Close()
+	}
+}
+
+// benchmarkTree returns a tree of 100 directories under inode 1 holding
+// 1000 files each, with an excluded directory among them.
+func benchmarkTree() []Entry {
+	entries := []Entry{{Inode: 1, Name: "root", Type: "dir", Mtime: 1}}
+	ino := uint64(2)
+	for d := 0; d < 100; d++ {
+		dir := ino
+		entries = append(entries, Entry{Inode: dir, ParentIno: 1, Name: fmt.Sprintf("d%d", d), Type: "dir", Mtime: 1, Excluded: d == 50})
+		ino++
+		for f := 0; f < 1000; f++ {
+			entries = append(entries, Entry{Inode: ino, ParentIno: dir, Name: fmt.Sprintf("f%d", f), Type: "blob", Mtime: 1})
+			ino++
+		}
+	}
+	return entries
+}
+
+func BenchmarkSetSelected_Tree(b *testing.B) {
+	store := setupTestDB(b)
+	require.NoError(b, store.UpsertEntriesBatch(benchmarkTree()))
+	b.Run("plain", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, store.SetSelected([]uint64{1}, i%2 == 0))
+		}
+	})
+	b.Run("undoable", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := store.SetSelectedUndoable([]uint64{1}, i%2 == 0, nil)
+			require.NoError(b, err)
+		}
+	})
+}
+
 func TestUpdateEntryName(t *testing.T) {
 	store := setupTestDB(t)
 
@@ -307,3 +411,207 @@ func TestChildCounts(t *testing.T) {
 	assert.Equal(t, 3, total)
 	assert.Equal(t, 2, sel)
 }
+
+func seedSelectionTree(t *testing.T, store *Store) {
+	t.Helper()
+	// Projects(1)/{a.txt(2), tmp(3)/{b.txt(4)}}
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "Projects", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 3, ParentIno: 1, Name: "tmp", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 4, ParentIno: 3, Name: "b.txt", Type: "text", Mtime: 1}))
+}
+
+func TestSetSelectedExcept_SkipsExcludedSubtree(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+
+	require.NoError(t, store.SetSelectedExcept([]uint64{1}, true, []uint64{3}))
+
+	for ino, want := range map[uint64]bool{1: true, 2: true, 3: false, 4: false} {
+		e, err := store.GetEntry(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, e.Selected, "inode %d", ino)
+	}
+}
+
+func TestSelectionState(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+
+	state, err := store.SelectionState(1)
+	require.NoError(t, err)
+	assert.Equal(t, SelectionNone, state)
+
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+	state, err = store.SelectionState(1)
+	require.NoError(t, err)
+	assert.Equal(t, SelectionAll, state)
+
+	// Deselecting a nested child makes the ancestor partial
+	require.NoError(t, store.SetSelected([]uint64{4}, false))
+	state, err = store.SelectionState(1)
+	require.NoError(t, err)
+	assert.Equal(t, SelectionPartial, state)
+	state, err = store.SelectionState(3)
+	require.NoError(t, err)
+	assert.Equal(t, SelectionNone, state)
+
+	// Empty directory reflects its own flag
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "empty", Type: "dir", Mtime: 1, Selected: true}))
+	state, err = store.SelectionState(5)
+	require.NoError(t, err)
+	assert.Equal(t, SelectionAll, state)
+}
+
+func TestSelectionStates(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+	require.NoError(t, store.SetSelected([]uint64{4}, false))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "empty", Type: "dir", Mtime: 1, Selected: true}))
+
+	states, err := store.SelectionStates([]uint64{1, 3, 5, 99})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint64]string{1: SelectionPartial, 3: SelectionNone, 5: SelectionAll, 99: SelectionNone}, states)
+}
+
+func TestMigrate_V3toV4_AddsExcluded(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "sync.db")
+
+	// Build a v3 database by hand
+	raw, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	_, err = raw.Exec(`
+		CREATE TABLE entries (
+			inode INTEGER PRIMARY KEY, parent_ino INTEGER NOT NULL DEFAULT 0,
+			name TEXT NOT NULL, type TEXT NOT NULL, size INTEGER,
+			mtime INTEGER NOT NULL, selected INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(parent_ino, name));
+		CREATE TABLE spaces_view (
+			entry_ino INTEGER PRIMARY KEY REFERENCES entries(inode) ON UPDATE CASCADE ON DELETE CASCADE,
+			synced_mtime INTEGER NOT NULL, checked_at INTEGER NOT NULL);
+		CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+		INSERT INTO meta VALUES ('schema_version', '3');
+		INSERT INTO entries (inode, name, type, mtime, selected) VALUES (7, 'old.txt', 'text', 1, 1);
+	`)
+	require.NoError(t, err)
+	raw.Close()
+
+	db, err := openDBAt(dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	e, err := NewStore(db).GetEntry(7)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.True(t, e.Selected)
+	assert.False(t, e.Excluded)
+}
+
+func TestSetExcluded_SurvivesParentSelect(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+
+	// Excluding tmp deselects its subtree
+	require.NoError(t, store.SetExcluded([]uint64{3}, true))
+	e, _ := store.GetEntry(4)
+	assert.False(t, e.Selected)
+
+	// Re-selecting the parent leaves the excluded subtree alone
+	require.NoError(t, store.SetSelected([]uint64{1}, false))
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+	for ino, want := range map[uint64]bool{1: true, 2: true, 3: false, 4: false} {
+		e, err := store.GetEntry(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, e.Selected, "inode %d", ino)
+	}
+
+	// Directly selecting an excluded entry is a no-op until it's included
+	require.NoError(t, store.SetSelected([]uint64{3}, true))
+	e, _ = store.GetEntry(3)
+	assert.False(t, e.Selected)
+
+	require.NoError(t, store.SetExcluded([]uint64{3}, false))
+	require.NoError(t, store.SetSelected([]uint64{3}, true))
+	e, _ = store.GetEntry(3)
+	assert.True(t, e.Selected)
+	assert.False(t, e.Excluded)
+}
+
+func TestSetStarred_IndependentOfSelection(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+
+	require.NoError(t, store.SetStarred([]uint64{3, 4}, true))
+	require.NoError(t, store.SetSelected([]uint64{1}, true))
+	require.NoError(t, store.SetSelected([]uint64{1}, false))
+
+	starred, err := store.ListStarred()
+	require.NoError(t, err)
+	require.Len(t, starred, 2)
+	assert.Equal(t, uint64(3), starred[0].Inode, "directories first")
+	assert.False(t, starred[0].Selected)
+
+	// Re-registering by path keeps the flag
+	e, err := store.GetEntry(4)
+	require.NoError(t, err)
+	e.Inode = 40
+	e.Starred = false
+	require.NoError(t, store.UpsertEntry(*e))
+	e, err = store.GetEntry(40)
+	require.NoError(t, err)
+	assert.True(t, e.Starred)
+
+	require.NoError(t, store.SetStarred([]uint64{3}, false))
+	starred, err = store.ListStarred()
+	require.NoError(t, err)
+	require.Len(t, starred, 1)
+	assert.Equal(t, uint64(40), starred[0].Inode)
+}
+
+func TestTagEntries(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+
+	require.NoError(t, store.TagEntries([]uint64{2, 4}, []string{"Work", "draft"}))
+	require.NoError(t, store.TagEntries([]uint64{4}, []string{"work"}), "case-insensitive duplicate is a no-op")
+
+	tags, err := store.ListTags()
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{Name: "draft", Count: 2}, {Name: "Work", Count: 2}}, tags)
+
+	require.NoError(t, store.UntagEntries([]uint64{2, 4}, []string{"draft"}))
+	got, err := store.EntryTags(4)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Work"}, got)
+
+	// Deleting an entry drops its tags
+	require.NoError(t, store.DeleteEntry(2))
+	tags, err = store.ListTags()
+	require.NoError(t, err)
+	assert.Equal(t, []Tag{{Name: "Work", Count: 1}}, tags)
+}
+
+func TestStore_WithContext(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := store.WithContext(ctx)
+	e, err := bound.GetEntry(1)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+
+	cancel()
+	_, err = bound.GetEntry(1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, bound.UpsertEntry(Entry{Inode: 2, Name: "b.txt", Type: "text", Mtime: 1}), context.Canceled)
+	assert.ErrorIs(t, bound.WithTx(func(*TxStore) error { return nil }), context.Canceled)
+
+	// The Store it came from is unaffected.
+	e, err = store.GetEntry(1)
+	require.NoError(t, err)
+	assert.NotNil(t, e)
+}
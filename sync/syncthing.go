@@ -0,0 +1,413 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// Syncthing support for a Spaces folder that Syncthing also shares
+// (Config.Syncthing). Its .stfolder and .stversions are hidden and so
+// already skipped. Three things are honoured on top of that:
+//   - its conflict copies become conflicts in this system;
+//   - paths it ignores (.stignore) are left alone;
+//   - it can be paused through its REST API while bulk work runs.
+
+// syncthingConflictRe matches Syncthing conflict copies,
+// "<name>.sync-conflict-<date>-<time>[-<device>]<ext>", capturing the
+// original name and extension.
+var syncthingConflictRe = regexp.MustCompile(`^(.*)\.sync-conflict-\d{8}-\d{6}(?:-[A-Z0-9]{7})?(\.[^.]*)?$`)
+
+// syncthingOriginal returns the name a Syncthing conflict copy was made
+// from, and whether name is one.
+func syncthingOriginal(name string) (string, bool) {
+	m := syncthingConflictRe.FindStringSubmatch(name)
+	if m == nil || m[1] == "" {
+		return "", false
+	}
+	return m[1] + m[2], true
+}
+
+// syncthingConflict reports whether a Spaces entry named name is a
+// Syncthing conflict copy to be ingested rather than skipped.
+func syncthingConflict(name string) bool {
+	if !currentConfig().Syncthing {
+		return false
+	}
+	_, ok := syncthingOriginal(name)
+	return ok
+}
+
+// ingestSyncthingConflict turns the Syncthing conflict copy at relPath in
+// Spaces into a conflict of this system: the copy moves to Archives next
+// to the original under the usual _conflict-N name, is registered
+// unselected and recorded as a conflict of the original path. The Spaces
+// copy is removed, which Syncthing propagates to its other devices.
+func ingestSyncthingConflict(ctx context.Context, store *Store, relPath, archivesRoot, spacesRoot string, hasQueued func() bool) error {
+	l := sub("syncthing")
+	spacesPath := filepath.Join(spacesRoot, relPath)
+	sfs := fsFor(spacesPath)
+	info, err := sfs.Lstat(spacesPath)
+	if os.IsNotExist(err) {
+		return nil // already handled or removed
+	}
+	if err != nil {
+		return fmt.Errorf("stat conflict copy: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if err := checkStable(spacesPath); err != nil {
+		return err
+	}
+
+	name, _ := syncthingOriginal(filepath.Base(relPath))
+	originalRel := filepath.Join(filepath.Dir(relPath), name)
+	parentIno, err := resolveParentInoFromDB(store, originalRel)
+	if err != nil {
+		l.Warn("conflict copy outside registered directories, left in Spaces", "path", relPath, "err", err)
+		return nil
+	}
+	archivePath := filepath.Join(archivesRoot, originalRel)
+	conflictName := ConflictName(archivePath)
+	conflictPath := filepath.Join(filepath.Dir(archivePath), conflictName)
+	if err := copyFromSpaces(ctx, relPath, spacesPath, conflictPath, hasQueued); err != nil {
+		return fmt.Errorf("copy conflict copy: %w", err)
+	}
+	mtime, _, inode, size := statFile(conflictPath)
+	if inode == nil || mtime == nil {
+		return fmt.Errorf("stat ingested conflict copy %s", conflictPath)
+	}
+	if err := store.UpsertEntry(Entry{
+		Inode:     *inode,
+		ParentIno: parentIno,
+		Name:      conflictName,
+		Type:      ClassifyFile(conflictPath, conflictName),
+		Size:      size,
+		Mtime:     *mtime,
+	}); err != nil {
+		return fmt.Errorf("register conflict copy: %w", err)
+	}
+	var winnerIno uint64
+	if winner, _, err := lookupDB(store, archivesRoot, originalRel); err == nil && winner != nil {
+		winnerIno = winner.Inode
+	}
+	id, err := store.RegisterConflict(Conflict{Path: originalRel, ConflictIno: *inode, ConflictName: conflictName, WinnerIno: winnerIno}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("register conflict: %w", err)
+	}
+	if err := sfs.Remove(spacesPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove conflict copy: %w", err)
+	}
+	l.Info("syncthing conflict ingested", "path", originalRel, "copy", filepath.Base(relPath), "conflictName", conflictName, "conflictID", id)
+	return nil
+}
+
+// queueSyncthingConflicts queues the conflict copies already in Spaces,
+// which the seed skips.
+func (d *Daemon) queueSyncthingConflicts() {
+	if !currentConfig().Syncthing || isRemote(d.spacesRoot) {
+		return
+	}
+	var n int
+	filepath.WalkDir(d.spacesRoot, func(path string, de os.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || path == d.spacesRoot {
+			return nil
+		}
+		if de.IsDir() {
+			if skipWatchDir(de.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if syncthingConflict(de.Name()) {
+			rel, _ := filepath.Rel(d.spacesRoot, path)
+			d.queue.Push(filepath.ToSlash(rel))
+			n++
+		}
+		return nil
+	})
+	if n > 0 {
+		sub("syncthing").Info("queued existing conflict copies", "count", n)
+	}
+}
+
+// stIgnore is a parsed .stignore. Patterns apply in order and the first
+// match decides; a "!" pattern re-includes. Supported beyond plain globs:
+// "**" across directories, a leading "/" anchoring to the folder root,
+// "(?i)" case folding and "//" comments. "#include" lines and the
+// "(?d)" prefix are not supported and skipped.
+type stIgnore struct {
+	rules []stRule
+}
+
+type stRule struct {
+	re      *regexp.Regexp
+	include bool // "!" pattern
+}
+
+// parseStIgnore parses .stignore content.
+func parseStIgnore(r io.Reader) (*stIgnore, error) {
+	ign := &stIgnore{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#include") {
+			continue
+		}
+		var rule stRule
+		fold := false
+		for {
+			switch {
+			case strings.HasPrefix(line, "!"):
+				rule.include = true
+				line = line[1:]
+				continue
+			case strings.HasPrefix(line, "(?i)"):
+				fold = true
+				line = line[4:]
+				continue
+			case strings.HasPrefix(line, "(?d)"):
+				line = line[4:]
+				continue
+			}
+			break
+		}
+		if line == "" {
+			continue
+		}
+		expr := stGlobToRegexp(line)
+		if fold {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", line, err)
+		}
+		rule.re = re
+		ign.rules = append(ign.rules, rule)
+	}
+	return ign, sc.Err()
+}
+
+// stGlobToRegexp translates a .stignore glob to a regexp matched against
+// slash-separated relative paths. An unanchored pattern matches at any
+// depth.
+func stGlobToRegexp(glob string) string {
+	anchored := strings.HasPrefix(glob, "/")
+	glob = strings.Trim(glob, "/")
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			if j := strings.IndexByte(glob[i:], ']'); j > 0 {
+				b.WriteString(glob[i : i+j+1])
+				i += j
+			} else {
+				b.WriteString(`\[`)
+			}
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ignored reports whether relPath is ignored: the first rule matching it
+// decides, else the first matching a directory above it, nearest first.
+func (ign *stIgnore) ignored(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for p := relPath; p != "." && p != ""; p = parentOf(p) {
+		for _, r := range ign.rules {
+			if r.re.MatchString(p) {
+				return !r.include
+			}
+		}
+	}
+	return false
+}
+
+func parentOf(p string) string {
+	if i := strings.LastIndexByte(p, '/'); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+var stIgnoreCache struct {
+	gosync.Mutex
+	root  string
+	mtime time.Time
+	size  int64
+	ign   *stIgnore
+}
+
+// stIgnoredRel reports whether relPath under root is ignored by the
+// root's .stignore, re-read whenever it changes. Always false unless
+// Config.Syncthing.
+func stIgnoredRel(root, relPath string) bool {
+	if !currentConfig().Syncthing {
+		return false
+	}
+	ign := loadStIgnore(root)
+	return ign != nil && ign.ignored(relPath)
+}
+
+func loadStIgnore(root string) *stIgnore {
+	path := filepath.Join(root, ".stignore")
+	fs := fsFor(path)
+	info, err := fs.Stat(path)
+	c := &stIgnoreCache
+	c.Lock()
+	defer c.Unlock()
+	if err != nil {
+		if c.root == root {
+			c.root, c.ign = "", nil
+		}
+		return nil
+	}
+	if c.root == root && c.mtime.Equal(info.ModTime()) && c.size == info.Size() {
+		return c.ign
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	ign, err := parseStIgnore(f)
+	if err != nil {
+		sub("syncthing").Warn("invalid .stignore, ignoring it", "path", path, "err", err)
+		ign = nil
+	}
+	c.root, c.mtime, c.size, c.ign = root, info.ModTime(), info.Size(), ign
+	return ign
+}
+
+// syncthingFolder pauses and resumes one Syncthing folder over its REST
+// API.
+type syncthingFolder struct {
+	base   string
+	apiKey string
+	id     string
+	http   *http.Client
+}
+
+func newSyncthingFolder(cfg Config) *syncthingFolder {
+	if cfg.SyncthingURL == "" {
+		return nil
+	}
+	return &syncthingFolder{
+		base:   strings.TrimSuffix(cfg.SyncthingURL, "/"),
+		apiKey: cfg.SyncthingAPIKey,
+		id:     cfg.SyncthingFolder,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (f *syncthingFolder) setPaused(ctx context.Context, paused bool) error {
+	body := fmt.Sprintf(`{"paused":%t}`, paused)
+	req, err := http.NewRequestWithContext(ctx, "PATCH", f.base+"/rest/config/folders/"+url.PathEscape(f.id), bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", f.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("syncthing: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// syncthingPauseInterval is how often runSyncthingPauser checks the
+// queue.
+const syncthingPauseInterval = time.Second
+
+// runSyncthingPauser pauses the Syncthing folder once at least
+// Config.SyncthingPauseThreshold paths are queued, so it doesn't scan
+// and transfer a bulk select, deselect or move half-way through, and
+// resumes it once the queue has drained. The queue is checked every
+// interval.
+func (d *Daemon) runSyncthingPauser(ctx context.Context, interval time.Duration) {
+	l := sub("syncthing")
+	var paused *syncthingFolder // the folder paused, nil if none
+	resume := func(rctx context.Context) {
+		if err := paused.setPaused(rctx, false); err != nil {
+			l.Warn("resume folder failed", "folder", paused.id, "err", err)
+			return
+		}
+		l.Info("folder resumed", "folder", paused.id)
+		paused = nil
+	}
+	defer func() {
+		if paused != nil {
+			rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			resume(rctx)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(interval):
+		}
+		cfg := currentConfig()
+		busy := d.queue.Len() + d.inflightCount()
+		switch {
+		case paused == nil && cfg.Syncthing && cfg.SyncthingURL != "" && busy >= cfg.SyncthingPauseThreshold:
+			f := newSyncthingFolder(cfg)
+			if err := f.setPaused(ctx, true); err != nil {
+				l.Warn("pause folder failed", "folder", f.id, "err", err)
+				continue
+			}
+			paused = f
+			l.Info("folder paused for bulk work", "folder", f.id, "queued", busy)
+		case paused != nil && busy == 0:
+			resume(ctx)
+		}
+	}
+}
+
+// inflightCount returns the number of paths in RunPipeline.
+func (d *Daemon) inflightCount() int {
+	d.inflightMu.Lock()
+	defer d.inflightMu.Unlock()
+	return len(d.inflight)
+}
@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncthingOriginal(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.sync-conflict-20260101-120000-ABCDEFG.docx": "report.docx",
+		"notes.sync-conflict-20260101-120000.txt":           "notes.txt",
+		"Makefile.sync-conflict-20260101-120000-ABCDEFG":    "Makefile",
+		"a.tar.sync-conflict-20260101-120000-ABCDEFG.gz":    "a.tar.gz",
+	} {
+		got, ok := syncthingOriginal(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, got, name)
+	}
+	for _, name := range []string{"report.docx", "x.sync-conflict-1.txt", ".sync-conflict-20260101-120000.txt"} {
+		_, ok := syncthingOriginal(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestStIgnore_Patterns(t *testing.T) {
+	ign, err := parseStIgnore(strings.NewReader(`
+// build output
+/build
+!keep.log
+*.log
+(?i)thumbs.db
+cache/**/*.bin
+#include .stglobalignore
+`))
+	require.NoError(t, err)
+	for path, want := range map[string]bool{
+		"build":                true,
+		"build/out.o":          true,
+		"src/build":            false,
+		"app.log":              true,
+		"logs/sub/app.log":     true,
+		"keep.log":             false,
+		"Pics/Thumbs.DB":       true,
+		"cache/a/b/x.bin":      true,
+		"cache/x.txt":          false,
+		"docs/readme.md":       false,
+		"docs/keep.log/inside": false, // the first matching pattern wins
+	} {
+		assert.Equal(t, want, ign.ignored(path), path)
+	}
+}
+
+func TestScanDir_HonoursStIgnore(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", "x"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "x", "a.js"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debug.log"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".stignore"), []byte("node_modules\n*.log\n"), 0644))
+
+	restoreConfig(t)
+	files, err := ScanDir(dir)
+	require.NoError(t, err)
+	assert.Contains(t, files, "debug.log", ".stignore only applies with syncthing on")
+
+	withConfig(t, func(c *Config) { c.Syncthing = true })
+	files, err = ScanDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Contains(t, files, "notes.txt")
+}
+
+func TestIngestSyncthingConflict(t *testing.T) {
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Docs/", "Docs/a.txt"}, map[string]bool{"Docs/a.txt": true})
+	withConfig(t, func(c *Config) { c.Syncthing = true })
+
+	copyRel := "Docs/a.sync-conflict-20260101-120000-ABCDEFG.txt"
+	copyPath := filepath.Join(spacesRoot, copyRel)
+	require.NoError(t, os.WriteFile(copyPath, []byte("their edit"), 0644))
+	old := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(copyPath, old, old))
+
+	require.NoError(t, RunPipeline(context.Background(), copyRel, store, archivesRoot, spacesRoot, "", nil))
+
+	got, err := os.ReadFile(filepath.Join(archivesRoot, "Docs", "a_conflict-1.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "their edit", string(got))
+	_, err = os.Stat(copyPath)
+	assert.True(t, os.IsNotExist(err), "conflict copy moved out of Spaces")
+
+	entry := registered(t, store, archivesRoot, "Docs/a_conflict-1.txt")
+	require.NotNil(t, entry)
+	assert.False(t, entry.Selected)
+	conflicts, err := store.ListConflicts(true)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "Docs/a.txt", conflicts[0].Path)
+	assert.Equal(t, entry.Inode, conflicts[0].ConflictIno)
+	assert.Equal(t, registered(t, store, archivesRoot, "Docs/a.txt").Inode, conflicts[0].WinnerIno)
+
+	// The original is untouched
+	got, err = os.ReadFile(filepath.Join(spacesRoot, "Docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Docs/a.txt", string(got))
+}
+
+func TestSyncthingPauser_PausesDuringBulkWork(t *testing.T) {
+	requests := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/rest/config/folders/spaces-1", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-API-Key"))
+		requests <- string(body)
+	}))
+	defer srv.Close()
+	withConfig(t, func(c *Config) {
+		c.Syncthing = true
+		c.SyncthingURL = srv.URL
+		c.SyncthingAPIKey = "secret"
+		c.SyncthingFolder = "spaces-1"
+		c.SyncthingPauseThreshold = 3
+	})
+
+	_, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	d := NewDaemon(store, archivesRoot, spacesRoot)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	defer func() { cancel(); <-stopped }()
+	d.queue.PushMany([]string{"a", "b"})
+	go func() {
+		defer close(stopped)
+		d.runSyncthingPauser(ctx, 10*time.Millisecond)
+	}()
+
+	select {
+	case body := <-requests:
+		t.Fatalf("paused below the threshold: %s", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	d.queue.Push("c")
+	assert.JSONEq(t, `{"paused":true}`, <-requests)
+	d.queue.Drain()
+	assert.JSONEq(t, `{"paused":false}`, <-requests)
+}
+
+func TestPatchConfig_SyncthingStartupOnly(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.Syncthing = true
+		c.SyncthingURL = "http://127.0.0.1:8384"
+		c.SyncthingAPIKey = "secret"
+		c.SyncthingFolder = "spaces-1"
+	})
+	for _, patch := range []string{
+		`{"syncthingUrl":"http://attacker.example"}`,
+		`{"syncthingFolder":"other"}`,
+		`{"syncthing":false}`,
+	} {
+		_, err := patchConfig([]byte(patch))
+		assert.Error(t, err, patch)
+	}
+	cfg := currentConfig()
+	assert.True(t, cfg.Syncthing)
+	assert.Equal(t, "http://127.0.0.1:8384", cfg.SyncthingURL)
+	assert.Equal(t, "spaces-1", cfg.SyncthingFolder)
+
+	_, err := patchConfig([]byte(`{"syncthingPauseThreshold":50}`))
+	require.NoError(t, err)
+	assert.Equal(t, 50, currentConfig().SyncthingPauseThreshold)
+}
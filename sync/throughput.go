@@ -0,0 +1,81 @@
+package sync
+
+import (
+	gosync "sync"
+	"time"
+)
+
+// rateWindow is the rolling window over which throughput is averaged.
+const rateWindow = 60 * time.Second
+
+// rateMeter is a rolling per-second counter over rateWindow.
+type rateMeter struct {
+	mu      gosync.Mutex
+	buckets [int(rateWindow / time.Second)]int64
+	stamps  [int(rateWindow / time.Second)]int64 // unix second of each bucket
+}
+
+// Add records n units at the current time.
+func (m *rateMeter) Add(n int64) {
+	sec := nowFunc().Unix()
+	i := int(sec % int64(len(m.buckets)))
+	m.mu.Lock()
+	if m.stamps[i] != sec {
+		m.stamps[i] = sec
+		m.buckets[i] = 0
+	}
+	m.buckets[i] += n
+	m.mu.Unlock()
+}
+
+// Rate returns units per second averaged over the window.
+// The window is trimmed to start at the oldest live bucket so a burst
+// after an idle period isn't diluted by empty seconds before it.
+func (m *rateMeter) Rate() float64 {
+	now := nowFunc().Unix()
+	oldest := now
+	var total int64
+	m.mu.Lock()
+	for i, stamp := range m.stamps {
+		if stamp == 0 || now-stamp >= int64(len(m.buckets)) {
+			continue
+		}
+		total += m.buckets[i]
+		if stamp < oldest {
+			oldest = stamp
+		}
+	}
+	m.mu.Unlock()
+	if total == 0 {
+		return 0
+	}
+	return float64(total) / float64(now-oldest+1)
+}
+
+// copyBytesMeter tracks bytes written by SafeCopy.
+var copyBytesMeter = &rateMeter{}
+
+// itemsMeter tracks pipeline runs completed by the daemon workers.
+var itemsMeter = &rateMeter{}
+
+// estimateETA returns the estimated seconds to drain the pending work,
+// using whichever of the byte-based or item-based estimate is larger.
+// Returns -1 when there is pending work but no rate to estimate from.
+func estimateETA(bytesPending int64, itemsPending int, bytesPerSec, itemsPerSec float64) int64 {
+	if bytesPending <= 0 && itemsPending <= 0 {
+		return 0
+	}
+	var eta float64 = -1
+	if bytesPending > 0 && bytesPerSec > 0 {
+		eta = float64(bytesPending) / bytesPerSec
+	}
+	if itemsPending > 0 && itemsPerSec > 0 {
+		if byItems := float64(itemsPending) / itemsPerSec; byItems > eta {
+			eta = byItems
+		}
+	}
+	if eta < 0 {
+		return -1
+	}
+	return int64(eta + 0.5)
+}
@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateMeter_RollingWindow(t *testing.T) {
+	base := time.Unix(1_700_000_000, 0)
+	clk := useFakeClock(t, base)
+
+	m := &rateMeter{}
+	assert.Equal(t, 0.0, m.Rate())
+
+	m.Add(100)
+	clk.Set(base.Add(time.Second))
+	m.Add(100)
+	assert.InDelta(t, 100.0, m.Rate(), 0.01, "200 units over 2 seconds")
+
+	// Samples fall out of the window
+	clk.Set(base.Add(rateWindow + 5*time.Second))
+	assert.Equal(t, 0.0, m.Rate())
+}
+
+func TestEstimateETA(t *testing.T) {
+	assert.Equal(t, int64(0), estimateETA(0, 0, 0, 0))
+	assert.Equal(t, int64(-1), estimateETA(1000, 0, 0, 0), "no rate yet")
+	assert.Equal(t, int64(10), estimateETA(1000, 0, 100, 0))
+	assert.Equal(t, int64(50), estimateETA(1000, 100, 100, 2), "item estimate dominates")
+}
+
+func TestPendingSyncSize(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a", Type: "text", Size: ptr(int64(10)), Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "b", Type: "text", Size: ptr(int64(20)), Selected: true}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 3, Name: "c", Type: "text", Size: ptr(int64(40)), Selected: false}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 2, SyncedMtime: 1}))
+
+	pending, err := store.PendingSyncSize()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), pending)
+}
@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tombstone registers relPath, tags and stars it, then loses it from disk
+// and runs the pipeline so P0 tombstones it.
+func tombstone(t *testing.T, env *pipelineEnv, relPath string) Entry {
+	t.Helper()
+	env.writeArchive(t, relPath, []byte("data"))
+	env.run(t, relPath)
+	e, err := env.store.GetEntryByPath(0, relPath)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	require.NoError(t, env.store.SetStarred([]uint64{e.Inode}, true))
+	require.NoError(t, env.store.TagEntries([]uint64{e.Inode}, []string{"keep"}))
+
+	require.NoError(t, os.Remove(filepath.Join(env.archivesRoot, relPath)))
+	env.run(t, relPath)
+	return *e
+}
+
+func TestTombstone_LostEntryIsHidden(t *testing.T) {
+	env := setupPipelineEnv(t)
+	e := tombstone(t, env, "lost.txt")
+
+	got, err := env.store.GetEntry(e.Inode)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	children, err := env.store.ListChildren(0)
+	require.NoError(t, err)
+	assert.Empty(t, children)
+
+	tombs, err := env.store.ListTombstones(10)
+	require.NoError(t, err)
+	require.Len(t, tombs, 1)
+	assert.Equal(t, e.Inode, tombs[0].Inode)
+	assert.NotZero(t, tombs[0].DeletedAt)
+	assert.True(t, tombs[0].Starred)
+	assert.NotZero(t, tombs[0].CreatedAt)
+}
+
+func TestTombstone_RecreatedPathRevives(t *testing.T) {
+	env := setupPipelineEnv(t)
+	e := tombstone(t, env, "back.txt")
+
+	env.writeArchive(t, "back.txt", []byte("again"))
+	env.run(t, "back.txt")
+
+	got, err := env.store.GetEntryByPath(0, "back.txt")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Zero(t, got.DeletedAt)
+	assert.True(t, got.Starred, "user flags survive the tombstone")
+	assert.Equal(t, e.CreatedAt, got.CreatedAt)
+	assert.GreaterOrEqual(t, got.UpdatedAt, e.UpdatedAt)
+	tags, err := env.store.EntryTags(got.Inode)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"keep"}, tags)
+}
+
+func TestTombstone_InodeReusedElsewhere(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "old.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.WithTx(func(tx *TxStore) error { return tx.TombstoneEntry(5) }))
+
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 5, Name: "new.txt", Type: "text", Mtime: 2}))
+	got, err := store.GetEntryByPath(0, "new.txt")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, uint64(5), got.Inode)
+	tombs, err := store.ListTombstones(10)
+	require.NoError(t, err)
+	assert.Empty(t, tombs)
+
+	// A live entry holding the inode still refuses a second path.
+	assert.Error(t, store.UpsertEntry(Entry{Inode: 5, Name: "other.txt", Type: "text", Mtime: 3}))
+}
+
+func TestTombstone_MoveOntoTombstonedName(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "b.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.WithTx(func(tx *TxStore) error { return tx.TombstoneEntry(2) }))
+
+	require.NoError(t, store.MoveEntry(1, 0, "b.txt"))
+	got, err := store.GetEntryByPath(0, "b.txt")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, uint64(1), got.Inode)
+}
+
+func TestPurgeTombstones_KeepsGracePeriod(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.TombstoneDays = 30
+	require.NoError(t, setConfig(cfg))
+	store := setupTestDB(t)
+	d := &Daemon{store: store}
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "old.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, Name: "recent.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.TagEntries([]uint64{1}, []string{"gone"}))
+
+	now := time.Now()
+	clk := useFakeClock(t, now.AddDate(0, 0, -31))
+	require.NoError(t, store.WithTx(func(tx *TxStore) error { return tx.TombstoneEntry(1) }))
+	clk.Set(now.AddDate(0, 0, -1))
+	require.NoError(t, store.WithTx(func(tx *TxStore) error { return tx.TombstoneEntry(2) }))
+	clk.Set(now)
+
+	require.NoError(t, d.purgeTombstones())
+	tombs, err := store.ListTombstones(10)
+	require.NoError(t, err)
+	require.Len(t, tombs, 1)
+	assert.Equal(t, uint64(2), tombs[0].Inode)
+	tags, err := store.EntryTags(1)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+}
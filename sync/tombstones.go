@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+// tombstonePurgeInterval is how often tombstones past the tombstoneDays
+// grace period are dropped.
+var tombstonePurgeInterval = time.Hour
+
+// purgeTombstones drops the tombstones older than the configured grace
+// period.
+func (d *Daemon) purgeTombstones() error {
+	days := currentConfig().TombstoneDays
+	n, err := d.store.PurgeTombstones(nowFunc().AddDate(0, 0, -days).UnixNano())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		sub("tombstones").Info("purged tombstones", "count", n, "days", days)
+	}
+	return nil
+}
+
+// runTombstonePurge purges expired tombstones every tombstonePurgeInterval.
+func (d *Daemon) runTombstonePurge(ctx context.Context) {
+	l := sub("tombstones")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(tombstonePurgeInterval)):
+			if err := d.purgeTombstones(); err != nil {
+				l.Warn("purge tombstones failed", "err", err)
+			}
+		}
+	}
+}
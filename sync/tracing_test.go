@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a provider that records every span until the test
+// ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestTrace_QueuedJobContinuesRequestTrace(t *testing.T) {
+	rec := recordSpans(t)
+	h, store, archivesRoot, _ := setupHandlersEnv(t)
+
+	filePath := filepath.Join(archivesRoot, "file.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("hello"), 0644))
+	aMtime, _, aIno, _ := statFile(filePath)
+	require.NoError(t, store.UpsertEntry(Entry{
+		Inode: *aIno, Name: "file.txt", Type: "text", Size: ptr(int64(5)), Mtime: *aMtime,
+	}))
+
+	body, _ := json.Marshal(SelectRequest{Inodes: []uint64{*aIno}})
+	w := httptest.NewRecorder()
+	h.Trace(http.HandlerFunc(h.HandleSelect)).ServeHTTP(w, httptest.NewRequest("POST", "/api/sync/select", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	ended := rec.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, "POST /api/sync/select", ended[0].Name())
+
+	done := make(chan struct{})
+	time.AfterFunc(time.Second, func() { close(done) })
+	job, ok := h.daemon.Queue().PopJob(done)
+	require.True(t, ok)
+	assert.Equal(t, "file.txt", job.Path)
+	assert.Equal(t, ended[0].SpanContext().TraceID(), job.Trace.TraceID())
+}
+
+func TestTrace_SkipsEventStream(t *testing.T) {
+	rec := recordSpans(t)
+	h, _, _, _ := setupHandlersEnv(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h.Trace(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/sync/events", nil))
+	assert.Empty(t, rec.Ended())
+
+	h.Trace(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/sync/entries", nil))
+	assert.Len(t, rec.Ended(), 1)
+}
+
+func TestRunStage_RecordsError(t *testing.T) {
+	rec := recordSpans(t)
+
+	ctx, parent := tracer().Start(context.Background(), "evaluate")
+	boom := errors.New("boom")
+	err := runStage(ctx, "P2", "a.txt", func(context.Context) error { return boom })
+	parent.End()
+	assert.ErrorIs(t, err, boom)
+
+	ended := rec.Ended()
+	require.Len(t, ended, 2)
+	stage := ended[0]
+	assert.Equal(t, "P2", stage.Name())
+	assert.Equal(t, codes.Error, stage.Status().Code)
+	assert.Equal(t, ended[1].SpanContext().SpanID(), stage.Parent().SpanID())
+}
@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A select rule with a transcode command puts a derivative of its files
+// into Spaces instead of the originals: a downscaled photo or video for a
+// media server, say. The derivative keeps the original's name, and its
+// mtime, and the entry records it in the derivatives table, which links
+// it back to its Archives original. Derivatives never flow back: edits
+// to them in Spaces are kept there, and Archives keeps the original.
+
+// Derivative describes a Spaces copy made by a transcode rule.
+type Derivative struct {
+	EntryIno    uint64 `json:"-"`
+	Original    string `json:"original"`    // Archives path of the original, relative to the root
+	Transcode   string `json:"transcode"`   // the command template it was made with
+	SourceMtime int64  `json:"sourceMtime"` // nanoseconds, mtime of the original it was made from
+	CreatedAt   int64  `json:"createdAt"`   // nanoseconds
+}
+
+// validateTranscode checks a rule's transcode command template.
+func validateTranscode(tmpl string) error {
+	if !strings.Contains(tmpl, "{src}") || !strings.Contains(tmpl, "{dst}") {
+		return fmt.Errorf("transcode must contain {src} and {dst}, got %q", tmpl)
+	}
+	return nil
+}
+
+// transcodeRule returns the transcode command of the first rule matching
+// a file, or "" to copy it as is.
+func transcodeRule(rules []AutoSelectRule, relPath string, size int64, mtime int64) string {
+	now := nowFunc()
+	for _, r := range rules {
+		if r.matches(relPath, size, mtime, now) {
+			return r.Transcode
+		}
+	}
+	return ""
+}
+
+// newTranscode returns a transcode command of rules that none of prev
+// has, "" if there is none. The daemon execs them, so a config patch may
+// rearrange the transcode rules but not bring in new commands.
+func newTranscode(prev, rules []AutoSelectRule) string {
+	known := make(map[string]bool)
+	for _, r := range prev {
+		known[r.Transcode] = true
+	}
+	for _, r := range rules {
+		if r.Transcode != "" && !known[r.Transcode] {
+			return r.Transcode
+		}
+	}
+	return ""
+}
+
+// transcode runs tmpl on the Archives file src, writing the derivative
+// into a new temporary directory under the same name, so the command can
+// pick its output format from the extension. The derivative gets src's
+// mtime. The returned func removes it.
+func transcode(ctx context.Context, tmpl, src string) (string, func(), error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "sync-transcode-")
+	if err != nil {
+		return "", nil, fmt.Errorf("transcode dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) } //nolint:errcheck // temp dir
+	dst := filepath.Join(dir, filepath.Base(src))
+
+	// Placeholders are replaced within the split arguments, so paths with
+	// spaces stay one argument each.
+	argv := strings.Fields(tmpl)
+	for i, a := range argv {
+		argv[i] = strings.NewReplacer("{src}", src, "{dst}", dst).Replace(a)
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var out limitedBuffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("transcode: %w: %s", err, strings.TrimSpace(out.String()))
+	}
+	if _, err := os.Stat(dst); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("transcode wrote no %s: %w", filepath.Base(dst), err)
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("transcode: %w", err)
+	}
+	return dst, cleanup, nil
+}
+
+// GetDerivative returns the derivative record of an entry, or nil when
+// its Spaces copy is the original.
+func (s *Store) GetDerivative(entryIno uint64) (*Derivative, error) {
+	d := &Derivative{EntryIno: entryIno}
+	err := s.rdb.QueryRowContext(s.context(), `
+		SELECT original, transcode, source_mtime, created_at FROM derivatives WHERE entry_ino = ?
+	`, entryIno).Scan(&d.Original, &d.Transcode, &d.SourceMtime, &d.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get derivative: %w", err)
+	}
+	return d, nil
+}
+
+// SetDerivative records the Spaces copy of entryIno as d, or as the
+// original when d is nil. The entry must have a spaces_view row, which
+// takes the record with it when deleted.
+func (s *Store) SetDerivative(entryIno uint64, d *Derivative) error {
+	return s.WithTx(func(t *TxStore) error { return t.SetDerivative(entryIno, d) })
+}
+
+// SetDerivative is Store.SetDerivative within the transaction.
+func (t *TxStore) SetDerivative(entryIno uint64, d *Derivative) error {
+	var err error
+	if d == nil {
+		_, err = t.tx.Exec("DELETE FROM derivatives WHERE entry_ino = ?", entryIno)
+	} else {
+		_, err = t.tx.Exec(`
+			INSERT INTO derivatives (entry_ino, original, transcode, source_mtime, created_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(entry_ino) DO UPDATE SET
+				original     = excluded.original,
+				transcode    = excluded.transcode,
+				source_mtime = excluded.source_mtime,
+				created_at   = excluded.created_at
+		`, entryIno, d.Original, d.Transcode, d.SourceMtime, d.CreatedAt)
+	}
+	if err != nil {
+		return fmt.Errorf("set derivative: %w", err)
+	}
+	return nil
+}
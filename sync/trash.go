@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"fmt"
+)
+
+// TrashItem records a Spaces item moved to the trash: where it was, what
+// it was, and where it went. The trash table indexes them, so restores
+// can find a trashed item by its original path.
+type TrashItem struct {
+	ID        int64  `json:"id"`
+	RelPath   string `json:"relPath"`  // original path, relative to the Spaces root
+	EntryIno  uint64 `json:"entryIno"` // entry it was the copy of, 0 if none
+	Size      int64  `json:"size"`     // 0 for directories
+	Mtime     int64  `json:"mtime"`    // nanoseconds
+	IsDir     bool   `json:"isDir"`
+	TrashPath string `json:"trashPath"`
+	TrashedAt int64  `json:"trashedAt"` // nanoseconds
+}
+
+// stat fills in the size, mtime and kind of the item at path, before it
+// is trashed.
+func (it *TrashItem) stat(path string) {
+	info, err := fsFor(path).Stat(path)
+	if err != nil {
+		return
+	}
+	it.Mtime, it.IsDir = info.ModTime().UnixNano(), info.IsDir()
+	if !it.IsDir {
+		it.Size = info.Size()
+	}
+}
+
+// RecordTrash adds a trashed item to the trash index.
+func (s *Store) RecordTrash(it TrashItem) error {
+	return s.WithTx(func(t *TxStore) error { return t.RecordTrash(it) })
+}
+
+// RecordTrash is Store.RecordTrash within the transaction.
+func (t *TxStore) RecordTrash(it TrashItem) error {
+	if it.TrashedAt == 0 {
+		it.TrashedAt = nowNano()
+	}
+	_, err := t.tx.Exec(`
+		INSERT INTO trash (rel_path, entry_ino, size, mtime, is_dir, trash_path, trashed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(trash_path) DO UPDATE SET
+			rel_path = excluded.rel_path, entry_ino = excluded.entry_ino, size = excluded.size,
+			mtime = excluded.mtime, is_dir = excluded.is_dir, trashed_at = excluded.trashed_at
+	`, it.RelPath, it.EntryIno, it.Size, it.Mtime, it.IsDir, it.TrashPath, it.TrashedAt)
+	if err != nil {
+		return fmt.Errorf("record trash: %w", err)
+	}
+	return nil
+}
+
+// ListTrashed returns the trashed items once at relPath, newest first.
+func (s *Store) ListTrashed(relPath string) ([]TrashItem, error) {
+	rows, err := s.rdb.QueryContext(s.context(), `
+		SELECT id, rel_path, entry_ino, size, mtime, is_dir, trash_path, trashed_at
+		FROM trash WHERE rel_path = ? ORDER BY trashed_at DESC, id DESC
+	`, relPath)
+	if err != nil {
+		return nil, fmt.Errorf("list trashed: %w", err)
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var it TrashItem
+		if err := rows.Scan(&it.ID, &it.RelPath, &it.EntryIno, &it.Size, &it.Mtime, &it.IsDir, &it.TrashPath, &it.TrashedAt); err != nil {
+			return nil, fmt.Errorf("scan trash item: %w", err)
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// DeleteTrashed drops an item from the trash index, once it has left the
+// trash.
+func (s *Store) DeleteTrashed(id int64) error {
+	if _, err := s.exec("DELETE FROM trash WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete trashed: %w", err)
+	}
+	return nil
+}
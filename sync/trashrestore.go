@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// restoreFromTrash puts back the Spaces copy of a re-selected file from
+// the trash, instead of copying it again from Archives. It looks the copy
+// up in the trash index by path and only takes one of the entry's current
+// version (same mtime and size) that would be copied as is; it reports
+// whether it restored one.
+func restoreFromTrash(store *Store, entry *Entry, relPath, archivePath, spacesPath string) (bool, error) {
+	if entry.Size == nil || transcodeRule(activeConfig.Load().Rules, relPath, *entry.Size, entry.Mtime) != "" {
+		return false, nil
+	}
+	items, err := store.ListTrashed(relPath)
+	if err != nil {
+		return false, err
+	}
+	l := sub("P3")
+	grain := mtimeGrain(archivePath)
+	for _, it := range items {
+		if it.IsDir || it.Size != *entry.Size || !sameMtime(it.Mtime, entry.Mtime, max(mtimeGrain(it.TrashPath), grain)) {
+			continue
+		}
+		// The index is only a hint: the item may have been emptied from
+		// the trash or put back since.
+		info, err := fsFor(it.TrashPath).Stat(it.TrashPath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != it.Size || !sameMtime(info.ModTime().UnixNano(), it.Mtime, grain) {
+			continue
+		}
+		fs := fsFor(spacesPath)
+		if err := fs.MkdirAll(filepath.Dir(spacesPath), 0755); err != nil {
+			return false, fmt.Errorf("mkdir spaces: %w", err)
+		}
+		if err := fs.Rename(it.TrashPath, spacesPath); err != nil {
+			// Fall back to a copy rather than fail the run.
+			l.Warn("restore from trash failed, copying", "path", relPath, "trashPath", it.TrashPath, "err", err)
+			return false, nil
+		}
+		setSpacesOwnerTree(spacesPath, archivePath)
+		l.Info("restored from trash instead of copying", "path", relPath, "trashPath", it.TrashPath)
+		return true, store.DeleteTrashed(it.ID)
+	}
+	return false, nil
+}
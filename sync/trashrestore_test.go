@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_ReselectRestoresFromTrash(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	spacesPath := filepath.Join(env.spacesRoot, "report.txt")
+	env.writeArchive(t, "report.txt", []byte("quarterly"))
+	env.run(t, "report.txt")
+	entry := registered(t, env.store, env.archivesRoot, "report.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "report.txt")
+	_, _, copyIno, _ := statFile(spacesPath)
+	require.NotNil(t, copyIno)
+
+	_, err := env.store.SetSelectedUndoable([]uint64{entry.Inode}, false, nil)
+	require.NoError(t, err)
+	env.run(t, "report.txt")
+	require.NoFileExists(t, spacesPath)
+	trashed, err := env.store.ListTrashed("report.txt")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	trashPath := trashed[0].TrashPath
+	require.FileExists(t, trashPath)
+
+	_, err = env.store.SetSelectedUndoable([]uint64{entry.Inode}, true, nil)
+	require.NoError(t, err)
+	env.run(t, "report.txt")
+	_, _, restoredIno, _ := statFile(spacesPath)
+	require.NotNil(t, restoredIno)
+	assert.Equal(t, *copyIno, *restoredIno, "renamed back, not copied")
+	assert.NoFileExists(t, trashPath)
+	trashed, err = env.store.ListTrashed("report.txt")
+	require.NoError(t, err)
+	assert.Empty(t, trashed, "restored items leave the index")
+	sv, err := env.store.GetSpacesView(entry.Inode)
+	require.NoError(t, err)
+	assert.NotNil(t, sv)
+}
+
+func TestPipeline_ReselectCopiesOverStaleTrash(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	spacesPath := filepath.Join(env.spacesRoot, "report.txt")
+	env.writeArchive(t, "report.txt", []byte("draft"))
+	env.run(t, "report.txt")
+	entry := registered(t, env.store, env.archivesRoot, "report.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "report.txt")
+	_, err := env.store.SetSelectedUndoable([]uint64{entry.Inode}, false, nil)
+	require.NoError(t, err)
+	env.run(t, "report.txt")
+	trashed, err := env.store.ListTrashed("report.txt")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	trashPath := trashed[0].TrashPath
+
+	// Archives moves on while the file is out of Spaces.
+	env.clock.Advance(time.Second)
+	env.writeArchive(t, "report.txt", []byte("final version"))
+	env.run(t, "report.txt")
+	_, err = env.store.SetSelectedUndoable([]uint64{entry.Inode}, true, nil)
+	require.NoError(t, err)
+	env.run(t, "report.txt")
+	got, err := os.ReadFile(spacesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "final version", string(got))
+	assert.FileExists(t, trashPath, "the stale copy stays in the trash")
+}
+
+func TestPipeline_DeselectRecordsTrash(t *testing.T) {
+	restoreConfig(t)
+	env := setupPipelineEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(env.archivesRoot, "docs"), 0755))
+	env.run(t, "docs")
+	env.writeArchive(t, "docs/report.txt", []byte("quarterly"))
+	env.run(t, "docs/report.txt")
+	entry := registered(t, env.store, env.archivesRoot, "docs/report.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "docs/report.txt")
+	mtime, _, _, _ := statFile(filepath.Join(env.spacesRoot, "docs", "report.txt"))
+
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, false))
+	env.run(t, "docs/report.txt")
+	trashed, err := env.store.ListTrashed("docs/report.txt")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	it := trashed[0]
+	assert.Equal(t, entry.Inode, it.EntryIno)
+	assert.EqualValues(t, len("quarterly"), it.Size)
+	require.NotNil(t, mtime)
+	assert.Equal(t, *mtime, it.Mtime)
+	assert.False(t, it.IsDir)
+	assert.Equal(t, filepath.Join(env.trashRoot, env.clock.Now().Format("2006-01-02"), "docs", "report.txt"), it.TrashPath,
+		"trashed under its directory")
+	assert.FileExists(t, it.TrashPath)
+}
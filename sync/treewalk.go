@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"errors"
+	"path"
+)
+
+// childPageSize is how many children a tree walk lists at once.
+const childPageSize = 1000
+
+// errTreeTruncated is returned by walkTree when it left part of the tree
+// out for Config.TreeMaxDepth or Config.TreeMaxEntries. Callers must not
+// take what the walk didn't visit as absent.
+var errTreeTruncated = errors.New("tree walk truncated")
+
+// walkTree visits the live descendants of rootIno, whose relative path
+// is rootPath ("" for the top), parents before their children. fn gets
+// each entry with its relative path and returns whether to descend into
+// it, for directories. The walk is iterative and pages through each
+// directory, so neither deep nesting nor a directory of millions of
+// children grows the stack or holds a whole listing in memory.
+//
+// Directories deeper than Config.TreeMaxDepth below rootIno are not
+// descended, and the walk stops after Config.TreeMaxEntries entries; what
+// it left out is logged as a warning once, and errTreeTruncated returned
+// once the rest is walked. Otherwise it returns the first error listing
+// or from fn.
+func (s *Store) walkTree(rootIno uint64, rootPath string, fn func(e *Entry, relPath string) (bool, error)) error {
+	type dir struct {
+		ino   uint64
+		path  string
+		depth int
+	}
+	cfg := activeConfig.Load()
+	l := sub("store")
+	stack := []dir{{rootIno, rootPath, 0}}
+	visited := 0
+	depthWarned := false
+	for len(stack) > 0 {
+		d := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		var subdirs []dir
+		after := ""
+		for {
+			page, err := s.ListChildrenAfter(d.ino, after, childPageSize)
+			if err != nil {
+				return err
+			}
+			for i := range page {
+				e := &page[i]
+				if cfg.TreeMaxEntries > 0 && visited >= cfg.TreeMaxEntries {
+					l.Warn("tree walk stopped at treeMaxEntries", "root", rootPath, "limit", cfg.TreeMaxEntries, "at", d.path)
+					return errTreeTruncated
+				}
+				visited++
+				relPath := path.Join(d.path, e.Name)
+				descend, err := fn(e, relPath)
+				if err != nil {
+					return err
+				}
+				if !descend || e.Type != "dir" {
+					continue
+				}
+				if d.depth+1 >= cfg.TreeMaxDepth {
+					if !depthWarned {
+						l.Warn("tree walk not descending past treeMaxDepth", "root", rootPath, "limit", cfg.TreeMaxDepth, "at", relPath)
+						depthWarned = true
+					}
+					continue
+				}
+				subdirs = append(subdirs, dir{e.Inode, relPath, d.depth + 1})
+			}
+			if len(page) < childPageSize {
+				break
+			}
+			after = page[len(page)-1].Name
+		}
+		// Reversed, so directories are walked in name order.
+		for i := len(subdirs) - 1; i >= 0; i-- {
+			stack = append(stack, subdirs[i])
+		}
+	}
+	if depthWarned {
+		return errTreeTruncated
+	}
+	return nil
+}
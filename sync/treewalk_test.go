@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walkPaths returns the paths walkTree visits from the top, and what it
+// returned.
+func walkPaths(store *Store) ([]string, error) {
+	var paths []string
+	err := store.walkTree(0, "", func(e *Entry, relPath string) (bool, error) {
+		paths = append(paths, relPath)
+		return true, nil
+	})
+	return paths, err
+}
+
+func TestWalkTree_PagesLargeDirs(t *testing.T) {
+	restoreConfig(t)
+	store := setupTestDB(t)
+	entries := []Entry{{Inode: 1, Name: "big", Type: "dir", Mtime: 1}, {Inode: 2, Name: "z.txt", Type: "text", Mtime: 1}}
+	for i := 0; i < childPageSize*2+3; i++ {
+		entries = append(entries, Entry{Inode: uint64(100 + i), ParentIno: 1, Name: fmt.Sprintf("f%05d", i), Type: "text", Mtime: 1})
+	}
+	require.NoError(t, store.UpsertEntriesBatch(entries))
+
+	paths, err := walkPaths(store)
+	require.NoError(t, err)
+	require.Len(t, paths, childPageSize*2+5)
+	assert.Equal(t, []string{"big", "z.txt", "big/f00000"}, paths[:3], "a directory's children come before its subdirectories'")
+	assert.Equal(t, fmt.Sprintf("big/f%05d", childPageSize*2+2), paths[len(paths)-1])
+}
+
+func TestWalkTree_Limits(t *testing.T) {
+	restoreConfig(t)
+	store := setupTestDB(t)
+	// a/a/a/.../a, 10 deep, with a file at each level.
+	var entries []Entry
+	parent := uint64(0)
+	for i := 0; i < 10; i++ {
+		ino := uint64(10 + i)
+		entries = append(entries,
+			Entry{Inode: ino, ParentIno: parent, Name: "a", Type: "dir", Mtime: 1},
+			Entry{Inode: ino + 100, ParentIno: parent, Name: "f.txt", Type: "text", Mtime: 1})
+		parent = ino
+	}
+	require.NoError(t, store.UpsertEntriesBatch(entries))
+	paths, err := walkPaths(store)
+	require.NoError(t, err)
+	assert.Len(t, paths, 20)
+
+	cfg := currentConfig()
+	cfg.TreeMaxDepth = 3
+	require.NoError(t, setConfig(cfg))
+	paths, err = walkPaths(store)
+	assert.ErrorIs(t, err, errTreeTruncated)
+	assert.Equal(t, []string{"a", "f.txt", "a/a", "a/f.txt", "a/a/a", "a/a/f.txt"}, paths,
+		"the third level is visited but not descended")
+
+	cfg.TreeMaxDepth = 128
+	cfg.TreeMaxEntries = 4
+	require.NoError(t, setConfig(cfg))
+	paths, err = walkPaths(store)
+	assert.ErrorIs(t, err, errTreeTruncated)
+	assert.Equal(t, []string{"a", "f.txt", "a/a", "a/f.txt"}, paths)
+
+	// Not descending skips the subtree without counting it.
+	paths = nil
+	require.NoError(t, store.walkTree(0, "", func(e *Entry, relPath string) (bool, error) {
+		paths = append(paths, relPath)
+		return false, nil
+	}))
+	assert.Equal(t, []string{"a", "f.txt"}, paths)
+}
+
+func TestSetSelected_StopsAtTreeMaxDepth(t *testing.T) {
+	restoreConfig(t)
+	store := setupTestDB(t)
+	// a/a/a/.../a, 10 deep, with a file at each level.
+	var entries []Entry
+	parent := uint64(0)
+	for i := 0; i < 10; i++ {
+		ino := uint64(10 + i)
+		entries = append(entries,
+			Entry{Inode: ino, ParentIno: parent, Name: "a", Type: "dir", Mtime: 1},
+			Entry{Inode: ino + 100, ParentIno: parent, Name: "f.txt", Type: "text", Mtime: 1})
+		parent = ino
+	}
+	require.NoError(t, store.UpsertEntriesBatch(entries))
+	cfg := currentConfig()
+	cfg.TreeMaxDepth = 3
+	require.NoError(t, setConfig(cfg))
+
+	require.NoError(t, store.SetSelected([]uint64{10}, true))
+	var walked, selected []string
+	err := store.walkTree(10, "a", func(e *Entry, relPath string) (bool, error) {
+		walked = append(walked, relPath)
+		return true, nil
+	})
+	require.ErrorIs(t, err, errTreeTruncated)
+	cfg.TreeMaxDepth = 128
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, store.walkTree(10, "a", func(e *Entry, relPath string) (bool, error) {
+		if e.Selected {
+			selected = append(selected, relPath)
+		}
+		return true, nil
+	}))
+	assert.Equal(t, walked, selected, "selecting reaches what a walk visits")
+}
@@ -0,0 +1,242 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TxStore is the unit of work handed to Store.WithTx: its mutations all
+// commit together or not at all. It must not be used once the callback
+// returns, and the callback must not use the Store's own methods for
+// writes, which would wait on the transaction.
+type TxStore struct {
+	s    *Store
+	tx   *sql.Tx
+	dirs []uint64 // directory sizes to invalidate on commit
+}
+
+// WithTx runs fn in one transaction, committing if it returns nil and
+// rolling back otherwise. fn's error is returned as is.
+func (s *Store) WithTx(fn func(tx *TxStore) error) error {
+	tx, err := s.begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	t := &TxStore{s: s, tx: tx}
+	if err := fn(t); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	for _, dir := range t.dirs {
+		s.dirSizes.invalidate(dir)
+	}
+	return nil
+}
+
+// invalidateDirSizeOf is Store.invalidateDirSizeOf deferred to commit,
+// looking the entry up as the transaction sees it.
+func (t *TxStore) invalidateDirSizeOf(inode uint64, self bool) {
+	if t.s.dirSizes.empty() {
+		return
+	}
+	var parent uint64
+	var typ string
+	if err := t.tx.QueryRow("SELECT parent_ino, type FROM entries WHERE inode = ?", inode).Scan(&parent, &typ); err != nil {
+		return
+	}
+	if self && typ == "dir" {
+		t.dirs = append(t.dirs, inode)
+	}
+	t.dirs = append(t.dirs, parent)
+}
+
+// UpsertEntry is Store.UpsertEntry within the transaction.
+func (t *TxStore) UpsertEntry(e Entry) error {
+	sub("store").Debug("UpsertEntry", "inode", e.Inode, "parentIno", e.ParentIno, "name", e.Name, "type", e.Type, "selected", e.Selected)
+	if _, err := t.tx.Exec(upsertEntrySQL, upsertEntryArgs(&e, nowNano())...); err != nil {
+		return fmt.Errorf("upsert entry: %w", err)
+	}
+	t.dirs = append(t.dirs, e.ParentIno)
+	return nil
+}
+
+// UpdateEntryMtime is Store.UpdateEntryMtime within the transaction.
+func (t *TxStore) UpdateEntryMtime(inode uint64, mtime int64, size *int64) error {
+	sub("store").Debug("UpdateEntryMtime", "inode", inode, "mtime", mtime, "size", size)
+	if _, err := t.tx.Exec(`UPDATE entries SET mtime = ?, size = ?, updated_at = ? WHERE inode = ?`, mtime, size, nowNano(), inode); err != nil {
+		return fmt.Errorf("update entry mtime: %w", err)
+	}
+	t.invalidateDirSizeOf(inode, false)
+	return nil
+}
+
+// TombstoneEntry marks a lost entry deleted. The row, with its flags and
+// tags, stays until PurgeTombstones drops it or the path or inode is
+// registered again.
+func (t *TxStore) TombstoneEntry(inode uint64) error {
+	sub("store").Debug("TombstoneEntry", "inode", inode)
+	t.invalidateDirSizeOf(inode, true)
+	now := nowNano()
+	if _, err := t.tx.Exec("UPDATE entries SET deleted_at = ?, updated_at = ? WHERE inode = ?", now, now, inode); err != nil {
+		return fmt.Errorf("tombstone entry: %w", err)
+	}
+	return nil
+}
+
+// purgeTombstoneAt drops a tombstone at parentIno/name, so a live entry
+// can take its name.
+func (t *TxStore) purgeTombstoneAt(parentIno uint64, name string) error {
+	if _, err := t.tx.Exec("DELETE FROM entries WHERE parent_ino = ? AND name = ? AND deleted_at != 0", parentIno, name); err != nil {
+		return fmt.Errorf("purge tombstone: %w", err)
+	}
+	return nil
+}
+
+// DeleteEntry is Store.DeleteEntry within the transaction.
+func (t *TxStore) DeleteEntry(inode uint64) error {
+	sub("store").Debug("DeleteEntry", "inode", inode)
+	t.invalidateDirSizeOf(inode, true)
+	if _, err := t.tx.Exec("DELETE FROM entries WHERE inode = ?", inode); err != nil {
+		return fmt.Errorf("delete entry: %w", err)
+	}
+	return nil
+}
+
+// MoveEntry is Store.MoveEntry within the transaction.
+func (t *TxStore) MoveEntry(inode, newParentIno uint64, newName string) error {
+	sub("store").Debug("MoveEntry", "inode", inode, "newParentIno", newParentIno, "newName", newName)
+	t.invalidateDirSizeOf(inode, true)
+	if err := t.purgeTombstoneAt(newParentIno, newName); err != nil {
+		return err
+	}
+	res, err := t.tx.Exec(`UPDATE entries SET parent_ino = ?, name = ?, updated_at = ? WHERE inode = ?`, newParentIno, newName, nowNano(), inode)
+	if err != nil {
+		return fmt.Errorf("move entry: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("move entry: inode %d not found", inode)
+	}
+	if _, err := t.tx.Exec(`UPDATE spaces_view SET checked_at = ? WHERE entry_ino = ?`, nowNano(), inode); err != nil {
+		return fmt.Errorf("move spaces view: %w", err)
+	}
+	t.dirs = append(t.dirs, newParentIno)
+	return nil
+}
+
+// UpsertSpacesView is Store.UpsertSpacesView within the transaction.
+func (t *TxStore) UpsertSpacesView(sv SpacesView) error {
+	sub("store").Debug("UpsertSpacesView", "entryIno", sv.EntryIno, "syncedMtime", sv.SyncedMtime)
+	if _, err := t.tx.Exec(upsertSpacesViewSQL, sv.EntryIno, sv.SyncedMtime, sv.CheckedAt, sv.Cipher, sv.Compression); err != nil {
+		return fmt.Errorf("upsert spaces view: %w", err)
+	}
+	t.invalidateDirSizeOf(sv.EntryIno, false)
+	return nil
+}
+
+// DeleteSpacesView is Store.DeleteSpacesView within the transaction.
+func (t *TxStore) DeleteSpacesView(entryIno uint64) error {
+	sub("store").Debug("DeleteSpacesView", "entryIno", entryIno)
+	if _, err := t.tx.Exec("DELETE FROM spaces_view WHERE entry_ino = ?", entryIno); err != nil {
+		return fmt.Errorf("delete spaces view: %w", err)
+	}
+	t.invalidateDirSizeOf(entryIno, false)
+	return nil
+}
+
+// RecordSelectionTrash notes where P3 trashed the Spaces copy of inode,
+// on the latest deselect in the undo log that covered it, so undoing that
+// deselect can put the copy back.
+func (t *TxStore) RecordSelectionTrash(inode uint64, trashPath string) error {
+	if _, err := t.tx.Exec(`
+		UPDATE selection_op_entries SET trash_path = ?
+		WHERE entry_ino = ? AND op_id = (
+			SELECT MAX(o.id) FROM selection_ops o JOIN selection_op_entries e ON e.op_id = o.id
+			WHERE e.entry_ino = ? AND o.selected = 0
+		)
+	`, trashPath, inode, inode); err != nil {
+		return fmt.Errorf("record selection trash: %w", err)
+	}
+	return nil
+}
+
+// MarkPlaceholder is Store.MarkPlaceholder within the transaction.
+func (t *TxStore) MarkPlaceholder(inode uint64) error {
+	if _, err := t.tx.Exec(`INSERT OR IGNORE INTO placeholders (entry_ino) VALUES (?)`, inode); err != nil {
+		return fmt.Errorf("mark placeholder: %w", err)
+	}
+	return nil
+}
+
+// ClearPlaceholder is Store.ClearPlaceholder within the transaction.
+func (t *TxStore) ClearPlaceholder(inode uint64) error {
+	if _, err := t.tx.Exec(`DELETE FROM placeholders WHERE entry_ino = ?`, inode); err != nil {
+		return fmt.Errorf("clear placeholder: %w", err)
+	}
+	return nil
+}
+
+// RegisterConflict is Store.RegisterConflict within the transaction.
+func (t *TxStore) RegisterConflict(c Conflict, winner *Entry, winnerSV *SpacesView) (int64, error) {
+	sub("store").Debug("RegisterConflict", "path", c.Path, "conflictIno", c.ConflictIno, "conflictName", c.ConflictName)
+	if c.Time == 0 {
+		c.Time = nowNano()
+	}
+	var parentIno uint64
+	if err := t.tx.QueryRow("SELECT parent_ino FROM entries WHERE inode = ?", c.ConflictIno).Scan(&parentIno); err != nil {
+		return 0, fmt.Errorf("conflict entry %d: %w", c.ConflictIno, err)
+	}
+	if err := t.purgeTombstoneAt(parentIno, c.ConflictName); err != nil {
+		return 0, err
+	}
+	if _, err := t.tx.Exec("UPDATE entries SET name = ?, updated_at = ? WHERE inode = ?", c.ConflictName, nowNano(), c.ConflictIno); err != nil {
+		return 0, fmt.Errorf("rename conflict entry: %w", err)
+	}
+	if winner != nil {
+		c.WinnerIno = winner.Inode
+		// The winner is a fresh copy, so another entry holding its inode
+		// is for a file deleted since, whose inode the disk reused.
+		t.invalidateDirSizeOf(winner.Inode, true)
+		res, err := t.tx.Exec("DELETE FROM entries WHERE inode = ? AND NOT (parent_ino = ? AND name = ?)", winner.Inode, winner.ParentIno, winner.Name)
+		if err != nil {
+			return 0, fmt.Errorf("drop stale winner inode: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			if _, err := t.tx.Exec("DELETE FROM spaces_view WHERE entry_ino = ?", winner.Inode); err != nil {
+				return 0, fmt.Errorf("drop stale winner inode: %w", err)
+			}
+		}
+		if _, err := t.tx.Exec(upsertEntrySQL, upsertEntryArgs(winner, nowNano())...); err != nil {
+			return 0, fmt.Errorf("register conflict winner: %w", err)
+		}
+	}
+	if winnerSV != nil {
+		// The Spaces file at the original path now belongs to the winner.
+		if _, err := t.tx.Exec("DELETE FROM spaces_view WHERE entry_ino = ?", c.ConflictIno); err != nil {
+			return 0, fmt.Errorf("move spaces view: %w", err)
+		}
+		if _, err := t.tx.Exec(upsertSpacesViewSQL, winnerSV.EntryIno, winnerSV.SyncedMtime, winnerSV.CheckedAt, winnerSV.Cipher, winnerSV.Compression); err != nil {
+			return 0, fmt.Errorf("winner spaces view: %w", err)
+		}
+	}
+	res, err := t.tx.Exec(`
+		INSERT INTO conflicts (time, path, conflict_ino, conflict_name, winner_ino) VALUES (?, ?, ?, ?, ?)
+	`, c.Time, c.Path, c.ConflictIno, c.ConflictName, c.WinnerIno)
+	if err != nil {
+		return 0, fmt.Errorf("insert conflict: %w", err)
+	}
+	t.dirs = append(t.dirs, parentIno)
+	return res.LastInsertId()
+}
+
+// CompleteIntent is Store.CompleteIntent within the transaction, so a
+// journaled step's DB update and the removal of its intent land together.
+func (t *TxStore) CompleteIntent(id int64) error {
+	if _, err := t.tx.Exec("DELETE FROM journal WHERE id = ?", id); err != nil {
+		return fmt.Errorf("complete intent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTx_CommitsTogether(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "d", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 2, ParentIno: 1, Name: "a.txt", Type: "text", Size: ptr(int64(5)), Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 2, SyncedMtime: 1}))
+	before, err := store.DirSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), before.TotalBytes)
+
+	err = store.WithTx(func(tx *TxStore) error {
+		if err := tx.UpdateEntryMtime(2, 2, ptr(int64(9))); err != nil {
+			return err
+		}
+		return tx.UpsertSpacesView(SpacesView{EntryIno: 2, SyncedMtime: 2})
+	})
+	require.NoError(t, err)
+
+	e, err := store.GetEntry(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), e.Mtime)
+	sv, err := store.GetSpacesView(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), sv.SyncedMtime)
+	after, err := store.DirSize(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), after.TotalBytes, "cached dir size is invalidated on commit")
+}
+
+func TestWithTx_RollsBackOnError(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Size: ptr(int64(5)), Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 1, SyncedMtime: 1}))
+
+	boom := errors.New("boom")
+	err := store.WithTx(func(tx *TxStore) error {
+		if err := tx.DeleteSpacesView(1); err != nil {
+			return err
+		}
+		if err := tx.DeleteEntry(1); err != nil {
+			return err
+		}
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+
+	e, err := store.GetEntry(1)
+	require.NoError(t, err)
+	assert.NotNil(t, e)
+	sv, err := store.GetSpacesView(1)
+	require.NoError(t, err)
+	assert.NotNil(t, sv)
+}
+
+func TestJournaled_FailedDBStepKeepsIntent(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Size: ptr(int64(5)), Mtime: 1}))
+	require.NoError(t, store.UpsertSpacesView(SpacesView{EntryIno: 1, SyncedMtime: 1}))
+
+	err := journaled(store, Intent{Op: IntentTrash, Path: "a.txt", Inode: 1},
+		func() error { return nil },
+		func(tx *TxStore) error {
+			if err := tx.DeleteSpacesView(1); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		})
+	require.Error(t, err)
+
+	// Nothing half-applied: the view is still there and the intent is left
+	// for replayJournal to finish.
+	sv, err := store.GetSpacesView(1)
+	require.NoError(t, err)
+	assert.NotNil(t, sv)
+	intents, err := store.ListIntents()
+	require.NoError(t, err)
+	require.Len(t, intents, 1)
+	assert.True(t, intents[0].Done)
+}
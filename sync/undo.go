@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UndoResponse is the body of POST /api/sync/undo.
+type UndoResponse struct {
+	ID       int64 `json:"id"`
+	Selected bool  `json:"selected"` // what the undone operation had set
+	Changed  int   `json:"changed"`  // entries given their previous flag back
+	Restored int   `json:"restored"` // trashed Spaces copies moved back
+}
+
+// HandleUndo handles POST /api/sync/undo
+// It reverts the most recent select or deselect. Undoing a deselect moves
+// the Spaces copies it trashed back in place when they still match the
+// Archives file; the pipeline copies the rest again.
+func (h *Handlers) HandleUndo(w http.ResponseWriter, r *http.Request) {
+	l := sub("handlers")
+	h = h.forRequest(r)
+	op, err := h.store.UndoSelection()
+	if err != nil {
+		l.Error("undo failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if op == nil {
+		http.Error(w, "nothing to undo", http.StatusNotFound)
+		return
+	}
+
+	restored := 0
+	if !op.Selected {
+		restored = h.restoreTrashed(op.Changes)
+	}
+	for _, c := range op.Changes {
+		entry, err := h.store.GetEntry(c.Inode)
+		if err != nil || entry == nil {
+			continue
+		}
+		if relPath := h.resolveRelPath(entry); relPath != "" {
+			h.daemon.Queue().PushTraced(r.Context(), relPath, sizeOrZero(entry.Size), entry.Type == "dir")
+		}
+	}
+	h.daemon.pokeWatchScope()
+
+	l.Info("HTTP undo complete", "op", op.ID, "selected", op.Selected, "changed", len(op.Changes), "restored", restored)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UndoResponse{ //nolint:errcheck
+		ID:       op.ID,
+		Selected: op.Selected,
+		Changed:  len(op.Changes),
+		Restored: restored,
+	})
+}
+
+// restoreTrashed moves the trashed Spaces copies of re-selected entries
+// back to their Spaces paths, parents first so a restored directory
+// brings its contents along. A copy is skipped if its path is taken, it
+// left the trash, or, for files, its mtime no longer matches the entry.
+func (h *Handlers) restoreTrashed(changes []SelectionChange) int {
+	l := sub("handlers")
+	type restore struct {
+		entry     *Entry
+		relPath   string
+		trashPath string
+	}
+	var todo []restore
+	for _, c := range changes {
+		if c.TrashPath == "" {
+			continue
+		}
+		entry, err := h.store.GetEntry(c.Inode)
+		if err != nil || entry == nil || !entry.Selected {
+			continue
+		}
+		if relPath := h.resolveRelPath(entry); relPath != "" {
+			todo = append(todo, restore{entry, relPath, c.TrashPath})
+		}
+	}
+	sort.Slice(todo, func(i, j int) bool {
+		return strings.Count(todo[i].relPath, "/") < strings.Count(todo[j].relPath, "/")
+	})
+
+	restored := 0
+	for _, r := range todo {
+		dst := filepath.Join(h.spacesRoot, r.relPath)
+		fs := fsFor(dst)
+		if _, err := fs.Stat(dst); err == nil {
+			continue
+		}
+		info, err := fsFor(r.trashPath).Stat(r.trashPath)
+		if err != nil {
+			continue
+		}
+		if r.entry.Type != "dir" && !sameMtime(info.ModTime().UnixNano(), r.entry.Mtime, max(mtimeGrain(r.trashPath), mtimeGrain(h.archivesRoot))) {
+			l.Debug("undo: trashed copy is stale", "path", r.relPath, "trashPath", r.trashPath)
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			l.Warn("undo: mkdir failed", "path", r.relPath, "err", err)
+			continue
+		}
+		if err := fs.Rename(r.trashPath, dst); err != nil {
+			l.Warn("undo: restore failed", "path", r.relPath, "trashPath", r.trashPath, "err", err)
+			continue
+		}
+		setSpacesOwnerTree(dst, filepath.Join(h.archivesRoot, r.relPath))
+		l.Info("undo: restored from trash", "path", r.relPath, "trashPath", r.trashPath)
+		restored++
+	}
+	return restored
+}
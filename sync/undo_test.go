@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drainQueue runs the pipeline for everything queued.
+func drainQueue(t *testing.T, h *Handlers, store *Store, archivesRoot, spacesRoot string) {
+	t.Helper()
+	trash := filepath.Join(filepath.Dir(spacesRoot), ".trash")
+	q := h.daemon.Queue()
+	for q.Len() > 0 {
+		j, ok := q.PopJob(nil)
+		require.True(t, ok)
+		require.NoError(t, RunPipeline(context.Background(), j.Path, store, archivesRoot, spacesRoot, trash, nil))
+	}
+}
+
+func TestUndo_DeselectRestoresTrashedCopies(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Photos/", "Photos/a.jpg", "Photos/b.jpg"}, nil)
+	dir, err := h.resolvePathToIno("Photos")
+	require.NoError(t, err)
+	body := fmt.Sprintf(`{"inodes":[%d]}`, dir)
+
+	require.Equal(t, http.StatusOK, postJSON(h.HandleSelect, "/api/sync/select", body).Code)
+	drainQueue(t, h, store, archivesRoot, spacesRoot)
+	require.FileExists(t, filepath.Join(spacesRoot, "Photos/a.jpg"))
+
+	require.Equal(t, http.StatusOK, postJSON(h.HandleDeselect, "/api/sync/deselect", body).Code)
+	drainQueue(t, h, store, archivesRoot, spacesRoot)
+	require.NoFileExists(t, filepath.Join(spacesRoot, "Photos/a.jpg"))
+
+	w := postJSON(h.HandleUndo, "/api/sync/undo", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp UndoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.Selected)
+	assert.Equal(t, 3, resp.Changed)
+	assert.Positive(t, resp.Restored)
+	assert.FileExists(t, filepath.Join(spacesRoot, "Photos/a.jpg"))
+	assert.FileExists(t, filepath.Join(spacesRoot, "Photos/b.jpg"))
+
+	drainQueue(t, h, store, archivesRoot, spacesRoot)
+	for _, p := range []string{"Photos", "Photos/a.jpg", "Photos/b.jpg"} {
+		e, _, err := lookupDB(store, archivesRoot, p)
+		require.NoError(t, err)
+		assert.True(t, e.Selected, p)
+		sv, err := store.GetSpacesView(e.Inode)
+		require.NoError(t, err)
+		assert.NotNil(t, sv, p)
+	}
+
+	// Undoing the select before it deselects again; then the log is empty.
+	w = postJSON(h.HandleUndo, "/api/sync/undo", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	e, _, err := lookupDB(store, archivesRoot, "Photos/a.jpg")
+	require.NoError(t, err)
+	assert.False(t, e.Selected)
+	assert.Equal(t, http.StatusNotFound, postJSON(h.HandleUndo, "/api/sync/undo", "").Code)
+}
+
+func TestUndo_SkipsStaleTrashedCopy(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"a.txt"}, nil)
+	ino, err := h.resolvePathToIno("a.txt")
+	require.NoError(t, err)
+	body := fmt.Sprintf(`{"inodes":[%d]}`, ino)
+	require.Equal(t, http.StatusOK, postJSON(h.HandleSelect, "/api/sync/select", body).Code)
+	drainQueue(t, h, store, archivesRoot, spacesRoot)
+	require.Equal(t, http.StatusOK, postJSON(h.HandleDeselect, "/api/sync/deselect", body).Code)
+	drainQueue(t, h, store, archivesRoot, spacesRoot)
+
+	// The Archives file changes after the copy was trashed.
+	require.NoError(t, store.UpdateEntryMtime(ino, 42, ptr(int64(1))))
+
+	w := postJSON(h.HandleUndo, "/api/sync/undo", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp UndoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Zero(t, resp.Restored)
+	_, err = os.Stat(filepath.Join(spacesRoot, "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSetSelectedUndoable_LogsOnlyChanges(t *testing.T) {
+	store := setupTestDB(t)
+	seedSelectionTree(t, store)
+
+	id, err := store.SetSelectedUndoable([]uint64{1}, false, nil)
+	require.NoError(t, err)
+	assert.Zero(t, id, "nothing was selected")
+
+	require.NoError(t, store.SetSelected([]uint64{2}, true))
+	id, err = store.SetSelectedUndoable([]uint64{1}, true, nil)
+	require.NoError(t, err)
+	require.NotZero(t, id)
+
+	op, err := store.UndoSelection()
+	require.NoError(t, err)
+	require.NotNil(t, op)
+	assert.Equal(t, id, op.ID)
+	for ino, want := range map[uint64]bool{1: false, 2: true, 3: false, 4: false} {
+		e, err := store.GetEntry(ino)
+		require.NoError(t, err)
+		assert.Equal(t, want, e.Selected, "inode %d", ino)
+	}
+
+	for i := 0; i < selectionUndoDepth+5; i++ {
+		_, err := store.SetSelectedUndoable([]uint64{1}, i%2 == 0, nil)
+		require.NoError(t, err)
+	}
+	n := 0
+	for {
+		op, err := store.UndoSelection()
+		require.NoError(t, err)
+		if op == nil {
+			break
+		}
+		n++
+	}
+	assert.Equal(t, selectionUndoDepth, n)
+}
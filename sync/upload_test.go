@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func uploadRequest(t *testing.T, query string, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		fw.Write([]byte(content)) //nolint:errcheck
+	}
+	require.NoError(t, mw.Close())
+	req := httptest.NewRequest("POST", "/api/sync/upload?"+query, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandleUpload_RegistersAndSelects(t *testing.T) {
+	h, store, archivesRoot, _ := setupHandlersEnv(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "Docs"), 0755))
+	info, err := os.Stat(filepath.Join(archivesRoot, "Docs"))
+	require.NoError(t, err)
+	docsIno := info.Sys().(*syscall.Stat_t).Ino
+	require.NoError(t, store.UpsertEntry(Entry{Inode: docsIno, Name: "Docs", Type: "dir", Mtime: 1}))
+
+	w := httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "path=/Docs&select=1", map[string]string{"notes.md": "# hi"}))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	data, err := os.ReadFile(filepath.Join(archivesRoot, "Docs", "notes.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# hi", string(data))
+	_, err = os.Stat(filepath.Join(archivesRoot, "Docs", "notes.md.sync-tmp"))
+	assert.True(t, os.IsNotExist(err), "tmp file renamed away")
+
+	e, err := store.GetEntryByPath(docsIno, "notes.md")
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.True(t, e.Selected)
+	assert.Equal(t, "text", e.Type)
+	assert.True(t, h.daemon.Queue().Has("Docs/notes.md"))
+
+	// Existing files are not overwritten unless asked
+	w = httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "path=Docs", map[string]string{"notes.md": "new"}))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "path=Docs&overwrite=1", map[string]string{"notes.md": "new"}))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	data, _ = os.ReadFile(filepath.Join(archivesRoot, "Docs", "notes.md"))
+	assert.Equal(t, "new", string(data))
+}
+
+func TestHandleUpload_Rejects(t *testing.T) {
+	restoreConfig(t)
+	h, _, archivesRoot, _ := setupHandlersEnv(t)
+
+	w := httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "path=Missing", map[string]string{"a.txt": "a"}))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "", map[string]string{"..": "a"}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Traversal in path is refused, not clamped to the root
+	w = httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "path=../..", map[string]string{"root.txt": "r"}))
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	_, err := os.Stat(filepath.Join(archivesRoot, "root.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	cfg := currentConfig()
+	cfg.UploadMaxBytes = 64
+	require.NoError(t, setConfig(cfg))
+	w = httptest.NewRecorder()
+	h.HandleUpload(w, uploadRequest(t, "", map[string]string{"big.bin": string(make([]byte, 4096))}))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	_, err = os.Stat(filepath.Join(archivesRoot, "big.bin"))
+	assert.True(t, os.IsNotExist(err))
+}
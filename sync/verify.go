@@ -0,0 +1,235 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	gosync "sync"
+	"sync/atomic"
+)
+
+// Reading every copy back doubles the IO of a sync, so copies between the
+// roots are verified by sample: every file of at least verify.minMB, and
+// verify.percent of the rest. A sampled copy is hashed against its source
+// before it is renamed into place, decoding Spaces content on either side,
+// so a bad copy never replaces the destination and the copy fails to be
+// retried. Outcomes are counted for the stats and kept per entry, in the
+// copy_verifications table; the pending ones are flushed with the copy
+// counters. Transcoded derivatives differ from their original by design
+// and are not verified.
+
+// CopyVerify configures the sampled verification of copies.
+type CopyVerify struct {
+	Percent int `json:"percent" yaml:"percent" toml:"percent"` // share of copies read back and compared, 0 = none
+	MinMB   int `json:"minMB" yaml:"minMB" toml:"minMB"`       // always verify copies of files this large (MiB), 0 = by percent only
+}
+
+func (v CopyVerify) validate() error {
+	if v.Percent < 0 || v.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, got %d", v.Percent)
+	}
+	if v.MinMB < 0 {
+		return fmt.Errorf("minMB must not be negative, got %d", v.MinMB)
+	}
+	return nil
+}
+
+// Verification is the outcome of verifying the latest copy of an entry.
+type Verification struct {
+	OK        bool        `json:"ok"`
+	Direction IODirection `json:"direction"`
+	At        int64       `json:"at"` // nanoseconds
+}
+
+// VerifyStats counts verified copies since the daemon started.
+type VerifyStats struct {
+	Verified int64 `json:"verified"` // copies found identical to their source
+	Failed   int64 `json:"failed"`   // copies that differed and were discarded
+	Bytes    int64 `json:"bytes"`    // source bytes read back to verify
+}
+
+// copyVerifier counts verifications and holds the per-entry outcomes not
+// yet flushed into the store; a nil outcome clears the entry's, as its
+// newest copy went unverified.
+type copyVerifier struct {
+	verified atomic.Int64
+	failed   atomic.Int64
+	bytes    atomic.Int64
+
+	mu      gosync.Mutex
+	pending map[uint64]*Verification
+}
+
+// verifications records the copies verified by copyToSpaces and
+// copyFromSpaces.
+var verifications = &copyVerifier{pending: make(map[uint64]*Verification)}
+
+// verifySampled reports whether a copy of a file of size bytes is
+// verified.
+func verifySampled(size int64) bool {
+	cfg := activeConfig.Load().Verify
+	if cfg.MinMB > 0 && size >= int64(cfg.MinMB)<<20 {
+		return true
+	}
+	return cfg.Percent > 0 && rand.IntN(100) < cfg.Percent
+}
+
+// verifyCheck returns a safeCopyChecked check comparing the content want
+// opens with what got makes of the temp file, and reporting the outcome
+// to done.
+func verifyCheck(relPath string, want func() (io.ReadCloser, error), got func(tmpPath string) (io.ReadCloser, error), done func(ok bool)) func(string) error {
+	return func(tmpPath string) error {
+		wantSum, n, err := readSHA256(want())
+		if err != nil {
+			return fmt.Errorf("verify: read source: %w", err)
+		}
+		gotSum, _, err := readSHA256(got(tmpPath))
+		if err != nil {
+			return fmt.Errorf("verify: read copy: %w", err)
+		}
+		verifications.bytes.Add(n)
+		ok := bytes.Equal(wantSum, gotSum)
+		done(ok)
+		if !ok {
+			return fmt.Errorf("verify: copy of %s differs from its source", relPath)
+		}
+		return nil
+	}
+}
+
+// readSHA256 hashes and closes what open returned.
+func readSHA256(rc io.ReadCloser, err error) ([]byte, int64, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, progressReader{rc})
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), n, nil
+}
+
+// record counts a copy in direction dir of the Archives file at
+// archivePath, verified or (for nil ok) not, as the outcome of its entry.
+func (v *copyVerifier) record(archivePath string, dir IODirection, ok *bool) {
+	var out *Verification
+	if ok != nil {
+		if *ok {
+			v.verified.Add(1)
+		} else {
+			v.failed.Add(1)
+			sub("verify").Error("copy verification failed", "path", archivePath, "direction", dir)
+		}
+		out = &Verification{OK: *ok, Direction: dir, At: nowNano()}
+	}
+	_, _, ino, _ := statFile(archivePath)
+	if ino == nil {
+		return
+	}
+	v.mu.Lock()
+	v.pending[*ino] = out
+	v.mu.Unlock()
+}
+
+// lookup returns the pending outcome for ino, if any.
+func (v *copyVerifier) lookup(ino uint64) (*Verification, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out, ok := v.pending[ino]
+	return out, ok
+}
+
+// take returns and clears the pending outcomes.
+func (v *copyVerifier) take() map[uint64]*Verification {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	pending := v.pending
+	v.pending = make(map[uint64]*Verification)
+	return pending
+}
+
+// put returns outcomes to pending that failed to flush, unless newer ones
+// came in meanwhile.
+func (v *copyVerifier) put(outcomes map[uint64]*Verification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for ino, out := range outcomes {
+		if _, ok := v.pending[ino]; !ok {
+			v.pending[ino] = out
+		}
+	}
+}
+
+func (v *copyVerifier) stats() VerifyStats {
+	return VerifyStats{Verified: v.verified.Load(), Failed: v.failed.Load(), Bytes: v.bytes.Load()}
+}
+
+// flushVerifications writes the pending per-entry outcomes to the store.
+func (d *Daemon) flushVerifications() error {
+	pending := verifications.take()
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := d.store.SetVerifications(pending); err != nil {
+		verifications.put(pending) // retried on the next flush
+		return err
+	}
+	return nil
+}
+
+// verification returns the outcome of verifying the latest copy of ino,
+// nil if it was not sampled.
+func (h *Handlers) verification(ino uint64) (*Verification, error) {
+	if out, ok := verifications.lookup(ino); ok {
+		return out, nil
+	}
+	return h.store.Verification(ino)
+}
+
+// SetVerifications records the outcomes of verified copies per entry
+// inode, clearing those given as nil. Inodes no longer registered are
+// ignored.
+func (s *Store) SetVerifications(outcomes map[uint64]*Verification) error {
+	err := s.WithTx(func(t *TxStore) error {
+		for ino, out := range outcomes {
+			if out == nil {
+				if _, err := t.tx.Exec("DELETE FROM copy_verifications WHERE entry_ino = ?", ino); err != nil {
+					return err
+				}
+				continue
+			}
+			_, err := t.tx.Exec(`
+				INSERT INTO copy_verifications (entry_ino, ok, direction, verified_at)
+				SELECT ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM entries WHERE inode = ?)
+				ON CONFLICT(entry_ino) DO UPDATE SET ok = excluded.ok, direction = excluded.direction, verified_at = excluded.verified_at
+			`, ino, out.OK, out.Direction, out.At, ino)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set verifications: %w", err)
+	}
+	return nil
+}
+
+// Verification returns the recorded outcome for ino, nil if none.
+func (s *Store) Verification(ino uint64) (*Verification, error) {
+	var out Verification
+	err := s.rdb.QueryRowContext(s.context(), "SELECT ok, direction, verified_at FROM copy_verifications WHERE entry_ino = ?", ino).
+		Scan(&out.OK, &out.Direction, &out.At)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get verification %d: %w", ino, err)
+	}
+	return &out, nil
+}
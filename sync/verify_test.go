@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetVerifications gives the test fresh verification counters.
+func resetVerifications(t *testing.T) {
+	prev := verifications
+	verifications = &copyVerifier{pending: make(map[uint64]*Verification)}
+	t.Cleanup(func() { verifications = prev })
+}
+
+func TestVerifySampled(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.Verify = CopyVerify{}
+	require.NoError(t, setConfig(cfg))
+	assert.False(t, verifySampled(10<<30))
+
+	cfg.Verify = CopyVerify{MinMB: 1}
+	require.NoError(t, setConfig(cfg))
+	assert.True(t, verifySampled(1<<20))
+	assert.False(t, verifySampled(1<<20-1))
+
+	cfg.Verify = CopyVerify{Percent: 100}
+	require.NoError(t, setConfig(cfg))
+	assert.True(t, verifySampled(1))
+}
+
+func TestPipeline_VerifiesSampledCopies(t *testing.T) {
+	restoreConfig(t)
+	resetVerifications(t)
+	cfg := currentConfig()
+	cfg.Verify = CopyVerify{Percent: 100}
+	require.NoError(t, setConfig(cfg))
+
+	env := setupPipelineEnv(t)
+	env.writeArchive(t, "a.txt", []byte("hello"))
+	env.run(t, "a.txt")
+	entry := registered(t, env.store, env.archivesRoot, "a.txt")
+	require.NoError(t, env.store.SetSelected([]uint64{entry.Inode}, true))
+	env.run(t, "a.txt")
+	assert.FileExists(t, filepath.Join(env.spacesRoot, "a.txt"))
+	assert.Equal(t, VerifyStats{Verified: 1, Bytes: 5}, verifications.stats())
+
+	h := NewHandlers(env.store, NewDaemon(env.store, env.archivesRoot, env.spacesRoot), env.archivesRoot, env.spacesRoot)
+	require.NoError(t, h.daemon.flushVerifications())
+	got, err := env.store.Verification(entry.Inode)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.True(t, got.OK)
+	assert.Equal(t, IOToSpaces, got.Direction)
+
+	w := httptest.NewRecorder()
+	h.HandleGetEntry(w, httptest.NewRequest("GET", "/api/sync/entry/"+strconv.FormatUint(entry.Inode, 10), nil))
+	var resp SyncEntryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotNil(t, resp.Verification)
+	assert.True(t, resp.Verification.OK)
+}
+
+func TestSafeCopyChecked_MismatchKeepsDestination(t *testing.T) {
+	resetVerifications(t)
+	dir := t.TempDir()
+	src, dst, other := filepath.Join(dir, "src"), filepath.Join(dir, "dst"), filepath.Join(dir, "other")
+	require.NoError(t, os.WriteFile(src, []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(dst, []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(other, []byte("corrupt"), 0644))
+
+	var verified *bool
+	check := verifyCheck("src", func() (io.ReadCloser, error) { return os.Open(other) },
+		func(tmpPath string) (io.ReadCloser, error) { return os.Open(tmpPath) },
+		func(ok bool) { verified = &ok })
+	err := safeCopyChecked(context.Background(), src, dst, nil, nil, check)
+	require.Error(t, err)
+	require.NotNil(t, verified)
+	assert.False(t, *verified)
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(got))
+	assert.NoFileExists(t, dst+".sync-tmp")
+
+	verifications.record(dst, IOToArchives, verified)
+	assert.Equal(t, int64(1), verifications.stats().Failed)
+}
+
+func TestStore_Verifications(t *testing.T) {
+	store := setupTestDB(t)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 1, Name: "a.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.SetVerifications(map[uint64]*Verification{
+		1: {OK: false, Direction: IOToArchives, At: 42},
+		2: {OK: true, Direction: IOToSpaces, At: 42}, // not registered
+	}))
+	got, err := store.Verification(1)
+	require.NoError(t, err)
+	assert.Equal(t, &Verification{OK: false, Direction: IOToArchives, At: 42}, got)
+	got, err = store.Verification(2)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	require.NoError(t, store.SetVerifications(map[uint64]*Verification{1: nil}))
+	got, err = store.Verification(1)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestCopyVerify_Validate(t *testing.T) {
+	assert.NoError(t, CopyVerify{}.validate())
+	assert.NoError(t, CopyVerify{Percent: 5, MinMB: 1024}.validate())
+	assert.Error(t, CopyVerify{Percent: 101}.validate())
+	assert.Error(t, CopyVerify{MinMB: -1}.validate())
+}
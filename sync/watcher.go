@@ -4,27 +4,118 @@ import (
 	"context"
 	"io/fs"
 	"log/slog"
+	"os"
 	"path/filepath"
 	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-const debounceInterval = 300 * time.Millisecond
+const debounceInterval = 300 * time.Millisecond // default, see Config.DebounceMs
+
+// scopeRefreshInterval is how often a scoped watcher re-evaluates its
+// scope, so expired activity drops out without an explicit change.
+const scopeRefreshInterval = time.Minute
+
+// WatchScope is the part of Archives a scoped watcher covers, as paths
+// relative to the Archives root. The root itself is always watched.
+type WatchScope struct {
+	Subtrees []string // watched recursively
+	Dirs     []string // watched without their subdirectories
+}
+
+// WatchBackend selects how the Watcher receives filesystem events.
+type WatchBackend string
+
+const (
+	// BackendFsnotify uses fsnotify (inotify on Linux), one watch per directory.
+	BackendFsnotify WatchBackend = "fsnotify"
+	// BackendFanotify uses Linux fanotify filesystem marks, which report
+	// events for whole filesystems without per-directory watches. Needs
+	// CAP_SYS_ADMIN and CAP_DAC_READ_SEARCH; falls back to fsnotify
+	// when unavailable.
+	BackendFanotify WatchBackend = "fanotify"
+)
+
+// valid reports whether b is a known watcher backend.
+func (b WatchBackend) valid() bool {
+	return b == BackendFsnotify || b == BackendFanotify
+}
+
+// watchBackend is the event source behind a Watcher.
+type watchBackend interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Add(path string) error
+	Remove(path string) error
+	Close() error
+	// WholeTree reports whether events below the roots arrive without
+	// per-directory watches, making Add and Remove no-ops.
+	WholeTree() bool
+}
+
+// fsnotifyBackend adapts fsnotify.Watcher to watchBackend.
+type fsnotifyBackend struct {
+	w *fsnotify.Watcher
+}
+
+func (b fsnotifyBackend) Events() <-chan fsnotify.Event { return b.w.Events }
+func (b fsnotifyBackend) Errors() <-chan error          { return b.w.Errors }
+func (b fsnotifyBackend) Add(path string) error         { return b.w.Add(path) }
+func (b fsnotifyBackend) Remove(path string) error      { return b.w.Remove(path) }
+func (b fsnotifyBackend) Close() error                  { return b.w.Close() }
+func (b fsnotifyBackend) WholeTree() bool               { return false }
+
+// newBackend opens the backend selected by kind, falling back to fsnotify
+// if fanotify is unsupported or lacks privileges.
+func newBackend(kind WatchBackend, roots ...string) (watchBackend, error) {
+	if kind == BackendFanotify {
+		b, err := newFanotifyBackend(roots...)
+		if err == nil {
+			return b, nil
+		}
+		sub("watcher").Warn("fanotify unavailable, falling back to fsnotify", "err", err)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return fsnotifyBackend{w}, nil
+}
 
 // Watcher monitors Archives and Spaces directories for filesystem changes
 // and feeds relative paths into the eval queue.
 type Watcher struct {
 	archivesRoot string
 	spacesRoot   string
+	remoteSpaces bool // Spaces is on another machine and not watched
 	queue        *EvalQueue
-	watcher      *fsnotify.Watcher
+	backend      watchBackend
+
+	// Scoped mode: only the Archives directories in scope() are watched.
+	scope        func() WatchScope
+	scopeChanged <-chan struct{}
+	mu           gosync.RWMutex
+	archived     map[string]bool // watched Archives directories (absolute)
+
+	// Self-test, see watchprobe.go.
+	live    atomic.Bool // watches are up and events are read
+	probeMu gosync.Mutex
+	probes  map[string]chan struct{} // root → probe awaiting its event
 }
 
-// NewWatcher creates a filesystem watcher for both roots.
+// NewWatcher creates a filesystem watcher for both roots, using the
+// backend selected by Config.WatchBackend.
 func NewWatcher(archivesRoot, spacesRoot string, queue *EvalQueue) (*Watcher, error) {
-	w, err := fsnotify.NewWatcher()
+	roots := []string{archivesRoot, spacesRoot}
+	remote := isRemote(spacesRoot)
+	if remote {
+		roots = roots[:1] // the daemon scans remote Spaces instead
+	}
+	b, err := newBackend(activeConfig.Load().WatchBackend, roots...)
 	if err != nil {
 		return nil, err
 	}
@@ -32,39 +123,86 @@ func NewWatcher(archivesRoot, spacesRoot string, queue *EvalQueue) (*Watcher, er
 	return &Watcher{
 		archivesRoot: archivesRoot,
 		spacesRoot:   spacesRoot,
+		remoteSpaces: remote,
 		queue:        queue,
-		watcher:      w,
+		backend:      b,
 	}, nil
 }
 
+// WholeTree reports whether the backend sees every directory below the
+// roots without per-directory watches, so scoping isn't needed.
+func (w *Watcher) WholeTree() bool {
+	return w.backend.WholeTree()
+}
+
+// SetScope switches the watcher to scoped mode: Spaces is still watched
+// fully, Archives only as far as scope returns. The scope is re-evaluated
+// when changed fires and every scopeRefreshInterval. Call before Start.
+func (w *Watcher) SetScope(scope func() WatchScope, changed <-chan struct{}) {
+	w.scope = scope
+	w.scopeChanged = changed
+	w.archived = make(map[string]bool)
+}
+
+// Watched reports whether events under the Archives directory dir are
+// seen. Always true unless the watcher is scoped.
+func (w *Watcher) Watched(dir string) bool {
+	if w.scope == nil || w.backend.WholeTree() {
+		return true
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.archived[dir]
+}
+
 // Start begins watching and debouncing events. Blocks until ctx is cancelled.
 func (w *Watcher) Start(ctx context.Context) error {
 	l := sub("watcher")
 
 	// Add recursive watches
-	if err := w.addRecursive(w.archivesRoot); err != nil {
-		return err
+	var refresh <-chan time.Time
+	switch {
+	case w.backend.WholeTree():
+		l.Info("watching", "root", w.archivesRoot, "type", "archives", "backend", BackendFanotify)
+	case w.scope == nil:
+		if err := w.addRecursive(w.archivesRoot); err != nil {
+			return err
+		}
+		l.Info("watching", "root", w.archivesRoot, "type", "archives")
+	default:
+		w.refreshScope()
+		ticker := time.NewTicker(scopeRefreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
 	}
-	l.Info("watching", "root", w.archivesRoot, "type", "archives")
 
-	if err := w.addRecursive(w.spacesRoot); err != nil {
-		return err
+	if w.remoteSpaces {
+		l.Info("not watching remote Spaces", "root", w.spacesRoot)
+	} else {
+		if !w.backend.WholeTree() {
+			if err := w.addRecursive(w.spacesRoot); err != nil {
+				return err
+			}
+		}
+		l.Info("watching", "root", w.spacesRoot, "type", "spaces")
 	}
-	l.Info("watching", "root", w.spacesRoot, "type", "spaces")
 
-	// Debounce timer and pending paths
-	pending := make(map[string]struct{})
-	timer := time.NewTimer(debounceInterval)
-	timer.Stop()
+	// Per-root debounce batches
+	archives := newEventBatch("archives", false)
+	spaces := newEventBatch("spaces", true)
+	defer archives.timer.Stop()
+	defer spaces.timer.Stop()
 
+	w.live.Store(true)
+	defer w.live.Store(false)
 	for {
 		select {
 		case <-ctx.Done():
-			w.watcher.Close()
+			w.backend.Close()
 			l.Info("watcher stopping")
 			return ctx.Err()
 
-		case event, ok := <-w.watcher.Events:
+		case event, ok := <-w.backend.Events():
 			if !ok {
 				return nil
 			}
@@ -78,52 +216,182 @@ func (w *Watcher) Start(ctx context.Context) error {
 				continue
 			}
 
-			// Skip .sync-conflict and hidden files
-			base := filepath.Base(event.Name)
-			if strings.HasPrefix(base, ".") || strings.Contains(base, ".sync-conflict-") {
+			// Skip .sync-conflict, hidden and temp files, except Syncthing
+			// conflict copies in Spaces, and paths Syncthing ignores
+			inSpaces := w.rootOf(event.Name) == w.spacesRoot
+			if relPath == watchProbeName {
+				w.probeSeen(w.rootOf(event.Name))
+				continue
+			}
+			if isCanary(relPath) {
+				if inSpaces {
+					checkCanary(w.spacesRoot, relPath)
+				}
+				continue
+			}
+			name := filepath.Base(event.Name)
+			if (skipScanName(name) && !(inSpaces && syncthingConflict(name))) || (inSpaces && stIgnoredRel(w.spacesRoot, relPath)) {
 				if logEnabled(slog.LevelDebug) {
-					l.Debug("skip", "name", event.Name, "reason", "hidden or conflict")
+					l.Debug("skip", "name", event.Name, "reason", "hidden, conflict or ignored")
 				}
 				continue
 			}
 
-			pending[relPath] = struct{}{}
+			b := archives
+			if inSpaces {
+				b = spaces
+			}
+			b.add(relPath, event.Op)
 			if logEnabled(slog.LevelDebug) {
-				l.Debug("pending", "path", relPath, "op", event.Op.String())
+				l.Debug("pending", "path", relPath, "op", event.Op.String(), "root", b.name)
 			}
 
-			// Reset debounce timer
-			timer.Reset(debounceInterval)
+			// Flush a full batch now, otherwise reset the debounce timer
+			rq, debounce := activeConfig.Load().rootQueue(b.spaces)
+			if rq.FlushBatch > 0 && b.size() >= rq.FlushBatch {
+				b.timer.Stop()
+				b.flush(w.queue)
+			} else {
+				b.timer.Reset(power.stretch(debounce))
+			}
 
 			// If a new directory was created, add it to watch
-			if event.Has(fsnotify.Create) {
-				if err := w.watcher.Add(event.Name); err == nil {
-					l.Debug("added new dir", "path", event.Name)
+			if event.Has(fsnotify.Create) && !w.backend.WholeTree() {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.backend.Add(event.Name); err == nil {
+						l.Debug("added new dir", "path", event.Name)
+						w.trackArchived(event.Name)
+					}
 				}
 			}
 
-		case err, ok := <-w.watcher.Errors:
+		case <-w.scopeChanged:
+			w.refreshScope()
+
+		case <-refresh:
+			w.refreshScope()
+
+		case err, ok := <-w.backend.Errors():
 			if !ok {
 				return nil
 			}
 			l.Error("watcher error", "err", err)
 
-		case <-timer.C:
-			// Debounce timer fired — flush pending paths to queue
-			if len(pending) > 0 {
-				paths := make([]string, 0, len(pending))
-				for p := range pending {
-					paths = append(paths, p)
-				}
-				w.queue.PushMany(paths)
-				l.Info("flushed", "count", len(paths))
-				if logEnabled(slog.LevelDebug) {
-					l.Debug("flush paths", "paths", paths)
-				}
-				pending = make(map[string]struct{})
-			}
+		case <-archives.timer.C:
+			archives.flush(w.queue)
+
+		case <-spaces.timer.C:
+			spaces.flush(w.queue)
+		}
+	}
+}
+
+// eventBatch collects one root's pending paths between flushes.
+type eventBatch struct {
+	name    string
+	spaces  bool
+	pending map[string]struct{}
+	moves   map[string]string // paired renames, new path → old path
+	renamed *pendingRename
+	timer   *time.Timer
+}
+
+func newEventBatch(name string, spaces bool) *eventBatch {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &eventBatch{
+		name:    name,
+		spaces:  spaces,
+		pending: make(map[string]struct{}),
+		moves:   make(map[string]string),
+		timer:   timer,
+	}
+}
+
+func (b *eventBatch) size() int {
+	return len(b.pending) + len(b.moves)
+}
+
+// add records an event for relPath, pairing a rename with the create
+// that follows it within the debounce window; the pipeline verifies the
+// pairing by inode.
+func (b *eventBatch) add(relPath string, op fsnotify.Op) {
+	_, window := activeConfig.Load().rootQueue(b.spaces)
+	switch {
+	case op.Has(fsnotify.Rename):
+		b.renamed = &pendingRename{path: relPath, at: nowFunc()}
+		b.pending[relPath] = struct{}{}
+	case op.Has(fsnotify.Create) && b.renamed.pairs(relPath, window):
+		from := b.renamed.path
+		if orig, ok := b.moves[from]; ok {
+			from = orig // chained rename: a → b → c is one move
+			delete(b.moves, b.renamed.path)
+		}
+		delete(b.pending, b.renamed.path)
+		delete(b.pending, relPath)
+		if from != relPath {
+			b.moves[relPath] = from
+		} else {
+			b.pending[relPath] = struct{}{} // renamed back
+		}
+		b.renamed = nil
+		sub("watcher").Debug("rename paired", "from", from, "to", relPath, "root", b.name)
+	default:
+		b.pending[relPath] = struct{}{}
+	}
+}
+
+// flush pushes the batch to the queue, promoting it if the root's
+// settings say so.
+func (b *eventBatch) flush(queue *EvalQueue) {
+	if b.size() == 0 {
+		return
+	}
+	l := sub("watcher")
+	paths := make([]string, 0, len(b.pending))
+	for p := range b.pending {
+		if _, ok := b.moves[p]; !ok {
+			paths = append(paths, p)
 		}
 	}
+	queue.PushMany(paths)
+	for to, from := range b.moves {
+		queue.PushMove(from, to)
+	}
+	rq, _ := activeConfig.Load().rootQueue(b.spaces)
+	if rq.Promote {
+		promoted := paths
+		for to := range b.moves {
+			promoted = append(promoted, to)
+		}
+		queue.Promote(promoted...)
+	}
+	l.Info("flushed", "root", b.name, "count", len(paths), "moves", len(b.moves), "promoted", rq.Promote)
+	if logEnabled(slog.LevelDebug) {
+		l.Debug("flush paths", "root", b.name, "paths", paths, "moves", b.moves)
+	}
+	b.pending = make(map[string]struct{})
+	b.moves = make(map[string]string)
+}
+
+// pendingRename is the last unpaired rename seen in a root.
+type pendingRename struct {
+	path string
+	at   time.Time
+}
+
+// pairs reports whether a create of relPath completes the rename within
+// the debounce window.
+func (r *pendingRename) pairs(relPath string, window time.Duration) bool {
+	return r != nil && r.path != relPath && sinceNow(r.at) < window
+}
+
+// rootOf returns the root absPath is under, Archives first.
+func (w *Watcher) rootOf(absPath string) string {
+	if rel, err := filepath.Rel(w.archivesRoot, absPath); err == nil && !strings.HasPrefix(rel, "..") {
+		return w.archivesRoot
+	}
+	return w.spacesRoot
 }
 
 // toRelPath converts an absolute path to the relative path used by the pipeline.
@@ -147,11 +415,10 @@ func (w *Watcher) addRecursive(root string) error {
 			return nil // skip inaccessible dirs
 		}
 		if d.IsDir() {
-			base := filepath.Base(path)
-			if strings.HasPrefix(base, ".") && path != root {
+			if skipWatchDir(filepath.Base(path)) && path != root {
 				return filepath.SkipDir
 			}
-			if err := w.watcher.Add(path); err != nil {
+			if err := w.backend.Add(path); err != nil {
 				return err
 			}
 			l.Debug("added dir", "path", path)
@@ -161,7 +428,72 @@ func (w *Watcher) addRecursive(root string) error {
 	})
 }
 
-// Close closes the underlying fsnotify watcher.
+// Close closes the underlying watch backend.
 func (w *Watcher) Close() error {
-	return w.watcher.Close()
+	return w.backend.Close()
+}
+
+// trackArchived records a directory added to the watch under Archives in
+// scoped mode; the next refresh drops it again if it's out of scope.
+func (w *Watcher) trackArchived(dir string) {
+	if w.scope == nil || !strings.HasPrefix(dir, w.archivesRoot) {
+		return
+	}
+	w.mu.Lock()
+	w.archived[dir] = true
+	w.mu.Unlock()
+}
+
+// refreshScope brings the Archives watches in line with the scope.
+func (w *Watcher) refreshScope() {
+	l := sub("watcher")
+	sc := w.scope()
+	desired := map[string]bool{w.archivesRoot: true}
+	for _, rel := range sc.Dirs {
+		dir := filepath.Join(w.archivesRoot, rel)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			desired[dir] = true
+		}
+	}
+	for _, rel := range sc.Subtrees {
+		root := filepath.Join(w.archivesRoot, rel)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error { //nolint:errcheck
+			if err != nil {
+				return nil // skip inaccessible dirs
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if skipWatchDir(d.Name()) && path != root {
+				return filepath.SkipDir
+			}
+			desired[path] = true
+			return nil
+		})
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var added, removed int
+	for dir := range w.archived {
+		if !desired[dir] {
+			w.backend.Remove(dir) //nolint:errcheck // gone from disk
+			delete(w.archived, dir)
+			removed++
+		}
+	}
+	for dir := range desired {
+		if w.archived[dir] {
+			continue
+		}
+		if err := w.backend.Add(dir); err != nil {
+			l.Warn("watch failed", "path", dir, "err", err)
+			continue
+		}
+		w.archived[dir] = true
+		added++
+	}
+	if added+removed > 0 {
+		l.Info("watch scope refreshed", "archivesDirs", len(w.archived), "added", added, "removed", removed)
+	}
 }
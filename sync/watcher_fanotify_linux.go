@@ -0,0 +1,279 @@
+package sync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMask is the set of events requested from fanotify, matching
+// what fsnotify reports for inotify.
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO |
+	unix.FAN_MODIFY | unix.FAN_ATTRIB | unix.FAN_ONDIR
+
+// fanotifyRoot is a watched root with its symlink-free path, which is
+// what resolved event paths start with.
+type fanotifyRoot struct {
+	path, real string
+}
+
+// fanotifyBackend watches whole filesystems through fanotify
+// FAN_MARK_FILESYSTEM marks with FAN_REPORT_DFID_NAME. Events carry the
+// parent directory's file handle and the entry name; the handle is
+// resolved back to a path with open_by_handle_at. Events outside the
+// roots are dropped.
+type fanotifyBackend struct {
+	f      *os.File
+	mounts map[unix.Fsid]int // filesystem → directory fd for open_by_handle_at
+	roots  []fanotifyRoot
+
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce gosync.Once
+}
+
+// newFanotifyBackend marks the filesystems holding roots. It fails when
+// the kernel lacks FAN_REPORT_DFID_NAME (Linux < 5.9), the process lacks
+// CAP_SYS_ADMIN or CAP_DAC_READ_SEARCH, or a filesystem can't report
+// file handles.
+func newFanotifyBackend(roots ...string) (watchBackend, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_LARGEFILE)
+	if err != nil {
+		return nil, fmt.Errorf("fanotify_init: %w", err)
+	}
+	b := &fanotifyBackend{
+		mounts: make(map[unix.Fsid]int),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+	}
+	if err := b.mark(fd, roots); err != nil {
+		unix.Close(fd) //nolint:errcheck
+		b.closeMounts()
+		return nil, err
+	}
+	b.f = os.NewFile(uintptr(fd), "fanotify")
+	go b.readLoop()
+	return b, nil
+}
+
+// mark adds a filesystem mark per distinct filesystem among roots and
+// checks that file handles on it can be opened.
+func (b *fanotifyBackend) mark(fd int, roots []string) error {
+	for _, root := range roots {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		b.roots = append(b.roots, fanotifyRoot{path: root, real: real})
+
+		var st unix.Statfs_t
+		if err := unix.Statfs(real, &st); err != nil {
+			return fmt.Errorf("statfs %s: %w", root, err)
+		}
+		if _, ok := b.mounts[st.Fsid]; ok {
+			continue
+		}
+		mfd, err := unix.Open(real, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", root, err)
+		}
+		b.mounts[st.Fsid] = mfd
+		if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, real); err != nil {
+			return fmt.Errorf("fanotify_mark %s: %w", root, err)
+		}
+		h, _, err := unix.NameToHandleAt(unix.AT_FDCWD, real, 0)
+		if err != nil {
+			return fmt.Errorf("name_to_handle_at %s: %w", root, err)
+		}
+		probe, err := unix.OpenByHandleAt(mfd, h, unix.O_PATH|unix.O_CLOEXEC)
+		if err != nil {
+			return fmt.Errorf("open_by_handle_at %s: %w", root, err)
+		}
+		unix.Close(probe) //nolint:errcheck
+	}
+	return nil
+}
+
+func (b *fanotifyBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *fanotifyBackend) Errors() <-chan error          { return b.errors }
+func (b *fanotifyBackend) Add(string) error              { return nil }
+func (b *fanotifyBackend) Remove(string) error           { return nil }
+func (b *fanotifyBackend) WholeTree() bool               { return true }
+
+// Close stops the read loop and releases the fanotify and mount fds.
+func (b *fanotifyBackend) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.f.Close()
+	})
+	return err
+}
+
+func (b *fanotifyBackend) closeMounts() {
+	for _, mfd := range b.mounts {
+		unix.Close(mfd) //nolint:errcheck
+	}
+}
+
+// readLoop reads event batches until Close, then closes the channels.
+func (b *fanotifyBackend) readLoop() {
+	defer func() {
+		b.closeMounts()
+		close(b.events)
+		close(b.errors)
+	}()
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := b.f.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			if !b.sendError(fmt.Errorf("fanotify read: %w", err)) {
+				return
+			}
+			continue
+		}
+		if !b.parse(buf[:n]) {
+			return
+		}
+	}
+}
+
+// parse decodes a batch of events. Returns false once the backend is closed.
+func (b *fanotifyBackend) parse(buf []byte) bool {
+	const metaLen = 24 // sizeof(struct fanotify_event_metadata)
+	for len(buf) >= metaLen {
+		evLen := int(binary.NativeEndian.Uint32(buf[0:4]))
+		vers := buf[4]
+		infoOff := int(binary.NativeEndian.Uint16(buf[6:8]))
+		mask := binary.NativeEndian.Uint64(buf[8:16])
+		evFD := int32(binary.NativeEndian.Uint32(buf[16:20]))
+		if evLen < metaLen || evLen > len(buf) || infoOff < metaLen || infoOff > evLen {
+			return b.sendError(fmt.Errorf("fanotify: malformed event"))
+		}
+		if vers != unix.FANOTIFY_METADATA_VERSION {
+			return b.sendError(fmt.Errorf("fanotify: unsupported metadata version %d", vers))
+		}
+		if evFD >= 0 {
+			unix.Close(int(evFD)) //nolint:errcheck // not used in FID mode
+		}
+
+		if mask&unix.FAN_Q_OVERFLOW != 0 {
+			if !b.sendError(fsnotify.ErrEventOverflow) {
+				return false
+			}
+		} else if name, ok := b.resolve(buf[infoOff:evLen]); ok {
+			select {
+			case b.events <- fsnotify.Event{Name: name, Op: fanotifyOp(mask)}:
+			case <-b.done:
+				return false
+			}
+		}
+		buf = buf[evLen:]
+	}
+	return true
+}
+
+// resolve returns the path named by an event's DFID_NAME info record,
+// translated to the root as configured. False if the directory is gone
+// or the path is outside every root.
+func (b *fanotifyBackend) resolve(info []byte) (string, bool) {
+	for len(info) >= 4 {
+		recType := info[0]
+		recLen := int(binary.NativeEndian.Uint16(info[2:4]))
+		if recLen < 4 || recLen > len(info) {
+			return "", false
+		}
+		rec := info[:recLen]
+		info = info[recLen:]
+		if recType != unix.FAN_EVENT_INFO_TYPE_DFID_NAME || len(rec) < 20 {
+			continue
+		}
+
+		// header(4) fsid(8) handle_bytes(4) handle_type(4) f_handle name\0
+		var fsid unix.Fsid
+		fsid.Val[0] = int32(binary.NativeEndian.Uint32(rec[4:8]))
+		fsid.Val[1] = int32(binary.NativeEndian.Uint32(rec[8:12]))
+		size := int(binary.NativeEndian.Uint32(rec[12:16]))
+		handleType := int32(binary.NativeEndian.Uint32(rec[16:20]))
+		if 20+size > len(rec) {
+			return "", false
+		}
+		mfd, ok := b.mounts[fsid]
+		if !ok {
+			return "", false
+		}
+		name, _, _ := strings.Cut(string(rec[20+size:]), "\x00")
+
+		dfd, err := unix.OpenByHandleAt(mfd, unix.NewFileHandle(handleType, rec[20:20+size]), unix.O_PATH|unix.O_CLOEXEC)
+		if err != nil {
+			return "", false // directory deleted since the event
+		}
+		dir, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", dfd))
+		unix.Close(dfd) //nolint:errcheck
+		if err != nil || strings.HasSuffix(dir, " (deleted)") {
+			return "", false
+		}
+		if name != "" && name != "." {
+			dir = filepath.Join(dir, name)
+		}
+		return b.fromReal(dir)
+	}
+	return "", false
+}
+
+// fromReal maps a resolved path into the root it belongs to.
+func (b *fanotifyBackend) fromReal(p string) (string, bool) {
+	for _, r := range b.roots {
+		if p == r.real {
+			return r.path, true
+		}
+		if rel, ok := strings.CutPrefix(p, r.real+string(filepath.Separator)); ok {
+			return filepath.Join(r.path, rel), true
+		}
+	}
+	return "", false
+}
+
+func (b *fanotifyBackend) sendError(err error) bool {
+	select {
+	case b.errors <- err:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+// fanotifyOp maps a fanotify event mask to the fsnotify ops inotify
+// would report.
+func fanotifyOp(mask uint64) fsnotify.Op {
+	var op fsnotify.Op
+	if mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+		op |= fsnotify.Create
+	}
+	if mask&unix.FAN_DELETE != 0 {
+		op |= fsnotify.Remove
+	}
+	if mask&unix.FAN_MOVED_FROM != 0 {
+		op |= fsnotify.Rename
+	}
+	if mask&unix.FAN_MODIFY != 0 {
+		op |= fsnotify.Write
+	}
+	if mask&unix.FAN_ATTRIB != 0 {
+		op |= fsnotify.Chmod
+	}
+	return op
+}
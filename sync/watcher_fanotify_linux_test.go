@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestFanotifyOp(t *testing.T) {
+	assert.Equal(t, fsnotify.Create, fanotifyOp(unix.FAN_CREATE|unix.FAN_ONDIR))
+	assert.Equal(t, fsnotify.Create, fanotifyOp(unix.FAN_MOVED_TO))
+	assert.Equal(t, fsnotify.Rename, fanotifyOp(unix.FAN_MOVED_FROM))
+	assert.Equal(t, fsnotify.Remove, fanotifyOp(unix.FAN_DELETE))
+	assert.Equal(t, fsnotify.Write|fsnotify.Chmod, fanotifyOp(unix.FAN_MODIFY|unix.FAN_ATTRIB))
+}
+
+func TestWatcher_FanotifyBackend(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.WatchBackend = BackendFanotify
+	cfg.DebounceMs = 10
+	require.NoError(t, setConfig(cfg))
+
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, "deep", "er"), 0755))
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+
+	queue := NewEvalQueue()
+	w, err := NewWatcher(archivesRoot, spacesRoot, queue)
+	require.NoError(t, err, "falls back to fsnotify when fanotify is unavailable")
+	defer w.Close()
+	if !w.WholeTree() {
+		t.Skip("fanotify unavailable (needs Linux >= 5.9 and CAP_SYS_ADMIN)")
+	}
+	assert.True(t, w.Watched(filepath.Join(archivesRoot, "deep")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "deep", "er", "f.txt"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "outside.txt"), []byte("x"), 0644))
+
+	var got []string
+	require.Eventually(t, func() bool {
+		got = append(got, queue.Drain()...)
+		return len(got) > 0
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"deep/er/f.txt"}, got, "no watch per directory needed; events outside the roots dropped")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package sync
+
+import "errors"
+
+// newFanotifyBackend is Linux-only; other platforms fall back to fsnotify.
+func newFanotifyBackend(...string) (watchBackend, error) {
+	return nil, errors.New("fanotify is only supported on Linux")
+}
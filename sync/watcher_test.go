@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_PerRootBatching(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.ArchivesQueue = RootQueue{DebounceMs: 60_000, FlushBatch: 2}
+	cfg.SpacesQueue = RootQueue{DebounceMs: 10, Promote: true}
+	require.NoError(t, setConfig(cfg))
+
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	spacesRoot := filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(archivesRoot, 0755))
+	require.NoError(t, os.MkdirAll(spacesRoot, 0755))
+
+	queue := NewEvalQueue()
+	w, err := NewWatcher(archivesRoot, spacesRoot, queue)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx) //nolint:errcheck
+	time.Sleep(50 * time.Millisecond)
+
+	// A full Archives batch flushes without waiting for its long debounce.
+	require.NoError(t, os.Mkdir(filepath.Join(archivesRoot, "a1"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(archivesRoot, "a2"), 0755))
+	require.Eventually(t, func() bool { return queue.Len() == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// Spaces flushes on its own short debounce and jumps the queue.
+	require.NoError(t, os.Mkdir(filepath.Join(spacesRoot, "s1"), 0755))
+	require.Eventually(t, func() bool { return queue.Len() == 3 }, 2*time.Second, 10*time.Millisecond)
+	path, ok := queue.Pop(ctx.Done())
+	require.True(t, ok)
+	assert.Equal(t, "s1", path)
+}
+
+func TestConfig_RootQueue(t *testing.T) {
+	cfg := DefaultConfig()
+	rq, debounce := cfg.rootQueue(true)
+	assert.Equal(t, RootQueue{}, rq)
+	assert.Equal(t, cfg.Debounce(), debounce, "0 inherits debounceMs")
+
+	cfg.SpacesQueue.DebounceMs = 50
+	_, debounce = cfg.rootQueue(true)
+	assert.Equal(t, 50*time.Millisecond, debounce)
+
+	cfg.ArchivesQueue.DebounceMs = 5
+	assert.Error(t, cfg.Validate())
+	cfg.ArchivesQueue = RootQueue{FlushBatch: -1}
+	assert.Error(t, cfg.Validate())
+}
@@ -0,0 +1,212 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// A watcher can break without a word: the kernel drops inotify events once
+// its queue overflows, and watches go stale on a root swapped underneath
+// them. Changes then go unnoticed until the next restart. So every
+// Config.WatchProbeSeconds the daemon creates and removes a probe file at
+// the top of each watched root and waits the root's debounce window for
+// the watcher to report it. A missed probe marks the watcher unhealthy in
+// the stats and rescans that root, queueing whatever changed unseen; the
+// next probe that comes through clears the warning. The probe file is
+// hidden, so the scanner skips it, and the watcher never queues it.
+
+// watchProbeName is the probe file made at the top of each watched root.
+const watchProbeName = ".sync-watch-probe"
+
+// WatcherHealth is the outcome of the watcher self-test.
+type WatcherHealth struct {
+	Healthy bool         `json:"healthy"`           // no root missed its latest probe
+	Warning string       `json:"warning,omitempty"` // the roots that did
+	Roots   []WatchProbe `json:"roots,omitempty"`
+}
+
+// WatchProbe counts the probes of one watched root since start.
+type WatchProbe struct {
+	Root     string    `json:"root"`     // archives or spaces
+	Probes   int64     `json:"probes"`   // probes made
+	Missed   int64     `json:"missed"`   // probes the watcher didn't report in time
+	LastAt   time.Time `json:"lastAt"`   // when the latest probe was made
+	LastOK   bool      `json:"lastOK"`   // the latest probe was reported
+	Requeued int64     `json:"requeued"` // paths queued by the rescans after missed probes
+}
+
+// probeMonitor holds the probe outcomes per root.
+type probeMonitor struct {
+	mu    gosync.Mutex
+	roots map[string]*WatchProbe
+}
+
+// watchProbes records the probes made by probeWatcher.
+var watchProbes = &probeMonitor{}
+
+// record counts a probe of root, reported by the watcher or not (ok).
+func (m *probeMonitor) record(root string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.roots == nil {
+		m.roots = make(map[string]*WatchProbe)
+	}
+	p := m.roots[root]
+	if p == nil {
+		p = &WatchProbe{Root: root}
+		m.roots[root] = p
+	}
+	p.Probes++
+	if !ok {
+		p.Missed++
+	}
+	p.LastAt, p.LastOK = nowFunc(), ok
+}
+
+// requeued counts n paths queued by rescanning root.
+func (m *probeMonitor) requeued(root string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.roots[root]; p != nil {
+		p.Requeued += int64(n)
+	}
+}
+
+func (m *probeMonitor) health() WatcherHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := WatcherHealth{Healthy: true}
+	var missed []string
+	for _, p := range m.roots {
+		out.Roots = append(out.Roots, *p)
+		if !p.LastOK {
+			missed = append(missed, p.Root)
+		}
+	}
+	sort.Slice(out.Roots, func(i, j int) bool { return out.Roots[i].Root < out.Roots[j].Root })
+	if len(missed) > 0 {
+		sort.Strings(missed)
+		out.Healthy = false
+		out.Warning = "watcher missed its latest probe of " + strings.Join(missed, " and ") + ", changes may go unnoticed"
+	}
+	return out
+}
+
+// probeRoots returns the roots w watches.
+func (w *Watcher) probeRoots() []string {
+	if w.remoteSpaces {
+		return []string{w.archivesRoot}
+	}
+	return []string{w.archivesRoot, w.spacesRoot}
+}
+
+// probe creates and removes the probe file at the top of root and reports
+// whether the watcher saw either within wait.
+func (w *Watcher) probe(root string, wait time.Duration) (bool, error) {
+	seen := make(chan struct{})
+	w.probeMu.Lock()
+	if w.probes == nil {
+		w.probes = make(map[string]chan struct{})
+	}
+	w.probes[root] = seen
+	w.probeMu.Unlock()
+	defer func() {
+		w.probeMu.Lock()
+		delete(w.probes, root)
+		w.probeMu.Unlock()
+	}()
+
+	p := filepath.Join(root, watchProbeName)
+	if err := os.WriteFile(p, nil, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Remove(p); err != nil {
+		return false, err
+	}
+	select {
+	case <-seen:
+		return true, nil
+	case <-clock.After(wait):
+		return false, nil
+	}
+}
+
+// probeSeen notes an event of the probe file in root.
+func (w *Watcher) probeSeen(root string) {
+	w.probeMu.Lock()
+	defer w.probeMu.Unlock()
+	if seen, ok := w.probes[root]; ok {
+		close(seen)
+		delete(w.probes, root)
+	}
+}
+
+// runWatchProbe probes the watcher every Config.WatchProbeSeconds.
+func (d *Daemon) runWatchProbe(ctx context.Context) {
+	for {
+		interval := time.Duration(currentConfig().WatchProbeSeconds) * time.Second
+		if interval <= 0 {
+			interval = time.Minute // disabled; check again later
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(interval)):
+		}
+		if currentConfig().WatchProbeSeconds <= 0 {
+			continue
+		}
+		d.probeWatcher(ctx)
+	}
+}
+
+// probeWatcher probes each root the current watcher watches, rescanning
+// those whose probe went unreported.
+func (d *Daemon) probeWatcher(ctx context.Context) {
+	l := sub("watcher")
+	w := d.watcher.Load()
+	if w == nil || !w.live.Load() {
+		return // not watching, e.g. while a root is remounted
+	}
+	for _, root := range w.probeRoots() {
+		spaces := root == d.spacesRoot
+		_, debounce := activeConfig.Load().rootQueue(spaces)
+		ok, err := w.probe(root, debounce)
+		if err != nil {
+			l.Warn("watch probe failed", "root", root, "err", err)
+			continue
+		}
+		if !w.live.Load() {
+			return // torn down meanwhile; the next watcher re-seeds
+		}
+		name := "archives"
+		if spaces {
+			name = "spaces"
+		}
+		watchProbes.record(name, ok)
+		if ok {
+			continue
+		}
+		l.Warn("watcher missed its probe, rescanning the root", "root", root, "waitMs", debounce.Milliseconds())
+		watchProbes.requeued(name, d.rescanRoot(ctx, spaces))
+	}
+}
+
+// rescanRoot queues what changed unseen in one root: Archives is compared
+// with the DB as if it were unwatched, Spaces with its synced views as if
+// it were remote. Returns the number queued.
+func (d *Daemon) rescanRoot(ctx context.Context, spaces bool) int {
+	if !spaces {
+		return d.scanUnwatched(ctx, 0, "", func(string) bool { return false })
+	}
+	queued, err := d.scanRemote(ctx)
+	if err != nil {
+		sub("watcher").Warn("spaces rescan failed", "err", err)
+	}
+	return queued
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetWatchProbes gives the test fresh probe counters.
+func resetWatchProbes(t *testing.T) {
+	prev := watchProbes
+	watchProbes = &probeMonitor{}
+	t.Cleanup(func() { watchProbes = prev })
+}
+
+func TestProbeWatcher_MissedProbeRescans(t *testing.T) {
+	resetWatchProbes(t)
+	h, _, _, spacesRoot := setupHandlersEnv(t)
+	d := h.daemon
+	w, err := d.newWatcher()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.Start(ctx) //nolint:errcheck
+	}()
+	defer func() { cancel(); <-stopped }()
+	require.Eventually(t, w.live.Load, 2*time.Second, 10*time.Millisecond)
+
+	d.probeWatcher(ctx)
+	health := watchProbes.health()
+	assert.True(t, health.Healthy)
+	require.Len(t, health.Roots, 2)
+	for _, p := range health.Roots {
+		assert.True(t, p.LastOK, p.Root)
+		assert.Zero(t, p.Missed, p.Root)
+	}
+	assert.Zero(t, d.queue.Len(), "probes are not synced")
+	assert.NoFileExists(t, filepath.Join(spacesRoot, watchProbeName))
+
+	// Events of Spaces stop arriving: the probe goes unreported and the
+	// rescan finds what changed meanwhile.
+	require.NoError(t, w.backend.Remove(spacesRoot))
+	require.NoError(t, os.WriteFile(filepath.Join(spacesRoot, "new.txt"), []byte("x"), 0644))
+	d.probeWatcher(ctx)
+	health = watchProbes.health()
+	assert.False(t, health.Healthy)
+	assert.Contains(t, health.Warning, "spaces")
+	assert.NotContains(t, health.Warning, "archives")
+	require.Len(t, health.Roots, 2)
+	assert.Equal(t, WatchProbe{Root: "spaces", Probes: 2, Missed: 1, LastAt: health.Roots[1].LastAt, Requeued: 1}, health.Roots[1])
+	assert.True(t, d.queue.Has("new.txt"))
+
+	// A probe that comes through again clears the warning.
+	require.NoError(t, w.backend.Add(spacesRoot))
+	d.probeWatcher(ctx)
+	assert.True(t, watchProbes.health().Healthy)
+}
+
+func TestProbeWatcher_SkipsStoppedWatcher(t *testing.T) {
+	resetWatchProbes(t)
+	h, _, archivesRoot, _ := setupHandlersEnv(t)
+	_, err := h.daemon.newWatcher()
+	require.NoError(t, err)
+
+	h.daemon.probeWatcher(context.Background())
+	assert.Equal(t, WatcherHealth{Healthy: true}, watchProbes.health())
+	assert.NoFileExists(t, filepath.Join(archivesRoot, watchProbeName))
+}
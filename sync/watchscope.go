@@ -0,0 +1,184 @@
+package sync
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// activeDirs records when Archives directories were last browsed, for
+// the scoped watcher.
+type activeDirs struct {
+	mu   gosync.Mutex
+	seen map[string]time.Time
+}
+
+// touch records activity on relDir and reports whether it was not
+// already active within ttl.
+func (a *activeDirs) touch(relDir string, ttl time.Duration) bool {
+	now := nowFunc()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+	last, ok := a.seen[relDir]
+	a.seen[relDir] = now
+	return !ok || now.Sub(last) >= ttl
+}
+
+// list returns the directories active within ttl, dropping older ones.
+func (a *activeDirs) list(ttl time.Duration) []string {
+	now := nowFunc()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var dirs []string
+	for dir, last := range a.seen {
+		if now.Sub(last) >= ttl {
+			delete(a.seen, dir)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// touchActive records that directory dirIno was browsed. In scoped watch
+// mode a newly active directory is watched right away.
+func (d *Daemon) touchActive(dirIno uint64) {
+	cfg := currentConfig()
+	if !cfg.WatchScoped || dirIno == 0 {
+		return // the root is always watched
+	}
+	entry, err := d.store.GetEntry(dirIno)
+	if err != nil || entry == nil {
+		return
+	}
+	if d.active.touch(d.store.RelPath(entry), time.Duration(cfg.WatchActiveMinutes)*time.Minute) {
+		d.pokeWatchScope()
+	}
+}
+
+// pokeWatchScope asks a scoped watcher to re-evaluate its scope, e.g.
+// after a selection change. It never blocks.
+func (d *Daemon) pokeWatchScope() {
+	select {
+	case d.scopeChanged <- struct{}{}:
+	default:
+	}
+}
+
+// watchScope returns the Archives directories a scoped watcher covers:
+// selected subtrees, the directories holding selected files, pinned
+// subtrees and recently browsed directories.
+func (d *Daemon) watchScope() WatchScope {
+	l := sub("watcher")
+	cfg := currentConfig()
+	var sc WatchScope
+	roots, err := d.store.ListSelectionRoots()
+	if err != nil {
+		l.Error("watch scope: list selection failed", "err", err)
+	}
+	for i := range roots {
+		relPath := d.store.RelPath(&roots[i])
+		if roots[i].Type == "dir" {
+			sc.Subtrees = append(sc.Subtrees, relPath)
+		} else if dir := path.Dir(relPath); dir != "." {
+			sc.Dirs = append(sc.Dirs, dir)
+		}
+	}
+	for _, pin := range cfg.WatchPins {
+		sc.Subtrees = append(sc.Subtrees, cleanRelPath(pin))
+	}
+	sc.Dirs = append(sc.Dirs, d.active.list(time.Duration(cfg.WatchActiveMinutes)*time.Minute)...)
+	sc.Subtrees = outermost(sc.Subtrees)
+	return sc
+}
+
+// outermost drops paths nested inside another path of the list.
+func outermost(paths []string) []string {
+	sort.Strings(paths)
+	var out []string
+	for _, p := range paths {
+		if n := len(out); n > 0 && (out[n-1] == "" || p == out[n-1] || strings.HasPrefix(p, out[n-1]+"/")) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// runWatchScan covers what a scoped watcher doesn't see: every
+// Config.WatchScanSeconds it compares unwatched Archives directories with
+// the DB and queues the differences.
+func (d *Daemon) runWatchScan(ctx context.Context, watched func(dir string) bool) {
+	l := sub("watcher")
+	for {
+		interval := time.Duration(currentConfig().WatchScanSeconds) * time.Second
+		if interval <= 0 {
+			interval = time.Minute // disabled; check again later
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(power.stretch(interval)):
+		}
+		if currentConfig().WatchScanSeconds <= 0 {
+			continue
+		}
+		start := time.Now()
+		queued := d.scanUnwatched(ctx, 0, "", watched)
+		l.Info("unwatched scan complete", "queued", queued, "durationMs", time.Since(start).Milliseconds())
+	}
+}
+
+// scanUnwatched compares the directory dirIno at relDir and its
+// registered subdirectories with the DB, skipping the comparison for
+// watched directories. Files whose inode or mtime differ, new entries
+// and entries gone from disk are queued. Returns the number queued.
+func (d *Daemon) scanUnwatched(ctx context.Context, dirIno uint64, relDir string, watched func(dir string) bool) int {
+	if ctx.Err() != nil {
+		return 0
+	}
+	if d.lazy != nil && !d.lazy.isListed(dirIno) {
+		return 0 // children not registered yet; nothing to compare
+	}
+	children, err := d.store.ListChildren(dirIno)
+	if err != nil {
+		sub("watcher").Warn("unwatched scan: list failed", "path", relDir, "err", err)
+		return 0
+	}
+	disk, err := listDir(d.archivesRoot, relDir)
+	if err != nil {
+		return 0 // the pipeline handles the vanished directory itself
+	}
+
+	known := make(map[string]*Entry, len(children))
+	for i := range children {
+		known[children[i].Name] = &children[i]
+	}
+	check := !watched(filepath.Join(d.archivesRoot, relDir))
+	var queued int
+	for relPath, stat := range disk {
+		e := known[stat.Name]
+		delete(known, stat.Name)
+		if check && (e == nil || e.Inode != stat.Inode || (!stat.IsDir && e.Mtime != stat.Mtime)) {
+			d.queue.PushSized(relPath, stat.Size, stat.IsDir)
+			queued++
+		}
+		if stat.IsDir && e != nil && e.Inode == stat.Inode {
+			queued += d.scanUnwatched(ctx, e.Inode, relPath, watched)
+		}
+	}
+	if check {
+		for name, e := range known {
+			d.queue.PushSized(path.Join(relDir, name), sizeOrZero(e.Size), e.Type == "dir")
+			queued++
+		}
+	}
+	return queued
+}
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemon_WatchScope(t *testing.T) {
+	restoreConfig(t)
+	cfg := currentConfig()
+	cfg.WatchScoped = true
+	cfg.WatchPins = []string{"/Pinned/", "Projects/tmp"}
+	require.NoError(t, setConfig(cfg))
+
+	h, store, _, _ := setupHandlersEnv(t)
+	seedSelectionTree(t, store)
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 10, Name: "Docs", Type: "dir", Mtime: 1}))
+	require.NoError(t, store.UpsertEntry(Entry{Inode: 11, ParentIno: 10, Name: "c.txt", Type: "text", Mtime: 1}))
+	require.NoError(t, store.SetSelected([]uint64{1, 11}, true))
+
+	h.daemon.touchActive(3)
+	select {
+	case <-h.daemon.scopeChanged:
+	default:
+		t.Fatal("newly active directory should poke the watcher")
+	}
+
+	sc := h.daemon.watchScope()
+	assert.Equal(t, []string{"Pinned", "Projects"}, sc.Subtrees, "nested pin folds into the selected subtree")
+	assert.ElementsMatch(t, []string{"Docs", "Projects/tmp"}, sc.Dirs)
+
+	useFakeClock(t, time.Now().Add(2*time.Hour))
+	assert.Equal(t, []string{"Docs"}, h.daemon.watchScope().Dirs, "activity expires")
+}
+
+func TestWatcher_RefreshScope(t *testing.T) {
+	dir := t.TempDir()
+	archivesRoot := filepath.Join(dir, "Archives")
+	for _, p := range []string{"sel/sub", "other/deep", "sel/.hidden"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(archivesRoot, p), 0755))
+	}
+	w, err := NewWatcher(archivesRoot, filepath.Join(dir, "Spaces"), NewEvalQueue())
+	require.NoError(t, err)
+	defer w.Close()
+
+	scope := WatchScope{Subtrees: []string{"sel"}}
+	w.SetScope(func() WatchScope { return scope }, nil)
+	w.refreshScope()
+	assert.True(t, w.Watched(archivesRoot))
+	assert.True(t, w.Watched(filepath.Join(archivesRoot, "sel", "sub")))
+	assert.False(t, w.Watched(filepath.Join(archivesRoot, "sel", ".hidden")))
+	assert.False(t, w.Watched(filepath.Join(archivesRoot, "other")))
+
+	scope = WatchScope{Dirs: []string{"other"}}
+	w.refreshScope()
+	assert.False(t, w.Watched(filepath.Join(archivesRoot, "sel")))
+	assert.True(t, w.Watched(filepath.Join(archivesRoot, "other")))
+	assert.False(t, w.Watched(filepath.Join(archivesRoot, "other", "deep")))
+}
+
+func TestDaemon_ScanUnwatched(t *testing.T) {
+	h, store, archivesRoot, spacesRoot := setupHandlersEnv(t)
+	registerPaths(t, store, archivesRoot, spacesRoot, []string{"Cold/", "Cold/a.txt", "Cold/gone.txt", "Hot/", "Hot/b.txt"}, nil)
+	h.daemon.queue.Drain()
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(archivesRoot, "Cold", "a.txt"), later, later))
+	require.NoError(t, os.Remove(filepath.Join(archivesRoot, "Cold", "gone.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(archivesRoot, "Cold", "new.txt"), []byte("n"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(archivesRoot, "Hot", "b.txt"), later, later))
+
+	hot := filepath.Join(archivesRoot, "Hot")
+	watched := func(dir string) bool { return dir == archivesRoot || dir == hot }
+	n := h.daemon.scanUnwatched(context.Background(), 0, "", watched)
+	assert.Equal(t, 3, n)
+	assert.ElementsMatch(t, []string{"Cold/a.txt", "Cold/gone.txt", "Cold/new.txt"}, h.daemon.queue.Drain())
+}
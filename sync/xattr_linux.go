@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// aclXattrs are the extended attributes holding a file's POSIX ACLs.
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// copyXattrs copies src's ACLs and security.* attributes (SELinux
+// context, capabilities) to dst, as x asks. It stops at the first
+// attribute dst's file system won't take.
+func copyXattrs(src, dst string, x RootXattrs) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		isACL := name == aclXattrs[0] || name == aclXattrs[1]
+		if !(x.ACLs && isACL) && !(x.Security && strings.HasPrefix(name, "security.")) {
+			continue
+		}
+		value, err := getXattr(src, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since listing
+		}
+		if err != nil {
+			return err
+		}
+		if err := unix.Lsetxattr(dst, name, value, 0); err != nil {
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of path's extended attributes.
+func listXattrs(path string) ([]string, error) {
+	for {
+		n, err := unix.Llistxattr(path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list xattrs: %w", err)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n)
+		n, err = unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue // grew since sizing
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list xattrs: %w", err)
+		}
+		return strings.Split(strings.TrimSuffix(string(buf[:n]), "\x00"), "\x00"), nil
+	}
+}
+
+// getXattr returns the value of path's attribute name.
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %w", name, err)
+		}
+		buf := make([]byte, n)
+		n, err = unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %w", name, err)
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// testACL is a POSIX ACL granting uid 1000 read, in the kernel's xattr
+// encoding.
+func testACL() []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 2) // version
+	for _, e := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{{0x01, 6, 0xffffffff}, {0x02, 4, 1000}, {0x04, 4, 0xffffffff}, {0x10, 4, 0xffffffff}, {0x20, 4, 0xffffffff}} {
+		b = binary.LittleEndian.AppendUint16(b, e.tag)
+		b = binary.LittleEndian.AppendUint16(b, e.perm)
+		b = binary.LittleEndian.AppendUint32(b, e.id)
+	}
+	return b
+}
+
+func TestSafeCopy_Xattrs(t *testing.T) {
+	restoreConfig(t)
+	dir := t.TempDir()
+	archives, spaces := filepath.Join(dir, "Archives"), filepath.Join(dir, "Spaces")
+	require.NoError(t, os.MkdirAll(archives, 0755))
+	src := filepath.Join(archives, "a.txt")
+	require.NoError(t, os.WriteFile(src, []byte("content"), 0644))
+	if err := unix.Lsetxattr(src, "system.posix_acl_access", testACL(), 0); err != nil {
+		t.Skipf("no ACLs here: %v", err)
+	}
+	if err := unix.Lsetxattr(src, "security.sync-test", []byte("label"), 0); err != nil {
+		t.Skipf("no security xattrs here: %v", err)
+	}
+
+	cfg := currentConfig()
+	cfg.ArchivesRoot, cfg.SpacesRoot = archives, spaces
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "plain.txt"), nil))
+	_, err := getXattr(filepath.Join(spaces, "plain.txt"), "security.sync-test")
+	assert.ErrorIs(t, err, unix.ENODATA, "off by default")
+
+	cfg.SpacesXattrs = RootXattrs{ACLs: true}
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "acl.txt"), nil))
+	acl, err := getXattr(filepath.Join(spaces, "acl.txt"), "system.posix_acl_access")
+	require.NoError(t, err)
+	assert.Equal(t, testACL(), acl)
+	_, err = getXattr(filepath.Join(spaces, "acl.txt"), "security.sync-test")
+	assert.ErrorIs(t, err, unix.ENODATA)
+
+	cfg.SpacesXattrs = RootXattrs{ACLs: true, Security: true}
+	require.NoError(t, setConfig(cfg))
+	require.NoError(t, SafeCopy(context.Background(), src, filepath.Join(spaces, "all.txt"), nil))
+	label, err := getXattr(filepath.Join(spaces, "all.txt"), "security.sync-test")
+	require.NoError(t, err)
+	assert.Equal(t, "label", string(label))
+
+	// The setting is per destination root.
+	require.NoError(t, SafeCopy(context.Background(), filepath.Join(spaces, "all.txt"), filepath.Join(archives, "back.txt"), nil))
+	_, err = getXattr(filepath.Join(archives, "back.txt"), "security.sync-test")
+	assert.ErrorIs(t, err, unix.ENODATA)
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package sync
+
+// copyXattrs does nothing: ACLs and security attributes are only copied
+// on Linux.
+func copyXattrs(src, dst string, x RootXattrs) error { return nil }